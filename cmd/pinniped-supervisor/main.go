@@ -29,22 +29,32 @@ import (
 	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
 	pinnipedclientset "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned"
 	pinnipedinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions"
+	"go.pinniped.dev/internal/auditlog"
+	"go.pinniped.dev/internal/boltstorage"
 	"go.pinniped.dev/internal/config/supervisor"
 	"go.pinniped.dev/internal/controller/supervisorconfig"
+	"go.pinniped.dev/internal/controller/supervisorconfig/activedirectoryupstreamwatcher"
 	"go.pinniped.dev/internal/controller/supervisorconfig/generator"
+	"go.pinniped.dev/internal/controller/supervisorconfig/githubupstreamwatcher"
 	"go.pinniped.dev/internal/controller/supervisorconfig/ldapupstreamwatcher"
+	"go.pinniped.dev/internal/controller/supervisorconfig/oidcclientwatcher"
 	"go.pinniped.dev/internal/controller/supervisorconfig/oidcupstreamwatcher"
+	"go.pinniped.dev/internal/controller/supervisorconfig/samlupstreamwatcher"
 	"go.pinniped.dev/internal/controller/supervisorstorage"
 	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/crud"
 	"go.pinniped.dev/internal/deploymentref"
 	"go.pinniped.dev/internal/downward"
 	"go.pinniped.dev/internal/groupsuffix"
 	"go.pinniped.dev/internal/kubeclient"
+	"go.pinniped.dev/internal/metrics"
+	"go.pinniped.dev/internal/oidc/clientregistry"
 	"go.pinniped.dev/internal/oidc/jwks"
 	"go.pinniped.dev/internal/oidc/provider"
 	"go.pinniped.dev/internal/oidc/provider/manager"
 	"go.pinniped.dev/internal/plog"
 	"go.pinniped.dev/internal/secret"
+	"go.pinniped.dev/internal/tracing"
 )
 
 const (
@@ -88,6 +98,7 @@ func startControllers(
 	dynamicTLSCertProvider provider.DynamicTLSCertProvider,
 	dynamicUpstreamIDPProvider provider.DynamicUpstreamIDPProvider,
 	secretCache *secret.Cache,
+	sessionDatabase *boltstorage.DB,
 	supervisorDeployment *appsv1.Deployment,
 	kubeClient kubernetes.Interface,
 	pinnipedClient pinnipedclientset.Interface,
@@ -97,6 +108,8 @@ func startControllers(
 	federationDomainInformer := pinnipedInformers.Config().V1alpha1().FederationDomains()
 	secretInformer := kubeInformers.Core().V1().Secrets()
 
+	symmetricKeysRotationInterval := time.Duration(*cfg.SymmetricKeysConfig.RotationIntervalSeconds) * time.Second
+
 	// Create controller manager.
 	controllerManager := controllerlib.
 		NewManager().
@@ -122,6 +135,9 @@ func startControllers(
 		WithController(
 			supervisorconfig.NewJWKSWriterController(
 				cfg.Labels,
+				time.Duration(*cfg.JWKSConfig.RotationIntervalSeconds)*time.Second,
+				time.Duration(*cfg.JWKSConfig.RetentionSeconds)*time.Second,
+				clock.RealClock{},
 				kubeClient,
 				pinnipedClient,
 				secretInformer,
@@ -171,9 +187,11 @@ func startControllers(
 					cfg.Labels,
 					rand.Reader,
 					generator.SecretUsageTokenSigningKey,
-					func(federationDomainIssuer string, symmetricKey []byte) {
-						plog.Debug("setting hmac secret", "issuer", federationDomainIssuer)
-						secretCache.SetTokenHMACKey(federationDomainIssuer, symmetricKey)
+					symmetricKeysRotationInterval,
+					clock.RealClock{},
+					func(federationDomainIssuer string, symmetricKeys [][]byte) {
+						plog.Debug("setting hmac secrets", "issuer", federationDomainIssuer, "count", len(symmetricKeys))
+						secretCache.SetTokenHMACKeys(federationDomainIssuer, symmetricKeys)
 					},
 				),
 				func(fd *configv1alpha1.FederationDomainStatus) *corev1.LocalObjectReference {
@@ -194,9 +212,11 @@ func startControllers(
 					cfg.Labels,
 					rand.Reader,
 					generator.SecretUsageStateSigningKey,
-					func(federationDomainIssuer string, symmetricKey []byte) {
-						plog.Debug("setting state signature key", "issuer", federationDomainIssuer)
-						secretCache.SetStateEncoderHashKey(federationDomainIssuer, symmetricKey)
+					symmetricKeysRotationInterval,
+					clock.RealClock{},
+					func(federationDomainIssuer string, symmetricKeys [][]byte) {
+						plog.Debug("setting state signature keys", "issuer", federationDomainIssuer, "count", len(symmetricKeys))
+						secretCache.SetStateEncoderHashKeys(federationDomainIssuer, symmetricKeys)
 					},
 				),
 				func(fd *configv1alpha1.FederationDomainStatus) *corev1.LocalObjectReference {
@@ -217,9 +237,11 @@ func startControllers(
 					cfg.Labels,
 					rand.Reader,
 					generator.SecretUsageStateEncryptionKey,
-					func(federationDomainIssuer string, symmetricKey []byte) {
-						plog.Debug("setting state encryption key", "issuer", federationDomainIssuer)
-						secretCache.SetStateEncoderBlockKey(federationDomainIssuer, symmetricKey)
+					symmetricKeysRotationInterval,
+					clock.RealClock{},
+					func(federationDomainIssuer string, symmetricKeys [][]byte) {
+						plog.Debug("setting state encryption keys", "issuer", federationDomainIssuer, "count", len(symmetricKeys))
+						secretCache.SetStateEncoderBlockKeys(federationDomainIssuer, symmetricKeys)
 					},
 				),
 				func(fd *configv1alpha1.FederationDomainStatus) *corev1.LocalObjectReference {
@@ -251,8 +273,57 @@ func startControllers(
 				secretInformer,
 				controllerlib.WithInformer,
 			),
+			singletonWorker).
+		WithController(
+			activedirectoryupstreamwatcher.New(
+				dynamicUpstreamIDPProvider,
+				pinnipedClient,
+				pinnipedInformers.IDP().V1alpha1().ActiveDirectoryIdentityProviders(),
+				secretInformer,
+				controllerlib.WithInformer,
+			),
+			singletonWorker).
+		WithController(
+			githubupstreamwatcher.New(
+				dynamicUpstreamIDPProvider,
+				pinnipedClient,
+				pinnipedInformers.IDP().V1alpha1().GitHubIdentityProviders(),
+				secretInformer,
+				klogr.New(),
+				controllerlib.WithInformer,
+			),
+			singletonWorker).
+		WithController(
+			samlupstreamwatcher.New(
+				dynamicUpstreamIDPProvider,
+				pinnipedClient,
+				pinnipedInformers.IDP().V1alpha1().SAMLIdentityProviders(),
+				klogr.New(),
+				controllerlib.WithInformer,
+			),
+			singletonWorker).
+		WithController(
+			oidcclientwatcher.New(
+				clock.RealClock{},
+				pinnipedClient,
+				pinnipedInformers.Config().V1alpha1().OIDCClients(),
+				secretInformer,
+				controllerlib.WithInformer,
+			),
 			singletonWorker)
 
+	// Sessions which are not stored as Secrets are garbage collected by their own controller.
+	if sessionDatabase != nil {
+		controllerManager = controllerManager.WithController(
+			supervisorstorage.DatabaseGarbageCollectorController(
+				clock.RealClock{},
+				sessionDatabase,
+				controllerlib.WithInitialEvent,
+			),
+			singletonWorker,
+		)
+	}
+
 	kubeInformers.Start(ctx.Done())
 	pinnipedInformers.Start(ctx.Done())
 
@@ -294,24 +365,66 @@ func run(podInfo *downward.PodInfo, cfg *supervisor.Config) error {
 		pinnipedinformers.WithNamespace(serverInstallationNamespace),
 	)
 
+	// Record the metrics of the Supervisor, which are served on the metrics port below.
+	metrics.Register()
+
+	// Write the audit events of the Supervisor to the configured output, if any.
+	closeAuditLog, err := auditlog.Open(cfg.AuditConfig.Output)
+	if err != nil {
+		return err
+	}
+	defer closeAuditLog()
+	if err := auditlog.SetTrustedProxies(cfg.AuditConfig.TrustedProxies); err != nil {
+		return err
+	}
+
+	// Export the spans of the login flows to the configured OpenTelemetry collector, if any.
+	stopTracing, err := tracing.Configure(cfg.TracingConfig.OTLPEndpoint, "pinniped-supervisor", cfg.TracingConfig.TrustedClients)
+	if err != nil {
+		return err
+	}
+	defer stopTracing()
+
 	// Serve the /healthz endpoint and make all other paths result in 404.
 	healthMux := http.NewServeMux()
 	healthMux.Handle("/healthz", http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 		_, _ = writer.Write([]byte("ok"))
 	}))
 
+	// Store the sessions of the FederationDomains in the configured backend.
+	var sessionStorageFactory crud.StorageFactory
+	var sessionDatabase *boltstorage.DB
+	switch cfg.StorageConfig.Type {
+	case supervisor.StorageTypeBolt:
+		sessionDatabase, err = boltstorage.Open(cfg.StorageConfig.Path, time.Now)
+		if err != nil {
+			return fmt.Errorf("cannot open session storage: %w", err)
+		}
+		defer func() { _ = sessionDatabase.Close() }()
+		sessionStorageFactory = sessionDatabase.StorageFactory()
+	default:
+		sessionStorageFactory = crud.SecretsStorageFactory(client.Kubernetes.CoreV1().Secrets(serverInstallationNamespace), time.Now)
+	}
+
 	dynamicJWKSProvider := jwks.NewDynamicJWKSProvider()
 	dynamicTLSCertProvider := provider.NewDynamicTLSCertProvider()
 	dynamicUpstreamIDPProvider := provider.NewDynamicUpstreamIDPProvider()
 	secretCache := secret.Cache{}
 
+	// OIDC clients are looked up from the informer caches, which are started by startControllers().
+	oidcClientManager := clientregistry.NewClientManager(
+		pinnipedInformers.Config().V1alpha1().OIDCClients().Lister().OIDCClients(serverInstallationNamespace),
+		kubeInformers.Core().V1().Secrets().Lister().Secrets(serverInstallationNamespace),
+	)
+
 	// OIDC endpoints will be served by the oidProvidersManager, and any non-OIDC paths will fallback to the healthMux.
 	oidProvidersManager := manager.NewManager(
 		healthMux,
 		dynamicJWKSProvider,
 		dynamicUpstreamIDPProvider,
 		&secretCache,
-		client.Kubernetes.CoreV1().Secrets(serverInstallationNamespace),
+		sessionStorageFactory,
+		oidcClientManager,
 	)
 
 	startControllers(
@@ -322,6 +435,7 @@ func run(podInfo *downward.PodInfo, cfg *supervisor.Config) error {
 		dynamicTLSCertProvider,
 		dynamicUpstreamIDPProvider,
 		&secretCache,
+		sessionDatabase,
 		supervisorDeployment,
 		client.Kubernetes,
 		client.PinnipedSupervisor,
@@ -360,9 +474,26 @@ func run(podInfo *downward.PodInfo, cfg *supervisor.Config) error {
 	defer func() { _ = httpsListener.Close() }()
 	start(ctx, httpsListener, oidProvidersManager)
 
+	// Serve the /metrics endpoint on its own port, which is not exposed by the Services of the Supervisor,
+	// and make all other paths result in 404.
+	metricsAddress := "disabled"
+	if !cfg.MetricsConfig.Disabled {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.Handler())
+
+		metricsListener, err := net.Listen("tcp", cfg.MetricsConfig.Address)
+		if err != nil {
+			return fmt.Errorf("cannot create listener: %w", err)
+		}
+		defer func() { _ = metricsListener.Close() }()
+		start(ctx, metricsListener, metricsMux)
+		metricsAddress = metricsListener.Addr().String()
+	}
+
 	plog.Debug("supervisor is ready",
 		"httpAddress", httpListener.Addr().String(),
 		"httpsAddress", httpsListener.Addr().String(),
+		"metricsAddress", metricsAddress,
 	)
 
 	gotSignal := waitForSignal()
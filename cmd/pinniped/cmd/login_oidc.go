@@ -74,6 +74,8 @@ type oidcLoginFlags struct {
 	credentialCachePath          string
 	upstreamIdentityProviderName string
 	upstreamIdentityProviderType string
+	upstreamIdentityProviderFlow string
+	useDeviceCode                bool
 }
 
 func oidcLoginCommand(deps oidcLoginCommandDeps) *cobra.Command {
@@ -107,7 +109,9 @@ func oidcLoginCommand(deps oidcLoginCommandDeps) *cobra.Command {
 	cmd.Flags().StringVar(&flags.conciergeAPIGroupSuffix, "concierge-api-group-suffix", groupsuffix.PinnipedDefaultSuffix, "Concierge API group suffix")
 	cmd.Flags().StringVar(&flags.credentialCachePath, "credential-cache", filepath.Join(mustGetConfigDir(), "credentials.yaml"), "Path to cluster-specific credentials cache (\"\" disables the cache)")
 	cmd.Flags().StringVar(&flags.upstreamIdentityProviderName, "upstream-identity-provider-name", "", "The name of the upstream identity provider used during login with a Supervisor")
-	cmd.Flags().StringVar(&flags.upstreamIdentityProviderType, "upstream-identity-provider-type", "oidc", "The type of the upstream identity provider used during login with a Supervisor (e.g. 'oidc', 'ldap')")
+	cmd.Flags().StringVar(&flags.upstreamIdentityProviderType, "upstream-identity-provider-type", "oidc", "The type of the upstream identity provider used during login with a Supervisor (e.g. 'oidc', 'ldap', 'activedirectory', 'github', 'saml')")
+	cmd.Flags().StringVar(&flags.upstreamIdentityProviderFlow, "upstream-identity-provider-flow", "", "The type of client flow to use with the upstream identity provider during login with a Supervisor (e.g. 'browser_authcode', 'cli_password')")
+	cmd.Flags().BoolVar(&flags.useDeviceCode, "use-device-code", false, "Log in by entering a code in a web browser on any device, instead of using a localhost callback")
 
 	// --skip-listen is mainly needed for testing. We'll leave it hidden until we have a non-testing use case.
 	mustMarkHidden(cmd, "skip-listen")
@@ -161,14 +165,37 @@ func runOIDCLogin(cmd *cobra.Command, deps oidcLoginCommandDeps, flags oidcLogin
 	}
 
 	switch flags.upstreamIdentityProviderType {
-	case "oidc":
-		// this is the default, so don't need to do anything
-	case "ldap":
-		opts = append(opts, oidcclient.WithCLISendingCredentials())
+	case "oidc", "github", "saml":
+		// oidc is the default, and github and saml also use the browser-based flow, so don't need to do anything
+		if flags.upstreamIdentityProviderFlow != "" && flags.upstreamIdentityProviderFlow != "browser_authcode" {
+			return fmt.Errorf(
+				"--upstream-identity-provider-flow value not recognized for identity provider type %q: %s (supported values: browser_authcode)",
+				flags.upstreamIdentityProviderType, flags.upstreamIdentityProviderFlow)
+		}
+	case "ldap", "activedirectory":
+		// Unless asked otherwise, prompt for the username and password on the CLI. A device code login always uses
+		// the login form of the Supervisor in a web browser instead.
+		switch flags.upstreamIdentityProviderFlow {
+		case "":
+			if !flags.useDeviceCode {
+				opts = append(opts, oidcclient.WithCLISendingCredentials())
+			}
+		case "cli_password":
+			if flags.useDeviceCode {
+				return fmt.Errorf("--use-device-code is not supported for --upstream-identity-provider-flow cli_password")
+			}
+			opts = append(opts, oidcclient.WithCLISendingCredentials())
+		case "browser_authcode":
+			// The Supervisor shows its login form in the web browser, so don't need to do anything.
+		default:
+			return fmt.Errorf(
+				"--upstream-identity-provider-flow value not recognized for identity provider type %q: %s (supported values: cli_password, browser_authcode)",
+				flags.upstreamIdentityProviderType, flags.upstreamIdentityProviderFlow)
+		}
 	default:
 		// Surprisingly cobra does not support this kind of flag validation. See https://github.com/spf13/pflag/issues/236
 		return fmt.Errorf(
-			"--upstream-identity-provider-type value not recognized: %s (supported values: oidc, ldap)",
+			"--upstream-identity-provider-type value not recognized: %s (supported values: oidc, ldap, activedirectory, github, saml)",
 			flags.upstreamIdentityProviderType)
 	}
 
@@ -196,6 +223,11 @@ func runOIDCLogin(cmd *cobra.Command, deps oidcLoginCommandDeps, flags oidcLogin
 		opts = append(opts, oidcclient.WithSkipListen())
 	}
 
+	// --use-device-code uses the device authorization grant instead of the authorization code flow.
+	if flags.useDeviceCode {
+		opts = append(opts, oidcclient.WithDeviceAuthorizationGrant())
+	}
+
 	if len(flags.caBundlePaths) > 0 || len(flags.caBundleData) > 0 {
 		client, err := makeClient(flags.caBundlePaths, flags.caBundleData)
 		if err != nil {
@@ -0,0 +1,378 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	clientauthv1beta1 "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/klog/v2/klogr"
+
+	"go.pinniped.dev/internal/execcredcache"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/pkg/oidcclient"
+	"go.pinniped.dev/pkg/oidcclient/filesession"
+)
+
+//nolint: gochecknoinits
+func init() {
+	rootCmd.AddCommand(logoutCommand(logoutCommandRealDeps()))
+}
+
+type logoutCommandDeps struct {
+	lookupEnv     func(string) (string, bool)
+	logout        func(string, string, ...oidcclient.Option) error
+	logoutSession func(oidcclient.SessionCacheKey, ...oidcclient.Option) error
+}
+
+func logoutCommandRealDeps() logoutCommandDeps {
+	return logoutCommandDeps{
+		lookupEnv:     os.LookupEnv,
+		logout:        oidcclient.Logout,
+		logoutSession: oidcclient.LogoutSession,
+	}
+}
+
+type logoutFlags struct {
+	kubeconfigPath            string
+	kubeconfigContextOverride string
+	sessionCachePath          string
+	credentialCachePath       string
+	all                       bool
+}
+
+func logoutCommand(deps logoutCommandDeps) *cobra.Command {
+	cmd := &cobra.Command{
+		Args:         cobra.NoArgs, // do not accept positional arguments for this command
+		Use:          "logout",
+		Short:        "Remove the cached credentials of a kubeconfig context and revoke its session",
+		SilenceUsage: true,
+	}
+	flags := &logoutFlags{}
+
+	f := cmd.Flags()
+	f.StringVar(&flags.kubeconfigPath, "kubeconfig", os.Getenv("KUBECONFIG"), "Path to kubeconfig file")
+	f.StringVar(&flags.kubeconfigContextOverride, "kubeconfig-context", "", "Kubeconfig context name (default: current active context)")
+	f.StringVar(&flags.sessionCachePath, "session-cache", "", "Path to session cache file")
+	f.StringVar(&flags.credentialCachePath, "credential-cache", "", "Path to cluster-specific credentials cache")
+	f.BoolVar(&flags.all, "all", false, "Remove every cached session and credential, instead of only those of the kubeconfig context")
+
+	cmd.RunE = func(cmd *cobra.Command, _ []string) error {
+		// Default to the same cache files as the "pinniped login" commands.
+		if flags.sessionCachePath == "" {
+			flags.sessionCachePath = filepath.Join(mustGetConfigDir(), "sessions.yaml")
+		}
+		if flags.credentialCachePath == "" {
+			flags.credentialCachePath = filepath.Join(mustGetConfigDir(), "credentials.yaml")
+		}
+		if flags.all {
+			return runLogoutAll(cmd, deps, flags)
+		}
+		return runLogout(cmd, deps, flags)
+	}
+
+	return cmd
+}
+
+// runLogout logs out of the session of a single kubeconfig context.
+func runLogout(cmd *cobra.Command, deps logoutCommandDeps, flags *logoutFlags) error {
+	if _, err := SetLogLevel(deps.lookupEnv); err != nil {
+		plog.WarningErr("Received error while setting log level", err)
+	}
+
+	clientConfig := newClientConfig(flags.kubeconfigPath, flags.kubeconfigContextOverride)
+	kubeconfig, err := clientConfig.RawConfig()
+	if err != nil {
+		return fmt.Errorf("could not load kubeconfig: %w", err)
+	}
+	contextName := kubeconfig.CurrentContext
+	if flags.kubeconfigContextOverride != "" {
+		contextName = flags.kubeconfigContextOverride
+	}
+	kubeContext, ok := kubeconfig.Contexts[contextName]
+	if !ok {
+		return fmt.Errorf("could not find kubeconfig context %q", contextName)
+	}
+	authInfo, ok := kubeconfig.AuthInfos[kubeContext.AuthInfo]
+	if !ok {
+		return fmt.Errorf("could not find kubeconfig user %q of context %q", kubeContext.AuthInfo, contextName)
+	}
+	login, err := parsePinnipedLogin(authInfo.Exec)
+	if err != nil {
+		return err
+	}
+	if login == nil {
+		return fmt.Errorf("kubeconfig context %q does not use \"pinniped login\" to authenticate", contextName)
+	}
+
+	// Remove the cluster-specific credential first, since it does not depend on the issuer being reachable.
+	if path := login.credentialCachePath(flags.credentialCachePath); path != "" {
+		cacheKey, err := login.credentialCacheKey(clientConfig, deps.lookupEnv)
+		if err != nil {
+			return err
+		}
+		if cacheKey != nil {
+			execcredcache.New(path).Delete(cacheKey)
+		}
+	}
+
+	if login.subcommand == "oidc" {
+		issuer, _ := login.flags().GetString("issuer")
+		clientID, _ := login.flags().GetString("client-id")
+		opts, err := login.oidcOptions()
+		if err != nil {
+			return err
+		}
+		opts = append(opts,
+			oidcclient.WithContext(cmd.Context()),
+			oidcclient.WithLogger(klogr.New()),
+			oidcclient.WithSessionCache(filesession.New(login.sessionCachePath(flags.sessionCachePath))),
+		)
+		if err := deps.logout(issuer, clientID, opts...); err != nil {
+			return fmt.Errorf("removed cached session, but could not revoke it on issuer %q: %w", issuer, err)
+		}
+	}
+
+	_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Logged out of kubeconfig context %q.\n", contextName)
+	return nil
+}
+
+// runLogoutAll logs out of every session in the session caches, and removes every cluster-specific credential.
+func runLogoutAll(cmd *cobra.Command, deps logoutCommandDeps, flags *logoutFlags) error {
+	if _, err := SetLogLevel(deps.lookupEnv); err != nil {
+		plog.WarningErr("Received error while setting log level", err)
+	}
+
+	if flags.kubeconfigContextOverride != "" {
+		return fmt.Errorf("--all and --kubeconfig-context cannot be used together")
+	}
+
+	kubeconfig, err := newClientConfig(flags.kubeconfigPath, "").RawConfig()
+	if err != nil {
+		return fmt.Errorf("could not load kubeconfig: %w", err)
+	}
+
+	// Find all the caches which are in use. The kubeconfig may configure non-default cache paths, and it also tells
+	// us which CA bundles to trust when revoking sessions on each issuer.
+	sessionCachePaths := sets.NewString(flags.sessionCachePath)
+	credentialCachePaths := sets.NewString(flags.credentialCachePath)
+	issuerOptions := map[string][]oidcclient.Option{}
+	authInfoNames := make([]string, 0, len(kubeconfig.AuthInfos))
+	for name := range kubeconfig.AuthInfos {
+		authInfoNames = append(authInfoNames, name)
+	}
+	sort.Strings(authInfoNames)
+	for _, authInfoName := range authInfoNames {
+		login, err := parsePinnipedLogin(kubeconfig.AuthInfos[authInfoName].Exec)
+		if err != nil {
+			return err
+		}
+		if login == nil {
+			continue
+		}
+		if path := login.credentialCachePath(flags.credentialCachePath); path != "" {
+			credentialCachePaths.Insert(path)
+		}
+		if login.subcommand != "oidc" {
+			continue
+		}
+		sessionCachePaths.Insert(login.sessionCachePath(flags.sessionCachePath))
+		issuer, _ := login.flags().GetString("issuer")
+		if _, ok := issuerOptions[issuer]; !ok {
+			client, err := login.httpClient()
+			if err != nil {
+				return err
+			}
+			if client != nil {
+				issuerOptions[issuer] = []oidcclient.Option{oidcclient.WithClient(client)}
+			}
+		}
+	}
+
+	for _, path := range credentialCachePaths.List() {
+		execcredcache.New(path).DeleteAll()
+	}
+
+	var errs []error
+	count := 0
+	for _, path := range sessionCachePaths.List() {
+		sessionCache := filesession.New(path)
+		for _, key := range sessionCache.Keys() {
+			opts := append([]oidcclient.Option{
+				oidcclient.WithContext(cmd.Context()),
+				oidcclient.WithLogger(klogr.New()),
+				oidcclient.WithSessionCache(sessionCache),
+			}, issuerOptions[key.Issuer]...)
+			if err := deps.logoutSession(key, opts...); err != nil {
+				errs = append(errs, fmt.Errorf("removed cached session, but could not revoke it on issuer %q: %w", key.Issuer, err))
+			}
+			count++
+		}
+	}
+
+	_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Logged out of %d session(s).\n", count)
+	return utilerrors.NewAggregate(errs)
+}
+
+// pinnipedLogin is the "pinniped login" exec plugin of a kubeconfig user, with its flags parsed.
+type pinnipedLogin struct {
+	execConfig *clientcmdapi.ExecConfig
+	subcommand string // "oidc" or "static"
+	command    *cobra.Command
+}
+
+// parsePinnipedLogin parses the arguments of an exec plugin using the same flags as the "pinniped login" commands.
+// It returns nil when the exec plugin is not a "pinniped login" command.
+func parsePinnipedLogin(execConfig *clientcmdapi.ExecConfig) (*pinnipedLogin, error) {
+	if execConfig == nil || len(execConfig.Args) < 2 || execConfig.Args[0] != "login" {
+		return nil, nil
+	}
+	login := pinnipedLogin{execConfig: execConfig, subcommand: execConfig.Args[1]}
+	switch login.subcommand {
+	case "oidc":
+		login.command = oidcLoginCommand(oidcLoginCommandDeps{})
+	case "static":
+		login.command = staticLoginCommand(staticLoginDeps{})
+	default:
+		return nil, nil
+	}
+	// Do not print warnings about deprecated flags, since these are not the user's own command line arguments.
+	login.flags().SetOutput(ioutil.Discard)
+	if err := login.command.ParseFlags(execConfig.Args[2:]); err != nil {
+		return nil, fmt.Errorf("could not parse arguments of \"pinniped login %s\": %w", login.subcommand, err)
+	}
+	return &login, nil
+}
+
+func (l *pinnipedLogin) flags() *pflag.FlagSet {
+	return l.command.Flags()
+}
+
+// sessionCachePath returns the session cache path from the login arguments, or defaultPath when they do not set it.
+func (l *pinnipedLogin) sessionCachePath(defaultPath string) string {
+	if l.flags().Changed("session-cache") {
+		path, _ := l.flags().GetString("session-cache")
+		return path
+	}
+	return defaultPath
+}
+
+// credentialCachePath returns the credential cache path from the login arguments, or defaultPath when they do not
+// set it. It returns "" when the login arguments disable the credential cache.
+func (l *pinnipedLogin) credentialCachePath(defaultPath string) string {
+	if l.flags().Changed("credential-cache") {
+		path, _ := l.flags().GetString("credential-cache")
+		return path
+	}
+	return defaultPath
+}
+
+// credentialCacheKey returns the key under which the login command caches its cluster-specific credential. This must
+// stay in sync with the cache keys used by the "pinniped login" commands. It returns nil when the key cannot be known.
+func (l *pinnipedLogin) credentialCacheKey(clientConfig clientcmd.ClientConfig, lookupEnv func(string) (string, bool)) (interface{}, error) {
+	clusterInfo, err := execClusterInfo(clientConfig, l.execConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.subcommand == "oidc" {
+		return struct {
+			Args        []string                   `json:"args"`
+			ClusterInfo *clientauthv1beta1.Cluster `json:"cluster"`
+		}{
+			Args:        l.execConfig.Args,
+			ClusterInfo: clusterInfo,
+		}, nil
+	}
+
+	token, _ := l.flags().GetString("token")
+	if tokenEnvName, _ := l.flags().GetString("token-env"); tokenEnvName != "" {
+		var ok bool
+		token, ok = l.lookupExecEnv(tokenEnvName, lookupEnv)
+		if !ok {
+			plog.Debug("could not find the static token, so its cached credential will not be removed", "tokenEnv", tokenEnvName)
+			return nil, nil
+		}
+	}
+	return struct {
+		Args        []string                   `json:"args"`
+		Token       string                     `json:"token"`
+		ClusterInfo *clientauthv1beta1.Cluster `json:"cluster"`
+	}{
+		Args:        l.execConfig.Args,
+		Token:       token,
+		ClusterInfo: clusterInfo,
+	}, nil
+}
+
+// lookupExecEnv looks up an environment variable in the same way as the exec plugin would see it, where the exec
+// plugin configuration may add to the environment inherited from kubectl.
+func (l *pinnipedLogin) lookupExecEnv(name string, lookupEnv func(string) (string, bool)) (string, bool) {
+	for _, env := range l.execConfig.Env {
+		if env.Name == name {
+			return env.Value, true
+		}
+	}
+	return lookupEnv(name)
+}
+
+// oidcOptions returns the options which select the session cache entry of an OIDC login.
+func (l *pinnipedLogin) oidcOptions() ([]oidcclient.Option, error) {
+	scopes, _ := l.flags().GetStringSlice("scopes")
+	opts := []oidcclient.Option{oidcclient.WithScopes(scopes)}
+	if listenPort, _ := l.flags().GetUint16("listen-port"); listenPort != 0 {
+		opts = append(opts, oidcclient.WithListenPort(listenPort))
+	}
+	client, err := l.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	if client != nil {
+		opts = append(opts, oidcclient.WithClient(client))
+	}
+	return opts, nil
+}
+
+// httpClient returns an HTTP client which trusts the CA bundles of an OIDC login, or nil when it has none.
+func (l *pinnipedLogin) httpClient() (*http.Client, error) {
+	caBundlePaths, _ := l.flags().GetStringSlice("ca-bundle")
+	caBundleData, _ := l.flags().GetStringSlice("ca-bundle-data")
+	if len(caBundlePaths) == 0 && len(caBundleData) == 0 {
+		return nil, nil
+	}
+	return makeClient(caBundlePaths, caBundleData)
+}
+
+// execClusterInfo returns the cluster info which kubectl passes to the exec plugin, or nil when the exec plugin does
+// not ask for it. It is part of the credential cache key.
+func execClusterInfo(clientConfig clientcmd.ClientConfig, execConfig *clientcmdapi.ExecConfig) (*clientauthv1beta1.Cluster, error) {
+	if !execConfig.ProvideClusterInfo {
+		return nil, nil
+	}
+	restConfig, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("could not load kubeconfig: %w", err)
+	}
+	internalCluster, err := rest.ConfigToExecCluster(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not load cluster info: %w", err)
+	}
+	var cluster clientauthv1beta1.Cluster
+	if err := clientauthv1beta1.Convert_clientauthentication_Cluster_To_v1beta1_Cluster(internalCluster, &cluster, nil); err != nil {
+		return nil, fmt.Errorf("could not convert cluster info: %w", err)
+	}
+	return &cluster, nil
+}
@@ -0,0 +1,268 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthv1beta1 "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
+
+	"go.pinniped.dev/internal/execcredcache"
+	"go.pinniped.dev/internal/here"
+	"go.pinniped.dev/internal/testutil"
+	"go.pinniped.dev/pkg/oidcclient"
+	"go.pinniped.dev/pkg/oidcclient/filesession"
+	"go.pinniped.dev/pkg/oidcclient/oidctypes"
+)
+
+func TestLogoutCommand(t *testing.T) {
+	oidcArgs := []string{"login", "oidc", "--issuer=https://test-issuer.example.com", "--client-id=test-client-id", "--scopes=openid,offline_access"}
+	staticArgs := []string{"login", "static", "--token-env=TEST_TOKEN_ENV"}
+
+	kubeconfig := here.Doc(`
+		apiVersion: v1
+		kind: Config
+		clusters:
+		  - name: test-cluster
+		    cluster:
+		      server: https://test-cluster.example.com
+		contexts:
+		  - name: oidc-context
+		    context:
+		      cluster: test-cluster
+		      user: oidc-user
+		  - name: static-context
+		    context:
+		      cluster: test-cluster
+		      user: static-user
+		  - name: other-context
+		    context:
+		      cluster: test-cluster
+		      user: other-user
+		current-context: oidc-context
+		users:
+		  - name: oidc-user
+		    user:
+		      exec:
+		        apiVersion: client.authentication.k8s.io/v1beta1
+		        command: pinniped
+		        args: ["` + strings.Join(oidcArgs, `", "`) + `"]
+		  - name: static-user
+		    user:
+		      exec:
+		        apiVersion: client.authentication.k8s.io/v1beta1
+		        command: pinniped
+		        args: ["` + strings.Join(staticArgs, `", "`) + `"]
+		        env:
+		          - name: TEST_TOKEN_ENV
+		            value: test-static-token
+		  - name: other-user
+		    user:
+		      token: some-token
+	`)
+
+	oidcCredentialKey := struct {
+		Args        []string                   `json:"args"`
+		ClusterInfo *clientauthv1beta1.Cluster `json:"cluster"`
+	}{Args: oidcArgs}
+	staticCredentialKey := struct {
+		Args        []string                   `json:"args"`
+		Token       string                     `json:"token"`
+		ClusterInfo *clientauthv1beta1.Cluster `json:"cluster"`
+	}{Args: staticArgs, Token: "test-static-token"}
+
+	sessionKeys := []oidcclient.SessionCacheKey{
+		{Issuer: "https://test-issuer.example.com", ClientID: "test-client-id", Scopes: []string{"offline_access", "openid"}, RedirectURI: "http://localhost:0/callback"},
+		{Issuer: "https://other-issuer.example.com", ClientID: "test-client-id", Scopes: []string{"openid"}, RedirectURI: "http://localhost:0/callback"},
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		logoutErr  error
+		wantError  bool
+		wantStdout string
+		wantStderr string
+
+		wantLogoutIssuer       string
+		wantLogoutClientID     string
+		wantLogoutOptionsCount int
+		wantSessionKeys        []oidcclient.SessionCacheKey
+		wantOIDCCredential     bool
+		wantStaticCredential   bool
+	}{
+		{
+			name: "help flag",
+			args: []string{"--help"},
+			wantStdout: here.Doc(`
+				Remove the cached credentials of a kubeconfig context and revoke its session
+
+				Usage:
+				  logout [flags]
+
+				Flags:
+				      --all                         Remove every cached session and credential, instead of only those of the kubeconfig context
+				      --credential-cache string     Path to cluster-specific credentials cache
+				  -h, --help                        help for logout
+				      --kubeconfig string           Path to kubeconfig file
+				      --kubeconfig-context string   Kubeconfig context name (default: current active context)
+				      --session-cache string        Path to session cache file
+			`),
+			wantOIDCCredential:   true,
+			wantStaticCredential: true,
+		},
+		{
+			name:                   "oidc context",
+			args:                   []string{"--kubeconfig-context", "oidc-context"},
+			wantStdout:             "Logged out of kubeconfig context \"oidc-context\".\n",
+			wantLogoutIssuer:       "https://test-issuer.example.com",
+			wantLogoutClientID:     "test-client-id",
+			wantLogoutOptionsCount: 4,
+			wantStaticCredential:   true,
+		},
+		{
+			name:                   "current context",
+			args:                   []string{},
+			wantStdout:             "Logged out of kubeconfig context \"oidc-context\".\n",
+			wantLogoutIssuer:       "https://test-issuer.example.com",
+			wantLogoutClientID:     "test-client-id",
+			wantLogoutOptionsCount: 4,
+			wantStaticCredential:   true,
+		},
+		{
+			name:                   "revocation fails",
+			args:                   []string{"--kubeconfig-context", "oidc-context"},
+			logoutErr:              fmt.Errorf("some revocation error"),
+			wantError:              true,
+			wantStderr:             "Error: removed cached session, but could not revoke it on issuer \"https://test-issuer.example.com\": some revocation error\n",
+			wantLogoutIssuer:       "https://test-issuer.example.com",
+			wantLogoutClientID:     "test-client-id",
+			wantLogoutOptionsCount: 4,
+			wantStaticCredential:   true,
+		},
+		{
+			name:               "static context",
+			args:               []string{"--kubeconfig-context", "static-context"},
+			wantStdout:         "Logged out of kubeconfig context \"static-context\".\n",
+			wantOIDCCredential: true,
+		},
+		{
+			name:                 "context which does not use pinniped login",
+			args:                 []string{"--kubeconfig-context", "other-context"},
+			wantError:            true,
+			wantStderr:           "Error: kubeconfig context \"other-context\" does not use \"pinniped login\" to authenticate\n",
+			wantOIDCCredential:   true,
+			wantStaticCredential: true,
+		},
+		{
+			name:                 "context not found",
+			args:                 []string{"--kubeconfig-context", "not-a-context"},
+			wantError:            true,
+			wantStderr:           "Error: could not find kubeconfig context \"not-a-context\"\n",
+			wantOIDCCredential:   true,
+			wantStaticCredential: true,
+		},
+		{
+			name:            "all",
+			args:            []string{"--all"},
+			wantStdout:      "Logged out of 2 session(s).\n",
+			wantSessionKeys: sessionKeys,
+		},
+		{
+			name:            "all with revocation errors",
+			args:            []string{"--all"},
+			logoutErr:       fmt.Errorf("some revocation error"),
+			wantError:       true,
+			wantStdout:      "Logged out of 2 session(s).\n",
+			wantStderr:      "Error: [removed cached session, but could not revoke it on issuer \"https://test-issuer.example.com\": some revocation error, removed cached session, but could not revoke it on issuer \"https://other-issuer.example.com\": some revocation error]\n",
+			wantSessionKeys: sessionKeys,
+		},
+		{
+			name:                 "all with a kubeconfig context",
+			args:                 []string{"--all", "--kubeconfig-context", "oidc-context"},
+			wantError:            true,
+			wantStderr:           "Error: --all and --kubeconfig-context cannot be used together\n",
+			wantOIDCCredential:   true,
+			wantStaticCredential: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tmpdir := testutil.TempDir(t)
+			kubeconfigPath := filepath.Join(tmpdir, "kubeconfig.yaml")
+			require.NoError(t, ioutil.WriteFile(kubeconfigPath, []byte(kubeconfig), 0600))
+
+			// Cache a credential for each of the pinniped login contexts.
+			credentialCachePath := filepath.Join(tmpdir, "credentials.yaml")
+			credentialCache := execcredcache.New(credentialCachePath)
+			expiry := metav1.NewTime(time.Now().Add(time.Hour))
+			for _, key := range []interface{}{oidcCredentialKey, staticCredentialKey} {
+				credentialCache.Put(key, &clientauthv1beta1.ExecCredential{
+					Status: &clientauthv1beta1.ExecCredentialStatus{Token: "test-token", ExpirationTimestamp: &expiry},
+				})
+			}
+
+			// Cache a session for each of the issuers.
+			sessionCachePath := filepath.Join(tmpdir, "sessions.yaml")
+			sessionCache := filesession.New(sessionCachePath)
+			for _, key := range sessionKeys {
+				sessionCache.PutToken(key, &oidctypes.Token{RefreshToken: &oidctypes.RefreshToken{Token: "test-refresh-token"}})
+			}
+
+			var (
+				gotLogoutIssuer   string
+				gotLogoutClientID string
+				gotLogoutOptions  []oidcclient.Option
+				gotSessionKeys    []oidcclient.SessionCacheKey
+			)
+			cmd := logoutCommand(logoutCommandDeps{
+				lookupEnv: func(string) (string, bool) { return "", false },
+				logout: func(issuer string, clientID string, opts ...oidcclient.Option) error {
+					gotLogoutIssuer = issuer
+					gotLogoutClientID = clientID
+					gotLogoutOptions = opts
+					return tt.logoutErr
+				},
+				logoutSession: func(key oidcclient.SessionCacheKey, opts ...oidcclient.Option) error {
+					gotSessionKeys = append(gotSessionKeys, key)
+					return tt.logoutErr
+				},
+			})
+			require.NotNil(t, cmd)
+
+			var stdout, stderr bytes.Buffer
+			cmd.SetOut(&stdout)
+			cmd.SetErr(&stderr)
+			cmd.SetArgs(append([]string{
+				"--kubeconfig", kubeconfigPath,
+				"--session-cache", sessionCachePath,
+				"--credential-cache", credentialCachePath,
+			}, tt.args...))
+			err := cmd.Execute()
+			if tt.wantError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.wantStdout, stdout.String(), "unexpected stdout")
+			require.Equal(t, tt.wantStderr, stderr.String(), "unexpected stderr")
+
+			require.Equal(t, tt.wantLogoutIssuer, gotLogoutIssuer)
+			require.Equal(t, tt.wantLogoutClientID, gotLogoutClientID)
+			require.Len(t, gotLogoutOptions, tt.wantLogoutOptionsCount)
+			require.Equal(t, tt.wantSessionKeys, gotSessionKeys)
+			require.Equal(t, tt.wantOIDCCredential, credentialCache.Get(oidcCredentialKey) != nil, "unexpected OIDC credential")
+			require.Equal(t, tt.wantStaticCredential, credentialCache.Get(staticCredentialKey) != nil, "unexpected static credential")
+		})
+	}
+}
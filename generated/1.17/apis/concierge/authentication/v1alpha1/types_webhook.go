@@ -25,6 +25,12 @@ type WebhookAuthenticatorSpec struct {
 	// TLS configuration.
 	// +optional
 	TLS *TLSSpec `json:"tls,omitempty"`
+
+	// ClientCertificateTTL is the lifetime of the client certificates which are issued by TokenCredentialRequests
+	// that were authenticated by this authenticator, e.g. "10m" or "1h". When not specified, it will default to 5
+	// minutes. It is limited by the spec.maxClientCertificateTTL of the CredentialIssuer, when that is specified.
+	// +optional
+	ClientCertificateTTL *metav1.Duration `json:"clientCertificateTTL,omitempty"`
 }
 
 // WebhookAuthenticator describes the configuration of a webhook authenticator.
@@ -0,0 +1,138 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type SAMLIdentityProviderPhase string
+
+const (
+	// SAMLPhasePending is the default phase for newly-created SAMLIdentityProvider resources.
+	SAMLPhasePending SAMLIdentityProviderPhase = "Pending"
+
+	// SAMLPhaseReady is the phase for a SAMLIdentityProvider resource in a healthy state.
+	SAMLPhaseReady SAMLIdentityProviderPhase = "Ready"
+
+	// SAMLPhaseError is the phase for a SAMLIdentityProvider in an unhealthy state.
+	SAMLPhaseError SAMLIdentityProviderPhase = "Error"
+)
+
+// +kubebuilder:validation:Enum=HTTP-Redirect;HTTP-POST
+type SAMLBinding string
+
+const (
+	// SAMLBindingHTTPRedirect sends the authentication request to the identity provider in the query params of a
+	// redirect.
+	SAMLBindingHTTPRedirect SAMLBinding = "HTTP-Redirect"
+
+	// SAMLBindingHTTPPOST sends the authentication request to the identity provider in an HTML form which is
+	// automatically posted by the browser.
+	SAMLBindingHTTPPOST SAMLBinding = "HTTP-POST"
+)
+
+// Status of a SAML identity provider.
+type SAMLIdentityProviderStatus struct {
+	// Phase summarizes the overall status of the SAMLIdentityProvider.
+	// +kubebuilder:default=Pending
+	// +kubebuilder:validation:Enum=Pending;Ready;Error
+	Phase SAMLIdentityProviderPhase `json:"phase,omitempty"`
+
+	// Represents the observations of an identity provider's current state.
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// SAMLMetadata provides the SAML metadata of the identity provider, either by its URL or inline. Exactly one of URL
+// and Data must be specified.
+type SAMLMetadata struct {
+	// URL is where the SAML metadata document of the identity provider is fetched from, e.g.
+	// "https://example.okta.com/app/abc123/sso/saml/metadata".
+	// +kubebuilder:validation:Pattern=`^https://`
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// TLS configuration for fetching the metadata from the URL.
+	// +optional
+	TLS *TLSSpec `json:"tls,omitempty"`
+
+	// Data is the SAML metadata document of the identity provider, as XML.
+	// +optional
+	Data string `json:"data,omitempty"`
+}
+
+// SAMLAuthnRequestConfig provides information about how to send the authentication request to the identity provider.
+type SAMLAuthnRequestConfig struct {
+	// Binding is the SAML binding which is used to send the authentication request to the single sign-on service
+	// of the identity provider, either "HTTP-Redirect" or "HTTP-POST". The metadata of the identity provider must
+	// list a single sign-on service for this binding. Optional, when empty this defaults to "HTTP-Redirect".
+	// The identity provider always sends its response to the assertion consumer service with the HTTP-POST binding.
+	// +optional
+	Binding SAMLBinding `json:"binding,omitempty"`
+}
+
+// SAMLClaims provides a mapping from the attributes of SAML assertions into identities.
+type SAMLClaims struct {
+	// Groups provides the name of the assertion attribute whose values will be used to ascertain the groups to which
+	// an identity belongs. Optional, when empty the identity will not belong to any groups from this identity provider.
+	// +optional
+	Groups string `json:"groups"`
+
+	// Username provides the name of the assertion attribute that will be used to ascertain an identity's username.
+	// The attribute must have exactly one value. Optional, when empty the NameID of the subject of the assertion
+	// will be used as the username.
+	// +optional
+	Username string `json:"username"`
+}
+
+// Spec for configuring a SAML identity provider.
+type SAMLIdentityProviderSpec struct {
+	// Metadata provides the SAML metadata of the identity provider, which includes its entity ID, the location of its
+	// single sign-on service, and its signing certificates. The Supervisor is the service provider. Its entity ID is
+	// the issuer of the FederationDomain, and its assertion consumer service is the "/saml/acs" endpoint of the
+	// FederationDomain, e.g. "https://issuer.example.com/some/path/saml/acs".
+	Metadata SAMLMetadata `json:"metadata"`
+
+	// AuthnRequest holds information about how to send the authentication request to the identity provider.
+	// +optional
+	AuthnRequest SAMLAuthnRequestConfig `json:"authnRequest,omitempty"`
+
+	// Claims provides the names of assertion attributes that will be used when inspecting an identity from
+	// this SAML identity provider.
+	// +optional
+	Claims SAMLClaims `json:"claims"`
+}
+
+// SAMLIdentityProvider describes the configuration of an upstream SAML 2.0 identity provider.
+// Users log in with the Web Browser SSO profile, started by the Supervisor as the service provider.
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:categories=pinniped;pinniped-idp;pinniped-idps
+// +kubebuilder:printcolumn:name="Metadata URL",type=string,JSONPath=`.spec.metadata.url`
+// +kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+// +kubebuilder:subresource:status
+type SAMLIdentityProvider struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec for configuring the identity provider.
+	Spec SAMLIdentityProviderSpec `json:"spec"`
+
+	// Status of the identity provider.
+	Status SAMLIdentityProviderStatus `json:"status,omitempty"`
+}
+
+// List of SAMLIdentityProvider objects.
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type SAMLIdentityProviderList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []SAMLIdentityProvider `json:"items"`
+}
@@ -41,6 +41,103 @@ type FederationDomainTLSSpec struct {
 	SecretName string `json:"secretName,omitempty"`
 }
 
+// +kubebuilder:validation:Enum=oidc;ldap;activedirectory;github;saml
+type FederationDomainIdentityProviderType string
+
+const (
+	OIDCFederationDomainIdentityProviderType            = FederationDomainIdentityProviderType("oidc")
+	LDAPFederationDomainIdentityProviderType            = FederationDomainIdentityProviderType("ldap")
+	ActiveDirectoryFederationDomainIdentityProviderType = FederationDomainIdentityProviderType("activedirectory")
+	GitHubFederationDomainIdentityProviderType          = FederationDomainIdentityProviderType("github")
+	SAMLFederationDomainIdentityProviderType            = FederationDomainIdentityProviderType("saml")
+)
+
+// FederationDomainIdentityProvider refers to an upstream identity provider which may be used to log in to a
+// FederationDomain.
+type FederationDomainIdentityProvider struct {
+	// Name is the name of an identity provider resource in the same namespace as this FederationDomain.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Type is the type of the identity provider resource, i.e. "oidc" for an OIDCIdentityProvider,
+	// "ldap" for an LDAPIdentityProvider, "activedirectory" for an ActiveDirectoryIdentityProvider,
+	// "github" for a GitHubIdentityProvider, or "saml" for a SAMLIdentityProvider.
+	Type FederationDomainIdentityProviderType `json:"type"`
+
+	// Transforms is an optional list of transformations which are applied, in order, to the username and group names
+	// of each user who logs in through this identity provider, before the user's session is created. They are applied
+	// again whenever the session is refreshed. For example, a prefix can keep the usernames from two identity
+	// providers from colliding.
+	// +optional
+	Transforms []FederationDomainTransform `json:"transforms,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=prefix;regexReplace;lowercase;allowGroups;denyGroups;denyLogin
+type FederationDomainTransformType string
+
+const (
+	PrefixFederationDomainTransformType       = FederationDomainTransformType("prefix")
+	RegexReplaceFederationDomainTransformType = FederationDomainTransformType("regexReplace")
+	LowercaseFederationDomainTransformType    = FederationDomainTransformType("lowercase")
+	AllowGroupsFederationDomainTransformType  = FederationDomainTransformType("allowGroups")
+	DenyGroupsFederationDomainTransformType   = FederationDomainTransformType("denyGroups")
+	DenyLoginFederationDomainTransformType    = FederationDomainTransformType("denyLogin")
+)
+
+// +kubebuilder:validation:Enum=username;groups
+type FederationDomainTransformTarget string
+
+const (
+	UsernameFederationDomainTransformTarget = FederationDomainTransformTarget("username")
+	GroupsFederationDomainTransformTarget   = FederationDomainTransformTarget("groups")
+)
+
+// FederationDomainTransform is one step of the transformation of a user's identity.
+type FederationDomainTransform struct {
+	// Type is the type of the transformation:
+	// "prefix" adds Prefix to the start of the target.
+	// "regexReplace" replaces each match of Regex in the target with Replacement.
+	// "lowercase" converts the target to lowercase.
+	// "allowGroups" removes every group whose name does not match Regex.
+	// "denyGroups" removes every group whose name matches Regex.
+	// "denyLogin" rejects the login when the target matches Regex.
+	Type FederationDomainTransformType `json:"type"`
+
+	// Target is the part of the identity to transform, i.e. "username" or "groups". When it is "groups", the
+	// transformation applies to each group name, and "denyLogin" rejects the login when any group name matches.
+	// It is ignored by "allowGroups" and "denyGroups". Defaults to "username".
+	// +optional
+	Target FederationDomainTransformTarget `json:"target,omitempty"`
+
+	// Prefix is the prefix to add. Required by "prefix" and ignored by the other types.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// Regex is an RE2 regular expression. Required by "regexReplace", "allowGroups", "denyGroups", and "denyLogin",
+	// and ignored by the other types.
+	// +optional
+	Regex string `json:"regex,omitempty"`
+
+	// Replacement is the replacement for each match of Regex, which may refer to the capture groups of Regex,
+	// e.g. "${1}". Only used by "regexReplace".
+	// +optional
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// FederationDomainTokenLifetimes configures the lifetimes of the tokens issued by a FederationDomain.
+type FederationDomainTokenLifetimes struct {
+	// AccessToken is the lifetime of the access tokens and ID tokens issued by this FederationDomain, as a duration
+	// string such as "5m". It must not be longer than "1h". Defaults to "2m".
+	// +optional
+	AccessToken *metav1.Duration `json:"accessToken,omitempty"`
+
+	// RefreshToken is the lifetime of the refresh tokens issued by this FederationDomain, as a duration string such
+	// as "24h". This is how long a user's session lasts before they must log in again. It must not be shorter than
+	// AccessToken, and it must not be longer than "168h" (7 days). Defaults to "9h".
+	// +optional
+	RefreshToken *metav1.Duration `json:"refreshToken,omitempty"`
+}
+
 // FederationDomainSpec is a struct that describes an OIDC Provider.
 type FederationDomainSpec struct {
 	// Issuer is the OIDC Provider's issuer, per the OIDC Discovery Metadata document, as well as the
@@ -57,6 +154,18 @@ type FederationDomainSpec struct {
 	// TLS configures how this FederationDomain is served over Transport Layer Security (TLS).
 	// +optional
 	TLS *FederationDomainTLSSpec `json:"tls,omitempty"`
+
+	// IdentityProviders is an optional list of the upstream identity providers which may be used to log in to this
+	// FederationDomain. When this list is empty, all identity providers in the same namespace may be used. When it
+	// is not empty, only the identity providers listed here will be advertised by this FederationDomain's
+	// identity provider discovery endpoint and accepted by its authorization endpoint.
+	// +optional
+	IdentityProviders []FederationDomainIdentityProvider `json:"identityProviders,omitempty"`
+
+	// TokenLifetimes optionally configures the lifetimes of the tokens issued by this FederationDomain. When it is
+	// not specified, the default lifetimes are used.
+	// +optional
+	TokenLifetimes *FederationDomainTokenLifetimes `json:"tokenLifetimes,omitempty"`
 }
 
 // FederationDomainSecrets holds information about this OIDC Provider's secrets.
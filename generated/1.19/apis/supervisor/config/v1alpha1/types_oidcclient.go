@@ -0,0 +1,108 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:validation:Enum=Pending;Ready;Error
+type OIDCClientPhase string
+
+const (
+	// OIDCClientPhasePending is the default phase for newly-created OIDCClient resources.
+	OIDCClientPhasePending OIDCClientPhase = "Pending"
+
+	// OIDCClientPhaseReady is the phase for an OIDCClient resource in a healthy state.
+	OIDCClientPhaseReady OIDCClientPhase = "Ready"
+
+	// OIDCClientPhaseError is the phase for an OIDCClient in an unhealthy state.
+	OIDCClientPhaseError OIDCClientPhase = "Error"
+)
+
+// RedirectURI is a redirect URI which may be used by an OIDCClient. It must use the https scheme, unless its host is
+// the IPv4 or IPv6 loopback address (127.0.0.1 or [::1]), in which case it may use the http scheme.
+// +kubebuilder:validation:Pattern=`^https://.+|^http://(127\.0\.0\.1|\[::1\])(:\d+)?/`
+type RedirectURI string
+
+// GrantType is an OAuth2 grant type which may be used by an OIDCClient.
+// +kubebuilder:validation:Enum="authorization_code";"refresh_token";"urn:ietf:params:oauth:grant-type:token-exchange"
+type GrantType string
+
+// Scope is an OAuth2 scope which may be requested by an OIDCClient.
+// +kubebuilder:validation:Enum="openid";"offline_access";"profile";"email";"pinniped:request-audience"
+type Scope string
+
+// OIDCClientSpec is a struct that describes an OIDCClient.
+type OIDCClientSpec struct {
+	// AllowedRedirectURIs is a list of the redirect_uri param values which will be accepted during authorization
+	// requests made by this client. Any other redirect_uri values will be rejected.
+	// +kubebuilder:validation:MinItems=1
+	AllowedRedirectURIs []RedirectURI `json:"allowedRedirectURIs"`
+
+	// AllowedGrantTypes is a list of the grant_type param values which will be accepted by the token endpoint for
+	// this client. It must include "authorization_code". The "refresh_token" grant type requires the
+	// "offline_access" scope to be allowed, and the "urn:ietf:params:oauth:grant-type:token-exchange" grant type
+	// requires the "pinniped:request-audience" scope to be allowed.
+	// +kubebuilder:validation:MinItems=1
+	AllowedGrantTypes []GrantType `json:"allowedGrantTypes"`
+
+	// AllowedScopes is a list of the scopes which this client may request. It must include "openid". Any other
+	// requested scopes will be rejected.
+	// +kubebuilder:validation:MinItems=1
+	AllowedScopes []Scope `json:"allowedScopes"`
+
+	// ClientSecretName is the name of a Secret in the same namespace, of type
+	// `secrets.pinniped.dev/oidc-client-secret-hash`, which contains the hashed client secret for this client.
+	// The Secret must contain a key named `bcryptHash` whose value is a bcrypt hash of the client secret, using a
+	// cost of at least 12. The plaintext client secret is never stored by the Supervisor.
+	// +kubebuilder:validation:MinLength=1
+	ClientSecretName string `json:"clientSecretName"`
+}
+
+// OIDCClientStatus is a struct that describes the actual state of an OIDCClient.
+type OIDCClientStatus struct {
+	// Phase summarizes the overall status of the OIDCClient.
+	// +kubebuilder:default=Pending
+	// +kubebuilder:validation:Enum=Pending;Ready;Error
+	Phase OIDCClientPhase `json:"phase,omitempty"`
+
+	// Message provides human-readable details about the Phase.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// LastUpdateTime holds the time at which the Phase was last updated. It is a pointer to get
+	// around some undesirable behavior with respect to the empty metav1.Time value (see
+	// https://github.com/kubernetes/kubernetes/issues/86811).
+	// +optional
+	LastUpdateTime *metav1.Time `json:"lastUpdateTime,omitempty"`
+}
+
+// OIDCClient describes the configuration of an OIDC client which may use the Supervisor's FederationDomains.
+// The name of the OIDCClient is used as its client ID, and it must start with "client.oauth.pinniped.dev-".
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:categories=pinniped
+// +kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+// +kubebuilder:subresource:status
+type OIDCClient struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec of the OIDC client.
+	Spec OIDCClientSpec `json:"spec"`
+
+	// Status of the OIDC client.
+	Status OIDCClientStatus `json:"status,omitempty"`
+}
+
+// List of OIDCClient objects.
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type OIDCClientList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []OIDCClient `json:"items"`
+}
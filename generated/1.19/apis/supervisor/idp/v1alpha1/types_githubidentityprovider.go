@@ -0,0 +1,184 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type GitHubIdentityProviderPhase string
+
+const (
+	// GitHubPhasePending is the default phase for newly-created GitHubIdentityProvider resources.
+	GitHubPhasePending GitHubIdentityProviderPhase = "Pending"
+
+	// GitHubPhaseReady is the phase for a GitHubIdentityProvider resource in a healthy state.
+	GitHubPhaseReady GitHubIdentityProviderPhase = "Ready"
+
+	// GitHubPhaseError is the phase for a GitHubIdentityProvider in an unhealthy state.
+	GitHubPhaseError GitHubIdentityProviderPhase = "Error"
+)
+
+// +kubebuilder:validation:Enum=login:id;login;id
+type GitHubUsernameAttribute string
+
+const (
+	// GitHubUsernameLoginAndID uses the login of the GitHub user and their numeric ID, separated by a colon,
+	// e.g. "octocat:583231". The login can be changed by the user, so the ID makes the username unique over time.
+	GitHubUsernameLoginAndID GitHubUsernameAttribute = "login:id"
+
+	// GitHubUsernameLogin uses the login of the GitHub user, e.g. "octocat".
+	GitHubUsernameLogin GitHubUsernameAttribute = "login"
+
+	// GitHubUsernameID uses the numeric ID of the GitHub user, e.g. "583231".
+	GitHubUsernameID GitHubUsernameAttribute = "id"
+)
+
+// +kubebuilder:validation:Enum=name;slug
+type GitHubGroupNameAttribute string
+
+const (
+	// GitHubUseTeamNameForGroupName uses the login of the organization and the name of the team, separated by a
+	// slash, e.g. "my-org/My Team".
+	GitHubUseTeamNameForGroupName GitHubGroupNameAttribute = "name"
+
+	// GitHubUseTeamSlugForGroupName uses the login of the organization and the slug of the team, separated by a
+	// slash, e.g. "my-org/my-team".
+	GitHubUseTeamSlugForGroupName GitHubGroupNameAttribute = "slug"
+)
+
+// +kubebuilder:validation:Enum=OnlyUsersFromAllowedOrganizations;AllGitHubUsers
+type GitHubAllowedAuthOrganizationsPolicy string
+
+const (
+	// GitHubAllowedAuthOrganizationsPolicyOnlyUsersFromAllowedOrganizations only allows the members of at least one
+	// of the allowed organizations to log in.
+	GitHubAllowedAuthOrganizationsPolicyOnlyUsersFromAllowedOrganizations GitHubAllowedAuthOrganizationsPolicy = "OnlyUsersFromAllowedOrganizations"
+
+	// GitHubAllowedAuthOrganizationsPolicyAllGitHubUsers allows any user of the GitHub server to log in.
+	GitHubAllowedAuthOrganizationsPolicyAllGitHubUsers GitHubAllowedAuthOrganizationsPolicy = "AllGitHubUsers"
+)
+
+// Status of a GitHub identity provider.
+type GitHubIdentityProviderStatus struct {
+	// Phase summarizes the overall status of the GitHubIdentityProvider.
+	// +kubebuilder:default=Pending
+	// +kubebuilder:validation:Enum=Pending;Ready;Error
+	Phase GitHubIdentityProviderPhase `json:"phase,omitempty"`
+
+	// Represents the observations of an identity provider's current state.
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// GitHubAPIConfig allows configuration for GitHub Enterprise Server.
+type GitHubAPIConfig struct {
+	// Host is the hostname of the GitHub server, optionally with a port, e.g. "github.example.com".
+	// Optional, when empty this defaults to "github.com", whose API is served by "api.github.com".
+	// For GitHub Enterprise Server, the API is served at the "/api/v3" path of the host.
+	// +kubebuilder:validation:MinLength=1
+	// +optional
+	Host *string `json:"host,omitempty"`
+
+	// TLS configuration for the GitHub server.
+	// +optional
+	TLS *TLSSpec `json:"tls,omitempty"`
+}
+
+// GitHubClaims allows customization of the username and groups claims.
+type GitHubClaims struct {
+	// Username configures which attribute of the GitHub user shall become their username.
+	// Optional, when empty this defaults to "login:id".
+	// +optional
+	Username *GitHubUsernameAttribute `json:"username,omitempty"`
+
+	// Groups configures which attribute of the GitHub teams of the user shall become their group names.
+	// Optional, when empty this defaults to "slug".
+	// +optional
+	Groups *GitHubGroupNameAttribute `json:"groups,omitempty"`
+}
+
+// GitHubClientSpec contains information about the GitHub OAuth client.
+type GitHubClientSpec struct {
+	// SecretName contains the name of a namespace-local Secret object that provides the clientID and
+	// clientSecret of a GitHub App or GitHub OAuth App. The Secret must be of type
+	// "secrets.pinniped.dev/github-client" with keys "clientID" and "clientSecret".
+	// The callback URL of the app must be the callback endpoint of the FederationDomain, e.g.
+	// "https://issuer.example.com/some/path/callback".
+	// +kubebuilder:validation:MinLength=1
+	SecretName string `json:"secretName"`
+}
+
+// GitHubOrganizationsSpec restricts the GitHub users who may log in by their organization memberships.
+type GitHubOrganizationsSpec struct {
+	// Policy is either "OnlyUsersFromAllowedOrganizations", which requires the user to be a member of at least one of
+	// the Allowed organizations, or "AllGitHubUsers", which allows any user of the GitHub server to log in.
+	// Optional, when empty this defaults to "OnlyUsersFromAllowedOrganizations".
+	// +kubebuilder:default=OnlyUsersFromAllowedOrganizations
+	// +optional
+	Policy *GitHubAllowedAuthOrganizationsPolicy `json:"policy,omitempty"`
+
+	// Allowed is the list of the logins of the GitHub organizations whose members may log in, e.g. "my-org".
+	// Organization logins are case-insensitive. When the policy is "OnlyUsersFromAllowedOrganizations", this must
+	// not be empty, and only the teams of these organizations become groups of the user. When the policy is
+	// "AllGitHubUsers", this must be empty, and all the teams of the user become groups.
+	// +listType=set
+	// +optional
+	Allowed []string `json:"allowed,omitempty"`
+}
+
+// GitHubAllowAuthenticationSpec configures which GitHub users may log in.
+type GitHubAllowAuthenticationSpec struct {
+	// Organizations restricts the GitHub users who may log in by their organization memberships.
+	Organizations GitHubOrganizationsSpec `json:"organizations"`
+}
+
+// Spec for configuring a GitHub identity provider.
+type GitHubIdentityProviderSpec struct {
+	// GitHubAPI configures the GitHub server. By default, the users of github.com may log in.
+	// +optional
+	GitHubAPI GitHubAPIConfig `json:"githubAPI,omitempty"`
+
+	// Claims configures how the identity of a GitHub user is mapped to their username and groups.
+	// +optional
+	Claims GitHubClaims `json:"claims,omitempty"`
+
+	// AllowAuthentication configures which GitHub users may log in.
+	AllowAuthentication GitHubAllowAuthenticationSpec `json:"allowAuthentication"`
+
+	// Client contains the credentials of the GitHub OAuth client.
+	Client GitHubClientSpec `json:"client"`
+}
+
+// GitHubIdentityProvider describes the configuration of an upstream GitHub identity provider.
+// Users log in with the OAuth 2.0 authorization code flow of a GitHub App or GitHub OAuth App.
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:categories=pinniped;pinniped-idp;pinniped-idps
+// +kubebuilder:printcolumn:name="Host",type=string,JSONPath=`.spec.githubAPI.host`
+// +kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+// +kubebuilder:subresource:status
+type GitHubIdentityProvider struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec for configuring the identity provider.
+	Spec GitHubIdentityProviderSpec `json:"spec"`
+
+	// Status of the identity provider.
+	Status GitHubIdentityProviderStatus `json:"status,omitempty"`
+}
+
+// List of GitHubIdentityProvider objects.
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type GitHubIdentityProviderList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []GitHubIdentityProvider `json:"items"`
+}
@@ -11,10 +11,16 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// ActiveDirectoryIdentityProviders returns a ActiveDirectoryIdentityProviderInformer.
+	ActiveDirectoryIdentityProviders() ActiveDirectoryIdentityProviderInformer
+	// GitHubIdentityProviders returns a GitHubIdentityProviderInformer.
+	GitHubIdentityProviders() GitHubIdentityProviderInformer
 	// LDAPIdentityProviders returns a LDAPIdentityProviderInformer.
 	LDAPIdentityProviders() LDAPIdentityProviderInformer
 	// OIDCIdentityProviders returns a OIDCIdentityProviderInformer.
 	OIDCIdentityProviders() OIDCIdentityProviderInformer
+	// SAMLIdentityProviders returns a SAMLIdentityProviderInformer.
+	SAMLIdentityProviders() SAMLIdentityProviderInformer
 }
 
 type version struct {
@@ -28,6 +34,16 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// ActiveDirectoryIdentityProviders returns a ActiveDirectoryIdentityProviderInformer.
+func (v *version) ActiveDirectoryIdentityProviders() ActiveDirectoryIdentityProviderInformer {
+	return &activeDirectoryIdentityProviderInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
+// GitHubIdentityProviders returns a GitHubIdentityProviderInformer.
+func (v *version) GitHubIdentityProviders() GitHubIdentityProviderInformer {
+	return &gitHubIdentityProviderInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
 // LDAPIdentityProviders returns a LDAPIdentityProviderInformer.
 func (v *version) LDAPIdentityProviders() LDAPIdentityProviderInformer {
 	return &lDAPIdentityProviderInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
@@ -37,3 +53,8 @@ func (v *version) LDAPIdentityProviders() LDAPIdentityProviderInformer {
 func (v *version) OIDCIdentityProviders() OIDCIdentityProviderInformer {
 	return &oIDCIdentityProviderInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
+
+// SAMLIdentityProviders returns a SAMLIdentityProviderInformer.
+func (v *version) SAMLIdentityProviders() SAMLIdentityProviderInformer {
+	return &sAMLIdentityProviderInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
@@ -8,7 +8,7 @@ import (
 )
 
 // StrategyType enumerates a type of "strategy" used to implement credential access on a cluster.
-// +kubebuilder:validation:Enum=KubeClusterSigningCertificate;ImpersonationProxy
+// +kubebuilder:validation:Enum=KubeClusterSigningCertificate;ExternalSigningCertificate;KubeCertificateSigningRequest;ImpersonationProxy
 type StrategyType string
 
 // FrontendType enumerates a type of "frontend" used to provide access to users of a cluster.
@@ -20,11 +20,13 @@ type FrontendType string
 type StrategyStatus string
 
 // StrategyReason enumerates the detailed reason why a strategy is in a particular status.
-// +kubebuilder:validation:Enum=Listening;Pending;Disabled;ErrorDuringSetup;CouldNotFetchKey;CouldNotGetClusterInfo;FetchedKey
+// +kubebuilder:validation:Enum=Listening;Pending;Disabled;ErrorDuringSetup;CouldNotFetchKey;CouldNotGetClusterInfo;FetchedKey;SigningRequestsAllowed;SigningRequestsForbidden;InvalidSigningCertificate
 type StrategyReason string
 
 const (
 	KubeClusterSigningCertificateStrategyType = StrategyType("KubeClusterSigningCertificate")
+	ExternalSigningCertificateStrategyType    = StrategyType("ExternalSigningCertificate")
+	KubeCertificateSigningRequestStrategyType = StrategyType("KubeCertificateSigningRequest")
 	ImpersonationProxyStrategyType            = StrategyType("ImpersonationProxy")
 
 	TokenCredentialRequestAPIFrontendType = FrontendType("TokenCredentialRequestAPI")
@@ -33,19 +35,45 @@ const (
 	SuccessStrategyStatus = StrategyStatus("Success")
 	ErrorStrategyStatus   = StrategyStatus("Error")
 
-	ListeningStrategyReason              = StrategyReason("Listening")
-	PendingStrategyReason                = StrategyReason("Pending")
-	DisabledStrategyReason               = StrategyReason("Disabled")
-	ErrorDuringSetupStrategyReason       = StrategyReason("ErrorDuringSetup")
-	CouldNotFetchKeyStrategyReason       = StrategyReason("CouldNotFetchKey")
-	CouldNotGetClusterInfoStrategyReason = StrategyReason("CouldNotGetClusterInfo")
-	FetchedKeyStrategyReason             = StrategyReason("FetchedKey")
+	ListeningStrategyReason                 = StrategyReason("Listening")
+	PendingStrategyReason                   = StrategyReason("Pending")
+	DisabledStrategyReason                  = StrategyReason("Disabled")
+	ErrorDuringSetupStrategyReason          = StrategyReason("ErrorDuringSetup")
+	CouldNotFetchKeyStrategyReason          = StrategyReason("CouldNotFetchKey")
+	CouldNotGetClusterInfoStrategyReason    = StrategyReason("CouldNotGetClusterInfo")
+	FetchedKeyStrategyReason                = StrategyReason("FetchedKey")
+	SigningRequestsAllowedStrategyReason    = StrategyReason("SigningRequestsAllowed")
+	SigningRequestsForbiddenStrategyReason  = StrategyReason("SigningRequestsForbidden")
+	InvalidSigningCertificateStrategyReason = StrategyReason("InvalidSigningCertificate")
 )
 
 // CredentialIssuerSpec describes the intended configuration of the Concierge.
 type CredentialIssuerSpec struct {
 	// ImpersonationProxy describes the intended configuration of the Concierge impersonation proxy.
 	ImpersonationProxy *ImpersonationProxySpec `json:"impersonationProxy"`
+
+	// SigningCertificate describes a CA certificate and key which the Concierge should use to sign the client
+	// certificates of TokenCredentialRequests, for clusters where the cluster's own signing key cannot be fetched.
+	//
+	// +optional
+	SigningCertificate *SigningCertificateSpec `json:"signingCertificate,omitempty"`
+
+	// MaxClientCertificateTTL is the upper limit on the lifetime of the client certificates which are issued by
+	// TokenCredentialRequests, e.g. "1h". It caps the clientCertificateTTL configured by each authenticator. When not
+	// specified, the lifetime of the client certificates is capped at 24 hours.
+	//
+	// +optional
+	MaxClientCertificateTTL *metav1.Duration `json:"maxClientCertificateTTL,omitempty"`
+}
+
+// SigningCertificateSpec describes a CA certificate and key which the Concierge should use to sign client certificates.
+type SigningCertificateSpec struct {
+	// SecretName is the name of a Secret in the namespace of the Concierge which holds the PEM-encoded CA certificate
+	// in its "tls.crt" key and the corresponding private key in its "tls.key" key. The Kubernetes API server must
+	// trust this CA for client certificates, e.g. by including it in the bundle of its --client-ca-file flag.
+	//
+	// +kubebuilder:validation:MinLength=1
+	SecretName string `json:"secretName"`
 }
 
 // ImpersonationProxyMode enumerates the configuration modes for the impersonation proxy.
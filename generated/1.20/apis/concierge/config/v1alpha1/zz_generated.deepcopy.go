@@ -8,6 +8,7 @@
 package v1alpha1
 
 import (
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -122,6 +123,16 @@ func (in *CredentialIssuerSpec) DeepCopyInto(out *CredentialIssuerSpec) {
 		*out = new(ImpersonationProxySpec)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.SigningCertificate != nil {
+		in, out := &in.SigningCertificate, &out.SigningCertificate
+		*out = new(SigningCertificateSpec)
+		**out = **in
+	}
+	if in.MaxClientCertificateTTL != nil {
+		in, out := &in.MaxClientCertificateTTL, &out.MaxClientCertificateTTL
+		*out = new(v1.Duration)
+		**out = **in
+	}
 	return
 }
 
@@ -241,6 +252,22 @@ func (in *ImpersonationProxySpec) DeepCopy() *ImpersonationProxySpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SigningCertificateSpec) DeepCopyInto(out *SigningCertificateSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SigningCertificateSpec.
+func (in *SigningCertificateSpec) DeepCopy() *SigningCertificateSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SigningCertificateSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TokenCredentialRequestAPIInfo) DeepCopyInto(out *TokenCredentialRequestAPIInfo) {
 	*out = *in
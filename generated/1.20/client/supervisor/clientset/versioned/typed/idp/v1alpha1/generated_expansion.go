@@ -5,6 +5,12 @@
 
 package v1alpha1
 
+type ActiveDirectoryIdentityProviderExpansion interface{}
+
+type GitHubIdentityProviderExpansion interface{}
+
 type LDAPIdentityProviderExpansion interface{}
 
 type OIDCIdentityProviderExpansion interface{}
+
+type SAMLIdentityProviderExpansion interface{}
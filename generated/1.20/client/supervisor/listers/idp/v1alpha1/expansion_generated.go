@@ -5,6 +5,22 @@
 
 package v1alpha1
 
+// ActiveDirectoryIdentityProviderListerExpansion allows custom methods to be added to
+// ActiveDirectoryIdentityProviderLister.
+type ActiveDirectoryIdentityProviderListerExpansion interface{}
+
+// ActiveDirectoryIdentityProviderNamespaceListerExpansion allows custom methods to be added to
+// ActiveDirectoryIdentityProviderNamespaceLister.
+type ActiveDirectoryIdentityProviderNamespaceListerExpansion interface{}
+
+// GitHubIdentityProviderListerExpansion allows custom methods to be added to
+// GitHubIdentityProviderLister.
+type GitHubIdentityProviderListerExpansion interface{}
+
+// GitHubIdentityProviderNamespaceListerExpansion allows custom methods to be added to
+// GitHubIdentityProviderNamespaceLister.
+type GitHubIdentityProviderNamespaceListerExpansion interface{}
+
 // LDAPIdentityProviderListerExpansion allows custom methods to be added to
 // LDAPIdentityProviderLister.
 type LDAPIdentityProviderListerExpansion interface{}
@@ -20,3 +36,11 @@ type OIDCIdentityProviderListerExpansion interface{}
 // OIDCIdentityProviderNamespaceListerExpansion allows custom methods to be added to
 // OIDCIdentityProviderNamespaceLister.
 type OIDCIdentityProviderNamespaceListerExpansion interface{}
+
+// SAMLIdentityProviderListerExpansion allows custom methods to be added to
+// SAMLIdentityProviderLister.
+type SAMLIdentityProviderListerExpansion interface{}
+
+// SAMLIdentityProviderNamespaceListerExpansion allows custom methods to be added to
+// SAMLIdentityProviderNamespaceLister.
+type SAMLIdentityProviderNamespaceListerExpansion interface{}
@@ -27,14 +27,48 @@ type JWTAuthenticatorSpec struct {
 	// +kubebuilder:validation:MinLength=1
 	Audience string `json:"audience"`
 
+	// AdditionalAudiences are other accepted values of the "aud" JWT claim. A JWT is accepted when its "aud" claim
+	// contains the Audience or any of the AdditionalAudiences, e.g. to accept the tokens of several OIDC clients.
+	// +optional
+	AdditionalAudiences []string `json:"additionalAudiences,omitempty"`
+
+	// JWKSURL is the URL of the JSON Web Key Set which contains the public signing keys of the issuer. It can be
+	// used for issuers whose discovery document is not reachable, e.g. because they are air-gapped or behind split
+	// DNS. When not specified, the keys are loaded from the "jwks_uri" of the discovery document at
+	// <issuer>/.well-known/openid-configuration.
+	// +kubebuilder:validation:Pattern=`^https://`
+	// +optional
+	JWKSURL string `json:"jwksURL,omitempty"`
+
+	// SigningAlgorithms are the accepted algorithms of the JWT signature. The supported algorithms are RS256, RS384,
+	// RS512, ES256, ES384, ES512, PS256, PS384 and PS512. When not specified, it will default to RS256 and ES256.
+	// +optional
+	SigningAlgorithms []string `json:"signingAlgorithms,omitempty"`
+
 	// Claims allows customization of the claims that will be mapped to user identity
 	// for Kubernetes access.
 	// +optional
 	Claims JWTTokenClaims `json:"claims"`
 
+	// RequiredClaims is a map of claim names to values. Each of these claims must be present in the JWT with
+	// exactly the given string value, or else the JWT will be rejected.
+	// +optional
+	RequiredClaims map[string]string `json:"requiredClaims,omitempty"`
+
+	// ClaimValidationRules are additional checks of the claims of the JWT. The JWT will be rejected unless it
+	// passes all of the rules.
+	// +optional
+	ClaimValidationRules []JWTClaimValidationRule `json:"claimValidationRules,omitempty"`
+
 	// TLS configuration for communicating with the OIDC provider.
 	// +optional
 	TLS *TLSSpec `json:"tls,omitempty"`
+
+	// ClientCertificateTTL is the lifetime of the client certificates which are issued by TokenCredentialRequests
+	// that were authenticated by this authenticator, e.g. "10m" or "1h". When not specified, it will default to 5
+	// minutes. It is limited by the spec.maxClientCertificateTTL of the CredentialIssuer, when that is specified.
+	// +optional
+	ClientCertificateTTL *metav1.Duration `json:"clientCertificateTTL,omitempty"`
 }
 
 // JWTTokenClaims allows customization of the claims that will be mapped to user identity
@@ -49,6 +83,61 @@ type JWTTokenClaims struct {
 	// username from the JWT token. When not specified, it will default to "username".
 	// +optional
 	Username string `json:"username"`
+
+	// UsernamePrefix is prepended to the username which was read from the JWT token, e.g. "oidc:". When not
+	// specified, the username is used as-is.
+	// +optional
+	UsernamePrefix string `json:"usernamePrefix,omitempty"`
+
+	// GroupsPrefix is prepended to each of the group names which were read from the JWT token, e.g. "oidc:". When
+	// not specified, the group names are used as-is.
+	// +optional
+	GroupsPrefix string `json:"groupsPrefix,omitempty"`
+
+	// Extra maps additional claims of the JWT token into the extra of the user identity. Note that the extra can
+	// only be honored by the impersonation proxy, since the Kubernetes API server does not read it from the client
+	// certificates which are issued by TokenCredentialRequests.
+	// +optional
+	Extra []JWTExtraMapping `json:"extra,omitempty"`
+}
+
+// JWTExtraMapping describes how a claim of the JWT token is mapped into the extra of the user identity.
+type JWTExtraMapping struct {
+	// Key is the key of the extra, e.g. "example.com/email-domain". It must be lowercase and prefixed by a
+	// domain name. Keys of the kubernetes.io and k8s.io domains and keys which end in
+	// ".impersonation-proxy.concierge.pinniped.dev" are reserved.
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:Pattern=`^[a-z0-9/\-._]+$`
+	Key string `json:"key"`
+
+	// Claim is the name of the claim which should be read. The claim must be a string or a list of strings.
+	// When the claim is not present in the JWT token, no extra is added for this key.
+	// +kubebuilder:validation:MinLength=1
+	Claim string `json:"claim"`
+
+	// Expression is an optional regular expression (using RE2 syntax) which transforms each value of the claim,
+	// e.g. "@(.+)$" to extract the domain of an email address. Values which do not match are skipped. When the
+	// expression has a capture group, the first capture group becomes the value, otherwise the whole match does.
+	// When not specified, the values of the claim are used as-is.
+	// +optional
+	Expression string `json:"expression,omitempty"`
+}
+
+// JWTClaimValidationRule describes a check of a claim of the JWT token.
+type JWTClaimValidationRule struct {
+	// Claim is the name of the claim which should be checked. The claim must be a string or a list of strings.
+	// When the claim is not present in the JWT token, the JWT will be rejected.
+	// +kubebuilder:validation:MinLength=1
+	Claim string `json:"claim"`
+
+	// Expression is a regular expression (using RE2 syntax) which each value of the claim must match, e.g.
+	// "@example\.com$" to only allow email addresses of one domain.
+	// +kubebuilder:validation:MinLength=1
+	Expression string `json:"expression"`
+
+	// Message is an optional explanation which is included in the error when the rule rejects a JWT.
+	// +optional
+	Message string `json:"message,omitempty"`
 }
 
 // JWTAuthenticator describes the configuration of a JWT authenticator.
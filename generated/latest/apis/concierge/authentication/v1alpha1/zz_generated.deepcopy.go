@@ -8,6 +8,7 @@
 package v1alpha1
 
 import (
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -92,12 +93,39 @@ func (in *JWTAuthenticatorList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *JWTAuthenticatorSpec) DeepCopyInto(out *JWTAuthenticatorSpec) {
 	*out = *in
-	out.Claims = in.Claims
+	if in.AdditionalAudiences != nil {
+		in, out := &in.AdditionalAudiences, &out.AdditionalAudiences
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.SigningAlgorithms != nil {
+		in, out := &in.SigningAlgorithms, &out.SigningAlgorithms
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.Claims.DeepCopyInto(&out.Claims)
+	if in.RequiredClaims != nil {
+		in, out := &in.RequiredClaims, &out.RequiredClaims
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ClaimValidationRules != nil {
+		in, out := &in.ClaimValidationRules, &out.ClaimValidationRules
+		*out = make([]JWTClaimValidationRule, len(*in))
+		copy(*out, *in)
+	}
 	if in.TLS != nil {
 		in, out := &in.TLS, &out.TLS
 		*out = new(TLSSpec)
 		**out = **in
 	}
+	if in.ClientCertificateTTL != nil {
+		in, out := &in.ClientCertificateTTL, &out.ClientCertificateTTL
+		*out = new(v1.Duration)
+		**out = **in
+	}
 	return
 }
 
@@ -134,9 +162,46 @@ func (in *JWTAuthenticatorStatus) DeepCopy() *JWTAuthenticatorStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *JWTClaimValidationRule) DeepCopyInto(out *JWTClaimValidationRule) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new JWTClaimValidationRule.
+func (in *JWTClaimValidationRule) DeepCopy() *JWTClaimValidationRule {
+	if in == nil {
+		return nil
+	}
+	out := new(JWTClaimValidationRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *JWTExtraMapping) DeepCopyInto(out *JWTExtraMapping) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new JWTExtraMapping.
+func (in *JWTExtraMapping) DeepCopy() *JWTExtraMapping {
+	if in == nil {
+		return nil
+	}
+	out := new(JWTExtraMapping)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *JWTTokenClaims) DeepCopyInto(out *JWTTokenClaims) {
 	*out = *in
+	if in.Extra != nil {
+		in, out := &in.Extra, &out.Extra
+		*out = make([]JWTExtraMapping, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -235,6 +300,11 @@ func (in *WebhookAuthenticatorSpec) DeepCopyInto(out *WebhookAuthenticatorSpec)
 		*out = new(TLSSpec)
 		**out = **in
 	}
+	if in.ClientCertificateTTL != nil {
+		in, out := &in.ClientCertificateTTL, &out.ClientCertificateTTL
+		*out = new(v1.Duration)
+		**out = **in
+	}
 	return
 }
 
@@ -13,8 +13,11 @@ import (
 
 type IDPV1alpha1Interface interface {
 	RESTClient() rest.Interface
+	ActiveDirectoryIdentityProvidersGetter
+	GitHubIdentityProvidersGetter
 	LDAPIdentityProvidersGetter
 	OIDCIdentityProvidersGetter
+	SAMLIdentityProvidersGetter
 }
 
 // IDPV1alpha1Client is used to interact with features provided by the idp.supervisor.pinniped.dev group.
@@ -22,6 +25,14 @@ type IDPV1alpha1Client struct {
 	restClient rest.Interface
 }
 
+func (c *IDPV1alpha1Client) ActiveDirectoryIdentityProviders(namespace string) ActiveDirectoryIdentityProviderInterface {
+	return newActiveDirectoryIdentityProviders(c, namespace)
+}
+
+func (c *IDPV1alpha1Client) GitHubIdentityProviders(namespace string) GitHubIdentityProviderInterface {
+	return newGitHubIdentityProviders(c, namespace)
+}
+
 func (c *IDPV1alpha1Client) LDAPIdentityProviders(namespace string) LDAPIdentityProviderInterface {
 	return newLDAPIdentityProviders(c, namespace)
 }
@@ -30,6 +41,10 @@ func (c *IDPV1alpha1Client) OIDCIdentityProviders(namespace string) OIDCIdentity
 	return newOIDCIdentityProviders(c, namespace)
 }
 
+func (c *IDPV1alpha1Client) SAMLIdentityProviders(namespace string) SAMLIdentityProviderInterface {
+	return newSAMLIdentityProviders(c, namespace)
+}
+
 // NewForConfig creates a new IDPV1alpha1Client for the given config.
 func NewForConfig(c *rest.Config) (*IDPV1alpha1Client, error) {
 	config := *c
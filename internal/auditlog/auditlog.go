@@ -0,0 +1,328 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package auditlog writes a stream of authentication events, one JSON object per line, which is separate from the
+// operational logs of plog. The fields of Event are a stable schema which may be ingested by a SIEM.
+package auditlog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/plog"
+)
+
+// APIVersion identifies the schema of the events. It will change if a field of Event is ever removed or changed.
+const APIVersion = "audit.pinniped.dev/v1alpha1"
+
+// OutputStdout is the value of the audit output setting which writes the events to the standard output.
+const OutputStdout = "stdout"
+
+// ErrInvalidOutput is returned by ValidateOutput.
+const ErrInvalidOutput = constable.Error(`output must be empty, "stdout" or an absolute file path`)
+
+// EventType is the kind of authentication event.
+type EventType string
+
+const (
+	// EventTypeSupervisorAuthorize is emitted when the authorize endpoint of a FederationDomain has either finished a
+	// login (for LDAP and Active Directory upstreams) or redirected the user to log in with an OIDC upstream or with
+	// the login form.
+	EventTypeSupervisorAuthorize EventType = "SupervisorAuthorize"
+
+	// EventTypeSupervisorCallback is emitted when the callback endpoint of a FederationDomain has finished a login
+	// with an OIDC upstream.
+	EventTypeSupervisorCallback EventType = "SupervisorCallback"
+
+	// EventTypeSupervisorLogin is emitted when the login endpoint of a FederationDomain has finished a login with an
+	// LDAP or Active Directory upstream using the login form in a web browser.
+	EventTypeSupervisorLogin EventType = "SupervisorLogin"
+
+	// EventTypeSupervisorLDAPBind is emitted when the Supervisor has checked the username and password of a user
+	// with an LDAP or Active Directory upstream.
+	EventTypeSupervisorLDAPBind EventType = "SupervisorLDAPBind"
+
+	// EventTypeSupervisorTokenExchange is emitted for each request to the token endpoint of a FederationDomain
+	// which is not a refresh, e.g. for an authorization code or an RFC8693 token exchange.
+	EventTypeSupervisorTokenExchange EventType = "SupervisorTokenExchange"
+
+	// EventTypeSupervisorRefresh is emitted for each refresh_token grant at the token endpoint of a FederationDomain.
+	EventTypeSupervisorRefresh EventType = "SupervisorRefresh"
+
+	// EventTypeConciergeTokenCredentialRequest is emitted for each TokenCredentialRequest.
+	EventTypeConciergeTokenCredentialRequest EventType = "ConciergeTokenCredentialRequest"
+
+	// EventTypeConciergeWhoAmIRequest is emitted for each WhoAmIRequest.
+	EventTypeConciergeWhoAmIRequest EventType = "ConciergeWhoAmIRequest"
+)
+
+// Outcome is the result of an authentication event.
+type Outcome string
+
+const (
+	// OutcomeSuccess means that the request was allowed.
+	OutcomeSuccess Outcome = "success"
+
+	// OutcomeFailure means that the request was denied, e.g. because of a wrong password.
+	OutcomeFailure Outcome = "failure"
+
+	// OutcomeError means that the request could not be handled, e.g. because an upstream server was unavailable.
+	OutcomeError Outcome = "error"
+)
+
+// Event is a single authentication event. Fields which do not apply to the type of the event are omitted.
+type Event struct {
+	APIVersion string    `json:"apiVersion"`
+	Time       time.Time `json:"time"`
+	Type       EventType `json:"type"`
+	Outcome    Outcome   `json:"outcome"`
+
+	// Reason explains a failure or an error to a human.
+	Reason string `json:"reason,omitempty"`
+
+	// SourceIP is the address of the client which made the request, i.e. the remote address of its connection.
+	SourceIP string `json:"sourceIP,omitempty"`
+
+	// ForwardedFor is the address of the original client according to the X-Forwarded-For header, i.e. the rightmost
+	// address in the header which is not one of the configured trusted proxies. It is only recorded when the request
+	// was made by one of the configured trusted proxies.
+	ForwardedFor string `json:"forwardedFor,omitempty"`
+
+	// FederationDomain is the issuer of the FederationDomain which handled a Supervisor request.
+	FederationDomain string `json:"federationDomain,omitempty"`
+
+	// ClientID is the OAuth client which made a Supervisor request.
+	ClientID string `json:"clientID,omitempty"`
+
+	// GrantType is the grant_type param of a request to the token endpoint of a FederationDomain.
+	GrantType string `json:"grantType,omitempty"`
+
+	// UpstreamIdentityProvider is the identity provider with which the user logged in to the Supervisor.
+	UpstreamIdentityProvider *IdentityProvider `json:"upstreamIdentityProvider,omitempty"`
+
+	// Authenticator is the authenticator which was used by a TokenCredentialRequest.
+	Authenticator *Authenticator `json:"authenticator,omitempty"`
+
+	// User is the resolved identity of the user, when it is known.
+	User *User `json:"user,omitempty"`
+}
+
+// IdentityProvider identifies an upstream identity provider of the Supervisor.
+type IdentityProvider struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Authenticator identifies an authenticator of the Concierge.
+type Authenticator struct {
+	APIGroup string `json:"apiGroup,omitempty"`
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+}
+
+// User is the identity of a user. For the Supervisor, this is the downstream identity after identity transforms.
+type User struct {
+	Username string   `json:"username"`
+	Groups   []string `json:"groups"`
+}
+
+//nolint: gochecknoglobals // the audit log is process wide, just like the plog logger
+var (
+	lock           sync.Mutex
+	output         io.Writer
+	trustedProxies []*net.IPNet
+	now            = time.Now
+)
+
+// ValidateOutput checks the audit output setting, which may be empty to disable the audit log, "stdout", or an
+// absolute file path.
+func ValidateOutput(out string) error {
+	if out == "" || out == OutputStdout || filepath.IsAbs(out) {
+		return nil
+	}
+	return ErrInvalidOutput
+}
+
+// ValidateTrustedProxies checks the trusted proxies setting, which is a list of CIDRs.
+func ValidateTrustedProxies(cidrs []string) error {
+	_, err := parseTrustedProxies(cidrs)
+	return err
+}
+
+// SetTrustedProxies sets the CIDRs of the proxies whose X-Forwarded-For headers are recorded as ForwardedFor.
+// By default, no proxies are trusted and X-Forwarded-For headers are ignored.
+func SetTrustedProxies(cidrs []string) error {
+	parsed, err := parseTrustedProxies(cidrs)
+	if err != nil {
+		return err
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	trustedProxies = parsed
+	return nil
+}
+
+func parseTrustedProxies(cidrs []string) ([]*net.IPNet, error) {
+	parsed := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+		}
+		parsed = append(parsed, ipNet)
+	}
+	return parsed, nil
+}
+
+// Open starts writing events to the given audit output setting, which must be valid according to ValidateOutput.
+// Files are created when needed and appended to. The returned function stops writing events and closes the file.
+func Open(out string) (func(), error) {
+	switch out {
+	case "":
+		return func() {}, nil
+	case OutputStdout:
+		SetOutput(os.Stdout)
+		return func() { SetOutput(nil) }, nil
+	}
+
+	f, err := os.OpenFile(filepath.Clean(out), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open audit log file: %w", err)
+	}
+	SetOutput(f)
+	return func() {
+		SetOutput(nil)
+		_ = f.Close()
+	}, nil
+}
+
+// SetOutput sets the writer to which events are written. A nil writer disables the audit log, which is the default.
+func SetOutput(w io.Writer) {
+	lock.Lock()
+	defer lock.Unlock()
+	output = w
+}
+
+// Record writes the event as a single line of JSON, after setting its APIVersion and Time.
+func Record(event Event) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if output == nil {
+		return
+	}
+
+	event.APIVersion = APIVersion
+	event.Time = now().UTC()
+	if event.User != nil && event.User.Groups == nil {
+		event.User.Groups = []string{}
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		plog.Error("could not encode audit event", err, "type", event.Type)
+		return
+	}
+	if _, err := output.Write(append(data, '\n')); err != nil {
+		plog.Error("could not write audit event", err, "type", event.Type)
+	}
+}
+
+// SourceIP returns the address of the client which made the request, i.e. the remote address of its connection.
+// Headers such as X-Forwarded-For are ignored, since any client could set them.
+func SourceIP(r *http.Request) string {
+	if ip := remoteIP(r); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// ForwardedFor returns the address of the original client from the X-Forwarded-For header of the request, but only
+// when the request was made by a trusted proxy. Otherwise, it returns an empty string.
+//
+// Each proxy appends the address of its own client to the header, so any client may put arbitrary addresses on the
+// left of the header. The header is therefore read from right to left, and the first address which is not a trusted
+// proxy is returned. This also finds the client of a request which went through several trusted proxies, e.g. the
+// Kubernetes API server aggregation layer in front of the Concierge.
+func ForwardedFor(r *http.Request) string {
+	ip := remoteIP(r)
+	if ip == nil || !isTrustedProxy(ip) {
+		return ""
+	}
+
+	var addresses []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		addresses = append(addresses, strings.Split(value, ",")...)
+	}
+
+	forwardedFor := ""
+	for i := len(addresses) - 1; i >= 0; i-- {
+		forwardedIP := net.ParseIP(strings.TrimSpace(addresses[i]))
+		if forwardedIP == nil {
+			// Anything further to the left was not written by a trusted proxy.
+			break
+		}
+		forwardedFor = forwardedIP.String()
+		if !isTrustedProxy(forwardedIP) {
+			break
+		}
+	}
+	return forwardedFor
+}
+
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	lock.Lock()
+	defer lock.Unlock()
+	for _, cidr := range trustedProxies {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type requestSourceKey struct{}
+
+type requestSource struct {
+	sourceIP     string
+	forwardedFor string
+}
+
+// WithSourceIP remembers the source IP and the forwarded for address of each request in its context, for the
+// handlers which do not have access to the request, such as the REST storage of an aggregated API server.
+func WithSourceIP(delegate http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		source := requestSource{sourceIP: SourceIP(r), forwardedFor: ForwardedFor(r)}
+		delegate.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestSourceKey{}, source)))
+	})
+}
+
+// SourceIPFrom returns the source IP which was remembered by WithSourceIP, or an empty string.
+func SourceIPFrom(ctx context.Context) string {
+	source, _ := ctx.Value(requestSourceKey{}).(requestSource)
+	return source.sourceIP
+}
+
+// ForwardedForFrom returns the forwarded for address which was remembered by WithSourceIP, or an empty string.
+func ForwardedForFrom(ctx context.Context) string {
+	source, _ := ctx.Value(requestSourceKey{}).(requestSource)
+	return source.forwardedFor
+}
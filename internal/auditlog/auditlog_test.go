@@ -0,0 +1,193 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package auditlog
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go.pinniped.dev/internal/here"
+)
+
+func TestValidateOutput(t *testing.T) {
+	tests := []struct {
+		output  string
+		wantErr error
+	}{
+		{output: ""},
+		{output: "stdout"},
+		{output: "/var/log/pinniped/audit.log"},
+		{output: "stderr", wantErr: ErrInvalidOutput},
+		{output: "audit.log", wantErr: ErrInvalidOutput},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.output, func(t *testing.T) {
+			require.Equal(t, tt.wantErr, ValidateOutput(tt.output))
+		})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	fakeNow := time.Date(2021, 10, 1, 12, 0, 0, 0, time.FixedZone("some-zone", 3600))
+	now = func() time.Time { return fakeNow }
+	t.Cleanup(func() { now = time.Now })
+
+	// Nothing is written before an output is set.
+	Record(Event{Type: EventTypeSupervisorAuthorize, Outcome: OutcomeSuccess})
+
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	t.Cleanup(func() { SetOutput(nil) })
+
+	Record(Event{
+		Type:                     EventTypeSupervisorLDAPBind,
+		Outcome:                  OutcomeFailure,
+		Reason:                   "some reason",
+		SourceIP:                 "1.2.3.4",
+		ForwardedFor:             "5.6.7.8",
+		FederationDomain:         "https://issuer.example.com",
+		ClientID:                 "pinniped-cli",
+		UpstreamIdentityProvider: &IdentityProvider{Name: "some-ldap", Type: "ldap"},
+		User:                     &User{Username: "pinny"},
+	})
+	Record(Event{
+		Type:          EventTypeConciergeTokenCredentialRequest,
+		Outcome:       OutcomeSuccess,
+		Authenticator: &Authenticator{APIGroup: "authentication.concierge.pinniped.dev", Kind: "JWTAuthenticator", Name: "some-jwt"},
+		User:          &User{Username: "pinny", Groups: []string{"a", "b"}},
+	})
+
+	require.Equal(t, here.Doc(`
+		{"apiVersion":"audit.pinniped.dev/v1alpha1","time":"2021-10-01T11:00:00Z","type":"SupervisorLDAPBind","outcome":"failure","reason":"some reason","sourceIP":"1.2.3.4","forwardedFor":"5.6.7.8","federationDomain":"https://issuer.example.com","clientID":"pinniped-cli","upstreamIdentityProvider":{"name":"some-ldap","type":"ldap"},"user":{"username":"pinny","groups":[]}}
+		{"apiVersion":"audit.pinniped.dev/v1alpha1","time":"2021-10-01T11:00:00Z","type":"ConciergeTokenCredentialRequest","outcome":"success","authenticator":{"apiGroup":"authentication.concierge.pinniped.dev","kind":"JWTAuthenticator","name":"some-jwt"},"user":{"username":"pinny","groups":["a","b"]}}
+	`), buf.String())
+}
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	require.NoError(t, ioutil.WriteFile(path, []byte("existing line\n"), 0600))
+
+	closeFunc, err := Open(path)
+	require.NoError(t, err)
+	Record(Event{Type: EventTypeConciergeWhoAmIRequest, Outcome: OutcomeSuccess})
+	closeFunc()
+
+	// Events are appended to the file, and none are written after it is closed.
+	Record(Event{Type: EventTypeConciergeWhoAmIRequest, Outcome: OutcomeSuccess})
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Regexp(t, `^existing line\n{"apiVersion":"audit.pinniped.dev/v1alpha1",.*"type":"ConciergeWhoAmIRequest","outcome":"success"}\n$`, string(contents))
+
+	_, err = Open(filepath.Join(t.TempDir(), "does-not-exist", "audit.log"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "could not open audit log file: ")
+}
+
+func TestSourceIP(t *testing.T) {
+	t.Cleanup(func() { require.NoError(t, SetTrustedProxies(nil)) })
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "1.2.3.4:5678"
+	require.Equal(t, "1.2.3.4", SourceIP(r))
+	require.Empty(t, ForwardedFor(r))
+
+	// The header is ignored unless the request comes from a trusted proxy.
+	r.Header.Set("X-Forwarded-For", "5.6.7.8, 1.2.3.4")
+	require.Equal(t, "1.2.3.4", SourceIP(r))
+	require.Empty(t, ForwardedFor(r))
+
+	require.NoError(t, SetTrustedProxies([]string{"9.9.9.0/24"}))
+	require.Empty(t, ForwardedFor(r))
+
+	require.NoError(t, SetTrustedProxies([]string{"1.2.3.0/24"}))
+	require.Equal(t, "1.2.3.4", SourceIP(r))
+	require.Equal(t, "5.6.7.8", ForwardedFor(r))
+
+	var sourceIPFromContext, forwardedForFromContext string
+	WithSourceIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sourceIPFromContext = SourceIPFrom(r.Context())
+		forwardedForFromContext = ForwardedForFrom(r.Context())
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	require.Equal(t, "1.2.3.4", sourceIPFromContext)
+	require.Equal(t, "5.6.7.8", forwardedForFromContext)
+
+	require.Empty(t, SourceIPFrom(r.Context()))
+	require.Empty(t, ForwardedForFrom(r.Context()))
+}
+
+func TestForwardedFor(t *testing.T) {
+	t.Cleanup(func() { require.NoError(t, SetTrustedProxies(nil)) })
+	require.NoError(t, SetTrustedProxies([]string{"1.2.3.0/24"}))
+
+	tests := []struct {
+		name          string
+		forwardedFor  []string
+		wantForwarded string
+	}{
+		{
+			name: "no header",
+		},
+		{
+			name:          "one client",
+			forwardedFor:  []string{"5.6.7.8"},
+			wantForwarded: "5.6.7.8",
+		},
+		{
+			name:          "spoofed leftmost entry is ignored",
+			forwardedFor:  []string{"6.6.6.6, 5.6.7.8"},
+			wantForwarded: "5.6.7.8",
+		},
+		{
+			name:          "several trusted proxies",
+			forwardedFor:  []string{"6.6.6.6, 5.6.7.8, 1.2.3.9,1.2.3.10"},
+			wantForwarded: "5.6.7.8",
+		},
+		{
+			name:          "several headers",
+			forwardedFor:  []string{"6.6.6.6, 5.6.7.8", "1.2.3.9"},
+			wantForwarded: "5.6.7.8",
+		},
+		{
+			name:          "only trusted proxies",
+			forwardedFor:  []string{"1.2.3.9, 1.2.3.10"},
+			wantForwarded: "1.2.3.9",
+		},
+		{
+			name:         "invalid rightmost entry",
+			forwardedFor: []string{"5.6.7.8, not-an-ip"},
+		},
+		{
+			name:          "ipv6 client",
+			forwardedFor:  []string{"[::1], 2001:db8::1"},
+			wantForwarded: "2001:db8::1",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = "1.2.3.4:5678"
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			require.Equal(t, tt.wantForwarded, ForwardedFor(r))
+		})
+	}
+}
+
+func TestSetTrustedProxies(t *testing.T) {
+	t.Cleanup(func() { require.NoError(t, SetTrustedProxies(nil)) })
+
+	require.NoError(t, ValidateTrustedProxies([]string{"10.0.0.0/8", "fd00::/8"}))
+	require.EqualError(t, ValidateTrustedProxies([]string{"10.0.0.1"}), "invalid trusted proxies: invalid CIDR address: 10.0.0.1")
+	require.EqualError(t, SetTrustedProxies([]string{"10.0.0.1"}), "invalid trusted proxies: invalid CIDR address: 10.0.0.1")
+}
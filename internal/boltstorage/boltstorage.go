@@ -0,0 +1,279 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package boltstorage implements crud.Storage with an embedded BoltDB database, as an alternative to storing the
+// sessions of the Supervisor as Kubernetes Secrets.
+package boltstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/plog"
+)
+
+const (
+	// openTimeout is how long Open waits for the lock on the database file, which is held by any other process
+	// which has the same database open.
+	openTimeout = 10 * time.Second
+
+	errNoneFound = constable.Error("none found")
+)
+
+// DB is a BoltDB database which stores entries in one bucket per type of resource.
+type DB struct {
+	db    *bolt.DB
+	clock func() time.Time
+}
+
+// entry is the value which is stored for each signature.
+type entry struct {
+	Data                json.RawMessage   `json:"data"`
+	Labels              map[string]string `json:"labels,omitempty"`
+	ResourceVersion     string            `json:"resourceVersion"`
+	GarbageCollectAfter time.Time         `json:"garbageCollectAfter"`
+}
+
+// Open opens the database at the given path, creating it if needed.
+func Open(path string, clock func() time.Time) (*DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("could not open database %s: %w", path, err)
+	}
+	return &DB{db: db, clock: clock}, nil
+}
+
+// Close closes the database.
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// StorageFactory returns a crud.StorageFactory which stores the entries in this database.
+func (d *DB) StorageFactory() crud.StorageFactory {
+	return func(resource string, lifetime time.Duration) crud.Storage {
+		return &boltStorage{
+			db:       d,
+			resource: resource,
+			lifetime: lifetime,
+		}
+	}
+}
+
+// GarbageCollect deletes all entries which should be garbage collected at the given time, and returns the type of
+// resource of each deleted entry.
+func (d *DB) GarbageCollect(now time.Time) ([]string, error) {
+	var deleted []string
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			resource := string(name)
+
+			// Keys must not be deleted while iterating over the bucket, so find them first.
+			var expiredKeys [][]byte
+			if err := bucket.ForEach(func(key, value []byte) error {
+				var e entry
+				if err := json.Unmarshal(value, &e); err != nil {
+					plog.WarningErr("could not decode stored entry during garbage collection", err, "resource", resource)
+					return nil
+				}
+				if e.GarbageCollectAfter.Before(now) {
+					expiredKeys = append(expiredKeys, append([]byte(nil), key...))
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+
+			for _, key := range expiredKeys {
+				if err := bucket.Delete(key); err != nil {
+					return err
+				}
+				deleted = append(deleted, resource)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to garbage collect database: %w", err)
+	}
+	return deleted, nil
+}
+
+type boltStorage struct {
+	db       *DB
+	resource string
+	lifetime time.Duration
+}
+
+func (s *boltStorage) Create(_ context.Context, signature string, data crud.JSON, additionalLabels map[string]string) (string, error) {
+	e, err := s.toEntry(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s for signature %s: %w", s.resource, signature, err)
+	}
+	e.Labels = map[string]string{crud.SecretLabelKey: s.resource}
+	for labelName, labelValue := range additionalLabels {
+		e.Labels[labelName] = labelValue
+	}
+
+	err = s.db.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(s.resource))
+		if err != nil {
+			return err
+		}
+		if bucket.Get([]byte(signature)) != nil {
+			return errors.NewAlreadyExists(s.groupResource(), signature)
+		}
+		return s.put(bucket, signature, e)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s for signature %s: %w", s.resource, signature, err)
+	}
+	return e.ResourceVersion, nil
+}
+
+func (s *boltStorage) Get(_ context.Context, signature string, data crud.JSON) (string, error) {
+	var e *entry
+	err := s.db.db.View(func(tx *bolt.Tx) error {
+		var err error
+		e, err = s.get(tx.Bucket([]byte(s.resource)), signature)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s for signature %s: %w", s.resource, signature, err)
+	}
+	if err := json.Unmarshal(e.Data, data); err != nil {
+		return "", fmt.Errorf("failed to decode %s for signature %s: %w", s.resource, signature, err)
+	}
+	return e.ResourceVersion, nil
+}
+
+func (s *boltStorage) Update(_ context.Context, signature, resourceVersion string, data crud.JSON) (string, error) {
+	e, err := s.toEntry(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to update %s for signature %s at resource version %s: %w", s.resource, signature, resourceVersion, err)
+	}
+
+	err = s.db.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(s.resource))
+		existing, err := s.get(bucket, signature)
+		if err != nil {
+			return err
+		}
+		if resourceVersion != "" && resourceVersion != existing.ResourceVersion {
+			return errors.NewConflict(s.groupResource(), signature,
+				fmt.Errorf("the resource version %s does not match the stored resource version %s", resourceVersion, existing.ResourceVersion))
+		}
+		// Like the Secrets of the crud package, an update keeps the labels and the garbage collection time.
+		e.Labels = existing.Labels
+		e.GarbageCollectAfter = existing.GarbageCollectAfter
+		return s.put(bucket, signature, e)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to update %s for signature %s at resource version %s: %w", s.resource, signature, resourceVersion, err)
+	}
+	return e.ResourceVersion, nil
+}
+
+func (s *boltStorage) Delete(_ context.Context, signature string) error {
+	err := s.db.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(s.resource))
+		if bucket == nil || bucket.Get([]byte(signature)) == nil {
+			return errors.NewNotFound(s.groupResource(), signature)
+		}
+		return bucket.Delete([]byte(signature))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete %s for signature %s: %w", s.resource, signature, err)
+	}
+	return nil
+}
+
+func (s *boltStorage) DeleteByLabel(_ context.Context, labelName string, labelValue string) error {
+	err := s.db.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(s.resource))
+		if bucket == nil {
+			return errNoneFound
+		}
+
+		// Keys must not be deleted while iterating over the bucket, so find them first.
+		var matchingKeys [][]byte
+		if err := bucket.ForEach(func(key, value []byte) error {
+			var e entry
+			if err := json.Unmarshal(value, &e); err != nil {
+				return fmt.Errorf("failed to decode entry %s: %w", key, err)
+			}
+			if e.Labels[labelName] == labelValue {
+				matchingKeys = append(matchingKeys, append([]byte(nil), key...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if len(matchingKeys) == 0 {
+			return errNoneFound
+		}
+
+		for _, key := range matchingKeys {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf(`failed to delete entries for resource "%s" matching label "%s=%s": %w`, s.resource, labelName, labelValue, err)
+	}
+	return nil
+}
+
+func (s *boltStorage) groupResource() schema.GroupResource {
+	return schema.GroupResource{Resource: s.resource}
+}
+
+func (s *boltStorage) toEntry(data crud.JSON) (*entry, error) {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode data: %w", err)
+	}
+	return &entry{
+		Data:                buf,
+		GarbageCollectAfter: s.db.clock().Add(s.lifetime).UTC(),
+	}, nil
+}
+
+func (s *boltStorage) get(bucket *bolt.Bucket, signature string) (*entry, error) {
+	if bucket == nil {
+		return nil, errors.NewNotFound(s.groupResource(), signature)
+	}
+	value := bucket.Get([]byte(signature))
+	if value == nil {
+		return nil, errors.NewNotFound(s.groupResource(), signature)
+	}
+	var e entry
+	if err := json.Unmarshal(value, &e); err != nil {
+		return nil, fmt.Errorf("failed to decode entry: %w", err)
+	}
+	return &e, nil
+}
+
+// put stores the entry with a new resource version, which is set on the entry.
+func (s *boltStorage) put(bucket *bolt.Bucket, signature string, e *entry) error {
+	sequence, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	e.ResourceVersion = strconv.FormatUint(sequence, 10)
+	value, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(signature), value)
+}
@@ -0,0 +1,154 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package boltstorage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	"go.pinniped.dev/internal/crud"
+)
+
+type testJSON struct {
+	Data string
+}
+
+func openTestDB(t *testing.T, clock func() time.Time) *DB {
+	t.Helper()
+	db, err := Open(filepath.Join(t.TempDir(), "sessions.db"), clock)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, db.Close()) })
+	return db
+}
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	fakeNow := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	db := openTestDB(t, func() time.Time { return fakeNow })
+	storage := db.StorageFactory()("authcode", 10*time.Minute)
+
+	// Get, Update and Delete of a missing entry are not found.
+	var got testJSON
+	_, err := storage.Get(ctx, "sig1", &got)
+	require.True(t, errors.IsNotFound(err), "wanted not found but got %v", err)
+	require.EqualError(t, err, `failed to get authcode for signature sig1: authcode "sig1" not found`)
+	_, err = storage.Update(ctx, "sig1", "", &testJSON{Data: "new"})
+	require.True(t, errors.IsNotFound(err), "wanted not found but got %v", err)
+	err = storage.Delete(ctx, "sig1")
+	require.True(t, errors.IsNotFound(err), "wanted not found but got %v", err)
+	err = storage.DeleteByLabel(ctx, "label1", "value1")
+	require.EqualError(t, err, `failed to delete entries for resource "authcode" matching label "label1=value1": none found`)
+
+	// Create and Get an entry.
+	rv1, err := storage.Create(ctx, "sig1", &testJSON{Data: "snorlax"}, map[string]string{"label1": "value1"})
+	require.NoError(t, err)
+	require.NotEmpty(t, rv1)
+	rv, err := storage.Get(ctx, "sig1", &got)
+	require.NoError(t, err)
+	require.Equal(t, rv1, rv)
+	require.Equal(t, testJSON{Data: "snorlax"}, got)
+
+	// Creating the same entry again fails.
+	_, err = storage.Create(ctx, "sig1", &testJSON{Data: "pikachu"}, nil)
+	require.True(t, errors.IsAlreadyExists(err), "wanted already exists but got %v", err)
+
+	// Update the entry, which changes its resource version.
+	rv2, err := storage.Update(ctx, "sig1", rv1, &testJSON{Data: "pikachu"})
+	require.NoError(t, err)
+	require.NotEqual(t, rv1, rv2)
+	rv, err = storage.Get(ctx, "sig1", &got)
+	require.NoError(t, err)
+	require.Equal(t, rv2, rv)
+	require.Equal(t, testJSON{Data: "pikachu"}, got)
+
+	// Updating at the old resource version is a conflict.
+	_, err = storage.Update(ctx, "sig1", rv1, &testJSON{Data: "eevee"})
+	require.True(t, errors.IsConflict(err), "wanted conflict but got %v", err)
+
+	// Storage for other resources does not see the entry.
+	otherStorage := db.StorageFactory()("access-token", 10*time.Minute)
+	_, err = otherStorage.Get(ctx, "sig1", &got)
+	require.True(t, errors.IsNotFound(err), "wanted not found but got %v", err)
+
+	// Delete the entry.
+	require.NoError(t, storage.Delete(ctx, "sig1"))
+	_, err = storage.Get(ctx, "sig1", &got)
+	require.True(t, errors.IsNotFound(err), "wanted not found but got %v", err)
+}
+
+func TestDeleteByLabel(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t, time.Now)
+	storage := db.StorageFactory()("refresh-token", time.Hour)
+
+	_, err := storage.Create(ctx, "sig1", &testJSON{Data: "a"}, map[string]string{"request-id": "abc"})
+	require.NoError(t, err)
+	rv, err := storage.Create(ctx, "sig2", &testJSON{Data: "b"}, map[string]string{"request-id": "abc"})
+	require.NoError(t, err)
+	_, err = storage.Create(ctx, "sig3", &testJSON{Data: "c"}, map[string]string{"request-id": "def"})
+	require.NoError(t, err)
+
+	// Labels are kept by updates.
+	_, err = storage.Update(ctx, "sig2", rv, &testJSON{Data: "b2"})
+	require.NoError(t, err)
+
+	require.NoError(t, storage.DeleteByLabel(ctx, "request-id", "abc"))
+
+	var got testJSON
+	_, err = storage.Get(ctx, "sig1", &got)
+	require.True(t, errors.IsNotFound(err), "wanted not found but got %v", err)
+	_, err = storage.Get(ctx, "sig2", &got)
+	require.True(t, errors.IsNotFound(err), "wanted not found but got %v", err)
+	_, err = storage.Get(ctx, "sig3", &got)
+	require.NoError(t, err)
+	require.Equal(t, testJSON{Data: "c"}, got)
+
+	err = storage.DeleteByLabel(ctx, "request-id", "abc")
+	require.EqualError(t, err, `failed to delete entries for resource "refresh-token" matching label "request-id=abc": none found`)
+}
+
+func TestGarbageCollect(t *testing.T) {
+	ctx := context.Background()
+	fakeNow := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	clockNow := fakeNow
+	db := openTestDB(t, func() time.Time { return clockNow })
+	authcodeStorage := db.StorageFactory()("authcode", time.Minute)
+	pkceStorage := db.StorageFactory()("pkce", time.Minute)
+	refreshStorage := db.StorageFactory()("refresh-token", time.Hour)
+
+	for _, storage := range []crud.Storage{authcodeStorage, pkceStorage, refreshStorage} {
+		_, err := storage.Create(ctx, "sig", &testJSON{Data: "a"}, nil)
+		require.NoError(t, err)
+	}
+
+	deleted, err := db.GarbageCollect(fakeNow.Add(time.Minute))
+	require.NoError(t, err)
+	require.Empty(t, deleted)
+
+	deleted, err = db.GarbageCollect(fakeNow.Add(time.Minute + time.Second))
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"authcode", "pkce"}, deleted)
+
+	var got testJSON
+	_, err = authcodeStorage.Get(ctx, "sig", &got)
+	require.True(t, errors.IsNotFound(err), "wanted not found but got %v", err)
+	_, err = pkceStorage.Get(ctx, "sig", &got)
+	require.True(t, errors.IsNotFound(err), "wanted not found but got %v", err)
+	rv, err := refreshStorage.Get(ctx, "sig", &got)
+	require.NoError(t, err)
+
+	// Updating an entry later does not extend its lifetime.
+	clockNow = fakeNow.Add(30 * time.Minute)
+	_, err = refreshStorage.Update(ctx, "sig", rv, &testJSON{Data: "b"})
+	require.NoError(t, err)
+
+	deleted, err = db.GarbageCollect(fakeNow.Add(time.Hour + time.Second))
+	require.NoError(t, err)
+	require.Equal(t, []string{"refresh-token"}, deleted)
+}
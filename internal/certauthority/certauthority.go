@@ -18,6 +18,7 @@ import (
 	"io"
 	"math/big"
 	"net"
+	"net/url"
 	"time"
 
 	"go.pinniped.dev/internal/constable"
@@ -31,6 +32,11 @@ import (
 // here.
 const certBackdate = 10 * time.Second
 
+// extraURIHost is the host of the URI SAN in which IssueClientCert encodes the extra of the user of a client
+// certificate. The Kubernetes API server ignores URI SANs of client certificates, but the Concierge impersonation
+// proxy reads the extra from it.
+const extraURIHost = "extra.concierge.pinniped.dev"
+
 type env struct {
 	// secure random number generators for various steps (usually crypto/rand.Reader, but broken out here for tests).
 	serialRNG  io.Reader
@@ -170,21 +176,42 @@ func (c *CA) Pool() *x509.CertPool {
 }
 
 // IssueClientCert issues a new client certificate with username and groups included in the Kube-style
-// certificate subject for the given identity and duration.
-func (c *CA) IssueClientCert(username string, groups []string, ttl time.Duration) (*tls.Certificate, error) {
-	return c.issueCert(x509.ExtKeyUsageClientAuth, pkix.Name{CommonName: username, Organization: groups}, nil, nil, ttl)
+// certificate subject for the given identity and duration. The extra of the identity is optional, and when it
+// is not empty it is included in a URI SAN of the certificate (see ExtraFromCert).
+func (c *CA) IssueClientCert(username string, groups []string, extra map[string][]string, ttl time.Duration) (*tls.Certificate, error) {
+	var uris []*url.URL
+	if len(extra) > 0 {
+		uris = []*url.URL{{Scheme: "https", Host: extraURIHost, RawQuery: url.Values(extra).Encode()}}
+	}
+	return c.issueCert(x509.ExtKeyUsageClientAuth, pkix.Name{CommonName: username, Organization: groups}, nil, nil, uris, ttl)
+}
+
+// ExtraFromCert returns the extra of the identity which IssueClientCert included in the given client certificate,
+// or nil when it does not include one. The caller is responsible for verifying the certificate.
+func ExtraFromCert(cert *x509.Certificate) map[string][]string {
+	for _, uri := range cert.URIs {
+		if uri.Scheme != "https" || uri.Host != extraURIHost {
+			continue
+		}
+		extra, err := url.ParseQuery(uri.RawQuery)
+		if err != nil || len(extra) == 0 {
+			return nil
+		}
+		return extra
+	}
+	return nil
 }
 
 // IssueServerCert issues a new server certificate for the given identity and duration.
 // The dnsNames and ips are each optional, but at least one of them should be specified.
 func (c *CA) IssueServerCert(dnsNames []string, ips []net.IP, ttl time.Duration) (*tls.Certificate, error) {
-	return c.issueCert(x509.ExtKeyUsageServerAuth, pkix.Name{}, dnsNames, ips, ttl)
+	return c.issueCert(x509.ExtKeyUsageServerAuth, pkix.Name{}, dnsNames, ips, nil, ttl)
 }
 
 // Similar to IssueClientCert, but returning the new cert as a pair of PEM-formatted byte slices
 // for the certificate and private key.
-func (c *CA) IssueClientCertPEM(username string, groups []string, ttl time.Duration) ([]byte, []byte, error) {
-	return toPEM(c.IssueClientCert(username, groups, ttl))
+func (c *CA) IssueClientCertPEM(username string, groups []string, extra map[string][]string, ttl time.Duration) ([]byte, []byte, error) {
+	return toPEM(c.IssueClientCert(username, groups, extra, ttl))
 }
 
 // Similar to IssueServerCert, but returning the new cert as a pair of PEM-formatted byte slices
@@ -193,7 +220,7 @@ func (c *CA) IssueServerCertPEM(dnsNames []string, ips []net.IP, ttl time.Durati
 	return toPEM(c.IssueServerCert(dnsNames, ips, ttl))
 }
 
-func (c *CA) issueCert(extKeyUsage x509.ExtKeyUsage, subject pkix.Name, dnsNames []string, ips []net.IP, ttl time.Duration) (*tls.Certificate, error) {
+func (c *CA) issueCert(extKeyUsage x509.ExtKeyUsage, subject pkix.Name, dnsNames []string, ips []net.IP, uris []*url.URL, ttl time.Duration) (*tls.Certificate, error) {
 	// Choose a random 128 bit serial number.
 	serialNumber, err := randomSerial(c.env.serialRNG)
 	if err != nil {
@@ -228,6 +255,7 @@ func (c *CA) issueCert(extKeyUsage x509.ExtKeyUsage, subject pkix.Name, dnsNames
 		IsCA:                  false,
 		DNSNames:              dnsNames,
 		IPAddresses:           ips,
+		URIs:                  uris,
 	}
 	certBytes, err := x509.CreateCertificate(rand.Reader, &template, caCert, &privateKey.PublicKey, c.signer)
 	if err != nil {
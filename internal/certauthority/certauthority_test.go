@@ -7,6 +7,7 @@ import (
 	"crypto"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -320,7 +321,7 @@ func TestIssue(t *testing.T) {
 				require.NoError(t, err)
 				require.NotNil(t, got)
 			}
-			got, err = tt.ca.IssueClientCert("test-user", []string{"group1", "group2"}, 10*time.Minute)
+			got, err = tt.ca.IssueClientCert("test-user", []string{"group1", "group2"}, nil, 10*time.Minute)
 			if tt.wantErr != "" {
 				require.EqualError(t, err, tt.wantErr)
 				require.Nil(t, got)
@@ -371,27 +372,53 @@ func TestIssueMethods(t *testing.T) {
 		user := "test-username"
 		groups := []string{"group1", "group2"}
 
-		clientCert, err := ca.IssueClientCert(user, groups, ttl)
+		clientCert, err := ca.IssueClientCert(user, groups, nil, ttl)
 		require.NoError(t, err)
 		certPEM, keyPEM, err := ToPEM(clientCert)
 		require.NoError(t, err)
 		validateClientCert(t, ca.Bundle(), certPEM, keyPEM, user, groups, ttl)
 
-		certPEM, keyPEM, err = ca.IssueClientCertPEM(user, groups, ttl)
+		certPEM, keyPEM, err = ca.IssueClientCertPEM(user, groups, nil, ttl)
 		require.NoError(t, err)
 		validateClientCert(t, ca.Bundle(), certPEM, keyPEM, user, groups, ttl)
 
-		certPEM, keyPEM, err = ca.IssueClientCertPEM(user, nil, ttl)
+		certPEM, keyPEM, err = ca.IssueClientCertPEM(user, nil, nil, ttl)
 		require.NoError(t, err)
 		validateClientCert(t, ca.Bundle(), certPEM, keyPEM, user, nil, ttl)
 
-		certPEM, keyPEM, err = ca.IssueClientCertPEM(user, []string{}, ttl)
+		certPEM, keyPEM, err = ca.IssueClientCertPEM(user, []string{}, nil, ttl)
 		require.NoError(t, err)
 		validateClientCert(t, ca.Bundle(), certPEM, keyPEM, user, nil, ttl)
 
-		certPEM, keyPEM, err = ca.IssueClientCertPEM("", []string{}, ttl)
+		certPEM, keyPEM, err = ca.IssueClientCertPEM("", []string{}, nil, ttl)
 		require.NoError(t, err)
 		validateClientCert(t, ca.Bundle(), certPEM, keyPEM, "", nil, ttl)
+		require.Nil(t, ExtraFromCert(clientCert.Leaf))
+	})
+
+	t.Run("client certs with extra", func(t *testing.T) {
+		user := "test-username"
+		groups := []string{"group1", "group2"}
+		extra := map[string][]string{
+			"example.com/email-domain": {"example.com"},
+			"example.com/teams":        {"team one", "team&two"},
+		}
+
+		clientCert, err := ca.IssueClientCert(user, groups, extra, ttl)
+		require.NoError(t, err)
+		require.Equal(t, extra, ExtraFromCert(clientCert.Leaf))
+
+		certPEM, keyPEM, err := ca.IssueClientCertPEM(user, groups, extra, ttl)
+		require.NoError(t, err)
+		v := testutil.ValidateClientCertificate(t, string(ca.Bundle()), string(certPEM))
+		v.RequireMatchesPrivateKey(string(keyPEM))
+		v.RequireCommonName(user)
+		v.RequireOrganizations(groups)
+		block, _ := pem.Decode(certPEM)
+		require.NotNil(t, block)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		require.NoError(t, err)
+		require.Equal(t, extra, ExtraFromCert(cert))
 	})
 
 	t.Run("server certs", func(t *testing.T) {
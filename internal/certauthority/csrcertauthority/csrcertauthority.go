@@ -0,0 +1,227 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package csrcertauthority implements a ClientCertIssuer which gets client certificates signed by the cluster
+// through the certificates.k8s.io/v1 CertificateSigningRequest API.
+package csrcertauthority
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	certificatesv1client "k8s.io/client-go/kubernetes/typed/certificates/v1"
+
+	"go.pinniped.dev/internal/issuer"
+	"go.pinniped.dev/internal/plog"
+)
+
+const (
+	// SignerName is the signer of the CertificateSigningRequests, which issues client certificates that are
+	// accepted by the Kubernetes API server.
+	SignerName = certificatesv1.KubeAPIServerClientSignerName
+
+	// ApprovalReason is the reason of the approval condition which is added to each CertificateSigningRequest.
+	ApprovalReason = "PinnipedConciergeApproved"
+
+	csrNamePrefix = "pinniped-client-"
+
+	// minDuration is the shortest duration which the API server accepts in spec.expirationSeconds.
+	minDuration = 10 * time.Minute
+
+	// maxClockSkew is how much later than requested a certificate may expire, to allow for the clock of the signer.
+	maxClockSkew = time.Minute
+
+	// How long to wait for the signer to issue the certificate, and how often to check. The signer of the cluster
+	// usually issues the certificate within a second, and the client of the TokenCredentialRequest is waiting.
+	defaultTimeout      = 5 * time.Second
+	defaultPollInterval = 100 * time.Millisecond
+)
+
+// ca is a type capable of issuing certificates.
+type ca struct {
+	csrs         certificatesv1client.CertificateSigningRequestInterface
+	dynamicCSRs  dynamic.ResourceInterface
+	timeout      time.Duration
+	pollInterval time.Duration
+}
+
+// New creates a ClientCertIssuer which creates a CertificateSigningRequest for the kubernetes.io/kube-apiserver-client
+// signer for each certificate, approves it, and waits for the cluster to sign it. The CertificateSigningRequests are
+// created through dynamicCSRs, since spec.expirationSeconds is not part of the vendored certificates/v1 types.
+func New(csrs certificatesv1client.CertificateSigningRequestInterface, dynamicCSRs dynamic.ResourceInterface) issuer.ClientCertIssuer {
+	return &ca{
+		csrs:         csrs,
+		dynamicCSRs:  dynamicCSRs,
+		timeout:      defaultTimeout,
+		pollInterval: defaultPollInterval,
+	}
+}
+
+func (c *ca) Name() string {
+	return "kube-csr-signer"
+}
+
+// IssueClientCertPEM issues a new client certificate for the given identity, returning it as a pair of
+// PEM-formatted byte slices for the certificate and private key. The ttl is requested through spec.expirationSeconds,
+// but it is raised to the minimum of ten minutes which the API server accepts. Clusters older than Kubernetes 1.22
+// ignore spec.expirationSeconds, so certificates which expire later than requested are rejected. Identities with
+// extra are rejected, because the cluster's signer is not trusted to copy the extra into the certificate faithfully,
+// and dropping it would grant a certificate for a different identity than the one which was authenticated.
+func (c *ca) IssueClientCertPEM(username string, groups []string, extra map[string][]string, ttl time.Duration) ([]byte, []byte, error) {
+	if len(extra) > 0 {
+		return nil, nil, issuer.ErrExtraNotSupported
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	duration := ttl
+	if duration < minDuration {
+		duration = minDuration
+	}
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not generate private key: %w", err)
+	}
+
+	requestDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:   username,
+			Organization: groups,
+		},
+	}, privateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create certificate request: %w", err)
+	}
+
+	csr, err := c.create(ctx, &certificatesv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: csrNamePrefix},
+		Spec: certificatesv1.CertificateSigningRequestSpec{
+			Request:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: requestDER}),
+			SignerName: SignerName,
+			Usages: []certificatesv1.KeyUsage{
+				certificatesv1.UsageDigitalSignature,
+				certificatesv1.UsageClientAuth,
+			},
+		},
+	}, duration)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create CertificateSigningRequest: %w", err)
+	}
+
+	// The CertificateSigningRequest is only needed until its certificate has been issued.
+	defer func() {
+		if err := c.csrs.Delete(context.Background(), csr.Name, metav1.DeleteOptions{}); err != nil {
+			plog.WarningErr("could not delete CertificateSigningRequest", err, "name", csr.Name)
+		}
+	}()
+
+	csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+		Type:           certificatesv1.CertificateApproved,
+		Status:         corev1.ConditionTrue,
+		Reason:         ApprovalReason,
+		Message:        "This CertificateSigningRequest was approved by the Pinniped Concierge for a TokenCredentialRequest.",
+		LastUpdateTime: metav1.Now(),
+	})
+	if _, err := c.csrs.UpdateApproval(ctx, csr.Name, csr, metav1.UpdateOptions{}); err != nil {
+		return nil, nil, fmt.Errorf("could not approve CertificateSigningRequest %s: %w", csr.Name, err)
+	}
+
+	certPEM, err := c.waitForCertificate(ctx, csr.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := c.checkExpiration(certPEM, duration); err != nil {
+		return nil, nil, fmt.Errorf("certificate of CertificateSigningRequest %s was rejected: %w", csr.Name, err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not marshal private key: %w", err)
+	}
+	return certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// create creates the CertificateSigningRequest with spec.expirationSeconds set to the given duration.
+func (c *ca) create(ctx context.Context, csr *certificatesv1.CertificateSigningRequest, duration time.Duration) (*certificatesv1.CertificateSigningRequest, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(csr)
+	if err != nil {
+		return nil, err
+	}
+	obj := &unstructured.Unstructured{Object: content}
+	obj.SetAPIVersion(certificatesv1.SchemeGroupVersion.String())
+	obj.SetKind("CertificateSigningRequest")
+	if err := unstructured.SetNestedField(obj.Object, int64(duration/time.Second), "spec", "expirationSeconds"); err != nil {
+		return nil, err
+	}
+
+	created, err := c.dynamicCSRs.Create(ctx, obj, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	result := &certificatesv1.CertificateSigningRequest{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(created.UnstructuredContent(), result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// checkExpiration returns an error unless the certificate expires within the requested duration.
+func (c *ca) checkExpiration(certPEM []byte, duration time.Duration) error {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return fmt.Errorf("certificate is not PEM-encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("could not parse certificate: %w", err)
+	}
+	if latest := time.Now().Add(duration + maxClockSkew); cert.NotAfter.After(latest) {
+		return fmt.Errorf("certificate expires at %s, which is more than the requested %s from now", cert.NotAfter.UTC().Format(time.RFC3339), duration)
+	}
+	return nil
+}
+
+// waitForCertificate waits until the signer has issued the certificate of the named CertificateSigningRequest.
+func (c *ca) waitForCertificate(ctx context.Context, name string) ([]byte, error) {
+	var certPEM []byte
+	err := wait.PollImmediateUntil(c.pollInterval, func() (bool, error) {
+		csr, err := c.csrs.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("could not get CertificateSigningRequest %s: %w", name, err)
+		}
+		for _, condition := range csr.Status.Conditions {
+			if condition.Status == corev1.ConditionTrue &&
+				(condition.Type == certificatesv1.CertificateDenied || condition.Type == certificatesv1.CertificateFailed) {
+				return false, fmt.Errorf("CertificateSigningRequest %s was not signed: %s: %s", name, condition.Reason, condition.Message)
+			}
+		}
+		if len(csr.Status.Certificate) == 0 {
+			return false, nil
+		}
+		certPEM = csr.Status.Certificate
+		return true, nil
+	}, ctx.Done())
+	if err == wait.ErrWaitTimeout {
+		return nil, fmt.Errorf("timed out waiting for CertificateSigningRequest %s to be signed", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return certPEM, nil
+}
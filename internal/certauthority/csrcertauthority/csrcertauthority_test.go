@@ -0,0 +1,235 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package csrcertauthority
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	coretesting "k8s.io/client-go/testing"
+
+	"go.pinniped.dev/internal/certauthority"
+)
+
+func TestIssueClientCertPEM(t *testing.T) {
+	csrsGVR := schema.GroupVersionResource{Group: "certificates.k8s.io", Version: "v1", Resource: "certificatesigningrequests"}
+
+	// The expiration of rejected certificates depends on when the test runs.
+	expiresAtRegexp := regexp.MustCompile(`expires at \S+,`)
+
+	signer, err := certauthority.New("some-signer", 24*time.Hour)
+	require.NoError(t, err)
+	certExpiringAfter := func(t *testing.T, ttl time.Duration) []byte {
+		t.Helper()
+		certPEM, _, err := signer.IssueClientCertPEM("some-user", nil, nil, ttl)
+		require.NoError(t, err)
+		return certPEM
+	}
+
+	// createCSRs plays the role of the API server for the CertificateSigningRequests which are created through the
+	// dynamic client, by storing them for the typed client with a generated name.
+	createCSRs := func(t *testing.T, client *kubefake.Clientset, dynamicClient *dynamicfake.FakeDynamicClient, wantExpirationSeconds int64) {
+		dynamicClient.PrependReactor("create", "certificatesigningrequests", func(action coretesting.Action) (bool, runtime.Object, error) {
+			obj := action.(coretesting.CreateAction).GetObject().(*unstructured.Unstructured)
+			require.Equal(t, "certificates.k8s.io/v1", obj.GetAPIVersion())
+			require.Equal(t, "CertificateSigningRequest", obj.GetKind())
+			expirationSeconds, found, err := unstructured.NestedInt64(obj.Object, "spec", "expirationSeconds")
+			require.NoError(t, err)
+			require.True(t, found)
+			require.Equal(t, wantExpirationSeconds, expirationSeconds)
+
+			csr := &certificatesv1.CertificateSigningRequest{}
+			require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, csr))
+			csr.Name = csr.GenerateName + "abc12"
+			created, err := client.CertificatesV1().CertificateSigningRequests().Create(context.Background(), csr, metav1.CreateOptions{})
+			require.NoError(t, err)
+			content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(created)
+			require.NoError(t, err)
+			return true, &unstructured.Unstructured{Object: content}, nil
+		})
+	}
+
+	// signApprovedCSRs plays the role of the cluster's signer by setting the certificate on approved requests.
+	signApprovedCSRs := func(t *testing.T, client *kubefake.Clientset, status certificatesv1.CertificateSigningRequestStatus) {
+		client.PrependReactor("update", "certificatesigningrequests", func(action coretesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() != "approval" {
+				return false, nil, nil
+			}
+			csr := action.(coretesting.UpdateAction).GetObject().(*certificatesv1.CertificateSigningRequest).DeepCopy()
+
+			require.Equal(t, "kubernetes.io/kube-apiserver-client", csr.Spec.SignerName)
+			require.Equal(t, []certificatesv1.KeyUsage{"digital signature", "client auth"}, csr.Spec.Usages)
+			require.Len(t, csr.Status.Conditions, 1)
+			require.Equal(t, certificatesv1.CertificateApproved, csr.Status.Conditions[0].Type)
+			require.Equal(t, corev1.ConditionTrue, csr.Status.Conditions[0].Status)
+			require.Equal(t, "PinnipedConciergeApproved", csr.Status.Conditions[0].Reason)
+
+			block, _ := pem.Decode(csr.Spec.Request)
+			require.NotNil(t, block)
+			request, err := x509.ParseCertificateRequest(block.Bytes)
+			require.NoError(t, err)
+			require.Equal(t, "some-user", request.Subject.CommonName)
+			require.Equal(t, []string{"group-a", "group-b"}, request.Subject.Organization)
+
+			csr.Status.Conditions = append(csr.Status.Conditions, status.Conditions...)
+			csr.Status.Certificate = status.Certificate
+			require.NoError(t, client.Tracker().Update(csrsGVR, csr, ""))
+			return true, csr, nil
+		})
+	}
+
+	tenMinutesCert := certExpiringAfter(t, 10*time.Minute)
+	oneHourCert := certExpiringAfter(t, time.Hour)
+
+	tests := []struct {
+		name        string
+		ttl         time.Duration
+		extra       map[string][]string
+		setupClient func(t *testing.T, client *kubefake.Clientset, dynamicClient *dynamicfake.FakeDynamicClient)
+		wantCertPEM []byte
+		wantErr     string
+	}{
+		{
+			name: "the cluster signs the request for the minimum duration",
+			ttl:  5 * time.Minute,
+			setupClient: func(t *testing.T, client *kubefake.Clientset, dynamicClient *dynamicfake.FakeDynamicClient) {
+				createCSRs(t, client, dynamicClient, 600)
+				signApprovedCSRs(t, client, certificatesv1.CertificateSigningRequestStatus{Certificate: tenMinutesCert})
+			},
+			wantCertPEM: tenMinutesCert,
+		},
+		{
+			name: "the cluster signs the request for the ttl",
+			ttl:  time.Hour,
+			setupClient: func(t *testing.T, client *kubefake.Clientset, dynamicClient *dynamicfake.FakeDynamicClient) {
+				createCSRs(t, client, dynamicClient, 3600)
+				signApprovedCSRs(t, client, certificatesv1.CertificateSigningRequestStatus{Certificate: oneHourCert})
+			},
+			wantCertPEM: oneHourCert,
+		},
+		{
+			name: "the cluster ignores the requested duration",
+			ttl:  5 * time.Minute,
+			setupClient: func(t *testing.T, client *kubefake.Clientset, dynamicClient *dynamicfake.FakeDynamicClient) {
+				createCSRs(t, client, dynamicClient, 600)
+				signApprovedCSRs(t, client, certificatesv1.CertificateSigningRequestStatus{Certificate: oneHourCert})
+			},
+			wantErr: "certificate of CertificateSigningRequest pinniped-client-abc12 was rejected: certificate expires at " +
+				"some-time, which is more than the requested 10m0s from now",
+		},
+		{
+			name: "the cluster issues a certificate which is not PEM-encoded",
+			ttl:  5 * time.Minute,
+			setupClient: func(t *testing.T, client *kubefake.Clientset, dynamicClient *dynamicfake.FakeDynamicClient) {
+				createCSRs(t, client, dynamicClient, 600)
+				signApprovedCSRs(t, client, certificatesv1.CertificateSigningRequestStatus{Certificate: []byte("some-cert-pem")})
+			},
+			wantErr: "certificate of CertificateSigningRequest pinniped-client-abc12 was rejected: certificate is not PEM-encoded",
+		},
+		{
+			name:        "the identity has extra",
+			ttl:         5 * time.Minute,
+			extra:       map[string][]string{"example.com/some-key": {"some-value"}},
+			setupClient: func(t *testing.T, client *kubefake.Clientset, dynamicClient *dynamicfake.FakeDynamicClient) {},
+			wantErr:     "cannot issue certificates for identities with extra",
+		},
+		{
+			name: "creating the request is forbidden",
+			ttl:  5 * time.Minute,
+			setupClient: func(t *testing.T, client *kubefake.Clientset, dynamicClient *dynamicfake.FakeDynamicClient) {
+				dynamicClient.PrependReactor("create", "certificatesigningrequests", func(action coretesting.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("some create error")
+				})
+			},
+			wantErr: "could not create CertificateSigningRequest: some create error",
+		},
+		{
+			name: "approving the request is forbidden",
+			ttl:  5 * time.Minute,
+			setupClient: func(t *testing.T, client *kubefake.Clientset, dynamicClient *dynamicfake.FakeDynamicClient) {
+				createCSRs(t, client, dynamicClient, 600)
+				client.PrependReactor("update", "certificatesigningrequests", func(action coretesting.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("some approval error")
+				})
+			},
+			wantErr: "could not approve CertificateSigningRequest pinniped-client-abc12: some approval error",
+		},
+		{
+			name: "the cluster fails to sign the request",
+			ttl:  5 * time.Minute,
+			setupClient: func(t *testing.T, client *kubefake.Clientset, dynamicClient *dynamicfake.FakeDynamicClient) {
+				createCSRs(t, client, dynamicClient, 600)
+				signApprovedCSRs(t, client, certificatesv1.CertificateSigningRequestStatus{
+					Conditions: []certificatesv1.CertificateSigningRequestCondition{{
+						Type:    certificatesv1.CertificateFailed,
+						Status:  corev1.ConditionTrue,
+						Reason:  "SignerValidationFailure",
+						Message: "some signer error",
+					}},
+				})
+			},
+			wantErr: "CertificateSigningRequest pinniped-client-abc12 was not signed: SignerValidationFailure: some signer error",
+		},
+		{
+			name: "the cluster never signs the request",
+			ttl:  5 * time.Minute,
+			setupClient: func(t *testing.T, client *kubefake.Clientset, dynamicClient *dynamicfake.FakeDynamicClient) {
+				createCSRs(t, client, dynamicClient, 600)
+			},
+			wantErr: "timed out waiting for CertificateSigningRequest pinniped-client-abc12 to be signed",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client := kubefake.NewSimpleClientset()
+			dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+			tt.setupClient(t, client, dynamicClient)
+
+			subject := &ca{
+				csrs:         client.CertificatesV1().CertificateSigningRequests(),
+				dynamicCSRs:  dynamicClient.Resource(csrsGVR),
+				timeout:      500 * time.Millisecond,
+				pollInterval: 10 * time.Millisecond,
+			}
+			require.Equal(t, "kube-csr-signer", subject.Name())
+
+			certPEM, keyPEM, err := subject.IssueClientCertPEM("some-user", []string{"group-a", "group-b"}, tt.extra, tt.ttl)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				require.Equal(t, tt.wantErr, expiresAtRegexp.ReplaceAllString(err.Error(), "expires at some-time,"))
+				require.Nil(t, certPEM)
+				require.Nil(t, keyPEM)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tt.wantCertPEM, certPEM)
+
+				block, _ := pem.Decode(keyPEM)
+				require.NotNil(t, block)
+				require.Equal(t, "EC PRIVATE KEY", block.Type)
+				_, err = x509.ParseECPrivateKey(block.Bytes)
+				require.NoError(t, err)
+			}
+
+			// The request is always cleaned up.
+			list, err := client.CertificatesV1().CertificateSigningRequests().List(context.Background(), metav1.ListOptions{})
+			require.NoError(t, err)
+			require.Empty(t, list.Items)
+		})
+	}
+}
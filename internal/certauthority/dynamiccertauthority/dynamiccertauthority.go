@@ -16,24 +16,42 @@ import (
 
 // ca is a type capable of issuing certificates.
 type ca struct {
-	provider dynamiccertificates.CertKeyContentProvider
+	provider   dynamiccertificates.CertKeyContentProvider
+	allowExtra bool
 }
 
 // New creates a ClientCertIssuer, ready to issue certs whenever
 // the given CertKeyContentProvider has a keypair to provide.
+// The certs are meant for the Kubernetes API server, which does not
+// read the extra of the identity from them, so identities with extra
+// are rejected.
 func New(provider dynamiccertificates.CertKeyContentProvider) issuer.ClientCertIssuer {
 	return &ca{
 		provider: provider,
 	}
 }
 
+// NewWithExtra is like New, except that it includes the extra of the
+// identity in the certs. It must only be used for certs which are
+// consumed by the impersonation proxy, which reads the extra from them.
+func NewWithExtra(provider dynamiccertificates.CertKeyContentProvider) issuer.ClientCertIssuer {
+	return &ca{
+		provider:   provider,
+		allowExtra: true,
+	}
+}
+
 func (c *ca) Name() string {
 	return c.provider.Name()
 }
 
 // IssueClientCertPEM issues a new client certificate for the given identity and duration, returning it as a
 // pair of PEM-formatted byte slices for the certificate and private key.
-func (c *ca) IssueClientCertPEM(username string, groups []string, ttl time.Duration) ([]byte, []byte, error) {
+func (c *ca) IssueClientCertPEM(username string, groups []string, extra map[string][]string, ttl time.Duration) ([]byte, []byte, error) {
+	if len(extra) > 0 && !c.allowExtra {
+		return nil, nil, issuer.ErrExtraNotSupported
+	}
+
 	caCrtPEM, caKeyPEM := c.provider.CurrentCertKeyContent()
 	// in the future we could split dynamiccert.Private into two interfaces (Private and PrivateRead)
 	// and have this code take PrivateRead as input.  We would then add ourselves as a listener to
@@ -43,5 +61,5 @@ func (c *ca) IssueClientCertPEM(username string, groups []string, ttl time.Durat
 		return nil, nil, err
 	}
 
-	return ca.IssueClientCertPEM(username, groups, ttl)
+	return ca.IssueClientCertPEM(username, groups, extra, ttl)
 }
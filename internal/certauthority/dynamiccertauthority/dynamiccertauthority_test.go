@@ -4,11 +4,14 @@
 package dynamiccertauthority
 
 import (
+	"crypto/x509"
+	"encoding/pem"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 
+	"go.pinniped.dev/internal/certauthority"
 	"go.pinniped.dev/internal/dynamiccert"
 	"go.pinniped.dev/internal/issuer"
 	"go.pinniped.dev/internal/testutil"
@@ -125,5 +128,38 @@ func issuePEM(provider dynamiccert.Provider, ca issuer.ClientCertIssuer, caCrt,
 	}
 
 	// otherwise check to see if their is an issuing error
-	return ca.IssueClientCertPEM("some-username", []string{"some-group1", "some-group2"}, time.Hour*24)
+	return ca.IssueClientCertPEM("some-username", []string{"some-group1", "some-group2"}, nil, time.Hour*24)
+}
+
+func TestCAIssuePEMWithExtra(t *testing.T) {
+	t.Parallel()
+
+	caCrtPEM, caKeyPEM, err := testutil.CreateCertificate(time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	provider := dynamiccert.NewCA(t.Name())
+	require.NoError(t, provider.SetCertKeyContent(caCrtPEM, caKeyPEM))
+
+	extra := map[string][]string{"example.com/some-key": {"some-value"}}
+
+	t.Run("certs for the Kubernetes API server", func(t *testing.T) {
+		t.Parallel()
+
+		crtPEM, keyPEM, err := New(provider).IssueClientCertPEM("some-username", nil, extra, time.Hour)
+		require.EqualError(t, err, "cannot issue certificates for identities with extra")
+		require.Nil(t, crtPEM)
+		require.Nil(t, keyPEM)
+	})
+
+	t.Run("certs for the impersonation proxy", func(t *testing.T) {
+		t.Parallel()
+
+		crtPEM, _, err := NewWithExtra(provider).IssueClientCertPEM("some-username", nil, extra, time.Hour)
+		require.NoError(t, err)
+
+		block, _ := pem.Decode(crtPEM)
+		require.NotNil(t, block)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		require.NoError(t, err)
+		require.Equal(t, extra, certauthority.ExtraFromCert(cert))
+	})
 }
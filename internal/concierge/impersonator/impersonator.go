@@ -5,6 +5,7 @@ package impersonator
 
 import (
 	"context"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -46,11 +47,14 @@ import (
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/transport"
 
+	"go.pinniped.dev/internal/certauthority"
 	"go.pinniped.dev/internal/constable"
 	"go.pinniped.dev/internal/dynamiccert"
 	"go.pinniped.dev/internal/httputil/securityheader"
 	"go.pinniped.dev/internal/kubeclient"
+	"go.pinniped.dev/internal/metrics"
 	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/tracing"
 	"go.pinniped.dev/internal/valuelesscontext"
 )
 
@@ -196,6 +200,12 @@ func newInternal( //nolint:funlen // yeah, it's kind of long.
 			handler = securityheader.Wrap(handler)
 			handler = filterlatency.TrackStarted(handler, "securityheaders")
 
+			// Record the number and latency of all requests, including those which are rejected by the handler chain.
+			handler = metrics.InstrumentImpersonationProxy(handler)
+
+			// Trace all requests, including the round trips to the Kubernetes API which are made by the proxy.
+			handler = tracing.Handler("concierge impersonation proxy", handler)
+
 			return handler
 		}
 
@@ -214,9 +224,13 @@ func newInternal( //nolint:funlen // yeah, it's kind of long.
 		// then we will need to update the related assumption in tokenPassthroughRoundTripper
 
 		delegatingAuthenticator := serverConfig.Authentication.Authenticator
+		clientCA := recommendedOptions.Authentication.ClientCert.CAContentProvider
 		blockAnonymousAuthenticator := &comparableAuthenticator{
 			RequestFunc: func(req *http.Request) (*authenticator.Response, bool, error) {
 				resp, ok, err := delegatingAuthenticator.AuthenticateRequest(req)
+				if err == nil && ok {
+					resp = withExtraFromClientCert(req, resp, clientCA)
+				}
 
 				// anonymous auth is enabled so no further check is necessary
 				if anonymousAuthEnabled {
@@ -406,6 +420,45 @@ func isTokenCredReq(reqInfo *genericapirequest.RequestInfo) bool {
 	return true
 }
 
+// withExtraFromClientCert adds the extra which the Concierge included in the client certificate of the request (see
+// certauthority.ExtraFromCert) to the user of the response. The client certificate authenticator of the server does
+// not know about the extra, so this only trusts the extra when the certificate is signed by one of our client CAs
+// and was issued to the same user.
+func withExtraFromClientCert(req *http.Request, resp *authenticator.Response, clientCA dynamiccertificates.CAContentProvider) *authenticator.Response {
+	if clientCA == nil || req.TLS == nil || len(req.TLS.PeerCertificates) == 0 || resp.User == nil || len(resp.User.GetExtra()) != 0 {
+		return resp
+	}
+
+	cert := req.TLS.PeerCertificates[0]
+	extra := certauthority.ExtraFromCert(cert)
+	if len(extra) == 0 || cert.Subject.CommonName != resp.User.GetName() {
+		return resp
+	}
+
+	verifyOptions, ok := clientCA.VerifyOptions()
+	if !ok {
+		return resp
+	}
+	verifyOptions.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	verifyOptions.Intermediates = x509.NewCertPool()
+	for _, intermediate := range req.TLS.PeerCertificates[1:] {
+		verifyOptions.Intermediates.AddCert(intermediate)
+	}
+	if _, err := cert.Verify(verifyOptions); err != nil {
+		return resp
+	}
+
+	return &authenticator.Response{
+		Audiences: resp.Audiences,
+		User: &user.DefaultInfo{
+			Name:   resp.User.GetName(),
+			UID:    resp.User.GetUID(),
+			Groups: resp.User.GetGroups(),
+			Extra:  extra,
+		},
+	}
+}
+
 // No-op wrapping around RequestFunc to allow for comparisons.
 type comparableAuthenticator struct {
 	authenticator.RequestFunc
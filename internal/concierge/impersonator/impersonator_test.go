@@ -108,6 +108,27 @@ func TestImpersonator(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:                               "happy path with extra from the client cert",
+			clientCert:                         newClientCertWithExtra(t, ca, "test-username", []string{"test-group1"}, map[string][]string{"example.com/team": {"team1", "team2"}}),
+			kubeAPIServerClientBearerTokenFile: "required-to-be-set",
+			wantKubeAPIServerRequestHeaders: http.Header{
+				"Impersonate-User":                     {"test-username"},
+				"Impersonate-Group":                    {"test-group1", "system:authenticated"},
+				"Impersonate-Extra-Example.com%2fteam": {"team1", "team2"},
+				"Authorization":                        {"Bearer some-service-account-token"},
+				"User-Agent":                           {"test-agent"},
+				"Accept":                               {"application/vnd.kubernetes.protobuf,application/json"},
+				"Accept-Encoding":                      {"gzip"},
+				"X-Forwarded-For":                      {"127.0.0.1"},
+			},
+			wantAuthorizerAttributes: []authorizer.AttributesRecord{
+				{
+					User: &user.DefaultInfo{Name: "test-username", UID: "", Groups: []string{"test-group1", "system:authenticated"}, Extra: map[string][]string{"example.com/team": {"team1", "team2"}}},
+					Verb: "list", Namespace: "", APIGroup: "", APIVersion: "v1", Resource: "namespaces", Subresource: "", Name: "", ResourceRequest: true, Path: "/api/v1/namespaces",
+				},
+			},
+		},
 		{
 			name:                               "happy path with forbidden healthz",
 			clientCert:                         newClientCert(t, ca, "test-username", []string{"test-group1", "test-group2"}),
@@ -1921,7 +1942,12 @@ type clientCert struct {
 
 func newClientCert(t *testing.T, ca *certauthority.CA, username string, groups []string) *clientCert {
 	t.Helper()
-	certPEM, keyPEM, err := ca.IssueClientCertPEM(username, groups, time.Hour)
+	return newClientCertWithExtra(t, ca, username, groups, nil)
+}
+
+func newClientCertWithExtra(t *testing.T, ca *certauthority.CA, username string, groups []string, extra map[string][]string) *clientCert {
+	t.Helper()
+	certPEM, keyPEM, err := ca.IssueClientCertPEM(username, groups, extra, time.Hour)
 	require.NoError(t, err)
 	return &clientCert{
 		certPEM: certPEM,
@@ -8,15 +8,20 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"time"
 
 	"github.com/spf13/cobra"
+	certificatesv1 "k8s.io/api/certificates/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	genericapiserver "k8s.io/apiserver/pkg/server"
 	genericoptions "k8s.io/apiserver/pkg/server/options"
+	"k8s.io/client-go/dynamic"
 
+	"go.pinniped.dev/internal/auditlog"
+	"go.pinniped.dev/internal/certauthority/csrcertauthority"
 	"go.pinniped.dev/internal/certauthority/dynamiccertauthority"
 	"go.pinniped.dev/internal/concierge/apiserver"
 	conciergescheme "go.pinniped.dev/internal/concierge/scheme"
@@ -27,8 +32,11 @@ import (
 	"go.pinniped.dev/internal/dynamiccert"
 	"go.pinniped.dev/internal/here"
 	"go.pinniped.dev/internal/issuer"
+	"go.pinniped.dev/internal/kubeclient"
+	"go.pinniped.dev/internal/metrics"
 	"go.pinniped.dev/internal/plog"
 	"go.pinniped.dev/internal/registry/credentialrequest"
+	"go.pinniped.dev/internal/tracing"
 )
 
 // App is an object that represents the pinniped-concierge application.
@@ -106,6 +114,27 @@ func (a *App) runServer(ctx context.Context) error {
 		return fmt.Errorf("could not read pod metadata: %w", err)
 	}
 
+	// Export the metrics of the Concierge at the /metrics endpoint of the aggregated API server,
+	// which serves all metrics in the global registry.
+	metrics.Register()
+
+	// Write the audit events of the Concierge to the configured output, if any.
+	closeAuditLog, err := auditlog.Open(cfg.AuditConfig.Output)
+	if err != nil {
+		return err
+	}
+	defer closeAuditLog()
+	if err := auditlog.SetTrustedProxies(cfg.AuditConfig.TrustedProxies); err != nil {
+		return err
+	}
+
+	// Export the spans of the impersonation proxy to the configured OpenTelemetry collector, if any.
+	stopTracing, err := tracing.Configure(cfg.TracingConfig.OTLPEndpoint, "pinniped-concierge", cfg.TracingConfig.TrustedClients)
+	if err != nil {
+		return err
+	}
+	defer stopTracing()
+
 	// Initialize the cache of active authenticators.
 	authenticators := authncache.New()
 
@@ -120,6 +149,10 @@ func (a *App) runServer(ctx context.Context) error {
 	// cert issuer used to issue certs to Pinniped clients wishing to login.
 	dynamicSigningCertProvider := dynamiccert.NewCA("concierge-kube-signing-cert")
 
+	// This cert provider will be used to provide the signing key which is configured in the CredentialIssuer to the
+	// cert issuer used to issue certs to Pinniped clients wishing to login.
+	externalSigningCertProvider := dynamiccert.NewCA("concierge-external-signing-cert")
+
 	// This cert provider will be used to provide the impersonation proxy signing key to the
 	// cert issuer used to issue certs to Pinniped clients wishing to login.
 	impersonationProxySigningCertProvider := dynamiccert.NewCA("impersonation-proxy-signing-cert")
@@ -137,9 +170,11 @@ func (a *App) runServer(ctx context.Context) error {
 			NamesConfig:                      &cfg.NamesConfig,
 			Labels:                           cfg.Labels,
 			KubeCertAgentConfig:              &cfg.KubeCertAgentConfig,
+			KubeCSRConfig:                    &cfg.KubeCSRConfig,
 			DiscoveryURLOverride:             cfg.DiscoveryInfo.URL,
 			DynamicServingCertProvider:       dynamicServingCertProvider,
 			DynamicSigningCertProvider:       dynamicSigningCertProvider,
+			ExternalSigningCertProvider:      externalSigningCertProvider,
 			ImpersonationSigningCertProvider: impersonationProxySigningCertProvider,
 			ServingCertDuration:              time.Duration(*cfg.APIConfig.ServingCertificateConfig.DurationSeconds) * time.Second,
 			ServingCertRenewBefore:           time.Duration(*cfg.APIConfig.ServingCertificateConfig.RenewBeforeSeconds) * time.Second,
@@ -151,9 +186,29 @@ func (a *App) runServer(ctx context.Context) error {
 	}
 
 	certIssuer := issuer.ClientCertIssuers{
-		dynamiccertauthority.New(dynamicSigningCertProvider),            // attempt to use the real Kube CA if possible
-		dynamiccertauthority.New(impersonationProxySigningCertProvider), // fallback to our internal CA if we need to
+		dynamiccertauthority.New(dynamicSigningCertProvider),  // attempt to use the real Kube CA if possible
+		dynamiccertauthority.New(externalSigningCertProvider), // then a CA which was configured for the cluster
+	}
+	if cfg.KubeCSRConfig.Enabled {
+		// Next, ask the cluster to sign the cert through a CertificateSigningRequest if that is enabled.
+		client, err := kubeclient.New()
+		if err != nil {
+			return fmt.Errorf("could not create client for CertificateSigningRequests: %w", err)
+		}
+		dynamicClient, err := dynamic.NewForConfig(client.JSONConfig)
+		if err != nil {
+			return fmt.Errorf("could not create dynamic client for CertificateSigningRequests: %w", err)
+		}
+		certIssuer = append(certIssuer, csrcertauthority.New(
+			client.Kubernetes.CertificatesV1().CertificateSigningRequests(),
+			dynamicClient.Resource(certificatesv1.SchemeGroupVersion.WithResource("certificatesigningrequests")),
+		))
 	}
+	certIssuer = append(certIssuer,
+		// fallback to our internal CA if we need to, which is also the only one which can issue certs for identities with extra,
+		// since only the impersonation proxy reads the extra from certs
+		dynamiccertauthority.NewWithExtra(impersonationProxySigningCertProvider),
+	)
 
 	// Get the aggregated API server config.
 	aggregatedAPIServerConfig, err := getAggregatedAPIServerConfig(
@@ -217,6 +272,11 @@ func getAggregatedAPIServerConfig(
 		return nil, err
 	}
 
+	// Remember the source IP of each request for the audit log, since the REST storage does not see the requests.
+	serverConfig.BuildHandlerChainFunc = func(apiHandler http.Handler, c *genericapiserver.Config) http.Handler {
+		return auditlog.WithSourceIP(genericapiserver.DefaultBuildHandlerChain(apiHandler, c))
+	}
+
 	apiServerConfig := &apiserver.Config{
 		GenericConfig: serverConfig,
 		ExtraConfig: apiserver.ExtraConfig{
@@ -13,9 +13,11 @@ import (
 	"k8s.io/utils/pointer"
 	"sigs.k8s.io/yaml"
 
+	"go.pinniped.dev/internal/auditlog"
 	"go.pinniped.dev/internal/constable"
 	"go.pinniped.dev/internal/groupsuffix"
 	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/tracing"
 )
 
 const (
@@ -57,6 +59,14 @@ func FromPath(path string) (*Config, error) {
 		return nil, fmt.Errorf("validate names: %w", err)
 	}
 
+	if err := validateAudit(&config.AuditConfig); err != nil {
+		return nil, fmt.Errorf("validate audit: %w", err)
+	}
+
+	if err := validateTracing(&config.TracingConfig); err != nil {
+		return nil, fmt.Errorf("validate tracing: %w", err)
+	}
+
 	if err := plog.ValidateAndSetLogLevelGlobally(config.LogLevel); err != nil {
 		return nil, fmt.Errorf("validate log level: %w", err)
 	}
@@ -94,6 +104,20 @@ func maybeSetKubeCertAgentDefaults(cfg *KubeCertAgentSpec) {
 	}
 }
 
+func validateAudit(auditConfig *AuditConfigSpec) error {
+	if err := auditlog.ValidateOutput(auditConfig.Output); err != nil {
+		return err
+	}
+	return auditlog.ValidateTrustedProxies(auditConfig.TrustedProxies)
+}
+
+func validateTracing(tracingConfig *TracingConfigSpec) error {
+	if err := tracing.ValidateEndpoint(tracingConfig.OTLPEndpoint); err != nil {
+		return err
+	}
+	return tracing.ValidateTrustedClients(tracingConfig.TrustedClients)
+}
+
 func validateNames(names *NamesConfigSpec) error {
 	missingNames := []string{}
 	if names == nil {
@@ -54,6 +54,14 @@ func TestFromPath(t *testing.T) {
 				  image: kube-cert-agent-image
 				  imagePullSecrets: [kube-cert-agent-image-pull-secret]
 				logLevel: debug
+				audit:
+				  output: /var/log/pinniped/audit.log
+				  trustedProxies: [10.0.0.0/8]
+				tracing:
+				  otlpEndpoint: https://otel-collector.example.com
+				  trustedClients: [10.1.0.0/16]
+				kubeCSR:
+				  enabled: true
 			`),
 			wantConfig: &Config{
 				DiscoveryInfo: DiscoveryInfoSpec{
@@ -87,6 +95,17 @@ func TestFromPath(t *testing.T) {
 					ImagePullSecrets: []string{"kube-cert-agent-image-pull-secret"},
 				},
 				LogLevel: plog.LevelDebug,
+				AuditConfig: AuditConfigSpec{
+					Output:         "/var/log/pinniped/audit.log",
+					TrustedProxies: []string{"10.0.0.0/8"},
+				},
+				TracingConfig: TracingConfigSpec{
+					OTLPEndpoint:   "https://otel-collector.example.com",
+					TrustedClients: []string{"10.1.0.0/16"},
+				},
+				KubeCSRConfig: KubeCSRSpec{
+					Enabled: true,
+				},
 			},
 		},
 		{
@@ -323,6 +342,83 @@ func TestFromPath(t *testing.T) {
 			`),
 			wantError: "validate api: renewBefore must be positive",
 		},
+		{
+			name: "InvalidAuditOutput",
+			yaml: here.Doc(`
+				---
+				names:
+				  servingCertificateSecret: pinniped-concierge-api-tls-serving-certificate
+				  credentialIssuer: pinniped-config
+				  apiService: pinniped-api
+				  impersonationLoadBalancerService: impersonationLoadBalancerService-value
+				  impersonationClusterIPService: impersonationClusterIPService-value
+				  impersonationTLSCertificateSecret: impersonationTLSCertificateSecret-value
+				  impersonationCACertificateSecret: impersonationCACertificateSecret-value
+				  impersonationSignerSecret: impersonationSignerSecret-value
+				  agentServiceAccount: agentServiceAccount-value
+				audit:
+				  output: relative/audit.log
+			`),
+			wantError: `validate audit: output must be empty, "stdout" or an absolute file path`,
+		},
+		{
+			name: "InvalidAuditTrustedProxies",
+			yaml: here.Doc(`
+				---
+				names:
+				  servingCertificateSecret: pinniped-concierge-api-tls-serving-certificate
+				  credentialIssuer: pinniped-config
+				  apiService: pinniped-api
+				  impersonationLoadBalancerService: impersonationLoadBalancerService-value
+				  impersonationClusterIPService: impersonationClusterIPService-value
+				  impersonationTLSCertificateSecret: impersonationTLSCertificateSecret-value
+				  impersonationCACertificateSecret: impersonationCACertificateSecret-value
+				  impersonationSignerSecret: impersonationSignerSecret-value
+				  agentServiceAccount: agentServiceAccount-value
+				audit:
+				  trustedProxies: [10.0.0.1]
+			`),
+			wantError: "validate audit: invalid trusted proxies: invalid CIDR address: 10.0.0.1",
+		},
+		{
+			name: "InvalidTracingEndpoint",
+			yaml: here.Doc(`
+				---
+				names:
+				  servingCertificateSecret: pinniped-concierge-api-tls-serving-certificate
+				  credentialIssuer: pinniped-config
+				  apiService: pinniped-api
+				  impersonationLoadBalancerService: impersonationLoadBalancerService-value
+				  impersonationClusterIPService: impersonationClusterIPService-value
+				  impersonationTLSCertificateSecret: impersonationTLSCertificateSecret-value
+				  impersonationCACertificateSecret: impersonationCACertificateSecret-value
+				  impersonationSignerSecret: impersonationSignerSecret-value
+				  agentServiceAccount: agentServiceAccount-value
+				tracing:
+				  otlpEndpoint: grpc://otel-collector:4317
+			`),
+			wantError: "validate tracing: OTLP endpoint must be empty or an http or https URL without a query or fragment",
+		},
+		{
+			name: "InvalidTracingTrustedClients",
+			yaml: here.Doc(`
+				---
+				names:
+				  servingCertificateSecret: pinniped-concierge-api-tls-serving-certificate
+				  credentialIssuer: pinniped-config
+				  apiService: pinniped-api
+				  impersonationLoadBalancerService: impersonationLoadBalancerService-value
+				  impersonationClusterIPService: impersonationClusterIPService-value
+				  impersonationTLSCertificateSecret: impersonationTLSCertificateSecret-value
+				  impersonationCACertificateSecret: impersonationCACertificateSecret-value
+				  impersonationSignerSecret: impersonationSignerSecret-value
+				  agentServiceAccount: agentServiceAccount-value
+				tracing:
+				  otlpEndpoint: https://otel-collector.example.com
+				  trustedClients: [10.1.0.1]
+			`),
+			wantError: "validate tracing: invalid trusted clients: invalid CIDR address: 10.1.0.1",
+		},
 		{
 			name: "ZeroRenewBefore",
 			yaml: here.Doc(`
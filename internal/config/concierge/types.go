@@ -14,6 +14,9 @@ type Config struct {
 	KubeCertAgentConfig KubeCertAgentSpec `json:"kubeCertAgent"`
 	Labels              map[string]string `json:"labels"`
 	LogLevel            plog.LogLevel     `json:"logLevel"`
+	AuditConfig         AuditConfigSpec   `json:"audit"`
+	TracingConfig       TracingConfigSpec `json:"tracing"`
+	KubeCSRConfig       KubeCSRSpec       `json:"kubeCSR"`
 }
 
 // DiscoveryInfoSpec contains configuration knobs specific to
@@ -77,3 +80,37 @@ type KubeCertAgentSpec struct {
 	// ImagePullSecrets on the kube-cert-agent pods.
 	ImagePullSecrets []string
 }
+
+// AuditConfigSpec configures the audit log, which is a stream of authentication events with one JSON object per line.
+type AuditConfigSpec struct {
+	// Output is where the audit events are written. It may be "stdout", or the absolute path of a file to which the
+	// events are appended. By default, it is empty and no audit events are written.
+	Output string `json:"output,omitempty"`
+
+	// TrustedProxies are the CIDRs of the proxies in front of the Concierge. The X-Forwarded-For header of a request is
+	// only recorded in the forwardedFor field of its events when the request was made from one of these addresses.
+	// Requests for the aggregated APIs, such as TokenCredentialRequests, are made by the Kubernetes API server, so its
+	// addresses must be included for their original clients to be recorded. By default, no proxies are trusted.
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+}
+
+// TracingConfigSpec configures the export of OpenTelemetry traces.
+type TracingConfigSpec struct {
+	// OTLPEndpoint is the base URL of an OTLP/HTTP receiver, e.g. http://otel-collector.observability.svc:4318,
+	// to which spans are sent at the /v1/traces path. By default, it is empty and no spans are recorded.
+	OTLPEndpoint string `json:"otlpEndpoint,omitempty"`
+
+	// TrustedClients are the CIDRs of the clients whose W3C traceparent headers are used as the parents of the spans
+	// of their requests. The requests of other clients always start a new trace. By default, no clients are trusted.
+	TrustedClients []string `json:"trustedClients,omitempty"`
+}
+
+// KubeCSRSpec configures the KubeCertificateSigningRequest strategy, which gets the client certificates of
+// TokenCredentialRequests signed by the cluster through the certificates.k8s.io/v1 CertificateSigningRequest API.
+type KubeCSRSpec struct {
+	// Enabled turns on the strategy. The Concierge creates and approves a CertificateSigningRequest for the
+	// kubernetes.io/kube-apiserver-client signer for each TokenCredentialRequest which cannot be signed with the
+	// cluster's signing key. The lifetime of these certificates is decided by the cluster's signer. By default,
+	// the strategy is disabled.
+	Enabled bool `json:"enabled,omitempty"`
+}
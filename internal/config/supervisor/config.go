@@ -8,14 +8,29 @@ package supervisor
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
+	"path/filepath"
 	"strings"
 
 	"k8s.io/utils/pointer"
 	"sigs.k8s.io/yaml"
 
+	"go.pinniped.dev/internal/auditlog"
 	"go.pinniped.dev/internal/constable"
 	"go.pinniped.dev/internal/groupsuffix"
+	"go.pinniped.dev/internal/oidc/provider"
 	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/tracing"
+)
+
+const (
+	StorageTypeSecrets = "secrets"
+	StorageTypeBolt    = "bolt"
+
+	defaultMetricsAddress = ":9090"
+
+	aboutAMonth = 60 * 60 * 24 * 30
+	aDay        = 60 * 60 * 24
 )
 
 // FromPath loads an Config from a provided local file path, inserts any
@@ -37,6 +52,10 @@ func FromPath(path string) (*Config, error) {
 	}
 
 	maybeSetAPIGroupSuffixDefault(&config.APIGroupSuffix)
+	maybeSetJWKSDefaults(&config.JWKSConfig)
+	maybeSetSymmetricKeysDefaults(&config.SymmetricKeysConfig)
+	maybeSetMetricsDefaults(&config.MetricsConfig)
+	maybeSetStorageDefaults(&config.StorageConfig)
 
 	if err := validateAPIGroupSuffix(*config.APIGroupSuffix); err != nil {
 		return nil, fmt.Errorf("validate apiGroupSuffix: %w", err)
@@ -46,6 +65,30 @@ func FromPath(path string) (*Config, error) {
 		return nil, fmt.Errorf("validate names: %w", err)
 	}
 
+	if err := validateJWKS(&config.JWKSConfig); err != nil {
+		return nil, fmt.Errorf("validate jwks: %w", err)
+	}
+
+	if err := validateSymmetricKeys(&config.SymmetricKeysConfig); err != nil {
+		return nil, fmt.Errorf("validate symmetricKeys: %w", err)
+	}
+
+	if err := validateAudit(&config.AuditConfig); err != nil {
+		return nil, fmt.Errorf("validate audit: %w", err)
+	}
+
+	if err := validateTracing(&config.TracingConfig); err != nil {
+		return nil, fmt.Errorf("validate tracing: %w", err)
+	}
+
+	if err := validateMetrics(&config.MetricsConfig); err != nil {
+		return nil, fmt.Errorf("validate metrics: %w", err)
+	}
+
+	if err := validateStorage(&config.StorageConfig); err != nil {
+		return nil, fmt.Errorf("validate storage: %w", err)
+	}
+
 	if err := plog.ValidateAndSetLogLevelGlobally(config.LogLevel); err != nil {
 		return nil, fmt.Errorf("validate log level: %w", err)
 	}
@@ -59,6 +102,91 @@ func maybeSetAPIGroupSuffixDefault(apiGroupSuffix **string) {
 	}
 }
 
+func maybeSetJWKSDefaults(jwksConfig *JWKSConfigSpec) {
+	if jwksConfig.RotationIntervalSeconds == nil {
+		jwksConfig.RotationIntervalSeconds = pointer.Int64Ptr(aboutAMonth)
+	}
+	if jwksConfig.RetentionSeconds == nil {
+		jwksConfig.RetentionSeconds = pointer.Int64Ptr(aDay)
+	}
+}
+
+func validateJWKS(jwksConfig *JWKSConfigSpec) error {
+	if *jwksConfig.RotationIntervalSeconds <= 0 {
+		return constable.Error("rotationIntervalSeconds must be positive")
+	}
+	if *jwksConfig.RetentionSeconds <= 0 {
+		return constable.Error("retentionSeconds must be positive")
+	}
+	// Replaced signing keys must be published for as long as the ID tokens which they signed may still be valid.
+	if minRetentionSeconds := int64(provider.MaxAccessTokenLifetime.Seconds()); *jwksConfig.RetentionSeconds < minRetentionSeconds {
+		return fmt.Errorf("retentionSeconds must be at least %d, the maximum lifetime of ID tokens", minRetentionSeconds)
+	}
+	return nil
+}
+
+func maybeSetSymmetricKeysDefaults(symmetricKeysConfig *SymmetricKeysConfigSpec) {
+	if symmetricKeysConfig.RotationIntervalSeconds == nil {
+		symmetricKeysConfig.RotationIntervalSeconds = pointer.Int64Ptr(aboutAMonth)
+	}
+}
+
+func validateSymmetricKeys(symmetricKeysConfig *SymmetricKeysConfigSpec) error {
+	if *symmetricKeysConfig.RotationIntervalSeconds <= 0 {
+		return constable.Error("rotationIntervalSeconds must be positive")
+	}
+	return nil
+}
+
+func validateAudit(auditConfig *AuditConfigSpec) error {
+	if err := auditlog.ValidateOutput(auditConfig.Output); err != nil {
+		return err
+	}
+	return auditlog.ValidateTrustedProxies(auditConfig.TrustedProxies)
+}
+
+func validateTracing(tracingConfig *TracingConfigSpec) error {
+	if err := tracing.ValidateEndpoint(tracingConfig.OTLPEndpoint); err != nil {
+		return err
+	}
+	return tracing.ValidateTrustedClients(tracingConfig.TrustedClients)
+}
+
+func maybeSetMetricsDefaults(metricsConfig *MetricsConfigSpec) {
+	if metricsConfig.Address == "" {
+		metricsConfig.Address = defaultMetricsAddress
+	}
+}
+
+func validateMetrics(metricsConfig *MetricsConfigSpec) error {
+	if _, _, err := net.SplitHostPort(metricsConfig.Address); err != nil {
+		return fmt.Errorf("invalid address: %w", err)
+	}
+	return nil
+}
+
+func maybeSetStorageDefaults(storageConfig *StorageConfigSpec) {
+	if storageConfig.Type == "" {
+		storageConfig.Type = StorageTypeSecrets
+	}
+}
+
+func validateStorage(storageConfig *StorageConfigSpec) error {
+	switch storageConfig.Type {
+	case StorageTypeSecrets:
+		if storageConfig.Path != "" {
+			return constable.Error("path may only be specified when type is " + StorageTypeBolt)
+		}
+	case StorageTypeBolt:
+		if !filepath.IsAbs(storageConfig.Path) {
+			return constable.Error("path must be an absolute path when type is " + StorageTypeBolt)
+		}
+	default:
+		return fmt.Errorf("unknown type %q (must be %q or %q)", storageConfig.Type, StorageTypeSecrets, StorageTypeBolt)
+	}
+	return nil
+}
+
 func validateAPIGroupSuffix(apiGroupSuffix string) error {
 	return groupsuffix.Validate(apiGroupSuffix)
 }
@@ -32,6 +32,22 @@ func TestFromPath(t *testing.T) {
 				  myLabelKey2: myLabelValue2
 				names:
 				  defaultTLSCertificateSecret: my-secret-name
+				jwks:
+				  rotationIntervalSeconds: 3600
+				  retentionSeconds: 7200
+				symmetricKeys:
+				  rotationIntervalSeconds: 86400
+				audit:
+				  output: stdout
+				  trustedProxies: [10.0.0.0/8, fd00::/8]
+				tracing:
+				  otlpEndpoint: http://otel-collector.observability.svc:4318
+				  trustedClients: [10.1.0.0/16]
+				metrics:
+				  address: 127.0.0.1:9091
+				storage:
+				  type: bolt
+				  path: /var/lib/pinniped/sessions.db
 			`),
 			wantConfig: &Config{
 				APIGroupSuffix: pointer.StringPtr("some.suffix.com"),
@@ -42,6 +58,28 @@ func TestFromPath(t *testing.T) {
 				NamesConfig: NamesConfigSpec{
 					DefaultTLSCertificateSecret: "my-secret-name",
 				},
+				JWKSConfig: JWKSConfigSpec{
+					RotationIntervalSeconds: pointer.Int64Ptr(3600),
+					RetentionSeconds:        pointer.Int64Ptr(7200),
+				},
+				SymmetricKeysConfig: SymmetricKeysConfigSpec{
+					RotationIntervalSeconds: pointer.Int64Ptr(86400),
+				},
+				AuditConfig: AuditConfigSpec{
+					Output:         "stdout",
+					TrustedProxies: []string{"10.0.0.0/8", "fd00::/8"},
+				},
+				TracingConfig: TracingConfigSpec{
+					OTLPEndpoint:   "http://otel-collector.observability.svc:4318",
+					TrustedClients: []string{"10.1.0.0/16"},
+				},
+				MetricsConfig: MetricsConfigSpec{
+					Address: "127.0.0.1:9091",
+				},
+				StorageConfig: StorageConfigSpec{
+					Type: "bolt",
+					Path: "/var/lib/pinniped/sessions.db",
+				},
 			},
 		},
 		{
@@ -57,6 +95,19 @@ func TestFromPath(t *testing.T) {
 				NamesConfig: NamesConfigSpec{
 					DefaultTLSCertificateSecret: "my-secret-name",
 				},
+				JWKSConfig: JWKSConfigSpec{
+					RotationIntervalSeconds: pointer.Int64Ptr(2592000),
+					RetentionSeconds:        pointer.Int64Ptr(86400),
+				},
+				SymmetricKeysConfig: SymmetricKeysConfigSpec{
+					RotationIntervalSeconds: pointer.Int64Ptr(2592000),
+				},
+				MetricsConfig: MetricsConfigSpec{
+					Address: ":9090",
+				},
+				StorageConfig: StorageConfigSpec{
+					Type: "secrets",
+				},
 			},
 		},
 		{
@@ -76,6 +127,171 @@ func TestFromPath(t *testing.T) {
 			`),
 			wantError: "validate apiGroupSuffix: a lowercase RFC 1123 subdomain must consist of lower case alphanumeric characters, '-' or '.', and must start and end with an alphanumeric character (e.g. 'example.com', regex used for validation is '[a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*')",
 		},
+		{
+			name: "jwks rotationIntervalSeconds is not positive",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				jwks:
+				  rotationIntervalSeconds: 0
+			`),
+			wantError: "validate jwks: rotationIntervalSeconds must be positive",
+		},
+		{
+			name: "jwks retentionSeconds is not positive",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				jwks:
+				  retentionSeconds: -1
+			`),
+			wantError: "validate jwks: retentionSeconds must be positive",
+		},
+		{
+			name: "jwks retentionSeconds is shorter than the maximum lifetime of ID tokens",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				jwks:
+				  retentionSeconds: 3599
+			`),
+			wantError: "validate jwks: retentionSeconds must be at least 3600, the maximum lifetime of ID tokens",
+		},
+		{
+			name: "symmetricKeys rotationIntervalSeconds is not positive",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				symmetricKeys:
+				  rotationIntervalSeconds: -1
+			`),
+			wantError: "validate symmetricKeys: rotationIntervalSeconds must be positive",
+		},
+		{
+			name: "audit output is not stdout or an absolute path",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				audit:
+				  output: stderr
+			`),
+			wantError: `validate audit: output must be empty, "stdout" or an absolute file path`,
+		},
+		{
+			name: "audit trusted proxy is not a CIDR",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				audit:
+				  trustedProxies: [10.0.0.1]
+			`),
+			wantError: "validate audit: invalid trusted proxies: invalid CIDR address: 10.0.0.1",
+		},
+		{
+			name: "tracing endpoint is not an http URL",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				tracing:
+				  otlpEndpoint: otel-collector:4317
+			`),
+			wantError: "validate tracing: OTLP endpoint must be empty or an http or https URL without a query or fragment",
+		},
+		{
+			name: "invalid tracing trusted clients",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				tracing:
+				  otlpEndpoint: http://otel-collector.observability.svc:4318
+				  trustedClients: [10.1.0.1]
+			`),
+			wantError: "validate tracing: invalid trusted clients: invalid CIDR address: 10.1.0.1",
+		},
+		{
+			name: "metrics can be disabled",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				metrics:
+				  disabled: true
+			`),
+			wantConfig: &Config{
+				APIGroupSuffix: pointer.StringPtr("pinniped.dev"),
+				Labels:         map[string]string{},
+				NamesConfig: NamesConfigSpec{
+					DefaultTLSCertificateSecret: "my-secret-name",
+				},
+				JWKSConfig: JWKSConfigSpec{
+					RotationIntervalSeconds: pointer.Int64Ptr(2592000),
+					RetentionSeconds:        pointer.Int64Ptr(86400),
+				},
+				SymmetricKeysConfig: SymmetricKeysConfigSpec{
+					RotationIntervalSeconds: pointer.Int64Ptr(2592000),
+				},
+				MetricsConfig: MetricsConfigSpec{
+					Address:  ":9090",
+					Disabled: true,
+				},
+				StorageConfig: StorageConfigSpec{
+					Type: "secrets",
+				},
+			},
+		},
+		{
+			name: "metrics address has no port",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				metrics:
+				  address: 127.0.0.1
+			`),
+			wantError: "validate metrics: invalid address: address 127.0.0.1: missing port in address",
+		},
+		{
+			name: "storage type is unknown",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				storage:
+				  type: postgres
+			`),
+			wantError: `validate storage: unknown type "postgres" (must be "secrets" or "bolt")`,
+		},
+		{
+			name: "storage path is not an absolute path",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				storage:
+				  type: bolt
+				  path: sessions.db
+			`),
+			wantError: "validate storage: path must be an absolute path when type is bolt",
+		},
+		{
+			name: "storage path is specified for secrets",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				storage:
+				  path: /var/lib/pinniped/sessions.db
+			`),
+			wantError: "validate storage: path may only be specified when type is bolt",
+		},
 	}
 	for _, test := range tests {
 		test := test
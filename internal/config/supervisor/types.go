@@ -7,13 +7,90 @@ import "go.pinniped.dev/internal/plog"
 
 // Config contains knobs to setup an instance of the Pinniped Supervisor.
 type Config struct {
-	APIGroupSuffix *string           `json:"apiGroupSuffix,omitempty"`
-	Labels         map[string]string `json:"labels"`
-	NamesConfig    NamesConfigSpec   `json:"names"`
-	LogLevel       plog.LogLevel     `json:"logLevel"`
+	APIGroupSuffix      *string                 `json:"apiGroupSuffix,omitempty"`
+	Labels              map[string]string       `json:"labels"`
+	NamesConfig         NamesConfigSpec         `json:"names"`
+	LogLevel            plog.LogLevel           `json:"logLevel"`
+	JWKSConfig          JWKSConfigSpec          `json:"jwks"`
+	SymmetricKeysConfig SymmetricKeysConfigSpec `json:"symmetricKeys"`
+	AuditConfig         AuditConfigSpec         `json:"audit"`
+	TracingConfig       TracingConfigSpec       `json:"tracing"`
+	MetricsConfig       MetricsConfigSpec       `json:"metrics"`
+	StorageConfig       StorageConfigSpec       `json:"storage"`
 }
 
 // NamesConfigSpec configures the names of some Kubernetes resources for the Supervisor.
 type NamesConfigSpec struct {
 	DefaultTLSCertificateSecret string `json:"defaultTLSCertificateSecret"`
 }
+
+// JWKSConfigSpec configures the rotation of the keys which the Supervisor uses to sign the ID tokens of each
+// FederationDomain.
+type JWKSConfigSpec struct {
+	// RotationIntervalSeconds is the period of time, in seconds, for which each signing key is used before it is
+	// replaced by a newly generated signing key. By default, signing keys are rotated every 2592000 seconds (30 days).
+	RotationIntervalSeconds *int64 `json:"rotationIntervalSeconds,omitempty"`
+
+	// RetentionSeconds is the period of time, in seconds, for which a replaced signing key is still published in
+	// the FederationDomain's JWKS, so that the tokens which it signed can still be verified. This must be at least
+	// 3600 seconds (1 hour), which is the longest lifetime of ID tokens which a FederationDomain may configure. By
+	// default, replaced signing keys are published for 86400 seconds (1 day).
+	RetentionSeconds *int64 `json:"retentionSeconds,omitempty"`
+}
+
+// SymmetricKeysConfigSpec configures the rotation of the symmetric keys which the Supervisor uses to sign the
+// authorization codes, access tokens and refresh tokens of each FederationDomain, and to sign and encrypt the state
+// param which it sends to upstream identity providers.
+type SymmetricKeysConfigSpec struct {
+	// RotationIntervalSeconds is the period of time, in seconds, for which each symmetric key is used before it is
+	// replaced by a newly generated key. A few replaced keys are kept for verification and decryption, so this
+	// should be longer than the lifetime of the refresh tokens. By default, symmetric keys are rotated every
+	// 2592000 seconds (30 days).
+	RotationIntervalSeconds *int64 `json:"rotationIntervalSeconds,omitempty"`
+}
+
+// AuditConfigSpec configures the audit log, which is a stream of authentication events with one JSON object per line.
+type AuditConfigSpec struct {
+	// Output is where the audit events are written. It may be "stdout", or the absolute path of a file to which the
+	// events are appended. By default, it is empty and no audit events are written.
+	Output string `json:"output,omitempty"`
+
+	// TrustedProxies are the CIDRs of the proxies in front of the Supervisor. The X-Forwarded-For header of a request is
+	// only recorded in the forwardedFor field of its events when the request was made from one of these addresses.
+	// By default, no proxies are trusted.
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+}
+
+// TracingConfigSpec configures the export of OpenTelemetry traces.
+type TracingConfigSpec struct {
+	// OTLPEndpoint is the base URL of an OTLP/HTTP receiver, e.g. http://otel-collector.observability.svc:4318,
+	// to which spans are sent at the /v1/traces path. By default, it is empty and no spans are recorded.
+	OTLPEndpoint string `json:"otlpEndpoint,omitempty"`
+
+	// TrustedClients are the CIDRs of the clients whose W3C traceparent headers are used as the parents of the spans
+	// of their requests. The requests of other clients always start a new trace. By default, no clients are trusted.
+	TrustedClients []string `json:"trustedClients,omitempty"`
+}
+
+// MetricsConfigSpec configures the endpoint which serves the Prometheus metrics of the Supervisor.
+type MetricsConfigSpec struct {
+	// Address is the host:port on which the /metrics endpoint is served. It is never served by the Services of
+	// the Supervisor. By default, it is ":9090".
+	Address string `json:"address,omitempty"`
+
+	// Disabled turns off the /metrics endpoint, so that the Supervisor does not listen on the Address at all.
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// StorageConfigSpec configures where the Supervisor stores the sessions of its FederationDomains, i.e. the
+// authorization codes, access tokens, refresh tokens and related data.
+type StorageConfigSpec struct {
+	// Type is the type of the session storage. It may be "secrets", which stores each session as a Secret in the
+	// Supervisor's namespace, or "bolt", which stores the sessions in an embedded BoltDB database file. By default,
+	// it is "secrets".
+	Type string `json:"type,omitempty"`
+
+	// Path is the absolute path of the database file when the Type is "bolt". The database can only be opened by
+	// one Supervisor pod at a time, and the sessions are lost when the file is not on a persistent volume.
+	Path string `json:"path,omitempty"`
+}
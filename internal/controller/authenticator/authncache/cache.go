@@ -8,6 +8,7 @@ import (
 	"context"
 	"sort"
 	"sync"
+	"time"
 
 	"k8s.io/apiserver/pkg/authentication/authenticator"
 	"k8s.io/apiserver/pkg/authentication/user"
@@ -15,6 +16,7 @@ import (
 
 	loginapi "go.pinniped.dev/generated/latest/apis/concierge/login"
 	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/metrics"
 	"go.pinniped.dev/internal/plog"
 	"go.pinniped.dev/internal/valuelesscontext"
 )
@@ -22,10 +24,17 @@ import (
 // ErrNoSuchAuthenticator is returned by Cache.AuthenticateTokenCredentialRequest() when the requested authenticator is not configured.
 const ErrNoSuchAuthenticator = constable.Error("no such authenticator")
 
+// DefaultMaxClientCertificateTTL is the upper limit on the lifetime of client certificates when none was set by
+// SetMaxClientCertificateTTL().
+const DefaultMaxClientCertificateTTL = 24 * time.Hour
+
 // Cache implements the authenticator.Token interface by multiplexing across a dynamic set of authenticators
 // loaded from authenticator resources.
 type Cache struct {
 	cache sync.Map
+
+	lock                    sync.RWMutex
+	maxClientCertificateTTL time.Duration
 }
 
 type Key struct {
@@ -38,6 +47,12 @@ type Value interface {
 	authenticator.Token
 }
 
+// ClientCertificateTTLer may optionally be implemented by a Value to configure the lifetime of the client certificates
+// which are issued to the users that it authenticates. A non-positive TTL means that the Value does not configure one.
+type ClientCertificateTTLer interface {
+	ClientCertificateTTL() time.Duration
+}
+
 // New returns an empty cache.
 func New() *Cache {
 	return &Cache{}
@@ -79,15 +94,38 @@ func (c *Cache) Keys() []Key {
 	return result
 }
 
-func (c *Cache) AuthenticateTokenCredentialRequest(ctx context.Context, req *loginapi.TokenCredentialRequest) (user.Info, error) {
-	// Map the incoming request to a cache key.
-	key := Key{
-		Name: req.Spec.Authenticator.Name,
-		Kind: req.Spec.Authenticator.Kind,
+// SetMaxClientCertificateTTL sets the upper limit on the lifetime of the client certificates returned by
+// ClientCertificateTTL(). A non-positive TTL restores the DefaultMaxClientCertificateTTL.
+func (c *Cache) SetMaxClientCertificateTTL(ttl time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.maxClientCertificateTTL = ttl
+}
+
+// ClientCertificateTTL returns the lifetime of the client certificates which should be issued for the given request.
+// This is the TTL configured by the requested authenticator, or defaultTTL when it does not configure one, limited
+// by the TTL set by SetMaxClientCertificateTTL().
+func (c *Cache) ClientCertificateTTL(req *loginapi.TokenCredentialRequest, defaultTTL time.Duration) time.Duration {
+	ttl := defaultTTL
+	if ttler, ok := c.Get(keyForRequest(req)).(ClientCertificateTTLer); ok && ttler.ClientCertificateTTL() > 0 {
+		ttl = ttler.ClientCertificateTTL()
 	}
-	if req.Spec.Authenticator.APIGroup != nil {
-		key.APIGroup = *req.Spec.Authenticator.APIGroup
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	maxTTL := c.maxClientCertificateTTL
+	if maxTTL <= 0 {
+		maxTTL = DefaultMaxClientCertificateTTL
 	}
+	if ttl > maxTTL {
+		ttl = maxTTL
+	}
+	return ttl
+}
+
+func (c *Cache) AuthenticateTokenCredentialRequest(ctx context.Context, req *loginapi.TokenCredentialRequest) (user.Info, error) {
+	// Map the incoming request to a cache key.
+	key := keyForRequest(req)
 
 	val := c.Get(key)
 	if val == nil {
@@ -97,6 +135,7 @@ func (c *Cache) AuthenticateTokenCredentialRequest(ctx context.Context, req *log
 			"kind", key.Kind,
 			"apiGroup", key.APIGroup,
 		)
+		metrics.RecordTokenCredentialRequest(metrics.LabelValueUnknown, metrics.LabelValueUnknown, metrics.ResultError)
 		return nil, ErrNoSuchAuthenticator
 	}
 
@@ -107,11 +146,14 @@ func (c *Cache) AuthenticateTokenCredentialRequest(ctx context.Context, req *log
 	// Call the selected authenticator.
 	resp, authenticated, err := val.AuthenticateToken(ctx, req.Spec.Token)
 	if err != nil {
+		metrics.RecordTokenCredentialRequest(key.Kind, key.Name, metrics.ResultError)
 		return nil, err
 	}
 	if !authenticated {
+		metrics.RecordTokenCredentialRequest(key.Kind, key.Name, metrics.ResultFailure)
 		return nil, nil
 	}
+	metrics.RecordTokenCredentialRequest(key.Kind, key.Name, metrics.ResultSuccess)
 
 	// Return the user.Info from the response (if it is non-nil).
 	var respUser user.Info
@@ -120,3 +162,14 @@ func (c *Cache) AuthenticateTokenCredentialRequest(ctx context.Context, req *log
 	}
 	return respUser, nil
 }
+
+func keyForRequest(req *loginapi.TokenCredentialRequest) Key {
+	key := Key{
+		Name: req.Spec.Authenticator.Name,
+		Kind: req.Spec.Authenticator.Kind,
+	}
+	if req.Spec.Authenticator.APIGroup != nil {
+		key.APIGroup = *req.Spec.Authenticator.APIGroup
+	}
+	return key
+}
@@ -182,6 +182,100 @@ func TestAuthenticateTokenCredentialRequest(t *testing.T) {
 	})
 }
 
+func TestClientCertificateTTL(t *testing.T) {
+	t.Parallel()
+
+	request := &loginapi.TokenCredentialRequest{
+		Spec: loginapi.TokenCredentialRequestSpec{
+			Authenticator: corev1.TypedLocalObjectReference{
+				APIGroup: &authv1alpha.SchemeGroupVersion.Group,
+				Kind:     "JWTAuthenticator",
+				Name:     "test-name",
+			},
+		},
+	}
+	key := Key{APIGroup: authv1alpha.SchemeGroupVersion.Group, Kind: "JWTAuthenticator", Name: "test-name"}
+
+	tests := []struct {
+		name    string
+		value   Value
+		maxTTL  time.Duration
+		wantTTL time.Duration
+	}{
+		{
+			name:    "no such authenticator",
+			wantTTL: 5 * time.Minute,
+		},
+		{
+			name:    "authenticator does not configure a TTL",
+			value:   &ttlValue{},
+			wantTTL: 5 * time.Minute,
+		},
+		{
+			name:    "authenticator configures a TTL",
+			value:   &ttlValue{ttl: time.Hour},
+			wantTTL: time.Hour,
+		},
+		{
+			name:    "authenticator configures a TTL above the default max",
+			value:   &ttlValue{ttl: 7 * 24 * time.Hour},
+			wantTTL: 24 * time.Hour,
+		},
+		{
+			name:    "authenticator configures a TTL above the default max which is raised",
+			value:   &ttlValue{ttl: 7 * 24 * time.Hour},
+			maxTTL:  30 * 24 * time.Hour,
+			wantTTL: 7 * 24 * time.Hour,
+		},
+		{
+			name:    "authenticator configures a TTL below the max",
+			value:   &ttlValue{ttl: time.Minute},
+			maxTTL:  2 * time.Minute,
+			wantTTL: time.Minute,
+		},
+		{
+			name:    "authenticator configures a TTL above the max",
+			value:   &ttlValue{ttl: time.Hour},
+			maxTTL:  10 * time.Minute,
+			wantTTL: 10 * time.Minute,
+		},
+		{
+			name:    "default TTL above the max",
+			value:   &ttlValue{},
+			maxTTL:  time.Minute,
+			wantTTL: time.Minute,
+		},
+		{
+			name:    "authenticator does not implement ClientCertificateTTLer",
+			value:   mocktokenauthenticator.NewMockToken(nil),
+			maxTTL:  time.Hour,
+			wantTTL: 5 * time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := New()
+			if tt.value != nil {
+				c.Store(key, tt.value)
+			}
+			c.SetMaxClientCertificateTTL(tt.maxTTL)
+			require.Equal(t, tt.wantTTL, c.ClientCertificateTTL(request, 5*time.Minute))
+		})
+	}
+}
+
+type ttlValue struct {
+	authenticator.Token
+	ttl time.Duration
+}
+
+func (v *ttlValue) ClientCertificateTTL() time.Duration {
+	return v.ttl
+}
+
 type audienceFreeContext struct{}
 
 func (audienceFreeContext) Matches(in interface{}) bool {
@@ -0,0 +1,76 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package certttl implements a controller for configuring an authncache.Cache with the max client certificate TTL
+// of the CredentialIssuer.
+package certttl
+
+import (
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	configinformers "go.pinniped.dev/generated/latest/client/concierge/informers/externalversions/config/v1alpha1"
+	pinnipedcontroller "go.pinniped.dev/internal/controller"
+	"go.pinniped.dev/internal/controller/authenticator/authncache"
+	"go.pinniped.dev/internal/controllerlib"
+)
+
+// New instantiates a new controllerlib.Controller which will set the max client certificate TTL of the provided
+// authncache.Cache from the spec.maxClientCertificateTTL of the CredentialIssuer.
+func New(
+	credentialIssuerName string,
+	cache *authncache.Cache,
+	credentialIssuers configinformers.CredentialIssuerInformer,
+	log logr.Logger,
+) controllerlib.Controller {
+	return controllerlib.New(
+		controllerlib.Config{
+			Name: "certttl-controller",
+			Syncer: &controller{
+				credentialIssuerName: credentialIssuerName,
+				cache:                cache,
+				credentialIssuers:    credentialIssuers,
+				log:                  log.WithName("certttl-controller"),
+			},
+		},
+		controllerlib.WithInformer(
+			credentialIssuers,
+			pinnipedcontroller.SimpleFilterWithSingletonQueue(func(obj metav1.Object) bool {
+				return obj.GetName() == credentialIssuerName
+			}),
+			controllerlib.InformerOption{},
+		),
+	)
+}
+
+type controller struct {
+	credentialIssuerName string
+	cache                *authncache.Cache
+	credentialIssuers    configinformers.CredentialIssuerInformer
+	log                  logr.Logger
+}
+
+// Sync implements controllerlib.Syncer.
+func (c *controller) Sync(_ controllerlib.Context) error {
+	credIssuer, err := c.credentialIssuers.Lister().Get(c.credentialIssuerName)
+	if err != nil && errors.IsNotFound(err) {
+		c.log.Info("Sync() found that the CredentialIssuer does not exist yet or was deleted")
+		c.cache.SetMaxClientCertificateTTL(0)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get CredentialIssuer %s: %w", c.credentialIssuerName, err)
+	}
+
+	maxTTL := authncache.DefaultMaxClientCertificateTTL
+	if credIssuer.Spec.MaxClientCertificateTTL != nil && credIssuer.Spec.MaxClientCertificateTTL.Duration > 0 {
+		maxTTL = credIssuer.Spec.MaxClientCertificateTTL.Duration
+	}
+	c.cache.SetMaxClientCertificateTTL(maxTTL)
+	c.log.WithValues("credentialIssuer", klog.KObj(credIssuer), "maxClientCertificateTTL", maxTTL.String()).Info("configured max client certificate TTL")
+	return nil
+}
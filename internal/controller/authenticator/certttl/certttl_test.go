@@ -0,0 +1,110 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package certttl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/concierge/config/v1alpha1"
+	loginapi "go.pinniped.dev/generated/latest/apis/concierge/login"
+	pinnipedfake "go.pinniped.dev/generated/latest/client/concierge/clientset/versioned/fake"
+	pinnipedinformers "go.pinniped.dev/generated/latest/client/concierge/informers/externalversions"
+	"go.pinniped.dev/internal/controller/authenticator/authncache"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/testutil/testlogger"
+)
+
+func TestController(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name              string
+		credentialIssuers []runtime.Object
+		initialMaxTTL     time.Duration
+		wantLogs          []string
+		wantTTL           time.Duration
+	}{
+		{
+			name:          "no CredentialIssuer",
+			initialMaxTTL: time.Minute,
+			wantLogs: []string{
+				`certttl-controller "level"=0 "msg"="Sync() found that the CredentialIssuer does not exist yet or was deleted"`,
+			},
+			wantTTL: time.Hour,
+		},
+		{
+			name: "CredentialIssuer without a max client certificate TTL",
+			credentialIssuers: []runtime.Object{
+				&configv1alpha1.CredentialIssuer{ObjectMeta: metav1.ObjectMeta{Name: "test-credential-issuer"}},
+			},
+			initialMaxTTL: time.Minute,
+			wantLogs: []string{
+				`certttl-controller "level"=0 "msg"="configured max client certificate TTL" "credentialIssuer"={"name":"test-credential-issuer"} "maxClientCertificateTTL"="24h0m0s"`,
+			},
+			wantTTL: time.Hour,
+		},
+		{
+			name: "CredentialIssuer with a max client certificate TTL",
+			credentialIssuers: []runtime.Object{
+				&configv1alpha1.CredentialIssuer{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-credential-issuer"},
+					Spec: configv1alpha1.CredentialIssuerSpec{
+						MaxClientCertificateTTL: &metav1.Duration{Duration: 10 * time.Minute},
+					},
+				},
+			},
+			wantLogs: []string{
+				`certttl-controller "level"=0 "msg"="configured max client certificate TTL" "credentialIssuer"={"name":"test-credential-issuer"} "maxClientCertificateTTL"="10m0s"`,
+			},
+			wantTTL: 10 * time.Minute,
+		},
+		{
+			name: "other CredentialIssuer with a max client certificate TTL",
+			credentialIssuers: []runtime.Object{
+				&configv1alpha1.CredentialIssuer{
+					ObjectMeta: metav1.ObjectMeta{Name: "other-credential-issuer"},
+					Spec: configv1alpha1.CredentialIssuerSpec{
+						MaxClientCertificateTTL: &metav1.Duration{Duration: 10 * time.Minute},
+					},
+				},
+			},
+			wantLogs: []string{
+				`certttl-controller "level"=0 "msg"="Sync() found that the CredentialIssuer does not exist yet or was deleted"`,
+			},
+			wantTTL: time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			fakeClient := pinnipedfake.NewSimpleClientset(tt.credentialIssuers...)
+			informers := pinnipedinformers.NewSharedInformerFactory(fakeClient, 0)
+			cache := authncache.New()
+			cache.SetMaxClientCertificateTTL(tt.initialMaxTTL)
+			testLog := testlogger.New(t)
+
+			controller := New("test-credential-issuer", cache, informers.Config().V1alpha1().CredentialIssuers(), testLog)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			informers.Start(ctx.Done())
+			controllerlib.TestRunSynchronously(t, controller)
+
+			require.NoError(t, controllerlib.TestSync(t, controller, controllerlib.Context{Context: ctx}))
+			require.Equal(t, tt.wantLogs, testLog.Lines())
+
+			// The request names an authenticator which is not in the cache, so the default TTL is used and only the max applies.
+			require.Equal(t, tt.wantTTL, cache.ClientCertificateTTL(&loginapi.TokenCredentialRequest{}, time.Hour))
+		})
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package jwtcachefiller
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/square/go-jose.v2"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	auth1alpha1 "go.pinniped.dev/generated/latest/apis/concierge/authentication/v1alpha1"
+)
+
+// reservedExtraKeySuffix is the suffix of the extra keys which are used by the impersonation proxy, so they can
+// never be mapped from a JWT.
+const reservedExtraKeySuffix = ".impersonation-proxy.concierge.pinniped.dev"
+
+type claimValidationRule struct {
+	claim      string
+	expression *regexp.Regexp
+	message    string
+}
+
+type extraMapping struct {
+	key        string
+	claim      string
+	expression *regexp.Regexp
+}
+
+// claimTransformer applies the claim validation rules and extra mappings of a JWTAuthenticator to the claims of
+// JWTs which were already verified by the underlying OIDC authenticator.
+type claimTransformer struct {
+	rules []claimValidationRule
+	extra []extraMapping
+}
+
+// newClaimTransformer compiles the claim validation rules and extra mappings of the spec. It returns nil when
+// the spec has neither, since then there is nothing to transform.
+func newClaimTransformer(spec *auth1alpha1.JWTAuthenticatorSpec) (*claimTransformer, error) {
+	if len(spec.ClaimValidationRules) == 0 && len(spec.Claims.Extra) == 0 {
+		return nil, nil
+	}
+
+	t := &claimTransformer{}
+	for i, rule := range spec.ClaimValidationRules {
+		if rule.Claim == "" {
+			return nil, fmt.Errorf("claimValidationRules[%d] must specify a claim", i)
+		}
+		expression, err := regexp.Compile(rule.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("claimValidationRules[%d] has an invalid expression: %w", i, err)
+		}
+		t.rules = append(t.rules, claimValidationRule{claim: rule.Claim, expression: expression, message: rule.Message})
+	}
+
+	for i, mapping := range spec.Claims.Extra {
+		key := mapping.Key
+		if !validExtraKey(key) {
+			return nil, fmt.Errorf("claims.extra[%d] has an invalid key %q", i, key)
+		}
+		if mapping.Claim == "" {
+			return nil, fmt.Errorf("claims.extra[%d] must specify a claim", i)
+		}
+		var expression *regexp.Regexp
+		if mapping.Expression != "" {
+			var err error
+			if expression, err = regexp.Compile(mapping.Expression); err != nil {
+				return nil, fmt.Errorf("claims.extra[%d] has an invalid expression: %w", i, err)
+			}
+		}
+		t.extra = append(t.extra, extraMapping{key: key, claim: mapping.Claim, expression: expression})
+	}
+
+	return t, nil
+}
+
+// validExtraKey returns whether the key is lowercase and prefixed by a domain name, e.g. "example.com/team", and is
+// not reserved by Kubernetes or by the impersonation proxy.
+func validExtraKey(key string) bool {
+	if key != strings.ToLower(key) || strings.HasSuffix(key, reservedExtraKeySuffix) {
+		return false
+	}
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return false
+	}
+	domain := parts[0]
+	if !strings.Contains(domain, ".") || len(validation.IsDNS1123Subdomain(domain)) != 0 {
+		return false
+	}
+	for _, reserved := range []string{"kubernetes.io", "k8s.io"} {
+		if domain == reserved || strings.HasSuffix(domain, "."+reserved) {
+			return false
+		}
+	}
+	return true
+}
+
+// transform checks the claims of the token against the validation rules and adds the mapped extra to the user of
+// the response. The token must have been verified already.
+func (t *claimTransformer) transform(token string, resp *authenticator.Response) (*authenticator.Response, error) {
+	claims, err := parseClaims(token)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range t.rules {
+		values, ok, err := stringClaimValues(claims, rule.claim)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("jwt: claim %q required by claim validation rule is not present", rule.claim)
+		}
+		for _, value := range values {
+			if !rule.expression.MatchString(value) {
+				if rule.message != "" {
+					return nil, fmt.Errorf("jwt: claim %q failed validation: %s", rule.claim, rule.message)
+				}
+				return nil, fmt.Errorf("jwt: claim %q does not match %q", rule.claim, rule.expression.String())
+			}
+		}
+	}
+
+	var extra map[string][]string
+	for _, mapping := range t.extra {
+		values, ok, err := stringClaimValues(claims, mapping.claim)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		for _, value := range values {
+			if mapping.expression != nil {
+				match := mapping.expression.FindStringSubmatch(value)
+				if match == nil {
+					continue
+				}
+				value = match[0]
+				if len(match) > 1 {
+					value = match[1]
+				}
+			}
+			if extra == nil {
+				extra = map[string][]string{}
+			}
+			extra[mapping.key] = append(extra[mapping.key], value)
+		}
+	}
+
+	if len(extra) == 0 {
+		return resp, nil
+	}
+	return &authenticator.Response{
+		Audiences: resp.Audiences,
+		User: &user.DefaultInfo{
+			Name:   resp.User.GetName(),
+			UID:    resp.User.GetUID(),
+			Groups: resp.User.GetGroups(),
+			Extra:  extra,
+		},
+	}, nil
+}
+
+// parseClaims returns the claims of the payload of the token without verifying its signature, so it must only be
+// used on tokens which were already verified.
+func parseClaims(token string) (map[string]interface{}, error) {
+	jws, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: parse token: %w", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(jws.UnsafePayloadWithoutVerification(), &claims); err != nil {
+		return nil, fmt.Errorf("jwt: parse claims: %w", err)
+	}
+	return claims, nil
+}
+
+// stringClaimValues returns the values of a claim which is either a string or a list of strings, and whether the
+// claim was present at all.
+func stringClaimValues(claims map[string]interface{}, claim string) ([]string, bool, error) {
+	raw, ok := claims[claim]
+	if !ok {
+		return nil, false, nil
+	}
+	switch value := raw.(type) {
+	case string:
+		return []string{value}, true, nil
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false, fmt.Errorf("jwt: claim %q must be a string or a list of strings", claim)
+			}
+			values = append(values, s)
+		}
+		return values, true, nil
+	default:
+		return nil, false, fmt.Errorf("jwt: claim %q must be a string or a list of strings", claim)
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package jwtcachefiller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	auth1alpha1 "go.pinniped.dev/generated/latest/apis/concierge/authentication/v1alpha1"
+)
+
+func TestNewClaimTransformer(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		spec    auth1alpha1.JWTAuthenticatorSpec
+		wantNil bool
+		wantErr string
+	}{
+		{
+			name:    "nothing to transform",
+			wantNil: true,
+		},
+		{
+			name: "valid rules and mappings",
+			spec: auth1alpha1.JWTAuthenticatorSpec{
+				ClaimValidationRules: []auth1alpha1.JWTClaimValidationRule{{Claim: "email", Expression: `@example\.com$`}},
+				Claims: auth1alpha1.JWTTokenClaims{
+					Extra: []auth1alpha1.JWTExtraMapping{{Key: "example.com/email-domain", Claim: "email", Expression: `@(.+)$`}},
+				},
+			},
+		},
+		{
+			name: "valid mapping with a key which has a path with slashes",
+			spec: auth1alpha1.JWTAuthenticatorSpec{
+				Claims: auth1alpha1.JWTTokenClaims{
+					Extra: []auth1alpha1.JWTExtraMapping{{Key: "teams.example.com/org/team", Claim: "team"}},
+				},
+			},
+		},
+		{
+			name: "rule without claim",
+			spec: auth1alpha1.JWTAuthenticatorSpec{
+				ClaimValidationRules: []auth1alpha1.JWTClaimValidationRule{{Expression: `.*`}},
+			},
+			wantErr: "claimValidationRules[0] must specify a claim",
+		},
+		{
+			name: "rule with invalid expression",
+			spec: auth1alpha1.JWTAuthenticatorSpec{
+				ClaimValidationRules: []auth1alpha1.JWTClaimValidationRule{{Claim: "email", Expression: `(`}},
+			},
+			wantErr: "claimValidationRules[0] has an invalid expression: error parsing regexp: missing closing ): `(`",
+		},
+		{
+			name: "mapping with uppercase key",
+			spec: auth1alpha1.JWTAuthenticatorSpec{
+				Claims: auth1alpha1.JWTTokenClaims{
+					Extra: []auth1alpha1.JWTExtraMapping{{Key: "Example.com/team", Claim: "team"}},
+				},
+			},
+			wantErr: `claims.extra[0] has an invalid key "Example.com/team"`,
+		},
+		{
+			name: "mapping with reserved key",
+			spec: auth1alpha1.JWTAuthenticatorSpec{
+				Claims: auth1alpha1.JWTTokenClaims{
+					Extra: []auth1alpha1.JWTExtraMapping{{Key: "original-user-info.impersonation-proxy.concierge.pinniped.dev", Claim: "team"}},
+				},
+			},
+			wantErr: `claims.extra[0] has an invalid key "original-user-info.impersonation-proxy.concierge.pinniped.dev"`,
+		},
+		{
+			name: "mapping with empty key",
+			spec: auth1alpha1.JWTAuthenticatorSpec{
+				Claims: auth1alpha1.JWTTokenClaims{
+					Extra: []auth1alpha1.JWTExtraMapping{{Key: "", Claim: "team"}},
+				},
+			},
+			wantErr: `claims.extra[0] has an invalid key ""`,
+		},
+		{
+			name: "mapping with key without domain",
+			spec: auth1alpha1.JWTAuthenticatorSpec{
+				Claims: auth1alpha1.JWTTokenClaims{
+					Extra: []auth1alpha1.JWTExtraMapping{{Key: "team", Claim: "team"}},
+				},
+			},
+			wantErr: `claims.extra[0] has an invalid key "team"`,
+		},
+		{
+			name: "mapping with key without path",
+			spec: auth1alpha1.JWTAuthenticatorSpec{
+				Claims: auth1alpha1.JWTTokenClaims{
+					Extra: []auth1alpha1.JWTExtraMapping{{Key: "example.com/", Claim: "team"}},
+				},
+			},
+			wantErr: `claims.extra[0] has an invalid key "example.com/"`,
+		},
+		{
+			name: "mapping with key which is not domain-prefixed",
+			spec: auth1alpha1.JWTAuthenticatorSpec{
+				Claims: auth1alpha1.JWTTokenClaims{
+					Extra: []auth1alpha1.JWTExtraMapping{{Key: "team/blue", Claim: "team"}},
+				},
+			},
+			wantErr: `claims.extra[0] has an invalid key "team/blue"`,
+		},
+		{
+			name: "mapping with key with an invalid domain",
+			spec: auth1alpha1.JWTAuthenticatorSpec{
+				Claims: auth1alpha1.JWTTokenClaims{
+					Extra: []auth1alpha1.JWTExtraMapping{{Key: "-example.com/team", Claim: "team"}},
+				},
+			},
+			wantErr: `claims.extra[0] has an invalid key "-example.com/team"`,
+		},
+		{
+			name: "mapping with key of the kubernetes.io domain",
+			spec: auth1alpha1.JWTAuthenticatorSpec{
+				Claims: auth1alpha1.JWTTokenClaims{
+					Extra: []auth1alpha1.JWTExtraMapping{{Key: "kubernetes.io/team", Claim: "team"}},
+				},
+			},
+			wantErr: `claims.extra[0] has an invalid key "kubernetes.io/team"`,
+		},
+		{
+			name: "mapping with key of a subdomain of kubernetes.io",
+			spec: auth1alpha1.JWTAuthenticatorSpec{
+				Claims: auth1alpha1.JWTTokenClaims{
+					Extra: []auth1alpha1.JWTExtraMapping{{Key: "authentication.kubernetes.io/team", Claim: "team"}},
+				},
+			},
+			wantErr: `claims.extra[0] has an invalid key "authentication.kubernetes.io/team"`,
+		},
+		{
+			name: "mapping with key of the k8s.io domain",
+			spec: auth1alpha1.JWTAuthenticatorSpec{
+				Claims: auth1alpha1.JWTTokenClaims{
+					Extra: []auth1alpha1.JWTExtraMapping{{Key: "k8s.io/team", Claim: "team"}},
+				},
+			},
+			wantErr: `claims.extra[0] has an invalid key "k8s.io/team"`,
+		},
+		{
+			name: "mapping without claim",
+			spec: auth1alpha1.JWTAuthenticatorSpec{
+				Claims: auth1alpha1.JWTTokenClaims{
+					Extra: []auth1alpha1.JWTExtraMapping{{Key: "example.com/team"}},
+				},
+			},
+			wantErr: "claims.extra[0] must specify a claim",
+		},
+		{
+			name: "mapping with invalid expression",
+			spec: auth1alpha1.JWTAuthenticatorSpec{
+				Claims: auth1alpha1.JWTTokenClaims{
+					Extra: []auth1alpha1.JWTExtraMapping{{Key: "example.com/team", Claim: "team", Expression: `[`}},
+				},
+			},
+			wantErr: "claims.extra[0] has an invalid expression: error parsing regexp: missing closing ]: `[`",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			transformer, err := newClaimTransformer(&tt.spec)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				require.Nil(t, transformer)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantNil, transformer == nil)
+		})
+	}
+}
+
+func TestClaimTransformer(t *testing.T) {
+	t.Parallel()
+
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: signingKey}, nil)
+	require.NoError(t, err)
+
+	spec := &auth1alpha1.JWTAuthenticatorSpec{
+		ClaimValidationRules: []auth1alpha1.JWTClaimValidationRule{
+			{Claim: "email", Expression: `@example\.com$`, Message: "only example.com users are allowed"},
+			{Claim: "teams", Expression: `^team-`},
+		},
+		Claims: auth1alpha1.JWTTokenClaims{
+			Extra: []auth1alpha1.JWTExtraMapping{
+				{Key: "example.com/email-domain", Claim: "email", Expression: `@(.+)$`},
+				{Key: "example.com/teams", Claim: "teams"},
+				{Key: "example.com/blue-teams", Claim: "teams", Expression: `blue`},
+				{Key: "example.com/missing", Claim: "missing"},
+			},
+		},
+	}
+
+	upstreamResponse := &authenticator.Response{
+		User: &user.DefaultInfo{Name: "pinny", Groups: []string{"some-group"}},
+	}
+
+	tests := []struct {
+		name         string
+		claims       map[string]interface{}
+		wantResponse *authenticator.Response
+		wantErr      string
+	}{
+		{
+			name:   "claims pass the rules and are mapped into extra",
+			claims: map[string]interface{}{"email": "pinny@example.com", "teams": []string{"team-blue", "team-red"}},
+			wantResponse: &authenticator.Response{
+				User: &user.DefaultInfo{
+					Name:   "pinny",
+					Groups: []string{"some-group"},
+					Extra: map[string][]string{
+						"example.com/email-domain": {"example.com"},
+						"example.com/teams":        {"team-blue", "team-red"},
+						"example.com/blue-teams":   {"blue"},
+					},
+				},
+			},
+		},
+		{
+			name:    "claim fails a rule with a message",
+			claims:  map[string]interface{}{"email": "pinny@example.org", "teams": "team-blue"},
+			wantErr: `jwt: claim "email" failed validation: only example.com users are allowed`,
+		},
+		{
+			name:    "one value of a claim fails a rule without a message",
+			claims:  map[string]interface{}{"email": "pinny@example.com", "teams": []string{"team-blue", "red"}},
+			wantErr: `jwt: claim "teams" does not match "^team-"`,
+		},
+		{
+			name:    "claim required by a rule is missing",
+			claims:  map[string]interface{}{"email": "pinny@example.com"},
+			wantErr: `jwt: claim "teams" required by claim validation rule is not present`,
+		},
+		{
+			name:    "claim has the wrong type",
+			claims:  map[string]interface{}{"email": "pinny@example.com", "teams": []interface{}{"team-blue", 42}},
+			wantErr: `jwt: claim "teams" must be a string or a list of strings`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			token, err := jwt.Signed(signer).Claims(tt.claims).CompactSerialize()
+			require.NoError(t, err)
+
+			transformer, err := newClaimTransformer(spec)
+			require.NoError(t, err)
+
+			resp, err := transformer.transform(token, upstreamResponse)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				require.Nil(t, resp)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantResponse, resp)
+		})
+	}
+}
@@ -6,16 +6,15 @@
 package jwtcachefiller
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
-	"os"
 	"reflect"
+	"time"
 
 	"github.com/go-logr/logr"
 	"gopkg.in/square/go-jose.v2"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apiserver/pkg/authentication/authenticator"
-	"k8s.io/apiserver/plugin/pkg/authenticator/token/oidc"
 	"k8s.io/klog/v2"
 
 	auth1alpha1 "go.pinniped.dev/generated/latest/apis/concierge/authentication/v1alpha1"
@@ -34,7 +33,7 @@ const (
 )
 
 // defaultSupportedSigningAlgos returns the default signing algos that this JWTAuthenticator
-// supports (i.e., if none are supplied by the user).
+// supports (i.e., if none are supplied by the user in spec.signingAlgorithms).
 func defaultSupportedSigningAlgos() []string {
 	return []string{
 		// RS256 is recommended by the OIDC spec and required, in some capacity. Since we want the
@@ -54,7 +53,31 @@ type tokenAuthenticatorCloser interface {
 
 type jwtAuthenticator struct {
 	tokenAuthenticatorCloser
-	spec *auth1alpha1.JWTAuthenticatorSpec
+	spec   *auth1alpha1.JWTAuthenticatorSpec
+	claims *claimTransformer
+}
+
+// AuthenticateToken implements authenticator.Token. It applies the claim validation rules and extra mappings of
+// the spec on top of the underlying OIDC authenticator.
+func (a *jwtAuthenticator) AuthenticateToken(ctx context.Context, token string) (*authenticator.Response, bool, error) {
+	resp, ok, err := a.tokenAuthenticatorCloser.AuthenticateToken(ctx, token)
+	if err != nil || !ok || a.claims == nil {
+		return resp, ok, err
+	}
+
+	resp, err = a.claims.transform(token, resp)
+	if err != nil {
+		return nil, false, err
+	}
+	return resp, true, nil
+}
+
+// ClientCertificateTTL implements authncache.ClientCertificateTTLer.
+func (a *jwtAuthenticator) ClientCertificateTTL() time.Duration {
+	if a.spec.ClientCertificateTTL == nil {
+		return 0
+	}
+	return a.spec.ClientCertificateTTL.Duration
 }
 
 // New instantiates a new controllerlib.Controller which will populate the provided authncache.Cache.
@@ -151,23 +174,6 @@ func newJWTAuthenticator(spec *auth1alpha1.JWTAuthenticatorSpec) (*jwtAuthentica
 		return nil, fmt.Errorf("invalid TLS configuration: %w", err)
 	}
 
-	var caFile string
-	if caBundle != nil {
-		temp, err := ioutil.TempFile("", "pinniped-jwkauthenticator-cafile-*")
-		if err != nil {
-			return nil, fmt.Errorf("unable to create temporary file: %w", err)
-		}
-
-		// We can safely remove the temp file at the end of this function since oidc.New() reads the
-		// provided CA file and then forgets about it.
-		defer func() { _ = os.Remove(temp.Name()) }()
-
-		if _, err := temp.Write(caBundle); err != nil {
-			return nil, fmt.Errorf("cannot write CA file: %w", err)
-		}
-
-		caFile = temp.Name()
-	}
 	usernameClaim := spec.Claims.Username
 	if usernameClaim == "" {
 		usernameClaim = defaultUsernameClaim
@@ -177,13 +183,27 @@ func newJWTAuthenticator(spec *auth1alpha1.JWTAuthenticatorSpec) (*jwtAuthentica
 		groupsClaim = defaultGroupsClaim
 	}
 
-	authenticator, err := oidc.New(oidc.Options{
-		IssuerURL:            spec.Issuer,
-		ClientID:             spec.Audience,
-		UsernameClaim:        usernameClaim,
-		GroupsClaim:          groupsClaim,
-		SupportedSigningAlgs: defaultSupportedSigningAlgos(),
-		CAFile:               caFile,
+	claims, err := newClaimTransformer(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid claims configuration: %w", err)
+	}
+
+	signingAlgos := spec.SigningAlgorithms
+	if len(signingAlgos) == 0 {
+		signingAlgos = defaultSupportedSigningAlgos()
+	}
+
+	authenticator, err := newOIDCAuthenticator(oidcAuthenticatorOptions{
+		issuer:               spec.Issuer,
+		jwksURL:              spec.JWKSURL,
+		audiences:            append([]string{spec.Audience}, spec.AdditionalAudiences...),
+		usernameClaim:        usernameClaim,
+		usernamePrefix:       spec.Claims.UsernamePrefix,
+		groupsClaim:          groupsClaim,
+		groupsPrefix:         spec.Claims.GroupsPrefix,
+		requiredClaims:       spec.RequiredClaims,
+		supportedSigningAlgs: signingAlgos,
+		caBundle:             caBundle,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("could not initialize authenticator: %w", err)
@@ -192,5 +212,6 @@ func newJWTAuthenticator(spec *auth1alpha1.JWTAuthenticatorSpec) (*jwtAuthentica
 	return &jwtAuthenticator{
 		tokenAuthenticatorCloser: authenticator,
 		spec:                     spec,
+		claims:                   claims,
 	}, nil
 }
@@ -121,6 +121,18 @@ func TestController(t *testing.T) {
 		Audience: goodAudience,
 		TLS:      &auth1alpha1.TLSSpec{CertificateAuthorityData: "invalid base64-encoded data"},
 	}
+	invalidClaimValidationRuleJWTAuthenticatorSpec := &auth1alpha1.JWTAuthenticatorSpec{
+		Issuer:               goodIssuer,
+		Audience:             goodAudience,
+		TLS:                  tlsSpecFromTLSConfig(server.TLS),
+		ClaimValidationRules: []auth1alpha1.JWTClaimValidationRule{{Claim: "email", Expression: "("}},
+	}
+	invalidSigningAlgorithmJWTAuthenticatorSpec := &auth1alpha1.JWTAuthenticatorSpec{
+		Issuer:            goodIssuer,
+		Audience:          goodAudience,
+		TLS:               tlsSpecFromTLSConfig(server.TLS),
+		SigningAlgorithms: []string{"HS256"},
+	}
 
 	tests := []struct {
 		name                             string
@@ -309,6 +321,32 @@ func TestController(t *testing.T) {
 			},
 			wantErr: "failed to build jwt authenticator: invalid TLS configuration: illegal base64 data at input byte 7",
 		},
+		{
+			name:    "invalid jwt authenticator claim validation rule",
+			syncKey: controllerlib.Key{Name: "test-name"},
+			jwtAuthenticators: []runtime.Object{
+				&auth1alpha1.JWTAuthenticator{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "test-name",
+					},
+					Spec: *invalidClaimValidationRuleJWTAuthenticatorSpec,
+				},
+			},
+			wantErr: "failed to build jwt authenticator: invalid claims configuration: claimValidationRules[0] has an invalid expression: error parsing regexp: missing closing ): `(`",
+		},
+		{
+			name:    "invalid jwt authenticator signing algorithm",
+			syncKey: controllerlib.Key{Name: "test-name"},
+			jwtAuthenticators: []runtime.Object{
+				&auth1alpha1.JWTAuthenticator{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "test-name",
+					},
+					Spec: *invalidSigningAlgorithmJWTAuthenticatorSpec,
+				},
+			},
+			wantErr: `failed to build jwt authenticator: could not initialize authenticator: unsupported signing algorithm "HS256"`,
+		},
 	}
 
 	for _, tt := range tests {
@@ -446,6 +484,17 @@ func TestController(t *testing.T) {
 	}
 }
 
+func TestClientCertificateTTL(t *testing.T) {
+	t.Parallel()
+
+	require.Zero(t, (&jwtAuthenticator{spec: &auth1alpha1.JWTAuthenticatorSpec{}}).ClientCertificateTTL())
+	require.Equal(t, time.Hour, (&jwtAuthenticator{spec: &auth1alpha1.JWTAuthenticatorSpec{
+		ClientCertificateTTL: &metav1.Duration{Duration: time.Hour},
+	}}).ClientCertificateTTL())
+
+	var _ authncache.ClientCertificateTTLer = (*jwtAuthenticator)(nil)
+}
+
 // isNotInitialized checks if the error is the internally-defined "oidc: authenticator not initialized" error from
 // the underlying OIDC authenticator, which is initialized asynchronously.
 func isNotInitialized(err error) bool {
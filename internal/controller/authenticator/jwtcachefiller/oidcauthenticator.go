@@ -0,0 +1,307 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package jwtcachefiller
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	coreosoidc "github.com/coreos/go-oidc/v3/oidc"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"go.pinniped.dev/internal/plog"
+)
+
+// supportedSigningAlgos are the signing algos which a JWTAuthenticator may be configured to accept.
+func supportedSigningAlgos() map[string]bool {
+	return map[string]bool{
+		coreosoidc.RS256: true,
+		coreosoidc.RS384: true,
+		coreosoidc.RS512: true,
+		coreosoidc.ES256: true,
+		coreosoidc.ES384: true,
+		coreosoidc.ES512: true,
+		coreosoidc.PS256: true,
+		coreosoidc.PS384: true,
+		coreosoidc.PS512: true,
+	}
+}
+
+type oidcAuthenticatorOptions struct {
+	issuer               string
+	jwksURL              string
+	audiences            []string
+	usernameClaim        string
+	usernamePrefix       string
+	groupsClaim          string
+	groupsPrefix         string
+	requiredClaims       map[string]string
+	supportedSigningAlgs []string
+	caBundle             []byte
+}
+
+// oidcAuthenticator is an authenticator.Token which verifies JWTs from an OIDC issuer. It behaves like the
+// authenticator of k8s.io/apiserver/plugin/pkg/authenticator/token/oidc, except that it accepts several audiences
+// and that it can load the signing keys from a JWKS URL instead of from the discovery document of the issuer. The
+// upstream authenticator cannot be used for the latter. Both verify tokens with github.com/coreos/go-oidc, so they
+// check the signature, algorithm, issuer, expiry and not-before time of tokens in the same way.
+type oidcAuthenticator struct {
+	issuer         string
+	audiences      []string
+	usernameClaim  string
+	usernamePrefix string
+	groupsClaim    string
+	groupsPrefix   string
+	requiredClaims map[string]string
+
+	cancel context.CancelFunc
+
+	lock     sync.RWMutex
+	verifier *coreosoidc.IDTokenVerifier
+}
+
+// newOIDCAuthenticator creates an oidcAuthenticator. When no JWKS URL is configured, the discovery document of
+// the issuer is loaded asynchronously, so that issuers which are not reachable yet (e.g. because they run on the
+// same cluster) can still be configured.
+func newOIDCAuthenticator(opts oidcAuthenticatorOptions) (*oidcAuthenticator, error) {
+	if err := validHTTPSURL(opts.issuer); err != nil {
+		return nil, fmt.Errorf("invalid issuer: %w", err)
+	}
+	if opts.jwksURL != "" {
+		if err := validHTTPSURL(opts.jwksURL); err != nil {
+			return nil, fmt.Errorf("invalid JWKS URL: %w", err)
+		}
+	}
+	if opts.usernameClaim == "" {
+		return nil, fmt.Errorf("no username claim provided")
+	}
+	if len(opts.audiences) == 0 {
+		return nil, fmt.Errorf("no audience provided")
+	}
+	for _, algo := range opts.supportedSigningAlgs {
+		if !supportedSigningAlgos()[algo] {
+			return nil, fmt.Errorf("unsupported signing algorithm %q", algo)
+		}
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.caBundle != nil {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(opts.caBundle) {
+			return nil, fmt.Errorf("certificateAuthorityData is not valid PEM")
+		}
+	}
+	httpClient := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+
+	verifierConfig := &coreosoidc.Config{
+		SupportedSigningAlgs: opts.supportedSigningAlgs,
+	}
+	if len(opts.audiences) == 1 {
+		verifierConfig.ClientID = opts.audiences[0]
+	} else {
+		// The verifier can only check a single audience, so the audiences are checked in AuthenticateToken.
+		verifierConfig.SkipClientIDCheck = true
+	}
+
+	ctx, cancel := context.WithCancel(coreosoidc.ClientContext(context.Background(), httpClient))
+	a := &oidcAuthenticator{
+		issuer:         opts.issuer,
+		audiences:      opts.audiences,
+		usernameClaim:  opts.usernameClaim,
+		usernamePrefix: opts.usernamePrefix,
+		groupsClaim:    opts.groupsClaim,
+		groupsPrefix:   opts.groupsPrefix,
+		requiredClaims: opts.requiredClaims,
+		cancel:         cancel,
+	}
+
+	if opts.jwksURL != "" {
+		a.setVerifier(coreosoidc.NewVerifier(opts.issuer, coreosoidc.NewRemoteKeySet(ctx, opts.jwksURL), verifierConfig))
+		return a, nil
+	}
+
+	go wait.PollImmediateUntil(10*time.Second, func() (bool, error) {
+		provider, err := coreosoidc.NewProvider(ctx, opts.issuer)
+		if err != nil {
+			plog.Error("failed to perform OIDC discovery", err, "issuer", opts.issuer)
+			return false, nil
+		}
+		a.setVerifier(provider.Verifier(verifierConfig))
+		return true, nil
+	}, ctx.Done())
+
+	return a, nil
+}
+
+func (a *oidcAuthenticator) setVerifier(verifier *coreosoidc.IDTokenVerifier) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.verifier = verifier
+}
+
+func (a *oidcAuthenticator) getVerifier() *coreosoidc.IDTokenVerifier {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return a.verifier
+}
+
+// Close implements authenticator.Closer.
+func (a *oidcAuthenticator) Close() {
+	a.cancel()
+}
+
+// AuthenticateToken implements authenticator.Token.
+func (a *oidcAuthenticator) AuthenticateToken(ctx context.Context, token string) (*authenticator.Response, bool, error) {
+	if !hasIssuer(token, a.issuer) {
+		return nil, false, nil
+	}
+
+	verifier := a.getVerifier()
+	if verifier == nil {
+		return nil, false, fmt.Errorf("oidc: authenticator not initialized")
+	}
+
+	idToken, err := verifier.Verify(ctx, token)
+	if err != nil {
+		return nil, false, fmt.Errorf("oidc: verify token: %w", err)
+	}
+	if len(a.audiences) > 1 && !containsAny(idToken.Audience, a.audiences) {
+		return nil, false, fmt.Errorf("oidc: verify token: oidc: expected one of audiences %q got %q", a.audiences, idToken.Audience)
+	}
+
+	var claims map[string]json.RawMessage
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, false, fmt.Errorf("oidc: parse claims: %w", err)
+	}
+
+	var username string
+	if err := unmarshalClaim(claims, a.usernameClaim, &username); err != nil {
+		return nil, false, fmt.Errorf("oidc: parse username claims %q: %w", a.usernameClaim, err)
+	}
+	if a.usernameClaim == "email" {
+		// If the email_verified claim is present, ensure the email is valid.
+		// https://openid.net/specs/openid-connect-core-1_0.html#StandardClaims
+		if _, ok := claims["email_verified"]; ok {
+			var emailVerified bool
+			if err := unmarshalClaim(claims, "email_verified", &emailVerified); err != nil {
+				return nil, false, fmt.Errorf("oidc: parse 'email_verified' claim: %w", err)
+			}
+			if !emailVerified {
+				return nil, false, fmt.Errorf("oidc: email not verified")
+			}
+		}
+	}
+
+	info := &user.DefaultInfo{Name: a.usernamePrefix + username}
+	if _, ok := claims[a.groupsClaim]; a.groupsClaim != "" && ok {
+		var groups stringOrArray
+		if err := unmarshalClaim(claims, a.groupsClaim, &groups); err != nil {
+			return nil, false, fmt.Errorf("oidc: parse groups claim %q: %w", a.groupsClaim, err)
+		}
+		for _, group := range groups {
+			info.Groups = append(info.Groups, a.groupsPrefix+group)
+		}
+	}
+
+	for claim, want := range a.requiredClaims {
+		if _, ok := claims[claim]; !ok {
+			return nil, false, fmt.Errorf("oidc: required claim %s not present in ID token", claim)
+		}
+		// Only string values are supported as required claim values.
+		var got string
+		if err := unmarshalClaim(claims, claim, &got); err != nil {
+			return nil, false, fmt.Errorf("oidc: parse claim %s: %w", claim, err)
+		}
+		if got != want {
+			return nil, false, fmt.Errorf("oidc: required claim %s value does not match. Got = %s, want = %s", claim, got, want)
+		}
+	}
+
+	return &authenticator.Response{User: info}, true, nil
+}
+
+// hasIssuer returns whether the unverified payload of the token has the given issuer, so that tokens from other
+// issuers can be skipped without an error.
+func hasIssuer(token string, issuer string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return false
+	}
+	return claims.Issuer == issuer
+}
+
+func containsAny(values []string, wanted []string) bool {
+	for _, value := range values {
+		for _, w := range wanted {
+			if value == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func unmarshalClaim(claims map[string]json.RawMessage, name string, v interface{}) error {
+	raw, ok := claims[name]
+	if !ok {
+		return fmt.Errorf("claim not present")
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// stringOrArray is a claim which may be either a string or a list of strings.
+type stringOrArray []string
+
+func (s *stringOrArray) UnmarshalJSON(b []byte) error {
+	var a []string
+	if err := json.Unmarshal(b, &a); err == nil {
+		*s = a
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return err
+	}
+	*s = []string{str}
+	return nil
+}
+
+// validHTTPSURL returns an error unless the given string is an https URL.
+func validHTTPSURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("%q has invalid scheme %q, require 'https'", rawURL, u.Scheme)
+	}
+	return nil
+}
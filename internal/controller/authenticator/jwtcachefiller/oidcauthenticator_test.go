@@ -0,0 +1,307 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package jwtcachefiller
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+func TestOIDCAuthenticator(t *testing.T) {
+	t.Parallel()
+
+	es256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	es384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	server := httptest.NewTLSServer(mux)
+	t.Cleanup(server.Close)
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	mux.Handle("/.well-known/openid-configuration", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, err := fmt.Fprintf(w, `{"issuer": "%s", "jwks_uri": "%s"}`, server.URL, server.URL+"/jwks.json")
+		require.NoError(t, err)
+	}))
+	mux.Handle("/jwks.json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: es256Key.Public(), KeyID: "es256", Algorithm: string(jose.ES256), Use: "sig"},
+			{Key: es384Key.Public(), KeyID: "es384", Algorithm: string(jose.ES384), Use: "sig"},
+		}}))
+	}))
+
+	// An issuer which does not serve a discovery document, so it can only be used along with a JWKS URL.
+	const undiscoverableIssuer = "https://undiscoverable.example.com"
+
+	defaultOptions := func() oidcAuthenticatorOptions {
+		return oidcAuthenticatorOptions{
+			issuer:               server.URL,
+			audiences:            []string{"some-audience"},
+			usernameClaim:        "username",
+			groupsClaim:          "groups",
+			supportedSigningAlgs: []string{string(jose.ES256)},
+			caBundle:             caBundle,
+		}
+	}
+
+	tests := []struct {
+		name              string
+		options           func(*oidcAuthenticatorOptions)
+		claims            map[string]interface{}
+		signWithES384     bool
+		signToken         func(t *testing.T, claims map[string]interface{}) string
+		wantResponse      *authenticator.Response
+		wantAuthenticated bool
+		wantErrorRegexp   string
+	}{
+		{
+			name:              "good token",
+			claims:            map[string]interface{}{"username": "pinny", "groups": []string{"group-1", "group-2"}},
+			wantResponse:      &authenticator.Response{User: &user.DefaultInfo{Name: "pinny", Groups: []string{"group-1", "group-2"}}},
+			wantAuthenticated: true,
+		},
+		{
+			name: "good token with prefixes and required claims",
+			options: func(o *oidcAuthenticatorOptions) {
+				o.usernamePrefix = "oidc:"
+				o.groupsPrefix = "oidc:"
+				o.requiredClaims = map[string]string{"hd": "example.com"}
+			},
+			claims:            map[string]interface{}{"username": "pinny", "groups": "group-1", "hd": "example.com"},
+			wantResponse:      &authenticator.Response{User: &user.DefaultInfo{Name: "oidc:pinny", Groups: []string{"oidc:group-1"}}},
+			wantAuthenticated: true,
+		},
+		{
+			name:            "required claim has the wrong value",
+			options:         func(o *oidcAuthenticatorOptions) { o.requiredClaims = map[string]string{"hd": "example.com"} },
+			claims:          map[string]interface{}{"username": "pinny", "hd": "example.org"},
+			wantErrorRegexp: `^oidc: required claim hd value does not match. Got = example.org, want = example.com$`,
+		},
+		{
+			name:            "required claim is missing",
+			options:         func(o *oidcAuthenticatorOptions) { o.requiredClaims = map[string]string{"hd": "example.com"} },
+			claims:          map[string]interface{}{"username": "pinny"},
+			wantErrorRegexp: `^oidc: required claim hd not present in ID token$`,
+		},
+		{
+			name:            "email is not verified",
+			options:         func(o *oidcAuthenticatorOptions) { o.usernameClaim = "email" },
+			claims:          map[string]interface{}{"email": "pinny@example.com", "email_verified": false},
+			wantErrorRegexp: `^oidc: email not verified$`,
+		},
+		{
+			name:              "token from another issuer is skipped",
+			claims:            map[string]interface{}{"username": "pinny", "iss": "https://other-issuer.example.com"},
+			wantAuthenticated: false,
+		},
+		{
+			name:              "token for an additional audience",
+			options:           func(o *oidcAuthenticatorOptions) { o.audiences = []string{"some-audience", "other-audience"} },
+			claims:            map[string]interface{}{"username": "pinny", "aud": []string{"other-audience"}},
+			wantResponse:      &authenticator.Response{User: &user.DefaultInfo{Name: "pinny"}},
+			wantAuthenticated: true,
+		},
+		{
+			name:            "token for none of the audiences",
+			options:         func(o *oidcAuthenticatorOptions) { o.audiences = []string{"some-audience", "other-audience"} },
+			claims:          map[string]interface{}{"username": "pinny", "aud": []string{"wrong-audience"}},
+			wantErrorRegexp: `^oidc: verify token: oidc: expected one of audiences \["some-audience" "other-audience"\] got \["wrong-audience"\]$`,
+		},
+		{
+			name:            "token for the wrong audience",
+			claims:          map[string]interface{}{"username": "pinny", "aud": []string{"wrong-audience"}},
+			wantErrorRegexp: `^oidc: verify token: oidc: expected audience "some-audience" got \["wrong-audience"\]$`,
+		},
+		{
+			name:            "token signed with an algorithm which is not allowed",
+			claims:          map[string]interface{}{"username": "pinny"},
+			signWithES384:   true,
+			wantErrorRegexp: `^oidc: verify token: oidc: id token signed with unsupported algorithm, expected \["ES256"\] got "ES384"$`,
+		},
+		{
+			name:              "token signed with an algorithm which was configured",
+			options:           func(o *oidcAuthenticatorOptions) { o.supportedSigningAlgs = []string{string(jose.ES384)} },
+			claims:            map[string]interface{}{"username": "pinny"},
+			signWithES384:     true,
+			wantResponse:      &authenticator.Response{User: &user.DefaultInfo{Name: "pinny"}},
+			wantAuthenticated: true,
+		},
+		{
+			name:            "expired token",
+			claims:          map[string]interface{}{"username": "pinny", "exp": time.Now().Add(-time.Minute).Unix()},
+			wantErrorRegexp: `^oidc: verify token: oidc: token is expired \(Token Expiry: .+\)$`,
+		},
+		{
+			name:            "token which is not valid yet",
+			claims:          map[string]interface{}{"username": "pinny", "nbf": time.Now().Add(time.Hour).Unix()},
+			wantErrorRegexp: `^oidc: verify token: oidc: current time .+ before the nbf \(not before\) time: .+$`,
+		},
+		{
+			name:              "token from an issuer which only differs by a trailing slash is skipped",
+			claims:            map[string]interface{}{"username": "pinny", "iss": server.URL + "/"},
+			wantAuthenticated: false,
+		},
+		{
+			name:   "unsigned token",
+			claims: map[string]interface{}{"username": "pinny"},
+			signToken: func(t *testing.T, claims map[string]interface{}) string {
+				header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+				payload, err := json.Marshal(claims)
+				require.NoError(t, err)
+				return header + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+			},
+			wantErrorRegexp: `^oidc: verify token: oidc: id token signed with unsupported algorithm, expected \["ES256"\] got "none"$`,
+		},
+		{
+			name:   "token signed with HS256",
+			claims: map[string]interface{}{"username": "pinny"},
+			signToken: func(t *testing.T, claims map[string]interface{}) string {
+				return signToken(t, []byte("some-shared-secret-which-is-long-enough"), jose.HS256, "es256", claims)
+			},
+			wantErrorRegexp: `^oidc: verify token: oidc: id token signed with unsupported algorithm, expected \["ES256"\] got "HS256"$`,
+		},
+		{
+			name: "keys from a JWKS URL for an issuer without discovery",
+			options: func(o *oidcAuthenticatorOptions) {
+				o.issuer = undiscoverableIssuer
+				o.jwksURL = server.URL + "/jwks.json"
+			},
+			claims:            map[string]interface{}{"username": "pinny", "iss": undiscoverableIssuer},
+			wantResponse:      &authenticator.Response{User: &user.DefaultInfo{Name: "pinny"}},
+			wantAuthenticated: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			options := defaultOptions()
+			if tt.options != nil {
+				tt.options(&options)
+			}
+			a, err := newOIDCAuthenticator(options)
+			require.NoError(t, err)
+			t.Cleanup(a.Close)
+
+			claims := map[string]interface{}{
+				"iss": server.URL,
+				"aud": []string{"some-audience"},
+				"exp": time.Now().Add(time.Hour).Unix(),
+			}
+			for k, v := range tt.claims {
+				claims[k] = v
+			}
+			token := signToken(t, es256Key, jose.ES256, "es256", claims)
+			if tt.signWithES384 {
+				token = signToken(t, es384Key, jose.ES384, "es384", claims)
+			}
+			if tt.signToken != nil {
+				token = tt.signToken(t, claims)
+			}
+
+			// Loop for a while here to allow the authenticator to perform discovery asynchronously.
+			var (
+				rsp           *authenticator.Response
+				authenticated bool
+			)
+			_ = wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+				rsp, authenticated, err = a.AuthenticateToken(context.Background(), token)
+				return !isNotInitialized(err), nil
+			})
+			if tt.wantErrorRegexp != "" {
+				require.Error(t, err)
+				require.Regexp(t, tt.wantErrorRegexp, err.Error())
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantResponse, rsp)
+			require.Equal(t, tt.wantAuthenticated, authenticated)
+		})
+	}
+}
+
+func TestNewOIDCAuthenticator(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		options oidcAuthenticatorOptions
+		wantErr string
+	}{
+		{
+			name:    "issuer is not https",
+			options: oidcAuthenticatorOptions{issuer: "http://example.com", audiences: []string{"a"}, usernameClaim: "username"},
+			wantErr: `invalid issuer: "http://example.com" has invalid scheme "http", require 'https'`,
+		},
+		{
+			name:    "JWKS URL is not https",
+			options: oidcAuthenticatorOptions{issuer: "https://example.com", jwksURL: "http://example.com/jwks", audiences: []string{"a"}, usernameClaim: "username"},
+			wantErr: `invalid JWKS URL: "http://example.com/jwks" has invalid scheme "http", require 'https'`,
+		},
+		{
+			name:    "no username claim",
+			options: oidcAuthenticatorOptions{issuer: "https://example.com", audiences: []string{"a"}},
+			wantErr: "no username claim provided",
+		},
+		{
+			name:    "no audience",
+			options: oidcAuthenticatorOptions{issuer: "https://example.com", usernameClaim: "username"},
+			wantErr: "no audience provided",
+		},
+		{
+			name:    "unsupported signing algorithm",
+			options: oidcAuthenticatorOptions{issuer: "https://example.com", audiences: []string{"a"}, usernameClaim: "username", supportedSigningAlgs: []string{"HS256"}},
+			wantErr: `unsupported signing algorithm "HS256"`,
+		},
+		{
+			name:    "invalid CA bundle",
+			options: oidcAuthenticatorOptions{issuer: "https://example.com", audiences: []string{"a"}, usernameClaim: "username", caBundle: []byte("not pem")},
+			wantErr: "certificateAuthorityData is not valid PEM",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			a, err := newOIDCAuthenticator(tt.options)
+			require.EqualError(t, err, tt.wantErr)
+			require.Nil(t, a)
+		})
+	}
+}
+
+func signToken(t *testing.T, key interface{}, algo jose.SignatureAlgorithm, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	sig, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: algo, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", kid),
+	)
+	require.NoError(t, err)
+
+	token, err := jwt.Signed(sig).Claims(claims).CompactSerialize()
+	require.NoError(t, err)
+	return token
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"time"
 
 	"github.com/go-logr/logr"
 	k8sauthv1beta1 "k8s.io/api/authentication/v1beta1"
@@ -46,6 +47,17 @@ func New(cache *authncache.Cache, webhooks authinformers.WebhookAuthenticatorInf
 	)
 }
 
+// cacheValue is the authncache.Value which is stored for each WebhookAuthenticator.
+type cacheValue struct {
+	authenticator.Token
+	clientCertificateTTL time.Duration
+}
+
+// ClientCertificateTTL implements authncache.ClientCertificateTTLer.
+func (v *cacheValue) ClientCertificateTTL() time.Duration {
+	return v.clientCertificateTTL
+}
+
 type controller struct {
 	cache    *authncache.Cache
 	webhooks authinformers.WebhookAuthenticatorInformer
@@ -68,11 +80,16 @@ func (c *controller) Sync(ctx controllerlib.Context) error {
 		return fmt.Errorf("failed to build webhook config: %w", err)
 	}
 
+	value := &cacheValue{Token: webhookAuthenticator}
+	if obj.Spec.ClientCertificateTTL != nil {
+		value.clientCertificateTTL = obj.Spec.ClientCertificateTTL.Duration
+	}
+
 	c.cache.Store(authncache.Key{
 		APIGroup: auth1alpha1.GroupName,
 		Kind:     "WebhookAuthenticator",
 		Name:     ctx.Key.Name,
-	}, webhookAuthenticator)
+	}, value)
 	c.log.WithValues("webhook", klog.KObj(obj), "endpoint", obj.Spec.Endpoint).Info("added new webhook authenticator")
 	return nil
 }
@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -31,12 +32,13 @@ func TestController(t *testing.T) {
 	t.Parallel()
 
 	tests := []struct {
-		name             string
-		syncKey          controllerlib.Key
-		webhooks         []runtime.Object
-		wantErr          string
-		wantLogs         []string
-		wantCacheEntries int
+		name                     string
+		syncKey                  controllerlib.Key
+		webhooks                 []runtime.Object
+		wantErr                  string
+		wantLogs                 []string
+		wantCacheEntries         int
+		wantClientCertificateTTL time.Duration
 	}{
 		{
 			name:    "not found",
@@ -79,6 +81,26 @@ func TestController(t *testing.T) {
 			},
 			wantCacheEntries: 1,
 		},
+		{
+			name:    "valid webhook with a client certificate TTL",
+			syncKey: controllerlib.Key{Name: "test-name"},
+			webhooks: []runtime.Object{
+				&auth1alpha1.WebhookAuthenticator{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "test-name",
+					},
+					Spec: auth1alpha1.WebhookAuthenticatorSpec{
+						Endpoint:             "https://example.com",
+						ClientCertificateTTL: &metav1.Duration{Duration: time.Hour},
+					},
+				},
+			},
+			wantLogs: []string{
+				`webhookcachefiller-controller "level"=0 "msg"="added new webhook authenticator" "endpoint"="https://example.com" "webhook"={"name":"test-name"}`,
+			},
+			wantCacheEntries:         1,
+			wantClientCertificateTTL: time.Hour,
+		},
 	}
 	for _, tt := range tests {
 		tt := tt
@@ -107,6 +129,11 @@ func TestController(t *testing.T) {
 			}
 			require.Equal(t, tt.wantLogs, testLog.Lines())
 			require.Equal(t, tt.wantCacheEntries, len(cache.Keys()))
+			for _, key := range cache.Keys() {
+				ttler, ok := cache.Get(key).(authncache.ClientCertificateTTLer)
+				require.True(t, ok)
+				require.Equal(t, tt.wantClientCertificateTTL, ttler.ClientCertificateTTL())
+			}
 		})
 	}
 }
@@ -1074,7 +1074,7 @@ func TestImpersonatorConfigControllerSync(t *testing.T) {
 			signingCAKeyPEM, err = ca.PrivateKeyToPEM()
 			r.NoError(err)
 			signingCASecret = newSigningKeySecret(caSignerName, signingCACertPEM, signingCAKeyPEM)
-			validClientCert, err = ca.IssueClientCert("username", nil, time.Hour)
+			validClientCert, err = ca.IssueClientCert("username", nil, nil, time.Hour)
 			r.NoError(err)
 			testLog = testlogger.New(t)
 		})
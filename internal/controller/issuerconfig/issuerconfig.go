@@ -62,7 +62,9 @@ func mergeStrategy(configToUpdate *v1alpha1.CredentialIssuerStatus, strategy v1a
 // weights are a set of priorities for each strategy type.
 //nolint: gochecknoglobals
 var weights = map[v1alpha1.StrategyType]int{
-	v1alpha1.KubeClusterSigningCertificateStrategyType: 2, // most preferred strategy
+	v1alpha1.KubeClusterSigningCertificateStrategyType: 4, // most preferred strategy
+	v1alpha1.ExternalSigningCertificateStrategyType:    3,
+	v1alpha1.KubeCertificateSigningRequestStrategyType: 2,
 	v1alpha1.ImpersonationProxyStrategyType:            1,
 	// unknown strategy types will have weight 0 by default
 }
@@ -225,6 +225,8 @@ func TestMergeStrategy(t *testing.T) {
 func TestStrategySorting(t *testing.T) {
 	expected := []v1alpha1.CredentialIssuerStrategy{
 		{Type: v1alpha1.KubeClusterSigningCertificateStrategyType},
+		{Type: v1alpha1.ExternalSigningCertificateStrategyType},
+		{Type: v1alpha1.KubeCertificateSigningRequestStrategyType},
 		{Type: v1alpha1.ImpersonationProxyStrategyType},
 		{Type: "Type1"},
 		{Type: "Type2"},
@@ -50,7 +50,7 @@ const (
 	agentPodLabelValue = "v2"
 
 	ClusterInfoNamespace    = "kube-public"
-	clusterInfoName         = "cluster-info"
+	ClusterInfoName         = "cluster-info"
 	clusterInfoConfigMapKey = "kubeconfig"
 )
 
@@ -208,7 +208,7 @@ func newAgentController(
 			controllerlib.WithInformer(
 				kubePublicConfigMaps,
 				pinnipedcontroller.SimpleFilterWithSingletonQueue(func(obj metav1.Object) bool {
-					return obj.GetNamespace() == ClusterInfoNamespace && obj.GetName() == clusterInfoName
+					return obj.GetNamespace() == ClusterInfoNamespace && obj.GetName() == ClusterInfoName
 				}),
 				controllerlib.InformerOption{},
 			),
@@ -271,15 +271,15 @@ func (c *agentController) Sync(ctx controllerlib.Context) error {
 	}
 
 	// Load the Kubernetes API info from the kube-public/cluster-info ConfigMap.
-	configMap, err := c.kubePublicConfigMaps.Lister().ConfigMaps(ClusterInfoNamespace).Get(clusterInfoName)
+	configMap, err := c.kubePublicConfigMaps.Lister().ConfigMaps(ClusterInfoNamespace).Get(ClusterInfoName)
 	if err != nil {
-		err := fmt.Errorf("failed to get %s/%s configmap: %w", ClusterInfoNamespace, clusterInfoName, err)
+		err := fmt.Errorf("failed to get %s/%s configmap: %w", ClusterInfoNamespace, ClusterInfoName, err)
 		return c.failStrategyAndErr(ctx.Context, credIssuer, err, configv1alpha1.CouldNotGetClusterInfoStrategyReason)
 	}
 
-	apiInfo, err := c.extractAPIInfo(configMap)
+	apiInfo, err := ExtractAPIInfo(configMap, c.cfg.DiscoveryURLOverride)
 	if err != nil {
-		err := fmt.Errorf("could not extract Kubernetes API endpoint info from %s/%s configmap: %w", ClusterInfoNamespace, clusterInfoName, err)
+		err := fmt.Errorf("could not extract Kubernetes API endpoint info from %s/%s configmap: %w", ClusterInfoNamespace, ClusterInfoName, err)
 		return c.failStrategyAndErr(ctx.Context, credIssuer, err, configv1alpha1.CouldNotGetClusterInfoStrategyReason)
 	}
 
@@ -382,7 +382,9 @@ func (c *agentController) failStrategyAndErr(ctx context.Context, credIssuer *co
 	return utilerrors.NewAggregate([]error{err, updateErr})
 }
 
-func (c *agentController) extractAPIInfo(configMap *corev1.ConfigMap) (*configv1alpha1.TokenCredentialRequestAPIInfo, error) {
+// ExtractAPIInfo returns the Kubernetes API endpoint info from the kubeconfig in the given kube-public/cluster-info
+// ConfigMap. The discovered server URL is replaced by discoveryURLOverride when it is set.
+func ExtractAPIInfo(configMap *corev1.ConfigMap, discoveryURLOverride *string) (*configv1alpha1.TokenCredentialRequestAPIInfo, error) {
 	kubeConfigYAML, kubeConfigPresent := configMap.Data[clusterInfoConfigMapKey]
 	if !kubeConfigPresent {
 		return nil, fmt.Errorf("missing %q key", clusterInfoConfigMapKey)
@@ -399,8 +401,8 @@ func (c *agentController) extractAPIInfo(configMap *corev1.ConfigMap) (*configv1
 			Server:                   v.Server,
 			CertificateAuthorityData: base64.StdEncoding.EncodeToString(v.CertificateAuthorityData),
 		}
-		if c.cfg.DiscoveryURLOverride != nil {
-			result.Server = *c.cfg.DiscoveryURLOverride
+		if discoveryURLOverride != nil {
+			result.Server = *discoveryURLOverride
 		}
 		return result, nil
 	}
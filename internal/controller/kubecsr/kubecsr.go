@@ -0,0 +1,161 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package kubecsr provides a controller which reports the status of the KubeCertificateSigningRequest strategy,
+// which issues client certificates through the cluster's CertificateSigningRequest API.
+package kubecsr
+
+import (
+	"context"
+	"fmt"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/concierge/config/v1alpha1"
+	configv1alpha1informers "go.pinniped.dev/generated/latest/client/concierge/informers/externalversions/config/v1alpha1"
+	pinnipedcontroller "go.pinniped.dev/internal/controller"
+	"go.pinniped.dev/internal/controller/issuerconfig"
+	"go.pinniped.dev/internal/controller/kubecertagent"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/kubeclient"
+)
+
+type statusController struct {
+	credentialIssuerName string
+	discoveryURLOverride *string
+	client               *kubeclient.Client
+	kubePublicConfigMaps corev1informers.ConfigMapInformer
+	credentialIssuers    configv1alpha1informers.CredentialIssuerInformer
+	clock                clock.Clock
+}
+
+// NewStatusController returns a controller which checks that the Concierge is allowed to create, approve and delete
+// CertificateSigningRequests for the kubernetes.io/kube-apiserver-client signer, and which reports the result as the
+// KubeCertificateSigningRequest strategy of the CredentialIssuer.
+func NewStatusController(
+	credentialIssuerName string,
+	discoveryURLOverride *string,
+	client *kubeclient.Client,
+	kubePublicConfigMaps corev1informers.ConfigMapInformer,
+	credentialIssuers configv1alpha1informers.CredentialIssuerInformer,
+	clock clock.Clock,
+	withInitialEvent pinnipedcontroller.WithInitialEventOptionFunc,
+) controllerlib.Controller {
+	return controllerlib.New(
+		controllerlib.Config{
+			Name: "kube-csr-status-controller",
+			Syncer: &statusController{
+				credentialIssuerName: credentialIssuerName,
+				discoveryURLOverride: discoveryURLOverride,
+				client:               client,
+				kubePublicConfigMaps: kubePublicConfigMaps,
+				credentialIssuers:    credentialIssuers,
+				clock:                clock,
+			},
+		},
+		controllerlib.WithInformer(
+			kubePublicConfigMaps,
+			pinnipedcontroller.SimpleFilterWithSingletonQueue(func(obj metav1.Object) bool {
+				return obj.GetNamespace() == kubecertagent.ClusterInfoNamespace && obj.GetName() == kubecertagent.ClusterInfoName
+			}),
+			controllerlib.InformerOption{},
+		),
+		controllerlib.WithInformer(
+			credentialIssuers,
+			pinnipedcontroller.SimpleFilterWithSingletonQueue(func(obj metav1.Object) bool {
+				return obj.GetName() == credentialIssuerName
+			}),
+			controllerlib.InformerOption{},
+		),
+		// Permissions are not watched, so they are checked again whenever the informers resync.
+		withInitialEvent(controllerlib.Key{}),
+	)
+}
+
+// Sync implements controllerlib.Syncer.
+func (c *statusController) Sync(ctx controllerlib.Context) error {
+	credIssuer, err := c.credentialIssuers.Lister().Get(c.credentialIssuerName)
+	if err != nil {
+		return fmt.Errorf("could not get CredentialIssuer to update: %w", err)
+	}
+
+	configMap, err := c.kubePublicConfigMaps.Lister().ConfigMaps(kubecertagent.ClusterInfoNamespace).Get(kubecertagent.ClusterInfoName)
+	if err != nil {
+		err := fmt.Errorf("failed to get %s/%s configmap: %w", kubecertagent.ClusterInfoNamespace, kubecertagent.ClusterInfoName, err)
+		return c.failStrategyAndErr(ctx.Context, credIssuer, err, configv1alpha1.CouldNotGetClusterInfoStrategyReason)
+	}
+
+	apiInfo, err := kubecertagent.ExtractAPIInfo(configMap, c.discoveryURLOverride)
+	if err != nil {
+		err := fmt.Errorf("could not extract Kubernetes API endpoint info from %s/%s configmap: %w", kubecertagent.ClusterInfoNamespace, kubecertagent.ClusterInfoName, err)
+		return c.failStrategyAndErr(ctx.Context, credIssuer, err, configv1alpha1.CouldNotGetClusterInfoStrategyReason)
+	}
+
+	if err := c.checkPermissions(ctx.Context); err != nil {
+		return c.failStrategyAndErr(ctx.Context, credIssuer, err, configv1alpha1.SigningRequestsForbiddenStrategyReason)
+	}
+
+	return issuerconfig.Update(ctx.Context, c.client.PinnipedConcierge, credIssuer, configv1alpha1.CredentialIssuerStrategy{
+		Type:           configv1alpha1.KubeCertificateSigningRequestStrategyType,
+		Status:         configv1alpha1.SuccessStrategyStatus,
+		Reason:         configv1alpha1.SigningRequestsAllowedStrategyReason,
+		Message:        fmt.Sprintf("CertificateSigningRequests for the %s signer can be created and approved", certificatesv1.KubeAPIServerClientSignerName),
+		LastUpdateTime: metav1.NewTime(c.clock.Now()),
+		Frontend: &configv1alpha1.CredentialIssuerFrontend{
+			Type:                          configv1alpha1.TokenCredentialRequestAPIFrontendType,
+			TokenCredentialRequestAPIInfo: apiInfo,
+		},
+	})
+}
+
+// checkPermissions asks the API server whether the Concierge is allowed to do everything that is needed to get a
+// client certificate signed through a CertificateSigningRequest.
+func (c *statusController) checkPermissions(ctx context.Context) error {
+	required := []authorizationv1.ResourceAttributes{
+		{Verb: "create", Group: certificatesv1.GroupName, Resource: "certificatesigningrequests"},
+		{Verb: "delete", Group: certificatesv1.GroupName, Resource: "certificatesigningrequests"},
+		{Verb: "update", Group: certificatesv1.GroupName, Resource: "certificatesigningrequests", Subresource: "approval"},
+		{Verb: "approve", Group: certificatesv1.GroupName, Resource: "signers", Name: certificatesv1.KubeAPIServerClientSignerName},
+	}
+
+	for i := range required {
+		attributes := required[i]
+		review, err := c.client.Kubernetes.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: &attributes},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("could not check permission to %s %s: %w", attributes.Verb, describeResource(attributes), err)
+		}
+		if !review.Status.Allowed {
+			return fmt.Errorf("not allowed to %s %s", attributes.Verb, describeResource(attributes))
+		}
+	}
+	return nil
+}
+
+func describeResource(attributes authorizationv1.ResourceAttributes) string {
+	resource := attributes.Resource + "." + attributes.Group
+	if attributes.Subresource != "" {
+		resource += "/" + attributes.Subresource
+	}
+	if attributes.Name != "" {
+		resource += " " + attributes.Name
+	}
+	return resource
+}
+
+func (c *statusController) failStrategyAndErr(ctx context.Context, credIssuer *configv1alpha1.CredentialIssuer, err error, reason configv1alpha1.StrategyReason) error {
+	updateErr := issuerconfig.Update(ctx, c.client.PinnipedConcierge, credIssuer, configv1alpha1.CredentialIssuerStrategy{
+		Type:           configv1alpha1.KubeCertificateSigningRequestStrategyType,
+		Status:         configv1alpha1.ErrorStrategyStatus,
+		Reason:         reason,
+		Message:        err.Error(),
+		LastUpdateTime: metav1.NewTime(c.clock.Now()),
+	})
+	return utilerrors.NewAggregate([]error{err, updateErr})
+}
@@ -0,0 +1,254 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package kubecsr
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/informers"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	coretesting "k8s.io/client-go/testing"
+	"k8s.io/utils/pointer"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/concierge/config/v1alpha1"
+	conciergefake "go.pinniped.dev/generated/latest/client/concierge/clientset/versioned/fake"
+	conciergeinformers "go.pinniped.dev/generated/latest/client/concierge/informers/externalversions"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/here"
+	"go.pinniped.dev/internal/kubeclient"
+	"go.pinniped.dev/internal/testutil"
+)
+
+func TestStatusControllerInitialEvent(t *testing.T) {
+	observableWithInitialEventOption := testutil.NewObservableWithInitialEventOption()
+	kubeInformers := informers.NewSharedInformerFactory(kubefake.NewSimpleClientset(), 0)
+	conciergeInformers := conciergeinformers.NewSharedInformerFactory(conciergefake.NewSimpleClientset(), 0)
+	_ = NewStatusController(
+		"pinniped-concierge-config",
+		nil,
+		&kubeclient.Client{},
+		kubeInformers.Core().V1().ConfigMaps(),
+		conciergeInformers.Config().V1alpha1().CredentialIssuers(),
+		clock.RealClock{},
+		observableWithInitialEventOption.WithInitialEvent,
+	)
+	require.Equal(t, &controllerlib.Key{}, observableWithInitialEventOption.GetInitialEventKey())
+}
+
+func TestStatusControllerSync(t *testing.T) {
+	now := time.Date(2021, 8, 2, 10, 30, 0, 0, time.UTC)
+
+	credentialIssuer := &configv1alpha1.CredentialIssuer{
+		ObjectMeta: metav1.ObjectMeta{Name: "pinniped-concierge-config"},
+	}
+
+	validClusterInfoConfigMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-public", Name: "cluster-info"},
+		Data: map[string]string{"kubeconfig": here.Docf(`
+			kind: Config
+			apiVersion: v1
+			clusters:
+			- name: ""
+			  cluster:
+				certificate-authority-data: dGVzdC1rdWJlcm5ldGVzLWNh # "test-kubernetes-ca"
+				server: https://test-kubernetes-endpoint.example.com
+			`),
+		},
+	}
+
+	// allowAccessReviews answers all SelfSubjectAccessReviews, denying those which match the given verb.
+	allowAccessReviews := func(deniedVerb string) func(client *kubefake.Clientset) {
+		return func(client *kubefake.Clientset) {
+			client.PrependReactor("create", "selfsubjectaccessreviews", func(action coretesting.Action) (bool, runtime.Object, error) {
+				review := action.(coretesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview).DeepCopy()
+				review.Status.Allowed = review.Spec.ResourceAttributes.Verb != deniedVerb
+				return true, review, nil
+			})
+		}
+	}
+
+	tests := []struct {
+		name                 string
+		kubeObjects          []runtime.Object
+		pinnipedObjects      []runtime.Object
+		addKubeReactions     func(*kubefake.Clientset)
+		discoveryURLOverride *string
+		wantErr              string
+		wantStrategy         *configv1alpha1.CredentialIssuerStrategy
+	}{
+		{
+			name:    "no CredentialIssuer",
+			wantErr: `could not get CredentialIssuer to update: credentialissuer.config.concierge.pinniped.dev "pinniped-concierge-config" not found`,
+		},
+		{
+			name:            "no cluster-info ConfigMap",
+			pinnipedObjects: []runtime.Object{credentialIssuer},
+			wantErr:         `failed to get kube-public/cluster-info configmap: configmap "cluster-info" not found`,
+			wantStrategy: &configv1alpha1.CredentialIssuerStrategy{
+				Type:           configv1alpha1.KubeCertificateSigningRequestStrategyType,
+				Status:         configv1alpha1.ErrorStrategyStatus,
+				Reason:         configv1alpha1.CouldNotGetClusterInfoStrategyReason,
+				Message:        `failed to get kube-public/cluster-info configmap: configmap "cluster-info" not found`,
+				LastUpdateTime: metav1.NewTime(now),
+			},
+		},
+		{
+			name:            "invalid cluster-info ConfigMap",
+			pinnipedObjects: []runtime.Object{credentialIssuer},
+			kubeObjects: []runtime.Object{&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "kube-public", Name: "cluster-info"},
+			}},
+			wantErr: `could not extract Kubernetes API endpoint info from kube-public/cluster-info configmap: missing "kubeconfig" key`,
+			wantStrategy: &configv1alpha1.CredentialIssuerStrategy{
+				Type:           configv1alpha1.KubeCertificateSigningRequestStrategyType,
+				Status:         configv1alpha1.ErrorStrategyStatus,
+				Reason:         configv1alpha1.CouldNotGetClusterInfoStrategyReason,
+				Message:        `could not extract Kubernetes API endpoint info from kube-public/cluster-info configmap: missing "kubeconfig" key`,
+				LastUpdateTime: metav1.NewTime(now),
+			},
+		},
+		{
+			name:            "access reviews fail",
+			pinnipedObjects: []runtime.Object{credentialIssuer},
+			kubeObjects:     []runtime.Object{validClusterInfoConfigMap},
+			addKubeReactions: func(client *kubefake.Clientset) {
+				client.PrependReactor("create", "selfsubjectaccessreviews", func(action coretesting.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("some access review error")
+				})
+			},
+			wantErr: "could not check permission to create certificatesigningrequests.certificates.k8s.io: some access review error",
+			wantStrategy: &configv1alpha1.CredentialIssuerStrategy{
+				Type:           configv1alpha1.KubeCertificateSigningRequestStrategyType,
+				Status:         configv1alpha1.ErrorStrategyStatus,
+				Reason:         configv1alpha1.SigningRequestsForbiddenStrategyReason,
+				Message:        "could not check permission to create certificatesigningrequests.certificates.k8s.io: some access review error",
+				LastUpdateTime: metav1.NewTime(now),
+			},
+		},
+		{
+			name:             "not allowed to approve for the signer",
+			pinnipedObjects:  []runtime.Object{credentialIssuer},
+			kubeObjects:      []runtime.Object{validClusterInfoConfigMap},
+			addKubeReactions: allowAccessReviews("approve"),
+			wantErr:          "not allowed to approve signers.certificates.k8s.io kubernetes.io/kube-apiserver-client",
+			wantStrategy: &configv1alpha1.CredentialIssuerStrategy{
+				Type:           configv1alpha1.KubeCertificateSigningRequestStrategyType,
+				Status:         configv1alpha1.ErrorStrategyStatus,
+				Reason:         configv1alpha1.SigningRequestsForbiddenStrategyReason,
+				Message:        "not allowed to approve signers.certificates.k8s.io kubernetes.io/kube-apiserver-client",
+				LastUpdateTime: metav1.NewTime(now),
+			},
+		},
+		{
+			name:             "not allowed to update the approval subresource",
+			pinnipedObjects:  []runtime.Object{credentialIssuer},
+			kubeObjects:      []runtime.Object{validClusterInfoConfigMap},
+			addKubeReactions: allowAccessReviews("update"),
+			wantErr:          "not allowed to update certificatesigningrequests.certificates.k8s.io/approval",
+			wantStrategy: &configv1alpha1.CredentialIssuerStrategy{
+				Type:           configv1alpha1.KubeCertificateSigningRequestStrategyType,
+				Status:         configv1alpha1.ErrorStrategyStatus,
+				Reason:         configv1alpha1.SigningRequestsForbiddenStrategyReason,
+				Message:        "not allowed to update certificatesigningrequests.certificates.k8s.io/approval",
+				LastUpdateTime: metav1.NewTime(now),
+			},
+		},
+		{
+			name:             "success",
+			pinnipedObjects:  []runtime.Object{credentialIssuer},
+			kubeObjects:      []runtime.Object{validClusterInfoConfigMap},
+			addKubeReactions: allowAccessReviews(""),
+			wantStrategy: &configv1alpha1.CredentialIssuerStrategy{
+				Type:           configv1alpha1.KubeCertificateSigningRequestStrategyType,
+				Status:         configv1alpha1.SuccessStrategyStatus,
+				Reason:         configv1alpha1.SigningRequestsAllowedStrategyReason,
+				Message:        "CertificateSigningRequests for the kubernetes.io/kube-apiserver-client signer can be created and approved",
+				LastUpdateTime: metav1.NewTime(now),
+				Frontend: &configv1alpha1.CredentialIssuerFrontend{
+					Type: configv1alpha1.TokenCredentialRequestAPIFrontendType,
+					TokenCredentialRequestAPIInfo: &configv1alpha1.TokenCredentialRequestAPIInfo{
+						Server:                   "https://test-kubernetes-endpoint.example.com",
+						CertificateAuthorityData: "dGVzdC1rdWJlcm5ldGVzLWNh",
+					},
+				},
+			},
+		},
+		{
+			name:                 "success with a discovery URL override",
+			pinnipedObjects:      []runtime.Object{credentialIssuer},
+			kubeObjects:          []runtime.Object{validClusterInfoConfigMap},
+			addKubeReactions:     allowAccessReviews(""),
+			discoveryURLOverride: pointer.StringPtr("https://overridden-server.example.com/some/path"),
+			wantStrategy: &configv1alpha1.CredentialIssuerStrategy{
+				Type:           configv1alpha1.KubeCertificateSigningRequestStrategyType,
+				Status:         configv1alpha1.SuccessStrategyStatus,
+				Reason:         configv1alpha1.SigningRequestsAllowedStrategyReason,
+				Message:        "CertificateSigningRequests for the kubernetes.io/kube-apiserver-client signer can be created and approved",
+				LastUpdateTime: metav1.NewTime(now),
+				Frontend: &configv1alpha1.CredentialIssuerFrontend{
+					Type: configv1alpha1.TokenCredentialRequestAPIFrontendType,
+					TokenCredentialRequestAPIInfo: &configv1alpha1.TokenCredentialRequestAPIInfo{
+						Server:                   "https://overridden-server.example.com/some/path",
+						CertificateAuthorityData: "dGVzdC1rdWJlcm5ldGVzLWNh",
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			conciergeClientset := conciergefake.NewSimpleClientset(tt.pinnipedObjects...)
+			conciergeInformers := conciergeinformers.NewSharedInformerFactory(conciergeClientset, 0)
+
+			kubeClientset := kubefake.NewSimpleClientset(tt.kubeObjects...)
+			if tt.addKubeReactions != nil {
+				tt.addKubeReactions(kubeClientset)
+			}
+			kubeInformers := informers.NewSharedInformerFactory(kubeClientset, 0)
+
+			controller := NewStatusController(
+				credentialIssuer.Name,
+				tt.discoveryURLOverride,
+				&kubeclient.Client{Kubernetes: kubeClientset, PinnipedConcierge: conciergeClientset},
+				kubeInformers.Core().V1().ConfigMaps(),
+				conciergeInformers.Config().V1alpha1().CredentialIssuers(),
+				clock.NewFakeClock(now),
+				controllerlib.WithInitialEvent,
+			)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kubeInformers.Start(ctx.Done())
+			conciergeInformers.Start(ctx.Done())
+			controllerlib.TestRunSynchronously(t, controller)
+
+			err := controllerlib.TestSync(t, controller, controllerlib.Context{Context: ctx})
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			if tt.wantStrategy != nil {
+				credIssuer, err := conciergeClientset.ConfigV1alpha1().CredentialIssuers().Get(ctx, credentialIssuer.Name, metav1.GetOptions{})
+				require.NoError(t, err)
+				require.Len(t, credIssuer.Status.Strategies, 1, "expected a single strategy in the CredentialIssuer")
+				require.Equal(t, tt.wantStrategy, &credIssuer.Status.Strategies[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package signingcert provides a controller which loads an externally provided CA certificate and key, which are
+// used to sign the client certificates of TokenCredentialRequests, and which reports the status of the
+// ExternalSigningCertificate strategy.
+package signingcert
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/concierge/config/v1alpha1"
+	pinnipedclientset "go.pinniped.dev/generated/latest/client/concierge/clientset/versioned"
+	configv1alpha1informers "go.pinniped.dev/generated/latest/client/concierge/informers/externalversions/config/v1alpha1"
+	pinnipedcontroller "go.pinniped.dev/internal/controller"
+	"go.pinniped.dev/internal/controller/issuerconfig"
+	"go.pinniped.dev/internal/controller/kubecertagent"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/dynamiccert"
+)
+
+type signingCertController struct {
+	namespace            string
+	credentialIssuerName string
+	discoveryURLOverride *string
+	pinnipedAPIClient    pinnipedclientset.Interface
+	credentialIssuers    configv1alpha1informers.CredentialIssuerInformer
+	secrets              corev1informers.SecretInformer
+	kubePublicConfigMaps corev1informers.ConfigMapInformer
+	signingCertProvider  dynamiccert.Private
+	clock                clock.Clock
+}
+
+// NewController returns a controller which loads the CA certificate and key from the Secret named by the
+// spec.signingCertificate field of the CredentialIssuer into signingCertProvider, and which reports their validity
+// as the ExternalSigningCertificate strategy of the CredentialIssuer.
+func NewController(
+	namespace string,
+	credentialIssuerName string,
+	discoveryURLOverride *string,
+	pinnipedAPIClient pinnipedclientset.Interface,
+	credentialIssuers configv1alpha1informers.CredentialIssuerInformer,
+	secrets corev1informers.SecretInformer,
+	kubePublicConfigMaps corev1informers.ConfigMapInformer,
+	signingCertProvider dynamiccert.Private,
+	clock clock.Clock,
+	withInitialEvent pinnipedcontroller.WithInitialEventOptionFunc,
+) controllerlib.Controller {
+	return controllerlib.New(
+		controllerlib.Config{
+			Name: "signing-cert-controller",
+			Syncer: &signingCertController{
+				namespace:            namespace,
+				credentialIssuerName: credentialIssuerName,
+				discoveryURLOverride: discoveryURLOverride,
+				pinnipedAPIClient:    pinnipedAPIClient,
+				credentialIssuers:    credentialIssuers,
+				secrets:              secrets,
+				kubePublicConfigMaps: kubePublicConfigMaps,
+				signingCertProvider:  signingCertProvider,
+				clock:                clock,
+			},
+		},
+		controllerlib.WithInformer(
+			credentialIssuers,
+			pinnipedcontroller.SimpleFilterWithSingletonQueue(func(obj metav1.Object) bool {
+				return obj.GetName() == credentialIssuerName
+			}),
+			controllerlib.InformerOption{},
+		),
+		// The name of the Secret is configured in the CredentialIssuer, so watch all Secrets in our namespace.
+		controllerlib.WithInformer(
+			secrets,
+			pinnipedcontroller.SimpleFilterWithSingletonQueue(func(obj metav1.Object) bool {
+				return obj.GetNamespace() == namespace
+			}),
+			controllerlib.InformerOption{},
+		),
+		controllerlib.WithInformer(
+			kubePublicConfigMaps,
+			pinnipedcontroller.SimpleFilterWithSingletonQueue(func(obj metav1.Object) bool {
+				return obj.GetNamespace() == kubecertagent.ClusterInfoNamespace && obj.GetName() == kubecertagent.ClusterInfoName
+			}),
+			controllerlib.InformerOption{},
+		),
+		withInitialEvent(controllerlib.Key{}),
+	)
+}
+
+// Sync implements controllerlib.Syncer.
+func (c *signingCertController) Sync(ctx controllerlib.Context) error {
+	credIssuer, err := c.credentialIssuers.Lister().Get(c.credentialIssuerName)
+	if err != nil {
+		return fmt.Errorf("could not get CredentialIssuer to update: %w", err)
+	}
+
+	if credIssuer.Spec.SigningCertificate == nil {
+		c.signingCertProvider.UnsetCertKeyContent()
+		return c.disableStrategy(ctx.Context, credIssuer)
+	}
+	secretName := credIssuer.Spec.SigningCertificate.SecretName
+
+	secret, err := c.secrets.Lister().Secrets(c.namespace).Get(secretName)
+	if err != nil {
+		c.signingCertProvider.UnsetCertKeyContent()
+		err := fmt.Errorf("failed to get %s/%s secret: %w", c.namespace, secretName, err)
+		return c.failStrategyAndErr(ctx.Context, credIssuer, err, configv1alpha1.CouldNotFetchKeyStrategyReason)
+	}
+
+	notAfter, err := c.loadSigningCert(ctx, secret)
+	if err != nil {
+		c.signingCertProvider.UnsetCertKeyContent()
+		return c.failStrategyAndErr(ctx.Context, credIssuer, err, configv1alpha1.InvalidSigningCertificateStrategyReason)
+	}
+
+	configMap, err := c.kubePublicConfigMaps.Lister().ConfigMaps(kubecertagent.ClusterInfoNamespace).Get(kubecertagent.ClusterInfoName)
+	if err != nil {
+		err := fmt.Errorf("failed to get %s/%s configmap: %w", kubecertagent.ClusterInfoNamespace, kubecertagent.ClusterInfoName, err)
+		return c.failStrategyAndErr(ctx.Context, credIssuer, err, configv1alpha1.CouldNotGetClusterInfoStrategyReason)
+	}
+
+	apiInfo, err := kubecertagent.ExtractAPIInfo(configMap, c.discoveryURLOverride)
+	if err != nil {
+		err := fmt.Errorf("could not extract Kubernetes API endpoint info from %s/%s configmap: %w", kubecertagent.ClusterInfoNamespace, kubecertagent.ClusterInfoName, err)
+		return c.failStrategyAndErr(ctx.Context, credIssuer, err, configv1alpha1.CouldNotGetClusterInfoStrategyReason)
+	}
+
+	return issuerconfig.Update(ctx.Context, c.pinnipedAPIClient, credIssuer, configv1alpha1.CredentialIssuerStrategy{
+		Type:           configv1alpha1.ExternalSigningCertificateStrategyType,
+		Status:         configv1alpha1.SuccessStrategyStatus,
+		Reason:         configv1alpha1.FetchedKeyStrategyReason,
+		Message:        fmt.Sprintf("signing certificate was loaded from %s/%s secret and is valid until %s", c.namespace, secretName, notAfter.UTC().Format(time.RFC3339)),
+		LastUpdateTime: metav1.NewTime(c.clock.Now()),
+		Frontend: &configv1alpha1.CredentialIssuerFrontend{
+			Type:                          configv1alpha1.TokenCredentialRequestAPIFrontendType,
+			TokenCredentialRequestAPIInfo: apiInfo,
+		},
+	})
+}
+
+// loadSigningCert validates the CA certificate and key in the given Secret and loads them into the signing cert
+// provider. It returns when the certificate expires, and it requeues the key so that the expiry is noticed.
+func (c *signingCertController) loadSigningCert(ctx controllerlib.Context, secret *corev1.Secret) (time.Time, error) {
+	certPEM, keyPEM := secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return time.Time{}, fmt.Errorf("%s/%s secret does not contain a PEM certificate in key %q", secret.Namespace, secret.Name, corev1.TLSCertKey)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s/%s secret contains an invalid certificate in key %q: %w", secret.Namespace, secret.Name, corev1.TLSCertKey, err)
+	}
+
+	now := c.clock.Now()
+	if now.Before(cert.NotBefore) {
+		ctx.Queue.AddAfter(ctx.Key, cert.NotBefore.Sub(now))
+		return time.Time{}, fmt.Errorf("signing certificate in %s/%s secret is not valid until %s", secret.Namespace, secret.Name, cert.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if now.After(cert.NotAfter) {
+		return time.Time{}, fmt.Errorf("signing certificate in %s/%s secret expired at %s", secret.Namespace, secret.Name, cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	if err := c.signingCertProvider.SetCertKeyContent(certPEM, keyPEM); err != nil {
+		return time.Time{}, fmt.Errorf("failed to load signing certificate and key from %s/%s secret: %w", secret.Namespace, secret.Name, err)
+	}
+
+	// Sync again when the certificate expires, so that the strategy status reports it.
+	ctx.Queue.AddAfter(ctx.Key, cert.NotAfter.Sub(now))
+	return cert.NotAfter, nil
+}
+
+// disableStrategy reports that no signing certificate is configured, but only when the strategy was reported before,
+// so that the CredentialIssuer of clusters which never used this strategy is left alone.
+func (c *signingCertController) disableStrategy(ctx context.Context, credIssuer *configv1alpha1.CredentialIssuer) error {
+	for _, strategy := range credIssuer.Status.Strategies {
+		if strategy.Type == configv1alpha1.ExternalSigningCertificateStrategyType {
+			return issuerconfig.Update(ctx, c.pinnipedAPIClient, credIssuer, configv1alpha1.CredentialIssuerStrategy{
+				Type:           configv1alpha1.ExternalSigningCertificateStrategyType,
+				Status:         configv1alpha1.ErrorStrategyStatus,
+				Reason:         configv1alpha1.DisabledStrategyReason,
+				Message:        "no signing certificate is configured in spec.signingCertificate",
+				LastUpdateTime: metav1.NewTime(c.clock.Now()),
+			})
+		}
+	}
+	return nil
+}
+
+func (c *signingCertController) failStrategyAndErr(ctx context.Context, credIssuer *configv1alpha1.CredentialIssuer, err error, reason configv1alpha1.StrategyReason) error {
+	updateErr := issuerconfig.Update(ctx, c.pinnipedAPIClient, credIssuer, configv1alpha1.CredentialIssuerStrategy{
+		Type:           configv1alpha1.ExternalSigningCertificateStrategyType,
+		Status:         configv1alpha1.ErrorStrategyStatus,
+		Reason:         reason,
+		Message:        err.Error(),
+		LastUpdateTime: metav1.NewTime(c.clock.Now()),
+	})
+	return utilerrors.NewAggregate([]error{err, updateErr})
+}
@@ -0,0 +1,326 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package signingcert
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/informers"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/concierge/config/v1alpha1"
+	conciergefake "go.pinniped.dev/generated/latest/client/concierge/clientset/versioned/fake"
+	conciergeinformers "go.pinniped.dev/generated/latest/client/concierge/informers/externalversions"
+	"go.pinniped.dev/internal/certauthority"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/dynamiccert"
+	"go.pinniped.dev/internal/here"
+	"go.pinniped.dev/internal/testutil"
+)
+
+func TestControllerInitialEvent(t *testing.T) {
+	observableWithInitialEventOption := testutil.NewObservableWithInitialEventOption()
+	kubeInformers := informers.NewSharedInformerFactory(kubefake.NewSimpleClientset(), 0)
+	conciergeInformers := conciergeinformers.NewSharedInformerFactory(conciergefake.NewSimpleClientset(), 0)
+	_ = NewController(
+		"concierge",
+		"pinniped-concierge-config",
+		nil,
+		conciergefake.NewSimpleClientset(),
+		conciergeInformers.Config().V1alpha1().CredentialIssuers(),
+		kubeInformers.Core().V1().Secrets(),
+		kubeInformers.Core().V1().ConfigMaps(),
+		dynamiccert.NewCA("test-signing-cert"),
+		clock.RealClock{},
+		observableWithInitialEventOption.WithInitialEvent,
+	)
+	require.Equal(t, &controllerlib.Key{}, observableWithInitialEventOption.GetInitialEventKey())
+}
+
+func TestControllerSync(t *testing.T) {
+	ca, err := certauthority.New("test signing CA", 24*time.Hour)
+	require.NoError(t, err)
+	caCertPEM := ca.Bundle()
+	caKeyPEM, err := ca.PrivateKeyToPEM()
+	require.NoError(t, err)
+	block, _ := pem.Decode(caCertPEM)
+	caCert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	now := caCert.NotBefore.Add(time.Hour)
+	notAfter := caCert.NotAfter.UTC().Format(time.RFC3339)
+
+	otherCA, err := certauthority.New("other CA", time.Hour)
+	require.NoError(t, err)
+	otherCAKeyPEM, err := otherCA.PrivateKeyToPEM()
+	require.NoError(t, err)
+
+	servingCertPEM, servingKeyPEM, err := ca.IssueServerCertPEM([]string{"example.com"}, nil, time.Hour)
+	require.NoError(t, err)
+
+	credentialIssuer := &configv1alpha1.CredentialIssuer{
+		ObjectMeta: metav1.ObjectMeta{Name: "pinniped-concierge-config"},
+		Spec: configv1alpha1.CredentialIssuerSpec{
+			SigningCertificate: &configv1alpha1.SigningCertificateSpec{SecretName: "some-signing-ca"},
+		},
+	}
+
+	unconfiguredCredentialIssuer := &configv1alpha1.CredentialIssuer{
+		ObjectMeta: metav1.ObjectMeta{Name: "pinniped-concierge-config"},
+	}
+
+	previouslyConfiguredCredentialIssuer := unconfiguredCredentialIssuer.DeepCopy()
+	previouslyConfiguredCredentialIssuer.Status.Strategies = []configv1alpha1.CredentialIssuerStrategy{{
+		Type:           configv1alpha1.ExternalSigningCertificateStrategyType,
+		Status:         configv1alpha1.SuccessStrategyStatus,
+		Reason:         configv1alpha1.FetchedKeyStrategyReason,
+		Message:        "some old message",
+		LastUpdateTime: metav1.NewTime(now.Add(-time.Hour)),
+	}}
+
+	signingSecret := func(certPEM, keyPEM []byte) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "concierge", Name: "some-signing-ca"},
+			Type:       corev1.SecretTypeTLS,
+			Data:       map[string][]byte{corev1.TLSCertKey: certPEM, corev1.TLSPrivateKeyKey: keyPEM},
+		}
+	}
+
+	validClusterInfoConfigMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-public", Name: "cluster-info"},
+		Data: map[string]string{"kubeconfig": here.Docf(`
+			kind: Config
+			apiVersion: v1
+			clusters:
+			- name: ""
+			  cluster:
+				certificate-authority-data: dGVzdC1rdWJlcm5ldGVzLWNh # "test-kubernetes-ca"
+				server: https://test-kubernetes-endpoint.example.com
+			`),
+		},
+	}
+
+	tests := []struct {
+		name            string
+		now             time.Time
+		kubeObjects     []runtime.Object
+		pinnipedObjects []runtime.Object
+		wantErr         string
+		wantStrategies  []configv1alpha1.CredentialIssuerStrategy
+		wantCertPEM     []byte
+		wantRequeue     time.Duration
+	}{
+		{
+			name:        "no CredentialIssuer",
+			wantCertPEM: otherCA.Bundle(),
+			wantErr:     `could not get CredentialIssuer to update: credentialissuer.config.concierge.pinniped.dev "pinniped-concierge-config" not found`,
+		},
+		{
+			name:            "no signing certificate is configured",
+			pinnipedObjects: []runtime.Object{unconfiguredCredentialIssuer},
+		},
+		{
+			name:            "a signing certificate is no longer configured",
+			pinnipedObjects: []runtime.Object{previouslyConfiguredCredentialIssuer},
+			wantStrategies: []configv1alpha1.CredentialIssuerStrategy{{
+				Type:           configv1alpha1.ExternalSigningCertificateStrategyType,
+				Status:         configv1alpha1.ErrorStrategyStatus,
+				Reason:         configv1alpha1.DisabledStrategyReason,
+				Message:        "no signing certificate is configured in spec.signingCertificate",
+				LastUpdateTime: metav1.NewTime(now),
+			}},
+		},
+		{
+			name:            "the Secret does not exist",
+			pinnipedObjects: []runtime.Object{credentialIssuer},
+			wantErr:         `failed to get concierge/some-signing-ca secret: secret "some-signing-ca" not found`,
+			wantStrategies: []configv1alpha1.CredentialIssuerStrategy{{
+				Type:           configv1alpha1.ExternalSigningCertificateStrategyType,
+				Status:         configv1alpha1.ErrorStrategyStatus,
+				Reason:         configv1alpha1.CouldNotFetchKeyStrategyReason,
+				Message:        `failed to get concierge/some-signing-ca secret: secret "some-signing-ca" not found`,
+				LastUpdateTime: metav1.NewTime(now),
+			}},
+		},
+		{
+			name:            "the Secret does not contain a certificate",
+			pinnipedObjects: []runtime.Object{credentialIssuer},
+			kubeObjects:     []runtime.Object{signingSecret(nil, caKeyPEM)},
+			wantErr:         `concierge/some-signing-ca secret does not contain a PEM certificate in key "tls.crt"`,
+			wantStrategies: []configv1alpha1.CredentialIssuerStrategy{{
+				Type:           configv1alpha1.ExternalSigningCertificateStrategyType,
+				Status:         configv1alpha1.ErrorStrategyStatus,
+				Reason:         configv1alpha1.InvalidSigningCertificateStrategyReason,
+				Message:        `concierge/some-signing-ca secret does not contain a PEM certificate in key "tls.crt"`,
+				LastUpdateTime: metav1.NewTime(now),
+			}},
+		},
+		{
+			name:            "the certificate is not yet valid",
+			now:             caCert.NotBefore.Add(-time.Hour),
+			pinnipedObjects: []runtime.Object{credentialIssuer},
+			kubeObjects:     []runtime.Object{signingSecret(caCertPEM, caKeyPEM)},
+			wantErr:         "signing certificate in concierge/some-signing-ca secret is not valid until " + caCert.NotBefore.UTC().Format(time.RFC3339),
+			wantStrategies: []configv1alpha1.CredentialIssuerStrategy{{
+				Type:           configv1alpha1.ExternalSigningCertificateStrategyType,
+				Status:         configv1alpha1.ErrorStrategyStatus,
+				Reason:         configv1alpha1.InvalidSigningCertificateStrategyReason,
+				Message:        "signing certificate in concierge/some-signing-ca secret is not valid until " + caCert.NotBefore.UTC().Format(time.RFC3339),
+				LastUpdateTime: metav1.NewTime(caCert.NotBefore.Add(-time.Hour)),
+			}},
+			wantRequeue: time.Hour,
+		},
+		{
+			name:            "the certificate has expired",
+			now:             caCert.NotAfter.Add(time.Minute),
+			pinnipedObjects: []runtime.Object{credentialIssuer},
+			kubeObjects:     []runtime.Object{signingSecret(caCertPEM, caKeyPEM)},
+			wantErr:         "signing certificate in concierge/some-signing-ca secret expired at " + notAfter,
+			wantStrategies: []configv1alpha1.CredentialIssuerStrategy{{
+				Type:           configv1alpha1.ExternalSigningCertificateStrategyType,
+				Status:         configv1alpha1.ErrorStrategyStatus,
+				Reason:         configv1alpha1.InvalidSigningCertificateStrategyReason,
+				Message:        "signing certificate in concierge/some-signing-ca secret expired at " + notAfter,
+				LastUpdateTime: metav1.NewTime(caCert.NotAfter.Add(time.Minute)),
+			}},
+		},
+		{
+			name:            "the key does not match the certificate",
+			pinnipedObjects: []runtime.Object{credentialIssuer},
+			kubeObjects:     []runtime.Object{signingSecret(caCertPEM, otherCAKeyPEM)},
+			wantErr:         "failed to load signing certificate and key from concierge/some-signing-ca secret: test-signing-cert: attempt to set invalid key pair: tls: private key does not match public key",
+			wantStrategies: []configv1alpha1.CredentialIssuerStrategy{{
+				Type:           configv1alpha1.ExternalSigningCertificateStrategyType,
+				Status:         configv1alpha1.ErrorStrategyStatus,
+				Reason:         configv1alpha1.InvalidSigningCertificateStrategyReason,
+				Message:        "failed to load signing certificate and key from concierge/some-signing-ca secret: test-signing-cert: attempt to set invalid key pair: tls: private key does not match public key",
+				LastUpdateTime: metav1.NewTime(now),
+			}},
+		},
+		{
+			name:            "the certificate is not a CA",
+			pinnipedObjects: []runtime.Object{credentialIssuer},
+			kubeObjects:     []runtime.Object{signingSecret(servingCertPEM, servingKeyPEM)},
+			wantErr:         "failed to load signing certificate and key from concierge/some-signing-ca secret: test-signing-cert: attempt to set x509 cert with unexpected IsCA=false",
+			wantStrategies: []configv1alpha1.CredentialIssuerStrategy{{
+				Type:           configv1alpha1.ExternalSigningCertificateStrategyType,
+				Status:         configv1alpha1.ErrorStrategyStatus,
+				Reason:         configv1alpha1.InvalidSigningCertificateStrategyReason,
+				Message:        "failed to load signing certificate and key from concierge/some-signing-ca secret: test-signing-cert: attempt to set x509 cert with unexpected IsCA=false",
+				LastUpdateTime: metav1.NewTime(now),
+			}},
+		},
+		{
+			name:            "the certificate is loaded but there is no cluster-info ConfigMap",
+			pinnipedObjects: []runtime.Object{credentialIssuer},
+			kubeObjects:     []runtime.Object{signingSecret(caCertPEM, caKeyPEM)},
+			wantErr:         `failed to get kube-public/cluster-info configmap: configmap "cluster-info" not found`,
+			wantStrategies: []configv1alpha1.CredentialIssuerStrategy{{
+				Type:           configv1alpha1.ExternalSigningCertificateStrategyType,
+				Status:         configv1alpha1.ErrorStrategyStatus,
+				Reason:         configv1alpha1.CouldNotGetClusterInfoStrategyReason,
+				Message:        `failed to get kube-public/cluster-info configmap: configmap "cluster-info" not found`,
+				LastUpdateTime: metav1.NewTime(now),
+			}},
+			wantCertPEM: caCertPEM,
+			wantRequeue: caCert.NotAfter.Sub(now),
+		},
+		{
+			name:            "success",
+			pinnipedObjects: []runtime.Object{credentialIssuer},
+			kubeObjects:     []runtime.Object{signingSecret(caCertPEM, caKeyPEM), validClusterInfoConfigMap},
+			wantStrategies: []configv1alpha1.CredentialIssuerStrategy{{
+				Type:           configv1alpha1.ExternalSigningCertificateStrategyType,
+				Status:         configv1alpha1.SuccessStrategyStatus,
+				Reason:         configv1alpha1.FetchedKeyStrategyReason,
+				Message:        "signing certificate was loaded from concierge/some-signing-ca secret and is valid until " + notAfter,
+				LastUpdateTime: metav1.NewTime(now),
+				Frontend: &configv1alpha1.CredentialIssuerFrontend{
+					Type: configv1alpha1.TokenCredentialRequestAPIFrontendType,
+					TokenCredentialRequestAPIInfo: &configv1alpha1.TokenCredentialRequestAPIInfo{
+						Server:                   "https://test-kubernetes-endpoint.example.com",
+						CertificateAuthorityData: "dGVzdC1rdWJlcm5ldGVzLWNh",
+					},
+				},
+			}},
+			wantCertPEM: caCertPEM,
+			wantRequeue: caCert.NotAfter.Sub(now),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			conciergeClientset := conciergefake.NewSimpleClientset(tt.pinnipedObjects...)
+			conciergeInformers := conciergeinformers.NewSharedInformerFactory(conciergeClientset, 0)
+			kubeInformers := informers.NewSharedInformerFactory(kubefake.NewSimpleClientset(tt.kubeObjects...), 0)
+
+			// Start with a previously loaded certificate, which must be removed unless the new one is loaded.
+			signingCertProvider := dynamiccert.NewCA("test-signing-cert")
+			require.NoError(t, signingCertProvider.SetCertKeyContent(otherCA.Bundle(), otherCAKeyPEM))
+
+			syncNow := now
+			if !tt.now.IsZero() {
+				syncNow = tt.now
+			}
+
+			controller := NewController(
+				"concierge",
+				"pinniped-concierge-config",
+				nil,
+				conciergeClientset,
+				conciergeInformers.Config().V1alpha1().CredentialIssuers(),
+				kubeInformers.Core().V1().Secrets(),
+				kubeInformers.Core().V1().ConfigMaps(),
+				signingCertProvider,
+				clock.NewFakeClock(syncNow),
+				controllerlib.WithInitialEvent,
+			)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kubeInformers.Start(ctx.Done())
+			conciergeInformers.Start(ctx.Done())
+			controllerlib.TestRunSynchronously(t, controller)
+
+			queue := &testQueue{}
+			err := controllerlib.TestSync(t, controller, controllerlib.Context{Context: ctx, Queue: queue})
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.wantRequeue, queue.duration)
+
+			certPEM, _ := signingCertProvider.CurrentCertKeyContent()
+			require.Equal(t, string(tt.wantCertPEM), string(certPEM))
+
+			if len(tt.pinnipedObjects) > 0 {
+				credIssuer, err := conciergeClientset.ConfigV1alpha1().CredentialIssuers().Get(ctx, "pinniped-concierge-config", metav1.GetOptions{})
+				require.NoError(t, err)
+				require.Equal(t, tt.wantStrategies, credIssuer.Status.Strategies)
+			}
+		})
+	}
+}
+
+type testQueue struct {
+	duration time.Duration
+
+	controllerlib.Queue // panic if any other methods called
+}
+
+func (q *testQueue) AddAfter(_ controllerlib.Key, duration time.Duration) {
+	q.duration = duration
+}
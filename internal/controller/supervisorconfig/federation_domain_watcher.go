@@ -21,6 +21,8 @@ import (
 	configinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions/config/v1alpha1"
 	pinnipedcontroller "go.pinniped.dev/internal/controller"
 	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/idtransform"
+	"go.pinniped.dev/internal/oidc"
 	"go.pinniped.dev/internal/oidc/provider"
 	"go.pinniped.dev/internal/plog"
 )
@@ -143,7 +145,7 @@ func (c *federationDomainWatcherController) Sync(ctx controllerlib.Context) erro
 			continue
 		}
 
-		federationDomainIssuer, err := provider.NewFederationDomainIssuer(federationDomain.Spec.Issuer) // This validates the Issuer URL.
+		federationDomainIssuer, err := newFederationDomainIssuer(federationDomain)
 		if err != nil {
 			if err := c.updateStatus(
 				ctx.Context,
@@ -162,7 +164,7 @@ func (c *federationDomainWatcherController) Sync(ctx controllerlib.Context) erro
 			federationDomain.Namespace,
 			federationDomain.Name,
 			configv1alpha1.SuccessFederationDomainStatusCondition,
-			"Provider successfully created",
+			successMessage(federationDomainIssuer),
 		); err != nil {
 			errs = append(errs, fmt.Errorf("could not update status: %w", err))
 			continue
@@ -209,4 +211,78 @@ func (c *federationDomainWatcherController) updateStatus(
 	})
 }
 
+func newFederationDomainIssuer(federationDomain *configv1alpha1.FederationDomain) (*provider.FederationDomainIssuer, error) {
+	identityProviders, err := federationDomainIdentityProviders(federationDomain.Spec.IdentityProviders)
+	if err != nil {
+		return nil, err
+	}
+	return provider.NewFederationDomainIssuer( // This validates the Issuer URL and the token lifetimes.
+		federationDomain.Spec.Issuer,
+		identityProviders,
+		tokenLifetimes(federationDomain.Spec.TokenLifetimes),
+	)
+}
+
+// tokenLifetimes returns the token lifetimes configured by the FederationDomain, using the default lifetime for each
+// one which is not configured, or nil when the FederationDomain does not configure any token lifetimes.
+func tokenLifetimes(spec *configv1alpha1.FederationDomainTokenLifetimes) *provider.TokenLifetimes {
+	if spec == nil {
+		return nil
+	}
+	result := provider.TokenLifetimes{
+		AccessToken:  oidc.DefaultAccessTokenLifespan,
+		RefreshToken: oidc.DefaultRefreshTokenLifespan,
+	}
+	if spec.AccessToken != nil {
+		result.AccessToken = spec.AccessToken.Duration
+	}
+	if spec.RefreshToken != nil {
+		result.RefreshToken = spec.RefreshToken.Duration
+	}
+	return &result
+}
+
+func federationDomainIdentityProviders(idps []configv1alpha1.FederationDomainIdentityProvider) ([]provider.FederationDomainIdentityProvider, error) {
+	if len(idps) == 0 {
+		return nil, nil
+	}
+	result := make([]provider.FederationDomainIdentityProvider, 0, len(idps))
+	for _, idp := range idps {
+		transforms, err := identityTransforms(idp.Transforms)
+		if err != nil {
+			return nil, fmt.Errorf("identity provider %q has invalid transforms: %w", idp.Name, err)
+		}
+		result = append(result, provider.FederationDomainIdentityProvider{Name: idp.Name, Type: string(idp.Type), Transforms: transforms})
+	}
+	return result, nil
+}
+
+func identityTransforms(transforms []configv1alpha1.FederationDomainTransform) (*idtransform.Pipeline, error) {
+	if len(transforms) == 0 {
+		return nil, nil
+	}
+	result := make([]idtransform.Transform, 0, len(transforms))
+	for _, t := range transforms {
+		result = append(result, idtransform.Transform{
+			Type:        idtransform.Type(t.Type),
+			Target:      idtransform.Target(t.Target),
+			Prefix:      t.Prefix,
+			Regex:       t.Regex,
+			Replacement: t.Replacement,
+		})
+	}
+	return idtransform.NewPipeline(result)
+}
+
+// successMessage returns the status message of a valid FederationDomain, which mentions its token lifetimes when
+// they are not the defaults.
+func successMessage(federationDomainIssuer *provider.FederationDomainIssuer) string {
+	lifetimes := federationDomainIssuer.TokenLifetimes()
+	if lifetimes == nil {
+		return "Provider successfully created"
+	}
+	return fmt.Sprintf("Provider successfully created with access token lifetime %s and refresh token lifetime %s",
+		lifetimes.AccessToken, lifetimes.RefreshToken)
+}
+
 func timePtr(t metav1.Time) *metav1.Time { return &t }
@@ -160,6 +160,211 @@ func TestSync(t *testing.T) {
 			cancelContextCancelFunc()
 		})
 
+		when("there is a valid FederationDomain which lists identity providers in the informer", func() {
+			var federationDomain *v1alpha1.FederationDomain
+
+			it.Before(func() {
+				federationDomain = &v1alpha1.FederationDomain{
+					ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace},
+					Spec: v1alpha1.FederationDomainSpec{
+						Issuer: "https://issuer1.com",
+						IdentityProviders: []v1alpha1.FederationDomainIdentityProvider{
+							{Name: "some-oidc-idp", Type: v1alpha1.OIDCFederationDomainIdentityProviderType},
+							{Name: "some-ldap-idp", Type: v1alpha1.LDAPFederationDomainIdentityProviderType},
+						},
+					},
+				}
+				r.NoError(pinnipedAPIClient.Tracker().Add(federationDomain))
+				r.NoError(federationDomainInformerClient.Tracker().Add(federationDomain))
+			})
+
+			it("calls the ProvidersSetter with the identity providers of the FederationDomain", func() {
+				startInformersAndController()
+				err := controllerlib.TestSync(t, subject, *syncContext)
+				r.NoError(err)
+
+				wantProvider, err := provider.NewFederationDomainIssuer(federationDomain.Spec.Issuer, []provider.FederationDomainIdentityProvider{
+					{Name: "some-oidc-idp", Type: "oidc"},
+					{Name: "some-ldap-idp", Type: "ldap"},
+				}, nil)
+				r.NoError(err)
+
+				r.True(providersSetter.SetProvidersWasCalled)
+				r.Equal([]*provider.FederationDomainIssuer{wantProvider}, providersSetter.FederationDomainsReceived)
+				r.True(providersSetter.FederationDomainsReceived[0].AllowsIdentityProvider("some-ldap-idp", "ldap"))
+				r.False(providersSetter.FederationDomainsReceived[0].AllowsIdentityProvider("some-other-ldap-idp", "ldap"))
+			})
+		})
+
+		when("there is a FederationDomain which configures identity transforms in the informer", func() {
+			var federationDomain *v1alpha1.FederationDomain
+
+			it.Before(func() {
+				federationDomain = &v1alpha1.FederationDomain{
+					ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace},
+					Spec: v1alpha1.FederationDomainSpec{
+						Issuer: "https://issuer1.com",
+						IdentityProviders: []v1alpha1.FederationDomainIdentityProvider{
+							{
+								Name: "some-oidc-idp",
+								Type: v1alpha1.OIDCFederationDomainIdentityProviderType,
+								Transforms: []v1alpha1.FederationDomainTransform{
+									{Type: v1alpha1.PrefixFederationDomainTransformType, Prefix: "oidc:"},
+									{Type: v1alpha1.DenyLoginFederationDomainTransformType, Target: v1alpha1.GroupsFederationDomainTransformTarget, Regex: "^banned$"},
+								},
+							},
+							{Name: "some-ldap-idp", Type: v1alpha1.LDAPFederationDomainIdentityProviderType},
+						},
+					},
+				}
+				r.NoError(pinnipedAPIClient.Tracker().Add(federationDomain))
+				r.NoError(federationDomainInformerClient.Tracker().Add(federationDomain))
+			})
+
+			it("calls the ProvidersSetter with the identity transforms of each identity provider", func() {
+				startInformersAndController()
+				err := controllerlib.TestSync(t, subject, *syncContext)
+				r.NoError(err)
+
+				r.True(providersSetter.SetProvidersWasCalled)
+				r.Len(providersSetter.FederationDomainsReceived, 1)
+				received := providersSetter.FederationDomainsReceived[0]
+				r.Nil(received.IdentityTransforms("some-ldap-idp", "ldap"))
+
+				transforms := received.IdentityTransforms("some-oidc-idp", "oidc")
+				r.NotNil(transforms)
+				username, groups, err := transforms.Evaluate("admin", []string{"a"})
+				r.NoError(err)
+				r.Equal("oidc:admin", username)
+				r.Equal([]string{"a"}, groups)
+				_, _, err = transforms.Evaluate("admin", []string{"banned"})
+				r.EqualError(err, `login denied by identity transform: group "banned" matches "^banned$"`)
+			})
+
+			when("the identity transforms are invalid", func() {
+				it.Before(func() {
+					federationDomain.Spec.IdentityProviders[0].Transforms[1].Regex = "("
+					r.NoError(pinnipedAPIClient.Tracker().Update(federationDomainGVR, federationDomain, namespace))
+					r.NoError(federationDomainInformerClient.Tracker().Update(federationDomainGVR, federationDomain, namespace))
+				})
+
+				it("does not call the ProvidersSetter with the FederationDomain and updates its status to invalid", func() {
+					startInformersAndController()
+					err := controllerlib.TestSync(t, subject, *syncContext)
+					r.NoError(err)
+
+					r.True(providersSetter.SetProvidersWasCalled)
+					r.Empty(providersSetter.FederationDomainsReceived)
+
+					federationDomain.Status.Status = v1alpha1.InvalidFederationDomainStatusCondition
+					federationDomain.Status.Message = `Invalid: identity provider "some-oidc-idp" has invalid transforms: ` +
+						"transform 1 (denyLogin): invalid regex: error parsing regexp: missing closing ): `(`"
+					federationDomain.Status.LastUpdateTime = timePtr(metav1.NewTime(frozenNow))
+
+					expectedActions := []coretesting.Action{
+						coretesting.NewGetAction(
+							federationDomainGVR,
+							federationDomain.Namespace,
+							federationDomain.Name,
+						),
+						coretesting.NewUpdateSubresourceAction(
+							federationDomainGVR,
+							"status",
+							federationDomain.Namespace,
+							federationDomain,
+						),
+					}
+					r.Equal(expectedActions, pinnipedAPIClient.Actions())
+				})
+			})
+		})
+
+		when("there is a FederationDomain which configures token lifetimes in the informer", func() {
+			var federationDomain *v1alpha1.FederationDomain
+
+			it.Before(func() {
+				federationDomain = &v1alpha1.FederationDomain{
+					ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace},
+					Spec: v1alpha1.FederationDomainSpec{
+						Issuer: "https://issuer1.com",
+						TokenLifetimes: &v1alpha1.FederationDomainTokenLifetimes{
+							RefreshToken: &metav1.Duration{Duration: 24 * time.Hour},
+						},
+					},
+				}
+				r.NoError(pinnipedAPIClient.Tracker().Add(federationDomain))
+				r.NoError(federationDomainInformerClient.Tracker().Add(federationDomain))
+			})
+
+			it("calls the ProvidersSetter with the token lifetimes, using the default for any which are not configured", func() {
+				startInformersAndController()
+				err := controllerlib.TestSync(t, subject, *syncContext)
+				r.NoError(err)
+
+				r.True(providersSetter.SetProvidersWasCalled)
+				r.Len(providersSetter.FederationDomainsReceived, 1)
+				r.Equal(
+					&provider.TokenLifetimes{AccessToken: 2 * time.Minute, RefreshToken: 24 * time.Hour},
+					providersSetter.FederationDomainsReceived[0].TokenLifetimes(),
+				)
+
+				federationDomain.Status.Status = v1alpha1.SuccessFederationDomainStatusCondition
+				federationDomain.Status.Message = "Provider successfully created with access token lifetime 2m0s and refresh token lifetime 24h0m0s"
+				federationDomain.Status.LastUpdateTime = timePtr(metav1.NewTime(frozenNow))
+
+				expectedActions := []coretesting.Action{
+					coretesting.NewGetAction(
+						federationDomainGVR,
+						federationDomain.Namespace,
+						federationDomain.Name,
+					),
+					coretesting.NewUpdateSubresourceAction(
+						federationDomainGVR,
+						"status",
+						federationDomain.Namespace,
+						federationDomain,
+					),
+				}
+				r.Equal(expectedActions, pinnipedAPIClient.Actions())
+			})
+
+			when("the token lifetimes are invalid", func() {
+				it.Before(func() {
+					federationDomain.Spec.TokenLifetimes.AccessToken = &metav1.Duration{Duration: 48 * time.Hour}
+					r.NoError(pinnipedAPIClient.Tracker().Update(federationDomainGVR, federationDomain, namespace))
+					r.NoError(federationDomainInformerClient.Tracker().Update(federationDomainGVR, federationDomain, namespace))
+				})
+
+				it("does not call the ProvidersSetter with the FederationDomain and updates its status to invalid", func() {
+					startInformersAndController()
+					err := controllerlib.TestSync(t, subject, *syncContext)
+					r.NoError(err)
+
+					r.True(providersSetter.SetProvidersWasCalled)
+					r.Empty(providersSetter.FederationDomainsReceived)
+
+					federationDomain.Status.Status = v1alpha1.InvalidFederationDomainStatusCondition
+					federationDomain.Status.Message = "Invalid: refresh token lifetime must not be shorter than access token lifetime"
+					federationDomain.Status.LastUpdateTime = timePtr(metav1.NewTime(frozenNow))
+
+					expectedActions := []coretesting.Action{
+						coretesting.NewGetAction(
+							federationDomainGVR,
+							federationDomain.Namespace,
+							federationDomain.Name,
+						),
+						coretesting.NewUpdateSubresourceAction(
+							federationDomainGVR,
+							"status",
+							federationDomain.Namespace,
+							federationDomain,
+						),
+					}
+					r.Equal(expectedActions, pinnipedAPIClient.Actions())
+				})
+			})
+		})
+
 		when("there are some valid FederationDomains in the informer", func() {
 			var (
 				federationDomain1 *v1alpha1.FederationDomain
@@ -187,10 +392,10 @@ func TestSync(t *testing.T) {
 				err := controllerlib.TestSync(t, subject, *syncContext)
 				r.NoError(err)
 
-				provider1, err := provider.NewFederationDomainIssuer(federationDomain1.Spec.Issuer)
+				provider1, err := provider.NewFederationDomainIssuer(federationDomain1.Spec.Issuer, nil, nil)
 				r.NoError(err)
 
-				provider2, err := provider.NewFederationDomainIssuer(federationDomain2.Spec.Issuer)
+				provider2, err := provider.NewFederationDomainIssuer(federationDomain2.Spec.Issuer, nil, nil)
 				r.NoError(err)
 
 				r.True(providersSetter.SetProvidersWasCalled)
@@ -288,10 +493,10 @@ func TestSync(t *testing.T) {
 					err := controllerlib.TestSync(t, subject, *syncContext)
 					r.NoError(err)
 
-					provider1, err := provider.NewFederationDomainIssuer(federationDomain1.Spec.Issuer)
+					provider1, err := provider.NewFederationDomainIssuer(federationDomain1.Spec.Issuer, nil, nil)
 					r.NoError(err)
 
-					provider2, err := provider.NewFederationDomainIssuer(federationDomain2.Spec.Issuer)
+					provider2, err := provider.NewFederationDomainIssuer(federationDomain2.Spec.Issuer, nil, nil)
 					r.NoError(err)
 
 					r.True(providersSetter.SetProvidersWasCalled)
@@ -326,10 +531,10 @@ func TestSync(t *testing.T) {
 					err := controllerlib.TestSync(t, subject, *syncContext)
 					r.EqualError(err, "could not update status: some update error")
 
-					provider1, err := provider.NewFederationDomainIssuer(federationDomain1.Spec.Issuer)
+					provider1, err := provider.NewFederationDomainIssuer(federationDomain1.Spec.Issuer, nil, nil)
 					r.NoError(err)
 
-					provider2, err := provider.NewFederationDomainIssuer(federationDomain2.Spec.Issuer)
+					provider2, err := provider.NewFederationDomainIssuer(federationDomain2.Spec.Issuer, nil, nil)
 					r.NoError(err)
 
 					r.True(providersSetter.SetProvidersWasCalled)
@@ -545,7 +750,7 @@ func TestSync(t *testing.T) {
 				err := controllerlib.TestSync(t, subject, *syncContext)
 				r.NoError(err)
 
-				validProvider, err := provider.NewFederationDomainIssuer(validFederationDomain.Spec.Issuer)
+				validProvider, err := provider.NewFederationDomainIssuer(validFederationDomain.Spec.Issuer, nil, nil)
 				r.NoError(err)
 
 				r.True(providersSetter.SetProvidersWasCalled)
@@ -619,7 +824,7 @@ func TestSync(t *testing.T) {
 					err := controllerlib.TestSync(t, subject, *syncContext)
 					r.EqualError(err, "could not update status: some update error")
 
-					validProvider, err := provider.NewFederationDomainIssuer(validFederationDomain.Spec.Issuer)
+					validProvider, err := provider.NewFederationDomainIssuer(validFederationDomain.Spec.Issuer, nil, nil)
 					r.NoError(err)
 
 					r.True(providersSetter.SetProvidersWasCalled)
@@ -709,7 +914,7 @@ func TestSync(t *testing.T) {
 				err := controllerlib.TestSync(t, subject, *syncContext)
 				r.NoError(err)
 
-				nonDuplicateProvider, err := provider.NewFederationDomainIssuer(federationDomain.Spec.Issuer)
+				nonDuplicateProvider, err := provider.NewFederationDomainIssuer(federationDomain.Spec.Issuer, nil, nil)
 				r.NoError(err)
 
 				r.True(providersSetter.SetProvidersWasCalled)
@@ -882,7 +1087,7 @@ func TestSync(t *testing.T) {
 				err := controllerlib.TestSync(t, subject, *syncContext)
 				r.NoError(err)
 
-				nonDuplicateProvider, err := provider.NewFederationDomainIssuer(federationDomainDifferentIssuerAddress.Spec.Issuer)
+				nonDuplicateProvider, err := provider.NewFederationDomainIssuer(federationDomainDifferentIssuerAddress.Spec.Issuer, nil, nil)
 				r.NoError(err)
 
 				r.True(providersSetter.SetProvidersWasCalled)
@@ -126,7 +126,7 @@ func (c *federationDomainSecretsController) Sync(ctx controllerlib.Context) erro
 	}
 
 	// If the FederationDomain does not have a secret associated with it, that secret does not exist, or the secret
-	// is invalid, we will create a new secret.
+	// is invalid, we will create a new secret. If the secret is due for rotation, we will rotate its key.
 	if err := c.createOrUpdateSecret(ctx.Context, federationDomain, &newSecret); err != nil {
 		return fmt.Errorf("failed to create or update secret: %w", err)
 	}
@@ -163,6 +163,11 @@ func (c *federationDomainSecretsController) secretNeedsUpdate(
 		return true, secret, nil
 	}
 
+	if c.secretHelper.NeedsRotation(secret) {
+		// If this secret's key is due for rotation, we need to rotate it.
+		return true, secret, nil
+	}
+
 	return false, secret, nil
 }
 
@@ -190,10 +195,21 @@ func (c *federationDomainSecretsController) createOrUpdateSecret(
 
 		// New secret already exists, so ensure it is up to date.
 		if c.secretHelper.IsValid(federationDomain, oldSecret) {
-			// If the secret already has valid a valid Secret, then we are good to go and we don't need an
-			// update.
-			*newSecret = oldSecret
-			return nil
+			if !c.secretHelper.NeedsRotation(oldSecret) {
+				// If the secret already has valid a valid Secret, then we are good to go and we don't need an
+				// update.
+				*newSecret = oldSecret
+				return nil
+			}
+
+			// Rotate the key of the valid Secret, keeping its current key as a previous key.
+			rotatedSecret, err := c.secretHelper.Rotate(oldSecret, *newSecret)
+			if err != nil {
+				return fmt.Errorf("failed to rotate secret %s/%s: %w", oldSecret.Namespace, oldSecret.Name, err)
+			}
+			*newSecret = rotatedSecret
+			_, err = secretClient.Update(ctx, rotatedSecret, metav1.UpdateOptions{})
+			return err
 		}
 
 		oldSecret.Labels = (*newSecret).Labels
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
@@ -18,6 +19,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/clock"
 	kubeinformers "k8s.io/client-go/informers"
 	kubernetesfake "k8s.io/client-go/kubernetes/fake"
 	kubetesting "k8s.io/client-go/testing"
@@ -177,7 +179,9 @@ func TestFederationDomainControllerFilterSecret(t *testing.T) {
 				map[string]string{},
 				rand.Reader,
 				SecretUsageTokenSigningKey,
-				func(cacheKey string, cacheValue []byte) {},
+				time.Hour,
+				clock.RealClock{},
+				func(cacheKey string, cacheValues [][]byte) {},
 			)
 
 			secretInformer := kubeinformers.NewSharedInformerFactory(
@@ -240,7 +244,9 @@ func TestNewFederationDomainSecretsControllerFilterFederationDomain(t *testing.T
 				map[string]string{},
 				rand.Reader,
 				SecretUsageTokenSigningKey,
-				func(cacheKey string, cacheValue []byte) {},
+				time.Hour,
+				clock.RealClock{},
+				func(cacheKey string, cacheValues [][]byte) {},
 			)
 
 			secretInformer := kubeinformers.NewSharedInformerFactory(
@@ -359,6 +365,12 @@ func TestFederationDomainSecretsControllerSync(t *testing.T) {
 		},
 	}
 
+	rotatedSecret := goodSecret.DeepCopy()
+	rotatedSecret.Data = map[string][]byte{
+		"some-key":          []byte("some-new-value"),
+		"some-previous-key": []byte("some-value"),
+	}
+
 	tests := []struct {
 		name                        string
 		storage                     func(**configv1alpha1.FederationDomain, **corev1.Secret)
@@ -463,6 +475,53 @@ func TestFederationDomainSecretsControllerSync(t *testing.T) {
 				kubetesting.NewUpdateAction(secretGVR, namespace, goodSecret),
 			},
 		},
+		{
+			name: "FederationDomain exists and valid secret exists",
+			secretHelper: func(secretHelper *mocksecrethelper.MockSecretHelper) {
+				secretHelper.EXPECT().Generate(goodFederationDomain).Times(1).Return(goodSecret, nil)
+				secretHelper.EXPECT().IsValid(goodFederationDomain, goodSecret).Times(1).Return(true)
+				secretHelper.EXPECT().NeedsRotation(goodSecret).Times(1).Return(false)
+				secretHelper.EXPECT().ObserveActiveSecretAndUpdateParentFederationDomain(goodFederationDomain, goodSecret).Times(1).Return(goodFederationDomainWithTokenSigningKey)
+			},
+			wantFederationDomainActions: []kubetesting.Action{
+				kubetesting.NewGetAction(federationDomainGVR, namespace, goodFederationDomain.Name),
+				kubetesting.NewUpdateSubresourceAction(federationDomainGVR, "status", namespace, goodFederationDomainWithTokenSigningKey),
+			},
+		},
+		{
+			name: "FederationDomain exists and valid secret exists which needs rotation",
+			secretHelper: func(secretHelper *mocksecrethelper.MockSecretHelper) {
+				generatedSecret := goodSecret.DeepCopy()
+				generatedSecret.Data = map[string][]byte{"some-key": []byte("some-new-value")}
+
+				secretHelper.EXPECT().Generate(goodFederationDomain).Times(1).Return(generatedSecret, nil)
+				secretHelper.EXPECT().IsValid(goodFederationDomain, goodSecret).Times(2).Return(true)
+				secretHelper.EXPECT().NeedsRotation(goodSecret).Times(2).Return(true)
+				secretHelper.EXPECT().Rotate(goodSecret, generatedSecret).Times(1).Return(rotatedSecret, nil)
+				secretHelper.EXPECT().ObserveActiveSecretAndUpdateParentFederationDomain(goodFederationDomain, rotatedSecret).Times(1).Return(goodFederationDomainWithTokenSigningKey)
+			},
+			wantFederationDomainActions: []kubetesting.Action{
+				kubetesting.NewGetAction(federationDomainGVR, namespace, goodFederationDomain.Name),
+				kubetesting.NewUpdateSubresourceAction(federationDomainGVR, "status", namespace, goodFederationDomainWithTokenSigningKey),
+			},
+			wantSecretActions: []kubetesting.Action{
+				kubetesting.NewGetAction(secretGVR, namespace, goodSecret.Name),
+				kubetesting.NewUpdateAction(secretGVR, namespace, rotatedSecret),
+			},
+		},
+		{
+			name: "FederationDomain exists and valid secret exists which needs rotation and rotating fails",
+			secretHelper: func(secretHelper *mocksecrethelper.MockSecretHelper) {
+				secretHelper.EXPECT().Generate(goodFederationDomain).Times(1).Return(goodSecret, nil)
+				secretHelper.EXPECT().IsValid(goodFederationDomain, goodSecret).Times(2).Return(true)
+				secretHelper.EXPECT().NeedsRotation(goodSecret).Times(2).Return(true)
+				secretHelper.EXPECT().Rotate(goodSecret, goodSecret).Times(1).Return(nil, errors.New("some rotate error"))
+			},
+			wantSecretActions: []kubetesting.Action{
+				kubetesting.NewGetAction(secretGVR, namespace, goodSecret.Name),
+			},
+			wantError: fmt.Sprintf("failed to create or update secret: failed to rotate secret %s/%s: some rotate error", namespace, goodSecret.Name),
+		},
 		{
 			name: "FederationDomain exists and generating a secret fails",
 			secretHelper: func(secretHelper *mocksecrethelper.MockSecretHelper) {
@@ -479,6 +538,7 @@ func TestFederationDomainSecretsControllerSync(t *testing.T) {
 				secretHelper.EXPECT().Generate(goodFederationDomain).Times(1).Return(otherSecret, nil)
 				secretHelper.EXPECT().IsValid(goodFederationDomain, goodSecret).Times(1).Return(false)
 				secretHelper.EXPECT().IsValid(goodFederationDomain, goodSecret).Times(1).Return(true)
+				secretHelper.EXPECT().NeedsRotation(goodSecret).Times(1).Return(false)
 				secretHelper.EXPECT().ObserveActiveSecretAndUpdateParentFederationDomain(goodFederationDomain, goodSecret).Times(1).Return(goodFederationDomainWithTokenSigningKey)
 			},
 			wantFederationDomainActions: []kubetesting.Action{
@@ -4,12 +4,15 @@
 package generator
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/clock"
 
 	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
 )
@@ -17,11 +20,16 @@ import (
 // SecretHelper describes an object that can Generate() a Secret and determine whether a Secret
 // IsValid(). It can also be Notify()'d about a Secret being persisted.
 //
+// A valid Secret may still be due for rotation, which is decided by NeedsRotation(). Rotate() returns
+// a copy of such a Secret whose current key has been replaced by the key of a newly generated Secret.
+//
 // A SecretHelper has a NamePrefix() that can be used to identify it from other SecretHelper instances.
 type SecretHelper interface {
 	NamePrefix() string
 	Generate(*configv1alpha1.FederationDomain) (*corev1.Secret, error)
 	IsValid(*configv1alpha1.FederationDomain, *corev1.Secret) bool
+	NeedsRotation(*corev1.Secret) bool
+	Rotate(existing *corev1.Secret, generated *corev1.Secret) (*corev1.Secret, error)
 	ObserveActiveSecretAndUpdateParentFederationDomain(*configv1alpha1.FederationDomain, *corev1.Secret) *configv1alpha1.FederationDomain
 	Handles(metav1.Object) bool
 }
@@ -44,6 +52,18 @@ const (
 	// symmetricSecretDataKey is the corev1.Secret.Data key for the symmetric key value generated by this helper.
 	symmetricSecretDataKey = "key"
 
+	// symmetricSecretCreatedAtDataKey is the corev1.Secret.Data key for the RFC 3339 time at which the
+	// symmetric key value was generated.
+	symmetricSecretCreatedAtDataKey = "keyCreatedAt"
+
+	// symmetricSecretPreviousKeysDataKey is the corev1.Secret.Data key for the JSON list of the symmetric key
+	// values which were replaced by rotations, newest first.
+	symmetricSecretPreviousKeysDataKey = "previousKeys"
+
+	// maxPreviousSymmetricKeys is the number of replaced symmetric keys which are kept, so that the values
+	// which were signed or encrypted with them can still be verified or decrypted after a rotation.
+	maxPreviousSymmetricKeys = 2
+
 	// symmetricKeySize is the default length, in bytes, of generated keys. It is set to 32 since this
 	// seems like reasonable entropy for our keys, and a 32-byte key will allow for AES-256
 	// to be used in our codecs (see dynamiccodec.Codec).
@@ -61,29 +81,36 @@ const (
 )
 
 // New returns a SecretHelper that has been parameterized with common symmetric secret generation
-// knobs.
+// knobs. The symmetric key of each Secret is rotated once it is older than rotationInterval. The
+// updateCacheFunc is called with the current key followed by the previous keys, newest first.
 func NewSymmetricSecretHelper(
 	namePrefix string,
 	labels map[string]string,
 	rand io.Reader,
 	secretUsage SecretUsage,
-	updateCacheFunc func(cacheKey string, cacheValue []byte),
+	rotationInterval time.Duration,
+	clock clock.Clock,
+	updateCacheFunc func(cacheKey string, cacheValues [][]byte),
 ) SecretHelper {
 	return &symmetricSecretHelper{
-		namePrefix:      namePrefix,
-		labels:          labels,
-		rand:            rand,
-		secretUsage:     secretUsage,
-		updateCacheFunc: updateCacheFunc,
+		namePrefix:       namePrefix,
+		labels:           labels,
+		rand:             rand,
+		secretUsage:      secretUsage,
+		rotationInterval: rotationInterval,
+		clock:            clock,
+		updateCacheFunc:  updateCacheFunc,
 	}
 }
 
 type symmetricSecretHelper struct {
-	namePrefix      string
-	labels          map[string]string
-	rand            io.Reader
-	secretUsage     SecretUsage
-	updateCacheFunc func(cacheKey string, cacheValue []byte)
+	namePrefix       string
+	labels           map[string]string
+	rand             io.Reader
+	secretUsage      SecretUsage
+	rotationInterval time.Duration
+	clock            clock.Clock
+	updateCacheFunc  func(cacheKey string, cacheValues [][]byte)
 }
 
 func (s *symmetricSecretHelper) NamePrefix() string { return s.namePrefix }
@@ -110,7 +137,8 @@ func (s *symmetricSecretHelper) Generate(parent *configv1alpha1.FederationDomain
 		},
 		Type: s.secretType(),
 		Data: map[string][]byte{
-			symmetricSecretDataKey: key,
+			symmetricSecretDataKey:          key,
+			symmetricSecretCreatedAtDataKey: []byte(s.clock.Now().UTC().Format(time.RFC3339)),
 		},
 	}, nil
 }
@@ -133,15 +161,79 @@ func (s *symmetricSecretHelper) IsValid(parent *configv1alpha1.FederationDomain,
 		return false
 	}
 
+	if _, ok := secret.Data[symmetricSecretPreviousKeysDataKey]; ok {
+		previousKeys, err := previousSymmetricKeys(secret)
+		if err != nil {
+			return false
+		}
+		for _, previousKey := range previousKeys {
+			if len(previousKey) != symmetricKeySize {
+				return false
+			}
+		}
+	}
+
 	return true
 }
 
+// NeedsRotation implements SecretHelper.NeedsRotation(). The key of a valid Secret which does not say
+// when its key was generated, e.g. because it was written by an older version of the Supervisor, is
+// always rotated.
+func (s *symmetricSecretHelper) NeedsRotation(secret *corev1.Secret) bool {
+	createdAt, err := time.Parse(time.RFC3339, string(secret.Data[symmetricSecretCreatedAtDataKey]))
+	if err != nil {
+		return true
+	}
+	return !s.clock.Now().Before(createdAt.Add(s.rotationInterval))
+}
+
+// Rotate implements SecretHelper.Rotate().
+func (s *symmetricSecretHelper) Rotate(existing *corev1.Secret, generated *corev1.Secret) (*corev1.Secret, error) {
+	previousKeys, err := previousSymmetricKeys(existing)
+	if err != nil {
+		return nil, err
+	}
+	previousKeys = append([][]byte{existing.Data[symmetricSecretDataKey]}, previousKeys...)
+	if len(previousKeys) > maxPreviousSymmetricKeys {
+		previousKeys = previousKeys[:maxPreviousSymmetricKeys]
+	}
+	previousKeysData, err := json.Marshal(previousKeys)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal previous keys: %w", err)
+	}
+
+	rotated := existing.DeepCopy()
+	rotated.Labels = generated.Labels
+	rotated.Type = generated.Type
+	rotated.Data = map[string][]byte{
+		symmetricSecretDataKey:             generated.Data[symmetricSecretDataKey],
+		symmetricSecretCreatedAtDataKey:    generated.Data[symmetricSecretCreatedAtDataKey],
+		symmetricSecretPreviousKeysDataKey: previousKeysData,
+	}
+	return rotated, nil
+}
+
+// previousSymmetricKeys returns the previous keys of the provided Secret, newest first.
+func previousSymmetricKeys(secret *corev1.Secret) ([][]byte, error) {
+	previousKeysData, ok := secret.Data[symmetricSecretPreviousKeysDataKey]
+	if !ok {
+		return nil, nil
+	}
+	var previousKeys [][]byte
+	if err := json.Unmarshal(previousKeysData, &previousKeys); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal previous keys: %w", err)
+	}
+	return previousKeys, nil
+}
+
 // ObserveActiveSecretAndUpdateParentFederationDomain implements SecretHelper.ObserveActiveSecretAndUpdateParentFederationDomain().
 func (s *symmetricSecretHelper) ObserveActiveSecretAndUpdateParentFederationDomain(
 	federationDomain *configv1alpha1.FederationDomain,
 	secret *corev1.Secret,
 ) *configv1alpha1.FederationDomain {
-	s.updateCacheFunc(federationDomain.Spec.Issuer, secret.Data[symmetricSecretDataKey])
+	// IsValid has already checked the previous keys.
+	previousKeys, _ := previousSymmetricKeys(secret)
+	s.updateCacheFunc(federationDomain.Spec.Issuer, append([][]byte{secret.Data[symmetricSecretDataKey]}, previousKeys...))
 
 	switch s.secretUsage {
 	case SecretUsageTokenSigningKey:
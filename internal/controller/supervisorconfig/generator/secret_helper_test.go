@@ -4,18 +4,25 @@
 package generator
 
 import (
+	"encoding/base64"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/clock"
 
 	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
 )
 
-const keyWith32Bytes = "0123456789abcdef0123456789abcdef"
+const (
+	keyWith32Bytes      = "0123456789abcdef0123456789abcdef"
+	otherKeyWith32Bytes = "fedcba9876543210fedcba9876543210"
+	oldKeyWith32Bytes   = "00112233445566778899aabbccddeeff"
+)
 
 func TestSymmetricSecretHelper(t *testing.T) {
 	t.Parallel()
@@ -62,16 +69,18 @@ func TestSymmetricSecretHelper(t *testing.T) {
 			}
 			randSource := strings.NewReader(keyWith32Bytes)
 			var federationDomainIssuerValue string
-			var symmetricKeyValue []byte
+			var symmetricKeyValues [][]byte
 			h := NewSymmetricSecretHelper(
 				"some-name-prefix-",
 				labels,
 				randSource,
 				test.secretUsage,
-				func(federationDomainIssuer string, symmetricKey []byte) {
-					require.True(t, federationDomainIssuer == "" && symmetricKeyValue == nil, "expected notify func not to have been called yet")
+				time.Hour,
+				clock.NewFakeClock(time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)),
+				func(federationDomainIssuer string, symmetricKeys [][]byte) {
+					require.True(t, federationDomainIssuer == "" && symmetricKeyValues == nil, "expected notify func not to have been called yet")
 					federationDomainIssuerValue = federationDomainIssuer
-					symmetricKeyValue = symmetricKey
+					symmetricKeyValues = symmetricKeys
 				},
 			)
 
@@ -98,16 +107,18 @@ func TestSymmetricSecretHelper(t *testing.T) {
 				},
 				Type: test.wantSecretType,
 				Data: map[string][]byte{
-					"key": []byte(keyWith32Bytes),
+					"key":          []byte(keyWith32Bytes),
+					"keyCreatedAt": []byte("2021-10-01T12:00:00Z"),
 				},
 			})
 
 			require.True(t, h.IsValid(parent, child))
+			require.False(t, h.NeedsRotation(child))
 
 			h.ObserveActiveSecretAndUpdateParentFederationDomain(parent, child)
 			require.Equal(t, parent.Spec.Issuer, federationDomainIssuerValue)
 			require.Equal(t, child.Name, test.wantSetFederationDomainField(parent))
-			require.Equal(t, child.Data["key"], symmetricKeyValue)
+			require.Equal(t, [][]byte{child.Data["key"]}, symmetricKeyValues)
 
 			require.True(t, h.Handles(child))
 			wrongTypedChild := child.DeepCopy()
@@ -162,6 +173,24 @@ func TestSymmetricSecretHelperIsValid(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			name:        "previous keys are not JSON",
+			secretUsage: SecretUsageTokenSigningKey,
+			child: func(s *corev1.Secret) {
+				s.Type = FederationDomainTokenSigningKeyType
+				s.Data["previousKeys"] = []byte("not-json")
+			},
+			want: false,
+		},
+		{
+			name:        "previous key is too short",
+			secretUsage: SecretUsageTokenSigningKey,
+			child: func(s *corev1.Secret) {
+				s.Type = FederationDomainTokenSigningKeyType
+				s.Data["previousKeys"] = []byte(`["c2hvcnQ="]`)
+			},
+			want: false,
+		},
 		{
 			name:        "child not owned by parent",
 			secretUsage: SecretUsageTokenSigningKey,
@@ -180,11 +209,20 @@ func TestSymmetricSecretHelperIsValid(t *testing.T) {
 				s.Type = FederationDomainTokenSigningKeyType
 			}, want: true,
 		},
+		{
+			name:        "happy path with previous keys",
+			secretUsage: SecretUsageTokenSigningKey,
+			child: func(s *corev1.Secret) {
+				s.Type = FederationDomainTokenSigningKeyType
+				s.Data["previousKeys"] = []byte(`["` + base64.StdEncoding.EncodeToString([]byte(oldKeyWith32Bytes)) + `"]`)
+			},
+			want: true,
+		},
 	}
 	for _, test := range tests {
 		test := test
 		t.Run(test.name, func(t *testing.T) {
-			h := NewSymmetricSecretHelper("none of these args matter", nil, nil, test.secretUsage, nil)
+			h := NewSymmetricSecretHelper("none of these args matter", nil, nil, test.secretUsage, 0, nil, nil)
 
 			parent := &configv1alpha1.FederationDomain{
 				ObjectMeta: metav1.ObjectMeta{
@@ -221,3 +259,130 @@ func TestSymmetricSecretHelperIsValid(t *testing.T) {
 		})
 	}
 }
+
+func TestSymmetricSecretHelperRotation(t *testing.T) {
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	previousKeys := func(keys ...string) []byte {
+		encoded := make([]string, 0, len(keys))
+		for _, key := range keys {
+			encoded = append(encoded, `"`+base64.StdEncoding.EncodeToString([]byte(key))+`"`)
+		}
+		return []byte("[" + strings.Join(encoded, ",") + "]")
+	}
+
+	tests := []struct {
+		name              string
+		data              map[string][]byte
+		wantNeedsRotation bool
+		wantRotatedData   map[string][]byte
+		wantCacheValues   [][]byte
+	}{
+		{
+			name: "key is not due for rotation",
+			data: map[string][]byte{
+				"key":          []byte(keyWith32Bytes),
+				"keyCreatedAt": []byte("2021-10-01T11:00:01Z"),
+			},
+			wantCacheValues: [][]byte{[]byte(keyWith32Bytes)},
+		},
+		{
+			name: "key is due for rotation",
+			data: map[string][]byte{
+				"key":          []byte(keyWith32Bytes),
+				"keyCreatedAt": []byte("2021-10-01T11:00:00Z"),
+			},
+			wantNeedsRotation: true,
+			wantRotatedData: map[string][]byte{
+				"key":          []byte(otherKeyWith32Bytes),
+				"keyCreatedAt": []byte("2021-10-01T12:00:00Z"),
+				"previousKeys": previousKeys(keyWith32Bytes),
+			},
+			wantCacheValues: [][]byte{[]byte(otherKeyWith32Bytes), []byte(keyWith32Bytes)},
+		},
+		{
+			name: "key from an older version of the Supervisor which did not record its creation time",
+			data: map[string][]byte{
+				"key": []byte(keyWith32Bytes),
+			},
+			wantNeedsRotation: true,
+			wantRotatedData: map[string][]byte{
+				"key":          []byte(otherKeyWith32Bytes),
+				"keyCreatedAt": []byte("2021-10-01T12:00:00Z"),
+				"previousKeys": previousKeys(keyWith32Bytes),
+			},
+			wantCacheValues: [][]byte{[]byte(otherKeyWith32Bytes), []byte(keyWith32Bytes)},
+		},
+		{
+			name: "oldest previous key is dropped",
+			data: map[string][]byte{
+				"key":          []byte(keyWith32Bytes),
+				"keyCreatedAt": []byte("2021-09-01T12:00:00Z"),
+				"previousKeys": previousKeys(oldKeyWith32Bytes, "ffeeddccbbaa99887766554433221100"),
+			},
+			wantNeedsRotation: true,
+			wantRotatedData: map[string][]byte{
+				"key":          []byte(otherKeyWith32Bytes),
+				"keyCreatedAt": []byte("2021-10-01T12:00:00Z"),
+				"previousKeys": previousKeys(keyWith32Bytes, oldKeyWith32Bytes),
+			},
+			wantCacheValues: [][]byte{[]byte(otherKeyWith32Bytes), []byte(keyWith32Bytes), []byte(oldKeyWith32Bytes)},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			var cacheValues [][]byte
+			h := NewSymmetricSecretHelper(
+				"some-name-prefix-",
+				nil,
+				strings.NewReader(otherKeyWith32Bytes),
+				SecretUsageTokenSigningKey,
+				time.Hour,
+				clock.NewFakeClock(now),
+				func(_ string, symmetricKeys [][]byte) { cacheValues = symmetricKeys },
+			)
+
+			parent := &configv1alpha1.FederationDomain{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "some-parent-name",
+					Namespace: "some-namespace",
+					UID:       "some-uid",
+				},
+			}
+			existing := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "some-name-prefix-some-uid",
+					Namespace: "some-namespace",
+					OwnerReferences: []metav1.OwnerReference{
+						*metav1.NewControllerRef(parent, schema.GroupVersionKind{
+							Group:   configv1alpha1.SchemeGroupVersion.Group,
+							Version: configv1alpha1.SchemeGroupVersion.Version,
+							Kind:    "FederationDomain",
+						}),
+					},
+				},
+				Type: FederationDomainTokenSigningKeyType,
+				Data: test.data,
+			}
+			require.True(t, h.IsValid(parent, existing))
+			require.Equal(t, test.wantNeedsRotation, h.NeedsRotation(existing))
+
+			active := existing
+			if test.wantNeedsRotation {
+				generated, err := h.Generate(parent)
+				require.NoError(t, err)
+
+				rotated, err := h.Rotate(existing, generated)
+				require.NoError(t, err)
+				require.Equal(t, test.wantRotatedData, rotated.Data)
+				require.Equal(t, existing.ObjectMeta.OwnerReferences, rotated.ObjectMeta.OwnerReferences)
+				require.True(t, h.IsValid(parent, rotated))
+				require.False(t, h.NeedsRotation(rotated))
+				active = rotated
+			}
+
+			h.ObserveActiveSecretAndUpdateParentFederationDomain(parent, active)
+			require.Equal(t, test.wantCacheValues, cacheValues)
+		})
+	}
+}
@@ -0,0 +1,420 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package githubupstreamwatcher implements a controller which watches GitHubIdentityProviders.
+package githubupstreamwatcher
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/oauth2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/cache"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+
+	"go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+	pinnipedclientset "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned"
+	idpinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions/idp/v1alpha1"
+	"go.pinniped.dev/internal/constable"
+	pinnipedcontroller "go.pinniped.dev/internal/controller"
+	"go.pinniped.dev/internal/controller/conditionsutil"
+	"go.pinniped.dev/internal/controller/supervisorconfig/upstreamwatchers"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/upstreamgithub"
+)
+
+const (
+	// Setup for the name of our controller in logs.
+	githubControllerName = "github-upstream-observer"
+
+	// Constants related to the client credentials Secret.
+	githubClientSecretType corev1.SecretType = "secrets.pinniped.dev/github-client"
+
+	clientIDDataKey     = "clientID"
+	clientSecretDataKey = "clientSecret"
+
+	// The host of the public GitHub, whose API is served from a different host than its web pages.
+	defaultHost    = "github.com"
+	defaultAPIHost = "api.github.com"
+
+	// Constants related to the HTTP client cache.
+	httpClientCacheTTL = 15 * time.Minute
+
+	// Constants related to conditions.
+	typeClientCredentialsValid   = "ClientCredentialsValid"
+	typeHostValid                = "HostValid"
+	typeTLSConfigurationValid    = "TLSConfigurationValid"
+	typeOrganizationsPolicyValid = "OrganizationsPolicyValid"
+
+	reasonInvalid = "Invalid"
+
+	// Errors that are generated by our reconcile process.
+	errGitHubFailureStatus = constable.Error("GitHubIdentityProvider has a failing condition")
+)
+
+// UpstreamGitHubIdentityProviderICache is a thread safe cache that holds a list of validated upstream GitHub IDP configurations.
+type UpstreamGitHubIdentityProviderICache interface {
+	SetGitHubIdentityProviders([]provider.UpstreamOIDCIdentityProviderI)
+}
+
+// lruClientCache caches the *http.Client associated with a particular host/TLS configuration, so that the
+// connections to the GitHub server are reused between syncs.
+type lruClientCache struct{ cache *cache.Expiring }
+
+func (c *lruClientCache) getClient(host string, spec *v1alpha1.GitHubIdentityProviderSpec) *http.Client {
+	if result, ok := c.cache.Get(c.cacheKey(host, spec)); ok {
+		return result.(*http.Client)
+	}
+	return nil
+}
+
+func (c *lruClientCache) putClient(host string, spec *v1alpha1.GitHubIdentityProviderSpec, client *http.Client) {
+	c.cache.Set(c.cacheKey(host, spec), client, httpClientCacheTTL)
+}
+
+func (c *lruClientCache) cacheKey(host string, spec *v1alpha1.GitHubIdentityProviderSpec) interface{} {
+	var key struct{ host, caBundle string }
+	key.host = host
+	if spec.GitHubAPI.TLS != nil {
+		key.caBundle = spec.GitHubAPI.TLS.CertificateAuthorityData
+	}
+	return key
+}
+
+type githubWatcherController struct {
+	cache                          UpstreamGitHubIdentityProviderICache
+	log                            logr.Logger
+	client                         pinnipedclientset.Interface
+	githubIdentityProviderInformer idpinformers.GitHubIdentityProviderInformer
+	secretInformer                 corev1informers.SecretInformer
+	clientCache                    interface {
+		getClient(string, *v1alpha1.GitHubIdentityProviderSpec) *http.Client
+		putClient(string, *v1alpha1.GitHubIdentityProviderSpec, *http.Client)
+	}
+}
+
+// New instantiates a new controllerlib.Controller which will populate the provided UpstreamGitHubIdentityProviderICache.
+func New(
+	idpCache UpstreamGitHubIdentityProviderICache,
+	client pinnipedclientset.Interface,
+	githubIdentityProviderInformer idpinformers.GitHubIdentityProviderInformer,
+	secretInformer corev1informers.SecretInformer,
+	log logr.Logger,
+	withInformer pinnipedcontroller.WithInformerOptionFunc,
+) controllerlib.Controller {
+	c := githubWatcherController{
+		cache:                          idpCache,
+		log:                            log.WithName(githubControllerName),
+		client:                         client,
+		githubIdentityProviderInformer: githubIdentityProviderInformer,
+		secretInformer:                 secretInformer,
+		clientCache:                    &lruClientCache{cache: cache.NewExpiring()},
+	}
+	return controllerlib.New(
+		controllerlib.Config{Name: githubControllerName, Syncer: &c},
+		withInformer(
+			githubIdentityProviderInformer,
+			pinnipedcontroller.MatchAnythingFilter(pinnipedcontroller.SingletonQueue()),
+			controllerlib.InformerOption{},
+		),
+		withInformer(
+			secretInformer,
+			pinnipedcontroller.MatchAnySecretOfTypeFilter(githubClientSecretType, pinnipedcontroller.SingletonQueue()),
+			controllerlib.InformerOption{},
+		),
+	)
+}
+
+// Sync implements controllerlib.Syncer.
+func (c *githubWatcherController) Sync(ctx controllerlib.Context) error {
+	actualUpstreams, err := c.githubIdentityProviderInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list GitHubIdentityProviders: %w", err)
+	}
+
+	requeue := false
+	validatedUpstreams := make([]provider.UpstreamOIDCIdentityProviderI, 0, len(actualUpstreams))
+	for _, upstream := range actualUpstreams {
+		valid := c.validateUpstream(ctx, upstream)
+		if valid == nil {
+			requeue = true
+		} else {
+			validatedUpstreams = append(validatedUpstreams, provider.UpstreamOIDCIdentityProviderI(valid))
+		}
+	}
+	c.cache.SetGitHubIdentityProviders(validatedUpstreams)
+	if requeue {
+		return controllerlib.ErrSyntheticRequeue
+	}
+	return nil
+}
+
+// validateUpstream validates the provided v1alpha1.GitHubIdentityProvider and returns the validated configuration as a
+// upstreamgithub.ProviderConfig. As a side effect, it also updates the status of the v1alpha1.GitHubIdentityProvider.
+func (c *githubWatcherController) validateUpstream(ctx controllerlib.Context, upstream *v1alpha1.GitHubIdentityProvider) *upstreamgithub.ProviderConfig {
+	result := upstreamgithub.ProviderConfig{
+		Name: upstream.Name,
+		Config: &oauth2.Config{
+			Scopes: []string{"read:user", "read:org"},
+		},
+		UsernameAttribute:  v1alpha1.GitHubUsernameLoginAndID,
+		GroupNameAttribute: v1alpha1.GitHubUseTeamSlugForGroupName,
+	}
+	if upstream.Spec.Claims.Username != nil {
+		result.UsernameAttribute = *upstream.Spec.Claims.Username
+	}
+	if upstream.Spec.Claims.Groups != nil {
+		result.GroupNameAttribute = *upstream.Spec.Claims.Groups
+	}
+
+	conditions := []*v1alpha1.Condition{
+		c.validateSecret(upstream, &result),
+		c.validateHost(upstream, &result),
+		c.validateTLS(upstream, &result),
+		c.validateOrganizationsPolicy(upstream, &result),
+	}
+	c.updateStatus(ctx.Context, upstream, conditions)
+
+	valid := true
+	log := c.log.WithValues("namespace", upstream.Namespace, "name", upstream.Name)
+	for _, condition := range conditions {
+		if condition.Status == v1alpha1.ConditionFalse {
+			valid = false
+			log.WithValues(
+				"type", condition.Type,
+				"reason", condition.Reason,
+				"message", condition.Message,
+			).Error(errGitHubFailureStatus, "found failing condition")
+		}
+	}
+	if valid {
+		return &result
+	}
+	return nil
+}
+
+// validateSecret validates the .spec.client.secretName field and returns the appropriate ClientCredentialsValid condition.
+func (c *githubWatcherController) validateSecret(upstream *v1alpha1.GitHubIdentityProvider, result *upstreamgithub.ProviderConfig) *v1alpha1.Condition {
+	secretName := upstream.Spec.Client.SecretName
+
+	// Fetch the Secret from informer cache.
+	secret, err := c.secretInformer.Lister().Secrets(upstream.Namespace).Get(secretName)
+	if err != nil {
+		return &v1alpha1.Condition{
+			Type:    typeClientCredentialsValid,
+			Status:  v1alpha1.ConditionFalse,
+			Reason:  upstreamwatchers.ReasonNotFound,
+			Message: err.Error(),
+		}
+	}
+
+	// Validate the secret .type field.
+	if secret.Type != githubClientSecretType {
+		return &v1alpha1.Condition{
+			Type:    typeClientCredentialsValid,
+			Status:  v1alpha1.ConditionFalse,
+			Reason:  upstreamwatchers.ReasonWrongType,
+			Message: fmt.Sprintf("referenced Secret %q has wrong type %q (should be %q)", secretName, secret.Type, githubClientSecretType),
+		}
+	}
+
+	// Validate the secret .data field.
+	clientID := secret.Data[clientIDDataKey]
+	clientSecret := secret.Data[clientSecretDataKey]
+	if len(clientID) == 0 || len(clientSecret) == 0 {
+		return &v1alpha1.Condition{
+			Type:    typeClientCredentialsValid,
+			Status:  v1alpha1.ConditionFalse,
+			Reason:  upstreamwatchers.ReasonMissingKeys,
+			Message: fmt.Sprintf("referenced Secret %q is missing required keys %q", secretName, []string{clientIDDataKey, clientSecretDataKey}),
+		}
+	}
+
+	// If everything is valid, update the result and set the condition to true.
+	result.Config.ClientID = string(clientID)
+	result.Config.ClientSecret = string(clientSecret)
+	return &v1alpha1.Condition{
+		Type:    typeClientCredentialsValid,
+		Status:  v1alpha1.ConditionTrue,
+		Reason:  upstreamwatchers.ReasonSuccess,
+		Message: "loaded client credentials",
+	}
+}
+
+// validateHost validates the .spec.githubAPI.host field, derives the endpoints of the GitHub server from it,
+// and returns the appropriate HostValid condition.
+func (c *githubWatcherController) validateHost(upstream *v1alpha1.GitHubIdentityProvider, result *upstreamgithub.ProviderConfig) *v1alpha1.Condition {
+	host := hostOf(upstream)
+
+	hostURL, err := url.Parse("https://" + host)
+	if err != nil || hostURL.Hostname() == "" || hostURL.Host != host || hostURL.User != nil {
+		return &v1alpha1.Condition{
+			Type:    typeHostValid,
+			Status:  v1alpha1.ConditionFalse,
+			Reason:  reasonInvalid,
+			Message: fmt.Sprintf("spec.githubAPI.host (%q) must be a hostname with an optional port, without a scheme or a path", host),
+		}
+	}
+
+	// If everything is valid, update the result and set the condition to true.
+	result.Issuer = hostURL.String()
+	result.Config.Endpoint = oauth2.Endpoint{
+		AuthURL:   hostURL.String() + "/login/oauth/authorize",
+		TokenURL:  hostURL.String() + "/login/oauth/access_token",
+		AuthStyle: oauth2.AuthStyleInParams,
+	}
+	if host == defaultHost {
+		result.APIBaseURL = "https://" + defaultAPIHost
+	} else {
+		result.APIBaseURL = hostURL.String() + "/api/v3"
+	}
+	return &v1alpha1.Condition{
+		Type:    typeHostValid,
+		Status:  v1alpha1.ConditionTrue,
+		Reason:  upstreamwatchers.ReasonSuccess,
+		Message: fmt.Sprintf("spec.githubAPI.host (%q) is valid", host),
+	}
+}
+
+// validateTLS validates the .spec.githubAPI.tls field, prepares an HTTP client for the GitHub server,
+// and returns the appropriate TLSConfigurationValid condition.
+func (c *githubWatcherController) validateTLS(upstream *v1alpha1.GitHubIdentityProvider, result *upstreamgithub.ProviderConfig) *v1alpha1.Condition {
+	host := hostOf(upstream)
+
+	// Get the HTTP Client from cache if possible.
+	httpClient := c.clientCache.getClient(host, &upstream.Spec)
+
+	// If the client does not exist in the cache, make a new one and save it to the cache.
+	if httpClient == nil {
+		tlsConfig, err := getTLSConfig(upstream)
+		if err != nil {
+			return &v1alpha1.Condition{
+				Type:    typeTLSConfigurationValid,
+				Status:  v1alpha1.ConditionFalse,
+				Reason:  upstreamwatchers.ReasonInvalidTLSConfig,
+				Message: err.Error(),
+			}
+		}
+
+		httpClient = &http.Client{
+			Timeout: time.Minute,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		}
+		c.clientCache.putClient(host, &upstream.Spec, httpClient)
+	}
+
+	// If everything is valid, update the result and set the condition to true.
+	result.Client = httpClient
+	return &v1alpha1.Condition{
+		Type:    typeTLSConfigurationValid,
+		Status:  v1alpha1.ConditionTrue,
+		Reason:  upstreamwatchers.ReasonSuccess,
+		Message: "spec.githubAPI.tls is valid",
+	}
+}
+
+// validateOrganizationsPolicy validates the .spec.allowAuthentication.organizations field and returns the
+// appropriate OrganizationsPolicyValid condition.
+func (c *githubWatcherController) validateOrganizationsPolicy(upstream *v1alpha1.GitHubIdentityProvider, result *upstreamgithub.ProviderConfig) *v1alpha1.Condition {
+	organizations := upstream.Spec.AllowAuthentication.Organizations
+
+	policy := v1alpha1.GitHubAllowedAuthOrganizationsPolicyOnlyUsersFromAllowedOrganizations
+	if organizations.Policy != nil {
+		policy = *organizations.Policy
+	}
+
+	var message string
+	switch {
+	case policy == v1alpha1.GitHubAllowedAuthOrganizationsPolicyOnlyUsersFromAllowedOrganizations && len(organizations.Allowed) == 0:
+		message = fmt.Sprintf("spec.allowAuthentication.organizations.allowed must not be empty when spec.allowAuthentication.organizations.policy is %q", policy)
+	case policy == v1alpha1.GitHubAllowedAuthOrganizationsPolicyAllGitHubUsers && len(organizations.Allowed) != 0:
+		message = fmt.Sprintf("spec.allowAuthentication.organizations.allowed must be empty when spec.allowAuthentication.organizations.policy is %q", policy)
+	case policy != v1alpha1.GitHubAllowedAuthOrganizationsPolicyOnlyUsersFromAllowedOrganizations && policy != v1alpha1.GitHubAllowedAuthOrganizationsPolicyAllGitHubUsers:
+		message = fmt.Sprintf("spec.allowAuthentication.organizations.policy has unknown value %q", policy)
+	}
+	if message != "" {
+		return &v1alpha1.Condition{
+			Type:    typeOrganizationsPolicyValid,
+			Status:  v1alpha1.ConditionFalse,
+			Reason:  reasonInvalid,
+			Message: message,
+		}
+	}
+
+	// If everything is valid, update the result and set the condition to true.
+	result.AllowedOrganizations = organizations.Allowed
+	return &v1alpha1.Condition{
+		Type:    typeOrganizationsPolicyValid,
+		Status:  v1alpha1.ConditionTrue,
+		Reason:  upstreamwatchers.ReasonSuccess,
+		Message: fmt.Sprintf("spec.allowAuthentication.organizations.policy (%q) is valid", policy),
+	}
+}
+
+func (c *githubWatcherController) updateStatus(ctx context.Context, upstream *v1alpha1.GitHubIdentityProvider, conditions []*v1alpha1.Condition) {
+	log := c.log.WithValues("namespace", upstream.Namespace, "name", upstream.Name)
+	updated := upstream.DeepCopy()
+
+	hadErrorCondition := conditionsutil.Merge(conditions, upstream.Generation, &updated.Status.Conditions, log)
+
+	updated.Status.Phase = v1alpha1.GitHubPhaseReady
+	if hadErrorCondition {
+		updated.Status.Phase = v1alpha1.GitHubPhaseError
+	}
+
+	if equality.Semantic.DeepEqual(upstream, updated) {
+		return
+	}
+
+	_, err := c.client.
+		IDPV1alpha1().
+		GitHubIdentityProviders(upstream.Namespace).
+		UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+	if err != nil {
+		log.Error(err, "failed to update status")
+	}
+}
+
+func hostOf(upstream *v1alpha1.GitHubIdentityProvider) string {
+	if upstream.Spec.GitHubAPI.Host == nil {
+		return defaultHost
+	}
+	return *upstream.Spec.GitHubAPI.Host
+}
+
+func getTLSConfig(upstream *v1alpha1.GitHubIdentityProvider) (*tls.Config, error) {
+	result := tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if upstream.Spec.GitHubAPI.TLS == nil || upstream.Spec.GitHubAPI.TLS.CertificateAuthorityData == "" {
+		return &result, nil
+	}
+
+	bundle, err := base64.StdEncoding.DecodeString(upstream.Spec.GitHubAPI.TLS.CertificateAuthorityData)
+	if err != nil {
+		return nil, fmt.Errorf("spec.githubAPI.tls.certificateAuthorityData is invalid: %w", err)
+	}
+
+	result.RootCAs = x509.NewCertPool()
+	if !result.RootCAs.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("spec.githubAPI.tls.certificateAuthorityData is invalid: %w", upstreamwatchers.ErrNoCertificates)
+	}
+
+	return &result, nil
+}
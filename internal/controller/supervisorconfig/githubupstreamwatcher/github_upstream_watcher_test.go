@@ -0,0 +1,557 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package githubupstreamwatcher
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+	pinnipedfake "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/fake"
+	pinnipedinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/testutil"
+	"go.pinniped.dev/internal/testutil/testlogger"
+	"go.pinniped.dev/internal/upstreamgithub"
+)
+
+func TestGitHubUpstreamWatcherControllerFilterSecret(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		secret     metav1.Object
+		wantAdd    bool
+		wantUpdate bool
+		wantDelete bool
+	}{
+		{
+			name: "a secret of the right type",
+			secret: &corev1.Secret{
+				Type:       "secrets.pinniped.dev/github-client",
+				ObjectMeta: metav1.ObjectMeta{Name: "some-name", Namespace: "some-namespace"},
+			},
+			wantAdd:    true,
+			wantUpdate: true,
+			wantDelete: true,
+		},
+		{
+			name: "a secret of the wrong type",
+			secret: &corev1.Secret{
+				Type:       "secrets.pinniped.dev/oidc-client",
+				ObjectMeta: metav1.ObjectMeta{Name: "some-name", Namespace: "some-namespace"},
+			},
+		},
+		{
+			name: "resource of wrong data type",
+			secret: &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "some-name", Namespace: "some-namespace"},
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			fakePinnipedClient := pinnipedfake.NewSimpleClientset()
+			pinnipedInformers := pinnipedinformers.NewSharedInformerFactory(fakePinnipedClient, 0)
+			fakeKubeClient := fake.NewSimpleClientset()
+			kubeInformers := informers.NewSharedInformerFactory(fakeKubeClient, 0)
+			testLog := testlogger.New(t)
+			cache := provider.NewDynamicUpstreamIDPProvider()
+			secretInformer := kubeInformers.Core().V1().Secrets()
+			withInformer := testutil.NewObservableWithInformerOption()
+
+			New(
+				cache,
+				nil,
+				pinnipedInformers.IDP().V1alpha1().GitHubIdentityProviders(),
+				secretInformer,
+				testLog,
+				withInformer.WithInformer,
+			)
+
+			unrelated := corev1.Secret{}
+			filter := withInformer.GetFilterForInformer(secretInformer)
+			require.Equal(t, test.wantAdd, filter.Add(test.secret))
+			require.Equal(t, test.wantUpdate, filter.Update(&unrelated, test.secret))
+			require.Equal(t, test.wantUpdate, filter.Update(test.secret, &unrelated))
+			require.Equal(t, test.wantDelete, filter.Delete(test.secret))
+		})
+	}
+}
+
+func TestGitHubUpstreamWatcherControllerSync(t *testing.T) {
+	t.Parallel()
+	now := metav1.NewTime(time.Now().UTC())
+	earlier := metav1.NewTime(now.Add(-1 * time.Hour).UTC())
+
+	testCA, _ := testutil.TLSTestServer(t, func(w http.ResponseWriter, r *http.Request) {})
+	testCABase64 := base64.StdEncoding.EncodeToString([]byte(testCA))
+
+	var (
+		testNamespace       = "test-namespace"
+		testName            = "test-name"
+		testSecretName      = "test-client-secret"
+		testClientID        = "test-github-client-id"
+		testClientSecret    = "test-github-client-secret"
+		testValidSecretData = map[string][]byte{"clientID": []byte(testClientID), "clientSecret": []byte(testClientSecret)}
+		testValidSecret     = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testSecretName},
+			Type:       "secrets.pinniped.dev/github-client",
+			Data:       testValidSecretData,
+		}
+		testAllowedOrgs    = []string{"some-org", "some-other-org"}
+		allGitHubUsers     = v1alpha1.GitHubAllowedAuthOrganizationsPolicyAllGitHubUsers
+		usernameLogin      = v1alpha1.GitHubUsernameLogin
+		groupNameTeamName  = v1alpha1.GitHubUseTeamNameForGroupName
+		enterpriseHost     = "github.example.com:8443"
+		invalidHost        = "https://github.example.com"
+		validSecretCond    = v1alpha1.Condition{Type: "ClientCredentialsValid", Status: "True", LastTransitionTime: now, Reason: "Success", Message: "loaded client credentials"}
+		validHostCond      = v1alpha1.Condition{Type: "HostValid", Status: "True", LastTransitionTime: now, Reason: "Success", Message: `spec.githubAPI.host ("github.com") is valid`}
+		validPolicyCond    = v1alpha1.Condition{Type: "OrganizationsPolicyValid", Status: "True", LastTransitionTime: now, Reason: "Success", Message: `spec.allowAuthentication.organizations.policy ("OnlyUsersFromAllowedOrganizations") is valid`}
+		validTLSCond       = v1alpha1.Condition{Type: "TLSConfigurationValid", Status: "True", LastTransitionTime: now, Reason: "Success", Message: "spec.githubAPI.tls is valid"}
+		validSecretLog     = `github-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="loaded client credentials" "reason"="Success" "status"="True" "type"="ClientCredentialsValid"`
+		validHostLog       = `github-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="spec.githubAPI.host (\"github.com\") is valid" "reason"="Success" "status"="True" "type"="HostValid"`
+		validTLSLog        = `github-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="spec.githubAPI.tls is valid" "reason"="Success" "status"="True" "type"="TLSConfigurationValid"`
+		validPolicyLog     = `github-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="spec.allowAuthentication.organizations.policy (\"OnlyUsersFromAllowedOrganizations\") is valid" "reason"="Success" "status"="True" "type"="OrganizationsPolicyValid"`
+		defaultOrgsAllowed = v1alpha1.GitHubAllowAuthenticationSpec{Organizations: v1alpha1.GitHubOrganizationsSpec{Allowed: testAllowedOrgs}}
+	)
+
+	tests := []struct {
+		name                   string
+		inputUpstreams         []runtime.Object
+		inputSecrets           []runtime.Object
+		wantErr                string
+		wantLogs               []string
+		wantResultingCache     []*upstreamgithub.ProviderConfig
+		wantResultingUpstreams []v1alpha1.GitHubIdentityProvider
+	}{
+		{
+			name: "no upstreams",
+		},
+		{
+			name: "missing secret",
+			inputUpstreams: []runtime.Object{&v1alpha1.GitHubIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Spec: v1alpha1.GitHubIdentityProviderSpec{
+					AllowAuthentication: defaultOrgsAllowed,
+					Client:              v1alpha1.GitHubClientSpec{SecretName: testSecretName},
+				},
+			}},
+			wantErr: controllerlib.ErrSyntheticRequeue.Error(),
+			wantLogs: []string{
+				`github-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="secret \"test-client-secret\" not found" "reason"="SecretNotFound" "status"="False" "type"="ClientCredentialsValid"`,
+				validHostLog,
+				validTLSLog,
+				validPolicyLog,
+				`github-upstream-observer "msg"="found failing condition" "error"="GitHubIdentityProvider has a failing condition" "message"="secret \"test-client-secret\" not found" "name"="test-name" "namespace"="test-namespace" "reason"="SecretNotFound" "type"="ClientCredentialsValid"`,
+			},
+			wantResultingCache: []*upstreamgithub.ProviderConfig{},
+			wantResultingUpstreams: []v1alpha1.GitHubIdentityProvider{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Status: v1alpha1.GitHubIdentityProviderStatus{
+					Phase: "Error",
+					Conditions: []v1alpha1.Condition{
+						{Type: "ClientCredentialsValid", Status: "False", LastTransitionTime: now, Reason: "SecretNotFound", Message: `secret "test-client-secret" not found`},
+						validHostCond,
+						validPolicyCond,
+						validTLSCond,
+					},
+				},
+			}},
+		},
+		{
+			name: "secret has wrong type",
+			inputUpstreams: []runtime.Object{&v1alpha1.GitHubIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Spec: v1alpha1.GitHubIdentityProviderSpec{
+					AllowAuthentication: defaultOrgsAllowed,
+					Client:              v1alpha1.GitHubClientSpec{SecretName: testSecretName},
+				},
+			}},
+			inputSecrets: []runtime.Object{&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testSecretName},
+				Type:       "secrets.pinniped.dev/oidc-client",
+				Data:       testValidSecretData,
+			}},
+			wantErr: controllerlib.ErrSyntheticRequeue.Error(),
+			wantLogs: []string{
+				`github-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="referenced Secret \"test-client-secret\" has wrong type \"secrets.pinniped.dev/oidc-client\" (should be \"secrets.pinniped.dev/github-client\")" "reason"="SecretWrongType" "status"="False" "type"="ClientCredentialsValid"`,
+				validHostLog,
+				validTLSLog,
+				validPolicyLog,
+				`github-upstream-observer "msg"="found failing condition" "error"="GitHubIdentityProvider has a failing condition" "message"="referenced Secret \"test-client-secret\" has wrong type \"secrets.pinniped.dev/oidc-client\" (should be \"secrets.pinniped.dev/github-client\")" "name"="test-name" "namespace"="test-namespace" "reason"="SecretWrongType" "type"="ClientCredentialsValid"`,
+			},
+			wantResultingCache: []*upstreamgithub.ProviderConfig{},
+			wantResultingUpstreams: []v1alpha1.GitHubIdentityProvider{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Status: v1alpha1.GitHubIdentityProviderStatus{
+					Phase: "Error",
+					Conditions: []v1alpha1.Condition{
+						{Type: "ClientCredentialsValid", Status: "False", LastTransitionTime: now, Reason: "SecretWrongType", Message: `referenced Secret "test-client-secret" has wrong type "secrets.pinniped.dev/oidc-client" (should be "secrets.pinniped.dev/github-client")`},
+						validHostCond,
+						validPolicyCond,
+						validTLSCond,
+					},
+				},
+			}},
+		},
+		{
+			name: "secret is missing key",
+			inputUpstreams: []runtime.Object{&v1alpha1.GitHubIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Spec: v1alpha1.GitHubIdentityProviderSpec{
+					AllowAuthentication: defaultOrgsAllowed,
+					Client:              v1alpha1.GitHubClientSpec{SecretName: testSecretName},
+				},
+			}},
+			inputSecrets: []runtime.Object{&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testSecretName},
+				Type:       "secrets.pinniped.dev/github-client",
+				Data:       map[string][]byte{"clientID": []byte(testClientID)},
+			}},
+			wantErr: controllerlib.ErrSyntheticRequeue.Error(),
+			wantLogs: []string{
+				`github-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="referenced Secret \"test-client-secret\" is missing required keys [\"clientID\" \"clientSecret\"]" "reason"="SecretMissingKeys" "status"="False" "type"="ClientCredentialsValid"`,
+				validHostLog,
+				validTLSLog,
+				validPolicyLog,
+				`github-upstream-observer "msg"="found failing condition" "error"="GitHubIdentityProvider has a failing condition" "message"="referenced Secret \"test-client-secret\" is missing required keys [\"clientID\" \"clientSecret\"]" "name"="test-name" "namespace"="test-namespace" "reason"="SecretMissingKeys" "type"="ClientCredentialsValid"`,
+			},
+			wantResultingCache: []*upstreamgithub.ProviderConfig{},
+			wantResultingUpstreams: []v1alpha1.GitHubIdentityProvider{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Status: v1alpha1.GitHubIdentityProviderStatus{
+					Phase: "Error",
+					Conditions: []v1alpha1.Condition{
+						{Type: "ClientCredentialsValid", Status: "False", LastTransitionTime: now, Reason: "SecretMissingKeys", Message: `referenced Secret "test-client-secret" is missing required keys ["clientID" "clientSecret"]`},
+						validHostCond,
+						validPolicyCond,
+						validTLSCond,
+					},
+				},
+			}},
+		},
+		{
+			name: "host is not a hostname and TLS CA bundle does not have any certificates",
+			inputUpstreams: []runtime.Object{&v1alpha1.GitHubIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Spec: v1alpha1.GitHubIdentityProviderSpec{
+					GitHubAPI: v1alpha1.GitHubAPIConfig{
+						Host: &invalidHost,
+						TLS:  &v1alpha1.TLSSpec{CertificateAuthorityData: base64.StdEncoding.EncodeToString([]byte("not a pem cert"))},
+					},
+					AllowAuthentication: defaultOrgsAllowed,
+					Client:              v1alpha1.GitHubClientSpec{SecretName: testSecretName},
+				},
+			}},
+			inputSecrets: []runtime.Object{testValidSecret},
+			wantErr:      controllerlib.ErrSyntheticRequeue.Error(),
+			wantLogs: []string{
+				validSecretLog,
+				`github-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="spec.githubAPI.host (\"https://github.example.com\") must be a hostname with an optional port, without a scheme or a path" "reason"="Invalid" "status"="False" "type"="HostValid"`,
+				`github-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="spec.githubAPI.tls.certificateAuthorityData is invalid: no certificates found" "reason"="InvalidTLSConfig" "status"="False" "type"="TLSConfigurationValid"`,
+				validPolicyLog,
+				`github-upstream-observer "msg"="found failing condition" "error"="GitHubIdentityProvider has a failing condition" "message"="spec.githubAPI.host (\"https://github.example.com\") must be a hostname with an optional port, without a scheme or a path" "name"="test-name" "namespace"="test-namespace" "reason"="Invalid" "type"="HostValid"`,
+				`github-upstream-observer "msg"="found failing condition" "error"="GitHubIdentityProvider has a failing condition" "message"="spec.githubAPI.tls.certificateAuthorityData is invalid: no certificates found" "name"="test-name" "namespace"="test-namespace" "reason"="InvalidTLSConfig" "type"="TLSConfigurationValid"`,
+			},
+			wantResultingCache: []*upstreamgithub.ProviderConfig{},
+			wantResultingUpstreams: []v1alpha1.GitHubIdentityProvider{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Status: v1alpha1.GitHubIdentityProviderStatus{
+					Phase: "Error",
+					Conditions: []v1alpha1.Condition{
+						validSecretCond,
+						{Type: "HostValid", Status: "False", LastTransitionTime: now, Reason: "Invalid", Message: `spec.githubAPI.host ("https://github.example.com") must be a hostname with an optional port, without a scheme or a path`},
+						validPolicyCond,
+						{Type: "TLSConfigurationValid", Status: "False", LastTransitionTime: now, Reason: "InvalidTLSConfig", Message: "spec.githubAPI.tls.certificateAuthorityData is invalid: no certificates found"},
+					},
+				},
+			}},
+		},
+		{
+			name: "only users from allowed organizations, but no organizations are allowed",
+			inputUpstreams: []runtime.Object{&v1alpha1.GitHubIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Spec: v1alpha1.GitHubIdentityProviderSpec{
+					Client: v1alpha1.GitHubClientSpec{SecretName: testSecretName},
+				},
+			}},
+			inputSecrets: []runtime.Object{testValidSecret},
+			wantErr:      controllerlib.ErrSyntheticRequeue.Error(),
+			wantLogs: []string{
+				validSecretLog,
+				validHostLog,
+				validTLSLog,
+				`github-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="spec.allowAuthentication.organizations.allowed must not be empty when spec.allowAuthentication.organizations.policy is \"OnlyUsersFromAllowedOrganizations\"" "reason"="Invalid" "status"="False" "type"="OrganizationsPolicyValid"`,
+				`github-upstream-observer "msg"="found failing condition" "error"="GitHubIdentityProvider has a failing condition" "message"="spec.allowAuthentication.organizations.allowed must not be empty when spec.allowAuthentication.organizations.policy is \"OnlyUsersFromAllowedOrganizations\"" "name"="test-name" "namespace"="test-namespace" "reason"="Invalid" "type"="OrganizationsPolicyValid"`,
+			},
+			wantResultingCache: []*upstreamgithub.ProviderConfig{},
+			wantResultingUpstreams: []v1alpha1.GitHubIdentityProvider{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Status: v1alpha1.GitHubIdentityProviderStatus{
+					Phase: "Error",
+					Conditions: []v1alpha1.Condition{
+						validSecretCond,
+						validHostCond,
+						{Type: "OrganizationsPolicyValid", Status: "False", LastTransitionTime: now, Reason: "Invalid", Message: `spec.allowAuthentication.organizations.allowed must not be empty when spec.allowAuthentication.organizations.policy is "OnlyUsersFromAllowedOrganizations"`},
+						validTLSCond,
+					},
+				},
+			}},
+		},
+		{
+			name: "all GitHub users are allowed, but some organizations are also listed",
+			inputUpstreams: []runtime.Object{&v1alpha1.GitHubIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Spec: v1alpha1.GitHubIdentityProviderSpec{
+					AllowAuthentication: v1alpha1.GitHubAllowAuthenticationSpec{Organizations: v1alpha1.GitHubOrganizationsSpec{
+						Policy:  &allGitHubUsers,
+						Allowed: testAllowedOrgs,
+					}},
+					Client: v1alpha1.GitHubClientSpec{SecretName: testSecretName},
+				},
+			}},
+			inputSecrets: []runtime.Object{testValidSecret},
+			wantErr:      controllerlib.ErrSyntheticRequeue.Error(),
+			wantLogs: []string{
+				validSecretLog,
+				validHostLog,
+				validTLSLog,
+				`github-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="spec.allowAuthentication.organizations.allowed must be empty when spec.allowAuthentication.organizations.policy is \"AllGitHubUsers\"" "reason"="Invalid" "status"="False" "type"="OrganizationsPolicyValid"`,
+				`github-upstream-observer "msg"="found failing condition" "error"="GitHubIdentityProvider has a failing condition" "message"="spec.allowAuthentication.organizations.allowed must be empty when spec.allowAuthentication.organizations.policy is \"AllGitHubUsers\"" "name"="test-name" "namespace"="test-namespace" "reason"="Invalid" "type"="OrganizationsPolicyValid"`,
+			},
+			wantResultingCache: []*upstreamgithub.ProviderConfig{},
+			wantResultingUpstreams: []v1alpha1.GitHubIdentityProvider{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Status: v1alpha1.GitHubIdentityProviderStatus{
+					Phase: "Error",
+					Conditions: []v1alpha1.Condition{
+						validSecretCond,
+						validHostCond,
+						{Type: "OrganizationsPolicyValid", Status: "False", LastTransitionTime: now, Reason: "Invalid", Message: `spec.allowAuthentication.organizations.allowed must be empty when spec.allowAuthentication.organizations.policy is "AllGitHubUsers"`},
+						validTLSCond,
+					},
+				},
+			}},
+		},
+		{
+			name: "upstream for github.com becomes valid",
+			inputUpstreams: []runtime.Object{&v1alpha1.GitHubIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Spec: v1alpha1.GitHubIdentityProviderSpec{
+					AllowAuthentication: defaultOrgsAllowed,
+					Client:              v1alpha1.GitHubClientSpec{SecretName: testSecretName},
+				},
+				Status: v1alpha1.GitHubIdentityProviderStatus{
+					Phase: "Error",
+					Conditions: []v1alpha1.Condition{
+						{Type: "ClientCredentialsValid", Status: "False", LastTransitionTime: earlier, Reason: "SomeError1", Message: "some previous error 1"},
+						validHostCond,
+						validPolicyCond,
+						validTLSCond,
+					},
+				},
+			}},
+			inputSecrets: []runtime.Object{testValidSecret},
+			wantLogs:     []string{validSecretLog},
+			wantResultingCache: []*upstreamgithub.ProviderConfig{{
+				Name:       testName,
+				Issuer:     "https://github.com",
+				APIBaseURL: "https://api.github.com",
+				Config: &oauth2.Config{
+					ClientID:     testClientID,
+					ClientSecret: testClientSecret,
+					Endpoint: oauth2.Endpoint{
+						AuthURL:   "https://github.com/login/oauth/authorize",
+						TokenURL:  "https://github.com/login/oauth/access_token",
+						AuthStyle: oauth2.AuthStyleInParams,
+					},
+					Scopes: []string{"read:user", "read:org"},
+				},
+				UsernameAttribute:    "login:id",
+				GroupNameAttribute:   "slug",
+				AllowedOrganizations: testAllowedOrgs,
+			}},
+			wantResultingUpstreams: []v1alpha1.GitHubIdentityProvider{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Status: v1alpha1.GitHubIdentityProviderStatus{
+					Phase:      "Ready",
+					Conditions: []v1alpha1.Condition{validSecretCond, validHostCond, validPolicyCond, validTLSCond},
+				},
+			}},
+		},
+		{
+			name: "valid upstream for GitHub Enterprise Server which allows all GitHub users",
+			inputUpstreams: []runtime.Object{&v1alpha1.GitHubIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234},
+				Spec: v1alpha1.GitHubIdentityProviderSpec{
+					GitHubAPI: v1alpha1.GitHubAPIConfig{
+						Host: &enterpriseHost,
+						TLS:  &v1alpha1.TLSSpec{CertificateAuthorityData: testCABase64},
+					},
+					Claims: v1alpha1.GitHubClaims{Username: &usernameLogin, Groups: &groupNameTeamName},
+					AllowAuthentication: v1alpha1.GitHubAllowAuthenticationSpec{Organizations: v1alpha1.GitHubOrganizationsSpec{
+						Policy: &allGitHubUsers,
+					}},
+					Client: v1alpha1.GitHubClientSpec{SecretName: testSecretName},
+				},
+				Status: v1alpha1.GitHubIdentityProviderStatus{
+					Phase: "Ready",
+					Conditions: []v1alpha1.Condition{
+						{Type: "ClientCredentialsValid", Status: "True", LastTransitionTime: earlier, Reason: "Success", Message: "loaded client credentials", ObservedGeneration: 1234},
+						{Type: "HostValid", Status: "True", LastTransitionTime: earlier, Reason: "Success", Message: `spec.githubAPI.host ("github.example.com:8443") is valid`, ObservedGeneration: 1234},
+						{Type: "OrganizationsPolicyValid", Status: "True", LastTransitionTime: earlier, Reason: "Success", Message: `spec.allowAuthentication.organizations.policy ("AllGitHubUsers") is valid`, ObservedGeneration: 1234},
+						{Type: "TLSConfigurationValid", Status: "True", LastTransitionTime: earlier, Reason: "Success", Message: "spec.githubAPI.tls is valid", ObservedGeneration: 1234},
+					},
+				},
+			}},
+			inputSecrets: []runtime.Object{testValidSecret},
+			wantResultingCache: []*upstreamgithub.ProviderConfig{{
+				Name:       testName,
+				Issuer:     "https://github.example.com:8443",
+				APIBaseURL: "https://github.example.com:8443/api/v3",
+				Config: &oauth2.Config{
+					ClientID:     testClientID,
+					ClientSecret: testClientSecret,
+					Endpoint: oauth2.Endpoint{
+						AuthURL:   "https://github.example.com:8443/login/oauth/authorize",
+						TokenURL:  "https://github.example.com:8443/login/oauth/access_token",
+						AuthStyle: oauth2.AuthStyleInParams,
+					},
+					Scopes: []string{"read:user", "read:org"},
+				},
+				UsernameAttribute:  "login",
+				GroupNameAttribute: "name",
+			}},
+			wantResultingUpstreams: []v1alpha1.GitHubIdentityProvider{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234},
+				Status: v1alpha1.GitHubIdentityProviderStatus{
+					Phase: "Ready",
+					Conditions: []v1alpha1.Condition{
+						{Type: "ClientCredentialsValid", Status: "True", LastTransitionTime: earlier, Reason: "Success", Message: "loaded client credentials", ObservedGeneration: 1234},
+						{Type: "HostValid", Status: "True", LastTransitionTime: earlier, Reason: "Success", Message: `spec.githubAPI.host ("github.example.com:8443") is valid`, ObservedGeneration: 1234},
+						{Type: "OrganizationsPolicyValid", Status: "True", LastTransitionTime: earlier, Reason: "Success", Message: `spec.allowAuthentication.organizations.policy ("AllGitHubUsers") is valid`, ObservedGeneration: 1234},
+						{Type: "TLSConfigurationValid", Status: "True", LastTransitionTime: earlier, Reason: "Success", Message: "spec.githubAPI.tls is valid", ObservedGeneration: 1234},
+					},
+				},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fakePinnipedClient := pinnipedfake.NewSimpleClientset(tt.inputUpstreams...)
+			pinnipedInformers := pinnipedinformers.NewSharedInformerFactory(fakePinnipedClient, 0)
+			fakeKubeClient := fake.NewSimpleClientset(tt.inputSecrets...)
+			kubeInformers := informers.NewSharedInformerFactory(fakeKubeClient, 0)
+			testLog := testlogger.New(t)
+			cache := provider.NewDynamicUpstreamIDPProvider()
+			cache.SetGitHubIdentityProviders([]provider.UpstreamOIDCIdentityProviderI{
+				&upstreamgithub.ProviderConfig{Name: "initial-entry"},
+			})
+
+			controller := New(
+				cache,
+				fakePinnipedClient,
+				pinnipedInformers.IDP().V1alpha1().GitHubIdentityProviders(),
+				kubeInformers.Core().V1().Secrets(),
+				testLog,
+				controllerlib.WithInformer,
+			)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			pinnipedInformers.Start(ctx.Done())
+			kubeInformers.Start(ctx.Done())
+			controllerlib.TestRunSynchronously(t, controller)
+
+			syncCtx := controllerlib.Context{Context: ctx, Key: controllerlib.Key{}}
+
+			if err := controllerlib.TestSync(t, controller, syncCtx); tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, strings.Join(tt.wantLogs, "\n"), strings.Join(testLog.Lines(), "\n"))
+
+			actualIDPList := cache.GetGitHubIdentityProviders()
+			require.Equal(t, len(tt.wantResultingCache), len(actualIDPList))
+			for i := range actualIDPList {
+				actualIDP := actualIDPList[i].(*upstreamgithub.ProviderConfig)
+
+				// We always want to use the proxy from env on these clients, so although the following assertions
+				// are a little hacky, this is a cheap way to test that we are using it.
+				actualTransport, ok := actualIDP.Client.Transport.(*http.Transport)
+				require.True(t, ok, "expected cached provider to have client with Transport of type *http.Transport")
+				httpProxyFromEnvFunction := reflect.ValueOf(http.ProxyFromEnvironment).Pointer()
+				actualTransportProxyFunction := reflect.ValueOf(actualTransport.Proxy).Pointer()
+				require.Equal(t, httpProxyFromEnvFunction, actualTransportProxyFunction,
+					"Transport should have used http.ProxyFromEnvironment as its Proxy func")
+				require.Equal(t, time.Minute, actualIDP.Client.Timeout)
+				if upstream := tt.inputUpstreams[i].(*v1alpha1.GitHubIdentityProvider); upstream.Spec.GitHubAPI.TLS != nil {
+					require.NotNil(t, actualTransport.TLSClientConfig.RootCAs)
+				}
+
+				// Compare everything else.
+				actualWithoutClient := *actualIDP
+				actualWithoutClient.Client = nil
+				require.Equal(t, tt.wantResultingCache[i], &actualWithoutClient)
+			}
+
+			actualUpstreams, err := fakePinnipedClient.IDPV1alpha1().GitHubIdentityProviders(testNamespace).List(ctx, metav1.ListOptions{})
+			require.NoError(t, err)
+
+			// Assert on the expected Status of the upstreams. Preprocess the upstreams a bit so that they're easier to assert against.
+			require.ElementsMatch(t, tt.wantResultingUpstreams, normalizeGitHubUpstreams(actualUpstreams.Items, now))
+
+			// Running the sync() a second time should be idempotent except for logs, and should return the same error.
+			// This also helps exercise code paths where the HTTP client hits cache.
+			if err := controllerlib.TestSync(t, controller, syncCtx); tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func normalizeGitHubUpstreams(upstreams []v1alpha1.GitHubIdentityProvider, now metav1.Time) []v1alpha1.GitHubIdentityProvider {
+	result := make([]v1alpha1.GitHubIdentityProvider, 0, len(upstreams))
+	for _, u := range upstreams {
+		normalized := u.DeepCopy()
+
+		// We're only interested in comparing the status, so zero out the spec.
+		normalized.Spec = v1alpha1.GitHubIdentityProviderSpec{}
+
+		// Round down the LastTransitionTime values to `now` if they were just updated. This makes
+		// it much easier to encode assertions about the expected timestamps.
+		for i := range normalized.Status.Conditions {
+			if time.Since(normalized.Status.Conditions[i].LastTransitionTime.Time) < 5*time.Second {
+				normalized.Status.Conditions[i].LastTransitionTime = now
+			}
+		}
+		result = append(result, *normalized)
+	}
+
+	return result
+}
@@ -5,18 +5,22 @@ package supervisorconfig
 
 import (
 	"context"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"time"
 
 	"gopkg.in/square/go-jose.v2"
 	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/clock"
 	corev1informers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/util/retry"
@@ -41,6 +45,9 @@ const (
 	//
 	// Note! The value for this key will contain only public key material!
 	jwksKey = "jwks"
+	// jwksMetadataKey points to the times at which the keys in the JWKS were created and replaced, which are used to
+	// decide when to rotate the active JWK and when to stop publishing the previous JWKs.
+	jwksMetadataKey = "jwksMetadata"
 
 	jwksSecretTypeValue corev1.SecretType = "secrets.pinniped.dev/federation-domain-jwks"
 )
@@ -57,10 +64,23 @@ func generateECKey(r io.Reader) (interface{}, error) {
 	return ecdsa.GenerateKey(elliptic.P256(), r)
 }
 
+// jwksMetadata is stored in a FederationDomain's Secret alongside the active JWK and the JWKS.
+type jwksMetadata struct {
+	// ActiveJWKCreatedAt is the time at which the active JWK was generated.
+	ActiveJWKCreatedAt time.Time `json:"activeJWKCreatedAt"`
+
+	// RetiredJWKs maps the key ID of each previous JWK which is still published in the JWKS to the time at which
+	// it was replaced by a newer JWK.
+	RetiredJWKs map[string]time.Time `json:"retiredJWKs,omitempty"`
+}
+
 // jwkController holds the fields necessary for the JWKS controller to communicate with FederationDomains and
 // secrets, both via a cache and via the API.
 type jwksWriterController struct {
 	jwksSecretLabels         map[string]string
+	rotationInterval         time.Duration
+	retentionPeriod          time.Duration
+	clock                    clock.Clock
 	pinnipedClient           pinnipedclientset.Interface
 	kubeClient               kubernetes.Interface
 	federationDomainInformer configinformers.FederationDomainInformer
@@ -68,9 +88,14 @@ type jwksWriterController struct {
 }
 
 // NewJWKSWriterController returns a controllerlib.Controller that ensures a FederationDomain has a corresponding
-// Secret that contains a valid active JWK and JWKS.
+// Secret that contains a valid active JWK and JWKS. The active JWK is replaced by a new JWK once it is older than
+// rotationInterval, and each replaced JWK remains in the JWKS for retentionPeriod so that the tokens which it signed
+// can still be verified.
 func NewJWKSWriterController(
 	jwksSecretLabels map[string]string,
+	rotationInterval time.Duration,
+	retentionPeriod time.Duration,
+	clock clock.Clock,
 	kubeClient kubernetes.Interface,
 	pinnipedClient pinnipedclientset.Interface,
 	secretInformer corev1informers.SecretInformer,
@@ -86,6 +111,9 @@ func NewJWKSWriterController(
 			Name: "JWKSController",
 			Syncer: &jwksWriterController{
 				jwksSecretLabels:         jwksSecretLabels,
+				rotationInterval:         rotationInterval,
+				retentionPeriod:          retentionPeriod,
+				clock:                    clock,
 				kubeClient:               kubeClient,
 				pinnipedClient:           pinnipedClient,
 				secretInformer:           secretInformer,
@@ -191,6 +219,11 @@ func (c *jwksWriterController) secretNeedsUpdate(federationDomain *configv1alpha
 		return true, nil
 	}
 
+	if c.rotationIsDue(secret) {
+		// If this secret's keys need to be rotated or pruned, we need to update it.
+		return true, nil
+	}
+
 	return false, nil
 }
 
@@ -208,10 +241,16 @@ func (c *jwksWriterController) generateSecret(federationDomain *configv1alpha1.F
 
 	jwk := jose.JSONWebKey{
 		Key:       key,
-		KeyID:     "pinniped-supervisor-key",
 		Algorithm: "ES256",
 		Use:       "sig",
 	}
+	// Give each key a unique ID, so clients can tell which key of the JWKS signed a token.
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("cannot calculate jwk thumbprint: %w", err)
+	}
+	jwk.KeyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+
 	jwkData, err := json.Marshal(jwk)
 	if err != nil {
 		return nil, fmt.Errorf("cannot marshal jwk: %w", err)
@@ -225,6 +264,11 @@ func (c *jwksWriterController) generateSecret(federationDomain *configv1alpha1.F
 		return nil, fmt.Errorf("cannot marshal jwks: %w", err)
 	}
 
+	metadataData, err := json.Marshal(jwksMetadata{ActiveJWKCreatedAt: c.clock.Now().UTC()})
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal jwks metadata: %w", err)
+	}
+
 	s := corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      federationDomain.Name + "-jwks",
@@ -239,8 +283,9 @@ func (c *jwksWriterController) generateSecret(federationDomain *configv1alpha1.F
 			},
 		},
 		Data: map[string][]byte{
-			activeJWKKey: jwkData,
-			jwksKey:      jwksData,
+			activeJWKKey:    jwkData,
+			jwksKey:         jwksData,
+			jwksMetadataKey: metadataData,
 		},
 		Type: jwksSecretTypeValue,
 	}
@@ -271,18 +316,125 @@ func (c *jwksWriterController) createOrUpdateSecret(
 
 		// New secret already exists, so ensure it is up to date.
 
+		newData := newSecret.Data
 		if isValid(oldSecret) {
-			// If the secret already has valid JWK's, then we are good to go and we don't need an update.
-			return nil
+			// If the secret already has valid JWK's, then only rotate its active JWK and prune its previous JWKs
+			// when that is due.
+			if !c.rotationIsDue(oldSecret) {
+				return nil
+			}
+			newData, err = c.rotatedSecretData(oldSecret, newSecret)
+			if err != nil {
+				return fmt.Errorf("cannot rotate jwks: %w", err)
+			}
 		}
 
-		oldSecret.Data = newSecret.Data
+		oldSecret.Data = newData
 		oldSecret.Type = jwksSecretTypeValue
 		_, err = secretClient.Update(ctx, oldSecret, metav1.UpdateOptions{})
 		return err
 	})
 }
 
+// rotationIsDue returns whether the active JWK of the provided valid secret should be replaced, or whether any of its
+// previous JWKs should no longer be published. The active JWK of a secret which does not contain any metadata, e.g.
+// because it was written by an older version of the Supervisor, is always replaced.
+func (c *jwksWriterController) rotationIsDue(secret *corev1.Secret) bool {
+	metadata, ok := readJWKSMetadata(secret)
+	if !ok {
+		return true
+	}
+
+	now := c.clock.Now()
+	if !now.Before(metadata.ActiveJWKCreatedAt.Add(c.rotationInterval)) {
+		return true
+	}
+	for _, retiredAt := range metadata.RetiredJWKs {
+		if !now.Before(retiredAt.Add(c.retentionPeriod)) {
+			return true
+		}
+	}
+	return false
+}
+
+// rotatedSecretData returns the data of the provided valid secret after replacing its active JWK with the active JWK
+// of newSecret, when that is due, and after removing the previous JWKs whose retention period has passed.
+func (c *jwksWriterController) rotatedSecretData(oldSecret, newSecret *corev1.Secret) (map[string][]byte, error) {
+	now := c.clock.Now().UTC()
+
+	metadata, ok := readJWKSMetadata(oldSecret)
+	rotate := !ok || !now.Before(metadata.ActiveJWKCreatedAt.Add(c.rotationInterval))
+
+	// Keep publishing the previous JWKs until their retention period has passed.
+	newMetadata := jwksMetadata{ActiveJWKCreatedAt: metadata.ActiveJWKCreatedAt, RetiredJWKs: map[string]time.Time{}}
+	for keyID, retiredAt := range metadata.RetiredJWKs {
+		if now.Before(retiredAt.Add(c.retentionPeriod)) {
+			newMetadata.RetiredJWKs[keyID] = retiredAt
+		}
+	}
+
+	// isValid has already checked that these can be unmarshalled.
+	var oldActiveJWK jose.JSONWebKey
+	if err := json.Unmarshal(oldSecret.Data[activeJWKKey], &oldActiveJWK); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal active jwk: %w", err)
+	}
+	var oldJWKS jose.JSONWebKeySet
+	if err := json.Unmarshal(oldSecret.Data[jwksKey], &oldJWKS); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal jwks: %w", err)
+	}
+
+	activeJWKData := oldSecret.Data[activeJWKKey]
+	activeJWK := oldActiveJWK
+	if rotate {
+		activeJWKData = newSecret.Data[activeJWKKey]
+		if err := json.Unmarshal(activeJWKData, &activeJWK); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal new active jwk: %w", err)
+		}
+		newMetadata.ActiveJWKCreatedAt = now
+		newMetadata.RetiredJWKs[oldActiveJWK.KeyID] = now
+	}
+
+	// The active JWK is always the first key of the JWKS.
+	newJWKS := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{activeJWK.Public()}}
+	for _, jwk := range oldJWKS.Keys {
+		if _, retained := newMetadata.RetiredJWKs[jwk.KeyID]; retained && jwk.KeyID != activeJWK.KeyID {
+			newJWKS.Keys = append(newJWKS.Keys, jwk)
+		}
+	}
+
+	jwksData, err := json.Marshal(newJWKS)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal jwks: %w", err)
+	}
+	if len(newMetadata.RetiredJWKs) == 0 {
+		newMetadata.RetiredJWKs = nil
+	}
+	metadataData, err := json.Marshal(newMetadata)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal jwks metadata: %w", err)
+	}
+
+	return map[string][]byte{
+		activeJWKKey:    activeJWKData,
+		jwksKey:         jwksData,
+		jwksMetadataKey: metadataData,
+	}, nil
+}
+
+// readJWKSMetadata returns the metadata of the provided secret, or false when it has none.
+func readJWKSMetadata(secret *corev1.Secret) (jwksMetadata, bool) {
+	metadataData, ok := secret.Data[jwksMetadataKey]
+	if !ok {
+		return jwksMetadata{}, false
+	}
+	var metadata jwksMetadata
+	if err := json.Unmarshal(metadataData, &metadata); err != nil {
+		plog.Debug("cannot unmarshal jwks metadata", "err", err)
+		return jwksMetadata{}, false
+	}
+	return metadata, true
+}
+
 func (c *jwksWriterController) updateFederationDomainStatus(
 	ctx context.Context,
 	newFederationDomain *configv1alpha1.FederationDomain,
@@ -12,12 +12,14 @@ import (
 	"io"
 	"io/ioutil"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/clock"
 	kubeinformers "k8s.io/client-go/informers"
 	kubernetesfake "k8s.io/client-go/kubernetes/fake"
 	kubetesting "k8s.io/client-go/testing"
@@ -182,6 +184,9 @@ func TestJWKSWriterControllerFilterSecret(t *testing.T) {
 			withInformer := testutil.NewObservableWithInformerOption()
 			_ = NewJWKSWriterController(
 				nil, // labels, not needed
+				0,   // rotationInterval, not needed
+				0,   // retentionPeriod, not needed
+				nil, // clock, not needed
 				nil, // kubeClient, not needed
 				nil, // pinnipedClient, not needed
 				secretInformer,
@@ -236,6 +241,9 @@ func TestJWKSWriterControllerFilterFederationDomain(t *testing.T) {
 			withInformer := testutil.NewObservableWithInformerOption()
 			_ = NewJWKSWriterController(
 				nil, // labels, not needed
+				0,   // rotationInterval, not needed
+				0,   // retentionPeriod, not needed
+				nil, // clock, not needed
 				nil, // kubeClient, not needed
 				nil, // pinnipedClient, not needed
 				secretInformer,
@@ -257,7 +265,13 @@ func TestJWKSWriterControllerFilterFederationDomain(t *testing.T) {
 func TestJWKSWriterControllerSync(t *testing.T) {
 	// We shouldn't run this test in parallel since it messes with a global function (generateKey).
 
-	const namespace = "tuna-namespace"
+	const (
+		namespace        = "tuna-namespace"
+		rotationInterval = 30 * 24 * time.Hour
+		retentionPeriod  = 24 * time.Hour
+	)
+
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
 
 	goodKeyPEM, err := ioutil.ReadFile("testdata/good-ec-key.pem")
 	require.NoError(t, err)
@@ -323,7 +337,20 @@ func TestJWKSWriterControllerSync(t *testing.T) {
 		return &s
 	}
 
-	goodSecret := newSecret("testdata/good-jwk.json", "testdata/good-jwks.json")
+	withMetadata := func(s *corev1.Secret, metadata string) *corev1.Secret {
+		s.Data["jwksMetadata"] = []byte(metadata)
+		return s
+	}
+
+	goodSecret := withMetadata(
+		newSecret("testdata/good-jwk.json", "testdata/good-jwks.json"),
+		`{"activeJWKCreatedAt":"2021-10-01T12:00:00Z"}`,
+	)
+
+	rotatedSecret := withMetadata(
+		newSecret("testdata/good-jwk.json", "testdata/good-and-old-jwks.json"),
+		`{"activeJWKCreatedAt":"2021-10-01T12:00:00Z","retiredJWKs":{"pinniped-supervisor-key":"2021-10-01T12:00:00Z"}}`,
+	)
 
 	secretWithWrongType := newSecret("testdata/good-jwk.json", "testdata/good-jwks.json")
 	secretWithWrongType.Type = "not-the-right-type"
@@ -585,6 +612,95 @@ func TestJWKSWriterControllerSync(t *testing.T) {
 				kubetesting.NewGetAction(federationDomainGVR, namespace, goodFederationDomain.Name),
 			},
 		},
+		{
+			name: "secret without metadata from an older version",
+			key:  controllerlib.Key{Namespace: goodFederationDomain.Namespace, Name: goodFederationDomain.Name},
+			federationDomains: []*configv1alpha1.FederationDomain{
+				goodFederationDomainWithStatus,
+			},
+			secrets: []*corev1.Secret{
+				newSecret("testdata/old-jwk.json", "testdata/old-jwks.json"),
+			},
+			wantGenerateKeyCount: 1,
+			wantSecretActions: []kubetesting.Action{
+				kubetesting.NewGetAction(secretGVR, namespace, goodSecret.Name),
+				kubetesting.NewUpdateAction(secretGVR, namespace, rotatedSecret),
+			},
+			wantFederationDomainActions: []kubetesting.Action{
+				kubetesting.NewGetAction(federationDomainGVR, namespace, goodFederationDomain.Name),
+			},
+		},
+		{
+			name: "active jwk is due for rotation",
+			key:  controllerlib.Key{Namespace: goodFederationDomain.Namespace, Name: goodFederationDomain.Name},
+			federationDomains: []*configv1alpha1.FederationDomain{
+				goodFederationDomainWithStatus,
+			},
+			secrets: []*corev1.Secret{
+				withMetadata(
+					newSecret("testdata/old-jwk.json", "testdata/old-jwks.json"),
+					`{"activeJWKCreatedAt":"2021-09-01T12:00:00Z"}`,
+				),
+			},
+			wantGenerateKeyCount: 1,
+			wantSecretActions: []kubetesting.Action{
+				kubetesting.NewGetAction(secretGVR, namespace, goodSecret.Name),
+				kubetesting.NewUpdateAction(secretGVR, namespace, rotatedSecret),
+			},
+			wantFederationDomainActions: []kubetesting.Action{
+				kubetesting.NewGetAction(federationDomainGVR, namespace, goodFederationDomain.Name),
+			},
+		},
+		{
+			name: "active jwk is not yet due for rotation",
+			key:  controllerlib.Key{Namespace: goodFederationDomain.Namespace, Name: goodFederationDomain.Name},
+			federationDomains: []*configv1alpha1.FederationDomain{
+				goodFederationDomainWithStatus,
+			},
+			secrets: []*corev1.Secret{
+				withMetadata(
+					newSecret("testdata/old-jwk.json", "testdata/old-jwks.json"),
+					`{"activeJWKCreatedAt":"2021-09-01T12:00:01Z"}`,
+				),
+			},
+		},
+		{
+			name: "previous jwk is due for removal",
+			key:  controllerlib.Key{Namespace: goodFederationDomain.Namespace, Name: goodFederationDomain.Name},
+			federationDomains: []*configv1alpha1.FederationDomain{
+				goodFederationDomainWithStatus,
+			},
+			secrets: []*corev1.Secret{
+				withMetadata(
+					newSecret("testdata/good-jwk.json", "testdata/good-and-old-jwks.json"),
+					`{"activeJWKCreatedAt":"2021-09-30T12:00:00Z","retiredJWKs":{"pinniped-supervisor-key":"2021-09-30T12:00:00Z"}}`,
+				),
+			},
+			wantGenerateKeyCount: 1,
+			wantSecretActions: []kubetesting.Action{
+				kubetesting.NewGetAction(secretGVR, namespace, goodSecret.Name),
+				kubetesting.NewUpdateAction(secretGVR, namespace, withMetadata(
+					newSecret("testdata/good-jwk.json", "testdata/good-jwks.json"),
+					`{"activeJWKCreatedAt":"2021-09-30T12:00:00Z"}`,
+				)),
+			},
+			wantFederationDomainActions: []kubetesting.Action{
+				kubetesting.NewGetAction(federationDomainGVR, namespace, goodFederationDomain.Name),
+			},
+		},
+		{
+			name: "previous jwk is still retained",
+			key:  controllerlib.Key{Namespace: goodFederationDomain.Namespace, Name: goodFederationDomain.Name},
+			federationDomains: []*configv1alpha1.FederationDomain{
+				goodFederationDomainWithStatus,
+			},
+			secrets: []*corev1.Secret{
+				withMetadata(
+					newSecret("testdata/good-jwk.json", "testdata/good-and-old-jwks.json"),
+					`{"activeJWKCreatedAt":"2021-09-30T12:00:00Z","retiredJWKs":{"pinniped-supervisor-key":"2021-09-30T12:00:01Z"}}`,
+				),
+			},
+		},
 		{
 			name: "generate key fails",
 			key:  controllerlib.Key{Namespace: goodFederationDomain.Namespace, Name: goodFederationDomain.Name},
@@ -710,6 +826,9 @@ func TestJWKSWriterControllerSync(t *testing.T) {
 					"myLabelKey1": "myLabelValue1",
 					"myLabelKey2": "myLabelValue2",
 				},
+				rotationInterval,
+				retentionPeriod,
+				clock.NewFakeClock(now),
 				kubeAPIClient,
 				pinnipedAPIClient,
 				kubeInformers.Core().V1().Secrets(),
@@ -0,0 +1,127 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package oidcclientwatcher implements a controller which watches OIDCClients and reports their validity in
+// their status.
+package oidcclientwatcher
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/errors"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/klog/v2"
+
+	"go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	pinnipedclientset "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned"
+	configinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions/config/v1alpha1"
+	pinnipedcontroller "go.pinniped.dev/internal/controller"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/plog"
+)
+
+const (
+	controllerName = "oidc-client-watcher-controller"
+
+	messageReady = "client is ready to be used"
+)
+
+type oidcClientWatcherController struct {
+	clock              clock.Clock
+	client             pinnipedclientset.Interface
+	oidcClientInformer configinformers.OIDCClientInformer
+	secretInformer     corev1informers.SecretInformer
+}
+
+// New instantiates a new controllerlib.Controller which will validate OIDCClients and update their status.
+func New(
+	clock clock.Clock,
+	client pinnipedclientset.Interface,
+	oidcClientInformer configinformers.OIDCClientInformer,
+	secretInformer corev1informers.SecretInformer,
+	withInformer pinnipedcontroller.WithInformerOptionFunc,
+) controllerlib.Controller {
+	return controllerlib.New(
+		controllerlib.Config{
+			Name: controllerName,
+			Syncer: &oidcClientWatcherController{
+				clock:              clock,
+				client:             client,
+				oidcClientInformer: oidcClientInformer,
+				secretInformer:     secretInformer,
+			},
+		},
+		withInformer(
+			oidcClientInformer,
+			pinnipedcontroller.MatchAnythingFilter(pinnipedcontroller.SingletonQueue()),
+			controllerlib.InformerOption{},
+		),
+		withInformer(
+			secretInformer,
+			pinnipedcontroller.MatchAnySecretOfTypeFilter(clientregistry.OIDCClientSecretType, pinnipedcontroller.SingletonQueue()),
+			controllerlib.InformerOption{},
+		),
+	)
+}
+
+// Sync implements controllerlib.Syncer.
+func (c *oidcClientWatcherController) Sync(ctx controllerlib.Context) error {
+	oidcClients, err := c.oidcClientInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list OIDCClients: %w", err)
+	}
+
+	var errs []error
+	for _, oidcClient := range oidcClients {
+		secret, err := c.secretInformer.Lister().Secrets(oidcClient.Namespace).Get(oidcClient.Spec.ClientSecretName)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get secret %q for OIDCClient %q: %w", oidcClient.Spec.ClientSecretName, oidcClient.Name, err)
+		}
+
+		phase, message := v1alpha1.OIDCClientPhaseReady, messageReady
+		if _, err := clientregistry.ClientFromOIDCClient(oidcClient, secret); err != nil {
+			phase, message = v1alpha1.OIDCClientPhaseError, err.Error()
+		}
+
+		if err := c.updateStatus(ctx, oidcClient, phase, message); err != nil {
+			errs = append(errs, fmt.Errorf("could not update status of OIDCClient %q: %w", oidcClient.Name, err))
+		}
+	}
+
+	return errors.NewAggregate(errs)
+}
+
+func (c *oidcClientWatcherController) updateStatus(
+	ctx controllerlib.Context,
+	oidcClient *v1alpha1.OIDCClient,
+	phase v1alpha1.OIDCClientPhase,
+	message string,
+) error {
+	updated := oidcClient.DeepCopy()
+	updated.Status.Phase = phase
+	updated.Status.Message = message
+
+	if equality.Semantic.DeepEqual(oidcClient, updated) {
+		return nil // nothing to update
+	}
+
+	plog.Debug(
+		"attempting status update",
+		"oidcclient", klog.KObj(oidcClient),
+		"phase", phase,
+		"message", message,
+	)
+	now := metav1.NewTime(c.clock.Now())
+	updated.Status.LastUpdateTime = &now
+	_, err := c.client.
+		ConfigV1alpha1().
+		OIDCClients(oidcClient.Namespace).
+		UpdateStatus(ctx.Context, updated, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,237 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package oidcclientwatcher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	pinnipedfake "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/fake"
+	pinnipedinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/testutil"
+)
+
+func TestOIDCClientWatcherControllerFilterSecrets(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		secret     metav1.Object
+		wantAdd    bool
+		wantUpdate bool
+		wantDelete bool
+	}{
+		{
+			name: "a secret of the right type",
+			secret: &corev1.Secret{
+				Type:       "secrets.pinniped.dev/oidc-client-secret-hash",
+				ObjectMeta: metav1.ObjectMeta{Name: "some-name", Namespace: "some-namespace"},
+			},
+			wantAdd:    true,
+			wantUpdate: true,
+			wantDelete: true,
+		},
+		{
+			name: "a secret of the wrong type",
+			secret: &corev1.Secret{
+				Type:       "secrets.pinniped.dev/oidc-client",
+				ObjectMeta: metav1.ObjectMeta{Name: "some-name", Namespace: "some-namespace"},
+			},
+		},
+		{
+			name: "resource of a data type which is not watched by this controller",
+			secret: &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "some-name", Namespace: "some-namespace"},
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			pinnipedInformers := pinnipedinformers.NewSharedInformerFactory(pinnipedfake.NewSimpleClientset(), 0)
+			oidcClientInformer := pinnipedInformers.Config().V1alpha1().OIDCClients()
+			kubeInformers := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+			secretInformer := kubeInformers.Core().V1().Secrets()
+			withInformer := testutil.NewObservableWithInformerOption()
+
+			New(nil, nil, oidcClientInformer, secretInformer, withInformer.WithInformer)
+
+			unrelated := corev1.Secret{}
+			filter := withInformer.GetFilterForInformer(secretInformer)
+			require.Equal(t, test.wantAdd, filter.Add(test.secret))
+			require.Equal(t, test.wantUpdate, filter.Update(&unrelated, test.secret))
+			require.Equal(t, test.wantUpdate, filter.Update(test.secret, &unrelated))
+			require.Equal(t, test.wantDelete, filter.Delete(test.secret))
+		})
+	}
+}
+
+func TestOIDCClientWatcherControllerFilterOIDCClients(t *testing.T) {
+	t.Parallel()
+
+	pinnipedInformers := pinnipedinformers.NewSharedInformerFactory(pinnipedfake.NewSimpleClientset(), 0)
+	oidcClientInformer := pinnipedInformers.Config().V1alpha1().OIDCClients()
+	kubeInformers := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	secretInformer := kubeInformers.Core().V1().Secrets()
+	withInformer := testutil.NewObservableWithInformerOption()
+
+	New(nil, nil, oidcClientInformer, secretInformer, withInformer.WithInformer)
+
+	oidcClient := &v1alpha1.OIDCClient{ObjectMeta: metav1.ObjectMeta{Name: "some-name", Namespace: "some-namespace"}}
+	unrelated := &v1alpha1.OIDCClient{ObjectMeta: metav1.ObjectMeta{Name: "other-name", Namespace: "some-namespace"}}
+	filter := withInformer.GetFilterForInformer(oidcClientInformer)
+	require.True(t, filter.Add(oidcClient))
+	require.True(t, filter.Update(unrelated, oidcClient))
+	require.True(t, filter.Update(oidcClient, unrelated))
+	require.True(t, filter.Delete(oidcClient))
+}
+
+func TestOIDCClientWatcherControllerSync(t *testing.T) {
+	t.Parallel()
+
+	const testNamespace = "some-namespace"
+
+	now := metav1.NewTime(time.Now().UTC().Truncate(time.Second))
+	earlier := metav1.NewTime(now.Add(-1 * time.Hour))
+
+	hashedSecret, err := bcrypt.GenerateFromPassword([]byte("some-client-secret"), 12)
+	require.NoError(t, err)
+
+	validSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "some-secret", Namespace: testNamespace},
+		Type:       "secrets.pinniped.dev/oidc-client-secret-hash",
+		Data:       map[string][]byte{"bcryptHash": hashedSecret},
+	}
+
+	validOIDCClient := &v1alpha1.OIDCClient{
+		ObjectMeta: metav1.ObjectMeta{Name: "client.oauth.pinniped.dev-some-client", Namespace: testNamespace},
+		Spec: v1alpha1.OIDCClientSpec{
+			AllowedRedirectURIs: []v1alpha1.RedirectURI{"https://example.com/callback"},
+			AllowedGrantTypes:   []v1alpha1.GrantType{"authorization_code"},
+			AllowedScopes:       []v1alpha1.Scope{"openid"},
+			ClientSecretName:    "some-secret",
+		},
+	}
+
+	withStatus := func(c *v1alpha1.OIDCClient, status v1alpha1.OIDCClientStatus) *v1alpha1.OIDCClient {
+		c = c.DeepCopy()
+		c.Status = status
+		return c
+	}
+
+	tests := []struct {
+		name              string
+		inputObjects      []runtime.Object
+		inputSecrets      []runtime.Object
+		wantResultClients []v1alpha1.OIDCClient
+	}{
+		{
+			name: "no OIDCClients",
+		},
+		{
+			name:         "valid OIDCClient",
+			inputObjects: []runtime.Object{validOIDCClient},
+			inputSecrets: []runtime.Object{validSecret},
+			wantResultClients: []v1alpha1.OIDCClient{*withStatus(validOIDCClient, v1alpha1.OIDCClientStatus{
+				Phase:          v1alpha1.OIDCClientPhaseReady,
+				Message:        "client is ready to be used",
+				LastUpdateTime: &now,
+			})},
+		},
+		{
+			name: "valid OIDCClient whose status is already up to date",
+			inputObjects: []runtime.Object{withStatus(validOIDCClient, v1alpha1.OIDCClientStatus{
+				Phase:          v1alpha1.OIDCClientPhaseReady,
+				Message:        "client is ready to be used",
+				LastUpdateTime: &earlier,
+			})},
+			inputSecrets: []runtime.Object{validSecret},
+			wantResultClients: []v1alpha1.OIDCClient{*withStatus(validOIDCClient, v1alpha1.OIDCClientStatus{
+				Phase:          v1alpha1.OIDCClientPhaseReady,
+				Message:        "client is ready to be used",
+				LastUpdateTime: &earlier,
+			})},
+		},
+		{
+			name:         "OIDCClient whose Secret is missing",
+			inputObjects: []runtime.Object{validOIDCClient},
+			wantResultClients: []v1alpha1.OIDCClient{*withStatus(validOIDCClient, v1alpha1.OIDCClientStatus{
+				Phase:          v1alpha1.OIDCClientPhaseError,
+				Message:        `secret "some-secret" not found`,
+				LastUpdateTime: &now,
+			})},
+		},
+		{
+			name: "previously valid OIDCClient which has become invalid",
+			inputObjects: []runtime.Object{withStatus(validOIDCClient, v1alpha1.OIDCClientStatus{
+				Phase:          v1alpha1.OIDCClientPhaseReady,
+				Message:        "client is ready to be used",
+				LastUpdateTime: &earlier,
+			})},
+			inputSecrets: []runtime.Object{&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "some-secret", Namespace: testNamespace},
+				Type:       "secrets.pinniped.dev/oidc-client-secret-hash",
+			}},
+			wantResultClients: []v1alpha1.OIDCClient{*withStatus(validOIDCClient, v1alpha1.OIDCClientStatus{
+				Phase:          v1alpha1.OIDCClientPhaseError,
+				Message:        `secret "some-secret" is missing the "bcryptHash" key`,
+				LastUpdateTime: &now,
+			})},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			fakePinnipedClient := pinnipedfake.NewSimpleClientset(tt.inputObjects...)
+			pinnipedInformers := pinnipedinformers.NewSharedInformerFactory(fakePinnipedClient, 0)
+			fakeKubeClient := fake.NewSimpleClientset(tt.inputSecrets...)
+			kubeInformers := informers.NewSharedInformerFactory(fakeKubeClient, 0)
+
+			controller := New(
+				clock.NewFakeClock(now.Time),
+				fakePinnipedClient,
+				pinnipedInformers.Config().V1alpha1().OIDCClients(),
+				kubeInformers.Core().V1().Secrets(),
+				controllerlib.WithInformer,
+			)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			pinnipedInformers.Start(ctx.Done())
+			kubeInformers.Start(ctx.Done())
+			controllerlib.TestRunSynchronously(t, controller)
+
+			syncCtx := controllerlib.Context{Context: ctx, Key: controllerlib.Key{}}
+
+			require.NoError(t, controllerlib.TestSync(t, controller, syncCtx))
+
+			actualClients, err := fakePinnipedClient.ConfigV1alpha1().OIDCClients(testNamespace).List(ctx, metav1.ListOptions{})
+			require.NoError(t, err)
+			require.Equal(t, len(tt.wantResultClients), len(actualClients.Items))
+			for i := range tt.wantResultClients {
+				// Require each separately to get a nice diff when the test fails.
+				require.Equal(t, tt.wantResultClients[i].Status.Phase, actualClients.Items[i].Status.Phase)
+				require.Equal(t, tt.wantResultClients[i].Status.Message, actualClients.Items[i].Status.Message)
+				require.True(t, tt.wantResultClients[i].Status.LastUpdateTime.Equal(actualClients.Items[i].Status.LastUpdateTime))
+			}
+		})
+	}
+}
@@ -0,0 +1,378 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package samlupstreamwatcher implements a controller which watches SAMLIdentityProviders.
+package samlupstreamwatcher
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/cache"
+
+	"go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+	pinnipedclientset "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned"
+	idpinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions/idp/v1alpha1"
+	"go.pinniped.dev/internal/constable"
+	pinnipedcontroller "go.pinniped.dev/internal/controller"
+	"go.pinniped.dev/internal/controller/conditionsutil"
+	"go.pinniped.dev/internal/controller/supervisorconfig/upstreamwatchers"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/upstreamsaml"
+)
+
+const (
+	// Setup for the name of our controller in logs.
+	samlControllerName = "saml-upstream-observer"
+
+	// Constants related to the metadata cache. Metadata which is provided inline is not cached.
+	metadataCacheTTL = 15 * time.Minute
+
+	// The largest metadata document which is fetched from a metadata URL.
+	maxMetadataBytes = 5 << 20
+
+	// Constants related to conditions.
+	typeMetadataValid            = "MetadataValid"
+	typeAuthnRequestBindingValid = "AuthnRequestBindingValid"
+
+	reasonInvalid          = "Invalid"
+	reasonUnreachable      = "Unreachable"
+	reasonInvalidMetadata  = "InvalidMetadata"
+	reasonUnableToValidate = "UnableToValidate"
+
+	// Errors that are generated by our reconcile process.
+	errSAMLFailureStatus = constable.Error("SAMLIdentityProvider has a failing condition")
+)
+
+// UpstreamSAMLIdentityProviderICache is a thread safe cache that holds a list of validated upstream SAML IDP configurations.
+type UpstreamSAMLIdentityProviderICache interface {
+	SetSAMLIdentityProviders([]provider.UpstreamSAMLIdentityProviderI)
+}
+
+// lruMetadataCache caches the parsed metadata associated with a particular metadata URL/TLS configuration, so that
+// the metadata is not fetched again on every sync.
+type lruMetadataCache struct{ cache *cache.Expiring }
+
+func (c *lruMetadataCache) getMetadata(spec *v1alpha1.SAMLMetadata) *upstreamsaml.Metadata {
+	if result, ok := c.cache.Get(c.cacheKey(spec)); ok {
+		return result.(*upstreamsaml.Metadata)
+	}
+	return nil
+}
+
+func (c *lruMetadataCache) putMetadata(spec *v1alpha1.SAMLMetadata, metadata *upstreamsaml.Metadata) {
+	c.cache.Set(c.cacheKey(spec), metadata, metadataCacheTTL)
+}
+
+func (c *lruMetadataCache) cacheKey(spec *v1alpha1.SAMLMetadata) interface{} {
+	var key struct{ url, caBundle string }
+	key.url = spec.URL
+	if spec.TLS != nil {
+		key.caBundle = spec.TLS.CertificateAuthorityData
+	}
+	return key
+}
+
+type samlWatcherController struct {
+	cache                        UpstreamSAMLIdentityProviderICache
+	log                          logr.Logger
+	client                       pinnipedclientset.Interface
+	samlIdentityProviderInformer idpinformers.SAMLIdentityProviderInformer
+	metadataCache                interface {
+		getMetadata(*v1alpha1.SAMLMetadata) *upstreamsaml.Metadata
+		putMetadata(*v1alpha1.SAMLMetadata, *upstreamsaml.Metadata)
+	}
+}
+
+// New instantiates a new controllerlib.Controller which will populate the provided UpstreamSAMLIdentityProviderICache.
+func New(
+	idpCache UpstreamSAMLIdentityProviderICache,
+	client pinnipedclientset.Interface,
+	samlIdentityProviderInformer idpinformers.SAMLIdentityProviderInformer,
+	log logr.Logger,
+	withInformer pinnipedcontroller.WithInformerOptionFunc,
+) controllerlib.Controller {
+	c := samlWatcherController{
+		cache:                        idpCache,
+		log:                          log.WithName(samlControllerName),
+		client:                       client,
+		samlIdentityProviderInformer: samlIdentityProviderInformer,
+		metadataCache:                &lruMetadataCache{cache: cache.NewExpiring()},
+	}
+	return controllerlib.New(
+		controllerlib.Config{Name: samlControllerName, Syncer: &c},
+		withInformer(
+			samlIdentityProviderInformer,
+			pinnipedcontroller.MatchAnythingFilter(pinnipedcontroller.SingletonQueue()),
+			controllerlib.InformerOption{},
+		),
+	)
+}
+
+// Sync implements controllerlib.Syncer.
+func (c *samlWatcherController) Sync(ctx controllerlib.Context) error {
+	actualUpstreams, err := c.samlIdentityProviderInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list SAMLIdentityProviders: %w", err)
+	}
+
+	requeue := false
+	validatedUpstreams := make([]provider.UpstreamSAMLIdentityProviderI, 0, len(actualUpstreams))
+	for _, upstream := range actualUpstreams {
+		valid := c.validateUpstream(ctx, upstream)
+		if valid == nil {
+			requeue = true
+		} else {
+			validatedUpstreams = append(validatedUpstreams, provider.UpstreamSAMLIdentityProviderI(valid))
+		}
+	}
+	c.cache.SetSAMLIdentityProviders(validatedUpstreams)
+	if requeue {
+		return controllerlib.ErrSyntheticRequeue
+	}
+	return nil
+}
+
+// validateUpstream validates the provided v1alpha1.SAMLIdentityProvider and returns the validated configuration as a
+// upstreamsaml.ProviderConfig. As a side effect, it also updates the status of the v1alpha1.SAMLIdentityProvider.
+func (c *samlWatcherController) validateUpstream(ctx controllerlib.Context, upstream *v1alpha1.SAMLIdentityProvider) *upstreamsaml.ProviderConfig {
+	result := upstreamsaml.ProviderConfig{
+		Name:              upstream.Name,
+		UsernameAttribute: upstream.Spec.Claims.Username,
+		GroupsAttribute:   upstream.Spec.Claims.Groups,
+	}
+
+	metadata, metadataCondition := c.validateMetadata(ctx.Context, upstream)
+	conditions := []*v1alpha1.Condition{
+		metadataCondition,
+		c.validateBinding(upstream, metadata, &result),
+	}
+	c.updateStatus(ctx.Context, upstream, conditions)
+
+	valid := true
+	log := c.log.WithValues("namespace", upstream.Namespace, "name", upstream.Name)
+	for _, condition := range conditions {
+		if condition.Status == v1alpha1.ConditionFalse {
+			valid = false
+			log.WithValues(
+				"type", condition.Type,
+				"reason", condition.Reason,
+				"message", condition.Message,
+			).Error(errSAMLFailureStatus, "found failing condition")
+		}
+	}
+	if valid {
+		result.EntityID = metadata.EntityID
+		result.SigningCertificates = metadata.SigningCertificates
+		return &result
+	}
+	return nil
+}
+
+// validateMetadata validates the .spec.metadata field, fetches the metadata from its URL if needed, and returns the
+// parsed metadata along with the appropriate MetadataValid condition.
+func (c *samlWatcherController) validateMetadata(ctx context.Context, upstream *v1alpha1.SAMLIdentityProvider) (*upstreamsaml.Metadata, *v1alpha1.Condition) {
+	spec := &upstream.Spec.Metadata
+
+	invalid := func(reason string, message string) (*upstreamsaml.Metadata, *v1alpha1.Condition) {
+		return nil, &v1alpha1.Condition{
+			Type:    typeMetadataValid,
+			Status:  v1alpha1.ConditionFalse,
+			Reason:  reason,
+			Message: message,
+		}
+	}
+
+	var metadata *upstreamsaml.Metadata
+	switch {
+	case spec.URL != "" && spec.Data != "":
+		return invalid(reasonInvalid, "only one of spec.metadata.url and spec.metadata.data may be specified")
+	case spec.Data != "":
+		if spec.TLS != nil {
+			return invalid(reasonInvalid, "spec.metadata.tls may only be specified with spec.metadata.url")
+		}
+		var err error
+		metadata, err = upstreamsaml.ParseMetadata([]byte(spec.Data))
+		if err != nil {
+			return invalid(reasonInvalidMetadata, fmt.Sprintf("spec.metadata.data is invalid: %s", err.Error()))
+		}
+	case spec.URL != "":
+		// Get the metadata from cache if possible.
+		metadata = c.metadataCache.getMetadata(spec)
+
+		// If the metadata does not exist in the cache, fetch it and save it to the cache.
+		if metadata == nil {
+			tlsConfig, err := getTLSConfig(upstream)
+			if err != nil {
+				return invalid(upstreamwatchers.ReasonInvalidTLSConfig, err.Error())
+			}
+
+			data, err := fetchMetadata(ctx, spec.URL, tlsConfig)
+			if err != nil {
+				const klogLevelTrace = 6
+				c.log.V(klogLevelTrace).WithValues(
+					"namespace", upstream.Namespace,
+					"name", upstream.Name,
+					"url", spec.URL,
+				).Error(err, "failed to fetch SAML metadata")
+				return invalid(reasonUnreachable, fmt.Sprintf("failed to fetch metadata from %q: %s", spec.URL, err.Error()))
+			}
+
+			metadata, err = upstreamsaml.ParseMetadata(data)
+			if err != nil {
+				return invalid(reasonInvalidMetadata, fmt.Sprintf("metadata from %q is invalid: %s", spec.URL, err.Error()))
+			}
+
+			// Update the cache with the newly fetched value.
+			c.metadataCache.putMetadata(spec, metadata)
+		}
+	default:
+		return invalid(reasonInvalid, "one of spec.metadata.url and spec.metadata.data must be specified")
+	}
+
+	return metadata, &v1alpha1.Condition{
+		Type:    typeMetadataValid,
+		Status:  v1alpha1.ConditionTrue,
+		Reason:  upstreamwatchers.ReasonSuccess,
+		Message: fmt.Sprintf("loaded metadata of identity provider %q", metadata.EntityID),
+	}
+}
+
+// validateBinding validates the .spec.authnRequest.binding field against the single sign-on services from the
+// metadata, and returns the appropriate AuthnRequestBindingValid condition.
+func (c *samlWatcherController) validateBinding(upstream *v1alpha1.SAMLIdentityProvider, metadata *upstreamsaml.Metadata, result *upstreamsaml.ProviderConfig) *v1alpha1.Condition {
+	binding := upstream.Spec.AuthnRequest.Binding
+	if binding == "" {
+		binding = v1alpha1.SAMLBindingHTTPRedirect
+	}
+
+	if binding != v1alpha1.SAMLBindingHTTPRedirect && binding != v1alpha1.SAMLBindingHTTPPOST {
+		return &v1alpha1.Condition{
+			Type:    typeAuthnRequestBindingValid,
+			Status:  v1alpha1.ConditionFalse,
+			Reason:  reasonInvalid,
+			Message: fmt.Sprintf("spec.authnRequest.binding has unknown value %q", binding),
+		}
+	}
+
+	if metadata == nil {
+		return &v1alpha1.Condition{
+			Type:    typeAuthnRequestBindingValid,
+			Status:  v1alpha1.ConditionUnknown,
+			Reason:  reasonUnableToValidate,
+			Message: "unable to validate; see other conditions for details",
+		}
+	}
+
+	singleSignOnURL, ok := metadata.SingleSignOnServices[binding]
+	if !ok {
+		return &v1alpha1.Condition{
+			Type:    typeAuthnRequestBindingValid,
+			Status:  v1alpha1.ConditionFalse,
+			Reason:  reasonInvalid,
+			Message: fmt.Sprintf("metadata of identity provider %q has no single sign-on service with the %s binding", metadata.EntityID, binding),
+		}
+	}
+
+	// If everything is valid, update the result and set the condition to true.
+	result.Binding = binding
+	result.SingleSignOnURL = singleSignOnURL
+	return &v1alpha1.Condition{
+		Type:    typeAuthnRequestBindingValid,
+		Status:  v1alpha1.ConditionTrue,
+		Reason:  upstreamwatchers.ReasonSuccess,
+		Message: fmt.Sprintf("spec.authnRequest.binding (%q) is valid", binding),
+	}
+}
+
+func (c *samlWatcherController) updateStatus(ctx context.Context, upstream *v1alpha1.SAMLIdentityProvider, conditions []*v1alpha1.Condition) {
+	log := c.log.WithValues("namespace", upstream.Namespace, "name", upstream.Name)
+	updated := upstream.DeepCopy()
+
+	hadErrorCondition := conditionsutil.Merge(conditions, upstream.Generation, &updated.Status.Conditions, log)
+
+	updated.Status.Phase = v1alpha1.SAMLPhaseReady
+	if hadErrorCondition {
+		updated.Status.Phase = v1alpha1.SAMLPhaseError
+	}
+
+	if equality.Semantic.DeepEqual(upstream, updated) {
+		return
+	}
+
+	_, err := c.client.
+		IDPV1alpha1().
+		SAMLIdentityProviders(upstream.Namespace).
+		UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+	if err != nil {
+		log.Error(err, "failed to update status")
+	}
+}
+
+func fetchMetadata(ctx context.Context, metadataURL string, tlsConfig *tls.Config) ([]byte, error) {
+	httpClient := &http.Client{
+		Timeout: time.Minute,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+	defer httpClient.CloseIdleConnections()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rsp.Body.Close() }()
+
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %q", rsp.Status)
+	}
+
+	data, err := ioutil.ReadAll(&io.LimitedReader{R: rsp.Body, N: maxMetadataBytes + 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxMetadataBytes {
+		return nil, fmt.Errorf("metadata is larger than %d bytes", maxMetadataBytes)
+	}
+	return data, nil
+}
+
+func getTLSConfig(upstream *v1alpha1.SAMLIdentityProvider) (*tls.Config, error) {
+	result := tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if upstream.Spec.Metadata.TLS == nil || upstream.Spec.Metadata.TLS.CertificateAuthorityData == "" {
+		return &result, nil
+	}
+
+	bundle, err := base64.StdEncoding.DecodeString(upstream.Spec.Metadata.TLS.CertificateAuthorityData)
+	if err != nil {
+		return nil, fmt.Errorf("spec.metadata.tls.certificateAuthorityData is invalid: %w", err)
+	}
+
+	result.RootCAs = x509.NewCertPool()
+	if !result.RootCAs.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("spec.metadata.tls.certificateAuthorityData is invalid: %w", upstreamwatchers.ErrNoCertificates)
+	}
+
+	return &result, nil
+}
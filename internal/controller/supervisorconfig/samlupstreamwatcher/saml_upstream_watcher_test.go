@@ -0,0 +1,529 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package samlupstreamwatcher
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+	pinnipedfake "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/fake"
+	pinnipedinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/testutil"
+	"go.pinniped.dev/internal/testutil/testlogger"
+	"go.pinniped.dev/internal/upstreamsaml"
+)
+
+func TestSAMLUpstreamWatcherControllerSync(t *testing.T) {
+	t.Parallel()
+	now := metav1.NewTime(time.Now().UTC())
+
+	signingCert, signingCertDER := newTestCertificate(t)
+	const testEntityID = "https://idp.example.com/metadata"
+	newMetadata := func(singleSignOnServices string) string {
+		return `<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="` + testEntityID + `">` +
+			`<md:IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">` +
+			`<md:KeyDescriptor use="signing"><ds:KeyInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:X509Data><ds:X509Certificate>` +
+			base64.StdEncoding.EncodeToString(signingCertDER) +
+			`</ds:X509Certificate></ds:X509Data></ds:KeyInfo></md:KeyDescriptor>` +
+			singleSignOnServices +
+			`</md:IDPSSODescriptor>` +
+			`</md:EntityDescriptor>`
+	}
+	const (
+		redirectService = `<md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="https://idp.example.com/sso/redirect"/>`
+		postService     = `<md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="https://idp.example.com/sso/post"/>`
+	)
+	testMetadata := newMetadata(redirectService + postService)
+
+	testCA, testServerURL := testutil.TLSTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata":
+			_, _ = w.Write([]byte(testMetadata))
+		case "/redirect-only-metadata":
+			_, _ = w.Write([]byte(newMetadata(redirectService)))
+		case "/not-metadata":
+			_, _ = w.Write([]byte(`<html><body>Log in</body></html>`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	testCABase64 := base64.StdEncoding.EncodeToString([]byte(testCA))
+	testTLS := &v1alpha1.TLSSpec{CertificateAuthorityData: testCABase64}
+
+	redirectURL, err := url.Parse("https://idp.example.com/sso/redirect")
+	require.NoError(t, err)
+	postURL, err := url.Parse("https://idp.example.com/sso/post")
+	require.NoError(t, err)
+
+	var (
+		testNamespace = "test-namespace"
+		testName      = "test-name"
+
+		validMetadataCond     = v1alpha1.Condition{Type: "MetadataValid", Status: "True", LastTransitionTime: now, Reason: "Success", Message: `loaded metadata of identity provider "https://idp.example.com/metadata"`}
+		validRedirectCond     = v1alpha1.Condition{Type: "AuthnRequestBindingValid", Status: "True", LastTransitionTime: now, Reason: "Success", Message: `spec.authnRequest.binding ("HTTP-Redirect") is valid`}
+		unknownBindingCond    = v1alpha1.Condition{Type: "AuthnRequestBindingValid", Status: "Unknown", LastTransitionTime: now, Reason: "UnableToValidate", Message: "unable to validate; see other conditions for details"}
+		validMetadataLog      = `saml-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="loaded metadata of identity provider \"https://idp.example.com/metadata\"" "reason"="Success" "status"="True" "type"="MetadataValid"`
+		validRedirectLog      = `saml-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="spec.authnRequest.binding (\"HTTP-Redirect\") is valid" "reason"="Success" "status"="True" "type"="AuthnRequestBindingValid"`
+		unknownBindingLog     = `saml-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="unable to validate; see other conditions for details" "reason"="UnableToValidate" "status"="Unknown" "type"="AuthnRequestBindingValid"`
+		testRedirectProvider  = &upstreamsaml.ProviderConfig{Name: testName, EntityID: testEntityID, SingleSignOnURL: redirectURL, Binding: v1alpha1.SAMLBindingHTTPRedirect, SigningCertificates: []*x509.Certificate{signingCert}}
+		testUsernameAttribute = "email"
+		testGroupsAttribute   = "memberOf"
+	)
+
+	tests := []struct {
+		name                   string
+		inputUpstreams         []runtime.Object
+		wantErr                string
+		wantLogs               []string
+		wantResultingCache     []*upstreamsaml.ProviderConfig
+		wantResultingUpstreams []v1alpha1.SAMLIdentityProvider
+	}{
+		{
+			name: "no upstreams",
+		},
+		{
+			name: "inline metadata with the default binding and attribute mappings",
+			inputUpstreams: []runtime.Object{&v1alpha1.SAMLIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234},
+				Spec: v1alpha1.SAMLIdentityProviderSpec{
+					Metadata: v1alpha1.SAMLMetadata{Data: testMetadata},
+					Claims:   v1alpha1.SAMLClaims{Username: testUsernameAttribute, Groups: testGroupsAttribute},
+				},
+			}},
+			wantLogs: []string{validMetadataLog, validRedirectLog},
+			wantResultingCache: []*upstreamsaml.ProviderConfig{{
+				Name:                testName,
+				EntityID:            testEntityID,
+				SingleSignOnURL:     redirectURL,
+				Binding:             v1alpha1.SAMLBindingHTTPRedirect,
+				SigningCertificates: []*x509.Certificate{signingCert},
+				UsernameAttribute:   testUsernameAttribute,
+				GroupsAttribute:     testGroupsAttribute,
+			}},
+			wantResultingUpstreams: []v1alpha1.SAMLIdentityProvider{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234},
+				Status: v1alpha1.SAMLIdentityProviderStatus{
+					Phase: "Ready",
+					Conditions: []v1alpha1.Condition{
+						withGeneration(validRedirectCond, 1234),
+						withGeneration(validMetadataCond, 1234),
+					},
+				},
+			}},
+		},
+		{
+			name: "metadata URL with a CA bundle and the HTTP-POST binding",
+			inputUpstreams: []runtime.Object{&v1alpha1.SAMLIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Spec: v1alpha1.SAMLIdentityProviderSpec{
+					Metadata:     v1alpha1.SAMLMetadata{URL: testServerURL + "/metadata", TLS: testTLS},
+					AuthnRequest: v1alpha1.SAMLAuthnRequestConfig{Binding: v1alpha1.SAMLBindingHTTPPOST},
+				},
+			}},
+			wantLogs: []string{
+				validMetadataLog,
+				`saml-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="spec.authnRequest.binding (\"HTTP-POST\") is valid" "reason"="Success" "status"="True" "type"="AuthnRequestBindingValid"`,
+			},
+			wantResultingCache: []*upstreamsaml.ProviderConfig{{
+				Name:                testName,
+				EntityID:            testEntityID,
+				SingleSignOnURL:     postURL,
+				Binding:             v1alpha1.SAMLBindingHTTPPOST,
+				SigningCertificates: []*x509.Certificate{signingCert},
+			}},
+			wantResultingUpstreams: []v1alpha1.SAMLIdentityProvider{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Status: v1alpha1.SAMLIdentityProviderStatus{
+					Phase: "Ready",
+					Conditions: []v1alpha1.Condition{
+						{Type: "AuthnRequestBindingValid", Status: "True", LastTransitionTime: now, Reason: "Success", Message: `spec.authnRequest.binding ("HTTP-POST") is valid`},
+						validMetadataCond,
+					},
+				},
+			}},
+		},
+		{
+			name: "neither metadata URL nor inline metadata",
+			inputUpstreams: []runtime.Object{&v1alpha1.SAMLIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+			}},
+			wantErr: controllerlib.ErrSyntheticRequeue.Error(),
+			wantLogs: []string{
+				`saml-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="one of spec.metadata.url and spec.metadata.data must be specified" "reason"="Invalid" "status"="False" "type"="MetadataValid"`,
+				unknownBindingLog,
+				`saml-upstream-observer "msg"="found failing condition" "error"="SAMLIdentityProvider has a failing condition" "message"="one of spec.metadata.url and spec.metadata.data must be specified" "name"="test-name" "namespace"="test-namespace" "reason"="Invalid" "type"="MetadataValid"`,
+			},
+			wantResultingCache: []*upstreamsaml.ProviderConfig{},
+			wantResultingUpstreams: []v1alpha1.SAMLIdentityProvider{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Status: v1alpha1.SAMLIdentityProviderStatus{
+					Phase: "Error",
+					Conditions: []v1alpha1.Condition{
+						unknownBindingCond,
+						{Type: "MetadataValid", Status: "False", LastTransitionTime: now, Reason: "Invalid", Message: "one of spec.metadata.url and spec.metadata.data must be specified"},
+					},
+				},
+			}},
+		},
+		{
+			name: "both metadata URL and inline metadata",
+			inputUpstreams: []runtime.Object{&v1alpha1.SAMLIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Spec: v1alpha1.SAMLIdentityProviderSpec{
+					Metadata: v1alpha1.SAMLMetadata{URL: testServerURL + "/metadata", Data: testMetadata},
+				},
+			}},
+			wantErr: controllerlib.ErrSyntheticRequeue.Error(),
+			wantLogs: []string{
+				`saml-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="only one of spec.metadata.url and spec.metadata.data may be specified" "reason"="Invalid" "status"="False" "type"="MetadataValid"`,
+				unknownBindingLog,
+				`saml-upstream-observer "msg"="found failing condition" "error"="SAMLIdentityProvider has a failing condition" "message"="only one of spec.metadata.url and spec.metadata.data may be specified" "name"="test-name" "namespace"="test-namespace" "reason"="Invalid" "type"="MetadataValid"`,
+			},
+			wantResultingCache: []*upstreamsaml.ProviderConfig{},
+			wantResultingUpstreams: []v1alpha1.SAMLIdentityProvider{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Status: v1alpha1.SAMLIdentityProviderStatus{
+					Phase: "Error",
+					Conditions: []v1alpha1.Condition{
+						unknownBindingCond,
+						{Type: "MetadataValid", Status: "False", LastTransitionTime: now, Reason: "Invalid", Message: "only one of spec.metadata.url and spec.metadata.data may be specified"},
+					},
+				},
+			}},
+		},
+		{
+			name: "inline metadata which is not valid",
+			inputUpstreams: []runtime.Object{&v1alpha1.SAMLIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Spec: v1alpha1.SAMLIdentityProviderSpec{
+					Metadata: v1alpha1.SAMLMetadata{Data: `<html><body>Log in</body></html>`},
+				},
+			}},
+			wantErr: controllerlib.ErrSyntheticRequeue.Error(),
+			wantLogs: []string{
+				`saml-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="spec.metadata.data is invalid: metadata must be an EntityDescriptor or an EntitiesDescriptor, found html" "reason"="InvalidMetadata" "status"="False" "type"="MetadataValid"`,
+				unknownBindingLog,
+				`saml-upstream-observer "msg"="found failing condition" "error"="SAMLIdentityProvider has a failing condition" "message"="spec.metadata.data is invalid: metadata must be an EntityDescriptor or an EntitiesDescriptor, found html" "name"="test-name" "namespace"="test-namespace" "reason"="InvalidMetadata" "type"="MetadataValid"`,
+			},
+			wantResultingCache: []*upstreamsaml.ProviderConfig{},
+			wantResultingUpstreams: []v1alpha1.SAMLIdentityProvider{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Status: v1alpha1.SAMLIdentityProviderStatus{
+					Phase: "Error",
+					Conditions: []v1alpha1.Condition{
+						unknownBindingCond,
+						{Type: "MetadataValid", Status: "False", LastTransitionTime: now, Reason: "InvalidMetadata", Message: "spec.metadata.data is invalid: metadata must be an EntityDescriptor or an EntitiesDescriptor, found html"},
+					},
+				},
+			}},
+		},
+		{
+			name: "inline metadata with a TLS configuration",
+			inputUpstreams: []runtime.Object{&v1alpha1.SAMLIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Spec: v1alpha1.SAMLIdentityProviderSpec{
+					Metadata: v1alpha1.SAMLMetadata{Data: testMetadata, TLS: testTLS},
+				},
+			}},
+			wantErr: controllerlib.ErrSyntheticRequeue.Error(),
+			wantLogs: []string{
+				`saml-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="spec.metadata.tls may only be specified with spec.metadata.url" "reason"="Invalid" "status"="False" "type"="MetadataValid"`,
+				unknownBindingLog,
+				`saml-upstream-observer "msg"="found failing condition" "error"="SAMLIdentityProvider has a failing condition" "message"="spec.metadata.tls may only be specified with spec.metadata.url" "name"="test-name" "namespace"="test-namespace" "reason"="Invalid" "type"="MetadataValid"`,
+			},
+			wantResultingCache: []*upstreamsaml.ProviderConfig{},
+			wantResultingUpstreams: []v1alpha1.SAMLIdentityProvider{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Status: v1alpha1.SAMLIdentityProviderStatus{
+					Phase: "Error",
+					Conditions: []v1alpha1.Condition{
+						unknownBindingCond,
+						{Type: "MetadataValid", Status: "False", LastTransitionTime: now, Reason: "Invalid", Message: "spec.metadata.tls may only be specified with spec.metadata.url"},
+					},
+				},
+			}},
+		},
+		{
+			name: "TLS CA bundle does not have any certificates",
+			inputUpstreams: []runtime.Object{&v1alpha1.SAMLIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Spec: v1alpha1.SAMLIdentityProviderSpec{
+					Metadata: v1alpha1.SAMLMetadata{
+						URL: testServerURL + "/metadata",
+						TLS: &v1alpha1.TLSSpec{CertificateAuthorityData: base64.StdEncoding.EncodeToString([]byte("not a pem cert"))},
+					},
+				},
+			}},
+			wantErr: controllerlib.ErrSyntheticRequeue.Error(),
+			wantLogs: []string{
+				`saml-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="spec.metadata.tls.certificateAuthorityData is invalid: no certificates found" "reason"="InvalidTLSConfig" "status"="False" "type"="MetadataValid"`,
+				unknownBindingLog,
+				`saml-upstream-observer "msg"="found failing condition" "error"="SAMLIdentityProvider has a failing condition" "message"="spec.metadata.tls.certificateAuthorityData is invalid: no certificates found" "name"="test-name" "namespace"="test-namespace" "reason"="InvalidTLSConfig" "type"="MetadataValid"`,
+			},
+			wantResultingCache: []*upstreamsaml.ProviderConfig{},
+			wantResultingUpstreams: []v1alpha1.SAMLIdentityProvider{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Status: v1alpha1.SAMLIdentityProviderStatus{
+					Phase: "Error",
+					Conditions: []v1alpha1.Condition{
+						unknownBindingCond,
+						{Type: "MetadataValid", Status: "False", LastTransitionTime: now, Reason: "InvalidTLSConfig", Message: "spec.metadata.tls.certificateAuthorityData is invalid: no certificates found"},
+					},
+				},
+			}},
+		},
+		{
+			name: "metadata URL which returns an error",
+			inputUpstreams: []runtime.Object{&v1alpha1.SAMLIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Spec: v1alpha1.SAMLIdentityProviderSpec{
+					Metadata: v1alpha1.SAMLMetadata{URL: testServerURL + "/missing", TLS: testTLS},
+				},
+			}},
+			wantErr: controllerlib.ErrSyntheticRequeue.Error(),
+			wantLogs: []string{
+				`saml-upstream-observer "msg"="failed to fetch SAML metadata" "error"="unexpected response status \"404 Not Found\"" "name"="test-name" "namespace"="test-namespace" "url"="` + testServerURL + `/missing"`,
+				`saml-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="failed to fetch metadata from \"` + testServerURL + `/missing\": unexpected response status \"404 Not Found\"" "reason"="Unreachable" "status"="False" "type"="MetadataValid"`,
+				unknownBindingLog,
+				`saml-upstream-observer "msg"="found failing condition" "error"="SAMLIdentityProvider has a failing condition" "message"="failed to fetch metadata from \"` + testServerURL + `/missing\": unexpected response status \"404 Not Found\"" "name"="test-name" "namespace"="test-namespace" "reason"="Unreachable" "type"="MetadataValid"`,
+			},
+			wantResultingCache: []*upstreamsaml.ProviderConfig{},
+			wantResultingUpstreams: []v1alpha1.SAMLIdentityProvider{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Status: v1alpha1.SAMLIdentityProviderStatus{
+					Phase: "Error",
+					Conditions: []v1alpha1.Condition{
+						unknownBindingCond,
+						{Type: "MetadataValid", Status: "False", LastTransitionTime: now, Reason: "Unreachable", Message: `failed to fetch metadata from "` + testServerURL + `/missing": unexpected response status "404 Not Found"`},
+					},
+				},
+			}},
+		},
+		{
+			name: "metadata URL which does not return metadata",
+			inputUpstreams: []runtime.Object{&v1alpha1.SAMLIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Spec: v1alpha1.SAMLIdentityProviderSpec{
+					Metadata: v1alpha1.SAMLMetadata{URL: testServerURL + "/not-metadata", TLS: testTLS},
+				},
+			}},
+			wantErr: controllerlib.ErrSyntheticRequeue.Error(),
+			wantLogs: []string{
+				`saml-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="metadata from \"` + testServerURL + `/not-metadata\" is invalid: metadata must be an EntityDescriptor or an EntitiesDescriptor, found html" "reason"="InvalidMetadata" "status"="False" "type"="MetadataValid"`,
+				unknownBindingLog,
+				`saml-upstream-observer "msg"="found failing condition" "error"="SAMLIdentityProvider has a failing condition" "message"="metadata from \"` + testServerURL + `/not-metadata\" is invalid: metadata must be an EntityDescriptor or an EntitiesDescriptor, found html" "name"="test-name" "namespace"="test-namespace" "reason"="InvalidMetadata" "type"="MetadataValid"`,
+			},
+			wantResultingCache: []*upstreamsaml.ProviderConfig{},
+			wantResultingUpstreams: []v1alpha1.SAMLIdentityProvider{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Status: v1alpha1.SAMLIdentityProviderStatus{
+					Phase: "Error",
+					Conditions: []v1alpha1.Condition{
+						unknownBindingCond,
+						{Type: "MetadataValid", Status: "False", LastTransitionTime: now, Reason: "InvalidMetadata", Message: `metadata from "` + testServerURL + `/not-metadata" is invalid: metadata must be an EntityDescriptor or an EntitiesDescriptor, found html`},
+					},
+				},
+			}},
+		},
+		{
+			name: "the metadata has no single sign-on service for the binding",
+			inputUpstreams: []runtime.Object{&v1alpha1.SAMLIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Spec: v1alpha1.SAMLIdentityProviderSpec{
+					Metadata:     v1alpha1.SAMLMetadata{URL: testServerURL + "/redirect-only-metadata", TLS: testTLS},
+					AuthnRequest: v1alpha1.SAMLAuthnRequestConfig{Binding: v1alpha1.SAMLBindingHTTPPOST},
+				},
+			}},
+			wantErr: controllerlib.ErrSyntheticRequeue.Error(),
+			wantLogs: []string{
+				validMetadataLog,
+				`saml-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="metadata of identity provider \"https://idp.example.com/metadata\" has no single sign-on service with the HTTP-POST binding" "reason"="Invalid" "status"="False" "type"="AuthnRequestBindingValid"`,
+				`saml-upstream-observer "msg"="found failing condition" "error"="SAMLIdentityProvider has a failing condition" "message"="metadata of identity provider \"https://idp.example.com/metadata\" has no single sign-on service with the HTTP-POST binding" "name"="test-name" "namespace"="test-namespace" "reason"="Invalid" "type"="AuthnRequestBindingValid"`,
+			},
+			wantResultingCache: []*upstreamsaml.ProviderConfig{},
+			wantResultingUpstreams: []v1alpha1.SAMLIdentityProvider{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Status: v1alpha1.SAMLIdentityProviderStatus{
+					Phase: "Error",
+					Conditions: []v1alpha1.Condition{
+						{Type: "AuthnRequestBindingValid", Status: "False", LastTransitionTime: now, Reason: "Invalid", Message: `metadata of identity provider "https://idp.example.com/metadata" has no single sign-on service with the HTTP-POST binding`},
+						validMetadataCond,
+					},
+				},
+			}},
+		},
+		{
+			name: "an unknown binding",
+			inputUpstreams: []runtime.Object{&v1alpha1.SAMLIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Spec: v1alpha1.SAMLIdentityProviderSpec{
+					Metadata:     v1alpha1.SAMLMetadata{Data: testMetadata},
+					AuthnRequest: v1alpha1.SAMLAuthnRequestConfig{Binding: "HTTP-Artifact"},
+				},
+			}},
+			wantErr: controllerlib.ErrSyntheticRequeue.Error(),
+			wantLogs: []string{
+				validMetadataLog,
+				`saml-upstream-observer "level"=0 "msg"="updated condition" "name"="test-name" "namespace"="test-namespace" "message"="spec.authnRequest.binding has unknown value \"HTTP-Artifact\"" "reason"="Invalid" "status"="False" "type"="AuthnRequestBindingValid"`,
+				`saml-upstream-observer "msg"="found failing condition" "error"="SAMLIdentityProvider has a failing condition" "message"="spec.authnRequest.binding has unknown value \"HTTP-Artifact\"" "name"="test-name" "namespace"="test-namespace" "reason"="Invalid" "type"="AuthnRequestBindingValid"`,
+			},
+			wantResultingCache: []*upstreamsaml.ProviderConfig{},
+			wantResultingUpstreams: []v1alpha1.SAMLIdentityProvider{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+				Status: v1alpha1.SAMLIdentityProviderStatus{
+					Phase: "Error",
+					Conditions: []v1alpha1.Condition{
+						{Type: "AuthnRequestBindingValid", Status: "False", LastTransitionTime: now, Reason: "Invalid", Message: `spec.authnRequest.binding has unknown value "HTTP-Artifact"`},
+						validMetadataCond,
+					},
+				},
+			}},
+		},
+		{
+			name: "existing valid upstream with unchanged conditions",
+			inputUpstreams: []runtime.Object{&v1alpha1.SAMLIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234},
+				Spec: v1alpha1.SAMLIdentityProviderSpec{
+					Metadata: v1alpha1.SAMLMetadata{URL: testServerURL + "/metadata", TLS: testTLS},
+				},
+				Status: v1alpha1.SAMLIdentityProviderStatus{
+					Phase: "Ready",
+					Conditions: []v1alpha1.Condition{
+						withGeneration(validRedirectCond, 1234),
+						withGeneration(validMetadataCond, 1234),
+					},
+				},
+			}},
+			wantResultingCache: []*upstreamsaml.ProviderConfig{testRedirectProvider},
+			wantResultingUpstreams: []v1alpha1.SAMLIdentityProvider{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234},
+				Status: v1alpha1.SAMLIdentityProviderStatus{
+					Phase: "Ready",
+					Conditions: []v1alpha1.Condition{
+						withGeneration(validRedirectCond, 1234),
+						withGeneration(validMetadataCond, 1234),
+					},
+				},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fakePinnipedClient := pinnipedfake.NewSimpleClientset(tt.inputUpstreams...)
+			pinnipedInformers := pinnipedinformers.NewSharedInformerFactory(fakePinnipedClient, 0)
+			testLog := testlogger.New(t)
+			cache := provider.NewDynamicUpstreamIDPProvider()
+			cache.SetSAMLIdentityProviders([]provider.UpstreamSAMLIdentityProviderI{
+				&upstreamsaml.ProviderConfig{Name: "initial-entry"},
+			})
+
+			controller := New(
+				cache,
+				fakePinnipedClient,
+				pinnipedInformers.IDP().V1alpha1().SAMLIdentityProviders(),
+				testLog,
+				controllerlib.WithInformer,
+			)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			pinnipedInformers.Start(ctx.Done())
+			controllerlib.TestRunSynchronously(t, controller)
+
+			syncCtx := controllerlib.Context{Context: ctx, Key: controllerlib.Key{}}
+
+			if err := controllerlib.TestSync(t, controller, syncCtx); tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, strings.Join(tt.wantLogs, "\n"), strings.Join(testLog.Lines(), "\n"))
+
+			actualIDPList := cache.GetSAMLIdentityProviders()
+			require.Equal(t, len(tt.wantResultingCache), len(actualIDPList))
+			for i := range actualIDPList {
+				require.Equal(t, tt.wantResultingCache[i], actualIDPList[i].(*upstreamsaml.ProviderConfig))
+			}
+
+			actualUpstreams, err := fakePinnipedClient.IDPV1alpha1().SAMLIdentityProviders(testNamespace).List(ctx, metav1.ListOptions{})
+			require.NoError(t, err)
+
+			// Assert on the expected Status of the upstreams. Preprocess the upstreams a bit so that they're easier to assert against.
+			require.ElementsMatch(t, tt.wantResultingUpstreams, normalizeSAMLUpstreams(actualUpstreams.Items, now))
+
+			// Running the sync() a second time should be idempotent except for logs, and should return the same error.
+			// This also helps exercise code paths where the metadata hits cache.
+			if err := controllerlib.TestSync(t, controller, syncCtx); tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func withGeneration(condition v1alpha1.Condition, generation int64) v1alpha1.Condition {
+	condition.ObservedGeneration = generation
+	return condition
+}
+
+func normalizeSAMLUpstreams(upstreams []v1alpha1.SAMLIdentityProvider, now metav1.Time) []v1alpha1.SAMLIdentityProvider {
+	result := make([]v1alpha1.SAMLIdentityProvider, 0, len(upstreams))
+	for _, u := range upstreams {
+		normalized := u.DeepCopy()
+
+		// We're only interested in comparing the status, so zero out the spec.
+		normalized.Spec = v1alpha1.SAMLIdentityProviderSpec{}
+
+		// Round down the LastTransitionTime values to `now` if they were just updated. This makes
+		// it much easier to encode assertions about the expected timestamps.
+		for i := range normalized.Status.Conditions {
+			if time.Since(normalized.Status.Conditions[i].LastTransitionTime.Time) < 5*time.Second {
+				normalized.Status.Conditions[i].LastTransitionTime = now
+			}
+		}
+		result = append(result, *normalized)
+	}
+
+	return result
+}
+
+func newTestCertificate(t *testing.T) (*x509.Certificate, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-signing-certificate"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, der
+}
@@ -0,0 +1,70 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package supervisorstorage
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+
+	pinnipedcontroller "go.pinniped.dev/internal/controller"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/metrics"
+	"go.pinniped.dev/internal/plog"
+)
+
+// SessionDatabase is a session storage backend which is not backed by Secrets, and which therefore needs to be
+// garbage collected by its own controller.
+type SessionDatabase interface {
+	// GarbageCollect deletes all entries which should be garbage collected at the given time, and returns the type
+	// of resource of each deleted entry.
+	GarbageCollect(now time.Time) ([]string, error)
+}
+
+type databaseGarbageCollectorController struct {
+	db    SessionDatabase
+	clock clock.Clock
+}
+
+// DatabaseGarbageCollectorController returns a controller which garbage collects the expired entries of the given
+// database, in the same way that GarbageCollectorController garbage collects the expired Secrets. There are no
+// informers to trigger it, so it is started by an initial event and then requeues itself.
+func DatabaseGarbageCollectorController(
+	clock clock.Clock,
+	db SessionDatabase,
+	withInitialEvent pinnipedcontroller.WithInitialEventOptionFunc,
+) controllerlib.Controller {
+	return controllerlib.New(
+		controllerlib.Config{
+			Name: "database-garbage-collector-controller",
+			Syncer: &databaseGarbageCollectorController{
+				db:    db,
+				clock: clock,
+			},
+		},
+		withInitialEvent(controllerlib.Key{}),
+	)
+}
+
+func (c *databaseGarbageCollectorController) Sync(ctx controllerlib.Context) error {
+	// Always run again after the repeat interval, even when this sweep fails.
+	defer ctx.Queue.AddAfter(ctx.Key, minimumRepeatInterval)
+
+	plog.Info("starting database storage garbage collection sweep")
+
+	deleted, err := c.db.GarbageCollect(c.clock.Now())
+	if err != nil {
+		plog.WarningErr("failed to garbage collect database", err)
+		return nil
+	}
+
+	for _, resource := range deleted {
+		metrics.RecordGarbageCollectedSession(resource)
+	}
+	if len(deleted) > 0 {
+		plog.Info("storage garbage collector deleted database entries", "count", len(deleted))
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package supervisorstorage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/util/clock"
+
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/testutil"
+)
+
+type fakeSessionDatabase struct {
+	deleted []string
+	err     error
+
+	calledWith []time.Time
+}
+
+func (f *fakeSessionDatabase) GarbageCollect(now time.Time) ([]string, error) {
+	f.calledWith = append(f.calledWith, now)
+	return f.deleted, f.err
+}
+
+func TestDatabaseGarbageCollectorControllerInitialEvent(t *testing.T) {
+	observableWithInitialEventOption := testutil.NewObservableWithInitialEventOption()
+	_ = DatabaseGarbageCollectorController(
+		clock.RealClock{},
+		&fakeSessionDatabase{},
+		observableWithInitialEventOption.WithInitialEvent,
+	)
+	require.Equal(t, &controllerlib.Key{}, observableWithInitialEventOption.GetInitialEventKey())
+}
+
+func TestDatabaseGarbageCollectorControllerSync(t *testing.T) {
+	frozenNow := time.Now().UTC()
+
+	tests := []struct {
+		name string
+		db   *fakeSessionDatabase
+	}{
+		{
+			name: "nothing to garbage collect",
+			db:   &fakeSessionDatabase{},
+		},
+		{
+			name: "some entries were garbage collected",
+			db:   &fakeSessionDatabase{deleted: []string{"authcode", "pkce", "authcode"}},
+		},
+		{
+			name: "garbage collection fails",
+			db:   &fakeSessionDatabase{err: errors.New("some database error")},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			subject := DatabaseGarbageCollectorController(
+				clock.NewFakeClock(frozenNow),
+				tt.db,
+				controllerlib.WithInitialEvent,
+			)
+			queue := &testQueue{t: t}
+			syncContext := controllerlib.Context{
+				Context: context.Background(),
+				Name:    subject.Name(),
+				Key:     controllerlib.Key{},
+				Queue:   queue,
+			}
+
+			require.NoError(t, controllerlib.TestSync(t, subject, syncContext))
+
+			require.Equal(t, []time.Time{frozenNow}, tt.db.calledWith)
+			// It always runs again after the repeat interval.
+			require.True(t, queue.called)
+			require.Equal(t, controllerlib.Key{}, queue.key)
+			require.Equal(t, minimumRepeatInterval, queue.duration)
+		})
+	}
+}
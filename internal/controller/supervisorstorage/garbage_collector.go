@@ -16,6 +16,7 @@ import (
 	pinnipedcontroller "go.pinniped.dev/internal/controller"
 	"go.pinniped.dev/internal/controllerlib"
 	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/metrics"
 	"go.pinniped.dev/internal/plog"
 )
 
@@ -108,6 +109,7 @@ func (c *garbageCollectorController) Sync(ctx controllerlib.Context) error {
 				continue
 			}
 			plog.Info("storage garbage collector deleted resource", logKV(secret))
+			metrics.RecordGarbageCollectedSession(secret.Labels[crud.SecretLabelKey])
 		}
 	}
 
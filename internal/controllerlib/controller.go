@@ -17,6 +17,7 @@ import (
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 
+	"go.pinniped.dev/internal/metrics"
 	"go.pinniped.dev/internal/plog"
 )
 
@@ -204,6 +205,10 @@ func (c *controller) handleKey(key Key, err error) {
 		return
 	}
 
+	if !errors.Is(err, ErrSyntheticRequeue) {
+		metrics.RecordControllerSyncError(c.Name())
+	}
+
 	retryForever := c.maxRetries <= 0
 	shouldRetry := retryForever || c.queue.NumRequeues(key) < c.maxRetries
 
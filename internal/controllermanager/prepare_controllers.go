@@ -23,10 +23,13 @@ import (
 	"go.pinniped.dev/internal/controller/apicerts"
 	"go.pinniped.dev/internal/controller/authenticator/authncache"
 	"go.pinniped.dev/internal/controller/authenticator/cachecleaner"
+	"go.pinniped.dev/internal/controller/authenticator/certttl"
 	"go.pinniped.dev/internal/controller/authenticator/jwtcachefiller"
 	"go.pinniped.dev/internal/controller/authenticator/webhookcachefiller"
 	"go.pinniped.dev/internal/controller/impersonatorconfig"
 	"go.pinniped.dev/internal/controller/kubecertagent"
+	"go.pinniped.dev/internal/controller/kubecsr"
+	"go.pinniped.dev/internal/controller/signingcert"
 	"go.pinniped.dev/internal/controllerlib"
 	"go.pinniped.dev/internal/deploymentref"
 	"go.pinniped.dev/internal/downward"
@@ -58,6 +61,10 @@ type Config struct {
 	// the kubecertagent package's controllers should manage the agent pods.
 	KubeCertAgentConfig *concierge.KubeCertAgentSpec
 
+	// KubeCSRConfig comes from the Pinniped config API (see api.Config). It configures whether the
+	// kubecsr package's controller should report the status of the KubeCertificateSigningRequest strategy.
+	KubeCSRConfig *concierge.KubeCSRSpec
+
 	// DiscoveryURLOverride allows a caller to inject a hardcoded discovery URL into Pinniped
 	// discovery document.
 	DiscoveryURLOverride *string
@@ -70,6 +77,11 @@ type Config struct {
 	// This is filled with the Kube API server's signing cert by a controller, if it can be found.
 	DynamicSigningCertProvider dynamiccert.Private
 
+	// ExternalSigningCertProvider provides a setter and a getter to the CA cert and key which are configured in the
+	// spec.signingCertificate field of the CredentialIssuer. When it is set, it is used to sign certs for Pinniped
+	// clients wishing to login if the Kube API server's signing cert cannot be found.
+	ExternalSigningCertProvider dynamiccert.Private
+
 	// ImpersonationSigningCertProvider provides a setter and a getter to the CA cert that should be
 	// used to sign client certs for authentication to the impersonation proxy. This CA is used by
 	// the TokenCredentialRequest to sign certs and by the impersonation proxy to check certs.
@@ -209,6 +221,23 @@ func PrepareControllers(c *Config) (func(ctx context.Context), error) {
 			),
 			singletonWorker,
 		).
+		// The signing cert controller is responsible for loading the CA cert and key which are configured in the
+		// CredentialIssuer, as well as reporting status on this cluster integration strategy.
+		WithController(
+			signingcert.NewController(
+				c.ServerInstallationInfo.Namespace,
+				c.NamesConfig.CredentialIssuer,
+				c.DiscoveryURLOverride,
+				client.PinnipedConcierge,
+				informers.pinniped.Config().V1alpha1().CredentialIssuers(),
+				informers.installationNamespaceK8s.Core().V1().Secrets(),
+				informers.kubePublicNamespaceK8s.Core().V1().ConfigMaps(),
+				c.ExternalSigningCertProvider,
+				clock.RealClock{},
+				controllerlib.WithInitialEvent,
+			),
+			singletonWorker,
+		).
 		// The kube-cert-agent legacy pod cleaner controller is responsible for cleaning up pods that were deployed by
 		// versions of Pinniped prior to v0.7.0. If we stop supporting upgrades from v0.7.0, we can safely remove this.
 		WithController(
@@ -247,6 +276,17 @@ func PrepareControllers(c *Config) (func(ctx context.Context), error) {
 			),
 			singletonWorker,
 		).
+		// The cert TTL controller is responsible for limiting the lifetime of the client certificates issued for
+		// authenticators to the max configured in the CredentialIssuer.
+		WithController(
+			certttl.New(
+				c.NamesConfig.CredentialIssuer,
+				c.AuthenticatorCache,
+				informers.pinniped.Config().V1alpha1().CredentialIssuers(),
+				klogr.New(),
+			),
+			singletonWorker,
+		).
 
 		// The impersonator configuration controller dynamically configures the impersonation proxy feature.
 		WithController(
@@ -300,6 +340,23 @@ func PrepareControllers(c *Config) (func(ctx context.Context), error) {
 			singletonWorker,
 		)
 
+	// The kube CSR status controller is responsible for reporting whether client certificates can be issued through
+	// the cluster's CertificateSigningRequest API, which is only done when that strategy is enabled.
+	if c.KubeCSRConfig != nil && c.KubeCSRConfig.Enabled {
+		controllerManager = controllerManager.WithController(
+			kubecsr.NewStatusController(
+				c.NamesConfig.CredentialIssuer,
+				c.DiscoveryURLOverride,
+				client,
+				informers.kubePublicNamespaceK8s.Core().V1().ConfigMaps(),
+				informers.pinniped.Config().V1alpha1().CredentialIssuers(),
+				clock.RealClock{},
+				controllerlib.WithInitialEvent,
+			),
+			singletonWorker,
+		)
+	}
+
 	// Return a function which starts the informers and controllers.
 	return func(ctx context.Context) {
 		informers.startAndWaitForSync(ctx)
@@ -39,6 +39,8 @@ const (
 	ErrSecretVersionMismatch = constable.Error("secret storage data has incorrect version")
 )
 
+// Storage stores JSON data by signature. Errors about missing entries and conflicting updates can be detected with
+// the helpers of k8s.io/apimachinery/pkg/api/errors, e.g. errors.IsNotFound(), no matter how the data is stored.
 type Storage interface {
 	Create(ctx context.Context, signature string, data JSON, additionalLabels map[string]string) (resourceVersion string, err error)
 	Get(ctx context.Context, signature string, data JSON) (resourceVersion string, err error)
@@ -49,6 +51,17 @@ type Storage interface {
 
 type JSON interface{} // document that we need valid JSON types
 
+// StorageFactory returns the Storage for the given type of resource. The stored entries may be garbage collected
+// after the given lifetime.
+type StorageFactory func(resource string, lifetime time.Duration) Storage
+
+// SecretsStorageFactory returns a StorageFactory which stores each entry as a Secret.
+func SecretsStorageFactory(secrets corev1client.SecretInterface, clock func() time.Time) StorageFactory {
+	return func(resource string, lifetime time.Duration) Storage {
+		return New(resource, secrets, clock, lifetime)
+	}
+}
+
 func New(resource string, secrets corev1client.SecretInterface, clock func() time.Time, lifetime time.Duration) Storage {
 	return &secretsStorage{
 		resource:      resource,
@@ -111,6 +111,37 @@ func (c *Cache) Put(key interface{}, cred *clientauthenticationv1beta1.ExecCrede
 	})
 }
 
+// Delete removes the cached credential for the given key, if there is one.
+func (c *Cache) Delete(key interface{}) {
+	// If the cache file does not exist, there is nothing to delete.
+	if _, err := os.Stat(c.path); errors.Is(err, os.ErrNotExist) {
+		return
+	}
+
+	cacheKey := jsonSHA256Hex(key)
+	c.withCache(func(cache *credCache) {
+		kept := cache.Entries[:0]
+		for _, e := range cache.Entries {
+			if e.Key != cacheKey {
+				kept = append(kept, e)
+			}
+		}
+		cache.Entries = kept
+	})
+}
+
+// DeleteAll removes every cached credential.
+func (c *Cache) DeleteAll() {
+	// If the cache file does not exist, there is nothing to delete.
+	if _, err := os.Stat(c.path); errors.Is(err, os.ErrNotExist) {
+		return
+	}
+
+	c.withCache(func(cache *credCache) {
+		cache.Entries = cache.Entries[:0]
+	})
+}
+
 func jsonSHA256Hex(key interface{}) string {
 	hash := sha256.New()
 	if err := json.NewEncoder(hash).Encode(key); err != nil {
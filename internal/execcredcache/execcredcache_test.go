@@ -351,6 +351,105 @@ func TestPutToken(t *testing.T) {
 	}
 }
 
+func TestDelete(t *testing.T) {
+	t.Parallel()
+	now := time.Now().Round(1 * time.Second)
+	oneHourFromNow := metav1.NewTime(now.Add(1 * time.Hour))
+
+	type testKey struct{ K1, K2 string }
+
+	writeEntries := func(t *testing.T, tmp string, keys ...testKey) {
+		validCache := emptyCache()
+		for _, k := range keys {
+			validCache.Entries = append(validCache.Entries, entry{
+				Key:               jsonSHA256Hex(k),
+				CreationTimestamp: metav1.NewTime(now.Add(-2 * time.Minute)),
+				LastUsedTimestamp: metav1.NewTime(now),
+				Credential: &clientauthenticationv1beta1.ExecCredentialStatus{
+					Token:               "test-token",
+					ExpirationTimestamp: &oneHourFromNow,
+				},
+			})
+		}
+		require.NoError(t, validCache.writeTo(tmp))
+	}
+
+	tests := []struct {
+		name         string
+		makeTestFile func(t *testing.T, tmp string)
+		deleteAll    bool
+		wantErrors   []string
+		wantEntries  int
+	}{
+		{
+			name: "file does not exist",
+		},
+		{
+			name:      "file does not exist, delete all",
+			deleteAll: true,
+		},
+		{
+			name: "invalid file",
+			makeTestFile: func(t *testing.T, tmp string) {
+				require.NoError(t, ioutil.WriteFile(tmp, []byte("invalid yaml"), 0600))
+			},
+			wantErrors: []string{
+				"failed to read cache, resetting: invalid cache file: error unmarshaling JSON: while decoding JSON: json: cannot unmarshal string into Go value of type execcredcache.credCache",
+			},
+		},
+		{
+			name: "cache miss",
+			makeTestFile: func(t *testing.T, tmp string) {
+				writeEntries(t, tmp, testKey{K1: "v3", K2: "v4"})
+			},
+			wantEntries: 1,
+		},
+		{
+			name: "cache hit",
+			makeTestFile: func(t *testing.T, tmp string) {
+				writeEntries(t, tmp, testKey{K1: "v1", K2: "v2"}, testKey{K1: "v3", K2: "v4"})
+			},
+			wantEntries: 1,
+		},
+		{
+			name: "delete all",
+			makeTestFile: func(t *testing.T, tmp string) {
+				writeEntries(t, tmp, testKey{K1: "v1", K2: "v2"}, testKey{K1: "v3", K2: "v4"})
+			},
+			deleteAll:   true,
+			wantEntries: 0,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			tmp := testutil.TempDir(t) + "/credentials.yaml"
+			if tt.makeTestFile != nil {
+				tt.makeTestFile(t, tmp)
+			}
+			// Initialize a cache with a reporter that collects errors
+			errors := errorCollector{t: t}
+			c := New(tmp)
+			c.errReporter = errors.report
+			if tt.deleteAll {
+				c.DeleteAll()
+			} else {
+				c.Delete(testKey{K1: "v1", K2: "v2"})
+			}
+			errors.require(tt.wantErrors, "TEMPFILE", tmp)
+			if tt.makeTestFile == nil {
+				require.NoFileExists(t, tmp)
+				return
+			}
+			cache, err := readCache(tmp)
+			require.NoError(t, err)
+			require.Len(t, cache.Entries, tt.wantEntries)
+			require.Nil(t, c.Get(testKey{K1: "v1", K2: "v2"}))
+		})
+	}
+}
+
 func TestHashing(t *testing.T) {
 	type testKey struct{ K1, K2 string }
 	require.Equal(t, "38e0b9de817f645c4bec37c0d4a3e58baecccb040f5718dc069a72c7385a0bed", jsonSHA256Hex(nil))
@@ -22,6 +22,7 @@ import (
 	coretesting "k8s.io/client-go/testing"
 
 	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/psession"
 )
 
 const namespace = "test-ns"
@@ -87,12 +88,14 @@ func TestAccessTokenStorage(t *testing.T) {
 		RequestedScope: nil,
 		GrantedScope:   nil,
 		Form:           url.Values{"key": []string{"val"}},
-		Session: &openid.DefaultSession{
-			Claims:    nil,
-			Headers:   nil,
-			ExpiresAt: nil,
-			Username:  "snorlax",
-			Subject:   "panda",
+		Session: &psession.PinnipedSession{
+			DefaultSession: openid.DefaultSession{
+				Claims:    nil,
+				Headers:   nil,
+				ExpiresAt: nil,
+				Username:  "snorlax",
+				Subject:   "panda",
+			},
 		},
 		RequestedAudience: nil,
 		GrantedAudience:   nil,
@@ -152,9 +155,11 @@ func TestAccessTokenStorageRevocation(t *testing.T) {
 			},
 		},
 		Form: url.Values{"key": []string{"val"}},
-		Session: &openid.DefaultSession{
-			Username: "snorlax",
-			Subject:  "panda",
+		Session: &psession.PinnipedSession{
+			DefaultSession: openid.DefaultSession{
+				Username: "snorlax",
+				Subject:  "panda",
+			},
 		},
 	}
 	err := storage.CreateAccessTokenSession(ctx, "fancy-signature", request)
@@ -246,10 +251,10 @@ func TestCreateWithWrongRequesterDataTypes(t *testing.T) {
 		Client:  &clientregistry.Client{},
 	}
 	err := storage.CreateAccessTokenSession(ctx, "signature-doesnt-matter", request)
-	require.EqualError(t, err, "requester's session must be of type openid.DefaultSession")
+	require.EqualError(t, err, "requester's session must be of type psession.PinnipedSession")
 
 	request = &fosite.Request{
-		Session: &openid.DefaultSession{},
+		Session: &psession.PinnipedSession{},
 		Client:  nil,
 	}
 	err = storage.CreateAccessTokenSession(ctx, "signature-doesnt-matter", request)
@@ -261,7 +266,7 @@ func TestCreateWithoutRequesterID(t *testing.T) {
 
 	request := &fosite.Request{
 		ID:      "", // empty ID
-		Session: &openid.DefaultSession{},
+		Session: &psession.PinnipedSession{},
 		Client:  &clientregistry.Client{},
 	}
 	err := storage.CreateAccessTokenSession(ctx, "signature-doesnt-matter", request)
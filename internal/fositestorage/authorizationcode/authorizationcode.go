@@ -11,7 +11,6 @@ import (
 
 	"github.com/ory/fosite"
 	"github.com/ory/fosite/handler/oauth2"
-	"github.com/ory/fosite/handler/openid"
 	"k8s.io/apimachinery/pkg/api/errors"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 
@@ -19,6 +18,7 @@ import (
 	"go.pinniped.dev/internal/crud"
 	"go.pinniped.dev/internal/fositestorage"
 	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/psession"
 )
 
 const (
@@ -43,7 +43,12 @@ type AuthorizeCodeSession struct {
 }
 
 func New(secrets corev1client.SecretInterface, clock func() time.Time, sessionStorageLifetime time.Duration) oauth2.AuthorizeCodeStorage {
-	return &authorizeCodeStorage{storage: crud.New(TypeLabelValue, secrets, clock, sessionStorageLifetime)}
+	return NewWithStorageFactory(crud.SecretsStorageFactory(secrets, clock), sessionStorageLifetime)
+}
+
+// NewWithStorageFactory is like New, but stores the sessions in the Storage returned by storageFactory.
+func NewWithStorageFactory(storageFactory crud.StorageFactory, sessionStorageLifetime time.Duration) oauth2.AuthorizeCodeStorage {
+	return &authorizeCodeStorage{storage: storageFactory(TypeLabelValue, sessionStorageLifetime)}
 }
 
 func (a *authorizeCodeStorage) CreateAuthorizeCodeSession(ctx context.Context, signature string, requester fosite.Requester) error {
@@ -139,7 +144,7 @@ func NewValidEmptyAuthorizeCodeSession() *AuthorizeCodeSession {
 	return &AuthorizeCodeSession{
 		Request: &fosite.Request{
 			Client:  &clientregistry.Client{},
-			Session: &openid.DefaultSession{},
+			Session: psession.NewPinnipedSession(),
 		},
 	}
 }
@@ -314,7 +319,21 @@ const ExpectedAuthorizeCodeSessionJSONFromFuzzing = `{
 			"ɦüHêQ仏1őƖ2Ė暮唍ǞʜƢú4": "2049-05-13T15:27:20.968432454Z"
 		  },
 		  "Username": "+韁臯氃妪婝rȤ\"h丬鎒ơ娻}ɼƟȥE",
-		  "Subject": "龳ǽÙ龦O亾EW莛8嘶×姮c恭企"
+		  "Subject": "龳ǽÙ龦O亾EW莛8嘶×姮c恭企",
+		  "custom": {
+			"providerName": "some-provider-name",
+			"providerType": "oidc",
+			"oidc": {
+			  "upstreamRefreshToken": "some-upstream-refresh-token",
+			  "upstreamIssuer": "https://some-upstream-issuer.com"
+			},
+			"ldap": {
+			  "username": "some-ldap-username"
+			},
+			"activedirectory": {
+			  "username": "some-active-directory-username"
+			}
+		  }
 		},
 		"requestedAudience": [
 		  "邖ɐ5檄¬",
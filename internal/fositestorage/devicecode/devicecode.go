@@ -0,0 +1,246 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package devicecode
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ory/fosite"
+	"k8s.io/apimachinery/pkg/api/errors"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/fositestorage"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/psession"
+)
+
+const (
+	TypeLabelValue         = "device-code"
+	UserCodeTypeLabelValue = "device-user-code"
+
+	ErrInvalidDeviceCodeRequestVersion = constable.Error("device code request data has wrong version")
+	ErrInvalidDeviceCodeRequestData    = constable.Error("device code request data must be present")
+
+	deviceCodeStorageVersion = "1"
+)
+
+// Status is the state of a device authorization request.
+type Status string
+
+const (
+	// StatusPending means that the end user has not yet finished logging in to approve the request.
+	StatusPending Status = "pending"
+
+	// StatusApproved means that the end user has logged in, so the device code may be redeemed for tokens.
+	StatusApproved Status = "approved"
+
+	// StatusDenied means that the end user's login failed, so the device code may never be redeemed for tokens.
+	StatusDenied Status = "denied"
+)
+
+// Session is a device authorization request, as stored by a DeviceCodeStorage.
+type Session struct {
+	// Request is the original device authorization request. Once the request is approved, its session holds
+	// the downstream identity of the end user.
+	Request *fosite.Request `json:"request"`
+
+	// UserCode is the code which the end user enters at the device verification endpoint.
+	UserCode string `json:"userCode"`
+
+	// Status is the state of the request.
+	Status Status `json:"status"`
+
+	// ExpiresAt is the time after which the device code and the user code may no longer be used.
+	ExpiresAt time.Time `json:"expiresAt"`
+
+	// LastPolledAt is the last time that the client asked the token endpoint about this request, used to
+	// enforce the polling interval.
+	LastPolledAt time.Time `json:"lastPolledAt"`
+
+	Version string `json:"version"`
+
+	// The resource version of the stored Session, used to avoid conflicting updates.
+	resourceVersion string
+}
+
+// DeviceCodeStorage stores device authorization requests. They are keyed by the signature of the device code,
+// and they can also be found by their user code.
+type DeviceCodeStorage interface {
+	CreateDeviceCodeSession(ctx context.Context, signature string, userCode string, expiresAt time.Time, requester fosite.Requester) error
+	GetDeviceCodeSession(ctx context.Context, signature string) (*Session, error)
+	GetDeviceCodeSessionByUserCode(ctx context.Context, userCode string) (string, *Session, error)
+	UpdateDeviceCodeSession(ctx context.Context, signature string, session *Session) error
+	DeleteDeviceCodeSession(ctx context.Context, signature string) error
+}
+
+var _ DeviceCodeStorage = &deviceCodeStorage{}
+
+type deviceCodeStorage struct {
+	storage         crud.Storage
+	userCodeStorage crud.Storage
+}
+
+// userCodeSession maps a user code to the signature of its device code.
+type userCodeSession struct {
+	Signature string `json:"signature"`
+	Version   string `json:"version"`
+}
+
+func New(secrets corev1client.SecretInterface, clock func() time.Time, sessionStorageLifetime time.Duration) DeviceCodeStorage {
+	return NewWithStorageFactory(crud.SecretsStorageFactory(secrets, clock), sessionStorageLifetime)
+}
+
+// NewWithStorageFactory is like New, but stores the device authorization requests in the Storage returned by
+// storageFactory.
+func NewWithStorageFactory(storageFactory crud.StorageFactory, sessionStorageLifetime time.Duration) DeviceCodeStorage {
+	return &deviceCodeStorage{
+		storage:         storageFactory(TypeLabelValue, sessionStorageLifetime),
+		userCodeStorage: storageFactory(UserCodeTypeLabelValue, sessionStorageLifetime),
+	}
+}
+
+// NormalizeUserCode returns the canonical form of a user code as typed by an end user, ignoring case and any
+// punctuation or whitespace.
+func NormalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		default:
+			return -1
+		}
+	}, userCode)
+}
+
+func (a *deviceCodeStorage) CreateDeviceCodeSession(ctx context.Context, signature string, userCode string, expiresAt time.Time, requester fosite.Requester) error {
+	request, err := fositestorage.ValidateAndExtractAuthorizeRequest(requester)
+	if err != nil {
+		return err
+	}
+
+	// Create the user code first, so an accidental collision with an existing user code is detected before
+	// the device code is stored.
+	_, err = a.userCodeStorage.Create(ctx,
+		NormalizeUserCode(userCode),
+		&userCodeSession{Signature: signature, Version: deviceCodeStorageVersion},
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.storage.Create(ctx,
+		signature,
+		&Session{
+			Request:   request,
+			UserCode:  userCode,
+			Status:    StatusPending,
+			ExpiresAt: expiresAt,
+			Version:   deviceCodeStorageVersion,
+		},
+		map[string]string{fositestorage.StorageRequestIDLabelName: requester.GetID()},
+	)
+	return err
+}
+
+func (a *deviceCodeStorage) GetDeviceCodeSession(ctx context.Context, signature string) (*Session, error) {
+	session := newValidEmptyDeviceCodeSession()
+	rv, err := a.storage.Get(ctx, signature, session)
+
+	if errors.IsNotFound(err) {
+		return nil, fosite.ErrNotFound.WithWrap(err).WithDebug(err.Error())
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device code session for %s: %w", signature, err)
+	}
+
+	if version := session.Version; version != deviceCodeStorageVersion {
+		return nil, fmt.Errorf("%w: device code session for %s has version %s instead of %s",
+			ErrInvalidDeviceCodeRequestVersion, signature, version, deviceCodeStorageVersion)
+	}
+
+	if session.Request.ID == "" {
+		return nil, fmt.Errorf("malformed device code session for %s: %w", signature, ErrInvalidDeviceCodeRequestData)
+	}
+
+	session.resourceVersion = rv
+	return session, nil
+}
+
+func (a *deviceCodeStorage) GetDeviceCodeSessionByUserCode(ctx context.Context, userCode string) (string, *Session, error) {
+	normalizedUserCode := NormalizeUserCode(userCode)
+	if normalizedUserCode == "" {
+		return "", nil, fosite.ErrNotFound
+	}
+
+	userCodeSession := &userCodeSession{}
+	_, err := a.userCodeStorage.Get(ctx, normalizedUserCode, userCodeSession)
+
+	if errors.IsNotFound(err) {
+		return "", nil, fosite.ErrNotFound.WithWrap(err).WithDebug(err.Error())
+	}
+
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get device user code session: %w", err)
+	}
+
+	if version := userCodeSession.Version; version != deviceCodeStorageVersion {
+		return "", nil, fmt.Errorf("%w: device user code session has version %s instead of %s",
+			ErrInvalidDeviceCodeRequestVersion, version, deviceCodeStorageVersion)
+	}
+
+	session, err := a.GetDeviceCodeSession(ctx, userCodeSession.Signature)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return userCodeSession.Signature, session, nil
+}
+
+func (a *deviceCodeStorage) UpdateDeviceCodeSession(ctx context.Context, signature string, session *Session) error {
+	if _, err := fositestorage.ValidateAndExtractAuthorizeRequest(session.Request); err != nil {
+		return err
+	}
+
+	rv, err := a.storage.Update(ctx, signature, session.resourceVersion, session)
+	if err != nil {
+		return err
+	}
+
+	session.resourceVersion = rv
+	return nil
+}
+
+func (a *deviceCodeStorage) DeleteDeviceCodeSession(ctx context.Context, signature string) error {
+	session, err := a.GetDeviceCodeSession(ctx, signature)
+	if err != nil {
+		return err
+	}
+
+	if err := a.storage.Delete(ctx, signature); err != nil {
+		return err
+	}
+
+	// The user code is useless without its device code, so clean it up too. If this fails, then the
+	// garbage collector will eventually delete it.
+	_ = a.userCodeStorage.Delete(ctx, NormalizeUserCode(session.UserCode))
+	return nil
+}
+
+func newValidEmptyDeviceCodeSession() *Session {
+	return &Session{
+		Request: &fosite.Request{
+			Client:  &clientregistry.Client{},
+			Session: psession.NewPinnipedSession(),
+		},
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package devicecode
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/handler/openid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	coretesting "k8s.io/client-go/testing"
+
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/psession"
+)
+
+const namespace = "test-ns"
+
+var fakeNow = time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+var lifetime = time.Minute * 10
+var fakeNowPlusLifetimeAsString = metav1.Time{Time: fakeNow.Add(lifetime)}.Format(time.RFC3339)
+
+func TestDeviceCodeStorage(t *testing.T) {
+	secretsGVR := schema.GroupVersionResource{
+		Group:    "",
+		Version:  "v1",
+		Resource: "secrets",
+	}
+
+	const (
+		deviceCodeSecretName = "pinniped-storage-device-code-pwu5zs7lekbhnln2w4"
+		userCodeSecretName   = "pinniped-storage-device-user-code-lazecmer2m"
+	)
+
+	wantActions := []coretesting.Action{
+		coretesting.NewCreateAction(secretsGVR, namespace, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            userCodeSecretName,
+				ResourceVersion: "",
+				Labels: map[string]string{
+					"storage.pinniped.dev/type": "device-user-code",
+				},
+				Annotations: map[string]string{
+					"storage.pinniped.dev/garbage-collect-after": fakeNowPlusLifetimeAsString,
+				},
+			},
+			Data: map[string][]byte{
+				"pinniped-storage-data":    []byte(`{"signature":"fancy-signature","version":"1"}`),
+				"pinniped-storage-version": []byte("1"),
+			},
+			Type: "storage.pinniped.dev/device-user-code",
+		}),
+		coretesting.NewCreateAction(secretsGVR, namespace, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            deviceCodeSecretName,
+				ResourceVersion: "",
+				Labels: map[string]string{
+					"storage.pinniped.dev/type":       "device-code",
+					"storage.pinniped.dev/request-id": "abcd-1",
+				},
+				Annotations: map[string]string{
+					"storage.pinniped.dev/garbage-collect-after": fakeNowPlusLifetimeAsString,
+				},
+			},
+			Data: map[string][]byte{
+				"pinniped-storage-data":    []byte(`{"request":{"id":"abcd-1","requestedAt":"0001-01-01T00:00:00Z","client":{"id":"pinny","redirect_uris":null,"grant_types":null,"response_types":null,"scopes":null,"audience":null,"public":true,"jwks_uri":"where","jwks":null,"token_endpoint_auth_method":"something","request_uris":null,"request_object_signing_alg":"","token_endpoint_auth_signing_alg":""},"scopes":null,"grantedScopes":null,"form":{"key":["val"]},"session":{"Claims":null,"Headers":null,"ExpiresAt":null,"Username":"snorlax","Subject":"panda"},"requestedAudience":null,"grantedAudience":null},"userCode":"WDJB-MJHT","status":"pending","expiresAt":"2030-01-01T00:05:00Z","lastPolledAt":"0001-01-01T00:00:00Z","version":"1"}`),
+				"pinniped-storage-version": []byte("1"),
+			},
+			Type: "storage.pinniped.dev/device-code",
+		}),
+		coretesting.NewGetAction(secretsGVR, namespace, userCodeSecretName),
+		coretesting.NewGetAction(secretsGVR, namespace, deviceCodeSecretName),
+		coretesting.NewUpdateAction(secretsGVR, namespace, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            deviceCodeSecretName,
+				ResourceVersion: "",
+				Labels: map[string]string{
+					"storage.pinniped.dev/type": "device-code",
+				},
+				Annotations: map[string]string{
+					"storage.pinniped.dev/garbage-collect-after": fakeNowPlusLifetimeAsString,
+				},
+			},
+			Data: map[string][]byte{
+				"pinniped-storage-data":    []byte(`{"request":{"id":"abcd-1","requestedAt":"0001-01-01T00:00:00Z","client":{"id":"pinny","redirect_uris":null,"grant_types":null,"response_types":null,"scopes":null,"audience":null,"public":true,"jwks_uri":"where","jwks":null,"token_endpoint_auth_method":"something","request_uris":null,"request_object_signing_alg":"","token_endpoint_auth_signing_alg":""},"scopes":null,"grantedScopes":null,"form":{"key":["val"]},"session":{"Claims":null,"Headers":null,"ExpiresAt":null,"Username":"snorlax","Subject":"panda"},"requestedAudience":null,"grantedAudience":null},"userCode":"WDJB-MJHT","status":"approved","expiresAt":"2030-01-01T00:05:00Z","lastPolledAt":"0001-01-01T00:00:00Z","version":"1"}`),
+				"pinniped-storage-version": []byte("1"),
+			},
+			Type: "storage.pinniped.dev/device-code",
+		}),
+		coretesting.NewGetAction(secretsGVR, namespace, deviceCodeSecretName),
+		coretesting.NewGetAction(secretsGVR, namespace, deviceCodeSecretName),
+		coretesting.NewDeleteAction(secretsGVR, namespace, deviceCodeSecretName),
+		coretesting.NewDeleteAction(secretsGVR, namespace, userCodeSecretName),
+	}
+
+	ctx, client, _, storage := makeTestSubject()
+
+	request := &fosite.Request{
+		ID:          "abcd-1",
+		RequestedAt: time.Time{},
+		Client: &clientregistry.Client{
+			DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{
+				DefaultClient: &fosite.DefaultClient{
+					ID:            "pinny",
+					Secret:        nil,
+					RedirectURIs:  nil,
+					GrantTypes:    nil,
+					ResponseTypes: nil,
+					Scopes:        nil,
+					Audience:      nil,
+					Public:        true,
+				},
+				JSONWebKeysURI:                    "where",
+				JSONWebKeys:                       nil,
+				TokenEndpointAuthMethod:           "something",
+				RequestURIs:                       nil,
+				RequestObjectSigningAlgorithm:     "",
+				TokenEndpointAuthSigningAlgorithm: "",
+			},
+		},
+		RequestedScope: nil,
+		GrantedScope:   nil,
+		Form:           url.Values{"key": []string{"val"}},
+		Session: &psession.PinnipedSession{
+			DefaultSession: openid.DefaultSession{
+				Claims:    nil,
+				Headers:   nil,
+				ExpiresAt: nil,
+				Username:  "snorlax",
+				Subject:   "panda",
+			},
+		},
+		RequestedAudience: nil,
+		GrantedAudience:   nil,
+	}
+	err := storage.CreateDeviceCodeSession(ctx, "fancy-signature", "WDJB-MJHT", fakeNow.Add(5*time.Minute), request)
+	require.NoError(t, err)
+
+	// The user code may be typed by the end user using a different case and punctuation.
+	signature, session, err := storage.GetDeviceCodeSessionByUserCode(ctx, "wdjb mjht")
+	require.NoError(t, err)
+	require.Equal(t, "fancy-signature", signature)
+	require.Equal(t, request, session.Request)
+	require.Equal(t, "WDJB-MJHT", session.UserCode)
+	require.Equal(t, StatusPending, session.Status)
+	require.True(t, fakeNow.Add(5*time.Minute).Equal(session.ExpiresAt))
+
+	session.Status = StatusApproved
+	err = storage.UpdateDeviceCodeSession(ctx, "fancy-signature", session)
+	require.NoError(t, err)
+
+	updatedSession, err := storage.GetDeviceCodeSession(ctx, "fancy-signature")
+	require.NoError(t, err)
+	require.Equal(t, StatusApproved, updatedSession.Status)
+	require.Equal(t, request, updatedSession.Request)
+
+	err = storage.DeleteDeviceCodeSession(ctx, "fancy-signature")
+	require.NoError(t, err)
+
+	require.Equal(t, wantActions, client.Actions())
+
+	_, _, err = storage.GetDeviceCodeSessionByUserCode(ctx, "WDJB-MJHT")
+	require.True(t, errors.Is(err, fosite.ErrNotFound))
+}
+
+func TestGetNotFound(t *testing.T) {
+	ctx, _, _, storage := makeTestSubject()
+
+	_, notFoundErr := storage.GetDeviceCodeSession(ctx, "non-existent-signature")
+	require.EqualError(t, notFoundErr, "not_found")
+	require.True(t, errors.Is(notFoundErr, fosite.ErrNotFound))
+
+	_, _, notFoundErr = storage.GetDeviceCodeSessionByUserCode(ctx, "BCDF-GHJK")
+	require.EqualError(t, notFoundErr, "not_found")
+	require.True(t, errors.Is(notFoundErr, fosite.ErrNotFound))
+
+	_, _, notFoundErr = storage.GetDeviceCodeSessionByUserCode(ctx, "--")
+	require.EqualError(t, notFoundErr, "not_found")
+	require.True(t, errors.Is(notFoundErr, fosite.ErrNotFound))
+
+	notFoundErr = storage.DeleteDeviceCodeSession(ctx, "non-existent-signature")
+	require.True(t, errors.Is(notFoundErr, fosite.ErrNotFound))
+}
+
+func TestWrongVersion(t *testing.T) {
+	ctx, _, secrets, storage := makeTestSubject()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "pinniped-storage-device-code-pwu5zs7lekbhnln2w4",
+			ResourceVersion: "",
+			Labels: map[string]string{
+				"storage.pinniped.dev/type": "device-code",
+			},
+			Annotations: map[string]string{
+				"storage.pinniped.dev/garbage-collect-after": fakeNowPlusLifetimeAsString,
+			},
+		},
+		Data: map[string][]byte{
+			"pinniped-storage-data":    []byte(`{"request":{"id":"abcd-1","requestedAt":"0001-01-01T00:00:00Z","client":{"id":"pinny","redirect_uris":null,"grant_types":null,"response_types":null,"scopes":null,"audience":null,"public":true,"jwks_uri":"where","jwks":null,"token_endpoint_auth_method":"something","request_uris":null,"request_object_signing_alg":"","token_endpoint_auth_signing_alg":""},"scopes":null,"grantedScopes":null,"form":{"key":["val"]},"session":{"Claims":null,"Headers":null,"ExpiresAt":null,"Username":"snorlax","Subject":"panda"},"requestedAudience":null,"grantedAudience":null},"userCode":"WDJB-MJHT","status":"pending","expiresAt":"2030-01-01T00:05:00Z","lastPolledAt":"0001-01-01T00:00:00Z","version":"not-the-right-version"}`),
+			"pinniped-storage-version": []byte("1"),
+		},
+		Type: "storage.pinniped.dev/device-code",
+	}
+	_, err := secrets.Create(ctx, secret, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	_, err = storage.GetDeviceCodeSession(ctx, "fancy-signature")
+
+	require.EqualError(t, err, "device code request data has wrong version: device code session for fancy-signature has version not-the-right-version instead of 1")
+}
+
+func TestUserCodeCollision(t *testing.T) {
+	ctx, _, _, storage := makeTestSubject()
+
+	request := &fosite.Request{
+		ID:      "abcd-1",
+		Session: &psession.PinnipedSession{},
+		Client:  &clientregistry.Client{},
+	}
+	err := storage.CreateDeviceCodeSession(ctx, "fancy-signature", "WDJB-MJHT", fakeNow.Add(5*time.Minute), request)
+	require.NoError(t, err)
+
+	err = storage.CreateDeviceCodeSession(ctx, "other-signature", "wdjbmjht", fakeNow.Add(5*time.Minute), request)
+	require.EqualError(t, err, `failed to create device-user-code for signature WDJBMJHT: secrets "pinniped-storage-device-user-code-lazecmer2m" already exists`)
+
+	_, err = storage.GetDeviceCodeSession(ctx, "other-signature")
+	require.True(t, errors.Is(err, fosite.ErrNotFound))
+}
+
+func TestCreateWithNilRequester(t *testing.T) {
+	ctx, _, _, storage := makeTestSubject()
+
+	err := storage.CreateDeviceCodeSession(ctx, "signature-doesnt-matter", "WDJB-MJHT", fakeNow, nil)
+	require.EqualError(t, err, "requester must be of type fosite.Request")
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	require.Equal(t, "WDJBMJHT", NormalizeUserCode("WDJB-MJHT"))
+	require.Equal(t, "WDJBMJHT", NormalizeUserCode(" wdjb mjht\n"))
+	require.Equal(t, "AB12", NormalizeUserCode("a.b-1_2"))
+	require.Equal(t, "", NormalizeUserCode("-- "))
+}
+
+func makeTestSubject() (context.Context, *fake.Clientset, corev1client.SecretInterface, DeviceCodeStorage) {
+	client := fake.NewSimpleClientset()
+	secrets := client.CoreV1().Secrets(namespace)
+	return context.Background(), client, secrets, New(secrets, clock.NewFakeClock(fakeNow).Now, lifetime)
+}
@@ -18,6 +18,7 @@ import (
 	"go.pinniped.dev/internal/crud"
 	"go.pinniped.dev/internal/fositestorage"
 	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/psession"
 )
 
 const (
@@ -42,7 +43,12 @@ type session struct {
 }
 
 func New(secrets corev1client.SecretInterface, clock func() time.Time, sessionStorageLifetime time.Duration) openid.OpenIDConnectRequestStorage {
-	return &openIDConnectRequestStorage{storage: crud.New(TypeLabelValue, secrets, clock, sessionStorageLifetime)}
+	return NewWithStorageFactory(crud.SecretsStorageFactory(secrets, clock), sessionStorageLifetime)
+}
+
+// NewWithStorageFactory is like New, but stores the sessions in the Storage returned by storageFactory.
+func NewWithStorageFactory(storageFactory crud.StorageFactory, sessionStorageLifetime time.Duration) openid.OpenIDConnectRequestStorage {
+	return &openIDConnectRequestStorage{storage: storageFactory(TypeLabelValue, sessionStorageLifetime)}
 }
 
 func (a *openIDConnectRequestStorage) CreateOpenIDConnectSession(ctx context.Context, authcode string, requester fosite.Requester) error {
@@ -112,7 +118,7 @@ func newValidEmptyOIDCSession() *session {
 	return &session{
 		Request: &fosite.Request{
 			Client:  &clientregistry.Client{},
-			Session: &openid.DefaultSession{},
+			Session: psession.NewPinnipedSession(),
 		},
 	}
 }
@@ -8,6 +8,8 @@ import (
 	"github.com/ory/fosite/handler/oauth2"
 	"github.com/ory/fosite/handler/openid"
 	"github.com/ory/fosite/handler/pkce"
+
+	"go.pinniped.dev/internal/fositestorage/devicecode"
 )
 
 // This interface seems to be missing from Fosite.
@@ -18,4 +20,5 @@ type AllFositeStorage interface {
 	oauth2.TokenRevocationStorage
 	openid.OpenIDConnectRequestStorage
 	pkce.PKCERequestStorage
+	devicecode.DeviceCodeStorage
 }
@@ -0,0 +1,68 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package responsewriter provides http.ResponseWriter wrappers for middleware which observe responses.
+package responsewriter
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// StatusRecorder remembers the response code. It still allows the delegate to flush responses and to hijack
+// connections, which the impersonation proxy needs for watches and for upgraded connections (e.g. exec).
+type StatusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+var (
+	_ http.Flusher  = &StatusRecorder{}
+	_ http.Hijacker = &StatusRecorder{}
+)
+
+// NewStatusRecorder wraps the given http.ResponseWriter.
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w}
+}
+
+func (w *StatusRecorder) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *StatusRecorder) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *StatusRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *StatusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the response writer does not support hijacking connections")
+	}
+	if w.code == 0 {
+		w.code = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+// StatusCode returns the response code which was written, which is http.StatusOK when nothing has been written yet.
+func (w *StatusRecorder) StatusCode() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
@@ -0,0 +1,184 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package idtransform transforms the username and group names of a user who has authenticated with an upstream
+// identity provider, before the downstream session is created for that user.
+package idtransform
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.pinniped.dev/internal/constable"
+)
+
+// ErrLoginDenied is returned (wrapped) by Pipeline.Evaluate when a denyLogin transform matched the user.
+const ErrLoginDenied = constable.Error("login denied by identity transform")
+
+// Type is the type of a Transform.
+type Type string
+
+const (
+	// TypePrefix adds Prefix to the start of the username or of each group name.
+	TypePrefix = Type("prefix")
+
+	// TypeRegexReplace replaces each match of Regex in the username or in each group name with Replacement.
+	TypeRegexReplace = Type("regexReplace")
+
+	// TypeLowercase converts the username or each group name to lowercase.
+	TypeLowercase = Type("lowercase")
+
+	// TypeAllowGroups removes each group whose name does not match Regex.
+	TypeAllowGroups = Type("allowGroups")
+
+	// TypeDenyGroups removes each group whose name matches Regex.
+	TypeDenyGroups = Type("denyGroups")
+
+	// TypeDenyLogin rejects the login when the username, or any group name, matches Regex.
+	TypeDenyLogin = Type("denyLogin")
+)
+
+// Target is the part of the identity which a Transform changes or inspects.
+type Target string
+
+const (
+	TargetUsername = Target("username")
+	TargetGroups   = Target("groups")
+)
+
+// Transform is the configuration of one step of a Pipeline.
+type Transform struct {
+	Type Type
+
+	// Target defaults to TargetUsername. It is ignored by TypeAllowGroups and TypeDenyGroups.
+	Target Target
+
+	// Prefix is only used by TypePrefix.
+	Prefix string
+
+	// Regex is an RE2 regular expression which is used by all types except TypePrefix and TypeLowercase.
+	Regex string
+
+	// Replacement is only used by TypeRegexReplace. It may refer to the capture groups of Regex, e.g. "${1}".
+	Replacement string
+}
+
+// Pipeline is a validated, ordered list of transforms. The zero value and a nil Pipeline make no changes.
+type Pipeline struct {
+	steps []step
+}
+
+type step func(username string, groups []string) (string, []string, error)
+
+// NewPipeline validates the given transforms and returns a Pipeline which applies them in order.
+func NewPipeline(transforms []Transform) (*Pipeline, error) {
+	p := &Pipeline{steps: make([]step, 0, len(transforms))}
+	for i, t := range transforms {
+		s, err := newStep(t)
+		if err != nil {
+			return nil, fmt.Errorf("transform %d (%s): %w", i, t.Type, err)
+		}
+		p.steps = append(p.steps, s)
+	}
+	return p, nil
+}
+
+// Evaluate runs the username and groups through each step of the pipeline and returns the transformed username and
+// groups. It returns an error wrapping ErrLoginDenied when the user must not be allowed to log in.
+func (p *Pipeline) Evaluate(username string, groups []string) (string, []string, error) {
+	if p == nil {
+		return username, groups, nil
+	}
+	// Never modify the caller's slice.
+	groups = append([]string(nil), groups...)
+	for _, s := range p.steps {
+		var err error
+		username, groups, err = s(username, groups)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	if username == "" {
+		return "", nil, fmt.Errorf("%w: the transformed username is empty", ErrLoginDenied)
+	}
+	return username, groups, nil
+}
+
+func newStep(t Transform) (step, error) {
+	target := t.Target
+	if target == "" {
+		target = TargetUsername
+	}
+	if target != TargetUsername && target != TargetGroups {
+		return nil, fmt.Errorf("unknown target %q", target)
+	}
+
+	var re *regexp.Regexp
+	switch t.Type {
+	case TypeRegexReplace, TypeAllowGroups, TypeDenyGroups, TypeDenyLogin:
+		if t.Regex == "" {
+			return nil, constable.Error("regex must not be empty")
+		}
+		var err error
+		if re, err = regexp.Compile(t.Regex); err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
+		}
+	}
+
+	switch t.Type {
+	case TypePrefix:
+		if t.Prefix == "" {
+			return nil, constable.Error("prefix must not be empty")
+		}
+		return mapTarget(target, func(s string) string { return t.Prefix + s }), nil
+	case TypeRegexReplace:
+		return mapTarget(target, func(s string) string { return re.ReplaceAllString(s, t.Replacement) }), nil
+	case TypeLowercase:
+		return mapTarget(target, strings.ToLower), nil
+	case TypeAllowGroups:
+		return filterGroups(func(group string) bool { return re.MatchString(group) }), nil
+	case TypeDenyGroups:
+		return filterGroups(func(group string) bool { return !re.MatchString(group) }), nil
+	case TypeDenyLogin:
+		return func(username string, groups []string) (string, []string, error) {
+			if target == TargetUsername && re.MatchString(username) {
+				return "", nil, fmt.Errorf("%w: username %q matches %q", ErrLoginDenied, username, t.Regex)
+			}
+			if target == TargetGroups {
+				for _, group := range groups {
+					if re.MatchString(group) {
+						return "", nil, fmt.Errorf("%w: group %q matches %q", ErrLoginDenied, group, t.Regex)
+					}
+				}
+			}
+			return username, groups, nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", t.Type)
+	}
+}
+
+func mapTarget(target Target, f func(string) string) step {
+	return func(username string, groups []string) (string, []string, error) {
+		if target == TargetUsername {
+			return f(username), groups, nil
+		}
+		for i := range groups {
+			groups[i] = f(groups[i])
+		}
+		return username, groups, nil
+	}
+}
+
+func filterGroups(keep func(group string) bool) step {
+	return func(username string, groups []string) (string, []string, error) {
+		kept := groups[:0]
+		for _, group := range groups {
+			if keep(group) {
+				kept = append(kept, group)
+			}
+		}
+		return username, kept, nil
+	}
+}
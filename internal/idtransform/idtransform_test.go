@@ -0,0 +1,209 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package idtransform
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPipeline(t *testing.T) {
+	tests := []struct {
+		name       string
+		transforms []Transform
+		wantErr    string
+	}{
+		{
+			name: "no transforms",
+		},
+		{
+			name: "valid transforms",
+			transforms: []Transform{
+				{Type: TypePrefix, Prefix: "a:"},
+				{Type: TypePrefix, Target: TargetGroups, Prefix: "a:"},
+				{Type: TypeRegexReplace, Regex: "@.*$", Replacement: ""},
+				{Type: TypeLowercase, Target: TargetGroups},
+				{Type: TypeAllowGroups, Regex: "^admins$"},
+				{Type: TypeDenyGroups, Regex: "^interns$"},
+				{Type: TypeDenyLogin, Target: TargetGroups, Regex: "^banned$"},
+			},
+		},
+		{
+			name:       "unknown type",
+			transforms: []Transform{{Type: "uppercase"}},
+			wantErr:    `transform 0 (uppercase): unknown type "uppercase"`,
+		},
+		{
+			name:       "unknown target",
+			transforms: []Transform{{Type: TypeLowercase}, {Type: TypeLowercase, Target: "email"}},
+			wantErr:    `transform 1 (lowercase): unknown target "email"`,
+		},
+		{
+			name:       "empty prefix",
+			transforms: []Transform{{Type: TypePrefix}},
+			wantErr:    `transform 0 (prefix): prefix must not be empty`,
+		},
+		{
+			name:       "empty regex",
+			transforms: []Transform{{Type: TypeDenyLogin}},
+			wantErr:    `transform 0 (denyLogin): regex must not be empty`,
+		},
+		{
+			name:       "invalid regex",
+			transforms: []Transform{{Type: TypeRegexReplace, Regex: "("}},
+			wantErr:    "transform 0 (regexReplace): invalid regex: error parsing regexp: missing closing ): `(`",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPipeline(tt.transforms)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				require.Nil(t, p)
+				return
+			}
+			require.NoError(t, err)
+			require.NotNil(t, p)
+		})
+	}
+}
+
+func TestPipelineEvaluate(t *testing.T) {
+	tests := []struct {
+		name         string
+		transforms   []Transform
+		username     string
+		groups       []string
+		wantUsername string
+		wantGroups   []string
+		wantErr      string
+	}{
+		{
+			name:         "no transforms",
+			username:     "Admin",
+			groups:       []string{"A", "B"},
+			wantUsername: "Admin",
+			wantGroups:   []string{"A", "B"},
+		},
+		{
+			name: "prefix",
+			transforms: []Transform{
+				{Type: TypePrefix, Prefix: "idp1:"},
+				{Type: TypePrefix, Target: TargetGroups, Prefix: "idp1:"},
+			},
+			username:     "admin",
+			groups:       []string{"a", "b"},
+			wantUsername: "idp1:admin",
+			wantGroups:   []string{"idp1:a", "idp1:b"},
+		},
+		{
+			name: "regex replace",
+			transforms: []Transform{
+				{Type: TypeRegexReplace, Regex: "^(.*)@example\\.com$", Replacement: "${1}"},
+				{Type: TypeRegexReplace, Target: TargetGroups, Regex: "^cn=([^,]+),.*$", Replacement: "$1"},
+			},
+			username:     "pinny@example.com",
+			groups:       []string{"cn=a,ou=groups", "b"},
+			wantUsername: "pinny",
+			wantGroups:   []string{"a", "b"},
+		},
+		{
+			name: "lowercase",
+			transforms: []Transform{
+				{Type: TypeLowercase},
+				{Type: TypeLowercase, Target: TargetGroups},
+			},
+			username:     "Pinny",
+			groups:       []string{"Admins", "devs"},
+			wantUsername: "pinny",
+			wantGroups:   []string{"admins", "devs"},
+		},
+		{
+			name: "allow and deny groups",
+			transforms: []Transform{
+				{Type: TypeAllowGroups, Regex: "^k8s-"},
+				{Type: TypeDenyGroups, Regex: "-interns$"},
+			},
+			username:     "pinny",
+			groups:       []string{"k8s-admins", "k8s-interns", "mail-users"},
+			wantUsername: "pinny",
+			wantGroups:   []string{"k8s-admins"},
+		},
+		{
+			name:         "allow groups when no groups match",
+			transforms:   []Transform{{Type: TypeAllowGroups, Regex: "^k8s-"}},
+			username:     "pinny",
+			groups:       []string{"mail-users"},
+			wantUsername: "pinny",
+			wantGroups:   []string{},
+		},
+		{
+			name:       "deny login by username",
+			transforms: []Transform{{Type: TypeDenyLogin, Regex: "^(admin|root)$"}},
+			username:   "admin",
+			groups:     []string{"a"},
+			wantErr:    `login denied by identity transform: username "admin" matches "^(admin|root)$"`,
+		},
+		{
+			name:       "deny login by group",
+			transforms: []Transform{{Type: TypeDenyLogin, Target: TargetGroups, Regex: "^banned$"}},
+			username:   "pinny",
+			groups:     []string{"a", "banned"},
+			wantErr:    `login denied by identity transform: group "banned" matches "^banned$"`,
+		},
+		{
+			name: "deny login sees the result of earlier transforms",
+			transforms: []Transform{
+				{Type: TypeLowercase},
+				{Type: TypeDenyLogin, Regex: "^admin$"},
+			},
+			username: "ADMIN",
+			wantErr:  `login denied by identity transform: username "admin" matches "^admin$"`,
+		},
+		{
+			name:         "deny login does not match",
+			transforms:   []Transform{{Type: TypeDenyLogin, Target: TargetGroups, Regex: "^banned$"}},
+			username:     "pinny",
+			groups:       []string{"a"},
+			wantUsername: "pinny",
+			wantGroups:   []string{"a"},
+		},
+		{
+			name:       "empty username after transforms",
+			transforms: []Transform{{Type: TypeRegexReplace, Regex: ".*", Replacement: ""}},
+			username:   "pinny",
+			wantErr:    "login denied by identity transform: the transformed username is empty",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPipeline(tt.transforms)
+			require.NoError(t, err)
+
+			originalGroups := append([]string(nil), tt.groups...)
+			username, groups, err := p.Evaluate(tt.username, tt.groups)
+			require.Equal(t, originalGroups, tt.groups, "the caller's groups should not be modified")
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				require.True(t, errors.Is(err, ErrLoginDenied))
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantUsername, username)
+			require.Equal(t, tt.wantGroups, groups)
+		})
+	}
+}
+
+func TestNilPipeline(t *testing.T) {
+	var p *Pipeline
+	username, groups, err := p.Evaluate("pinny", []string{"a"})
+	require.NoError(t, err)
+	require.Equal(t, "pinny", username)
+	require.Equal(t, []string{"a"}, groups)
+}
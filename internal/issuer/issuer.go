@@ -13,11 +13,17 @@ import (
 	"go.pinniped.dev/internal/constable"
 )
 
-const defaultCertIssuerErr = constable.Error("failed to issue cert")
+const (
+	defaultCertIssuerErr = constable.Error("failed to issue cert")
+
+	// ErrExtraNotSupported is returned by ClientCertIssuers whose certificates are used directly with the Kubernetes
+	// API server, which does not read the extra of the identity from client certificates.
+	ErrExtraNotSupported = constable.Error("cannot issue certificates for identities with extra")
+)
 
 type ClientCertIssuer interface {
 	Name() string
-	IssueClientCertPEM(username string, groups []string, ttl time.Duration) (certPEM, keyPEM []byte, err error)
+	IssueClientCertPEM(username string, groups []string, extra map[string][]string, ttl time.Duration) (certPEM, keyPEM []byte, err error)
 }
 
 var _ ClientCertIssuer = ClientCertIssuers{}
@@ -37,11 +43,11 @@ func (c ClientCertIssuers) Name() string {
 	return strings.Join(names, ",")
 }
 
-func (c ClientCertIssuers) IssueClientCertPEM(username string, groups []string, ttl time.Duration) ([]byte, []byte, error) {
+func (c ClientCertIssuers) IssueClientCertPEM(username string, groups []string, extra map[string][]string, ttl time.Duration) ([]byte, []byte, error) {
 	var errs []error
 
 	for _, issuer := range c {
-		certPEM, keyPEM, err := issuer.IssueClientCertPEM(username, groups, ttl)
+		certPEM, keyPEM, err := issuer.IssueClientCertPEM(username, groups, extra, ttl)
 		if err != nil {
 			errs = append(errs, fmt.Errorf("%s failed to issue client cert: %w", issuer.Name(), err))
 			continue
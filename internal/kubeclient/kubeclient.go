@@ -17,6 +17,7 @@ import (
 	pinnipedconciergeclientsetscheme "go.pinniped.dev/generated/latest/client/concierge/clientset/versioned/scheme"
 	pinnipedsupervisorclientset "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned"
 	pinnipedsupervisorclientsetscheme "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/scheme"
+	"go.pinniped.dev/internal/tracing"
 )
 
 type Client struct {
@@ -44,6 +45,10 @@ func New(opts ...Option) (*Client, error) {
 		WithConfig(inClusterConfig)(c) // make sure all writes to clientConfig flow through one code path
 	}
 
+	// trace the requests which are made on behalf of a traced request, e.g. writes to session storage during a login
+	c.config = restclient.CopyConfig(c.config)
+	c.config.Wrap(tracing.WrapTransport)
+
 	// explicitly use json when talking to CRD APIs
 	jsonKubeConfig := createJSONKubeConfig(c.config)
 
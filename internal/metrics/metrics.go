@@ -0,0 +1,193 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package metrics defines the Prometheus metrics which are exported by the Supervisor and the Concierge.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+
+	// Export the depth, latency and retries of the queues of all controllerlib controllers.
+	_ "k8s.io/component-base/metrics/prometheus/workqueue"
+
+	"go.pinniped.dev/internal/httputil/responsewriter"
+)
+
+const namespace = "pinniped"
+
+// The results which are recorded by the metrics of this package.
+const (
+	// ResultSuccess means that the request was allowed.
+	ResultSuccess = "success"
+
+	// ResultFailure means that the request was denied, e.g. because of a wrong password.
+	ResultFailure = "failure"
+
+	// ResultError means that the request could not be handled, e.g. because an upstream server was unavailable.
+	ResultError = "error"
+)
+
+// LabelValueUnknown is recorded instead of a label value which was taken from a request but which does not match
+// anything that is configured, so that clients cannot create an unbounded number of time series.
+const LabelValueUnknown = "unknown"
+
+//nolint: gochecknoglobals // metrics are registered once per process, so they are globals like in the rest of Kubernetes
+var (
+	registerOnce sync.Once
+
+	loginAttempts = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      namespace,
+			Subsystem:      "supervisor",
+			Name:           "login_attempts_total",
+			Help:           "Number of attempts to log in to a FederationDomain, by upstream identity provider and result.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"federation_domain", "idp_name", "idp_type", "result"},
+	)
+
+	tokenGrants = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      namespace,
+			Subsystem:      "supervisor",
+			Name:           "token_grants_total",
+			Help:           "Number of requests to the token endpoint of a FederationDomain, by grant type and result.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"federation_domain", "grant_type", "result"},
+	)
+
+	garbageCollectedSessions = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      namespace,
+			Subsystem:      "supervisor",
+			Name:           "session_storage_garbage_collected_total",
+			Help:           "Number of expired session storage entries which were deleted by the garbage collector, by storage type.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"storage_type"},
+	)
+
+	tokenCredentialRequests = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      namespace,
+			Subsystem:      "concierge",
+			Name:           "token_credential_requests_total",
+			Help:           "Number of TokenCredentialRequests, by authenticator and result.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"authenticator_kind", "authenticator_name", "result"},
+	)
+
+	impersonationProxyRequests = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      namespace,
+			Subsystem:      "concierge",
+			Name:           "impersonation_proxy_requests_total",
+			Help:           "Number of requests to the impersonation proxy, by HTTP method and response code.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"method", "code"},
+	)
+
+	impersonationProxyRequestDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Namespace:      namespace,
+			Subsystem:      "concierge",
+			Name:           "impersonation_proxy_request_duration_seconds",
+			Help:           "Latency of the requests to the impersonation proxy, by HTTP method.",
+			Buckets:        metrics.ExponentialBuckets(0.005, 2, 15),
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"method"},
+	)
+
+	controllerSyncErrors = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      namespace,
+			Name:           "controller_sync_errors_total",
+			Help:           "Number of times that the sync of a controller returned an error, by controller.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"controller"},
+	)
+)
+
+// Register registers all metrics of this package with the global registry, which is served by Handler().
+// Metrics are not recorded until they are registered. It is safe to call Register more than once.
+func Register() {
+	registerOnce.Do(func() {
+		legacyregistry.MustRegister(
+			loginAttempts,
+			tokenGrants,
+			garbageCollectedSessions,
+			tokenCredentialRequests,
+			impersonationProxyRequests,
+			impersonationProxyRequestDuration,
+			controllerSyncErrors,
+		)
+	})
+}
+
+// Handler returns an http.Handler which serves all registered metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return legacyregistry.Handler()
+}
+
+// RecordLogin records the result of an attempt to log in to a FederationDomain using an upstream identity provider.
+func RecordLogin(federationDomain, idpName, idpType, result string) {
+	loginAttempts.WithLabelValues(federationDomain, idpName, idpType, result).Inc()
+}
+
+// RecordTokenGrant records the result of a request to the token endpoint of a FederationDomain.
+func RecordTokenGrant(federationDomain, grantType, result string) {
+	tokenGrants.WithLabelValues(federationDomain, grantType, result).Inc()
+}
+
+// RecordGarbageCollectedSession records that the garbage collector deleted a session storage entry of the given type.
+func RecordGarbageCollectedSession(storageType string) {
+	garbageCollectedSessions.WithLabelValues(storageType).Inc()
+}
+
+// RecordTokenCredentialRequest records the result of a TokenCredentialRequest which used the given authenticator.
+func RecordTokenCredentialRequest(authenticatorKind, authenticatorName, result string) {
+	tokenCredentialRequests.WithLabelValues(authenticatorKind, authenticatorName, result).Inc()
+}
+
+// RecordControllerSyncError records that the sync of the named controller returned an error.
+func RecordControllerSyncError(controller string) {
+	controllerSyncErrors.WithLabelValues(controller).Inc()
+}
+
+// InstrumentImpersonationProxy wraps the handler of the impersonation proxy to record the number and latency of its
+// requests.
+func InstrumentImpersonationProxy(delegate http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := responsewriter.NewStatusRecorder(w)
+		defer func() {
+			method := methodLabelValue(r.Method)
+			impersonationProxyRequests.WithLabelValues(method, strconv.Itoa(rw.StatusCode())).Inc()
+			impersonationProxyRequestDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+		}()
+		delegate.ServeHTTP(rw, r)
+	})
+}
+
+// methodLabelValue returns the given HTTP method, or LabelValueUnknown when it is not one of the standard methods,
+// since any client of the impersonation proxy may send an arbitrary method.
+func methodLabelValue(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return LabelValueUnknown
+	}
+}
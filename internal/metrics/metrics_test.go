@@ -0,0 +1,149 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestRecordFuncs(t *testing.T) {
+	Register()
+	Register() // registering more than once is allowed
+
+	tests := []struct {
+		name    string
+		record  func()
+		counter metrics.CounterMetric
+	}{
+		{
+			name:    "login",
+			record:  func() { RecordLogin("https://issuer.example.com", "some-idp", "ldap", ResultFailure) },
+			counter: loginAttempts.WithLabelValues("https://issuer.example.com", "some-idp", "ldap", ResultFailure),
+		},
+		{
+			name:    "token grant",
+			record:  func() { RecordTokenGrant("https://issuer.example.com", "refresh_token", ResultSuccess) },
+			counter: tokenGrants.WithLabelValues("https://issuer.example.com", "refresh_token", ResultSuccess),
+		},
+		{
+			name:    "garbage collected session",
+			record:  func() { RecordGarbageCollectedSession("access-token") },
+			counter: garbageCollectedSessions.WithLabelValues("access-token"),
+		},
+		{
+			name:    "token credential request",
+			record:  func() { RecordTokenCredentialRequest("WebhookAuthenticator", "some-authenticator", ResultError) },
+			counter: tokenCredentialRequests.WithLabelValues("WebhookAuthenticator", "some-authenticator", ResultError),
+		},
+		{
+			name:    "controller sync error",
+			record:  func() { RecordControllerSyncError("some-controller") },
+			counter: controllerSyncErrors.WithLabelValues("some-controller"),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			before, err := testutil.GetCounterMetricValue(tt.counter)
+			require.NoError(t, err)
+
+			tt.record()
+			tt.record()
+
+			after, err := testutil.GetCounterMetricValue(tt.counter)
+			require.NoError(t, err)
+			require.Equal(t, before+2, after)
+		})
+	}
+}
+
+func TestInstrumentImpersonationProxy(t *testing.T) {
+	Register()
+
+	tests := []struct {
+		name       string
+		method     string
+		handler    http.HandlerFunc
+		wantMethod string
+		wantCode   string
+	}{
+		{
+			name:     "explicit response code",
+			method:   http.MethodDelete,
+			handler:  func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusForbidden) },
+			wantCode: "403",
+		},
+		{
+			name:   "implicit response code",
+			method: http.MethodGet,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("some body"))
+				w.(http.Flusher).Flush()
+			},
+			wantCode: "200",
+		},
+		{
+			name:   "no response",
+			method: http.MethodPut,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _, err := w.(http.Hijacker).Hijack()
+				require.EqualError(t, err, "the response writer does not support hijacking connections")
+			},
+			wantCode: "200",
+		},
+		{
+			name:       "non-standard method",
+			method:     "SOME-METHOD",
+			handler:    func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusMethodNotAllowed) },
+			wantMethod: LabelValueUnknown,
+			wantCode:   "405",
+		},
+		{
+			name:       "standard method in lower case",
+			method:     "get",
+			handler:    func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusMethodNotAllowed) },
+			wantMethod: LabelValueUnknown,
+			wantCode:   "405",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			wantMethod := tt.wantMethod
+			if wantMethod == "" {
+				wantMethod = tt.method
+			}
+			counter := impersonationProxyRequests.WithLabelValues(wantMethod, tt.wantCode)
+			before, err := testutil.GetCounterMetricValue(counter)
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			InstrumentImpersonationProxy(tt.handler).ServeHTTP(rec, httptest.NewRequest(tt.method, "/api/v1/namespaces", nil))
+
+			after, err := testutil.GetCounterMetricValue(counter)
+			require.NoError(t, err)
+			require.Equal(t, before+1, after)
+
+			_, err = testutil.GetHistogramMetricValue(impersonationProxyRequestDuration.WithLabelValues(wantMethod))
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	Register()
+	RecordControllerSyncError("some-controller")
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `pinniped_controller_sync_errors_total{controller="some-controller"}`)
+}
@@ -11,6 +11,7 @@ package credentialrequestmocks
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	login "go.pinniped.dev/generated/latest/apis/concierge/login"
@@ -54,3 +55,17 @@ func (mr *MockTokenCredentialRequestAuthenticatorMockRecorder) AuthenticateToken
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuthenticateTokenCredentialRequest", reflect.TypeOf((*MockTokenCredentialRequestAuthenticator)(nil).AuthenticateTokenCredentialRequest), arg0, arg1)
 }
+
+// ClientCertificateTTL mocks base method.
+func (m *MockTokenCredentialRequestAuthenticator) ClientCertificateTTL(arg0 *login.TokenCredentialRequest, arg1 time.Duration) time.Duration {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClientCertificateTTL", arg0, arg1)
+	ret0, _ := ret[0].(time.Duration)
+	return ret0
+}
+
+// ClientCertificateTTL indicates an expected call of ClientCertificateTTL.
+func (mr *MockTokenCredentialRequestAuthenticatorMockRecorder) ClientCertificateTTL(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClientCertificateTTL", reflect.TypeOf((*MockTokenCredentialRequestAuthenticator)(nil).ClientCertificateTTL), arg0, arg1)
+}
@@ -97,6 +97,20 @@ func (mr *MockSecretHelperMockRecorder) NamePrefix() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NamePrefix", reflect.TypeOf((*MockSecretHelper)(nil).NamePrefix))
 }
 
+// NeedsRotation mocks base method.
+func (m *MockSecretHelper) NeedsRotation(arg0 *v1.Secret) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NeedsRotation", arg0)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// NeedsRotation indicates an expected call of NeedsRotation.
+func (mr *MockSecretHelperMockRecorder) NeedsRotation(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NeedsRotation", reflect.TypeOf((*MockSecretHelper)(nil).NeedsRotation), arg0)
+}
+
 // ObserveActiveSecretAndUpdateParentFederationDomain mocks base method.
 func (m *MockSecretHelper) ObserveActiveSecretAndUpdateParentFederationDomain(arg0 *v1alpha1.FederationDomain, arg1 *v1.Secret) *v1alpha1.FederationDomain {
 	m.ctrl.T.Helper()
@@ -110,3 +124,18 @@ func (mr *MockSecretHelperMockRecorder) ObserveActiveSecretAndUpdateParentFedera
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ObserveActiveSecretAndUpdateParentFederationDomain", reflect.TypeOf((*MockSecretHelper)(nil).ObserveActiveSecretAndUpdateParentFederationDomain), arg0, arg1)
 }
+
+// Rotate mocks base method.
+func (m *MockSecretHelper) Rotate(arg0, arg1 *v1.Secret) (*v1.Secret, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Rotate", arg0, arg1)
+	ret0, _ := ret[0].(*v1.Secret)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Rotate indicates an expected call of Rotate.
+func (mr *MockSecretHelperMockRecorder) Rotate(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Rotate", reflect.TypeOf((*MockSecretHelper)(nil).Rotate), arg0, arg1)
+}
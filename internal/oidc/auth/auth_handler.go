@@ -5,8 +5,10 @@
 package auth
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 
 	coreosoidc "github.com/coreos/go-oidc/v3/oidc"
@@ -15,15 +17,22 @@ import (
 	"github.com/ory/fosite/token/jwt"
 	"github.com/pkg/errors"
 	"golang.org/x/oauth2"
-	"k8s.io/apiserver/pkg/authentication/authenticator"
 
+	"go.pinniped.dev/internal/auditlog"
+	"go.pinniped.dev/internal/fositestorage/devicecode"
 	"go.pinniped.dev/internal/httputil/httperr"
 	"go.pinniped.dev/internal/httputil/securityheader"
+	"go.pinniped.dev/internal/metrics"
 	"go.pinniped.dev/internal/oidc"
 	"go.pinniped.dev/internal/oidc/csrftoken"
 	"go.pinniped.dev/internal/oidc/downstreamsession"
+	"go.pinniped.dev/internal/oidc/idpdiscovery"
 	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/oidc/provider/chooseidphtml"
+	"go.pinniped.dev/internal/oidc/provider/deviceverificationhtml"
+	"go.pinniped.dev/internal/oidc/provider/samlposthtml"
 	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/psession"
 	"go.pinniped.dev/pkg/oidcclient/nonce"
 	"go.pinniped.dev/pkg/oidcclient/pkce"
 )
@@ -38,6 +47,7 @@ func NewHandler(
 	idpLister oidc.UpstreamIdentityProvidersLister,
 	oauthHelperWithoutStorage fosite.OAuth2Provider,
 	oauthHelperWithStorage fosite.OAuth2Provider,
+	deviceCodeStorage devicecode.DeviceCodeStorage,
 	generateCSRF func() (csrftoken.CSRFToken, error),
 	generatePKCE func() (pkce.Code, error),
 	generateNonce func() (nonce.Nonce, error),
@@ -52,25 +62,76 @@ func NewHandler(
 			return httperr.Newf(http.StatusMethodNotAllowed, "%s (try GET or POST)", r.Method)
 		}
 
-		oidcUpstream, ldapUpstream, err := chooseUpstreamIDP(idpLister)
+		oidcUpstream, ldapUpstream, samlUpstream, upstreamType, err := chooseUpstreamIDP(
+			r.FormValue(oidc.AuthorizeUpstreamIDPNameParamName),
+			r.FormValue(oidc.AuthorizeUpstreamIDPTypeParamName),
+			idpLister,
+		)
 		if err != nil {
 			plog.WarningErr("authorize upstream config", err)
 			return err
 		}
 
+		if deviceUserCode := r.FormValue(oidc.AuthorizeDeviceUserCodeParamName); deviceUserCode != "" {
+			// The device verification endpoint sent the end user here to log in on behalf of a device.
+			return handleAuthRequestForDevice(r, w,
+				deviceUserCode,
+				deviceCodeStorage,
+				generateCSRF, generateNonce, generatePKCE,
+				oidcUpstream, ldapUpstream, samlUpstream, upstreamType,
+				idpLister,
+				downstreamIssuer,
+				upstreamStateEncoder,
+				cookieCodec,
+			)
+		}
+
+		if oidcUpstream == nil && ldapUpstream == nil && samlUpstream == nil {
+			// There are several upstreams and the client did not pick one, so let the end user choose.
+			return handleAuthRequestByShowingIDPChooser(r, w,
+				oauthHelperWithoutStorage,
+				idpLister,
+				downstreamIssuer,
+			)
+		}
+
 		if oidcUpstream != nil {
 			return handleAuthRequestForOIDCUpstream(r, w,
 				oauthHelperWithoutStorage,
 				generateCSRF, generateNonce, generatePKCE,
-				oidcUpstream,
+				oidcUpstream, upstreamType,
 				downstreamIssuer,
 				upstreamStateEncoder,
 				cookieCodec,
 			)
 		}
-		return handleAuthRequestForLDAPUpstream(r, w,
-			oauthHelperWithStorage,
-			ldapUpstream,
+		if samlUpstream != nil {
+			return handleAuthRequestForSAMLUpstream(r, w,
+				oauthHelperWithoutStorage,
+				generateCSRF, generateNonce, generatePKCE,
+				samlUpstream,
+				downstreamIssuer,
+				upstreamStateEncoder,
+				cookieCodec,
+			)
+		}
+		if len(r.Header.Values(CustomUsernameHeaderName)) > 0 || len(r.Header.Values(CustomPasswordHeaderName)) > 0 {
+			// The client sent the username and password on the custom headers, so there is no web browser involved.
+			return handleAuthRequestForLDAPUpstream(r, w,
+				oauthHelperWithStorage,
+				idpLister,
+				ldapUpstream,
+				upstreamType,
+				downstreamIssuer,
+			)
+		}
+		return handleAuthRequestForLDAPUpstreamBrowserFlow(r, w,
+			oauthHelperWithoutStorage,
+			generateCSRF, generateNonce, generatePKCE,
+			ldapUpstream, upstreamType,
+			downstreamIssuer,
+			upstreamStateEncoder,
+			cookieCodec,
 		)
 	}))
 }
@@ -79,19 +140,41 @@ func handleAuthRequestForLDAPUpstream(
 	r *http.Request,
 	w http.ResponseWriter,
 	oauthHelper fosite.OAuth2Provider,
+	transformsLister oidc.IdentityTransformsLister,
 	ldapUpstream provider.UpstreamLDAPIdentityProviderI,
+	ldapUpstreamType psession.ProviderType,
+	downstreamIssuer string,
 ) error {
 	authorizeRequester, created := newAuthorizeRequest(r, w, oauthHelper)
 	if !created {
 		return nil
 	}
 
+	recordLogin := func(result string) {
+		metrics.RecordLogin(downstreamIssuer, ldapUpstream.GetName(), string(ldapUpstreamType), result)
+	}
+	recordAuditEvent := func(eventType auditlog.EventType, outcome auditlog.Outcome, reason string, user *auditlog.User) {
+		auditlog.Record(auditlog.Event{
+			Type:                     eventType,
+			Outcome:                  outcome,
+			Reason:                   reason,
+			SourceIP:                 auditlog.SourceIP(r),
+			ForwardedFor:             auditlog.ForwardedFor(r),
+			FederationDomain:         downstreamIssuer,
+			ClientID:                 authorizeRequester.GetClient().GetID(),
+			UpstreamIdentityProvider: &auditlog.IdentityProvider{Name: ldapUpstream.GetName(), Type: string(ldapUpstreamType)},
+			User:                     user,
+		})
+	}
+
 	username := r.Header.Get(CustomUsernameHeaderName)
 	password := r.Header.Get(CustomPasswordHeaderName)
 	if username == "" || password == "" {
 		// Return an error according to OIDC spec 3.1.2.6 (second paragraph).
 		err := errors.WithStack(fosite.ErrAccessDenied.WithHintf("Missing or blank username or password."))
 		plog.Info("authorize response error", oidc.FositeErrorForLog(err)...)
+		recordLogin(metrics.ResultFailure)
+		recordAuditEvent(auditlog.EventTypeSupervisorAuthorize, auditlog.OutcomeFailure, "missing or blank username or password", nil)
 		oauthHelper.WriteAuthorizeError(w, authorizeRequester, err)
 		return nil
 	}
@@ -99,6 +182,9 @@ func handleAuthRequestForLDAPUpstream(
 	authenticateResponse, authenticated, err := ldapUpstream.AuthenticateUser(r.Context(), username, password)
 	if err != nil {
 		plog.WarningErr("unexpected error during upstream LDAP authentication", err, "upstreamName", ldapUpstream.GetName())
+		recordLogin(metrics.ResultError)
+		recordAuditEvent(auditlog.EventTypeSupervisorLDAPBind, auditlog.OutcomeError, err.Error(), &auditlog.User{Username: username})
+		recordAuditEvent(auditlog.EventTypeSupervisorAuthorize, auditlog.OutcomeError, "unexpected error during upstream authentication", nil)
 		return httperr.New(http.StatusBadGateway, "unexpected error during upstream authentication")
 	}
 	if !authenticated {
@@ -106,28 +192,127 @@ func handleAuthRequestForLDAPUpstream(
 		// Return an error according to OIDC spec 3.1.2.6 (second paragraph).
 		err = errors.WithStack(fosite.ErrAccessDenied.WithHintf("Username/password not accepted by LDAP provider."))
 		plog.Info("authorize response error", oidc.FositeErrorForLog(err)...)
+		recordLogin(metrics.ResultFailure)
+		recordAuditEvent(auditlog.EventTypeSupervisorLDAPBind, auditlog.OutcomeFailure, "username/password not accepted by LDAP provider", &auditlog.User{Username: username})
+		recordAuditEvent(auditlog.EventTypeSupervisorAuthorize, auditlog.OutcomeFailure, "username/password not accepted by LDAP provider", nil)
 		oauthHelper.WriteAuthorizeError(w, authorizeRequester, err)
 		return nil
 	}
+	recordAuditEvent(auditlog.EventTypeSupervisorLDAPBind, auditlog.OutcomeSuccess, "", &auditlog.User{
+		Username: authenticateResponse.User.GetName(),
+		Groups:   authenticateResponse.User.GetGroups(),
+	})
+
+	transforms := transformsLister.GetIdentityTransforms(ldapUpstream.GetName(), string(ldapUpstreamType))
+	downstreamUsername, downstreamGroups, err := transforms.Evaluate(authenticateResponse.User.GetName(), authenticateResponse.User.GetGroups())
+	if err != nil {
+		plog.Info("identity transforms denied the login", "upstreamName", ldapUpstream.GetName(), "reason", err.Error())
+		recordAuditEvent(auditlog.EventTypeSupervisorAuthorize, auditlog.OutcomeFailure, err.Error(), &auditlog.User{
+			Username: authenticateResponse.User.GetName(),
+			Groups:   authenticateResponse.User.GetGroups(),
+		})
+		err = errors.WithStack(fosite.ErrAccessDenied.WithHintf("Login denied by identity transforms."))
+		plog.Info("authorize response error", oidc.FositeErrorForLog(err)...)
+		recordLogin(metrics.ResultFailure)
+		oauthHelper.WriteAuthorizeError(w, authorizeRequester, err)
+		return nil
+	}
+
+	customSessionData := &psession.CustomSessionData{
+		ProviderName: ldapUpstream.GetName(),
+		ProviderType: ldapUpstreamType,
+	}
+	// Remember the username which was used to find the user, so the search can be repeated during refresh.
+	if ldapUpstreamType == psession.ProviderTypeActiveDirectory {
+		customSessionData.ActiveDirectory = &psession.ActiveDirectorySessionData{Username: username}
+	} else {
+		customSessionData.LDAP = &psession.LDAPSessionData{Username: username}
+	}
 
 	openIDSession := downstreamsession.MakeDownstreamSession(
-		downstreamSubjectFromUpstreamLDAP(ldapUpstream, authenticateResponse),
-		authenticateResponse.User.GetName(),
-		authenticateResponse.User.GetGroups(),
+		downstreamsession.DownstreamSubjectFromUpstreamLDAP(ldapUpstream, authenticateResponse),
+		downstreamUsername,
+		downstreamGroups,
+		customSessionData,
 	)
 
 	authorizeResponder, err := oauthHelper.NewAuthorizeResponse(r.Context(), authorizeRequester, openIDSession)
 	if err != nil {
 		plog.Info("authorize response error", oidc.FositeErrorForLog(err)...)
+		recordLogin(metrics.ResultError)
+		recordAuditEvent(auditlog.EventTypeSupervisorAuthorize, auditlog.OutcomeError, err.Error(), nil)
 		oauthHelper.WriteAuthorizeError(w, authorizeRequester, err)
 		return nil
 	}
 
+	recordLogin(metrics.ResultSuccess)
+	recordAuditEvent(auditlog.EventTypeSupervisorAuthorize, auditlog.OutcomeSuccess, "", &auditlog.User{Username: downstreamUsername, Groups: downstreamGroups})
 	oauthHelper.WriteAuthorizeResponse(w, authorizeRequester, authorizeResponder)
 
 	return nil
 }
 
+func handleAuthRequestForLDAPUpstreamBrowserFlow(
+	r *http.Request,
+	w http.ResponseWriter,
+	oauthHelper fosite.OAuth2Provider,
+	generateCSRF func() (csrftoken.CSRFToken, error),
+	generateNonce func() (nonce.Nonce, error),
+	generatePKCE func() (pkce.Code, error),
+	ldapUpstream provider.UpstreamLDAPIdentityProviderI,
+	ldapUpstreamType psession.ProviderType,
+	downstreamIssuer string,
+	upstreamStateEncoder oidc.Encoder,
+	cookieCodec oidc.Codec,
+) error {
+	authorizeRequester, created := newAuthorizeRequestWithoutStorage(r, w, oauthHelper)
+	if !created {
+		return nil
+	}
+
+	err := redirectToLoginPage(r, w,
+		authorizeRequester.GetRequestForm().Encode(),
+		"",
+		generateCSRF, generateNonce, generatePKCE,
+		ldapUpstream, ldapUpstreamType,
+		downstreamIssuer,
+		upstreamStateEncoder,
+		cookieCodec,
+	)
+
+	// The login is not finished until the end user submits their username and password to the login endpoint.
+	recordRedirectAuditEvent(r, authorizeRequester, downstreamIssuer, ldapUpstream.GetName(), ldapUpstreamType, err)
+
+	return err
+}
+
+// recordRedirectAuditEvent records the authorize event of a browser-based login which sends the user elsewhere to
+// authenticate, i.e. to an upstream identity provider or to the login page. A successful outcome only means that the
+// user was redirected, and err is the error of the redirect, if any.
+func recordRedirectAuditEvent(
+	r *http.Request,
+	authorizeRequester fosite.AuthorizeRequester,
+	downstreamIssuer string,
+	upstreamName string,
+	upstreamType psession.ProviderType,
+	err error,
+) {
+	auditEvent := auditlog.Event{
+		Type:                     auditlog.EventTypeSupervisorAuthorize,
+		Outcome:                  auditlog.OutcomeSuccess,
+		SourceIP:                 auditlog.SourceIP(r),
+		ForwardedFor:             auditlog.ForwardedFor(r),
+		FederationDomain:         downstreamIssuer,
+		ClientID:                 authorizeRequester.GetClient().GetID(),
+		UpstreamIdentityProvider: &auditlog.IdentityProvider{Name: upstreamName, Type: string(upstreamType)},
+	}
+	if err != nil {
+		auditEvent.Outcome = auditlog.OutcomeError
+		auditEvent.Reason = err.Error()
+	}
+	auditlog.Record(auditEvent)
+}
+
 func handleAuthRequestForOIDCUpstream(
 	r *http.Request,
 	w http.ResponseWriter,
@@ -136,30 +321,226 @@ func handleAuthRequestForOIDCUpstream(
 	generateNonce func() (nonce.Nonce, error),
 	generatePKCE func() (pkce.Code, error),
 	oidcUpstream provider.UpstreamOIDCIdentityProviderI,
+	oidcUpstreamType psession.ProviderType,
 	downstreamIssuer string,
 	upstreamStateEncoder oidc.Encoder,
 	cookieCodec oidc.Codec,
 ) error {
-	authorizeRequester, created := newAuthorizeRequest(r, w, oauthHelper)
+	authorizeRequester, created := newAuthorizeRequestWithoutStorage(r, w, oauthHelper)
 	if !created {
 		return nil
 	}
 
-	now := time.Now()
-	_, err := oauthHelper.NewAuthorizeResponse(r.Context(), authorizeRequester, &openid.DefaultSession{
-		Claims: &jwt.IDTokenClaims{
-			// Temporary claim values to allow `NewAuthorizeResponse` to perform other OIDC validations.
-			Subject:     "none",
-			AuthTime:    now,
-			RequestedAt: now,
-		},
-	})
-	if err != nil {
-		plog.Info("authorize response error", oidc.FositeErrorForLog(err)...)
-		oauthHelper.WriteAuthorizeError(w, authorizeRequester, err)
+	promptParam := ""
+	if oidc.ScopeWasRequested(authorizeRequester, coreosoidc.ScopeOpenID) {
+		promptParam = r.Form.Get("prompt")
+	}
+
+	err := redirectToUpstreamOIDC(r, w,
+		authorizeRequester.GetRequestForm().Encode(),
+		"",
+		promptParam,
+		generateCSRF, generateNonce, generatePKCE,
+		oidcUpstream, oidcUpstreamType,
+		downstreamIssuer,
+		upstreamStateEncoder,
+		cookieCodec,
+	)
+
+	// The login is not finished until the upstream sends the user back to the callback endpoint.
+	recordRedirectAuditEvent(r, authorizeRequester, downstreamIssuer, oidcUpstream.GetName(), oidcUpstreamType, err)
+
+	return err
+}
+
+func handleAuthRequestForSAMLUpstream(
+	r *http.Request,
+	w http.ResponseWriter,
+	oauthHelper fosite.OAuth2Provider,
+	generateCSRF func() (csrftoken.CSRFToken, error),
+	generateNonce func() (nonce.Nonce, error),
+	generatePKCE func() (pkce.Code, error),
+	samlUpstream provider.UpstreamSAMLIdentityProviderI,
+	downstreamIssuer string,
+	upstreamStateEncoder oidc.Encoder,
+	cookieCodec oidc.Codec,
+) error {
+	authorizeRequester, created := newAuthorizeRequestWithoutStorage(r, w, oauthHelper)
+	if !created {
 		return nil
 	}
 
+	err := redirectToUpstreamSAML(r, w,
+		authorizeRequester.GetRequestForm().Encode(),
+		"",
+		generateCSRF, generateNonce, generatePKCE,
+		samlUpstream,
+		downstreamIssuer,
+		upstreamStateEncoder,
+		cookieCodec,
+	)
+
+	// The login is not finished until the upstream posts its response to the assertion consumer service.
+	recordRedirectAuditEvent(r, authorizeRequester, downstreamIssuer, samlUpstream.GetName(), psession.ProviderTypeSAML, err)
+
+	return err
+}
+
+func handleAuthRequestForDevice(
+	r *http.Request,
+	w http.ResponseWriter,
+	deviceUserCode string,
+	deviceCodeStorage devicecode.DeviceCodeStorage,
+	generateCSRF func() (csrftoken.CSRFToken, error),
+	generateNonce func() (nonce.Nonce, error),
+	generatePKCE func() (pkce.Code, error),
+	oidcUpstream provider.UpstreamOIDCIdentityProviderI,
+	ldapUpstream provider.UpstreamLDAPIdentityProviderI,
+	samlUpstream provider.UpstreamSAMLIdentityProviderI,
+	upstreamType psession.ProviderType,
+	idpLister oidc.UpstreamIdentityProvidersLister,
+	downstreamIssuer string,
+	upstreamStateEncoder oidc.Encoder,
+	cookieCodec oidc.Codec,
+) error {
+	if oidcUpstream == nil && ldapUpstream == nil && samlUpstream == nil {
+		// Let the end user choose an upstream IDP first. The chosen one will be shown on the confirmation page.
+		params := url.Values{}
+		params.Set(oidc.AuthorizeDeviceUserCodeParamName, deviceUserCode)
+		return showIDPChooser(w, idpLister, downstreamIssuer, params)
+	}
+
+	if r.Method == http.MethodGet {
+		// Anybody could have sent the end user a link to this page, so never start the login of a device until the
+		// end user has confirmed that the user code and the client are really those of their own device.
+		return showDeviceConfirmation(r, w, deviceUserCode, deviceCodeStorage, generateCSRF, downstreamIssuer, cookieCodec)
+	}
+
+	csrfFromCookie := readCSRFCookie(r, cookieCodec)
+	csrfFromForm := r.PostFormValue(oidc.AuthorizeDeviceCSRFParamName)
+	if csrfFromCookie == "" || subtle.ConstantTimeCompare([]byte(csrfFromCookie), []byte(csrfFromForm)) != 1 {
+		plog.Info("device login confirmation has an invalid CSRF token")
+		return httperr.New(http.StatusForbidden, "device login confirmation has an invalid CSRF token")
+	}
+
+	switch {
+	case oidcUpstream != nil:
+		// The device authorization request will be checked again and approved by the callback endpoint.
+		return redirectToUpstreamOIDC(r, w,
+			"",
+			deviceUserCode,
+			"",
+			generateCSRF, generateNonce, generatePKCE,
+			oidcUpstream, upstreamType,
+			downstreamIssuer,
+			upstreamStateEncoder,
+			cookieCodec,
+		)
+	case samlUpstream != nil:
+		return redirectToUpstreamSAML(r, w,
+			"",
+			deviceUserCode,
+			generateCSRF, generateNonce, generatePKCE,
+			samlUpstream,
+			downstreamIssuer,
+			upstreamStateEncoder,
+			cookieCodec,
+		)
+	default:
+		return redirectToLoginPage(r, w,
+			"",
+			deviceUserCode,
+			generateCSRF, generateNonce, generatePKCE,
+			ldapUpstream, upstreamType,
+			downstreamIssuer,
+			upstreamStateEncoder,
+			cookieCodec,
+		)
+	}
+}
+
+// showDeviceConfirmation asks the end user to confirm the login of the device which made the device authorization
+// request with the given user code. The confirmation is posted back to the authorize endpoint along with a CSRF
+// token, which must match the CSRF cookie before the upstream login is started.
+func showDeviceConfirmation(
+	r *http.Request,
+	w http.ResponseWriter,
+	deviceUserCode string,
+	deviceCodeStorage devicecode.DeviceCodeStorage,
+	generateCSRF func() (csrftoken.CSRFToken, error),
+	downstreamIssuer string,
+	cookieCodec oidc.Codec,
+) error {
+	_, session, err := deviceCodeStorage.GetDeviceCodeSessionByUserCode(r.Context(), deviceUserCode)
+	if errors.Is(err, fosite.ErrNotFound) {
+		plog.Info("device authorization request not found")
+		return httperr.New(http.StatusUnprocessableEntity, "device authorization request not found")
+	}
+	if err != nil {
+		plog.Error("error reading device authorization request", err)
+		return httperr.Wrap(http.StatusInternalServerError, "error reading device authorization request", err)
+	}
+	if session.Status != devicecode.StatusPending || time.Now().After(session.ExpiresAt) {
+		plog.Info("device authorization request has expired or was already used")
+		return httperr.New(http.StatusUnprocessableEntity, "device authorization request has expired or was already used")
+	}
+
+	csrfValue := readCSRFCookie(r, cookieCodec)
+	if csrfValue == "" {
+		// We did not receive an incoming CSRF cookie, so write a new one.
+		if csrfValue, err = generateCSRF(); err != nil {
+			return httperr.Wrap(http.StatusInternalServerError, "error generating CSRF token", err)
+		}
+		if err := addCSRFSetCookieHeader(w, csrfValue, cookieCodec); err != nil {
+			plog.Error("error setting CSRF cookie", err)
+			return err
+		}
+	}
+
+	hiddenParams := map[string]string{
+		oidc.AuthorizeDeviceUserCodeParamName: deviceUserCode,
+		oidc.AuthorizeDeviceCSRFParamName:     string(csrfValue),
+	}
+	for _, param := range []string{oidc.AuthorizeUpstreamIDPNameParamName, oidc.AuthorizeUpstreamIDPTypeParamName} {
+		if value := r.FormValue(param); value != "" {
+			hiddenParams[param] = value
+		}
+	}
+
+	// This page has its own inline CSS, so override the default CSP header which was already set by the wrapper.
+	w.Header().Set("Content-Security-Policy", deviceverificationhtml.ContentSecurityPolicy())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := deviceverificationhtml.Template().Execute(w, &deviceverificationhtml.PageData{
+		FormAction:   downstreamIssuer + oidc.AuthorizationEndpointPath,
+		UserCode:     session.UserCode,
+		Confirm:      true,
+		ClientID:     session.Request.GetClient().GetID(),
+		HiddenParams: hiddenParams,
+	}); err != nil {
+		// It's too late to return an error response at this point, so just log it.
+		plog.Error("error rendering device verification page", err)
+	}
+	return nil
+}
+
+// redirectToUpstreamOIDC starts the login with the upstream OIDC or GitHub IDP. The downstream authorization request
+// params, or else the device user code, are encrypted into the upstream state param for use by the callback endpoint.
+func redirectToUpstreamOIDC(
+	r *http.Request,
+	w http.ResponseWriter,
+	downstreamAuthParams string,
+	deviceUserCode string,
+	promptParam string,
+	generateCSRF func() (csrftoken.CSRFToken, error),
+	generateNonce func() (nonce.Nonce, error),
+	generatePKCE func() (pkce.Code, error),
+	oidcUpstream provider.UpstreamOIDCIdentityProviderI,
+	oidcUpstreamType psession.ProviderType,
+	downstreamIssuer string,
+	upstreamStateEncoder oidc.Encoder,
+	cookieCodec oidc.Codec,
+) error {
 	csrfValue, nonceValue, pkceValue, err := generateValues(generateCSRF, generateNonce, generatePKCE)
 	if err != nil {
 		plog.Error("authorize generate error", err)
@@ -180,8 +561,10 @@ func handleAuthRequestForOIDCUpstream(
 	}
 
 	encodedStateParamValue, err := upstreamStateParam(
-		authorizeRequester,
+		downstreamAuthParams,
+		deviceUserCode,
 		oidcUpstream.GetName(),
+		oidcUpstreamType,
 		nonceValue,
 		csrfValue,
 		pkceValue,
@@ -208,8 +591,7 @@ func handleAuthRequestForOIDCUpstream(
 		pkceValue.Method(),
 	}
 
-	promptParam := r.Form.Get("prompt")
-	if promptParam != "" && oidc.ScopeWasRequested(authorizeRequester, coreosoidc.ScopeOpenID) {
+	if promptParam != "" {
 		authCodeOptions = append(authCodeOptions, oauth2.SetAuthURLParam("prompt", promptParam))
 	}
 
@@ -224,6 +606,148 @@ func handleAuthRequestForOIDCUpstream(
 	return nil
 }
 
+// redirectToUpstreamSAML starts the login with the upstream SAML IDP by sending an authentication request, either by
+// redirecting the browser or by having it post a form, depending on the binding of the upstream. The downstream
+// authorization request params, or else the device user code, are encrypted into the upstream state param, which is
+// sent as the RelayState and which is returned along with the response to the assertion consumer service.
+func redirectToUpstreamSAML(
+	r *http.Request,
+	w http.ResponseWriter,
+	downstreamAuthParams string,
+	deviceUserCode string,
+	generateCSRF func() (csrftoken.CSRFToken, error),
+	generateNonce func() (nonce.Nonce, error),
+	generatePKCE func() (pkce.Code, error),
+	samlUpstream provider.UpstreamSAMLIdentityProviderI,
+	downstreamIssuer string,
+	upstreamStateEncoder oidc.Encoder,
+	cookieCodec oidc.Codec,
+) error {
+	csrfValue, nonceValue, pkceValue, err := generateValues(generateCSRF, generateNonce, generatePKCE)
+	if err != nil {
+		plog.Error("authorize generate error", err)
+		return err
+	}
+	csrfFromCookie := readCSRFCookie(r, cookieCodec)
+	if csrfFromCookie != "" {
+		csrfValue = csrfFromCookie
+	}
+
+	encodedStateParamValue, err := upstreamStateParam(
+		downstreamAuthParams,
+		deviceUserCode,
+		samlUpstream.GetName(),
+		psession.ProviderTypeSAML,
+		nonceValue,
+		csrfValue,
+		pkceValue,
+		upstreamStateEncoder,
+	)
+	if err != nil {
+		plog.Error("authorize upstream state param error", err)
+		return err
+	}
+
+	// The nonce is used as the ID of the authentication request, so the assertion consumer service can check that
+	// the response is for this request.
+	authnRequest, err := samlUpstream.AuthnRequest(
+		oidc.SAMLRequestID(nonceValue),
+		downstreamIssuer,
+		downstreamIssuer+oidc.SAMLACSEndpointPath,
+		encodedStateParamValue,
+	)
+	if err != nil {
+		plog.Error("authorize SAML authentication request error", err, "upstreamName", samlUpstream.GetName())
+		return httperr.Wrap(http.StatusInternalServerError, "error creating SAML authentication request", err)
+	}
+
+	if csrfFromCookie == "" {
+		// We did not receive an incoming CSRF cookie, so write a new one.
+		err := addCSRFSetCookieHeader(w, csrfValue, cookieCodec)
+		if err != nil {
+			plog.Error("error setting CSRF cookie", err)
+			return err
+		}
+	}
+
+	if authnRequest.PostParams == nil {
+		http.Redirect(w, r, authnRequest.URL.String(), 302)
+		return nil
+	}
+
+	// This page has its own inline JS, so override the default CSP header which was already set by the wrapper.
+	w.Header().Set("Content-Security-Policy", samlposthtml.ContentSecurityPolicy())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := samlposthtml.Template().Execute(w, &samlposthtml.PageData{
+		FormAction: authnRequest.URL.String(),
+		Parameters: authnRequest.PostParams,
+	}); err != nil {
+		// It's too late to return an error response at this point, so just log it.
+		plog.Error("error rendering SAML post page", err)
+	}
+
+	return nil
+}
+
+// redirectToLoginPage starts the login with the upstream LDAP or Active Directory IDP by sending the browser to the
+// login page, where the end user will enter their username and password. The downstream authorization request params,
+// or else the device user code, are encrypted into the upstream state param, which is submitted along with the form.
+func redirectToLoginPage(
+	r *http.Request,
+	w http.ResponseWriter,
+	downstreamAuthParams string,
+	deviceUserCode string,
+	generateCSRF func() (csrftoken.CSRFToken, error),
+	generateNonce func() (nonce.Nonce, error),
+	generatePKCE func() (pkce.Code, error),
+	ldapUpstream provider.UpstreamLDAPIdentityProviderI,
+	ldapUpstreamType psession.ProviderType,
+	downstreamIssuer string,
+	upstreamStateEncoder oidc.Encoder,
+	cookieCodec oidc.Codec,
+) error {
+	csrfValue, nonceValue, pkceValue, err := generateValues(generateCSRF, generateNonce, generatePKCE)
+	if err != nil {
+		plog.Error("authorize generate error", err)
+		return err
+	}
+	csrfFromCookie := readCSRFCookie(r, cookieCodec)
+	if csrfFromCookie != "" {
+		csrfValue = csrfFromCookie
+	}
+
+	encodedStateParamValue, err := upstreamStateParam(
+		downstreamAuthParams,
+		deviceUserCode,
+		ldapUpstream.GetName(),
+		ldapUpstreamType,
+		nonceValue,
+		csrfValue,
+		pkceValue,
+		upstreamStateEncoder,
+	)
+	if err != nil {
+		plog.Error("authorize upstream state param error", err)
+		return err
+	}
+
+	if csrfFromCookie == "" {
+		// We did not receive an incoming CSRF cookie, so write a new one.
+		err := addCSRFSetCookieHeader(w, csrfValue, cookieCodec)
+		if err != nil {
+			plog.Error("error setting CSRF cookie", err)
+			return err
+		}
+	}
+
+	params := url.Values{}
+	params.Set("state", encodedStateParamValue)
+	http.Redirect(w, r, downstreamIssuer+oidc.LoginEndpointPath+"?"+params.Encode(), 302)
+
+	return nil
+}
+
 func newAuthorizeRequest(r *http.Request, w http.ResponseWriter, oauthHelper fosite.OAuth2Provider) (fosite.AuthorizeRequester, bool) {
 	authorizeRequester, err := oauthHelper.NewAuthorizeRequest(r.Context(), r)
 	if err != nil {
@@ -241,6 +765,88 @@ func newAuthorizeRequest(r *http.Request, w http.ResponseWriter, oauthHelper fos
 	return authorizeRequester, true
 }
 
+// newAuthorizeRequestWithoutStorage validates the authorize request and then performs the OIDC validations
+// of fosite's NewAuthorizeResponse without storing anything, because the actual downstream session will only be
+// created after the end user has authenticated with the upstream IDP. The oauthHelper must be configured with
+// NullStorage.
+func newAuthorizeRequestWithoutStorage(r *http.Request, w http.ResponseWriter, oauthHelper fosite.OAuth2Provider) (fosite.AuthorizeRequester, bool) {
+	authorizeRequester, created := newAuthorizeRequest(r, w, oauthHelper)
+	if !created {
+		return nil, false
+	}
+
+	now := time.Now()
+	_, err := oauthHelper.NewAuthorizeResponse(r.Context(), authorizeRequester, &openid.DefaultSession{
+		Claims: &jwt.IDTokenClaims{
+			// Temporary claim values to allow `NewAuthorizeResponse` to perform other OIDC validations.
+			Subject:     "none",
+			AuthTime:    now,
+			RequestedAt: now,
+		},
+	})
+	if err != nil {
+		plog.Info("authorize response error", oidc.FositeErrorForLog(err)...)
+		oauthHelper.WriteAuthorizeError(w, authorizeRequester, err)
+		return nil, false
+	}
+
+	return authorizeRequester, true
+}
+
+func handleAuthRequestByShowingIDPChooser(
+	r *http.Request,
+	w http.ResponseWriter,
+	oauthHelper fosite.OAuth2Provider,
+	idpLister oidc.UpstreamIdentityProvidersLister,
+	downstreamIssuer string,
+) error {
+	// Validate the request before asking the end user to make a choice, so they will not need to choose an
+	// upstream IDP only to find out afterwards that the client made an invalid request.
+	authorizeRequester, created := newAuthorizeRequestWithoutStorage(r, w, oauthHelper)
+	if !created {
+		return nil
+	}
+
+	return showIDPChooser(w, idpLister, downstreamIssuer, authorizeRequester.GetRequestForm())
+}
+
+// showIDPChooser renders the page which lets the end user choose an upstream IDP. Each choice links back to
+// the authorize endpoint with the given params, plus the params which select that upstream IDP.
+func showIDPChooser(
+	w http.ResponseWriter,
+	idpLister oidc.UpstreamIdentityProvidersLister,
+	downstreamIssuer string,
+	authorizeParams url.Values,
+) error {
+	pageData := chooseidphtml.PageData{}
+	for _, idp := range idpdiscovery.GetIdentityProviders(idpLister) {
+		// Repeat all the original params of the authorization request, plus the ones that select this upstream IDP.
+		params := url.Values{}
+		for k, v := range authorizeParams {
+			params[k] = v
+		}
+		params.Set(oidc.AuthorizeUpstreamIDPNameParamName, idp.Name)
+		params.Set(oidc.AuthorizeUpstreamIDPTypeParamName, idp.Type)
+
+		pageData.IdentityProviders = append(pageData.IdentityProviders, chooseidphtml.IdentityProvider{
+			Name: idp.Name,
+			Type: idp.Type,
+			URL:  downstreamIssuer + oidc.AuthorizationEndpointPath + "?" + params.Encode(),
+		})
+	}
+
+	// This page has its own inline CSS, so override the default CSP header which was already set by the wrapper.
+	w.Header().Set("Content-Security-Policy", chooseidphtml.ContentSecurityPolicy())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := chooseidphtml.Template().Execute(w, &pageData); err != nil {
+		// It's too late to return an error response at this point, so just log it.
+		plog.Error("error rendering upstream IDP chooser page", err)
+	}
+
+	return nil
+}
+
 func readCSRFCookie(r *http.Request, codec oidc.Decoder) csrftoken.CSRFToken {
 	receivedCSRFCookie, err := r.Cookie(oidc.CSRFCookieName)
 	if err != nil {
@@ -260,33 +866,140 @@ func readCSRFCookie(r *http.Request, codec oidc.Decoder) csrftoken.CSRFToken {
 	return csrfFromCookie
 }
 
-// Select either an OIDC or an LDAP IDP, or return an error.
-func chooseUpstreamIDP(idpLister oidc.UpstreamIdentityProvidersLister) (provider.UpstreamOIDCIdentityProviderI, provider.UpstreamLDAPIdentityProviderI, error) {
+// Select either an OIDC-style (OIDC or GitHub), an LDAP-style (LDAP or Active Directory), or a SAML IDP, or return an
+// error. The type of the returned IDP is also returned. When the client did not request any particular upstream IDP
+// and there is more than one to choose from, then all returned IDPs will be nil.
+func chooseUpstreamIDP(
+	idpName string,
+	idpType string,
+	idpLister oidc.UpstreamIdentityProvidersLister,
+) (provider.UpstreamOIDCIdentityProviderI, provider.UpstreamLDAPIdentityProviderI, provider.UpstreamSAMLIdentityProviderI, psession.ProviderType, error) {
 	oidcUpstreams := idpLister.GetOIDCIdentityProviders()
 	ldapUpstreams := idpLister.GetLDAPIdentityProviders()
+	adUpstreams := idpLister.GetActiveDirectoryIdentityProviders()
+	gitHubUpstreams := idpLister.GetGitHubIdentityProviders()
+	samlUpstreams := idpLister.GetSAMLIdentityProviders()
 	switch {
-	case len(oidcUpstreams)+len(ldapUpstreams) == 0:
-		return nil, nil, httperr.New(
+	case len(oidcUpstreams)+len(ldapUpstreams)+len(adUpstreams)+len(gitHubUpstreams)+len(samlUpstreams) == 0:
+		return nil, nil, nil, "", httperr.New(
 			http.StatusUnprocessableEntity,
 			"No upstream providers are configured",
 		)
-	case len(oidcUpstreams)+len(ldapUpstreams) > 1:
-		var upstreamIDPNames []string
+	case idpName == "" && idpType != "":
+		return nil, nil, nil, "", httperr.Newf(
+			http.StatusBadRequest,
+			"%s param requires the %s param",
+			oidc.AuthorizeUpstreamIDPTypeParamName, oidc.AuthorizeUpstreamIDPNameParamName,
+		)
+	case idpName != "":
+		return findUpstreamIDP(idpName, idpType, oidcUpstreams, ldapUpstreams, adUpstreams, gitHubUpstreams, samlUpstreams)
+	case len(oidcUpstreams)+len(ldapUpstreams)+len(adUpstreams)+len(gitHubUpstreams)+len(samlUpstreams) > 1:
+		return nil, nil, nil, "", nil
+	case len(oidcUpstreams) == 1:
+		return oidcUpstreams[0], nil, nil, psession.ProviderTypeOIDC, nil
+	case len(gitHubUpstreams) == 1:
+		return gitHubUpstreams[0], nil, nil, psession.ProviderTypeGitHub, nil
+	case len(samlUpstreams) == 1:
+		return nil, nil, samlUpstreams[0], psession.ProviderTypeSAML, nil
+	case len(ldapUpstreams) == 1:
+		return nil, ldapUpstreams[0], nil, psession.ProviderTypeLDAP, nil
+	default:
+		return nil, adUpstreams[0], nil, psession.ProviderTypeActiveDirectory, nil
+	}
+}
+
+// Find the upstream IDP which was requested by name, and optionally by type.
+func findUpstreamIDP(
+	idpName string,
+	idpType string,
+	oidcUpstreams []provider.UpstreamOIDCIdentityProviderI,
+	ldapUpstreams []provider.UpstreamLDAPIdentityProviderI,
+	adUpstreams []provider.UpstreamLDAPIdentityProviderI,
+	gitHubUpstreams []provider.UpstreamOIDCIdentityProviderI,
+	samlUpstreams []provider.UpstreamSAMLIdentityProviderI,
+) (provider.UpstreamOIDCIdentityProviderI, provider.UpstreamLDAPIdentityProviderI, provider.UpstreamSAMLIdentityProviderI, psession.ProviderType, error) {
+	if idpType != "" &&
+		idpType != idpdiscovery.IDPTypeOIDC &&
+		idpType != idpdiscovery.IDPTypeLDAP &&
+		idpType != idpdiscovery.IDPTypeActiveDirectory &&
+		idpType != idpdiscovery.IDPTypeGitHub &&
+		idpType != idpdiscovery.IDPTypeSAML {
+		return nil, nil, nil, "", httperr.Newf(
+			http.StatusBadRequest,
+			"%s param must be one of: %s, %s, %s, %s, %s",
+			oidc.AuthorizeUpstreamIDPTypeParamName,
+			idpdiscovery.IDPTypeOIDC, idpdiscovery.IDPTypeLDAP, idpdiscovery.IDPTypeActiveDirectory, idpdiscovery.IDPTypeGitHub, idpdiscovery.IDPTypeSAML,
+		)
+	}
+
+	found := 0
+	var foundType psession.ProviderType
+
+	var foundOIDC provider.UpstreamOIDCIdentityProviderI
+	if idpType == "" || idpType == idpdiscovery.IDPTypeOIDC {
 		for _, idp := range oidcUpstreams {
-			upstreamIDPNames = append(upstreamIDPNames, idp.GetName())
+			if idp.GetName() == idpName {
+				foundOIDC, foundType = idp, psession.ProviderTypeOIDC
+				found++
+				break
+			}
+		}
+	}
+	if idpType == "" || idpType == idpdiscovery.IDPTypeGitHub {
+		for _, idp := range gitHubUpstreams {
+			if idp.GetName() == idpName {
+				foundOIDC, foundType = idp, psession.ProviderTypeGitHub
+				found++
+				break
+			}
 		}
+	}
+
+	var foundLDAP provider.UpstreamLDAPIdentityProviderI
+	if idpType == "" || idpType == idpdiscovery.IDPTypeLDAP {
 		for _, idp := range ldapUpstreams {
-			upstreamIDPNames = append(upstreamIDPNames, idp.GetName())
+			if idp.GetName() == idpName {
+				foundLDAP, foundType = idp, psession.ProviderTypeLDAP
+				found++
+				break
+			}
+		}
+	}
+	if idpType == "" || idpType == idpdiscovery.IDPTypeActiveDirectory {
+		for _, idp := range adUpstreams {
+			if idp.GetName() == idpName {
+				foundLDAP, foundType = idp, psession.ProviderTypeActiveDirectory
+				found++
+				break
+			}
+		}
+	}
+
+	var foundSAML provider.UpstreamSAMLIdentityProviderI
+	if idpType == "" || idpType == idpdiscovery.IDPTypeSAML {
+		for _, idp := range samlUpstreams {
+			if idp.GetName() == idpName {
+				foundSAML, foundType = idp, psession.ProviderTypeSAML
+				found++
+				break
+			}
 		}
-		plog.Warning("Too many upstream providers are configured (found: %s)", upstreamIDPNames)
-		return nil, nil, httperr.New(
+	}
+
+	switch {
+	case found > 1:
+		return nil, nil, nil, "", httperr.Newf(
 			http.StatusUnprocessableEntity,
-			"Too many upstream providers are configured (support for multiple upstreams is not yet implemented)",
+			"More than one upstream provider is named %q (use the %s param to choose one)",
+			idpName, oidc.AuthorizeUpstreamIDPTypeParamName,
+		)
+	case found == 0:
+		return nil, nil, nil, "", httperr.New(
+			http.StatusUnprocessableEntity,
+			"Requested upstream provider was not found",
 		)
-	case len(oidcUpstreams) == 1:
-		return oidcUpstreams[0], nil, nil
 	default:
-		return nil, ldapUpstreams[0], nil
+		return foundOIDC, foundLDAP, foundSAML, foundType, nil
 	}
 }
 
@@ -311,20 +1024,26 @@ func generateValues(
 }
 
 func upstreamStateParam(
-	authorizeRequester fosite.AuthorizeRequester,
+	downstreamAuthParams string,
+	deviceUserCode string,
 	upstreamName string,
+	upstreamType psession.ProviderType,
 	nonceValue nonce.Nonce,
 	csrfValue csrftoken.CSRFToken,
 	pkceValue pkce.Code,
 	encoder oidc.Encoder,
 ) (string, error) {
 	stateParamData := oidc.UpstreamStateParamData{
-		AuthParams:    authorizeRequester.GetRequestForm().Encode(),
-		UpstreamName:  upstreamName,
-		Nonce:         nonceValue,
-		CSRFToken:     csrfValue,
-		PKCECode:      pkceValue,
-		FormatVersion: oidc.UpstreamStateParamFormatVersion,
+		AuthParams:     downstreamAuthParams,
+		UpstreamName:   upstreamName,
+		Nonce:          nonceValue,
+		CSRFToken:      csrfValue,
+		PKCECode:       pkceValue,
+		FormatVersion:  oidc.UpstreamStateParamFormatVersion,
+		DeviceUserCode: deviceUserCode,
+	}
+	if upstreamType != psession.ProviderTypeOIDC {
+		stateParamData.UpstreamType = string(upstreamType)
 	}
 	encodedStateParamValue, err := encoder.Encode(oidc.UpstreamStateParamEncodingName, stateParamData)
 	if err != nil {
@@ -350,11 +1069,3 @@ func addCSRFSetCookieHeader(w http.ResponseWriter, csrfValue csrftoken.CSRFToken
 
 	return nil
 }
-
-func downstreamSubjectFromUpstreamLDAP(ldapUpstream provider.UpstreamLDAPIdentityProviderI, authenticateResponse *authenticator.Response) string {
-	ldapURL := *ldapUpstream.GetURL()
-	q := ldapURL.Query()
-	q.Set(oidc.IDTokenSubjectClaim, authenticateResponse.User.GetUID())
-	ldapURL.RawQuery = q.Encode()
-	return ldapURL.String()
-}
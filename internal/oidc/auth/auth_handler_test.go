@@ -13,6 +13,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/securecookie"
 	"github.com/ory/fosite"
@@ -24,10 +25,13 @@ import (
 	"k8s.io/utils/pointer"
 
 	"go.pinniped.dev/internal/here"
+	"go.pinniped.dev/internal/idtransform"
 	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/clientregistry"
 	"go.pinniped.dev/internal/oidc/csrftoken"
 	"go.pinniped.dev/internal/oidc/jwks"
 	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/psession"
 	"go.pinniped.dev/internal/testutil"
 	"go.pinniped.dev/internal/testutil/oidctestutil"
 	"go.pinniped.dev/pkg/oidcclient/nonce"
@@ -119,6 +123,12 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			"state":             happyState,
 		}
 
+		fositeAccessDeniedWithIdentityTransformsHintErrorQuery = map[string]string{
+			"error":             "access_denied",
+			"error_description": "The resource owner or authorization server denied the request. Login denied by identity transforms.",
+			"state":             happyState,
+		}
+
 		fositeAccessDeniedWithMissingUsernamePasswordHintErrorQuery = map[string]string{
 			"error":             "access_denied",
 			"error_description": "The resource owner or authorization server denied the request. Missing or blank username or password.",
@@ -126,20 +136,22 @@ func TestAuthorizationEndpoint(t *testing.T) {
 		}
 	)
 
-	hmacSecretFunc := func() []byte { return []byte("some secret - must have at least 32 bytes") }
-	require.GreaterOrEqual(t, len(hmacSecretFunc()), 32, "fosite requires that hmac secrets have at least 32 bytes")
+	hmacSecretFunc := func() [][]byte { return [][]byte{[]byte("some secret - must have at least 32 bytes")} }
+	require.GreaterOrEqual(t, len(hmacSecretFunc()[0]), 32, "fosite requires that hmac secrets have at least 32 bytes")
 	jwksProviderIsUnused := jwks.NewDynamicJWKSProvider()
 	timeoutsConfiguration := oidc.DefaultOIDCTimeoutsConfiguration()
 
+	clientManager := oidctestutil.NewClientManager("some-namespace")
+
 	createOauthHelperWithRealStorage := func(secretsClient v1.SecretInterface) (fosite.OAuth2Provider, *oidc.KubeStorage) {
 		// Configure fosite the same way that the production code would when using Kube storage.
 		// Inject this into our test subject at the last second so we get a fresh storage for every test.
-		kubeOauthStore := oidc.NewKubeStorage(secretsClient, timeoutsConfiguration)
+		kubeOauthStore := oidc.NewKubeStorage(secretsClient, clientManager, timeoutsConfiguration)
 		return oidc.FositeOauth2Helper(kubeOauthStore, downstreamIssuer, hmacSecretFunc, jwksProviderIsUnused, timeoutsConfiguration), kubeOauthStore
 	}
 
 	// Configure fosite the same way that the production code would, using NullStorage to turn off storage.
-	nullOauthStore := oidc.NullStorage{}
+	nullOauthStore := oidc.NullStorage{ClientManager: clientManager}
 	oauthHelperWithNullStorage := oidc.FositeOauth2Helper(nullOauthStore, downstreamIssuer, hmacSecretFunc, jwksProviderIsUnused, timeoutsConfiguration)
 
 	upstreamAuthURL, err := url.Parse("https://some-upstream-idp:8443/auth")
@@ -152,12 +164,72 @@ func TestAuthorizationEndpoint(t *testing.T) {
 		Scopes:           []string{"scope1", "scope2"}, // the scopes to request when starting the upstream authorization flow
 	}
 
+	otherUpstreamOIDCIdentityProvider := oidctestutil.TestUpstreamOIDCIdentityProvider{
+		Name:             "some-other-oidc-idp",
+		ClientID:         "some-other-client-id",
+		AuthorizationURL: *upstreamAuthURL,
+		Scopes:           []string{"other-scope1", "other-scope2"},
+	}
+
+	gitHubUpstreamWithSameNameAsOIDCUpstream := oidctestutil.TestUpstreamOIDCIdentityProvider{
+		Name:             upstreamOIDCIdentityProvider.Name,
+		ClientID:         upstreamOIDCIdentityProvider.ClientID,
+		AuthorizationURL: *upstreamAuthURL,
+		Scopes:           upstreamOIDCIdentityProvider.Scopes,
+	}
+
+	// The fake SAML authentication requests are made of their args, so the tests can check that the args were right.
+	fakeSAMLRequest := func(requestID, spEntityID, acsURL string) string {
+		return strings.Join([]string{requestID, spEntityID, acsURL}, " ")
+	}
+
+	upstreamSAMLIdentityProvider := oidctestutil.TestUpstreamSAMLIdentityProvider{
+		Name:     "some-saml-idp",
+		EntityID: "https://some-saml-idp/metadata",
+		AuthnRequestFunc: func(requestID, spEntityID, acsURL, relayState string) (*provider.SAMLAuthnRequest, error) {
+			query := url.Values{}
+			query.Set("SAMLRequest", fakeSAMLRequest(requestID, spEntityID, acsURL))
+			query.Set("RelayState", relayState)
+			return &provider.SAMLAuthnRequest{
+				URL: &url.URL{Scheme: "https", Host: "some-saml-idp", Path: "/sso/redirect", RawQuery: query.Encode()},
+			}, nil
+		},
+	}
+
+	postBindingUpstreamSAMLIdentityProvider := oidctestutil.TestUpstreamSAMLIdentityProvider{
+		Name:     "some-saml-idp",
+		EntityID: "https://some-saml-idp/metadata",
+		AuthnRequestFunc: func(requestID, spEntityID, acsURL, relayState string) (*provider.SAMLAuthnRequest, error) {
+			return &provider.SAMLAuthnRequest{
+				URL: &url.URL{Scheme: "https", Host: "some-saml-idp", Path: "/sso/post"},
+				PostParams: url.Values{
+					"SAMLRequest": {fakeSAMLRequest(requestID, spEntityID, acsURL)},
+					"RelayState":  {relayState},
+				},
+			}, nil
+		},
+	}
+
+	erroringUpstreamSAMLIdentityProvider := oidctestutil.TestUpstreamSAMLIdentityProvider{
+		Name:     "some-saml-idp",
+		EntityID: "https://some-saml-idp/metadata",
+		AuthnRequestFunc: func(requestID, spEntityID, acsURL, relayState string) (*provider.SAMLAuthnRequest, error) {
+			return nil, fmt.Errorf("some SAML authentication request error")
+		},
+	}
+
 	happyLDAPUsername := "some-ldap-user"
 	happyLDAPUsernameFromAuthenticator := "some-mapped-ldap-username"
 	happyLDAPPassword := "some-ldap-password" //nolint:gosec
 	happyLDAPUID := "some-ldap-uid"
 	happyLDAPGroups := []string{"group1", "group2", "group3"}
 
+	happyLDAPCustomSessionData := &psession.CustomSessionData{
+		ProviderName: "some-ldap-idp",
+		ProviderType: psession.ProviderTypeLDAP,
+		LDAP:         &psession.LDAPSessionData{Username: happyLDAPUsername},
+	}
+
 	parsedUpstreamLDAPURL, err := url.Parse(upstreamLDAPURL)
 	require.NoError(t, err)
 
@@ -181,6 +253,41 @@ func TestAuthorizationEndpoint(t *testing.T) {
 		},
 	}
 
+	happyActiveDirectoryCustomSessionData := &psession.CustomSessionData{
+		ProviderName:    "some-active-directory-idp",
+		ProviderType:    psession.ProviderTypeActiveDirectory,
+		ActiveDirectory: &psession.ActiveDirectorySessionData{Username: happyLDAPUsername},
+	}
+
+	upstreamActiveDirectoryIdentityProvider := oidctestutil.TestUpstreamLDAPIdentityProvider{
+		Name:             "some-active-directory-idp",
+		URL:              parsedUpstreamLDAPURL,
+		AuthenticateFunc: upstreamLDAPIdentityProvider.AuthenticateFunc,
+	}
+
+	activeDirectoryUpstreamWithSameNameAsLDAPUpstream := oidctestutil.TestUpstreamLDAPIdentityProvider{
+		Name:             upstreamLDAPIdentityProvider.Name,
+		URL:              parsedUpstreamLDAPURL,
+		AuthenticateFunc: upstreamLDAPIdentityProvider.AuthenticateFunc,
+	}
+
+	ldapUpstreamWithSameNameAsOIDCUpstream := oidctestutil.TestUpstreamLDAPIdentityProvider{
+		Name:             upstreamOIDCIdentityProvider.Name,
+		URL:              parsedUpstreamLDAPURL,
+		AuthenticateFunc: upstreamLDAPIdentityProvider.AuthenticateFunc,
+	}
+
+	prefixLDAPTransforms, err := idtransform.NewPipeline([]idtransform.Transform{
+		{Type: idtransform.TypePrefix, Prefix: "ldap:"},
+		{Type: idtransform.TypeDenyGroups, Regex: "^group3$"},
+	})
+	require.NoError(t, err)
+
+	denyLoginLDAPTransforms, err := idtransform.NewPipeline([]idtransform.Transform{
+		{Type: idtransform.TypeDenyLogin, Target: idtransform.TargetGroups, Regex: "^group2$"},
+	})
+	require.NoError(t, err)
+
 	erroringUpstreamLDAPIdentityProvider := oidctestutil.TestUpstreamLDAPIdentityProvider{
 		Name: "some-ldap-idp",
 		AuthenticateFunc: func(ctx context.Context, username, password string) (*authenticator.Response, bool, error) {
@@ -271,6 +378,41 @@ func TestAuthorizationEndpoint(t *testing.T) {
 		return pathWithQuery("/some/path", modifiedHappyGetRequestQueryMap(queryOverrides))
 	}
 
+	oidcUpstreamChoiceParams := map[string]string{
+		"pinniped_idp_name": upstreamOIDCIdentityProvider.Name,
+		"pinniped_idp_type": "oidc",
+	}
+
+	ldapUpstreamChoiceParams := map[string]string{
+		"pinniped_idp_name": upstreamLDAPIdentityProvider.Name,
+		"pinniped_idp_type": "ldap",
+	}
+
+	activeDirectoryUpstreamChoiceParams := map[string]string{
+		"pinniped_idp_name": upstreamActiveDirectoryIdentityProvider.Name,
+		"pinniped_idp_type": "activedirectory",
+	}
+
+	gitHubUpstreamChoiceParams := map[string]string{
+		"pinniped_idp_name": gitHubUpstreamWithSameNameAsOIDCUpstream.Name,
+		"pinniped_idp_type": "github",
+	}
+
+	samlUpstreamChoiceParams := map[string]string{
+		"pinniped_idp_name": upstreamSAMLIdentityProvider.Name,
+		"pinniped_idp_type": "saml",
+	}
+
+	expectedChooserLink := func(idpName, idpType string) string {
+		chooserLinkQuery := modifiedHappyGetRequestQueryMap(map[string]string{
+			"pinniped_idp_name": idpName,
+			"pinniped_idp_type": idpType,
+		})
+		// The html/template package also escapes plus signs in attribute values.
+		escapedURL := html.EscapeString(downstreamIssuer + "/oauth2/authorize?" + encodeQuery(chooserLinkQuery))
+		return fmt.Sprintf(`<a href="%s">`, strings.ReplaceAll(escapedURL, "+", "&#43;"))
+	}
+
 	expectedUpstreamStateParam := func(queryOverrides map[string]string, csrfValueOverride, upstreamNameOverride string) string {
 		csrf := happyCSRF
 		if csrfValueOverride != "" {
@@ -294,6 +436,87 @@ func TestAuthorizationEndpoint(t *testing.T) {
 		return encoded
 	}
 
+	expectedGitHubUpstreamStateParam := func(queryOverrides map[string]string) string {
+		encoded, err := happyStateEncoder.Encode("s",
+			oidctestutil.ExpectedUpstreamStateParamFormat{
+				P: encodeQuery(modifiedHappyGetRequestQueryMap(queryOverrides)),
+				U: gitHubUpstreamWithSameNameAsOIDCUpstream.Name,
+				N: happyNonce,
+				C: happyCSRF,
+				K: happyPKCE,
+				V: "1",
+				T: "github",
+			},
+		)
+		require.NoError(t, err)
+		return encoded
+	}
+
+	expectedSAMLUpstreamStateParam := func(downstreamAuthParams string, deviceUserCode string, csrfValueOverride string) string {
+		csrf := happyCSRF
+		if csrfValueOverride != "" {
+			csrf = csrfValueOverride
+		}
+		encoded, err := happyStateEncoder.Encode("s",
+			oidctestutil.ExpectedUpstreamStateParamFormat{
+				P: downstreamAuthParams,
+				U: upstreamSAMLIdentityProvider.Name,
+				N: happyNonce,
+				C: csrf,
+				K: happyPKCE,
+				V: "1",
+				D: deviceUserCode,
+				T: "saml",
+			},
+		)
+		require.NoError(t, err)
+		return encoded
+	}
+
+	expectedLDAPUpstreamStateParam := func(downstreamAuthParams string, deviceUserCode string, csrfValueOverride string, upstreamName string, upstreamType string) string {
+		csrf := happyCSRF
+		if csrfValueOverride != "" {
+			csrf = csrfValueOverride
+		}
+		encoded, err := happyStateEncoder.Encode("s",
+			oidctestutil.ExpectedUpstreamStateParamFormat{
+				P: downstreamAuthParams,
+				U: upstreamName,
+				N: happyNonce,
+				C: csrf,
+				K: happyPKCE,
+				V: "1",
+				D: deviceUserCode,
+				T: upstreamType,
+			},
+		)
+		require.NoError(t, err)
+		return encoded
+	}
+
+	expectedRedirectLocationForLoginPage := func(expectedUpstreamState string) string {
+		return urlWithQuery(downstreamIssuer+"/login", map[string]string{"state": expectedUpstreamState})
+	}
+
+	expectedDeviceUpstreamStateParam := func(deviceUserCode string, csrfValueOverride string) string {
+		csrf := happyCSRF
+		if csrfValueOverride != "" {
+			csrf = csrfValueOverride
+		}
+		encoded, err := happyStateEncoder.Encode("s",
+			oidctestutil.ExpectedUpstreamStateParamFormat{
+				U: upstreamOIDCIdentityProvider.Name,
+				N: happyNonce,
+				C: csrf,
+				K: happyPKCE,
+				V: "1",
+				D: deviceUserCode,
+			},
+		)
+		require.NoError(t, err)
+		return encoded
+	}
+
 	expectedRedirectLocationForUpstreamOIDC := func(expectedUpstreamState string, expectedPrompt string) string {
 		query := map[string]string{
 			"response_type":         "code",
@@ -312,6 +535,13 @@ func TestAuthorizationEndpoint(t *testing.T) {
 		return urlWithQuery(upstreamAuthURL.String(), query)
 	}
 
+	expectedRedirectLocationForUpstreamSAML := func(expectedUpstreamState string) string {
+		return urlWithQuery("https://some-saml-idp/sso/redirect", map[string]string{
+			"SAMLRequest": fakeSAMLRequest("_"+happyNonce, downstreamIssuer, downstreamIssuer+"/saml/acs"),
+			"RelayState":  expectedUpstreamState,
+		})
+	}
+
 	// Note that fosite puts the granted scopes as a param in the redirect URI even though the spec doesn't seem to require it
 	happyAuthcodeDownstreamRedirectLocationRegexp := downstreamRedirectURI + `\?code=([^&]+)&scope=openid&state=` + happyState
 
@@ -322,7 +552,7 @@ func TestAuthorizationEndpoint(t *testing.T) {
 	type testCase struct {
 		name string
 
-		idpLister            provider.DynamicUpstreamIDPProvider
+		idpLister            *oidctestutil.UpstreamIDPLister
 		generateCSRF         func() (csrftoken.CSRFToken, error)
 		generatePKCE         func() (pkce.Code, error)
 		generateNonce        func() (nonce.Nonce, error)
@@ -336,14 +566,20 @@ func TestAuthorizationEndpoint(t *testing.T) {
 		customUsernameHeader *string // nil means do not send header, empty means send header with empty value
 		customPasswordHeader *string // nil means do not send header, empty means send header with empty value
 
+		// When non-zero, a device authorization request with the user code "WDJB-MJHT" which expires after
+		// this duration is stored before the request is made.
+		deviceCodeSessionTTL time.Duration
+
 		wantStatus                             int
 		wantContentType                        string
 		wantBodyString                         string
 		wantBodyJSON                           string
+		wantBodyContains                       []string
 		wantCSRFValueInCookieHeader            string
 		wantBodyStringWithLocationInHref       bool
 		wantLocationHeader                     string
 		wantUpstreamStateParamInLocationHeader bool
+		wantUpstreamStateParamName             string // the query param which holds the upstream state param, when not "state"
 
 		// For when the request was authenticated by an upstream LDAP provider and an authcode is being returned.
 		wantRedirectLocationRegexp        string
@@ -356,6 +592,7 @@ func TestAuthorizationEndpoint(t *testing.T) {
 		wantDownstreamPKCEChallenge       string
 		wantDownstreamPKCEChallengeMethod string
 		wantDownstreamNonce               string
+		wantDownstreamCustomSessionData   *psession.CustomSessionData
 		wantUnnecessaryStoredRecords      int
 	}
 	tests := []testCase{
@@ -372,10 +609,339 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			wantStatus:                             http.StatusFound,
 			wantContentType:                        htmlContentType,
 			wantCSRFValueInCookieHeader:            happyCSRF,
-			wantLocationHeader:                     expectedRedirectLocationForUpstreamOIDC(expectedUpstreamStateParam(nil, "", ""), ""),
+			wantLocationHeader:                     expectedRedirectLocationForUpstreamOIDC(expectedUpstreamStateParam(nil, "", ""), ""),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name:                 "device authorization request asks the end user to confirm the login",
+			idpLister:            oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).Build(),
+			generateCSRF:         happyCSRFGenerator,
+			generatePKCE:         happyPKCEGenerator,
+			generateNonce:        happyNonceGenerator,
+			stateEncoder:         happyStateEncoder,
+			cookieEncoder:        happyCookieEncoder,
+			deviceCodeSessionTTL: time.Hour,
+			method:               http.MethodGet,
+			path: pathWithQuery("/some/path", map[string]string{
+				"pinniped_device_user_code": "WDJBMJHT",
+				"pinniped_idp_name":         upstreamOIDCIdentityProvider.Name,
+			}),
+			wantStatus:                  http.StatusOK,
+			wantContentType:             htmlContentType,
+			wantCSRFValueInCookieHeader: happyCSRF,
+			wantBodyContains: []string{
+				`<form method="post" action="` + downstreamIssuer + `/oauth2/authorize">`,
+				`<strong>pinniped-cli</strong>`,
+				`<p class="user-code">WDJB-MJHT</p>`,
+				`<input type="hidden" name="pinniped_device_csrf" value="` + happyCSRF + `">`,
+				`<input type="hidden" name="pinniped_device_user_code" value="WDJBMJHT">`,
+				`<input type="hidden" name="pinniped_idp_name" value="` + upstreamOIDCIdentityProvider.Name + `">`,
+			},
+		},
+		{
+			name:                 "device authorization request asks the end user to confirm the login using an existing CSRF cookie",
+			idpLister:            oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).Build(),
+			generateCSRF:         happyCSRFGenerator,
+			generatePKCE:         happyPKCEGenerator,
+			generateNonce:        happyNonceGenerator,
+			stateEncoder:         happyStateEncoder,
+			cookieEncoder:        happyCookieEncoder,
+			deviceCodeSessionTTL: time.Hour,
+			method:               http.MethodGet,
+			path:                 pathWithQuery("/some/path", map[string]string{"pinniped_device_user_code": "WDJBMJHT"}),
+			csrfCookie:           "__Host-pinniped-csrf=" + encodedIncomingCookieCSRFValue + " ",
+			wantStatus:           http.StatusOK,
+			wantContentType:      htmlContentType,
+			wantBodyContains: []string{
+				`<input type="hidden" name="pinniped_device_csrf" value="` + incomingCookieCSRFValue + `">`,
+			},
+		},
+		{
+			name:            "device authorization request which does not exist",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).Build(),
+			generateCSRF:    happyCSRFGenerator,
+			cookieEncoder:   happyCookieEncoder,
+			method:          http.MethodGet,
+			path:            pathWithQuery("/some/path", map[string]string{"pinniped_device_user_code": "WDJBMJHT"}),
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBodyString:  "Unprocessable Entity: device authorization request not found\n",
+		},
+		{
+			name:                 "device authorization request which has expired",
+			idpLister:            oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).Build(),
+			generateCSRF:         happyCSRFGenerator,
+			cookieEncoder:        happyCookieEncoder,
+			deviceCodeSessionTTL: -time.Minute,
+			method:               http.MethodGet,
+			path:                 pathWithQuery("/some/path", map[string]string{"pinniped_device_user_code": "WDJBMJHT"}),
+			wantStatus:           http.StatusUnprocessableEntity,
+			wantContentType:      "text/plain; charset=utf-8",
+			wantBodyString:       "Unprocessable Entity: device authorization request has expired or was already used\n",
+		},
+		{
+			name:                                   "OIDC upstream happy path for a confirmed device authorization request",
+			idpLister:                              oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).Build(),
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodPost,
+			path:                                   "/some/path",
+			contentType:                            "application/x-www-form-urlencoded",
+			body:                                   encodeQuery(map[string]string{"pinniped_device_user_code": "WDJBMJHT", "pinniped_device_csrf": incomingCookieCSRFValue}),
+			csrfCookie:                             "__Host-pinniped-csrf=" + encodedIncomingCookieCSRFValue + " ",
+			wantStatus:                             http.StatusFound,
+			wantContentType:                        "",
+			wantBodyString:                         "",
+			wantLocationHeader:                     expectedRedirectLocationForUpstreamOIDC(expectedDeviceUpstreamStateParam("WDJBMJHT", incomingCookieCSRFValue), ""),
+			wantUpstreamStateParamInLocationHeader: true,
+		},
+		{
+			name:                                   "SAML upstream happy path using GET with the HTTP-Redirect binding",
+			idpLister:                              oidctestutil.NewUpstreamIDPListerBuilder().WithSAML(&upstreamSAMLIdentityProvider).Build(),
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodGet,
+			path:                                   happyGetRequestPath,
+			wantStatus:                             http.StatusFound,
+			wantContentType:                        htmlContentType,
+			wantCSRFValueInCookieHeader:            happyCSRF,
+			wantLocationHeader:                     expectedRedirectLocationForUpstreamSAML(expectedSAMLUpstreamStateParam(encodeQuery(happyGetRequestQueryMap), "", "")),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantUpstreamStateParamName:             "RelayState",
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name:                                   "SAML upstream happy path using GET with a CSRF cookie",
+			idpLister:                              oidctestutil.NewUpstreamIDPListerBuilder().WithSAML(&upstreamSAMLIdentityProvider).Build(),
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodGet,
+			path:                                   happyGetRequestPath,
+			csrfCookie:                             "__Host-pinniped-csrf=" + encodedIncomingCookieCSRFValue + " ",
+			wantStatus:                             http.StatusFound,
+			wantContentType:                        htmlContentType,
+			wantLocationHeader:                     expectedRedirectLocationForUpstreamSAML(expectedSAMLUpstreamStateParam(encodeQuery(happyGetRequestQueryMap), "", incomingCookieCSRFValue)),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantUpstreamStateParamName:             "RelayState",
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name:                        "SAML upstream happy path using GET with the HTTP-POST binding",
+			idpLister:                   oidctestutil.NewUpstreamIDPListerBuilder().WithSAML(&postBindingUpstreamSAMLIdentityProvider).Build(),
+			generateCSRF:                happyCSRFGenerator,
+			generatePKCE:                happyPKCEGenerator,
+			generateNonce:               happyNonceGenerator,
+			stateEncoder:                happyStateEncoder,
+			cookieEncoder:               happyCookieEncoder,
+			method:                      http.MethodGet,
+			path:                        happyGetRequestPath,
+			wantStatus:                  http.StatusOK,
+			wantContentType:             htmlContentType,
+			wantCSRFValueInCookieHeader: happyCSRF,
+			wantBodyContains: []string{
+				`<form method="post" action="https://some-saml-idp/sso/post">`,
+				`<input type="hidden" name="RelayState" value="`,
+				fmt.Sprintf(`<input type="hidden" name="SAMLRequest" value="%s">`,
+					html.EscapeString(fakeSAMLRequest("_"+happyNonce, downstreamIssuer, downstreamIssuer+"/saml/acs")),
+				),
+			},
+		},
+		{
+			name:                                   "SAML upstream happy path for a confirmed device authorization request",
+			idpLister:                              oidctestutil.NewUpstreamIDPListerBuilder().WithSAML(&upstreamSAMLIdentityProvider).Build(),
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodPost,
+			path:                                   "/some/path",
+			contentType:                            "application/x-www-form-urlencoded",
+			body:                                   encodeQuery(map[string]string{"pinniped_device_user_code": "WDJBMJHT", "pinniped_device_csrf": incomingCookieCSRFValue}),
+			csrfCookie:                             "__Host-pinniped-csrf=" + encodedIncomingCookieCSRFValue + " ",
+			wantStatus:                             http.StatusFound,
+			wantContentType:                        "",
+			wantBodyString:                         "",
+			wantLocationHeader:                     expectedRedirectLocationForUpstreamSAML(expectedSAMLUpstreamStateParam("", "WDJBMJHT", incomingCookieCSRFValue)),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantUpstreamStateParamName:             "RelayState",
+		},
+		{
+			name:            "SAML upstream error while creating the authentication request",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithSAML(&erroringUpstreamSAMLIdentityProvider).Build(),
+			generateCSRF:    happyCSRFGenerator,
+			generatePKCE:    happyPKCEGenerator,
+			generateNonce:   happyNonceGenerator,
+			stateEncoder:    happyStateEncoder,
+			cookieEncoder:   happyCookieEncoder,
+			method:          http.MethodGet,
+			path:            happyGetRequestPath,
+			wantStatus:      http.StatusInternalServerError,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBodyString:  "Internal Server Error: error creating SAML authentication request\n",
+		},
+		{
+			name:            "SAML upstream with an invalid downstream authorization request",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithSAML(&upstreamSAMLIdentityProvider).Build(),
+			method:          http.MethodGet,
+			path:            modifiedHappyGetRequestPath(map[string]string{"client_id": "invalid-client"}),
+			wantStatus:      http.StatusUnauthorized,
+			wantContentType: "application/json; charset=utf-8",
+			wantBodyJSON:    fositeInvalidClientErrorBody,
+		},
+		{
+			name:                                   "LDAP upstream for a confirmed device authorization request",
+			idpLister:                              oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(&upstreamLDAPIdentityProvider).Build(),
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodPost,
+			path:                                   "/some/path",
+			contentType:                            "application/x-www-form-urlencoded",
+			body:                                   encodeQuery(map[string]string{"pinniped_device_user_code": "WDJBMJHT", "pinniped_device_csrf": incomingCookieCSRFValue}),
+			csrfCookie:                             "__Host-pinniped-csrf=" + encodedIncomingCookieCSRFValue + " ",
+			wantStatus:                             http.StatusFound,
+			wantContentType:                        "",
+			wantBodyString:                         "",
+			wantLocationHeader:                     expectedRedirectLocationForLoginPage(expectedLDAPUpstreamStateParam("", "WDJBMJHT", incomingCookieCSRFValue, upstreamLDAPIdentityProvider.Name, "ldap")),
+			wantUpstreamStateParamInLocationHeader: true,
+		},
+		{
+			name:                                   "Active Directory upstream for a confirmed device authorization request",
+			idpLister:                              oidctestutil.NewUpstreamIDPListerBuilder().WithActiveDirectory(&upstreamActiveDirectoryIdentityProvider).Build(),
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodPost,
+			path:                                   "/some/path",
+			contentType:                            "application/x-www-form-urlencoded",
+			body:                                   encodeQuery(map[string]string{"pinniped_device_user_code": "WDJBMJHT", "pinniped_device_csrf": incomingCookieCSRFValue}),
+			csrfCookie:                             "__Host-pinniped-csrf=" + encodedIncomingCookieCSRFValue + " ",
+			wantStatus:                             http.StatusFound,
+			wantContentType:                        "",
+			wantBodyString:                         "",
+			wantLocationHeader:                     expectedRedirectLocationForLoginPage(expectedLDAPUpstreamStateParam("", "WDJBMJHT", incomingCookieCSRFValue, upstreamActiveDirectoryIdentityProvider.Name, "activedirectory")),
+			wantUpstreamStateParamInLocationHeader: true,
+		},
+		{
+			name:                                   "LDAP upstream browser flow happy path using GET without a CSRF cookie",
+			idpLister:                              oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(&upstreamLDAPIdentityProvider).Build(),
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodGet,
+			path:                                   happyGetRequestPath,
+			wantStatus:                             http.StatusFound,
+			wantContentType:                        htmlContentType,
+			wantCSRFValueInCookieHeader:            happyCSRF,
+			wantLocationHeader:                     expectedRedirectLocationForLoginPage(expectedLDAPUpstreamStateParam(encodeQuery(happyGetRequestQueryMap), "", "", upstreamLDAPIdentityProvider.Name, "ldap")),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name:                                   "LDAP upstream browser flow happy path using GET with a CSRF cookie",
+			idpLister:                              oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(&upstreamLDAPIdentityProvider).Build(),
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodGet,
+			path:                                   happyGetRequestPath,
+			csrfCookie:                             "__Host-pinniped-csrf=" + encodedIncomingCookieCSRFValue + " ",
+			wantStatus:                             http.StatusFound,
+			wantContentType:                        htmlContentType,
+			wantLocationHeader:                     expectedRedirectLocationForLoginPage(expectedLDAPUpstreamStateParam(encodeQuery(happyGetRequestQueryMap), "", incomingCookieCSRFValue, upstreamLDAPIdentityProvider.Name, "ldap")),
 			wantUpstreamStateParamInLocationHeader: true,
 			wantBodyStringWithLocationInHref:       true,
 		},
+		{
+			name:                                   "Active Directory upstream browser flow happy path using POST",
+			idpLister:                              oidctestutil.NewUpstreamIDPListerBuilder().WithActiveDirectory(&upstreamActiveDirectoryIdentityProvider).Build(),
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodPost,
+			path:                                   "/some/path",
+			contentType:                            "application/x-www-form-urlencoded",
+			body:                                   encodeQuery(happyGetRequestQueryMap),
+			wantStatus:                             http.StatusFound,
+			wantContentType:                        "",
+			wantCSRFValueInCookieHeader:            happyCSRF,
+			wantLocationHeader:                     expectedRedirectLocationForLoginPage(expectedLDAPUpstreamStateParam(encodeQuery(happyGetRequestQueryMap), "", "", upstreamActiveDirectoryIdentityProvider.Name, "activedirectory")),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantBodyString:                         "",
+		},
+		{
+			name:            "LDAP upstream browser flow error while generating the CSRF value",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(&upstreamLDAPIdentityProvider).Build(),
+			generateCSRF:    sadCSRFGenerator,
+			generatePKCE:    happyPKCEGenerator,
+			generateNonce:   happyNonceGenerator,
+			stateEncoder:    happyStateEncoder,
+			cookieEncoder:   happyCookieEncoder,
+			method:          http.MethodGet,
+			path:            happyGetRequestPath,
+			wantStatus:      http.StatusInternalServerError,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBodyString:  "Internal Server Error: error generating CSRF token\n",
+		},
+		{
+			name:            "device login confirmation without a CSRF cookie",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).Build(),
+			cookieEncoder:   happyCookieEncoder,
+			method:          http.MethodPost,
+			path:            "/some/path",
+			contentType:     "application/x-www-form-urlencoded",
+			body:            encodeQuery(map[string]string{"pinniped_device_user_code": "WDJBMJHT", "pinniped_device_csrf": incomingCookieCSRFValue}),
+			wantStatus:      http.StatusForbidden,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBodyString:  "Forbidden: device login confirmation has an invalid CSRF token\n",
+		},
+		{
+			name:            "device login confirmation with the wrong CSRF token",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).Build(),
+			cookieEncoder:   happyCookieEncoder,
+			method:          http.MethodPost,
+			path:            "/some/path",
+			contentType:     "application/x-www-form-urlencoded",
+			body:            encodeQuery(map[string]string{"pinniped_device_user_code": "WDJBMJHT", "pinniped_device_csrf": "wrong-csrf-value"}),
+			csrfCookie:      "__Host-pinniped-csrf=" + encodedIncomingCookieCSRFValue + " ",
+			wantStatus:      http.StatusForbidden,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBodyString:  "Forbidden: device login confirmation has an invalid CSRF token\n",
+		},
+		{
+			name:            "device login confirmation with the CSRF token in the query instead of the form",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).Build(),
+			cookieEncoder:   happyCookieEncoder,
+			method:          http.MethodPost,
+			path:            pathWithQuery("/some/path", map[string]string{"pinniped_device_csrf": incomingCookieCSRFValue}),
+			contentType:     "application/x-www-form-urlencoded",
+			body:            encodeQuery(map[string]string{"pinniped_device_user_code": "WDJBMJHT"}),
+			csrfCookie:      "__Host-pinniped-csrf=" + encodedIncomingCookieCSRFValue + " ",
+			wantStatus:      http.StatusForbidden,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBodyString:  "Forbidden: device login confirmation has an invalid CSRF token\n",
+		},
 		{
 			name:                              "LDAP upstream happy path using GET",
 			idpLister:                         oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(&upstreamLDAPIdentityProvider).Build(),
@@ -396,6 +962,29 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyLDAPCustomSessionData,
+		},
+		{
+			name:                              "Active Directory upstream happy path using GET",
+			idpLister:                         oidctestutil.NewUpstreamIDPListerBuilder().WithActiveDirectory(&upstreamActiveDirectoryIdentityProvider).Build(),
+			method:                            http.MethodGet,
+			path:                              happyGetRequestPath,
+			customUsernameHeader:              pointer.StringPtr(happyLDAPUsername),
+			customPasswordHeader:              pointer.StringPtr(happyLDAPPassword),
+			wantStatus:                        http.StatusFound,
+			wantContentType:                   htmlContentType,
+			wantRedirectLocationRegexp:        happyAuthcodeDownstreamRedirectLocationRegexp,
+			wantBodyStringWithLocationInHref:  false,
+			wantDownstreamIDTokenSubject:      upstreamLDAPURL + "&sub=" + happyLDAPUID,
+			wantDownstreamIDTokenUsername:     happyLDAPUsernameFromAuthenticator,
+			wantDownstreamIDTokenGroups:       happyLDAPGroups,
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamRedirectURI:         downstreamRedirectURI,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyActiveDirectoryCustomSessionData,
 		},
 		{
 			name:                                   "OIDC upstream happy path using GET with a CSRF cookie",
@@ -455,6 +1044,7 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyLDAPCustomSessionData,
 		},
 		{
 			name:                                   "OIDC upstream happy path with prompt param login passed through to redirect uri",
@@ -537,6 +1127,7 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyLDAPCustomSessionData,
 		},
 		{
 			name:                        "OIDC upstream happy path when downstream requested scopes include offline_access",
@@ -568,6 +1159,72 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			wantContentType:      htmlContentType,
 			wantBodyString:       "Bad Gateway: unexpected error during upstream authentication\n",
 		},
+		{
+			name: "LDAP upstream with identity transforms",
+			idpLister: oidctestutil.NewUpstreamIDPListerBuilder().
+				WithLDAP(&upstreamLDAPIdentityProvider).
+				WithIdentityTransforms(upstreamLDAPIdentityProvider.Name, "ldap", prefixLDAPTransforms).
+				Build(),
+			method:                            http.MethodGet,
+			path:                              happyGetRequestPath,
+			customUsernameHeader:              pointer.StringPtr(happyLDAPUsername),
+			customPasswordHeader:              pointer.StringPtr(happyLDAPPassword),
+			wantStatus:                        http.StatusFound,
+			wantContentType:                   htmlContentType,
+			wantRedirectLocationRegexp:        happyAuthcodeDownstreamRedirectLocationRegexp,
+			wantBodyStringWithLocationInHref:  false,
+			wantDownstreamIDTokenSubject:      upstreamLDAPURL + "&sub=" + happyLDAPUID,
+			wantDownstreamIDTokenUsername:     "ldap:" + happyLDAPUsernameFromAuthenticator,
+			wantDownstreamIDTokenGroups:       []string{"group1", "group2"},
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamRedirectURI:         downstreamRedirectURI,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyLDAPCustomSessionData,
+		},
+		{
+			name: "LDAP upstream login denied by identity transforms",
+			idpLister: oidctestutil.NewUpstreamIDPListerBuilder().
+				WithLDAP(&upstreamLDAPIdentityProvider).
+				WithIdentityTransforms(upstreamLDAPIdentityProvider.Name, "ldap", denyLoginLDAPTransforms).
+				Build(),
+			method:               http.MethodGet,
+			path:                 happyGetRequestPath,
+			customUsernameHeader: pointer.StringPtr(happyLDAPUsername),
+			customPasswordHeader: pointer.StringPtr(happyLDAPPassword),
+			wantStatus:           http.StatusFound,
+			wantContentType:      "application/json; charset=utf-8",
+			wantLocationHeader:   urlWithQuery(downstreamRedirectURI, fositeAccessDeniedWithIdentityTransformsHintErrorQuery),
+			wantBodyString:       "",
+		},
+		{
+			name: "Active Directory upstream with identity transforms",
+			idpLister: oidctestutil.NewUpstreamIDPListerBuilder().
+				WithActiveDirectory(&upstreamActiveDirectoryIdentityProvider).
+				WithIdentityTransforms(upstreamActiveDirectoryIdentityProvider.Name, "ldap", denyLoginLDAPTransforms).
+				WithIdentityTransforms(upstreamActiveDirectoryIdentityProvider.Name, "activedirectory", prefixLDAPTransforms).
+				Build(),
+			method:                            http.MethodGet,
+			path:                              happyGetRequestPath,
+			customUsernameHeader:              pointer.StringPtr(happyLDAPUsername),
+			customPasswordHeader:              pointer.StringPtr(happyLDAPPassword),
+			wantStatus:                        http.StatusFound,
+			wantContentType:                   htmlContentType,
+			wantRedirectLocationRegexp:        happyAuthcodeDownstreamRedirectLocationRegexp,
+			wantBodyStringWithLocationInHref:  false,
+			wantDownstreamIDTokenSubject:      upstreamLDAPURL + "&sub=" + happyLDAPUID,
+			wantDownstreamIDTokenUsername:     "ldap:" + happyLDAPUsernameFromAuthenticator,
+			wantDownstreamIDTokenGroups:       []string{"group1", "group2"},
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamRedirectURI:         downstreamRedirectURI,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyActiveDirectoryCustomSessionData,
+		},
 		{
 			name:                 "wrong upstream password for LDAP authentication",
 			idpLister:            oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(&upstreamLDAPIdentityProvider).Build(),
@@ -953,6 +1610,7 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyLDAPCustomSessionData,
 		},
 		{
 			name:               "downstream state does not have enough entropy using OIDC upstream",
@@ -1061,31 +1719,219 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			wantBodyString:  "Unprocessable Entity: No upstream providers are configured\n",
 		},
 		{
-			name:            "too many upstream providers are configured: multiple OIDC",
-			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider, &upstreamOIDCIdentityProvider).Build(), // more than one not allowed
+			name:            "multiple upstream providers are configured and none was requested shows the chooser page",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).WithLDAP(&upstreamLDAPIdentityProvider).Build(),
+			method:          http.MethodGet,
+			path:            happyGetRequestPath,
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBodyContains: []string{
+				"<h1>Choose an identity provider</h1>",
+				expectedChooserLink(upstreamLDAPIdentityProvider.Name, "ldap"),
+				expectedChooserLink(upstreamOIDCIdentityProvider.Name, "oidc"),
+			},
+		},
+		{
+			name:            "multiple upstream providers are configured and none was requested shows the chooser page using POST",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider, &otherUpstreamOIDCIdentityProvider).Build(),
+			method:          http.MethodPost,
+			path:            "/some/path",
+			contentType:     "application/x-www-form-urlencoded",
+			body:            encodeQuery(happyGetRequestQueryMap),
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBodyContains: []string{
+				expectedChooserLink(upstreamOIDCIdentityProvider.Name, "oidc"),
+				expectedChooserLink(otherUpstreamOIDCIdentityProvider.Name, "oidc"),
+			},
+		},
+		{
+			name:            "multiple upstream providers are configured and none was requested, but the downstream client does not exist",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).WithLDAP(&upstreamLDAPIdentityProvider).Build(),
+			method:          http.MethodGet,
+			path:            modifiedHappyGetRequestPath(map[string]string{"client_id": "invalid-client"}),
+			wantStatus:      http.StatusUnauthorized,
+			wantContentType: "application/json; charset=utf-8",
+			wantBodyJSON:    fositeInvalidClientErrorBody,
+		},
+		{
+			name:                                   "multiple upstream providers are configured and the OIDC upstream was requested by name and type",
+			idpLister:                              oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider, &otherUpstreamOIDCIdentityProvider).WithLDAP(&upstreamLDAPIdentityProvider).Build(),
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodGet,
+			path:                                   modifiedHappyGetRequestPath(oidcUpstreamChoiceParams),
+			wantStatus:                             http.StatusFound,
+			wantContentType:                        htmlContentType,
+			wantCSRFValueInCookieHeader:            happyCSRF,
+			wantLocationHeader:                     expectedRedirectLocationForUpstreamOIDC(expectedUpstreamStateParam(oidcUpstreamChoiceParams, "", ""), ""),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name:                                   "multiple upstream providers are configured and the OIDC upstream was requested by name only",
+			idpLister:                              oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).WithLDAP(&upstreamLDAPIdentityProvider).Build(),
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodGet,
+			path:                                   modifiedHappyGetRequestPath(map[string]string{"pinniped_idp_name": upstreamOIDCIdentityProvider.Name}),
+			wantStatus:                             http.StatusFound,
+			wantContentType:                        htmlContentType,
+			wantCSRFValueInCookieHeader:            happyCSRF,
+			wantLocationHeader:                     expectedRedirectLocationForUpstreamOIDC(expectedUpstreamStateParam(map[string]string{"pinniped_idp_name": upstreamOIDCIdentityProvider.Name}, "", ""), ""),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name:                              "multiple upstream providers are configured and the LDAP upstream was requested by name and type",
+			idpLister:                         oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).WithLDAP(&upstreamLDAPIdentityProvider).Build(),
+			method:                            http.MethodGet,
+			path:                              modifiedHappyGetRequestPath(ldapUpstreamChoiceParams),
+			customUsernameHeader:              pointer.StringPtr(happyLDAPUsername),
+			customPasswordHeader:              pointer.StringPtr(happyLDAPPassword),
+			wantStatus:                        http.StatusFound,
+			wantContentType:                   htmlContentType,
+			wantRedirectLocationRegexp:        happyAuthcodeDownstreamRedirectLocationRegexp,
+			wantBodyStringWithLocationInHref:  false,
+			wantDownstreamIDTokenSubject:      upstreamLDAPURL + "&sub=" + happyLDAPUID,
+			wantDownstreamIDTokenUsername:     happyLDAPUsernameFromAuthenticator,
+			wantDownstreamIDTokenGroups:       happyLDAPGroups,
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamRedirectURI:         downstreamRedirectURI,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyLDAPCustomSessionData,
+		},
+		{
+			name:                              "multiple upstream providers are configured and the Active Directory upstream was requested by name and type",
+			idpLister:                         oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).WithLDAP(&upstreamLDAPIdentityProvider).WithActiveDirectory(&upstreamActiveDirectoryIdentityProvider).Build(),
+			method:                            http.MethodGet,
+			path:                              modifiedHappyGetRequestPath(activeDirectoryUpstreamChoiceParams),
+			customUsernameHeader:              pointer.StringPtr(happyLDAPUsername),
+			customPasswordHeader:              pointer.StringPtr(happyLDAPPassword),
+			wantStatus:                        http.StatusFound,
+			wantContentType:                   htmlContentType,
+			wantRedirectLocationRegexp:        happyAuthcodeDownstreamRedirectLocationRegexp,
+			wantBodyStringWithLocationInHref:  false,
+			wantDownstreamIDTokenSubject:      upstreamLDAPURL + "&sub=" + happyLDAPUID,
+			wantDownstreamIDTokenUsername:     happyLDAPUsernameFromAuthenticator,
+			wantDownstreamIDTokenGroups:       happyLDAPGroups,
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamRedirectURI:         downstreamRedirectURI,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyActiveDirectoryCustomSessionData,
+		},
+		{
+			name:                                   "multiple upstream providers are configured and the GitHub upstream was requested by name and type",
+			idpLister:                              oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).WithGitHub(&gitHubUpstreamWithSameNameAsOIDCUpstream).Build(),
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodGet,
+			path:                                   modifiedHappyGetRequestPath(gitHubUpstreamChoiceParams),
+			wantStatus:                             http.StatusFound,
+			wantContentType:                        htmlContentType,
+			wantCSRFValueInCookieHeader:            happyCSRF,
+			wantLocationHeader:                     expectedRedirectLocationForUpstreamOIDC(expectedGitHubUpstreamStateParam(gitHubUpstreamChoiceParams), ""),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name:                                   "multiple upstream providers are configured and the SAML upstream was requested by name and type",
+			idpLister:                              oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).WithSAML(&upstreamSAMLIdentityProvider).Build(),
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodGet,
+			path:                                   modifiedHappyGetRequestPath(samlUpstreamChoiceParams),
+			wantStatus:                             http.StatusFound,
+			wantContentType:                        htmlContentType,
+			wantCSRFValueInCookieHeader:            happyCSRF,
+			wantLocationHeader:                     expectedRedirectLocationForUpstreamSAML(expectedSAMLUpstreamStateParam(encodeQuery(modifiedHappyGetRequestQueryMap(samlUpstreamChoiceParams)), "", "")),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantUpstreamStateParamName:             "RelayState",
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name:            "multiple upstream providers are configured including SAML and none was requested shows the chooser page",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).WithSAML(&upstreamSAMLIdentityProvider).Build(),
 			method:          http.MethodGet,
 			path:            happyGetRequestPath,
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBodyContains: []string{
+				expectedChooserLink(upstreamOIDCIdentityProvider.Name, "oidc"),
+				expectedChooserLink(upstreamSAMLIdentityProvider.Name, "saml"),
+			},
+		},
+		{
+			name:            "requested upstream provider name does not exist",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).WithLDAP(&upstreamLDAPIdentityProvider).Build(),
+			method:          http.MethodGet,
+			path:            modifiedHappyGetRequestPath(map[string]string{"pinniped_idp_name": "does-not-exist"}),
 			wantStatus:      http.StatusUnprocessableEntity,
 			wantContentType: "text/plain; charset=utf-8",
-			wantBodyString:  "Unprocessable Entity: Too many upstream providers are configured (support for multiple upstreams is not yet implemented)\n",
+			wantBodyString:  "Unprocessable Entity: Requested upstream provider was not found\n",
 		},
 		{
-			name:            "too many upstream providers are configured: multiple LDAP",
-			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(&upstreamLDAPIdentityProvider, &upstreamLDAPIdentityProvider).Build(), // more than one not allowed
+			name:            "requested upstream provider exists by name but not with the requested type",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).WithLDAP(&upstreamLDAPIdentityProvider).Build(),
 			method:          http.MethodGet,
-			path:            happyGetRequestPath,
+			path:            modifiedHappyGetRequestPath(map[string]string{"pinniped_idp_name": upstreamOIDCIdentityProvider.Name, "pinniped_idp_type": "ldap"}),
 			wantStatus:      http.StatusUnprocessableEntity,
 			wantContentType: "text/plain; charset=utf-8",
-			wantBodyString:  "Unprocessable Entity: Too many upstream providers are configured (support for multiple upstreams is not yet implemented)\n",
+			wantBodyString:  "Unprocessable Entity: Requested upstream provider was not found\n",
 		},
 		{
-			name:            "too many upstream providers are configured: both OIDC and LDAP",
-			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).WithLDAP(&upstreamLDAPIdentityProvider).Build(), // more than one not allowed
+			name:            "requested upstream provider type is invalid",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).WithLDAP(&upstreamLDAPIdentityProvider).Build(),
 			method:          http.MethodGet,
-			path:            happyGetRequestPath,
+			path:            modifiedHappyGetRequestPath(map[string]string{"pinniped_idp_name": upstreamOIDCIdentityProvider.Name, "pinniped_idp_type": "kerberos"}),
+			wantStatus:      http.StatusBadRequest,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBodyString:  "Bad Request: pinniped_idp_type param must be one of: oidc, ldap, activedirectory, github, saml\n",
+		},
+		{
+			name:            "requested upstream provider type without a name",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).WithLDAP(&upstreamLDAPIdentityProvider).Build(),
+			method:          http.MethodGet,
+			path:            modifiedHappyGetRequestPath(map[string]string{"pinniped_idp_type": "oidc"}),
+			wantStatus:      http.StatusBadRequest,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBodyString:  "Bad Request: pinniped_idp_type param requires the pinniped_idp_name param\n",
+		},
+		{
+			name:            "requested upstream provider name is ambiguous without a type",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).WithLDAP(&ldapUpstreamWithSameNameAsOIDCUpstream).Build(),
+			method:          http.MethodGet,
+			path:            modifiedHappyGetRequestPath(map[string]string{"pinniped_idp_name": upstreamOIDCIdentityProvider.Name}),
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBodyString:  "Unprocessable Entity: More than one upstream provider is named \"some-oidc-idp\" (use the pinniped_idp_type param to choose one)\n",
+		},
+		{
+			name:            "requested upstream provider name is ambiguous between LDAP and Active Directory without a type",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(&upstreamLDAPIdentityProvider).WithActiveDirectory(&activeDirectoryUpstreamWithSameNameAsLDAPUpstream).Build(),
+			method:          http.MethodGet,
+			path:            modifiedHappyGetRequestPath(map[string]string{"pinniped_idp_name": upstreamLDAPIdentityProvider.Name}),
 			wantStatus:      http.StatusUnprocessableEntity,
 			wantContentType: "text/plain; charset=utf-8",
-			wantBodyString:  "Unprocessable Entity: Too many upstream providers are configured (support for multiple upstreams is not yet implemented)\n",
+			wantBodyString:  "Unprocessable Entity: More than one upstream provider is named \"some-ldap-idp\" (use the pinniped_idp_type param to choose one)\n",
 		},
 		{
 			name:            "PUT is a bad method",
@@ -1140,13 +1986,19 @@ func TestAuthorizationEndpoint(t *testing.T) {
 		actualLocation := rsp.Header().Get("Location")
 		switch {
 		case test.wantLocationHeader != "":
+			upstreamStateParamName := "state"
+			if test.wantUpstreamStateParamName != "" {
+				upstreamStateParamName = test.wantUpstreamStateParamName
+			}
+			ignoredParamName := ""
 			if test.wantUpstreamStateParamInLocationHeader {
-				requireEqualDecodedStateParams(t, actualLocation, test.wantLocationHeader, test.stateEncoder)
+				requireEqualDecodedStateParams(t, actualLocation, test.wantLocationHeader, upstreamStateParamName, test.stateEncoder)
+				ignoredParamName = upstreamStateParamName
 			}
 			// The upstream state param is encoded using a timestamp at the beginning so we don't want to
 			// compare those states since they may be different, but we do want to compare the downstream
 			// state param that should be exactly the same.
-			requireEqualURLs(t, actualLocation, test.wantLocationHeader, test.wantUpstreamStateParamInLocationHeader)
+			requireEqualURLs(t, actualLocation, test.wantLocationHeader, ignoredParamName)
 
 			// Authorization requests for either a successful OIDC upstream or for an error with any upstream
 			// should never use Kube storage. There is only one exception to this rule, which is that certain
@@ -1173,6 +2025,7 @@ func TestAuthorizationEndpoint(t *testing.T) {
 				test.wantDownstreamNonce,
 				downstreamClientID,
 				test.wantDownstreamRedirectURI,
+				test.wantDownstreamCustomSessionData,
 			)
 		default:
 			require.Empty(t, rsp.Header().Values("Location"))
@@ -1181,6 +2034,10 @@ func TestAuthorizationEndpoint(t *testing.T) {
 		switch {
 		case test.wantBodyJSON != "":
 			require.JSONEq(t, test.wantBodyJSON, rsp.Body.String())
+		case test.wantBodyContains != nil:
+			for _, wantContains := range test.wantBodyContains {
+				require.Contains(t, rsp.Body.String(), wantContains)
+			}
 		case test.wantBodyStringWithLocationInHref:
 			anchorTagWithLocationHref := fmt.Sprintf("<a href=\"%s\">Found</a>.\n\n", html.EscapeString(actualLocation))
 			require.Equal(t, anchorTagWithLocationHref, rsp.Body.String())
@@ -1210,10 +2067,19 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			kubeClient := fake.NewSimpleClientset()
 			secretsClient := kubeClient.CoreV1().Secrets("some-namespace")
 			oauthHelperWithRealStorage, kubeOauthStore := createOauthHelperWithRealStorage(secretsClient)
+			if test.deviceCodeSessionTTL != 0 {
+				// Simulate the device authorization endpoint having already run.
+				request := fosite.NewRequest()
+				request.Client = clientregistry.PinnipedCLI()
+				request.Session = psession.NewPinnipedSession()
+				require.NoError(t, kubeOauthStore.CreateDeviceCodeSession(context.Background(), "some-signature", "WDJB-MJHT", time.Now().Add(test.deviceCodeSessionTTL), request))
+				kubeClient.ClearActions()
+			}
 			subject := NewHandler(
 				downstreamIssuer,
 				test.idpLister,
 				oauthHelperWithNullStorage, oauthHelperWithRealStorage,
+				kubeOauthStore,
 				test.generateCSRF, test.generatePKCE, test.generateNonce,
 				test.stateEncoder, test.cookieEncoder,
 			)
@@ -1232,6 +2098,7 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			downstreamIssuer,
 			test.idpLister,
 			oauthHelperWithNullStorage, oauthHelperWithRealStorage,
+			kubeOauthStore,
 			test.generateCSRF, test.generatePKCE, test.generateNonce,
 			test.stateEncoder, test.cookieEncoder,
 		)
@@ -1282,20 +2149,20 @@ func (*errorReturningEncoder) Encode(_ string, _ interface{}) (string, error) {
 	return "", fmt.Errorf("some encoding error")
 }
 
-func requireEqualDecodedStateParams(t *testing.T, actualURL string, expectedURL string, stateParamDecoder oidc.Codec) {
+func requireEqualDecodedStateParams(t *testing.T, actualURL string, expectedURL string, stateParamName string, stateParamDecoder oidc.Codec) {
 	t.Helper()
 	actualLocationURL, err := url.Parse(actualURL)
 	require.NoError(t, err)
 	expectedLocationURL, err := url.Parse(expectedURL)
 	require.NoError(t, err)
 
-	expectedQueryStateParam := expectedLocationURL.Query().Get("state")
+	expectedQueryStateParam := expectedLocationURL.Query().Get(stateParamName)
 	require.NotEmpty(t, expectedQueryStateParam)
 	var expectedDecodedStateParam oidctestutil.ExpectedUpstreamStateParamFormat
 	err = stateParamDecoder.Decode("s", expectedQueryStateParam, &expectedDecodedStateParam)
 	require.NoError(t, err)
 
-	actualQueryStateParam := actualLocationURL.Query().Get("state")
+	actualQueryStateParam := actualLocationURL.Query().Get(stateParamName)
 	require.NotEmpty(t, actualQueryStateParam)
 	var actualDecodedStateParam oidctestutil.ExpectedUpstreamStateParamFormat
 	err = stateParamDecoder.Decode("s", actualQueryStateParam, &actualDecodedStateParam)
@@ -1304,7 +2171,7 @@ func requireEqualDecodedStateParams(t *testing.T, actualURL string, expectedURL
 	require.Equal(t, expectedDecodedStateParam, actualDecodedStateParam)
 }
 
-func requireEqualURLs(t *testing.T, actualURL string, expectedURL string, ignoreState bool) {
+func requireEqualURLs(t *testing.T, actualURL string, expectedURL string, ignoredParamName string) {
 	t.Helper()
 	actualLocationURL, err := url.Parse(actualURL)
 	require.NoError(t, err)
@@ -1329,9 +2196,9 @@ func requireEqualURLs(t *testing.T, actualURL string, expectedURL string, ignore
 	actualLocationQuery := actualLocationURL.Query()
 	// Let the caller ignore the state, since it may contain a digest at the end that is difficult to
 	// predict because it depends on a time.Now() timestamp.
-	if ignoreState {
-		expectedLocationQuery.Del("state")
-		actualLocationQuery.Del("state")
+	if ignoredParamName != "" {
+		expectedLocationQuery.Del(ignoredParamName)
+		actualLocationQuery.Del(ignoredParamName)
 	}
 	require.Equal(t, expectedLocationQuery, actualLocationQuery)
 }
@@ -6,33 +6,35 @@ package callback
 
 import (
 	"crypto/subtle"
-	"fmt"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/ory/fosite"
+	"github.com/pkg/errors"
 
+	"go.pinniped.dev/internal/auditlog"
+	"go.pinniped.dev/internal/fositestorage/devicecode"
 	"go.pinniped.dev/internal/httputil/httperr"
 	"go.pinniped.dev/internal/httputil/securityheader"
+	"go.pinniped.dev/internal/metrics"
 	"go.pinniped.dev/internal/oidc"
 	"go.pinniped.dev/internal/oidc/csrftoken"
 	"go.pinniped.dev/internal/oidc/downstreamsession"
+	"go.pinniped.dev/internal/oidc/idpdiscovery"
 	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/oidc/provider/deviceverificationhtml"
 	"go.pinniped.dev/internal/oidc/provider/formposthtml"
 	"go.pinniped.dev/internal/plog"
-)
-
-const (
-	// The name of the email claim from https://openid.net/specs/openid-connect-core-1_0.html#StandardClaims
-	emailClaimName = "email"
-
-	// The name of the email_verified claim from https://openid.net/specs/openid-connect-core-1_0.html#StandardClaims
-	emailVerifiedClaimName = "email_verified"
+	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/pkg/oidcclient/oidctypes"
 )
 
 func NewHandler(
-	upstreamIDPs oidc.UpstreamOIDCIdentityProvidersLister,
+	downstreamIssuer string,
+	upstreamIDPs oidc.UpstreamIdentityProvidersLister,
 	oauthHelper fosite.OAuth2Provider,
+	deviceCodeStorage devicecode.DeviceCodeStorage,
 	stateDecoder, cookieDecoder oidc.Decoder,
 	redirectURI string,
 ) http.Handler {
@@ -42,64 +44,244 @@ func NewHandler(
 			return err
 		}
 
-		upstreamIDPConfig := findUpstreamIDPConfig(state.UpstreamName, upstreamIDPs)
+		upstreamIDPConfig, upstreamType := findUpstreamIDPConfig(state.UpstreamName, state.UpstreamType, upstreamIDPs)
 		if upstreamIDPConfig == nil {
 			plog.Warning("upstream provider not found")
 			return httperr.New(http.StatusUnprocessableEntity, "upstream provider not found")
 		}
 
-		downstreamAuthParams, err := url.ParseQuery(state.AuthParams)
-		if err != nil {
-			plog.Error("error reading state downstream auth params", err)
-			return httperr.New(http.StatusBadRequest, "error reading state downstream auth params")
+		makeSession := func(clientID string) (*psession.PinnipedSession, error) {
+			return makeDownstreamSessionFromUpstream(r, downstreamIssuer, clientID, upstreamIDPs, upstreamIDPConfig, upstreamType, state, redirectURI)
 		}
 
-		// Recreate enough of the original authorize request so we can pass it to NewAuthorizeRequest().
-		reconstitutedAuthRequest := &http.Request{Form: downstreamAuthParams}
-		authorizeRequester, err := oauthHelper.NewAuthorizeRequest(r.Context(), reconstitutedAuthRequest)
-		if err != nil {
-			plog.Error("error using state downstream auth params", err)
-			return httperr.New(http.StatusBadRequest, "error using state downstream auth params")
+		if state.DeviceUserCode != "" {
+			// This login was started by the device verification endpoint, so there is no downstream authorization
+			// request. Instead, approve the device authorization request.
+			return handleDeviceCallback(r, w, deviceCodeStorage, state.DeviceUserCode, upstreamIDPConfig.GetName(), makeSession)
 		}
 
-		// Automatically grant the openid, offline_access, and pinniped:request-audience scopes, but only if they were requested.
-		downstreamsession.GrantScopesIfRequested(authorizeRequester)
+		return finishDownstreamAuthorize(r, w, oauthHelper, state.AuthParams, upstreamIDPConfig.GetName(), makeSession)
+	})
+	return securityheader.WrapWithCustomCSP(handler, formposthtml.ContentSecurityPolicy())
+}
 
-		token, err := upstreamIDPConfig.ExchangeAuthcodeAndValidateTokens(
-			r.Context(),
-			authcode(r),
-			state.PKCECode,
-			state.Nonce,
-			redirectURI,
-		)
-		if err != nil {
-			plog.WarningErr("error exchanging and validating upstream tokens", err, "upstreamName", upstreamIDPConfig.GetName())
-			return httperr.New(http.StatusBadGateway, "error exchanging and validating upstream tokens")
-		}
+// finishDownstreamAuthorize recreates the downstream authorization request from the params which were saved in the
+// upstream state param, and then responds to it with a downstream authcode for the session made by makeSession.
+func finishDownstreamAuthorize(
+	r *http.Request,
+	w http.ResponseWriter,
+	oauthHelper fosite.OAuth2Provider,
+	authParams string,
+	upstreamName string,
+	makeSession func(clientID string) (*psession.PinnipedSession, error),
+) error {
+	downstreamAuthParams, err := url.ParseQuery(authParams)
+	if err != nil {
+		plog.Error("error reading state downstream auth params", err)
+		return httperr.New(http.StatusBadRequest, "error reading state downstream auth params")
+	}
 
-		subject, username, err := getSubjectAndUsernameFromUpstreamIDToken(upstreamIDPConfig, token.IDToken.Claims)
-		if err != nil {
-			return err
-		}
+	// Recreate enough of the original authorize request so we can pass it to NewAuthorizeRequest().
+	reconstitutedAuthRequest := &http.Request{Form: downstreamAuthParams}
+	authorizeRequester, err := oauthHelper.NewAuthorizeRequest(r.Context(), reconstitutedAuthRequest)
+	if err != nil {
+		plog.Error("error using state downstream auth params", err)
+		return httperr.New(http.StatusBadRequest, "error using state downstream auth params")
+	}
 
-		groups, err := getGroupsFromUpstreamIDToken(upstreamIDPConfig, token.IDToken.Claims)
-		if err != nil {
-			return err
+	// Automatically grant the openid, offline_access, and pinniped:request-audience scopes, but only if they were requested.
+	downstreamsession.GrantScopesIfRequested(authorizeRequester)
+
+	openIDSession, err := makeSession(authorizeRequester.GetClient().GetID())
+	if err != nil {
+		return err
+	}
+
+	authorizeResponder, err := oauthHelper.NewAuthorizeResponse(r.Context(), authorizeRequester, openIDSession)
+	if err != nil {
+		plog.WarningErr("error while generating and saving authcode", err, "upstreamName", upstreamName)
+		return httperr.Wrap(http.StatusInternalServerError, "error while generating and saving authcode", err)
+	}
+
+	oauthHelper.WriteAuthorizeResponse(w, authorizeRequester, authorizeResponder)
+
+	return nil
+}
+
+// makeDownstreamSessionFromUpstream redeems the upstream authcode and makes a downstream session for the upstream user.
+// The result of the login is recorded in the metrics of the downstream issuer and in the audit log.
+func makeDownstreamSessionFromUpstream(
+	r *http.Request,
+	downstreamIssuer string,
+	clientID string,
+	transformsLister oidc.IdentityTransformsLister,
+	upstreamIDPConfig provider.UpstreamOIDCIdentityProviderI,
+	upstreamType psession.ProviderType,
+	state *oidc.UpstreamStateParamData,
+	redirectURI string,
+) (*psession.PinnipedSession, error) {
+	recordLogin := func(result string) {
+		metrics.RecordLogin(downstreamIssuer, upstreamIDPConfig.GetName(), string(upstreamType), result)
+	}
+	recordAuditEvent := func(outcome auditlog.Outcome, reason string, user *auditlog.User) {
+		auditlog.Record(auditlog.Event{
+			Type:                     auditlog.EventTypeSupervisorCallback,
+			Outcome:                  outcome,
+			Reason:                   reason,
+			SourceIP:                 auditlog.SourceIP(r),
+			ForwardedFor:             auditlog.ForwardedFor(r),
+			FederationDomain:         downstreamIssuer,
+			ClientID:                 clientID,
+			UpstreamIdentityProvider: &auditlog.IdentityProvider{Name: upstreamIDPConfig.GetName(), Type: string(upstreamType)},
+			User:                     user,
+		})
+	}
+
+	token, err := upstreamIDPConfig.ExchangeAuthcodeAndValidateTokens(
+		r.Context(),
+		authcode(r),
+		state.PKCECode,
+		state.Nonce,
+		redirectURI,
+	)
+	if err != nil {
+		plog.WarningErr("error exchanging and validating upstream tokens", err, "upstreamName", upstreamIDPConfig.GetName())
+		recordLogin(metrics.ResultError)
+		recordAuditEvent(auditlog.OutcomeError, err.Error(), nil)
+		return nil, httperr.New(http.StatusBadGateway, "error exchanging and validating upstream tokens")
+	}
+
+	subject, username, err := downstreamsession.GetSubjectAndUsernameFromUpstreamIDToken(upstreamIDPConfig, token.IDToken.Claims)
+	if err != nil {
+		recordLogin(metrics.ResultError)
+		recordAuditEvent(auditlog.OutcomeError, err.Error(), nil)
+		return nil, err
+	}
+
+	groups, err := downstreamsession.GetGroupsFromUpstreamIDToken(upstreamIDPConfig, token.IDToken.Claims)
+	if err != nil {
+		recordLogin(metrics.ResultError)
+		recordAuditEvent(auditlog.OutcomeError, err.Error(), &auditlog.User{Username: username})
+		return nil, err
+	}
+
+	transforms := transformsLister.GetIdentityTransforms(upstreamIDPConfig.GetName(), string(upstreamType))
+	upstreamUser := &auditlog.User{Username: username, Groups: groups}
+	username, groups, err = transforms.Evaluate(username, groups)
+	if err != nil {
+		plog.Info("identity transforms denied the login", "upstreamName", upstreamIDPConfig.GetName(), "reason", err.Error())
+		recordLogin(metrics.ResultFailure)
+		recordAuditEvent(auditlog.OutcomeFailure, err.Error(), upstreamUser)
+		return nil, httperr.New(http.StatusForbidden, "login denied by identity transforms")
+	}
+
+	customSessionData := makeCustomSessionData(upstreamIDPConfig, upstreamType, token)
+
+	recordLogin(metrics.ResultSuccess)
+	recordAuditEvent(auditlog.OutcomeSuccess, "", &auditlog.User{Username: username, Groups: groups})
+	return downstreamsession.MakeDownstreamSession(subject, username, groups, customSessionData), nil
+}
+
+// makeCustomSessionData returns the data which is needed to check with the upstream IDP again during a downstream
+// refresh. That is the upstream refresh token for OIDC providers. GitHub does not issue refresh tokens to OAuth Apps,
+// so the upstream access token is used to look up the user again instead.
+func makeCustomSessionData(
+	upstreamIDPConfig provider.UpstreamOIDCIdentityProviderI,
+	upstreamType psession.ProviderType,
+	token *oidctypes.Token,
+) *psession.CustomSessionData {
+	if upstreamType == psession.ProviderTypeGitHub {
+		// GitHub does not issue ID tokens, so the access token is what is needed to check the user again during a refresh.
+		var upstreamAccessToken string
+		if token.AccessToken != nil {
+			upstreamAccessToken = token.AccessToken.Token
 		}
+		return &psession.CustomSessionData{
+			ProviderName: upstreamIDPConfig.GetName(),
+			ProviderType: psession.ProviderTypeGitHub,
+			GitHub:       &psession.GitHubSessionData{UpstreamAccessToken: upstreamAccessToken},
+		}
+	}
 
-		openIDSession := downstreamsession.MakeDownstreamSession(subject, username, groups)
+	var upstreamRefreshToken string
+	if token.RefreshToken != nil {
+		upstreamRefreshToken = token.RefreshToken.Token
+	}
+	if upstreamRefreshToken == "" {
+		plog.Warning(
+			"upstream provider did not return a refresh token, so the downstream session will not be refreshable (try adding offline_access to the additionalScopes of the provider)",
+			"upstreamName", upstreamIDPConfig.GetName(),
+		)
+	}
+	upstreamIssuer, _ := token.IDToken.Claims[oidc.IDTokenIssuerClaim].(string)
+
+	return &psession.CustomSessionData{
+		ProviderName: upstreamIDPConfig.GetName(),
+		ProviderType: psession.ProviderTypeOIDC,
+		OIDC: &psession.OIDCSessionData{
+			UpstreamRefreshToken: upstreamRefreshToken,
+			UpstreamIssuer:       upstreamIssuer,
+		},
+	}
+}
 
-		authorizeResponder, err := oauthHelper.NewAuthorizeResponse(r.Context(), authorizeRequester, openIDSession)
-		if err != nil {
-			plog.WarningErr("error while generating and saving authcode", err, "upstreamName", upstreamIDPConfig.GetName())
-			return httperr.Wrap(http.StatusInternalServerError, "error while generating and saving authcode", err)
+// handleDeviceCallback approves the device authorization request which has the given user code, using the session
+// made by makeSession, and tells the end user that their device has been logged in.
+func handleDeviceCallback(
+	r *http.Request,
+	w http.ResponseWriter,
+	deviceCodeStorage devicecode.DeviceCodeStorage,
+	deviceUserCode string,
+	upstreamName string,
+	makeSession func(clientID string) (*psession.PinnipedSession, error),
+) error {
+	signature, session, err := deviceCodeStorage.GetDeviceCodeSessionByUserCode(r.Context(), deviceUserCode)
+	if errors.Is(err, fosite.ErrNotFound) {
+		plog.Info("device authorization request not found")
+		return httperr.New(http.StatusUnprocessableEntity, "device authorization request not found")
+	}
+	if err != nil {
+		plog.Error("error reading device authorization request", err)
+		return httperr.Wrap(http.StatusInternalServerError, "error reading device authorization request", err)
+	}
+
+	if session.Status != devicecode.StatusPending || time.Now().After(session.ExpiresAt) {
+		plog.Info("device authorization request has expired or was already used")
+		return httperr.New(http.StatusUnprocessableEntity, "device authorization request has expired or was already used")
+	}
+
+	openIDSession, err := makeSession(session.Request.GetClient().GetID())
+	if errors.Is(err, errUsernamePasswordNotAccepted) {
+		// The end user may try again with another username and password, so leave the request pending.
+		return err
+	}
+	if err != nil {
+		// The end user could not log in, so also let the polling device know that its request was denied.
+		session.Status = devicecode.StatusDenied
+		if updateErr := deviceCodeStorage.UpdateDeviceCodeSession(r.Context(), signature, session); updateErr != nil {
+			plog.Error("error while denying device authorization request", updateErr)
 		}
+		return err
+	}
 
-		oauthHelper.WriteAuthorizeResponse(w, authorizeRequester, authorizeResponder)
+	// Automatically grant the openid, offline_access, and pinniped:request-audience scopes, but only if they were requested.
+	session.Request.Session = openIDSession
+	downstreamsession.GrantScopesIfRequested(session.Request)
+	session.Status = devicecode.StatusApproved
+	if err := deviceCodeStorage.UpdateDeviceCodeSession(r.Context(), signature, session); err != nil {
+		plog.WarningErr("error while approving device authorization request", err, "upstreamName", upstreamName)
+		return httperr.Wrap(http.StatusInternalServerError, "error while approving device authorization request", err)
+	}
 
-		return nil
-	})
-	return securityheader.WrapWithCustomCSP(handler, formposthtml.ContentSecurityPolicy())
+	// This page has its own inline CSS, so override the default CSP header which was already set by the wrapper.
+	w.Header().Set("Content-Security-Policy", deviceverificationhtml.ContentSecurityPolicy())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := deviceverificationhtml.Template().Execute(w, &deviceverificationhtml.PageData{Success: true}); err != nil {
+		// It's too late to return an error response at this point, so just log it.
+		plog.Error("error rendering device verification page", err)
+	}
+	return nil
 }
 
 func authcode(r *http.Request) string {
@@ -127,7 +309,7 @@ func validateRequest(r *http.Request, stateDecoder, cookieDecoder oidc.Decoder)
 		return nil, httperr.New(http.StatusBadRequest, "state param not found")
 	}
 
-	state, err := readState(r, stateDecoder)
+	state, err := readState(r.FormValue("state"), stateDecoder)
 	if err != nil {
 		plog.InfoErr("error reading state", err)
 		return nil, err
@@ -141,13 +323,23 @@ func validateRequest(r *http.Request, stateDecoder, cookieDecoder oidc.Decoder)
 	return state, nil
 }
 
-func findUpstreamIDPConfig(upstreamName string, upstreamIDPs oidc.UpstreamOIDCIdentityProvidersLister) provider.UpstreamOIDCIdentityProviderI {
-	for _, p := range upstreamIDPs.GetOIDCIdentityProviders() {
+// findUpstreamIDPConfig finds the upstream IDP which was named in the state param, along with its type. The type
+// is only included in the state param for upstreams which are not OIDC providers.
+func findUpstreamIDPConfig(upstreamName string, upstreamType string, upstreamIDPs oidc.UpstreamIdentityProvidersLister) (provider.UpstreamOIDCIdentityProviderI, psession.ProviderType) {
+	upstreams, providerType := upstreamIDPs.GetOIDCIdentityProviders(), psession.ProviderTypeOIDC
+	switch upstreamType {
+	case "":
+	case idpdiscovery.IDPTypeGitHub:
+		upstreams, providerType = upstreamIDPs.GetGitHubIdentityProviders(), psession.ProviderTypeGitHub
+	default:
+		return nil, ""
+	}
+	for _, p := range upstreams {
 		if p.GetName() == upstreamName {
-			return p
+			return p, providerType
 		}
 	}
-	return nil
+	return nil, ""
 }
 
 func readCSRFCookie(r *http.Request, cookieDecoder oidc.Decoder) (csrftoken.CSRFToken, error) {
@@ -166,11 +358,11 @@ func readCSRFCookie(r *http.Request, cookieDecoder oidc.Decoder) (csrftoken.CSRF
 	return csrfFromCookie, nil
 }
 
-func readState(r *http.Request, stateDecoder oidc.Decoder) (*oidc.UpstreamStateParamData, error) {
+func readState(encodedState string, stateDecoder oidc.Decoder) (*oidc.UpstreamStateParamData, error) {
 	var state oidc.UpstreamStateParamData
 	if err := stateDecoder.Decode(
 		oidc.UpstreamStateParamEncodingName,
-		r.FormValue("state"),
+		encodedState,
 		&state,
 	); err != nil {
 		return nil, httperr.New(http.StatusBadRequest, "error reading state")
@@ -182,166 +374,3 @@ func readState(r *http.Request, stateDecoder oidc.Decoder) (*oidc.UpstreamStateP
 
 	return &state, nil
 }
-
-func getSubjectAndUsernameFromUpstreamIDToken(
-	upstreamIDPConfig provider.UpstreamOIDCIdentityProviderI,
-	idTokenClaims map[string]interface{},
-) (string, string, error) {
-	// The spec says the "sub" claim is only unique per issuer,
-	// so we will prepend the issuer string to make it globally unique.
-	upstreamIssuer := idTokenClaims[oidc.IDTokenIssuerClaim]
-	if upstreamIssuer == "" {
-		plog.Warning(
-			"issuer claim in upstream ID token missing",
-			"upstreamName", upstreamIDPConfig.GetName(),
-			"issClaim", upstreamIssuer,
-		)
-		return "", "", httperr.New(http.StatusUnprocessableEntity, "issuer claim in upstream ID token missing")
-	}
-	upstreamIssuerAsString, ok := upstreamIssuer.(string)
-	if !ok {
-		plog.Warning(
-			"issuer claim in upstream ID token has invalid format",
-			"upstreamName", upstreamIDPConfig.GetName(),
-			"issClaim", upstreamIssuer,
-		)
-		return "", "", httperr.New(http.StatusUnprocessableEntity, "issuer claim in upstream ID token has invalid format")
-	}
-
-	subjectAsInterface, ok := idTokenClaims[oidc.IDTokenSubjectClaim]
-	if !ok {
-		plog.Warning(
-			"no subject claim in upstream ID token",
-			"upstreamName", upstreamIDPConfig.GetName(),
-		)
-		return "", "", httperr.New(http.StatusUnprocessableEntity, "no subject claim in upstream ID token")
-	}
-
-	upstreamSubject, ok := subjectAsInterface.(string)
-	if !ok {
-		plog.Warning(
-			"subject claim in upstream ID token has invalid format",
-			"upstreamName", upstreamIDPConfig.GetName(),
-		)
-		return "", "", httperr.New(http.StatusUnprocessableEntity, "subject claim in upstream ID token has invalid format")
-	}
-
-	subject := downstreamSubjectFromUpstreamOIDC(upstreamIssuerAsString, upstreamSubject)
-
-	usernameClaimName := upstreamIDPConfig.GetUsernameClaim()
-	if usernameClaimName == "" {
-		return subject, subject, nil
-	}
-
-	// If the upstream username claim is configured to be the special "email" claim and the upstream "email_verified"
-	// claim is present, then validate that the "email_verified" claim is true.
-	emailVerifiedAsInterface, ok := idTokenClaims[emailVerifiedClaimName]
-	if usernameClaimName == emailClaimName && ok {
-		emailVerified, ok := emailVerifiedAsInterface.(bool)
-		if !ok {
-			plog.Warning(
-				"username claim configured as \"email\" and upstream email_verified claim is not a boolean",
-				"upstreamName", upstreamIDPConfig.GetName(),
-				"configuredUsernameClaim", usernameClaimName,
-				"emailVerifiedClaim", emailVerifiedAsInterface,
-			)
-			return "", "", httperr.New(http.StatusUnprocessableEntity, "email_verified claim in upstream ID token has invalid format")
-		}
-		if !emailVerified {
-			plog.Warning(
-				"username claim configured as \"email\" and upstream email_verified claim has false value",
-				"upstreamName", upstreamIDPConfig.GetName(),
-				"configuredUsernameClaim", usernameClaimName,
-			)
-			return "", "", httperr.New(http.StatusUnprocessableEntity, "email_verified claim in upstream ID token has false value")
-		}
-	}
-
-	usernameAsInterface, ok := idTokenClaims[usernameClaimName]
-	if !ok {
-		plog.Warning(
-			"no username claim in upstream ID token",
-			"upstreamName", upstreamIDPConfig.GetName(),
-			"configuredUsernameClaim", usernameClaimName,
-		)
-		return "", "", httperr.New(http.StatusUnprocessableEntity, "no username claim in upstream ID token")
-	}
-
-	username, ok := usernameAsInterface.(string)
-	if !ok {
-		plog.Warning(
-			"username claim in upstream ID token has invalid format",
-			"upstreamName", upstreamIDPConfig.GetName(),
-			"configuredUsernameClaim", usernameClaimName,
-		)
-		return "", "", httperr.New(http.StatusUnprocessableEntity, "username claim in upstream ID token has invalid format")
-	}
-
-	return subject, username, nil
-}
-
-func downstreamSubjectFromUpstreamOIDC(upstreamIssuerAsString string, upstreamSubject string) string {
-	return fmt.Sprintf("%s?%s=%s", upstreamIssuerAsString, oidc.IDTokenSubjectClaim, url.QueryEscape(upstreamSubject))
-}
-
-func getGroupsFromUpstreamIDToken(
-	upstreamIDPConfig provider.UpstreamOIDCIdentityProviderI,
-	idTokenClaims map[string]interface{},
-) ([]string, error) {
-	groupsClaimName := upstreamIDPConfig.GetGroupsClaim()
-	if groupsClaimName == "" {
-		return nil, nil
-	}
-
-	groupsAsInterface, ok := idTokenClaims[groupsClaimName]
-	if !ok {
-		plog.Warning(
-			"no groups claim in upstream ID token",
-			"upstreamName", upstreamIDPConfig.GetName(),
-			"configuredGroupsClaim", groupsClaimName,
-		)
-		return nil, nil // the upstream IDP may have omitted the claim if the user has no groups
-	}
-
-	groupsAsArray, okAsArray := extractGroups(groupsAsInterface)
-	if !okAsArray {
-		plog.Warning(
-			"groups claim in upstream ID token has invalid format",
-			"upstreamName", upstreamIDPConfig.GetName(),
-			"configuredGroupsClaim", groupsClaimName,
-		)
-		return nil, httperr.New(http.StatusUnprocessableEntity, "groups claim in upstream ID token has invalid format")
-	}
-
-	return groupsAsArray, nil
-}
-
-func extractGroups(groupsAsInterface interface{}) ([]string, bool) {
-	groupsAsString, okAsString := groupsAsInterface.(string)
-	if okAsString {
-		return []string{groupsAsString}, true
-	}
-
-	groupsAsStringArray, okAsStringArray := groupsAsInterface.([]string)
-	if okAsStringArray {
-		return groupsAsStringArray, true
-	}
-
-	groupsAsInterfaceArray, okAsArray := groupsAsInterface.([]interface{})
-	if !okAsArray {
-		return nil, false
-	}
-
-	var groupsAsStrings []string
-	for _, groupAsInterface := range groupsAsInterfaceArray {
-		groupAsString, okAsString := groupAsInterface.(string)
-		if !okAsString {
-			return nil, false
-		}
-		if groupAsString != "" {
-			groupsAsStrings = append(groupsAsStrings, groupAsString)
-		}
-	}
-
-	return groupsAsStrings, true
-}
@@ -11,13 +11,19 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/securecookie"
+	"github.com/ory/fosite"
 	"github.com/stretchr/testify/require"
 	"k8s.io/client-go/kubernetes/fake"
 
+	"go.pinniped.dev/internal/fositestorage/devicecode"
+	"go.pinniped.dev/internal/idtransform"
 	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/clientregistry"
 	"go.pinniped.dev/internal/oidc/jwks"
+	"go.pinniped.dev/internal/psession"
 	"go.pinniped.dev/internal/testutil"
 	"go.pinniped.dev/internal/testutil/oidctestutil"
 	"go.pinniped.dev/pkg/oidcclient/nonce"
@@ -32,6 +38,8 @@ const (
 	upstreamSubject             = "abc123-some guid" // has a space character which should get escaped in URL
 	queryEscapedUpstreamSubject = "abc123-some+guid"
 	upstreamUsername            = "test-pinniped-username"
+	upstreamRefreshToken        = "test-upstream-refresh-token"
+	upstreamAccessToken         = "test-upstream-access-token"
 
 	upstreamUsernameClaim = "the-user-claim"
 	upstreamGroupsClaim   = "the-groups-claim"
@@ -107,6 +115,23 @@ func TestCallbackEndpoint(t *testing.T) {
 		RedirectURI:          happyUpstreamRedirectURI,
 	}
 
+	happyDownstreamCustomSessionData := &psession.CustomSessionData{
+		ProviderName: happyUpstreamIDPName,
+		ProviderType: psession.ProviderTypeOIDC,
+		OIDC: &psession.OIDCSessionData{
+			UpstreamRefreshToken: upstreamRefreshToken,
+			UpstreamIssuer:       upstreamIssuer,
+		},
+	}
+
+	happyGitHubState := happyUpstreamStateParam().WithUpstreamType("github").Build(t, happyStateCodec)
+
+	happyGitHubDownstreamCustomSessionData := &psession.CustomSessionData{
+		ProviderName: happyUpstreamIDPName,
+		ProviderType: psession.ProviderTypeGitHub,
+		GitHub:       &psession.GitHubSessionData{UpstreamAccessToken: upstreamAccessToken},
+	}
+
 	// Note that fosite puts the granted scopes as a param in the redirect URI even though the spec doesn't seem to require it
 	happyDownstreamRedirectLocationRegexp := downstreamRedirectURI + `\?code=([^&]+)&scope=openid&state=` + happyDownstreamState
 
@@ -114,6 +139,8 @@ func TestCallbackEndpoint(t *testing.T) {
 		name string
 
 		idp        oidctestutil.TestUpstreamOIDCIdentityProvider
+		idpType    string // defaults to "oidc"
+		transforms []idtransform.Transform
 		method     string
 		path       string
 		csrfCookie string
@@ -131,6 +158,7 @@ func TestCallbackEndpoint(t *testing.T) {
 		wantDownstreamNonce               string
 		wantDownstreamPKCEChallenge       string
 		wantDownstreamPKCEChallengeMethod string
+		wantDownstreamCustomSessionData   *psession.CustomSessionData
 
 		wantExchangeAndValidateTokensCall *oidctestutil.ExchangeAuthcodeAndValidateTokenArgs
 	}{
@@ -158,6 +186,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -177,6 +206,122 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
+			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
+		},
+		{
+			name:                              "GitHub upstream with good state and cookie and successful upstream token exchange returns 302 to downstream client callback with its state and code",
+			idp:                               happyUpstream().WithAccessToken(upstreamAccessToken).Build(),
+			idpType:                           "github",
+			method:                            http.MethodGet,
+			path:                              newRequestPath().WithState(happyGitHubState).String(),
+			csrfCookie:                        happyCSRFCookie,
+			wantStatus:                        http.StatusFound,
+			wantRedirectLocationRegexp:        happyDownstreamRedirectLocationRegexp,
+			wantBody:                          "",
+			wantDownstreamIDTokenSubject:      upstreamIssuer + "?sub=" + queryEscapedUpstreamSubject,
+			wantDownstreamIDTokenUsername:     upstreamUsername,
+			wantDownstreamIDTokenGroups:       upstreamGroupMembership,
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyGitHubDownstreamCustomSessionData,
+			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
+		},
+		{
+			name:            "the state is for a GitHub upstream, but only an OIDC upstream of the same name exists",
+			idp:             happyUpstream().Build(),
+			method:          http.MethodGet,
+			path:            newRequestPath().WithState(happyGitHubState).String(),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: htmlContentType,
+			wantBody:        "Unprocessable Entity: upstream provider not found\n",
+		},
+		{
+			name:            "the state is for a SAML upstream, which must use the assertion consumer service instead",
+			idp:             happyUpstream().Build(),
+			method:          http.MethodGet,
+			path:            newRequestPath().WithState(happyUpstreamStateParam().WithUpstreamType("saml").Build(t, happyStateCodec)).String(),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: htmlContentType,
+			wantBody:        "Unprocessable Entity: upstream provider not found\n",
+		},
+		{
+			name:            "the state is for an OIDC upstream, but only a GitHub upstream of the same name exists",
+			idp:             happyUpstream().Build(),
+			idpType:         "github",
+			method:          http.MethodGet,
+			path:            newRequestPath().WithState(happyState).String(),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: htmlContentType,
+			wantBody:        "Unprocessable Entity: upstream provider not found\n",
+		},
+		{
+			name: "identity transforms change the downstream username and groups",
+			idp:  happyUpstream().Build(),
+			transforms: []idtransform.Transform{
+				{Type: idtransform.TypePrefix, Prefix: "some-prefix:"},
+				{Type: idtransform.TypeDenyGroups, Regex: "^" + upstreamGroupMembership[0] + "$"},
+			},
+			method:                            http.MethodGet,
+			path:                              newRequestPath().WithState(happyState).String(),
+			csrfCookie:                        happyCSRFCookie,
+			wantStatus:                        http.StatusFound,
+			wantRedirectLocationRegexp:        happyDownstreamRedirectLocationRegexp,
+			wantBody:                          "",
+			wantDownstreamIDTokenSubject:      upstreamIssuer + "?sub=" + queryEscapedUpstreamSubject,
+			wantDownstreamIDTokenUsername:     "some-prefix:" + upstreamUsername,
+			wantDownstreamIDTokenGroups:       upstreamGroupMembership[1:],
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
+			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
+		},
+		{
+			name:                              "identity transforms deny the login",
+			idp:                               happyUpstream().Build(),
+			transforms:                        []idtransform.Transform{{Type: idtransform.TypeDenyLogin, Regex: "^" + upstreamUsername + "$"}},
+			method:                            http.MethodGet,
+			path:                              newRequestPath().WithState(happyState).String(),
+			csrfCookie:                        happyCSRFCookie,
+			wantStatus:                        http.StatusForbidden,
+			wantBody:                          "Forbidden: login denied by identity transforms\n",
+			wantContentType:                   htmlContentType,
+			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
+		},
+		{
+			name:                              "upstream IDP does not return a refresh token, so the downstream session is stored without one",
+			idp:                               happyUpstream().WithoutRefreshToken().Build(),
+			method:                            http.MethodGet,
+			path:                              newRequestPath().WithState(happyState).String(),
+			csrfCookie:                        happyCSRFCookie,
+			wantStatus:                        http.StatusFound,
+			wantRedirectLocationRegexp:        happyDownstreamRedirectLocationRegexp,
+			wantBody:                          "",
+			wantDownstreamIDTokenSubject:      upstreamIssuer + "?sub=" + queryEscapedUpstreamSubject,
+			wantDownstreamIDTokenUsername:     upstreamUsername,
+			wantDownstreamIDTokenGroups:       upstreamGroupMembership,
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData: &psession.CustomSessionData{
+				ProviderName: happyUpstreamIDPName,
+				ProviderType: psession.ProviderTypeOIDC,
+				OIDC: &psession.OIDCSessionData{
+					UpstreamRefreshToken: "",
+					UpstreamIssuer:       upstreamIssuer,
+				},
+			},
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -196,6 +341,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -216,6 +362,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -237,6 +384,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -259,6 +407,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -304,6 +453,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -323,6 +473,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -342,6 +493,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 
@@ -479,6 +631,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -502,6 +655,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -584,6 +738,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -663,14 +818,28 @@ func TestCallbackEndpoint(t *testing.T) {
 			// Configure fosite the same way that the production code would.
 			// Inject this into our test subject at the last second so we get a fresh storage for every test.
 			timeoutsConfiguration := oidc.DefaultOIDCTimeoutsConfiguration()
-			oauthStore := oidc.NewKubeStorage(secrets, timeoutsConfiguration)
-			hmacSecretFunc := func() []byte { return []byte("some secret - must have at least 32 bytes") }
-			require.GreaterOrEqual(t, len(hmacSecretFunc()), 32, "fosite requires that hmac secrets have at least 32 bytes")
+			oauthStore := oidc.NewKubeStorage(secrets, oidctestutil.NewClientManager("some-namespace"), timeoutsConfiguration)
+			hmacSecretFunc := func() [][]byte { return [][]byte{[]byte("some secret - must have at least 32 bytes")} }
+			require.GreaterOrEqual(t, len(hmacSecretFunc()[0]), 32, "fosite requires that hmac secrets have at least 32 bytes")
 			jwksProviderIsUnused := jwks.NewDynamicJWKSProvider()
 			oauthHelper := oidc.FositeOauth2Helper(oauthStore, downstreamIssuer, hmacSecretFunc, jwksProviderIsUnused, timeoutsConfiguration)
 
-			idpLister := oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&test.idp).Build()
-			subject := NewHandler(idpLister, oauthHelper, happyStateCodec, happyCookieCodec, happyUpstreamRedirectURI)
+			idpType := test.idpType
+			if idpType == "" {
+				idpType = "oidc"
+			}
+			idpListerBuilder := oidctestutil.NewUpstreamIDPListerBuilder()
+			if idpType == "github" {
+				idpListerBuilder.WithGitHub(&test.idp)
+			} else {
+				idpListerBuilder.WithOIDC(&test.idp)
+			}
+			if test.transforms != nil {
+				transforms, err := idtransform.NewPipeline(test.transforms)
+				require.NoError(t, err)
+				idpListerBuilder.WithIdentityTransforms(test.idp.Name, idpType, transforms)
+			}
+			subject := NewHandler(downstreamIssuer, idpListerBuilder.Build(), oauthHelper, oauthStore, happyStateCodec, happyCookieCodec, happyUpstreamRedirectURI)
 			req := httptest.NewRequest(test.method, test.path, nil)
 			if test.csrfCookie != "" {
 				req.Header.Set("Cookie", test.csrfCookie)
@@ -717,6 +886,7 @@ func TestCallbackEndpoint(t *testing.T) {
 					test.wantDownstreamNonce,
 					downstreamClientID,
 					downstreamRedirectURI,
+					test.wantDownstreamCustomSessionData,
 				)
 
 			// Otherwise, expect an empty response body.
@@ -743,12 +913,178 @@ func TestCallbackEndpoint(t *testing.T) {
 					test.wantDownstreamNonce,
 					downstreamClientID,
 					downstreamRedirectURI,
+					test.wantDownstreamCustomSessionData,
 				)
 			}
 		})
 	}
 }
 
+func TestCallbackEndpointForDeviceAuthorization(t *testing.T) {
+	const (
+		deviceCodeSignature = "some-device-code-signature"
+		deviceUserCode      = "WDJB-MJHT"
+	)
+
+	var stateEncoderHashKey = []byte("fake-hash-secret")
+	var stateEncoderBlockKey = []byte("0123456789ABCDEF") // block encryption requires 16/24/32 bytes for AES
+	var cookieEncoderHashKey = []byte("fake-hash-secret2")
+	var cookieEncoderBlockKey = []byte("0123456789ABCDE2") // block encryption requires 16/24/32 bytes for AES
+
+	var happyStateCodec = securecookie.New(stateEncoderHashKey, stateEncoderBlockKey)
+	happyStateCodec.SetSerializer(securecookie.JSONEncoder{})
+	var happyCookieCodec = securecookie.New(cookieEncoderHashKey, cookieEncoderBlockKey)
+	happyCookieCodec.SetSerializer(securecookie.JSONEncoder{})
+
+	happyDeviceState := happyUpstreamStateParam().WithDeviceUserCode("WDJBMJHT").Build(t, happyStateCodec)
+
+	encodedIncomingCookieCSRFValue, err := happyCookieCodec.Encode("csrf", happyDownstreamCSRF)
+	require.NoError(t, err)
+	happyCSRFCookie := "__Host-pinniped-csrf=" + encodedIncomingCookieCSRFValue
+
+	tests := []struct {
+		name          string
+		idp           oidctestutil.TestUpstreamOIDCIdentityProvider
+		transforms    []idtransform.Transform
+		sessionStatus devicecode.Status
+		sessionTTL    time.Duration
+		noSession     bool
+
+		wantStatus        int
+		wantContentType   string
+		wantBody          string
+		wantBodyContains  string
+		wantSessionStatus devicecode.Status
+	}{
+		{
+			name:              "happy path approves the device authorization request",
+			idp:               happyUpstream().Build(),
+			sessionStatus:     devicecode.StatusPending,
+			sessionTTL:        time.Minute,
+			wantStatus:        http.StatusOK,
+			wantContentType:   htmlContentType,
+			wantBodyContains:  "Your device has been logged in.",
+			wantSessionStatus: devicecode.StatusApproved,
+		},
+		{
+			name:              "upstream login fails, which denies the device authorization request",
+			idp:               happyUpstream().WithoutUpstreamAuthcodeExchangeError(errors.New("some error")).Build(),
+			sessionStatus:     devicecode.StatusPending,
+			sessionTTL:        time.Minute,
+			wantStatus:        http.StatusBadGateway,
+			wantContentType:   "text/plain; charset=utf-8",
+			wantBody:          "Bad Gateway: error exchanging and validating upstream tokens\n",
+			wantSessionStatus: devicecode.StatusDenied,
+		},
+		{
+			name:              "identity transforms deny the login, which denies the device authorization request",
+			idp:               happyUpstream().Build(),
+			transforms:        []idtransform.Transform{{Type: idtransform.TypeDenyLogin, Regex: "^" + upstreamUsername + "$"}},
+			sessionStatus:     devicecode.StatusPending,
+			sessionTTL:        time.Minute,
+			wantStatus:        http.StatusForbidden,
+			wantContentType:   "text/plain; charset=utf-8",
+			wantBody:          "Forbidden: login denied by identity transforms\n",
+			wantSessionStatus: devicecode.StatusDenied,
+		},
+		{
+			name:              "device authorization request has expired",
+			idp:               happyUpstream().Build(),
+			sessionStatus:     devicecode.StatusPending,
+			sessionTTL:        -time.Minute,
+			wantStatus:        http.StatusUnprocessableEntity,
+			wantContentType:   "text/plain; charset=utf-8",
+			wantBody:          "Unprocessable Entity: device authorization request has expired or was already used\n",
+			wantSessionStatus: devicecode.StatusPending,
+		},
+		{
+			name:              "device authorization request was already approved",
+			idp:               happyUpstream().Build(),
+			sessionStatus:     devicecode.StatusApproved,
+			sessionTTL:        time.Minute,
+			wantStatus:        http.StatusUnprocessableEntity,
+			wantContentType:   "text/plain; charset=utf-8",
+			wantBody:          "Unprocessable Entity: device authorization request has expired or was already used\n",
+			wantSessionStatus: devicecode.StatusApproved,
+		},
+		{
+			name:            "device authorization request does not exist",
+			idp:             happyUpstream().Build(),
+			noSession:       true,
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Unprocessable Entity: device authorization request not found\n",
+		},
+	}
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			secrets := client.CoreV1().Secrets("some-namespace")
+
+			timeoutsConfiguration := oidc.DefaultOIDCTimeoutsConfiguration()
+			oauthStore := oidc.NewKubeStorage(secrets, oidctestutil.NewClientManager("some-namespace"), timeoutsConfiguration)
+			hmacSecretFunc := func() [][]byte { return [][]byte{[]byte("some secret - must have at least 32 bytes")} }
+			oauthHelper := oidc.FositeOauth2Helper(oauthStore, downstreamIssuer, hmacSecretFunc, jwks.NewDynamicJWKSProvider(), timeoutsConfiguration)
+
+			if !test.noSession {
+				// Simulate the device authorization endpoint having already run.
+				request := fosite.NewRequest()
+				request.Client = clientregistry.PinnipedCLI()
+				request.RequestedScope = fosite.Arguments{"openid", "offline_access", "profile"}
+				request.Session = psession.NewPinnipedSession()
+				require.NoError(t, oauthStore.CreateDeviceCodeSession(context.Background(), deviceCodeSignature, deviceUserCode, time.Now().Add(test.sessionTTL), request))
+				if test.sessionStatus != devicecode.StatusPending {
+					session, err := oauthStore.GetDeviceCodeSession(context.Background(), deviceCodeSignature)
+					require.NoError(t, err)
+					session.Status = test.sessionStatus
+					require.NoError(t, oauthStore.UpdateDeviceCodeSession(context.Background(), deviceCodeSignature, session))
+				}
+			}
+
+			idpListerBuilder := oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&test.idp)
+			if test.transforms != nil {
+				transforms, err := idtransform.NewPipeline(test.transforms)
+				require.NoError(t, err)
+				idpListerBuilder.WithIdentityTransforms(test.idp.Name, "oidc", transforms)
+			}
+			subject := NewHandler(downstreamIssuer, idpListerBuilder.Build(), oauthHelper, oauthStore, happyStateCodec, happyCookieCodec, happyUpstreamRedirectURI)
+			req := httptest.NewRequest(http.MethodGet, newRequestPath().WithState(happyDeviceState).String(), nil)
+			req.Header.Set("Cookie", happyCSRFCookie)
+			rsp := httptest.NewRecorder()
+			subject.ServeHTTP(rsp, req)
+			t.Logf("response: %#v", rsp)
+			t.Logf("response body: %q", rsp.Body.String())
+
+			testutil.RequireSecurityHeaders(t, rsp)
+			require.Equal(t, test.wantStatus, rsp.Code)
+			testutil.RequireEqualContentType(t, rsp.Header().Get("Content-Type"), test.wantContentType)
+			if test.wantBody != "" {
+				require.Equal(t, test.wantBody, rsp.Body.String())
+			}
+			if test.wantBodyContains != "" {
+				require.Contains(t, rsp.Body.String(), test.wantBodyContains)
+			}
+
+			if test.noSession {
+				return
+			}
+			session, err := oauthStore.GetDeviceCodeSession(context.Background(), deviceCodeSignature)
+			require.NoError(t, err)
+			require.Equal(t, test.wantSessionStatus, session.Status)
+			if test.wantSessionStatus == devicecode.StatusApproved && test.wantStatus == http.StatusOK {
+				// Only the scopes which were requested and which are automatically granted should be granted.
+				require.Equal(t, fosite.Arguments{"openid", "offline_access"}, session.Request.GetGrantedScopes())
+				pinnipedSession := session.Request.GetSession().(*psession.PinnipedSession)
+				require.Equal(t, upstreamIssuer+"?sub="+queryEscapedUpstreamSubject, pinnipedSession.Claims.Subject)
+				require.Equal(t, upstreamUsername, pinnipedSession.Claims.Extra["username"])
+				require.Equal(t, upstreamRefreshToken, pinnipedSession.Custom.OIDC.UpstreamRefreshToken)
+			}
+		})
+	}
+}
+
 type requestPath struct {
 	code, state *string
 }
@@ -838,10 +1174,23 @@ func (b *upstreamStateParamBuilder) WithStateVersion(version string) *upstreamSt
 	return b
 }
 
+func (b *upstreamStateParamBuilder) WithUpstreamType(upstreamType string) *upstreamStateParamBuilder {
+	b.T = upstreamType
+	return b
+}
+
+func (b *upstreamStateParamBuilder) WithDeviceUserCode(userCode string) *upstreamStateParamBuilder {
+	b.P = ""
+	b.D = userCode
+	return b
+}
+
 type upstreamOIDCIdentityProviderBuilder struct {
 	idToken                    map[string]interface{}
 	usernameClaim, groupsClaim string
 	authcodeExchangeErr        error
+	withoutRefreshToken        bool
+	accessToken                string
 }
 
 func happyUpstream() *upstreamOIDCIdentityProviderBuilder {
@@ -883,6 +1232,16 @@ func (u *upstreamOIDCIdentityProviderBuilder) WithoutIDTokenClaim(claim string)
 	return u
 }
 
+func (u *upstreamOIDCIdentityProviderBuilder) WithoutRefreshToken() *upstreamOIDCIdentityProviderBuilder {
+	u.withoutRefreshToken = true
+	return u
+}
+
+func (u *upstreamOIDCIdentityProviderBuilder) WithAccessToken(token string) *upstreamOIDCIdentityProviderBuilder {
+	u.accessToken = token
+	return u
+}
+
 func (u *upstreamOIDCIdentityProviderBuilder) WithoutUpstreamAuthcodeExchangeError(err error) *upstreamOIDCIdentityProviderBuilder {
 	u.authcodeExchangeErr = err
 	return u
@@ -899,7 +1258,14 @@ func (u *upstreamOIDCIdentityProviderBuilder) Build() oidctestutil.TestUpstreamO
 			if u.authcodeExchangeErr != nil {
 				return nil, u.authcodeExchangeErr
 			}
-			return &oidctypes.Token{IDToken: &oidctypes.IDToken{Claims: u.idToken}}, nil
+			token := &oidctypes.Token{IDToken: &oidctypes.IDToken{Claims: u.idToken}}
+			if !u.withoutRefreshToken {
+				token.RefreshToken = &oidctypes.RefreshToken{Token: upstreamRefreshToken}
+			}
+			if u.accessToken != "" {
+				token.AccessToken = &oidctypes.AccessToken{Token: u.accessToken}
+			}
+			return token, nil
 		},
 	}
 }
@@ -0,0 +1,239 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package callback
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/ory/fosite"
+	"github.com/pkg/errors"
+
+	"go.pinniped.dev/internal/auditlog"
+	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/fositestorage/devicecode"
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/httputil/securityheader"
+	"go.pinniped.dev/internal/metrics"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/downstreamsession"
+	"go.pinniped.dev/internal/oidc/idpdiscovery"
+	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/oidc/provider/formposthtml"
+	"go.pinniped.dev/internal/oidc/provider/loginhtml"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/psession"
+)
+
+const (
+	stateParamName    = "state"
+	usernameParamName = "username"
+	passwordParamName = "password" //nolint:gosec // this is not a credential
+
+	// errUsernamePasswordNotAccepted is returned when making the downstream session when the upstream did not accept
+	// the username and password, so that the end user can be asked to try again.
+	errUsernamePasswordNotAccepted = constable.Error("username/password not accepted by LDAP provider")
+)
+
+// NewLoginHandler returns a handler for the login endpoint, which shows a form where the end user enters their
+// username and password for an upstream LDAP or Active Directory provider. When the form is submitted, it finishes
+// the login in the same way that the OIDC callback endpoint does.
+func NewLoginHandler(
+	downstreamIssuer string,
+	upstreamIDPs oidc.UpstreamIdentityProvidersLister,
+	oauthHelper fosite.OAuth2Provider,
+	deviceCodeStorage devicecode.DeviceCodeStorage,
+	stateDecoder, cookieDecoder oidc.Decoder,
+) http.Handler {
+	loginURL := downstreamIssuer + oidc.LoginEndpointPath
+
+	handler := httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			return httperr.Newf(http.StatusMethodNotAllowed, "%s (try GET or POST)", r.Method)
+		}
+
+		encodedState := r.FormValue(stateParamName)
+		if encodedState == "" {
+			plog.Info("state param not found")
+			return httperr.New(http.StatusBadRequest, "state param not found")
+		}
+
+		csrfValue, err := readCSRFCookie(r, cookieDecoder)
+		if err != nil {
+			plog.InfoErr("error reading CSRF cookie", err)
+			return err
+		}
+
+		state, err := readState(encodedState, stateDecoder)
+		if err != nil {
+			plog.InfoErr("error reading state", err)
+			return err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(state.CSRFToken), []byte(csrfValue)) != 1 {
+			plog.Info("CSRF value does not match")
+			return httperr.New(http.StatusForbidden, "CSRF value does not match")
+		}
+
+		ldapUpstream, upstreamType := findUpstreamLDAPIDPConfig(state.UpstreamName, state.UpstreamType, upstreamIDPs)
+		if ldapUpstream == nil {
+			plog.Warning("upstream provider not found")
+			return httperr.New(http.StatusUnprocessableEntity, "upstream provider not found")
+		}
+
+		pageData := &loginhtml.PageData{
+			FormAction:           loginURL,
+			State:                encodedState,
+			IdentityProviderName: ldapUpstream.GetName(),
+		}
+
+		if r.Method == http.MethodGet {
+			renderLoginPage(w, http.StatusOK, pageData)
+			return nil
+		}
+
+		username := r.PostFormValue(usernameParamName)
+		password := r.PostFormValue(passwordParamName)
+		pageData.Username = username
+		if username == "" || password == "" {
+			pageData.ErrorMessage = "Please enter your username and password."
+			renderLoginPage(w, http.StatusUnprocessableEntity, pageData)
+			return nil
+		}
+
+		makeSession := func(clientID string) (*psession.PinnipedSession, error) {
+			return makeDownstreamSessionFromUpstreamLDAP(r, downstreamIssuer, clientID, upstreamIDPs, ldapUpstream, upstreamType, username, password)
+		}
+
+		if state.DeviceUserCode != "" {
+			// This login was started by the device verification endpoint, so there is no downstream authorization
+			// request. Instead, approve the device authorization request.
+			err = handleDeviceCallback(r, w, deviceCodeStorage, state.DeviceUserCode, ldapUpstream.GetName(), makeSession)
+		} else {
+			err = finishDownstreamAuthorize(r, w, oauthHelper, state.AuthParams, ldapUpstream.GetName(), makeSession)
+		}
+
+		if errors.Is(err, errUsernamePasswordNotAccepted) {
+			pageData.ErrorMessage = "Incorrect username or password."
+			renderLoginPage(w, http.StatusUnauthorized, pageData)
+			return nil
+		}
+		return err
+	})
+	return securityheader.WrapWithCustomCSP(handler, formposthtml.ContentSecurityPolicy())
+}
+
+// renderLoginPage renders the page which asks the end user for their username and password.
+func renderLoginPage(w http.ResponseWriter, status int, pageData *loginhtml.PageData) {
+	// This page has its own inline CSS, so override the default CSP header which was already set by the wrapper.
+	w.Header().Set("Content-Security-Policy", loginhtml.ContentSecurityPolicy())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := loginhtml.Template().Execute(w, pageData); err != nil {
+		// It's too late to return an error response at this point, so just log it.
+		plog.Error("error rendering login page", err)
+	}
+}
+
+// makeDownstreamSessionFromUpstreamLDAP checks the username and password with the upstream LDAP or Active Directory
+// provider and makes a downstream session for the upstream user. The result of the login is recorded in the metrics
+// of the downstream issuer and in the audit log.
+func makeDownstreamSessionFromUpstreamLDAP(
+	r *http.Request,
+	downstreamIssuer string,
+	clientID string,
+	transformsLister oidc.IdentityTransformsLister,
+	ldapUpstream provider.UpstreamLDAPIdentityProviderI,
+	ldapUpstreamType psession.ProviderType,
+	username string,
+	password string,
+) (*psession.PinnipedSession, error) {
+	recordLogin := func(result string) {
+		metrics.RecordLogin(downstreamIssuer, ldapUpstream.GetName(), string(ldapUpstreamType), result)
+	}
+	recordAuditEvent := func(eventType auditlog.EventType, outcome auditlog.Outcome, reason string, user *auditlog.User) {
+		auditlog.Record(auditlog.Event{
+			Type:                     eventType,
+			Outcome:                  outcome,
+			Reason:                   reason,
+			SourceIP:                 auditlog.SourceIP(r),
+			ForwardedFor:             auditlog.ForwardedFor(r),
+			FederationDomain:         downstreamIssuer,
+			ClientID:                 clientID,
+			UpstreamIdentityProvider: &auditlog.IdentityProvider{Name: ldapUpstream.GetName(), Type: string(ldapUpstreamType)},
+			User:                     user,
+		})
+	}
+
+	authenticateResponse, authenticated, err := ldapUpstream.AuthenticateUser(r.Context(), username, password)
+	if err != nil {
+		plog.WarningErr("unexpected error during upstream LDAP authentication", err, "upstreamName", ldapUpstream.GetName())
+		recordLogin(metrics.ResultError)
+		recordAuditEvent(auditlog.EventTypeSupervisorLDAPBind, auditlog.OutcomeError, err.Error(), &auditlog.User{Username: username})
+		recordAuditEvent(auditlog.EventTypeSupervisorLogin, auditlog.OutcomeError, "unexpected error during upstream authentication", nil)
+		return nil, httperr.New(http.StatusBadGateway, "unexpected error during upstream authentication")
+	}
+	if !authenticated {
+		plog.Debug("failed upstream LDAP authentication", "upstreamName", ldapUpstream.GetName())
+		recordLogin(metrics.ResultFailure)
+		recordAuditEvent(auditlog.EventTypeSupervisorLDAPBind, auditlog.OutcomeFailure, errUsernamePasswordNotAccepted.Error(), &auditlog.User{Username: username})
+		recordAuditEvent(auditlog.EventTypeSupervisorLogin, auditlog.OutcomeFailure, errUsernamePasswordNotAccepted.Error(), nil)
+		return nil, errUsernamePasswordNotAccepted
+	}
+	upstreamUser := &auditlog.User{
+		Username: authenticateResponse.User.GetName(),
+		Groups:   authenticateResponse.User.GetGroups(),
+	}
+	recordAuditEvent(auditlog.EventTypeSupervisorLDAPBind, auditlog.OutcomeSuccess, "", upstreamUser)
+
+	transforms := transformsLister.GetIdentityTransforms(ldapUpstream.GetName(), string(ldapUpstreamType))
+	downstreamUsername, downstreamGroups, err := transforms.Evaluate(upstreamUser.Username, upstreamUser.Groups)
+	if err != nil {
+		plog.Info("identity transforms denied the login", "upstreamName", ldapUpstream.GetName(), "reason", err.Error())
+		recordLogin(metrics.ResultFailure)
+		recordAuditEvent(auditlog.EventTypeSupervisorLogin, auditlog.OutcomeFailure, err.Error(), upstreamUser)
+		return nil, httperr.New(http.StatusForbidden, "login denied by identity transforms")
+	}
+
+	customSessionData := &psession.CustomSessionData{
+		ProviderName: ldapUpstream.GetName(),
+		ProviderType: ldapUpstreamType,
+	}
+	// Remember the username which was used to find the user, so the search can be repeated during refresh.
+	if ldapUpstreamType == psession.ProviderTypeActiveDirectory {
+		customSessionData.ActiveDirectory = &psession.ActiveDirectorySessionData{Username: username}
+	} else {
+		customSessionData.LDAP = &psession.LDAPSessionData{Username: username}
+	}
+
+	recordLogin(metrics.ResultSuccess)
+	recordAuditEvent(auditlog.EventTypeSupervisorLogin, auditlog.OutcomeSuccess, "", &auditlog.User{Username: downstreamUsername, Groups: downstreamGroups})
+	return downstreamsession.MakeDownstreamSession(
+		downstreamsession.DownstreamSubjectFromUpstreamLDAP(ldapUpstream, authenticateResponse),
+		downstreamUsername,
+		downstreamGroups,
+		customSessionData,
+	), nil
+}
+
+// findUpstreamLDAPIDPConfig finds the upstream LDAP or Active Directory IDP which was named in the state param,
+// along with its type.
+func findUpstreamLDAPIDPConfig(upstreamName string, upstreamType string, upstreamIDPs oidc.UpstreamIdentityProvidersLister) (provider.UpstreamLDAPIdentityProviderI, psession.ProviderType) {
+	var upstreams []provider.UpstreamLDAPIdentityProviderI
+	var providerType psession.ProviderType
+	switch upstreamType {
+	case idpdiscovery.IDPTypeLDAP:
+		upstreams, providerType = upstreamIDPs.GetLDAPIdentityProviders(), psession.ProviderTypeLDAP
+	case idpdiscovery.IDPTypeActiveDirectory:
+		upstreams, providerType = upstreamIDPs.GetActiveDirectoryIdentityProviders(), psession.ProviderTypeActiveDirectory
+	default:
+		return nil, ""
+	}
+	for _, p := range upstreams {
+		if p.GetName() == upstreamName {
+			return p, providerType
+		}
+	}
+	return nil, ""
+}
@@ -0,0 +1,508 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package callback
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/ory/fosite"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"go.pinniped.dev/internal/fositestorage/devicecode"
+	"go.pinniped.dev/internal/idtransform"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/oidc/jwks"
+	"go.pinniped.dev/internal/oidc/provider/loginhtml"
+	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/internal/testutil"
+	"go.pinniped.dev/internal/testutil/oidctestutil"
+)
+
+const (
+	upstreamLDAPURL   = "ldaps://some-ldap-host:123?base=ou%3Dusers"
+	upstreamLDAPUID   = "some-ldap-uid"
+	happyLDAPUsername = "some-ldap-username"
+	happyLDAPPassword = "some-ldap-password" //nolint:gosec // this is not a credential
+)
+
+func TestLoginEndpoint(t *testing.T) {
+	var stateEncoderHashKey = []byte("fake-hash-secret")
+	var stateEncoderBlockKey = []byte("0123456789ABCDEF") // block encryption requires 16/24/32 bytes for AES
+	var cookieEncoderHashKey = []byte("fake-hash-secret2")
+	var cookieEncoderBlockKey = []byte("0123456789ABCDE2") // block encryption requires 16/24/32 bytes for AES
+
+	var happyStateCodec = securecookie.New(stateEncoderHashKey, stateEncoderBlockKey)
+	happyStateCodec.SetSerializer(securecookie.JSONEncoder{})
+	var happyCookieCodec = securecookie.New(cookieEncoderHashKey, cookieEncoderBlockKey)
+	happyCookieCodec.SetSerializer(securecookie.JSONEncoder{})
+
+	happyLDAPState := happyUpstreamStateParam().WithUpstreamType("ldap").Build(t, happyStateCodec)
+	happyActiveDirectoryState := happyUpstreamStateParam().WithUpstreamType("activedirectory").Build(t, happyStateCodec)
+
+	encodedIncomingCookieCSRFValue, err := happyCookieCodec.Encode("csrf", happyDownstreamCSRF)
+	require.NoError(t, err)
+	happyCSRFCookie := "__Host-pinniped-csrf=" + encodedIncomingCookieCSRFValue
+
+	happyLDAPCustomSessionData := &psession.CustomSessionData{
+		ProviderName: happyUpstreamIDPName,
+		ProviderType: psession.ProviderTypeLDAP,
+		LDAP:         &psession.LDAPSessionData{Username: happyLDAPUsername},
+	}
+
+	happyActiveDirectoryCustomSessionData := &psession.CustomSessionData{
+		ProviderName:    happyUpstreamIDPName,
+		ProviderType:    psession.ProviderTypeActiveDirectory,
+		ActiveDirectory: &psession.ActiveDirectorySessionData{Username: happyLDAPUsername},
+	}
+
+	// Note that fosite puts the granted scopes as a param in the redirect URI even though the spec doesn't seem to require it
+	happyDownstreamRedirectLocationRegexp := downstreamRedirectURI + `\?code=([^&]+)&scope=openid&state=` + happyDownstreamState
+
+	happyBody := func(modifications map[string]string) string {
+		return shallowCopyAndModifyQuery(url.Values{
+			"state":    {happyLDAPState},
+			"username": {happyLDAPUsername},
+			"password": {happyLDAPPassword},
+		}, modifications).Encode()
+	}
+
+	tests := []struct {
+		name string
+
+		authenticateErr error
+		transforms      []idtransform.Transform
+		method          string
+		path            string
+		body            string
+		csrfCookie      string
+
+		wantStatus                      int
+		wantContentType                 string
+		wantBody                        string
+		wantBodyContains                []string
+		wantBodyNotContains             []string
+		wantContentSecurityPolicy       string
+		wantRedirectLocationRegexp      string
+		wantDownstreamIDTokenUsername   string
+		wantDownstreamIDTokenGroups     []string
+		wantDownstreamCustomSessionData *psession.CustomSessionData
+	}{
+		{
+			name:            "GET with good state and cookie shows the login form",
+			method:          http.MethodGet,
+			path:            "/downstream-provider-name/login?" + url.Values{"state": {happyLDAPState}}.Encode(),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBodyContains: []string{
+				`<h1>Log in to ` + happyUpstreamIDPName + `</h1>`,
+				`<form method="post" action="` + downstreamIssuer + `/login">`,
+				`<input type="hidden" name="state" value="` + happyLDAPState + `">`,
+			},
+			wantBodyNotContains:       []string{`class="error"`},
+			wantContentSecurityPolicy: loginhtml.ContentSecurityPolicy(),
+		},
+		{
+			name:                            "POST with good state and cookie and a valid username and password returns 302 to downstream client callback with its state and code",
+			method:                          http.MethodPost,
+			body:                            happyBody(nil),
+			csrfCookie:                      happyCSRFCookie,
+			wantStatus:                      http.StatusFound,
+			wantRedirectLocationRegexp:      happyDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenUsername:   upstreamUsername,
+			wantDownstreamIDTokenGroups:     upstreamGroupMembership,
+			wantDownstreamCustomSessionData: happyLDAPCustomSessionData,
+		},
+		{
+			name:                            "POST for an Active Directory upstream",
+			method:                          http.MethodPost,
+			body:                            happyBody(map[string]string{"state": happyActiveDirectoryState}),
+			csrfCookie:                      happyCSRFCookie,
+			wantStatus:                      http.StatusFound,
+			wantRedirectLocationRegexp:      happyDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenUsername:   upstreamUsername,
+			wantDownstreamIDTokenGroups:     upstreamGroupMembership,
+			wantDownstreamCustomSessionData: happyActiveDirectoryCustomSessionData,
+		},
+		{
+			name: "identity transforms change the downstream username and groups",
+			transforms: []idtransform.Transform{
+				{Type: idtransform.TypePrefix, Prefix: "ldap:"},
+				{Type: idtransform.TypeDenyGroups, Regex: "-1$"},
+			},
+			method:                          http.MethodPost,
+			body:                            happyBody(nil),
+			csrfCookie:                      happyCSRFCookie,
+			wantStatus:                      http.StatusFound,
+			wantRedirectLocationRegexp:      happyDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenUsername:   "ldap:" + upstreamUsername,
+			wantDownstreamIDTokenGroups:     []string{"test-pinniped-group-0"},
+			wantDownstreamCustomSessionData: happyLDAPCustomSessionData,
+		},
+		{
+			name:            "identity transforms deny the login",
+			transforms:      []idtransform.Transform{{Type: idtransform.TypeDenyLogin, Regex: "^" + upstreamUsername + "$"}},
+			method:          http.MethodPost,
+			body:            happyBody(nil),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusForbidden,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Forbidden: login denied by identity transforms\n",
+		},
+		{
+			name:            "the username and password are not accepted, so the login form is shown again",
+			method:          http.MethodPost,
+			body:            happyBody(map[string]string{"password": "wrong-password"}),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusUnauthorized,
+			wantContentType: htmlContentType,
+			wantBodyContains: []string{
+				`<p class="error">Incorrect username or password.</p>`,
+				`<input type="hidden" name="state" value="` + happyLDAPState + `">`,
+				`value="` + happyLDAPUsername + `"`,
+			},
+			wantContentSecurityPolicy: loginhtml.ContentSecurityPolicy(),
+		},
+		{
+			name:            "the password is missing, so the login form is shown again",
+			method:          http.MethodPost,
+			body:            happyBody(map[string]string{"password": ""}),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: htmlContentType,
+			wantBodyContains: []string{
+				`<p class="error">Please enter your username and password.</p>`,
+			},
+			wantContentSecurityPolicy: loginhtml.ContentSecurityPolicy(),
+		},
+		{
+			name:            "unexpected error while checking the username and password",
+			authenticateErr: errors.New("some ldap error"),
+			method:          http.MethodPost,
+			body:            happyBody(nil),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusBadGateway,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Bad Gateway: unexpected error during upstream authentication\n",
+		},
+		{
+			name:            "without the CSRF cookie",
+			method:          http.MethodPost,
+			body:            happyBody(nil),
+			wantStatus:      http.StatusForbidden,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Forbidden: CSRF cookie is missing\n",
+		},
+		{
+			name:            "the CSRF cookie cannot be decoded",
+			method:          http.MethodGet,
+			path:            "/downstream-provider-name/login?" + url.Values{"state": {happyLDAPState}}.Encode(),
+			csrfCookie:      "__Host-pinniped-csrf=this-value-was-not-signed-by-pinniped",
+			wantStatus:      http.StatusForbidden,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Forbidden: error reading CSRF cookie\n",
+		},
+		{
+			name:            "the CSRF value does not match the state",
+			method:          http.MethodPost,
+			body:            happyBody(map[string]string{"state": happyUpstreamStateParam().WithUpstreamType("ldap").WithCSRF("other-csrf").Build(t, happyStateCodec)}),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusForbidden,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Forbidden: CSRF value does not match\n",
+		},
+		{
+			name:            "PUT is not allowed",
+			method:          http.MethodPut,
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusMethodNotAllowed,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Method Not Allowed: PUT (try GET or POST)\n",
+		},
+		{
+			name:            "the state param is missing",
+			method:          http.MethodPost,
+			body:            happyBody(map[string]string{"state": ""}),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusBadRequest,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Bad Request: state param not found\n",
+		},
+		{
+			name:            "the state param cannot be decoded",
+			method:          http.MethodPost,
+			body:            happyBody(map[string]string{"state": "this-value-was-not-signed-by-pinniped"}),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusBadRequest,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Bad Request: error reading state\n",
+		},
+		{
+			name:            "the state is for an OIDC upstream of the same name",
+			method:          http.MethodPost,
+			body:            happyBody(map[string]string{"state": happyUpstreamStateParam().Build(t, happyStateCodec)}),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Unprocessable Entity: upstream provider not found\n",
+		},
+		{
+			name:   "the state is for an LDAP upstream which does not exist",
+			method: http.MethodPost,
+			body: happyBody(map[string]string{"state": func() string {
+				state := happyUpstreamStateParam().WithUpstreamType("ldap")
+				state.U = "other-upstream-idp-name"
+				return state.Build(t, happyStateCodec)
+			}()}),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Unprocessable Entity: upstream provider not found\n",
+		},
+		{
+			name:   "the state has invalid downstream auth params",
+			method: http.MethodPost,
+			body: happyBody(map[string]string{"state": happyUpstreamStateParam().WithUpstreamType("ldap").WithAuthorizeRequestParams(
+				shallowCopyAndModifyQuery(happyDownstreamRequestParamsQuery, map[string]string{"client_id": "bogus"}).Encode(),
+			).Build(t, happyStateCodec)}),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusBadRequest,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Bad Request: error using state downstream auth params\n",
+		},
+	}
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			secrets := client.CoreV1().Secrets("some-namespace")
+
+			// Configure fosite the same way that the production code would.
+			timeoutsConfiguration := oidc.DefaultOIDCTimeoutsConfiguration()
+			oauthStore := oidc.NewKubeStorage(secrets, oidctestutil.NewClientManager("some-namespace"), timeoutsConfiguration)
+			hmacSecretFunc := func() [][]byte { return [][]byte{[]byte("some secret - must have at least 32 bytes")} }
+			oauthHelper := oidc.FositeOauth2Helper(oauthStore, downstreamIssuer, hmacSecretFunc, jwks.NewDynamicJWKSProvider(), timeoutsConfiguration)
+
+			idp := newTestUpstreamLDAPIdentityProvider(test.authenticateErr)
+			idpListerBuilder := oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(idp).WithActiveDirectory(idp)
+			if test.transforms != nil {
+				transforms, err := idtransform.NewPipeline(test.transforms)
+				require.NoError(t, err)
+				idpListerBuilder.WithIdentityTransforms(idp.Name, "ldap", transforms)
+			}
+			subject := NewLoginHandler(downstreamIssuer, idpListerBuilder.Build(), oauthHelper, oauthStore, happyStateCodec, happyCookieCodec)
+			path := test.path
+			if path == "" {
+				path = "/downstream-provider-name/login"
+			}
+			req := httptest.NewRequest(test.method, path, strings.NewReader(test.body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if test.csrfCookie != "" {
+				req.Header.Set("Cookie", test.csrfCookie)
+			}
+			rsp := httptest.NewRecorder()
+			subject.ServeHTTP(rsp, req)
+			t.Logf("response: %#v", rsp)
+			t.Logf("response body: %q", rsp.Body.String())
+
+			testutil.RequireSecurityHeaders(t, rsp)
+
+			require.Equal(t, test.wantStatus, rsp.Code)
+			testutil.RequireEqualContentType(t, rsp.Header().Get("Content-Type"), test.wantContentType)
+			if test.wantContentSecurityPolicy != "" {
+				require.Equal(t, test.wantContentSecurityPolicy, rsp.Header().Get("Content-Security-Policy"))
+			}
+
+			switch {
+			case test.wantBody != "":
+				require.Equal(t, test.wantBody, rsp.Body.String())
+			case test.wantBodyContains != nil:
+				for _, wantContains := range test.wantBodyContains {
+					require.Contains(t, rsp.Body.String(), wantContains)
+				}
+			default:
+				require.Empty(t, rsp.Body.String())
+			}
+			for _, wantNotContains := range test.wantBodyNotContains {
+				require.NotContains(t, rsp.Body.String(), wantNotContains)
+			}
+
+			if test.wantRedirectLocationRegexp != "" {
+				require.Len(t, rsp.Header().Values("Location"), 1)
+				oidctestutil.RequireAuthCodeRegexpMatch(
+					t,
+					rsp.Header().Get("Location"),
+					test.wantRedirectLocationRegexp,
+					client,
+					secrets,
+					oauthStore,
+					happyDownstreamScopesGranted,
+					upstreamLDAPURL+"&sub="+upstreamLDAPUID,
+					test.wantDownstreamIDTokenUsername,
+					test.wantDownstreamIDTokenGroups,
+					happyDownstreamScopesRequested,
+					downstreamPKCEChallenge,
+					downstreamPKCEChallengeMethod,
+					downstreamNonce,
+					downstreamClientID,
+					downstreamRedirectURI,
+					test.wantDownstreamCustomSessionData,
+				)
+			} else {
+				require.Empty(t, rsp.Header().Values("Location"))
+			}
+		})
+	}
+}
+
+func TestLoginEndpointForDeviceAuthorization(t *testing.T) {
+	const (
+		deviceCodeSignature = "some-device-code-signature"
+		deviceUserCode      = "WDJB-MJHT"
+	)
+
+	var stateEncoderHashKey = []byte("fake-hash-secret")
+	var stateEncoderBlockKey = []byte("0123456789ABCDEF") // block encryption requires 16/24/32 bytes for AES
+	var cookieEncoderHashKey = []byte("fake-hash-secret2")
+	var cookieEncoderBlockKey = []byte("0123456789ABCDE2") // block encryption requires 16/24/32 bytes for AES
+
+	var happyStateCodec = securecookie.New(stateEncoderHashKey, stateEncoderBlockKey)
+	happyStateCodec.SetSerializer(securecookie.JSONEncoder{})
+	var happyCookieCodec = securecookie.New(cookieEncoderHashKey, cookieEncoderBlockKey)
+	happyCookieCodec.SetSerializer(securecookie.JSONEncoder{})
+
+	happyDeviceState := happyUpstreamStateParam().WithUpstreamType("ldap").WithDeviceUserCode("WDJBMJHT").Build(t, happyStateCodec)
+
+	encodedIncomingCookieCSRFValue, err := happyCookieCodec.Encode("csrf", happyDownstreamCSRF)
+	require.NoError(t, err)
+	happyCSRFCookie := "__Host-pinniped-csrf=" + encodedIncomingCookieCSRFValue
+
+	tests := []struct {
+		name            string
+		password        string
+		authenticateErr error
+
+		wantStatus        int
+		wantContentType   string
+		wantBody          string
+		wantBodyContains  string
+		wantSessionStatus devicecode.Status
+	}{
+		{
+			name:              "happy path approves the device authorization request",
+			password:          happyLDAPPassword,
+			wantStatus:        http.StatusOK,
+			wantContentType:   htmlContentType,
+			wantBodyContains:  "Your device has been logged in.",
+			wantSessionStatus: devicecode.StatusApproved,
+		},
+		{
+			name:              "the username and password are not accepted, which leaves the device authorization request pending",
+			password:          "wrong-password",
+			wantStatus:        http.StatusUnauthorized,
+			wantContentType:   htmlContentType,
+			wantBodyContains:  "Incorrect username or password.",
+			wantSessionStatus: devicecode.StatusPending,
+		},
+		{
+			name:              "unexpected error while checking the username and password, which denies the device authorization request",
+			password:          happyLDAPPassword,
+			authenticateErr:   errors.New("some ldap error"),
+			wantStatus:        http.StatusBadGateway,
+			wantContentType:   "text/plain; charset=utf-8",
+			wantBody:          "Bad Gateway: unexpected error during upstream authentication\n",
+			wantSessionStatus: devicecode.StatusDenied,
+		},
+	}
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			secrets := client.CoreV1().Secrets("some-namespace")
+
+			timeoutsConfiguration := oidc.DefaultOIDCTimeoutsConfiguration()
+			oauthStore := oidc.NewKubeStorage(secrets, oidctestutil.NewClientManager("some-namespace"), timeoutsConfiguration)
+			hmacSecretFunc := func() [][]byte { return [][]byte{[]byte("some secret - must have at least 32 bytes")} }
+			oauthHelper := oidc.FositeOauth2Helper(oauthStore, downstreamIssuer, hmacSecretFunc, jwks.NewDynamicJWKSProvider(), timeoutsConfiguration)
+
+			// Simulate the device authorization endpoint having already run.
+			request := fosite.NewRequest()
+			request.Client = clientregistry.PinnipedCLI()
+			request.RequestedScope = fosite.Arguments{"openid", "offline_access", "profile"}
+			request.Session = psession.NewPinnipedSession()
+			require.NoError(t, oauthStore.CreateDeviceCodeSession(context.Background(), deviceCodeSignature, deviceUserCode, time.Now().Add(time.Minute), request))
+
+			idp := newTestUpstreamLDAPIdentityProvider(test.authenticateErr)
+			subject := NewLoginHandler(downstreamIssuer, oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(idp).Build(), oauthHelper, oauthStore, happyStateCodec, happyCookieCodec)
+			body := url.Values{"state": {happyDeviceState}, "username": {happyLDAPUsername}, "password": {test.password}}.Encode()
+			req := httptest.NewRequest(http.MethodPost, "/downstream-provider-name/login", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Cookie", happyCSRFCookie)
+			rsp := httptest.NewRecorder()
+			subject.ServeHTTP(rsp, req)
+			t.Logf("response: %#v", rsp)
+			t.Logf("response body: %q", rsp.Body.String())
+
+			testutil.RequireSecurityHeaders(t, rsp)
+			require.Equal(t, test.wantStatus, rsp.Code)
+			testutil.RequireEqualContentType(t, rsp.Header().Get("Content-Type"), test.wantContentType)
+			if test.wantBody != "" {
+				require.Equal(t, test.wantBody, rsp.Body.String())
+			}
+			if test.wantBodyContains != "" {
+				require.Contains(t, rsp.Body.String(), test.wantBodyContains)
+			}
+
+			session, err := oauthStore.GetDeviceCodeSession(context.Background(), deviceCodeSignature)
+			require.NoError(t, err)
+			require.Equal(t, test.wantSessionStatus, session.Status)
+			if test.wantSessionStatus == devicecode.StatusApproved {
+				pinnipedSession := session.Request.GetSession().(*psession.PinnipedSession)
+				require.Equal(t, upstreamLDAPURL+"&sub="+upstreamLDAPUID, pinnipedSession.Claims.Subject)
+				require.Equal(t, upstreamUsername, pinnipedSession.Claims.Extra["username"])
+				require.Equal(t, happyLDAPUsername, pinnipedSession.Custom.LDAP.Username)
+			}
+		})
+	}
+}
+
+func newTestUpstreamLDAPIdentityProvider(authenticateErr error) *oidctestutil.TestUpstreamLDAPIdentityProvider {
+	parsedUpstreamLDAPURL, err := url.Parse(upstreamLDAPURL)
+	if err != nil {
+		panic(err)
+	}
+	return &oidctestutil.TestUpstreamLDAPIdentityProvider{
+		Name: happyUpstreamIDPName,
+		URL:  parsedUpstreamLDAPURL,
+		AuthenticateFunc: func(ctx context.Context, username, password string) (*authenticator.Response, bool, error) {
+			if authenticateErr != nil {
+				return nil, false, authenticateErr
+			}
+			if username != happyLDAPUsername || password != happyLDAPPassword {
+				return nil, false, nil
+			}
+			return &authenticator.Response{
+				User: &user.DefaultInfo{
+					Name:   upstreamUsername,
+					UID:    upstreamLDAPUID,
+					Groups: upstreamGroupMembership,
+				},
+			}, true, nil
+		},
+	}
+}
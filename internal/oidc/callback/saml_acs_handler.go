@@ -0,0 +1,216 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package callback
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+
+	"github.com/ory/fosite"
+
+	"go.pinniped.dev/internal/auditlog"
+	"go.pinniped.dev/internal/fositestorage/devicecode"
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/httputil/securityheader"
+	"go.pinniped.dev/internal/metrics"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/downstreamsession"
+	"go.pinniped.dev/internal/oidc/idpdiscovery"
+	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/oidc/provider/formposthtml"
+	"go.pinniped.dev/internal/oidc/provider/samlposthtml"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/psession"
+)
+
+const (
+	samlResponseParamName = "SAMLResponse"
+	relayStateParamName   = "RelayState"
+
+	// repostedParamName marks a response which was posted again by the browser from the Supervisor's own page.
+	repostedParamName = "pinniped_reposted"
+)
+
+// NewSAMLACSHandler returns a handler for the SAML assertion consumer service endpoint, to which an upstream SAML
+// provider posts its response to an authentication request. It finishes the login in the same way that the OIDC
+// callback endpoint does.
+func NewSAMLACSHandler(
+	downstreamIssuer string,
+	upstreamIDPs oidc.UpstreamIdentityProvidersLister,
+	oauthHelper fosite.OAuth2Provider,
+	deviceCodeStorage devicecode.DeviceCodeStorage,
+	stateDecoder, cookieDecoder oidc.Decoder,
+) http.Handler {
+	acsURL := downstreamIssuer + oidc.SAMLACSEndpointPath
+
+	handler := httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodPost {
+			return httperr.Newf(http.StatusMethodNotAllowed, "%s (try POST)", r.Method)
+		}
+
+		samlResponse := r.PostFormValue(samlResponseParamName)
+		if samlResponse == "" {
+			plog.Info("SAMLResponse param not found")
+			return httperr.New(http.StatusBadRequest, "SAMLResponse param not found")
+		}
+		relayState := r.PostFormValue(relayStateParamName)
+		if relayState == "" {
+			plog.Info("RelayState param not found")
+			return httperr.New(http.StatusBadRequest, "RelayState param not found")
+		}
+
+		if _, err := r.Cookie(oidc.CSRFCookieName); err != nil && r.PostFormValue(repostedParamName) == "" {
+			// The CSRF cookie is SameSite=Lax, so browsers do not send it along with the cross-site post from the
+			// upstream provider. Have the browser post the response again from this site, which sends the cookie.
+			return repostSAMLResponse(w, acsURL, samlResponse, relayState)
+		}
+
+		csrfValue, err := readCSRFCookie(r, cookieDecoder)
+		if err != nil {
+			plog.InfoErr("error reading CSRF cookie", err)
+			return err
+		}
+
+		state, err := readState(relayState, stateDecoder)
+		if err != nil {
+			plog.InfoErr("error reading state", err)
+			return err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(state.CSRFToken), []byte(csrfValue)) != 1 {
+			plog.Info("CSRF value does not match")
+			return httperr.New(http.StatusForbidden, "CSRF value does not match")
+		}
+
+		samlUpstream := findUpstreamSAMLIDPConfig(state.UpstreamName, state.UpstreamType, upstreamIDPs)
+		if samlUpstream == nil {
+			plog.Warning("upstream provider not found")
+			return httperr.New(http.StatusUnprocessableEntity, "upstream provider not found")
+		}
+
+		makeSession := func(clientID string) (*psession.PinnipedSession, error) {
+			return makeDownstreamSessionFromUpstreamSAML(r, downstreamIssuer, acsURL, clientID, upstreamIDPs, samlUpstream, state, samlResponse)
+		}
+
+		if state.DeviceUserCode != "" {
+			// This login was started by the device verification endpoint, so there is no downstream authorization
+			// request. Instead, approve the device authorization request.
+			return handleDeviceCallback(r, w, deviceCodeStorage, state.DeviceUserCode, samlUpstream.GetName(), makeSession)
+		}
+
+		return finishDownstreamAuthorize(r, w, oauthHelper, state.AuthParams, samlUpstream.GetName(), makeSession)
+	})
+	return securityheader.WrapWithCustomCSP(handler, formposthtml.ContentSecurityPolicy())
+}
+
+// repostSAMLResponse renders a page which makes the browser post the response of the upstream provider to the
+// assertion consumer service again, this time marked as reposted.
+func repostSAMLResponse(w http.ResponseWriter, acsURL string, samlResponse string, relayState string) error {
+	params := url.Values{}
+	params.Set(samlResponseParamName, samlResponse)
+	params.Set(relayStateParamName, relayState)
+	params.Set(repostedParamName, "true")
+
+	// This page has its own inline JS, so override the default CSP header which was already set by the wrapper.
+	w.Header().Set("Content-Security-Policy", samlposthtml.ContentSecurityPolicy())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := samlposthtml.Template().Execute(w, &samlposthtml.PageData{FormAction: acsURL, Parameters: params}); err != nil {
+		// It's too late to return an error response at this point, so just log it.
+		plog.Error("error rendering SAML post page", err)
+	}
+	return nil
+}
+
+// makeDownstreamSessionFromUpstreamSAML validates the response of the upstream SAML provider and makes a downstream
+// session for the upstream user. The result of the login is recorded in the metrics of the downstream issuer and in
+// the audit log.
+func makeDownstreamSessionFromUpstreamSAML(
+	r *http.Request,
+	downstreamIssuer string,
+	acsURL string,
+	clientID string,
+	transformsLister oidc.IdentityTransformsLister,
+	samlUpstream provider.UpstreamSAMLIdentityProviderI,
+	state *oidc.UpstreamStateParamData,
+	samlResponse string,
+) (*psession.PinnipedSession, error) {
+	recordLogin := func(result string) {
+		metrics.RecordLogin(downstreamIssuer, samlUpstream.GetName(), string(psession.ProviderTypeSAML), result)
+	}
+	recordAuditEvent := func(outcome auditlog.Outcome, reason string, user *auditlog.User) {
+		auditlog.Record(auditlog.Event{
+			Type:                     auditlog.EventTypeSupervisorCallback,
+			Outcome:                  outcome,
+			Reason:                   reason,
+			SourceIP:                 auditlog.SourceIP(r),
+			ForwardedFor:             auditlog.ForwardedFor(r),
+			FederationDomain:         downstreamIssuer,
+			ClientID:                 clientID,
+			UpstreamIdentityProvider: &auditlog.IdentityProvider{Name: samlUpstream.GetName(), Type: string(psession.ProviderTypeSAML)},
+			User:                     user,
+		})
+	}
+
+	authenticateResponse, err := samlUpstream.ValidateResponse(
+		r.Context(),
+		samlResponse,
+		oidc.SAMLRequestID(state.Nonce),
+		downstreamIssuer,
+		acsURL,
+	)
+	if err != nil {
+		plog.WarningErr("error validating upstream SAML response", err, "upstreamName", samlUpstream.GetName())
+		recordLogin(metrics.ResultFailure)
+		recordAuditEvent(auditlog.OutcomeFailure, err.Error(), nil)
+		return nil, httperr.New(http.StatusUnprocessableEntity, "error validating upstream SAML response")
+	}
+
+	nameID := authenticateResponse.User.GetUID()
+	upstreamUsername := authenticateResponse.User.GetName()
+	upstreamGroups := authenticateResponse.User.GetGroups()
+
+	transforms := transformsLister.GetIdentityTransforms(samlUpstream.GetName(), string(psession.ProviderTypeSAML))
+	username, groups, err := transforms.Evaluate(upstreamUsername, upstreamGroups)
+	if err != nil {
+		plog.Info("identity transforms denied the login", "upstreamName", samlUpstream.GetName(), "reason", err.Error())
+		recordLogin(metrics.ResultFailure)
+		recordAuditEvent(auditlog.OutcomeFailure, err.Error(), &auditlog.User{Username: upstreamUsername, Groups: upstreamGroups})
+		return nil, httperr.New(http.StatusForbidden, "login denied by identity transforms")
+	}
+
+	// SAML has no refresh, so remember the upstream identity to apply the identity transforms again during refresh.
+	customSessionData := &psession.CustomSessionData{
+		ProviderName: samlUpstream.GetName(),
+		ProviderType: psession.ProviderTypeSAML,
+		SAML: &psession.SAMLSessionData{
+			NameID:   nameID,
+			Username: upstreamUsername,
+			Groups:   upstreamGroups,
+		},
+	}
+
+	recordLogin(metrics.ResultSuccess)
+	recordAuditEvent(auditlog.OutcomeSuccess, "", &auditlog.User{Username: username, Groups: groups})
+	return downstreamsession.MakeDownstreamSession(
+		downstreamsession.DownstreamSubjectFromUpstreamSAML(samlUpstream, nameID),
+		username,
+		groups,
+		customSessionData,
+	), nil
+}
+
+// findUpstreamSAMLIDPConfig finds the upstream SAML IDP which was named in the state param.
+func findUpstreamSAMLIDPConfig(upstreamName string, upstreamType string, upstreamIDPs oidc.UpstreamIdentityProvidersLister) provider.UpstreamSAMLIdentityProviderI {
+	if upstreamType != idpdiscovery.IDPTypeSAML {
+		return nil
+	}
+	for _, p := range upstreamIDPs.GetSAMLIdentityProviders() {
+		if p.GetName() == upstreamName {
+			return p
+		}
+	}
+	return nil
+}
@@ -0,0 +1,471 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package callback
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/ory/fosite"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"go.pinniped.dev/internal/fositestorage/devicecode"
+	"go.pinniped.dev/internal/idtransform"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/oidc/jwks"
+	"go.pinniped.dev/internal/oidc/provider/samlposthtml"
+	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/internal/testutil"
+	"go.pinniped.dev/internal/testutil/oidctestutil"
+)
+
+const (
+	upstreamSAMLEntityID      = "https://some-saml-idp.example.com/metadata"
+	upstreamSAMLNameID        = "some user@example.com" // has a space character which should get escaped in the subject
+	queryEscapedSAMLNameID    = "some+user%40example.com"
+	happyUpstreamSAMLResponse = "some-base64-saml-response"
+)
+
+func TestSAMLACSEndpoint(t *testing.T) {
+	var stateEncoderHashKey = []byte("fake-hash-secret")
+	var stateEncoderBlockKey = []byte("0123456789ABCDEF") // block encryption requires 16/24/32 bytes for AES
+	var cookieEncoderHashKey = []byte("fake-hash-secret2")
+	var cookieEncoderBlockKey = []byte("0123456789ABCDE2") // block encryption requires 16/24/32 bytes for AES
+
+	var happyStateCodec = securecookie.New(stateEncoderHashKey, stateEncoderBlockKey)
+	happyStateCodec.SetSerializer(securecookie.JSONEncoder{})
+	var happyCookieCodec = securecookie.New(cookieEncoderHashKey, cookieEncoderBlockKey)
+	happyCookieCodec.SetSerializer(securecookie.JSONEncoder{})
+
+	happySAMLState := happyUpstreamStateParam().WithUpstreamType("saml").Build(t, happyStateCodec)
+
+	encodedIncomingCookieCSRFValue, err := happyCookieCodec.Encode("csrf", happyDownstreamCSRF)
+	require.NoError(t, err)
+	happyCSRFCookie := "__Host-pinniped-csrf=" + encodedIncomingCookieCSRFValue
+
+	happyDownstreamCustomSessionData := &psession.CustomSessionData{
+		ProviderName: happyUpstreamIDPName,
+		ProviderType: psession.ProviderTypeSAML,
+		SAML: &psession.SAMLSessionData{
+			NameID:   upstreamSAMLNameID,
+			Username: upstreamUsername,
+			Groups:   upstreamGroupMembership,
+		},
+	}
+
+	happyValidateResponseArgs := &oidctestutil.ValidateResponseArgs{
+		EncodedResponse: happyUpstreamSAMLResponse,
+		RequestID:       "_" + happyDownstreamNonce,
+		SPEntityID:      downstreamIssuer,
+		ACSURL:          downstreamIssuer + "/saml/acs",
+	}
+
+	// Note that fosite puts the granted scopes as a param in the redirect URI even though the spec doesn't seem to require it
+	happyDownstreamRedirectLocationRegexp := downstreamRedirectURI + `\?code=([^&]+)&scope=openid&state=` + happyDownstreamState
+
+	happyBody := func(modifications map[string]string) string {
+		return shallowCopyAndModifyQuery(url.Values{
+			"SAMLResponse": {happyUpstreamSAMLResponse},
+			"RelayState":   {happySAMLState},
+		}, modifications).Encode()
+	}
+
+	tests := []struct {
+		name string
+
+		validateResponseErr error
+		transforms          []idtransform.Transform
+		method              string
+		body                string
+		csrfCookie          string
+
+		wantStatus                      int
+		wantContentType                 string
+		wantBody                        string
+		wantBodyContains                []string
+		wantContentSecurityPolicy       string
+		wantRedirectLocationRegexp      string
+		wantDownstreamIDTokenSubject    string
+		wantDownstreamIDTokenUsername   string
+		wantDownstreamIDTokenGroups     []string
+		wantDownstreamCustomSessionData *psession.CustomSessionData
+		wantValidateResponseCall        *oidctestutil.ValidateResponseArgs
+	}{
+		{
+			name:                            "POST with good state and cookie and a valid response returns 302 to downstream client callback with its state and code",
+			method:                          http.MethodPost,
+			body:                            happyBody(nil),
+			csrfCookie:                      happyCSRFCookie,
+			wantStatus:                      http.StatusFound,
+			wantRedirectLocationRegexp:      happyDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenSubject:    upstreamSAMLEntityID + "?sub=" + queryEscapedSAMLNameID,
+			wantDownstreamIDTokenUsername:   upstreamUsername,
+			wantDownstreamIDTokenGroups:     upstreamGroupMembership,
+			wantDownstreamCustomSessionData: happyDownstreamCustomSessionData,
+			wantValidateResponseCall:        happyValidateResponseArgs,
+		},
+		{
+			name: "identity transforms change the downstream username and groups, but the session keeps the upstream identity",
+			transforms: []idtransform.Transform{
+				{Type: idtransform.TypePrefix, Prefix: "saml:"},
+				{Type: idtransform.TypePrefix, Target: idtransform.TargetGroups, Prefix: "saml:"},
+				{Type: idtransform.TypeDenyGroups, Regex: "-1$"},
+			},
+			method:                          http.MethodPost,
+			body:                            happyBody(nil),
+			csrfCookie:                      happyCSRFCookie,
+			wantStatus:                      http.StatusFound,
+			wantRedirectLocationRegexp:      happyDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenSubject:    upstreamSAMLEntityID + "?sub=" + queryEscapedSAMLNameID,
+			wantDownstreamIDTokenUsername:   "saml:" + upstreamUsername,
+			wantDownstreamIDTokenGroups:     []string{"saml:test-pinniped-group-0"},
+			wantDownstreamCustomSessionData: happyDownstreamCustomSessionData,
+			wantValidateResponseCall:        happyValidateResponseArgs,
+		},
+		{
+			name:            "identity transforms deny the login",
+			transforms:      []idtransform.Transform{{Type: idtransform.TypeDenyLogin, Regex: "^" + upstreamUsername + "$"}},
+			method:          http.MethodPost,
+			body:            happyBody(nil),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusForbidden,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Forbidden: login denied by identity transforms\n",
+
+			wantValidateResponseCall: happyValidateResponseArgs,
+		},
+		{
+			name:                "the upstream response is not valid",
+			validateResponseErr: errors.New("some validation error"),
+			method:              http.MethodPost,
+			body:                happyBody(nil),
+			csrfCookie:          happyCSRFCookie,
+			wantStatus:          http.StatusUnprocessableEntity,
+			wantContentType:     "text/plain; charset=utf-8",
+			wantBody:            "Unprocessable Entity: error validating upstream SAML response\n",
+
+			wantValidateResponseCall: happyValidateResponseArgs,
+		},
+		{
+			name:            "without the CSRF cookie, the browser is asked to post the response again from this site",
+			method:          http.MethodPost,
+			body:            happyBody(nil),
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBodyContains: []string{
+				`<form method="post" action="` + downstreamIssuer + `/saml/acs">`,
+				`<input type="hidden" name="SAMLResponse" value="` + happyUpstreamSAMLResponse + `">`,
+				`<input type="hidden" name="pinniped_reposted" value="true">`,
+			},
+			wantContentSecurityPolicy: samlposthtml.ContentSecurityPolicy(),
+		},
+		{
+			name:            "without the CSRF cookie when the response was already posted again",
+			method:          http.MethodPost,
+			body:            happyBody(map[string]string{"pinniped_reposted": "true"}),
+			wantStatus:      http.StatusForbidden,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Forbidden: CSRF cookie is missing\n",
+		},
+		{
+			name:            "the CSRF cookie cannot be decoded",
+			method:          http.MethodPost,
+			body:            happyBody(nil),
+			csrfCookie:      "__Host-pinniped-csrf=this-value-was-not-signed-by-pinniped",
+			wantStatus:      http.StatusForbidden,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Forbidden: error reading CSRF cookie\n",
+		},
+		{
+			name:            "the CSRF value does not match the state",
+			method:          http.MethodPost,
+			body:            happyBody(map[string]string{"RelayState": happyUpstreamStateParam().WithUpstreamType("saml").WithCSRF("other-csrf").Build(t, happyStateCodec)}),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusForbidden,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Forbidden: CSRF value does not match\n",
+		},
+		{
+			name:            "GET is not allowed",
+			method:          http.MethodGet,
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusMethodNotAllowed,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Method Not Allowed: GET (try POST)\n",
+		},
+		{
+			name:            "the SAMLResponse param is missing",
+			method:          http.MethodPost,
+			body:            happyBody(map[string]string{"SAMLResponse": ""}),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusBadRequest,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Bad Request: SAMLResponse param not found\n",
+		},
+		{
+			name:            "the RelayState param is missing",
+			method:          http.MethodPost,
+			body:            happyBody(map[string]string{"RelayState": ""}),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusBadRequest,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Bad Request: RelayState param not found\n",
+		},
+		{
+			name:            "the RelayState param cannot be decoded",
+			method:          http.MethodPost,
+			body:            happyBody(map[string]string{"RelayState": "this-value-was-not-signed-by-pinniped"}),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusBadRequest,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Bad Request: error reading state\n",
+		},
+		{
+			name:            "the state is for an OIDC upstream of the same name",
+			method:          http.MethodPost,
+			body:            happyBody(map[string]string{"RelayState": happyUpstreamStateParam().Build(t, happyStateCodec)}),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Unprocessable Entity: upstream provider not found\n",
+		},
+		{
+			name:   "the state is for a SAML upstream which does not exist",
+			method: http.MethodPost,
+			body: happyBody(map[string]string{"RelayState": func() string {
+				state := happyUpstreamStateParam().WithUpstreamType("saml")
+				state.U = "other-upstream-idp-name"
+				return state.Build(t, happyStateCodec)
+			}()}),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Unprocessable Entity: upstream provider not found\n",
+		},
+		{
+			name:   "the state has invalid downstream auth params",
+			method: http.MethodPost,
+			body: happyBody(map[string]string{"RelayState": happyUpstreamStateParam().WithUpstreamType("saml").WithAuthorizeRequestParams(
+				shallowCopyAndModifyQuery(happyDownstreamRequestParamsQuery, map[string]string{"client_id": "bogus"}).Encode(),
+			).Build(t, happyStateCodec)}),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusBadRequest,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Bad Request: error using state downstream auth params\n",
+		},
+	}
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			secrets := client.CoreV1().Secrets("some-namespace")
+
+			// Configure fosite the same way that the production code would.
+			timeoutsConfiguration := oidc.DefaultOIDCTimeoutsConfiguration()
+			oauthStore := oidc.NewKubeStorage(secrets, oidctestutil.NewClientManager("some-namespace"), timeoutsConfiguration)
+			hmacSecretFunc := func() [][]byte { return [][]byte{[]byte("some secret - must have at least 32 bytes")} }
+			oauthHelper := oidc.FositeOauth2Helper(oauthStore, downstreamIssuer, hmacSecretFunc, jwks.NewDynamicJWKSProvider(), timeoutsConfiguration)
+
+			idp := newTestUpstreamSAMLIdentityProvider(test.validateResponseErr)
+			idpListerBuilder := oidctestutil.NewUpstreamIDPListerBuilder().WithSAML(idp)
+			if test.transforms != nil {
+				transforms, err := idtransform.NewPipeline(test.transforms)
+				require.NoError(t, err)
+				idpListerBuilder.WithIdentityTransforms(idp.Name, "saml", transforms)
+			}
+			subject := NewSAMLACSHandler(downstreamIssuer, idpListerBuilder.Build(), oauthHelper, oauthStore, happyStateCodec, happyCookieCodec)
+			req := httptest.NewRequest(test.method, "/downstream-provider-name/saml/acs", strings.NewReader(test.body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if test.csrfCookie != "" {
+				req.Header.Set("Cookie", test.csrfCookie)
+			}
+			rsp := httptest.NewRecorder()
+			subject.ServeHTTP(rsp, req)
+			t.Logf("response: %#v", rsp)
+			t.Logf("response body: %q", rsp.Body.String())
+
+			testutil.RequireSecurityHeaders(t, rsp)
+
+			if test.wantValidateResponseCall != nil {
+				require.Equal(t, 1, idp.ValidateResponseCallCount())
+				test.wantValidateResponseCall.Ctx = req.Context()
+				require.Equal(t, test.wantValidateResponseCall, idp.ValidateResponseArgs(0))
+			} else {
+				require.Equal(t, 0, idp.ValidateResponseCallCount())
+			}
+
+			require.Equal(t, test.wantStatus, rsp.Code)
+			testutil.RequireEqualContentType(t, rsp.Header().Get("Content-Type"), test.wantContentType)
+			if test.wantContentSecurityPolicy != "" {
+				require.Equal(t, test.wantContentSecurityPolicy, rsp.Header().Get("Content-Security-Policy"))
+			}
+
+			switch {
+			case test.wantBody != "":
+				require.Equal(t, test.wantBody, rsp.Body.String())
+			case test.wantBodyContains != nil:
+				for _, wantContains := range test.wantBodyContains {
+					require.Contains(t, rsp.Body.String(), wantContains)
+				}
+			default:
+				require.Empty(t, rsp.Body.String())
+			}
+
+			if test.wantRedirectLocationRegexp != "" {
+				require.Len(t, rsp.Header().Values("Location"), 1)
+				oidctestutil.RequireAuthCodeRegexpMatch(
+					t,
+					rsp.Header().Get("Location"),
+					test.wantRedirectLocationRegexp,
+					client,
+					secrets,
+					oauthStore,
+					happyDownstreamScopesGranted,
+					test.wantDownstreamIDTokenSubject,
+					test.wantDownstreamIDTokenUsername,
+					test.wantDownstreamIDTokenGroups,
+					happyDownstreamScopesRequested,
+					downstreamPKCEChallenge,
+					downstreamPKCEChallengeMethod,
+					downstreamNonce,
+					downstreamClientID,
+					downstreamRedirectURI,
+					test.wantDownstreamCustomSessionData,
+				)
+			}
+		})
+	}
+}
+
+func TestSAMLACSEndpointForDeviceAuthorization(t *testing.T) {
+	const (
+		deviceCodeSignature = "some-device-code-signature"
+		deviceUserCode      = "WDJB-MJHT"
+	)
+
+	var stateEncoderHashKey = []byte("fake-hash-secret")
+	var stateEncoderBlockKey = []byte("0123456789ABCDEF") // block encryption requires 16/24/32 bytes for AES
+	var cookieEncoderHashKey = []byte("fake-hash-secret2")
+	var cookieEncoderBlockKey = []byte("0123456789ABCDE2") // block encryption requires 16/24/32 bytes for AES
+
+	var happyStateCodec = securecookie.New(stateEncoderHashKey, stateEncoderBlockKey)
+	happyStateCodec.SetSerializer(securecookie.JSONEncoder{})
+	var happyCookieCodec = securecookie.New(cookieEncoderHashKey, cookieEncoderBlockKey)
+	happyCookieCodec.SetSerializer(securecookie.JSONEncoder{})
+
+	happyDeviceState := happyUpstreamStateParam().WithUpstreamType("saml").WithDeviceUserCode("WDJBMJHT").Build(t, happyStateCodec)
+
+	encodedIncomingCookieCSRFValue, err := happyCookieCodec.Encode("csrf", happyDownstreamCSRF)
+	require.NoError(t, err)
+	happyCSRFCookie := "__Host-pinniped-csrf=" + encodedIncomingCookieCSRFValue
+
+	tests := []struct {
+		name                string
+		validateResponseErr error
+
+		wantStatus        int
+		wantContentType   string
+		wantBody          string
+		wantBodyContains  string
+		wantSessionStatus devicecode.Status
+	}{
+		{
+			name:              "happy path approves the device authorization request",
+			wantStatus:        http.StatusOK,
+			wantContentType:   htmlContentType,
+			wantBodyContains:  "Your device has been logged in.",
+			wantSessionStatus: devicecode.StatusApproved,
+		},
+		{
+			name:                "the upstream response is not valid, which denies the device authorization request",
+			validateResponseErr: errors.New("some validation error"),
+			wantStatus:          http.StatusUnprocessableEntity,
+			wantContentType:     "text/plain; charset=utf-8",
+			wantBody:            "Unprocessable Entity: error validating upstream SAML response\n",
+			wantSessionStatus:   devicecode.StatusDenied,
+		},
+	}
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			secrets := client.CoreV1().Secrets("some-namespace")
+
+			timeoutsConfiguration := oidc.DefaultOIDCTimeoutsConfiguration()
+			oauthStore := oidc.NewKubeStorage(secrets, oidctestutil.NewClientManager("some-namespace"), timeoutsConfiguration)
+			hmacSecretFunc := func() [][]byte { return [][]byte{[]byte("some secret - must have at least 32 bytes")} }
+			oauthHelper := oidc.FositeOauth2Helper(oauthStore, downstreamIssuer, hmacSecretFunc, jwks.NewDynamicJWKSProvider(), timeoutsConfiguration)
+
+			// Simulate the device authorization endpoint having already run.
+			request := fosite.NewRequest()
+			request.Client = clientregistry.PinnipedCLI()
+			request.RequestedScope = fosite.Arguments{"openid", "offline_access", "profile"}
+			request.Session = psession.NewPinnipedSession()
+			require.NoError(t, oauthStore.CreateDeviceCodeSession(context.Background(), deviceCodeSignature, deviceUserCode, time.Now().Add(time.Minute), request))
+
+			idp := newTestUpstreamSAMLIdentityProvider(test.validateResponseErr)
+			subject := NewSAMLACSHandler(downstreamIssuer, oidctestutil.NewUpstreamIDPListerBuilder().WithSAML(idp).Build(), oauthHelper, oauthStore, happyStateCodec, happyCookieCodec)
+			body := url.Values{"SAMLResponse": {happyUpstreamSAMLResponse}, "RelayState": {happyDeviceState}}.Encode()
+			req := httptest.NewRequest(http.MethodPost, "/downstream-provider-name/saml/acs", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Cookie", happyCSRFCookie)
+			rsp := httptest.NewRecorder()
+			subject.ServeHTTP(rsp, req)
+			t.Logf("response: %#v", rsp)
+			t.Logf("response body: %q", rsp.Body.String())
+
+			testutil.RequireSecurityHeaders(t, rsp)
+			require.Equal(t, test.wantStatus, rsp.Code)
+			testutil.RequireEqualContentType(t, rsp.Header().Get("Content-Type"), test.wantContentType)
+			if test.wantBody != "" {
+				require.Equal(t, test.wantBody, rsp.Body.String())
+			}
+			if test.wantBodyContains != "" {
+				require.Contains(t, rsp.Body.String(), test.wantBodyContains)
+			}
+
+			session, err := oauthStore.GetDeviceCodeSession(context.Background(), deviceCodeSignature)
+			require.NoError(t, err)
+			require.Equal(t, test.wantSessionStatus, session.Status)
+			if test.wantSessionStatus == devicecode.StatusApproved {
+				pinnipedSession := session.Request.GetSession().(*psession.PinnipedSession)
+				require.Equal(t, upstreamSAMLEntityID+"?sub="+queryEscapedSAMLNameID, pinnipedSession.Claims.Subject)
+				require.Equal(t, upstreamUsername, pinnipedSession.Claims.Extra["username"])
+				require.Equal(t, upstreamSAMLNameID, pinnipedSession.Custom.SAML.NameID)
+			}
+		})
+	}
+}
+
+func newTestUpstreamSAMLIdentityProvider(validateResponseErr error) *oidctestutil.TestUpstreamSAMLIdentityProvider {
+	return &oidctestutil.TestUpstreamSAMLIdentityProvider{
+		Name:     happyUpstreamIDPName,
+		EntityID: upstreamSAMLEntityID,
+		ValidateResponseFunc: func(ctx context.Context, encodedResponse string, requestID string, spEntityID string, acsURL string) (*authenticator.Response, error) {
+			if validateResponseErr != nil {
+				return nil, validateResponseErr
+			}
+			return &authenticator.Response{
+				User: &user.DefaultInfo{
+					Name:   upstreamUsername,
+					UID:    upstreamSAMLNameID,
+					Groups: upstreamGroupMembership,
+				},
+			}, nil
+		},
+	}
+}
@@ -7,10 +7,22 @@ package clientregistry
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/ory/fosite"
+	"golang.org/x/crypto/bcrypt"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/errors"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	configv1alpha1listers "go.pinniped.dev/generated/latest/client/supervisor/listers/config/v1alpha1"
+	"go.pinniped.dev/internal/plog"
 )
 
 // Client represents a Pinniped OAuth/OIDC client.
@@ -30,28 +42,87 @@ var (
 	_ fosite.ResponseModeClient  = (*Client)(nil)
 )
 
-// StaticClientManager is a fosite.ClientManager with statically-defined clients.
-type StaticClientManager struct{}
+const (
+	// PinnipedCLIClientID is the client ID of the static Pinniped CLI client.
+	PinnipedCLIClientID = "pinniped-cli"
+
+	// OIDCClientNamePrefix is the prefix which the names, and therefore the client IDs, of OIDCClients must have.
+	// Audiences which are requested through token exchange can never contain ".pinniped.dev", so the ID tokens which
+	// are issued for them can never be accepted by an OIDCClient.
+	OIDCClientNamePrefix = "client.oauth.pinniped.dev-"
+
+	// OIDCClientSecretType is the type of the Secret which holds the hashed client secret of an OIDCClient.
+	OIDCClientSecretType corev1.SecretType = "secrets.pinniped.dev/oidc-client-secret-hash" //nolint:gosec // this is not a credential
 
-var _ fosite.ClientManager = (*StaticClientManager)(nil)
+	// OIDCClientSecretBcryptHashKey is the key in an OIDCClient's Secret which holds the bcrypt hash of the client secret.
+	OIDCClientSecretBcryptHashKey = "bcryptHash"
+
+	// minimumBcryptCost is the lowest bcrypt cost which will be accepted for a hashed client secret.
+	minimumBcryptCost = 12
+)
 
-// GetClient returns a static client specified by the given ID.
+// ClientManager is a fosite.ClientManager which knows about the static Pinniped CLI client and about the dynamic
+// clients configured by OIDCClient resources.
+type ClientManager struct {
+	oidcClientsLister configv1alpha1listers.OIDCClientNamespaceLister
+	secretsLister     corev1listers.SecretNamespaceLister
+}
+
+var _ fosite.ClientManager = (*ClientManager)(nil)
+
+// NewClientManager returns a ClientManager which looks up dynamic clients using the given listers, which should
+// both be scoped to the namespace in which the Supervisor is installed.
+func NewClientManager(
+	oidcClientsLister configv1alpha1listers.OIDCClientNamespaceLister,
+	secretsLister corev1listers.SecretNamespaceLister,
+) *ClientManager {
+	return &ClientManager{
+		oidcClientsLister: oidcClientsLister,
+		secretsLister:     secretsLister,
+	}
+}
+
+// GetClient returns the client specified by the given ID, which is either the static Pinniped CLI client or the
+// name of a valid OIDCClient.
 //
-// It returns a fosite.ErrNotFound if an unknown client is specified.
-func (StaticClientManager) GetClient(_ context.Context, id string) (fosite.Client, error) {
-	switch id {
-	case "pinniped-cli":
+// It returns a fosite.ErrNotFound if an unknown or invalid client is specified.
+func (m *ClientManager) GetClient(_ context.Context, id string) (fosite.Client, error) {
+	if id == PinnipedCLIClientID {
 		return PinnipedCLI(), nil
-	default:
+	}
+
+	if !strings.HasPrefix(id, OIDCClientNamePrefix) {
+		return nil, fosite.ErrNotFound.WithDescription("no such client")
+	}
+
+	oidcClient, err := m.oidcClientsLister.Get(id)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			plog.Error("failed to get OIDCClient", err, "clientID", id)
+		}
 		return nil, fosite.ErrNotFound.WithDescription("no such client")
 	}
+
+	// When the Secret cannot be found, ClientFromOIDCClient will report that the client is invalid.
+	secret, err := m.secretsLister.Get(oidcClient.Spec.ClientSecretName)
+	if err != nil && !apierrors.IsNotFound(err) {
+		plog.Error("failed to get OIDCClient secret", err, "clientID", id)
+	}
+
+	client, err := ClientFromOIDCClient(oidcClient, secret)
+	if err != nil {
+		plog.DebugErr("ignoring invalid OIDCClient", err, "clientID", id)
+		return nil, fosite.ErrNotFound.WithDescription("no such client")
+	}
+
+	return client, nil
 }
 
 // ClientAssertionJWTValid returns an error if the JTI is
 // known or the DB check failed and nil if the JTI is not known.
 //
-// This functionality is not supported by the StaticClientManager.
-func (StaticClientManager) ClientAssertionJWTValid(ctx context.Context, jti string) error {
+// This functionality is not supported by the ClientManager.
+func (*ClientManager) ClientAssertionJWTValid(ctx context.Context, jti string) error {
 	return fmt.Errorf("not implemented")
 }
 
@@ -60,23 +131,144 @@ func (StaticClientManager) ClientAssertionJWTValid(ctx context.Context, jti stri
 // up any existing JTIs that have expired as those tokens can
 // not be replayed due to the expiry.
 //
-// This functionality is not supported by the StaticClientManager.
-func (StaticClientManager) SetClientAssertionJWT(ctx context.Context, jti string, exp time.Time) error {
+// This functionality is not supported by the ClientManager.
+func (*ClientManager) SetClientAssertionJWT(ctx context.Context, jti string, exp time.Time) error {
 	return fmt.Errorf("not implemented")
 }
 
+// ClientFromOIDCClient validates the given OIDCClient and the Secret which it references, and converts them into a
+// confidential Client. The secret may be nil when it could not be found. All validation problems are returned
+// together in the error.
+func ClientFromOIDCClient(oidcClient *configv1alpha1.OIDCClient, secret *corev1.Secret) (*Client, error) {
+	var errs []error
+
+	if !strings.HasPrefix(oidcClient.Name, OIDCClientNamePrefix) {
+		errs = append(errs, fmt.Errorf("the name of an OIDCClient must start with %q", OIDCClientNamePrefix))
+	}
+
+	redirectURIs := make([]string, 0, len(oidcClient.Spec.AllowedRedirectURIs))
+	for _, redirectURI := range oidcClient.Spec.AllowedRedirectURIs {
+		if err := validateRedirectURI(string(redirectURI)); err != nil {
+			errs = append(errs, err)
+		}
+		redirectURIs = append(redirectURIs, string(redirectURI))
+	}
+
+	grantTypes := make(fosite.Arguments, 0, len(oidcClient.Spec.AllowedGrantTypes))
+	for _, grantType := range oidcClient.Spec.AllowedGrantTypes {
+		grantTypes = append(grantTypes, string(grantType))
+	}
+
+	scopes := make(fosite.Arguments, 0, len(oidcClient.Spec.AllowedScopes))
+	for _, scope := range oidcClient.Spec.AllowedScopes {
+		scopes = append(scopes, string(scope))
+	}
+
+	errs = append(errs, validateGrantTypesAndScopes(grantTypes, scopes)...)
+
+	hashedSecret, err := hashedSecretFromSecret(oidcClient.Spec.ClientSecretName, secret)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.NewAggregate(errs)
+	}
+
+	return &Client{
+		DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{
+			DefaultClient: &fosite.DefaultClient{
+				ID:            oidcClient.Name,
+				Secret:        hashedSecret,
+				RedirectURIs:  redirectURIs,
+				GrantTypes:    grantTypes,
+				ResponseTypes: []string{"code"},
+				Scopes:        scopes,
+				Audience:      nil,
+				Public:        false,
+			},
+			RequestURIs:                       nil,
+			JSONWebKeys:                       nil,
+			JSONWebKeysURI:                    "",
+			RequestObjectSigningAlgorithm:     "",
+			TokenEndpointAuthSigningAlgorithm: oidc.RS256,
+			TokenEndpointAuthMethod:           "client_secret_basic",
+		},
+	}, nil
+}
+
+func validateRedirectURI(redirectURI string) error {
+	parsed, err := url.Parse(redirectURI)
+	if err != nil {
+		return fmt.Errorf("redirect URI %q is not a valid URL: %w", redirectURI, err)
+	}
+	switch {
+	case parsed.Scheme == "https" && parsed.Host != "":
+		return nil
+	case parsed.Scheme == "http" && (parsed.Hostname() == "127.0.0.1" || parsed.Hostname() == "::1"):
+		return nil
+	default:
+		return fmt.Errorf("redirect URI %q must use the https scheme, or the http scheme with a loopback IP address host", redirectURI)
+	}
+}
+
+func validateGrantTypesAndScopes(grantTypes, scopes fosite.Arguments) []error {
+	var errs []error
+	if !grantTypes.Has("authorization_code") {
+		errs = append(errs, fmt.Errorf(`allowedGrantTypes must include "authorization_code"`))
+	}
+	if !scopes.Has(oidc.ScopeOpenID) {
+		errs = append(errs, fmt.Errorf(`allowedScopes must include "openid"`))
+	}
+	for grantType, scope := range map[string]string{
+		"refresh_token": oidc.ScopeOfflineAccess,
+		"urn:ietf:params:oauth:grant-type:token-exchange": "pinniped:request-audience",
+	} {
+		if grantTypes.Has(grantType) && !scopes.Has(scope) {
+			errs = append(errs, fmt.Errorf("the %q grant type requires the %q scope to be allowed", grantType, scope))
+		}
+		if scopes.Has(scope) && !grantTypes.Has(grantType) {
+			errs = append(errs, fmt.Errorf("the %q scope requires the %q grant type to be allowed", scope, grantType))
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errs
+}
+
+func hashedSecretFromSecret(secretName string, secret *corev1.Secret) ([]byte, error) {
+	if secret == nil {
+		return nil, fmt.Errorf("secret %q not found", secretName)
+	}
+	if secret.Type != OIDCClientSecretType {
+		return nil, fmt.Errorf("secret %q must be of type %q", secretName, OIDCClientSecretType)
+	}
+	hashedSecret := secret.Data[OIDCClientSecretBcryptHashKey]
+	if len(hashedSecret) == 0 {
+		return nil, fmt.Errorf("secret %q is missing the %q key", secretName, OIDCClientSecretBcryptHashKey)
+	}
+	cost, err := bcrypt.Cost(hashedSecret)
+	if err != nil {
+		return nil, fmt.Errorf("secret %q does not contain a valid bcrypt hash: %w", secretName, err)
+	}
+	if cost < minimumBcryptCost {
+		return nil, fmt.Errorf("secret %q contains a bcrypt hash with cost %d, but the minimum cost is %d", secretName, cost, minimumBcryptCost)
+	}
+	return hashedSecret, nil
+}
+
 // PinnipedCLI returns the static Client corresponding to the Pinniped CLI.
 func PinnipedCLI() *Client {
 	return &Client{
 		DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{
 			DefaultClient: &fosite.DefaultClient{
-				ID:           "pinniped-cli",
+				ID:           PinnipedCLIClientID,
 				Secret:       nil,
 				RedirectURIs: []string{"http://127.0.0.1/callback"},
 				GrantTypes: fosite.Arguments{
 					"authorization_code",
 					"refresh_token",
 					"urn:ietf:params:oauth:grant-type:token-exchange",
+					"urn:ietf:params:oauth:grant-type:device_code",
 				},
 				ResponseTypes: []string{"code"},
 				Scopes: fosite.Arguments{
@@ -12,35 +12,310 @@ import (
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/ory/fosite"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	configv1alpha1listers "go.pinniped.dev/generated/latest/client/supervisor/listers/config/v1alpha1"
 )
 
-func TestStaticRegistry(t *testing.T) {
+func TestClientManager(t *testing.T) {
 	ctx := context.Background()
 
-	t.Run("unimplemented methods", func(t *testing.T) {
-		registry := StaticClientManager{}
-		require.EqualError(t, registry.ClientAssertionJWTValid(ctx, "some-token-id"), "not implemented")
-		require.EqualError(t, registry.SetClientAssertionJWT(ctx, "some-token-id", time.Now()), "not implemented")
-	})
+	const namespace = "some-namespace"
 
-	t.Run("not found", func(t *testing.T) {
-		registry := StaticClientManager{}
-		got, err := registry.GetClient(ctx, "does-not-exist")
+	hashedSecret, err := bcrypt.GenerateFromPassword([]byte("some-client-secret"), minimumBcryptCost)
+	require.NoError(t, err)
+
+	validOIDCClient := &configv1alpha1.OIDCClient{
+		ObjectMeta: metav1.ObjectMeta{Name: "client.oauth.pinniped.dev-some-client", Namespace: namespace},
+		Spec: configv1alpha1.OIDCClientSpec{
+			AllowedRedirectURIs: []configv1alpha1.RedirectURI{"https://example.com/callback"},
+			AllowedGrantTypes:   []configv1alpha1.GrantType{"authorization_code"},
+			AllowedScopes:       []configv1alpha1.Scope{"openid"},
+			ClientSecretName:    "some-client-secret",
+		},
+	}
+	invalidOIDCClient := validOIDCClient.DeepCopy()
+	invalidOIDCClient.Name = "client.oauth.pinniped.dev-invalid-client"
+	invalidOIDCClient.Spec.AllowedScopes = []configv1alpha1.Scope{"email"}
+	clientWithoutSecret := validOIDCClient.DeepCopy()
+	clientWithoutSecret.Name = "client.oauth.pinniped.dev-client-without-secret"
+	clientWithoutSecret.Spec.ClientSecretName = "does-not-exist"
+	clientWithoutPrefix := validOIDCClient.DeepCopy()
+	clientWithoutPrefix.Name = "client-without-prefix"
+	validSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "some-client-secret", Namespace: namespace},
+		Type:       OIDCClientSecretType,
+		Data:       map[string][]byte{OIDCClientSecretBcryptHashKey: hashedSecret},
+	}
+
+	oidcClientsIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	secretsIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, oidcClientsIndexer.Add(validOIDCClient))
+	require.NoError(t, oidcClientsIndexer.Add(invalidOIDCClient))
+	require.NoError(t, oidcClientsIndexer.Add(clientWithoutSecret))
+	require.NoError(t, oidcClientsIndexer.Add(clientWithoutPrefix))
+	require.NoError(t, secretsIndexer.Add(validSecret))
+
+	registry := NewClientManager(
+		configv1alpha1listers.NewOIDCClientLister(oidcClientsIndexer).OIDCClients(namespace),
+		corev1listers.NewSecretLister(secretsIndexer).Secrets(namespace),
+	)
+
+	requireNotFound := func(t *testing.T, got fosite.Client, err error) {
+		t.Helper()
 		require.Error(t, err)
 		require.Nil(t, got)
 		rfcErr := fosite.ErrorToRFC6749Error(err)
 		require.NotNil(t, rfcErr)
 		require.Equal(t, rfcErr.CodeField, 404)
 		require.Equal(t, rfcErr.GetDescription(), "no such client")
+	}
+
+	t.Run("unimplemented methods", func(t *testing.T) {
+		require.EqualError(t, registry.ClientAssertionJWTValid(ctx, "some-token-id"), "not implemented")
+		require.EqualError(t, registry.SetClientAssertionJWT(ctx, "some-token-id", time.Now()), "not implemented")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		got, err := registry.GetClient(ctx, "client.oauth.pinniped.dev-does-not-exist")
+		requireNotFound(t, got, err)
+	})
+
+	t.Run("invalid OIDCClient", func(t *testing.T) {
+		got, err := registry.GetClient(ctx, "client.oauth.pinniped.dev-invalid-client")
+		requireNotFound(t, got, err)
+	})
+
+	t.Run("OIDCClient whose Secret does not exist", func(t *testing.T) {
+		got, err := registry.GetClient(ctx, "client.oauth.pinniped.dev-client-without-secret")
+		requireNotFound(t, got, err)
+	})
+
+	t.Run("OIDCClient whose name does not have the required prefix", func(t *testing.T) {
+		got, err := registry.GetClient(ctx, "client-without-prefix")
+		requireNotFound(t, got, err)
 	})
 
 	t.Run("pinniped CLI", func(t *testing.T) {
-		registry := StaticClientManager{}
 		got, err := registry.GetClient(ctx, "pinniped-cli")
 		require.NoError(t, err)
 		require.NotNil(t, got)
 		require.IsType(t, &Client{}, got)
+		require.True(t, got.IsPublic())
 	})
+
+	t.Run("valid OIDCClient", func(t *testing.T) {
+		got, err := registry.GetClient(ctx, "client.oauth.pinniped.dev-some-client")
+		require.NoError(t, err)
+		require.NotNil(t, got)
+		require.IsType(t, &Client{}, got)
+		require.Equal(t, "client.oauth.pinniped.dev-some-client", got.GetID())
+		require.False(t, got.IsPublic())
+		require.NoError(t, bcrypt.CompareHashAndPassword(got.GetHashedSecret(), []byte("some-client-secret")))
+	})
+}
+
+func TestClientFromOIDCClient(t *testing.T) {
+	hashedSecret, err := bcrypt.GenerateFromPassword([]byte("some-client-secret"), minimumBcryptCost)
+	require.NoError(t, err)
+	weakHashedSecret, err := bcrypt.GenerateFromPassword([]byte("some-client-secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	validSecret := func() *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "some-secret", Namespace: "some-namespace"},
+			Type:       "secrets.pinniped.dev/oidc-client-secret-hash",
+			Data:       map[string][]byte{"bcryptHash": hashedSecret},
+		}
+	}
+
+	validOIDCClient := func() *configv1alpha1.OIDCClient {
+		return &configv1alpha1.OIDCClient{
+			ObjectMeta: metav1.ObjectMeta{Name: "client.oauth.pinniped.dev-some-client", Namespace: "some-namespace"},
+			Spec: configv1alpha1.OIDCClientSpec{
+				AllowedRedirectURIs: []configv1alpha1.RedirectURI{
+					"https://example.com/callback",
+					"http://127.0.0.1/callback",
+					"http://[::1]:1234/callback",
+				},
+				AllowedGrantTypes: []configv1alpha1.GrantType{
+					"authorization_code",
+					"refresh_token",
+					"urn:ietf:params:oauth:grant-type:token-exchange",
+				},
+				AllowedScopes: []configv1alpha1.Scope{
+					"openid",
+					"offline_access",
+					"pinniped:request-audience",
+				},
+				ClientSecretName: "some-secret",
+			},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		modifyClient func(*configv1alpha1.OIDCClient)
+		secret       func() *corev1.Secret
+		wantErr      string
+	}{
+		{
+			name:   "valid",
+			secret: validSecret,
+		},
+		{
+			name: "minimal valid client",
+			modifyClient: func(c *configv1alpha1.OIDCClient) {
+				c.Spec.AllowedGrantTypes = []configv1alpha1.GrantType{"authorization_code"}
+				c.Spec.AllowedScopes = []configv1alpha1.Scope{"openid"}
+			},
+			secret: validSecret,
+		},
+		{
+			name:         "name without the required prefix",
+			modifyClient: func(c *configv1alpha1.OIDCClient) { c.Name = "some-client" },
+			secret:       validSecret,
+			wantErr:      `the name of an OIDCClient must start with "client.oauth.pinniped.dev-"`,
+		},
+		{
+			name:         "client ID of the Pinniped CLI",
+			modifyClient: func(c *configv1alpha1.OIDCClient) { c.Name = "pinniped-cli" },
+			secret:       validSecret,
+			wantErr:      `the name of an OIDCClient must start with "client.oauth.pinniped.dev-"`,
+		},
+		{
+			name: "invalid redirect URIs",
+			modifyClient: func(c *configv1alpha1.OIDCClient) {
+				c.Spec.AllowedRedirectURIs = []configv1alpha1.RedirectURI{"http://example.com/callback", "https:///callback", "https://example.com/callback"}
+			},
+			secret: validSecret,
+			wantErr: `[redirect URI "http://example.com/callback" must use the https scheme, or the http scheme with a loopback IP address host, ` +
+				`redirect URI "https:///callback" must use the https scheme, or the http scheme with a loopback IP address host]`,
+		},
+		{
+			name: "missing required grant type and scope",
+			modifyClient: func(c *configv1alpha1.OIDCClient) {
+				c.Spec.AllowedGrantTypes = []configv1alpha1.GrantType{"refresh_token"}
+				c.Spec.AllowedScopes = []configv1alpha1.Scope{"offline_access"}
+			},
+			secret:  validSecret,
+			wantErr: `[allowedGrantTypes must include "authorization_code", allowedScopes must include "openid"]`,
+		},
+		{
+			name: "grant types without their required scopes",
+			modifyClient: func(c *configv1alpha1.OIDCClient) {
+				c.Spec.AllowedScopes = []configv1alpha1.Scope{"openid"}
+			},
+			secret: validSecret,
+			wantErr: `[the "refresh_token" grant type requires the "offline_access" scope to be allowed, ` +
+				`the "urn:ietf:params:oauth:grant-type:token-exchange" grant type requires the "pinniped:request-audience" scope to be allowed]`,
+		},
+		{
+			name: "scopes without their required grant types",
+			modifyClient: func(c *configv1alpha1.OIDCClient) {
+				c.Spec.AllowedGrantTypes = []configv1alpha1.GrantType{"authorization_code"}
+			},
+			secret: validSecret,
+			wantErr: `[the "offline_access" scope requires the "refresh_token" grant type to be allowed, ` +
+				`the "pinniped:request-audience" scope requires the "urn:ietf:params:oauth:grant-type:token-exchange" grant type to be allowed]`,
+		},
+		{
+			name:    "secret not found",
+			secret:  func() *corev1.Secret { return nil },
+			wantErr: `secret "some-secret" not found`,
+		},
+		{
+			name: "secret has wrong type",
+			secret: func() *corev1.Secret {
+				s := validSecret()
+				s.Type = corev1.SecretTypeOpaque
+				return s
+			},
+			wantErr: `secret "some-secret" must be of type "secrets.pinniped.dev/oidc-client-secret-hash"`,
+		},
+		{
+			name: "secret is missing hash",
+			secret: func() *corev1.Secret {
+				s := validSecret()
+				s.Data = map[string][]byte{"other-key": hashedSecret}
+				return s
+			},
+			wantErr: `secret "some-secret" is missing the "bcryptHash" key`,
+		},
+		{
+			name: "secret contains something other than a bcrypt hash",
+			secret: func() *corev1.Secret {
+				s := validSecret()
+				s.Data[OIDCClientSecretBcryptHashKey] = []byte("some-client-secret")
+				return s
+			},
+			wantErr: `secret "some-secret" does not contain a valid bcrypt hash: crypto/bcrypt: hashedSecret too short to be a bcrypted password`,
+		},
+		{
+			name: "secret contains a bcrypt hash with a low cost",
+			secret: func() *corev1.Secret {
+				s := validSecret()
+				s.Data[OIDCClientSecretBcryptHashKey] = weakHashedSecret
+				return s
+			},
+			wantErr: `secret "some-secret" contains a bcrypt hash with cost 4, but the minimum cost is 12`,
+		},
+		{
+			name: "multiple problems",
+			modifyClient: func(c *configv1alpha1.OIDCClient) {
+				c.Spec.AllowedScopes = []configv1alpha1.Scope{"openid", "offline_access"}
+				c.Spec.AllowedGrantTypes = []configv1alpha1.GrantType{"authorization_code"}
+			},
+			secret: func() *corev1.Secret { return nil },
+			wantErr: `[the "offline_access" scope requires the "refresh_token" grant type to be allowed, ` +
+				`secret "some-secret" not found]`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			oidcClient := validOIDCClient()
+			if tt.modifyClient != nil {
+				tt.modifyClient(oidcClient)
+			}
+
+			got, err := ClientFromOIDCClient(oidcClient, tt.secret())
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				require.Nil(t, got)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, oidcClient.Name, got.GetID())
+			require.Equal(t, hashedSecret, got.GetHashedSecret())
+			require.Len(t, got.GetRedirectURIs(), len(oidcClient.Spec.AllowedRedirectURIs))
+			for i, redirectURI := range oidcClient.Spec.AllowedRedirectURIs {
+				require.Equal(t, string(redirectURI), got.GetRedirectURIs()[i])
+			}
+			require.Len(t, got.GetGrantTypes(), len(oidcClient.Spec.AllowedGrantTypes))
+			for _, grantType := range oidcClient.Spec.AllowedGrantTypes {
+				require.True(t, got.GetGrantTypes().Has(string(grantType)))
+			}
+			require.Len(t, got.GetScopes(), len(oidcClient.Spec.AllowedScopes))
+			for _, scope := range oidcClient.Spec.AllowedScopes {
+				require.True(t, got.GetScopes().Has(string(scope)))
+			}
+			require.Equal(t, fosite.Arguments{"code"}, got.GetResponseTypes())
+			require.False(t, got.IsPublic())
+			require.Nil(t, got.GetAudience())
+			require.Equal(t, "client_secret_basic", got.GetTokenEndpointAuthMethod())
+			require.Equal(t, "RS256", got.GetTokenEndpointAuthSigningAlgorithm())
+			require.Equal(t, []fosite.ResponseModeType{"", "query", "form_post"}, got.GetResponseModes())
+		})
+	}
 }
 
 func TestPinnipedCLI(t *testing.T) {
@@ -48,7 +323,7 @@ func TestPinnipedCLI(t *testing.T) {
 	require.Equal(t, "pinniped-cli", c.GetID())
 	require.Nil(t, c.GetHashedSecret())
 	require.Equal(t, []string{"http://127.0.0.1/callback"}, c.GetRedirectURIs())
-	require.Equal(t, fosite.Arguments{"authorization_code", "refresh_token", "urn:ietf:params:oauth:grant-type:token-exchange"}, c.GetGrantTypes())
+	require.Equal(t, fosite.Arguments{"authorization_code", "refresh_token", "urn:ietf:params:oauth:grant-type:token-exchange", "urn:ietf:params:oauth:grant-type:device_code"}, c.GetGrantTypes())
 	require.Equal(t, fosite.Arguments{"code"}, c.GetResponseTypes())
 	require.Equal(t, fosite.Arguments{oidc.ScopeOpenID, oidc.ScopeOfflineAccess, "profile", "email", "pinniped:request-audience"}, c.GetScopes())
 	require.True(t, c.IsPublic())
@@ -72,7 +347,8 @@ func TestPinnipedCLI(t *testing.T) {
 		  "grant_types": [
 			"authorization_code",
 			"refresh_token",
-			"urn:ietf:params:oauth:grant-type:token-exchange"
+			"urn:ietf:params:oauth:grant-type:token-exchange",
+			"urn:ietf:params:oauth:grant-type:device_code"
 		  ],
 		  "response_types": [
 			"code"
@@ -0,0 +1,226 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/compose"
+	"github.com/ory/fosite/handler/oauth2"
+	"github.com/ory/fosite/handler/openid"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"go.pinniped.dev/internal/fositestorage/devicecode"
+)
+
+// DeviceCodeGrantType is the grant_type param value for the device authorization grant from RFC8628.
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code" //nolint: gosec
+
+// These are the additional error responses of the token endpoint which are defined by RFC8628 section 3.5.
+var (
+	ErrAuthorizationPending = &fosite.RFC6749Error{
+		ErrorField:       "authorization_pending",
+		DescriptionField: "The authorization request is still pending as the end user hasn't yet completed the user-interaction steps.",
+		CodeField:        http.StatusBadRequest,
+	}
+	ErrSlowDown = &fosite.RFC6749Error{
+		ErrorField:       "slow_down",
+		DescriptionField: "The authorization request is still pending and the client should poll the token endpoint less frequently.",
+		CodeField:        http.StatusBadRequest,
+	}
+	ErrExpiredToken = &fosite.RFC6749Error{
+		ErrorField:       "expired_token",
+		DescriptionField: "The device_code has expired, and the device authorization session has concluded.",
+		CodeField:        http.StatusBadRequest,
+	}
+)
+
+// DeviceCodeSignature returns the value which is used to store and look up the device authorization request
+// of a device code, so the device code itself never needs to be stored.
+func DeviceCodeSignature(deviceCode string) string {
+	hash := sha256.Sum256([]byte(deviceCode))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func DeviceCodeFactory(config *compose.Config, storage interface{}, strategy interface{}) interface{} {
+	return &DeviceCodeHandler{
+		accessTokenLifespan:  config.AccessTokenLifespan,
+		refreshTokenLifespan: config.RefreshTokenLifespan,
+		refreshTokenScopes:   config.RefreshTokenScopes,
+		idTokenStrategy:      strategy.(openid.OpenIDConnectTokenStrategy),
+		accessTokenStrategy:  strategy.(oauth2.AccessTokenStrategy),
+		refreshTokenStrategy: strategy.(oauth2.RefreshTokenStrategy),
+		accessTokenStorage:   storage.(oauth2.AccessTokenStorage),
+		refreshTokenStorage:  storage.(oauth2.RefreshTokenStorage),
+		deviceCodeStorage:    storage.(devicecode.DeviceCodeStorage),
+		now:                  time.Now,
+	}
+}
+
+// DeviceCodeHandler handles the device code grant type from RFC8628 in the token endpoint. It redeems
+// device codes which were issued by the device authorization endpoint, once the end user has approved them.
+type DeviceCodeHandler struct {
+	accessTokenLifespan  time.Duration
+	refreshTokenLifespan time.Duration
+	refreshTokenScopes   []string
+	idTokenStrategy      openid.OpenIDConnectTokenStrategy
+	accessTokenStrategy  oauth2.AccessTokenStrategy
+	refreshTokenStrategy oauth2.RefreshTokenStrategy
+	accessTokenStorage   oauth2.AccessTokenStorage
+	refreshTokenStorage  oauth2.RefreshTokenStorage
+	deviceCodeStorage    devicecode.DeviceCodeStorage
+	now                  func() time.Time
+}
+
+var _ fosite.TokenEndpointHandler = (*DeviceCodeHandler)(nil)
+
+func (d *DeviceCodeHandler) HandleTokenEndpointRequest(ctx context.Context, requester fosite.AccessRequester) error {
+	if !d.CanHandleTokenEndpointRequest(requester) {
+		return errors.WithStack(fosite.ErrUnknownRequest)
+	}
+	return nil
+}
+
+func (d *DeviceCodeHandler) PopulateTokenEndpointResponse(ctx context.Context, requester fosite.AccessRequester, responder fosite.AccessResponder) error {
+	// Skip this request if it's for a different grant type.
+	if err := d.HandleTokenEndpointRequest(ctx, requester); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !requester.GetClient().GetGrantTypes().Has(DeviceCodeGrantType) {
+		return errors.WithStack(fosite.ErrUnauthorizedClient.WithHintf("The OAuth 2.0 Client is not allowed to use authorization grant %q.", DeviceCodeGrantType))
+	}
+
+	deviceCode := requester.GetRequestForm().Get("device_code")
+	if deviceCode == "" {
+		return errors.WithStack(fosite.ErrInvalidRequest.WithHint("missing device_code parameter"))
+	}
+
+	signature := DeviceCodeSignature(deviceCode)
+	session, err := d.deviceCodeStorage.GetDeviceCodeSession(ctx, signature)
+	if errors.Is(err, fosite.ErrNotFound) {
+		return errors.WithStack(fosite.ErrInvalidGrant.WithWrap(err).WithHint("invalid device_code"))
+	}
+	if err != nil {
+		return errors.WithStack(fosite.ErrServerError.WithWrap(err).WithDebug(err.Error()))
+	}
+
+	if session.Request.GetClient().GetID() != requester.GetClient().GetID() {
+		return errors.WithStack(fosite.ErrInvalidGrant.WithHint("The device_code was issued to a different client."))
+	}
+
+	now := d.now().UTC()
+	if now.After(session.ExpiresAt) {
+		// Leave the expired session in storage for the garbage collector, so any further polling by the client
+		// continues to get the same error.
+		return errors.WithStack(ErrExpiredToken)
+	}
+
+	switch session.Status {
+	case devicecode.StatusApproved:
+		return d.issueTokens(ctx, requester, responder, signature, session, now)
+	case devicecode.StatusDenied:
+		return errors.WithStack(fosite.ErrAccessDenied.WithHint("The end user did not approve the device authorization request."))
+	default:
+		return d.handlePendingPoll(ctx, signature, session, now)
+	}
+}
+
+// handlePendingPoll remembers when the client last polled, so it can be told to slow down when it polls too often.
+func (d *DeviceCodeHandler) handlePendingPoll(ctx context.Context, signature string, session *devicecode.Session, now time.Time) error {
+	tooFrequent := now.Sub(session.LastPolledAt) < DeviceCodePollingInterval
+
+	session.LastPolledAt = now
+	if err := d.deviceCodeStorage.UpdateDeviceCodeSession(ctx, signature, session); err != nil {
+		if apierrors.IsConflict(err) {
+			// The session was updated concurrently, perhaps by the end user finishing their login,
+			// so the client should simply try again.
+			return errors.WithStack(ErrAuthorizationPending)
+		}
+		return errors.WithStack(fosite.ErrServerError.WithWrap(err).WithDebug(err.Error()))
+	}
+
+	if tooFrequent {
+		return errors.WithStack(ErrSlowDown)
+	}
+	return errors.WithStack(ErrAuthorizationPending)
+}
+
+func (d *DeviceCodeHandler) issueTokens(
+	ctx context.Context,
+	requester fosite.AccessRequester,
+	responder fosite.AccessResponder,
+	signature string,
+	session *devicecode.Session,
+	now time.Time,
+) error {
+	// A device code may only be redeemed once, so delete it before issuing any tokens.
+	if err := d.deviceCodeStorage.DeleteDeviceCodeSession(ctx, signature); err != nil {
+		return errors.WithStack(fosite.ErrInvalidGrant.WithWrap(err).WithHint("The device_code could not be redeemed."))
+	}
+
+	// Continue the original device authorization request, which holds the end user's downstream session.
+	originalRequest := session.Request
+	requester.SetID(originalRequest.GetID())
+	requester.SetSession(originalRequest.GetSession())
+	requester.SetRequestedScopes(originalRequest.GetRequestedScopes())
+	for _, scope := range originalRequest.GetGrantedScopes() {
+		requester.GrantScope(scope)
+	}
+
+	canIssueRefreshToken := requester.GetGrantedScopes().HasOneOf(d.refreshTokenScopes...) &&
+		requester.GetClient().GetGrantTypes().Has("refresh_token")
+
+	requester.GetSession().SetExpiresAt(fosite.AccessToken, now.Add(d.accessTokenLifespan).Round(time.Second))
+	if canIssueRefreshToken {
+		requester.GetSession().SetExpiresAt(fosite.RefreshToken, now.Add(d.refreshTokenLifespan).Round(time.Second))
+	}
+
+	accessToken, accessTokenSignature, err := d.accessTokenStrategy.GenerateAccessToken(ctx, requester)
+	if err != nil {
+		return errors.WithStack(fosite.ErrServerError.WithWrap(err).WithDebug(err.Error()))
+	}
+	if err := d.accessTokenStorage.CreateAccessTokenSession(ctx, accessTokenSignature, requester.Sanitize([]string{})); err != nil {
+		return errors.WithStack(fosite.ErrServerError.WithWrap(err).WithDebug(err.Error()))
+	}
+
+	if canIssueRefreshToken {
+		refreshToken, refreshTokenSignature, err := d.refreshTokenStrategy.GenerateRefreshToken(ctx, requester)
+		if err != nil {
+			return errors.WithStack(fosite.ErrServerError.WithWrap(err).WithDebug(err.Error()))
+		}
+		if err := d.refreshTokenStorage.CreateRefreshTokenSession(ctx, refreshTokenSignature, requester.Sanitize([]string{})); err != nil {
+			return errors.WithStack(fosite.ErrServerError.WithWrap(err).WithDebug(err.Error()))
+		}
+		responder.SetExtra("refresh_token", refreshToken)
+	}
+
+	if requester.GetGrantedScopes().Has("openid") {
+		idToken, err := d.idTokenStrategy.GenerateIDToken(ctx, requester)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		responder.SetExtra("id_token", idToken)
+	}
+
+	responder.SetAccessToken(accessToken)
+	responder.SetTokenType("bearer")
+	responder.SetExpiresIn(d.accessTokenLifespan)
+	responder.SetScopes(requester.GetGrantedScopes())
+	return nil
+}
+
+func (d *DeviceCodeHandler) CanSkipClientAuth(_ fosite.AccessRequester) bool {
+	return false
+}
+
+func (d *DeviceCodeHandler) CanHandleTokenEndpointRequest(requester fosite.AccessRequester) bool {
+	return requester.GetGrantTypes().ExactOne(DeviceCodeGrantType)
+}
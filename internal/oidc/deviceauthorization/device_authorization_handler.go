@@ -0,0 +1,183 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package deviceauthorization provides a handler for the device authorization endpoint of the OAuth 2.0 device
+// authorization grant (RFC8628).
+package deviceauthorization
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ory/fosite"
+
+	"go.pinniped.dev/internal/fositestorage/devicecode"
+	"go.pinniped.dev/internal/httputil/securityheader"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/psession"
+)
+
+const (
+	// The characters used in user codes. These are the consonants from RFC8628 section 6.1, which avoids
+	// ambiguous characters and the accidental spelling of words.
+	userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+
+	// User codes are rendered as two groups of this many characters, separated by a dash.
+	userCodeGroupLength = 4
+)
+
+// ClientAuthenticator authenticates the client which made a request. It is implemented by *fosite.Fosite.
+type ClientAuthenticator interface {
+	AuthenticateClient(ctx context.Context, r *http.Request, form url.Values) (fosite.Client, error)
+}
+
+type response struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func NewHandler(
+	downstreamIssuer string,
+	clientAuthenticator ClientAuthenticator,
+	deviceCodeStorage devicecode.DeviceCodeStorage,
+	deviceCodeLifespan time.Duration,
+	generateDeviceCode func() (string, error),
+	generateUserCode func() (string, error),
+) http.Handler {
+	return securityheader.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, fosite.ErrInvalidRequest.WithHintf("HTTP method is %q, expected \"POST\".", r.Method))
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			writeError(w, fosite.ErrInvalidRequest.WithWrap(err).WithHint("Unable to parse HTTP body, make sure to send a properly formatted form request body."))
+			return
+		}
+
+		client, err := clientAuthenticator.AuthenticateClient(r.Context(), r, r.PostForm)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if !client.GetGrantTypes().Has(oidc.DeviceCodeGrantType) {
+			writeError(w, fosite.ErrUnauthorizedClient.WithHintf("The OAuth 2.0 Client is not allowed to use authorization grant %q.", oidc.DeviceCodeGrantType))
+			return
+		}
+
+		scopes := fosite.RemoveEmpty(strings.Split(r.PostForm.Get("scope"), " "))
+		for _, scope := range scopes {
+			if !fosite.ExactScopeStrategy(client.GetScopes(), scope) {
+				writeError(w, fosite.ErrInvalidScope.WithHintf("The OAuth 2.0 Client is not allowed to request scope %q.", scope))
+				return
+			}
+		}
+
+		deviceCode, err := generateDeviceCode()
+		if err != nil {
+			writeError(w, fosite.ErrServerError.WithWrap(err).WithDebug(err.Error()))
+			return
+		}
+		userCode, err := generateUserCode()
+		if err != nil {
+			writeError(w, fosite.ErrServerError.WithWrap(err).WithDebug(err.Error()))
+			return
+		}
+
+		request := fosite.NewRequest()
+		request.Client = client
+		request.RequestedScope = scopes
+		request.Session = psession.NewPinnipedSession()
+		// Remember only the params which are needed later. In particular, never store the client secret.
+		for _, param := range []string{
+			"client_id",
+			"scope",
+			oidc.AuthorizeUpstreamIDPNameParamName,
+			oidc.AuthorizeUpstreamIDPTypeParamName,
+		} {
+			if value := r.PostForm.Get(param); value != "" {
+				request.Form.Set(param, value)
+			}
+		}
+
+		expiresAt := request.RequestedAt.Add(deviceCodeLifespan)
+		if err := deviceCodeStorage.CreateDeviceCodeSession(r.Context(), oidc.DeviceCodeSignature(deviceCode), userCode, expiresAt, request); err != nil {
+			writeError(w, fosite.ErrServerError.WithWrap(err).WithDebug(err.Error()))
+			return
+		}
+
+		verificationURI := downstreamIssuer + oidc.DeviceVerificationEndpointPath
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		if err := json.NewEncoder(w).Encode(&response{
+			DeviceCode:              deviceCode,
+			UserCode:                userCode,
+			VerificationURI:         verificationURI,
+			VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {userCode}}.Encode(),
+			ExpiresIn:               int64(deviceCodeLifespan.Seconds()),
+			Interval:                int64(oidc.DeviceCodePollingInterval.Seconds()),
+		}); err != nil {
+			plog.Error("error writing device authorization response", err)
+		}
+	}))
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	rfc6749Error := fosite.ErrorToRFC6749Error(err)
+	plog.Info("device authorization request error", oidc.FositeErrorForLog(err)...)
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.WriteHeader(rfc6749Error.StatusCode())
+	_ = json.NewEncoder(w).Encode(&errorResponse{
+		Error:            rfc6749Error.ErrorField,
+		ErrorDescription: rfc6749Error.GetDescription(),
+	})
+}
+
+// GenerateDeviceCode generates a new random device code value.
+func GenerateDeviceCode() (string, error) { return generateDeviceCode(rand.Reader) }
+
+func generateDeviceCode(randReader io.Reader) (string, error) {
+	var buf [32]byte
+	if _, err := io.ReadFull(randReader, buf[:]); err != nil {
+		return "", fmt.Errorf("could not generate device code: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf[:]), nil
+}
+
+// GenerateUserCode generates a new random user code value, e.g. "WDJB-MJHT".
+func GenerateUserCode() (string, error) { return generateUserCode(rand.Reader) }
+
+func generateUserCode(randReader io.Reader) (string, error) {
+	var b strings.Builder
+	for i := 0; i < 2*userCodeGroupLength; i++ {
+		if i == userCodeGroupLength {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(randReader, big.NewInt(int64(len(userCodeCharset))))
+		if err != nil {
+			return "", fmt.Errorf("could not generate user code: %w", err)
+		}
+		b.WriteByte(userCodeCharset[n.Int64()])
+	}
+	return b.String(), nil
+}
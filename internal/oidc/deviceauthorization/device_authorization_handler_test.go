@@ -0,0 +1,221 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package deviceauthorization
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"go.pinniped.dev/internal/fositestorage/devicecode"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/testutil"
+)
+
+type fakeClientAuthenticator struct {
+	client fosite.Client
+	err    error
+}
+
+func (f *fakeClientAuthenticator) AuthenticateClient(_ context.Context, _ *http.Request, _ url.Values) (fosite.Client, error) {
+	return f.client, f.err
+}
+
+func TestDeviceAuthorizationHandler(t *testing.T) {
+	const (
+		downstreamIssuer = "https://my-downstream-issuer.com/some-path"
+		happyDeviceCode  = "some-device-code"
+		happyUserCode    = "WDJB-MJHT"
+	)
+
+	happyGenerateDeviceCode := func() (string, error) { return happyDeviceCode, nil }
+	happyGenerateUserCode := func() (string, error) { return happyUserCode, nil }
+	sadGenerate := func() (string, error) { return "", errors.New("some generator error") }
+
+	clientWithoutDeviceGrant := clientregistry.PinnipedCLI()
+	clientWithoutDeviceGrant.GrantTypes = fosite.Arguments{"authorization_code", "refresh_token"}
+
+	happyBody := url.Values{
+		"client_id":                      {"pinniped-cli"},
+		"scope":                          {"openid offline_access"},
+		"pinniped_idp_name":              {"some-idp"},
+		"pinniped_idp_type":              {"oidc"},
+		"some_other_param_to_be_ignored": {"foo"},
+	}
+
+	tests := []struct {
+		name                string
+		method              string
+		body                url.Values
+		clientAuthenticator *fakeClientAuthenticator
+		generateDeviceCode  func() (string, error)
+		generateUserCode    func() (string, error)
+
+		wantStatus     int
+		wantBodyJSON   string
+		wantError      string
+		wantStoredForm url.Values
+	}{
+		{
+			name:                "happy path",
+			method:              http.MethodPost,
+			body:                happyBody,
+			clientAuthenticator: &fakeClientAuthenticator{client: clientregistry.PinnipedCLI()},
+			generateDeviceCode:  happyGenerateDeviceCode,
+			generateUserCode:    happyGenerateUserCode,
+			wantStatus:          http.StatusOK,
+			wantBodyJSON: `{
+				"device_code": "some-device-code",
+				"user_code": "WDJB-MJHT",
+				"verification_uri": "https://my-downstream-issuer.com/some-path/oauth2/device",
+				"verification_uri_complete": "https://my-downstream-issuer.com/some-path/oauth2/device?user_code=WDJB-MJHT",
+				"expires_in": 600,
+				"interval": 5
+			}`,
+			wantStoredForm: url.Values{
+				"client_id":         {"pinniped-cli"},
+				"scope":             {"openid offline_access"},
+				"pinniped_idp_name": {"some-idp"},
+				"pinniped_idp_type": {"oidc"},
+			},
+		},
+		{
+			name:                "wrong HTTP method",
+			method:              http.MethodGet,
+			clientAuthenticator: &fakeClientAuthenticator{client: clientregistry.PinnipedCLI()},
+			generateDeviceCode:  happyGenerateDeviceCode,
+			generateUserCode:    happyGenerateUserCode,
+			wantStatus:          http.StatusBadRequest,
+			wantError:           "invalid_request",
+		},
+		{
+			name:                "client authentication fails",
+			method:              http.MethodPost,
+			body:                happyBody,
+			clientAuthenticator: &fakeClientAuthenticator{err: fosite.ErrInvalidClient},
+			generateDeviceCode:  happyGenerateDeviceCode,
+			generateUserCode:    happyGenerateUserCode,
+			wantStatus:          http.StatusUnauthorized,
+			wantError:           "invalid_client",
+		},
+		{
+			name:                "client is not allowed to use the device code grant type",
+			method:              http.MethodPost,
+			body:                happyBody,
+			clientAuthenticator: &fakeClientAuthenticator{client: clientWithoutDeviceGrant},
+			generateDeviceCode:  happyGenerateDeviceCode,
+			generateUserCode:    happyGenerateUserCode,
+			wantStatus:          http.StatusBadRequest,
+			wantError:           "unauthorized_client",
+		},
+		{
+			name:                "client is not allowed to request a scope",
+			method:              http.MethodPost,
+			body:                url.Values{"client_id": {"pinniped-cli"}, "scope": {"openid some-other-scope"}},
+			clientAuthenticator: &fakeClientAuthenticator{client: clientregistry.PinnipedCLI()},
+			generateDeviceCode:  happyGenerateDeviceCode,
+			generateUserCode:    happyGenerateUserCode,
+			wantStatus:          http.StatusBadRequest,
+			wantError:           "invalid_scope",
+		},
+		{
+			name:                "error generating device code",
+			method:              http.MethodPost,
+			body:                happyBody,
+			clientAuthenticator: &fakeClientAuthenticator{client: clientregistry.PinnipedCLI()},
+			generateDeviceCode:  sadGenerate,
+			generateUserCode:    happyGenerateUserCode,
+			wantStatus:          http.StatusInternalServerError,
+			wantError:           "server_error",
+		},
+		{
+			name:                "error generating user code",
+			method:              http.MethodPost,
+			body:                happyBody,
+			clientAuthenticator: &fakeClientAuthenticator{client: clientregistry.PinnipedCLI()},
+			generateDeviceCode:  happyGenerateDeviceCode,
+			generateUserCode:    sadGenerate,
+			wantStatus:          http.StatusInternalServerError,
+			wantError:           "server_error",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			secrets := fake.NewSimpleClientset().CoreV1().Secrets("some-namespace")
+			storage := devicecode.New(secrets, time.Now, 11*time.Minute)
+
+			subject := NewHandler(downstreamIssuer, test.clientAuthenticator, storage, 10*time.Minute, test.generateDeviceCode, test.generateUserCode)
+
+			req := httptest.NewRequest(test.method, "/some/path", strings.NewReader(test.body.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rsp := httptest.NewRecorder()
+			subject.ServeHTTP(rsp, req)
+			t.Logf("response: %#v", rsp)
+			t.Logf("response body: %q", rsp.Body.String())
+
+			testutil.RequireSecurityHeaders(t, rsp)
+			require.Equal(t, test.wantStatus, rsp.Code)
+			testutil.RequireEqualContentType(t, rsp.Header().Get("Content-Type"), "application/json")
+
+			if test.wantError != "" {
+				var errorResponse map[string]interface{}
+				require.NoError(t, json.Unmarshal(rsp.Body.Bytes(), &errorResponse))
+				require.Equal(t, test.wantError, errorResponse["error"])
+				require.NotEmpty(t, errorResponse["error_description"])
+				return
+			}
+
+			require.JSONEq(t, test.wantBodyJSON, rsp.Body.String())
+
+			signature, session, err := storage.GetDeviceCodeSessionByUserCode(context.Background(), happyUserCode)
+			require.NoError(t, err)
+			require.Equal(t, oidc.DeviceCodeSignature(happyDeviceCode), signature)
+			require.Equal(t, devicecode.StatusPending, session.Status)
+			require.Equal(t, happyUserCode, session.UserCode)
+			require.Equal(t, test.wantStoredForm, session.Request.GetRequestForm())
+			require.Equal(t, fosite.Arguments{"openid", "offline_access"}, session.Request.GetRequestedScopes())
+			require.Empty(t, session.Request.GetGrantedScopes())
+			require.Equal(t, "pinniped-cli", session.Request.GetClient().GetID())
+			require.WithinDuration(t, time.Now().Add(10*time.Minute), session.ExpiresAt, time.Minute)
+		})
+	}
+}
+
+func TestGenerateDeviceCode(t *testing.T) {
+	code, err := generateDeviceCode(bytes.NewReader(bytes.Repeat([]byte{0xff}, 32)))
+	require.NoError(t, err)
+	require.Equal(t, "__________________________________________8", code)
+
+	_, err = generateDeviceCode(bytes.NewReader([]byte{1, 2, 3}))
+	require.EqualError(t, err, "could not generate device code: unexpected EOF")
+
+	code, err = GenerateDeviceCode()
+	require.NoError(t, err)
+	require.Len(t, code, 43)
+}
+
+func TestGenerateUserCode(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		code, err := GenerateUserCode()
+		require.NoError(t, err)
+		require.Regexp(t, regexp.MustCompile(`^[BCDFGHJKLMNPQRSTVWXZ]{4}-[BCDFGHJKLMNPQRSTVWXZ]{4}$`), code)
+	}
+
+	_, err := generateUserCode(bytes.NewReader(nil))
+	require.EqualError(t, err, "could not generate user code: EOF")
+}
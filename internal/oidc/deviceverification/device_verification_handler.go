@@ -0,0 +1,95 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package deviceverification provides a handler for the device verification endpoint of the OAuth 2.0 device
+// authorization grant (RFC8628), where the end user enters the user code which is displayed by their device.
+package deviceverification
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/pkg/errors"
+
+	"go.pinniped.dev/internal/fositestorage/devicecode"
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/httputil/securityheader"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/provider/deviceverificationhtml"
+	"go.pinniped.dev/internal/plog"
+)
+
+const userCodeParamName = "user_code"
+
+func NewHandler(
+	downstreamIssuer string,
+	deviceCodeStorage devicecode.DeviceCodeStorage,
+	now func() time.Time,
+) http.Handler {
+	formAction := downstreamIssuer + oidc.DeviceVerificationEndpointPath
+
+	handler := httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
+		case http.MethodGet:
+			// The user code may have been included in the verification_uri_complete, so use it to fill in the form.
+			renderPage(w, http.StatusOK, &deviceverificationhtml.PageData{
+				FormAction: formAction,
+				UserCode:   r.URL.Query().Get(userCodeParamName),
+			})
+			return nil
+		case http.MethodPost:
+			// Continue below.
+		default:
+			return httperr.Newf(http.StatusMethodNotAllowed, "%s (try GET or POST)", r.Method)
+		}
+
+		userCode := r.PostFormValue(userCodeParamName)
+		_, session, err := deviceCodeStorage.GetDeviceCodeSessionByUserCode(r.Context(), userCode)
+		if errors.Is(err, fosite.ErrNotFound) {
+			renderPage(w, http.StatusUnprocessableEntity, &deviceverificationhtml.PageData{
+				FormAction:   formAction,
+				UserCode:     userCode,
+				ErrorMessage: "The code was not recognized. Please check the code and try again.",
+			})
+			return nil
+		}
+		if err != nil {
+			plog.Error("error reading device authorization request", err)
+			return httperr.Wrap(http.StatusInternalServerError, "error reading device authorization request", err)
+		}
+
+		if session.Status != devicecode.StatusPending || now().After(session.ExpiresAt) {
+			renderPage(w, http.StatusUnprocessableEntity, &deviceverificationhtml.PageData{
+				FormAction:   formAction,
+				UserCode:     userCode,
+				ErrorMessage: "The code has expired or has already been used. Please start again on your device.",
+			})
+			return nil
+		}
+
+		// Let the authorize endpoint perform the upstream login on behalf of the device authorization request, after
+		// the end user has confirmed the user code and the client there.
+		params := url.Values{}
+		params.Set(oidc.AuthorizeDeviceUserCodeParamName, devicecode.NormalizeUserCode(userCode))
+		for _, param := range []string{oidc.AuthorizeUpstreamIDPNameParamName, oidc.AuthorizeUpstreamIDPTypeParamName} {
+			if value := session.Request.GetRequestForm().Get(param); value != "" {
+				params.Set(param, value)
+			}
+		}
+		http.Redirect(w, r, downstreamIssuer+oidc.AuthorizationEndpointPath+"?"+params.Encode(), http.StatusSeeOther)
+		return nil
+	})
+
+	return securityheader.WrapWithCustomCSP(handler, deviceverificationhtml.ContentSecurityPolicy())
+}
+
+func renderPage(w http.ResponseWriter, status int, pageData *deviceverificationhtml.PageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := deviceverificationhtml.Template().Execute(w, pageData); err != nil {
+		// It's too late to return an error response at this point, so just log it.
+		plog.Error("error rendering device verification page", err)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package deviceverification
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"go.pinniped.dev/internal/fositestorage/devicecode"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/internal/testutil"
+)
+
+func TestDeviceVerificationHandler(t *testing.T) {
+	const (
+		downstreamIssuer = "https://my-downstream-issuer.com/some-path"
+		htmlContentType  = "text/html; charset=utf-8"
+	)
+
+	now := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	type storedSession struct {
+		signature string
+		userCode  string
+		status    devicecode.Status
+		expiresAt time.Time
+		form      url.Values
+	}
+
+	happySession := storedSession{
+		signature: "some-signature",
+		userCode:  "WDJB-MJHT",
+		status:    devicecode.StatusPending,
+		expiresAt: now.Add(time.Minute),
+		form:      url.Values{"client_id": {"pinniped-cli"}, "pinniped_idp_name": {"some-idp"}, "pinniped_idp_type": {"oidc"}},
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    url.Values
+		session *storedSession
+
+		wantStatus                 int
+		wantContentType            string
+		wantBodyContains           []string
+		wantBodyString             string
+		wantRedirectLocationString string
+	}{
+		{
+			name:            "GET renders the form",
+			method:          http.MethodGet,
+			path:            "/some/path",
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBodyContains: []string{
+				`action="https://my-downstream-issuer.com/some-path/oauth2/device"`,
+				`name="user_code" value=""`,
+			},
+		},
+		{
+			name:            "GET with a user code fills in the form",
+			method:          http.MethodGet,
+			path:            "/some/path?user_code=WDJB-MJHT",
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBodyContains: []string{
+				`action="https://my-downstream-issuer.com/some-path/oauth2/device"`,
+				`name="user_code" value="WDJB-MJHT"`,
+			},
+		},
+		{
+			name:            "wrong HTTP method",
+			method:          http.MethodPut,
+			path:            "/some/path",
+			wantStatus:      http.StatusMethodNotAllowed,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBodyString:  "Method Not Allowed: PUT (try GET or POST)\n",
+		},
+		{
+			name:                       "POST with a pending user code redirects to the authorize endpoint",
+			method:                     http.MethodPost,
+			path:                       "/some/path",
+			body:                       url.Values{"user_code": {"wdjb-mjht"}},
+			session:                    &happySession,
+			wantStatus:                 http.StatusSeeOther,
+			wantRedirectLocationString: "https://my-downstream-issuer.com/some-path/oauth2/authorize?pinniped_device_user_code=WDJBMJHT&pinniped_idp_name=some-idp&pinniped_idp_type=oidc",
+		},
+		{
+			name:            "POST with an unknown user code",
+			method:          http.MethodPost,
+			path:            "/some/path",
+			body:            url.Values{"user_code": {"BCDF-GHJK"}},
+			session:         &happySession,
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: htmlContentType,
+			wantBodyContains: []string{
+				"The code was not recognized. Please check the code and try again.",
+				`name="user_code" value="BCDF-GHJK"`,
+			},
+		},
+		{
+			name:   "POST with an expired user code",
+			method: http.MethodPost,
+			path:   "/some/path",
+			body:   url.Values{"user_code": {"WDJB-MJHT"}},
+			session: &storedSession{
+				signature: happySession.signature,
+				userCode:  happySession.userCode,
+				status:    devicecode.StatusPending,
+				expiresAt: now.Add(-time.Second),
+				form:      happySession.form,
+			},
+			wantStatus:       http.StatusUnprocessableEntity,
+			wantContentType:  htmlContentType,
+			wantBodyContains: []string{"The code has expired or has already been used. Please start again on your device."},
+		},
+		{
+			name:   "POST with an already approved user code",
+			method: http.MethodPost,
+			path:   "/some/path",
+			body:   url.Values{"user_code": {"WDJB-MJHT"}},
+			session: &storedSession{
+				signature: happySession.signature,
+				userCode:  happySession.userCode,
+				status:    devicecode.StatusApproved,
+				expiresAt: happySession.expiresAt,
+				form:      happySession.form,
+			},
+			wantStatus:       http.StatusUnprocessableEntity,
+			wantContentType:  htmlContentType,
+			wantBodyContains: []string{"The code has expired or has already been used. Please start again on your device."},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			secrets := fake.NewSimpleClientset().CoreV1().Secrets("some-namespace")
+			storage := devicecode.New(secrets, func() time.Time { return now }, 11*time.Minute)
+
+			if test.session != nil {
+				request := fosite.NewRequest()
+				request.Client = clientregistry.PinnipedCLI()
+				request.Session = psession.NewPinnipedSession()
+				request.Form = test.session.form
+				require.NoError(t, storage.CreateDeviceCodeSession(context.Background(), test.session.signature, test.session.userCode, test.session.expiresAt, request))
+				if test.session.status != devicecode.StatusPending {
+					_, session, err := storage.GetDeviceCodeSessionByUserCode(context.Background(), test.session.userCode)
+					require.NoError(t, err)
+					session.Status = test.session.status
+					require.NoError(t, storage.UpdateDeviceCodeSession(context.Background(), test.session.signature, session))
+				}
+			}
+
+			subject := NewHandler(downstreamIssuer, storage, func() time.Time { return now })
+
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rsp := httptest.NewRecorder()
+			subject.ServeHTTP(rsp, req)
+			t.Logf("response: %#v", rsp)
+			t.Logf("response body: %q", rsp.Body.String())
+
+			require.Equal(t, test.wantStatus, rsp.Code)
+			testutil.RequireEqualContentType(t, rsp.Header().Get("Content-Type"), test.wantContentType)
+
+			if test.wantRedirectLocationString != "" {
+				require.Equal(t, test.wantRedirectLocationString, rsp.Header().Get("Location"))
+			}
+			if test.wantBodyString != "" {
+				require.Equal(t, test.wantBodyString, rsp.Body.String())
+			}
+			for _, want := range test.wantBodyContains {
+				require.Contains(t, rsp.Body.String(), want)
+			}
+		})
+	}
+}
@@ -33,10 +33,19 @@ type Metadata struct {
 
 	// vvv Optional vvv
 
+	UserInfoEndpoint string `json:"userinfo_endpoint"`
+
 	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
 	ScopesSupported                   []string `json:"scopes_supported"`
 	ClaimsSupported                   []string `json:"claims_supported"`
 
+	// From https://datatracker.ietf.org/doc/html/rfc8628#section-4.
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+
+	// From https://datatracker.ietf.org/doc/html/rfc8414#section-2.
+	RevocationEndpoint    string `json:"revocation_endpoint"`
+	IntrospectionEndpoint string `json:"introspection_endpoint"`
+
 	// ^^^ Optional ^^^
 
 	// vvv Custom vvv
@@ -62,6 +71,7 @@ func NewHandler(issuerURL string) http.Handler {
 		AuthorizationEndpoint:             issuerURL + oidc.AuthorizationEndpointPath,
 		TokenEndpoint:                     issuerURL + oidc.TokenEndpointPath,
 		JWKSURI:                           issuerURL + oidc.JWKSEndpointPath,
+		UserInfoEndpoint:                  issuerURL + oidc.UserInfoEndpointPath,
 		SupervisorDiscovery:               SupervisorDiscoveryMetadataV1Alpha1{PinnipedIDPsEndpoint: issuerURL + oidc.PinnipedIDPsPathV1Alpha1},
 		ResponseTypesSupported:            []string{"code"},
 		ResponseModesSupported:            []string{"query", "form_post"},
@@ -70,6 +80,9 @@ func NewHandler(issuerURL string) http.Handler {
 		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic"},
 		ScopesSupported:                   []string{"openid", "offline"},
 		ClaimsSupported:                   []string{"groups"},
+		DeviceAuthorizationEndpoint:       issuerURL + oidc.DeviceAuthorizationEndpointPath,
+		RevocationEndpoint:                issuerURL + oidc.RevocationEndpointPath,
+		IntrospectionEndpoint:             issuerURL + oidc.IntrospectionEndpointPath,
 	}
 
 	var b bytes.Buffer
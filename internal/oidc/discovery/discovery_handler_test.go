@@ -39,6 +39,7 @@ func TestDiscovery(t *testing.T) {
 				AuthorizationEndpoint: "https://some-issuer.com/some/path/oauth2/authorize",
 				TokenEndpoint:         "https://some-issuer.com/some/path/oauth2/token",
 				JWKSURI:               "https://some-issuer.com/some/path/jwks.json",
+				UserInfoEndpoint:      "https://some-issuer.com/some/path/oauth2/userinfo",
 				SupervisorDiscovery: SupervisorDiscoveryMetadataV1Alpha1{
 					PinnipedIDPsEndpoint: "https://some-issuer.com/some/path/v1alpha1/pinniped_identity_providers",
 				},
@@ -49,6 +50,9 @@ func TestDiscovery(t *testing.T) {
 				TokenEndpointAuthMethodsSupported: []string{"client_secret_basic"},
 				ScopesSupported:                   []string{"openid", "offline"},
 				ClaimsSupported:                   []string{"groups"},
+				DeviceAuthorizationEndpoint:       "https://some-issuer.com/some/path/oauth2/device_authorization",
+				RevocationEndpoint:                "https://some-issuer.com/some/path/oauth2/revoke",
+				IntrospectionEndpoint:             "https://some-issuer.com/some/path/oauth2/introspect",
 			},
 		},
 		{
@@ -1,4 +1,4 @@
-// Copyright 2020 the Pinniped contributors. All Rights Reserved.
+// Copyright 2020-2021 the Pinniped contributors. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
 package oidc
@@ -19,20 +19,23 @@ import (
 // If we ever update FederationDomain's to hold their signing key, we might not need this type, since we
 // could have an invariant that routes to an FederationDomain's endpoints are only wired up if an
 // FederationDomain has a valid signing key.
+//
+// The keysFunc returns the current HMAC key followed by the previous HMAC keys, newest first. Everything is signed
+// with the current key, but everything which was signed with one of the previous keys can still be validated.
 type dynamicOauth2HMACStrategy struct {
 	fositeConfig *compose.Config
-	keyFunc      func() []byte
+	keysFunc     func() [][]byte
 }
 
 var _ oauth2.CoreStrategy = &dynamicOauth2HMACStrategy{}
 
 func newDynamicOauth2HMACStrategy(
 	fositeConfig *compose.Config,
-	keyFunc func() []byte,
+	keysFunc func() [][]byte,
 ) *dynamicOauth2HMACStrategy {
 	return &dynamicOauth2HMACStrategy{
 		fositeConfig: fositeConfig,
-		keyFunc:      keyFunc,
+		keysFunc:     keysFunc,
 	}
 }
 
@@ -94,5 +97,10 @@ func (s *dynamicOauth2HMACStrategy) ValidateAuthorizeCode(
 }
 
 func (s *dynamicOauth2HMACStrategy) delegate() *oauth2.HMACSHAStrategy {
-	return compose.NewOAuth2HMACStrategy(s.fositeConfig, s.keyFunc(), nil)
+	keys := s.keysFunc()
+	if len(keys) == 0 {
+		// Let fosite complain about the missing key.
+		return compose.NewOAuth2HMACStrategy(s.fositeConfig, nil, nil)
+	}
+	return compose.NewOAuth2HMACStrategy(s.fositeConfig, keys[0], keys[1:])
 }
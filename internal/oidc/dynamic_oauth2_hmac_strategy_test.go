@@ -0,0 +1,84 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package oidc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/compose"
+	"github.com/ory/fosite/handler/openid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDynamicOauth2HMACStrategy(t *testing.T) {
+	var (
+		oldKey   = []byte("some old secret - must have at least 32 bytes")
+		newKey   = []byte("some new secret - must have at least 32 bytes")
+		otherKey = []byte("some other secret - must have at least 32 bytes")
+	)
+
+	tests := []struct {
+		name      string
+		keys      [][]byte
+		wantError error
+	}{
+		{
+			name: "token was signed with the current key",
+			keys: [][]byte{oldKey},
+		},
+		{
+			name: "token was signed with a previous key",
+			keys: [][]byte{newKey, otherKey, oldKey},
+		},
+		{
+			name:      "token was signed with a key which is no longer kept",
+			keys:      [][]byte{newKey, otherKey},
+			wantError: fosite.ErrTokenSignatureMismatch,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			requester := &fosite.Request{
+				RequestedAt: time.Now().UTC(),
+				Session:     &openid.DefaultSession{},
+			}
+
+			oldStrategy := newDynamicOauth2HMACStrategy(&compose.Config{}, func() [][]byte { return [][]byte{oldKey} })
+			accessToken, accessTokenSignature, err := oldStrategy.GenerateAccessToken(ctx, requester)
+			require.NoError(t, err)
+			refreshToken, _, err := oldStrategy.GenerateRefreshToken(ctx, requester)
+			require.NoError(t, err)
+
+			s := newDynamicOauth2HMACStrategy(&compose.Config{}, func() [][]byte { return test.keys })
+
+			// The signature is how the token is found in storage, so it must not depend on the keys.
+			require.Equal(t, accessTokenSignature, s.AccessTokenSignature(accessToken))
+
+			accessTokenErr := s.ValidateAccessToken(ctx, requester, accessToken)
+			refreshTokenErr := s.ValidateRefreshToken(ctx, requester, refreshToken)
+			if test.wantError != nil {
+				require.True(t, errors.Is(accessTokenErr, test.wantError), "unexpected error: %v", accessTokenErr)
+				require.True(t, errors.Is(refreshTokenErr, test.wantError), "unexpected error: %v", refreshTokenErr)
+				return
+			}
+			require.NoError(t, accessTokenErr)
+			require.NoError(t, refreshTokenErr)
+
+			if len(test.keys) > 1 {
+				// New tokens are signed with the current key, so they cannot be validated with the previous keys.
+				newAccessToken, _, err := s.GenerateAccessToken(ctx, requester)
+				require.NoError(t, err)
+				require.NoError(t, s.ValidateAccessToken(ctx, requester, newAccessToken))
+				err = oldStrategy.ValidateAccessToken(ctx, requester, newAccessToken)
+				require.True(t, errors.Is(err, fosite.ErrTokenSignatureMismatch), "unexpected error: %v", err)
+			}
+		})
+	}
+}
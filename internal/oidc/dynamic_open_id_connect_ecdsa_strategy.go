@@ -67,5 +67,11 @@ func (s *dynamicOpenIDConnectECDSAStrategy) GenerateIDToken(
 		return "", fosite.ErrServerError.WithWrap(constable.Error("JWK must be of type ecdsa"))
 	}
 
+	// Name the signing key in the header of the ID token, so clients can find it in the JWKS
+	// even while the JWKS also contains previous signing keys.
+	if session, ok := requester.GetSession().(openid.Session); ok && activeJwk.KeyID != "" {
+		session.IDTokenHeaders().Add("kid", activeJwk.KeyID)
+	}
+
 	return compose.NewOpenIDConnectECDSAStrategy(s.fositeConfig, key).GenerateIDToken(ctx, requester)
 }
@@ -55,13 +55,15 @@ func TestDynamicOpenIDConnectECDSAStrategy(t *testing.T) {
 					nil,
 					map[string]*jose.JSONWebKey{
 						goodIssuer: {
-							Key: ecPrivateKey,
+							Key:   ecPrivateKey,
+							KeyID: "some-key-id",
 						},
 					},
 				)
 			},
 			wantSigningJWK: &jose.JSONWebKey{
-				Key: ecPrivateKey,
+				Key:   ecPrivateKey,
+				KeyID: "some-key-id",
 			},
 		},
 		{
@@ -130,6 +132,12 @@ func TestDynamicOpenIDConnectECDSAStrategy(t *testing.T) {
 				token := oidctestutil.VerifyECDSAIDToken(t, goodIssuer, clientID, privateKey, idToken)
 				require.Equal(t, goodSubject, token.Subject)
 				require.Equal(t, goodNonce, token.Nonce)
+
+				// The signing key must be named in the header, since the JWKS may contain more than one key.
+				parsedIDToken, err := jose.ParseSigned(idToken)
+				require.NoError(t, err)
+				require.Len(t, parsedIDToken.Signatures, 1)
+				require.Equal(t, test.wantSigningJWK.KeyID, parsedIDToken.Signatures[0].Header.KeyID)
 			}
 		})
 	}
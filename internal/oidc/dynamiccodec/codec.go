@@ -1,4 +1,4 @@
-// Copyright 2020 the Pinniped contributors. All Rights Reserved.
+// Copyright 2020-2021 the Pinniped contributors. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
 // Package dynamiccodec provides a type that can encode information using a just-in-time signing and
@@ -18,12 +18,15 @@ var _ oidc.Codec = &Codec{}
 // KeyFunc returns a single key: a symmetric key.
 type KeyFunc func() []byte
 
-// Codec can dynamically encode and decode information by using a KeyFunc to get its keys
+// KeysFunc returns a list of symmetric keys: the current key, followed by the previous keys, newest first.
+type KeysFunc func() [][]byte
+
+// Codec can dynamically encode and decode information by using a KeysFunc to get its keys
 // just-in-time.
 type Codec struct {
-	lifespan          time.Duration
-	signingKeyFunc    KeyFunc
-	encryptionKeyFunc KeyFunc
+	lifespan           time.Duration
+	signingKeysFunc    KeysFunc
+	encryptionKeysFunc KeysFunc
 }
 
 // New creates a new Codec that will use the provided keyFuncs for its key source, and
@@ -33,26 +36,57 @@ type Codec struct {
 // The returned Codec will make ensure that the encoded values will only be valid for the provided
 // lifespan.
 func New(lifespan time.Duration, signingKeyFunc, encryptionKeyFunc KeyFunc) *Codec {
+	return NewWithPreviousKeys(lifespan, singleKey(signingKeyFunc), singleKey(encryptionKeyFunc))
+}
+
+// NewWithPreviousKeys is like New, except that the provided keysFuncs may also return previous keys. Values are
+// always encoded with the current keys, but they can be decoded with any combination of the current and previous
+// keys, so that values which were encoded before a key was rotated can still be decoded.
+func NewWithPreviousKeys(lifespan time.Duration, signingKeysFunc, encryptionKeysFunc KeysFunc) *Codec {
 	return &Codec{
-		lifespan:          lifespan,
-		signingKeyFunc:    signingKeyFunc,
-		encryptionKeyFunc: encryptionKeyFunc,
+		lifespan:           lifespan,
+		signingKeysFunc:    signingKeysFunc,
+		encryptionKeysFunc: encryptionKeysFunc,
 	}
 }
 
 // Encode implements oidc.Encode().
 func (c *Codec) Encode(name string, value interface{}) (string, error) {
-	return c.delegate().Encode(name, value)
+	return c.delegates()[0].Encode(name, value)
 }
 
 // Decode implements oidc.Decode().
 func (c *Codec) Decode(name string, value string, into interface{}) error {
-	return c.delegate().Decode(name, value, into)
+	return securecookie.DecodeMulti(name, value, into, c.delegates()...)
+}
+
+// delegates returns a codec for each combination of the signing and encryption keys, starting with the codec for
+// the current keys.
+func (c *Codec) delegates() []securecookie.Codec {
+	signingKeys := orNoKey(c.signingKeysFunc())
+	encryptionKeys := orNoKey(c.encryptionKeysFunc())
+
+	codecs := make([]securecookie.Codec, 0, len(signingKeys)*len(encryptionKeys))
+	for _, signingKey := range signingKeys {
+		for _, encryptionKey := range encryptionKeys {
+			codec := securecookie.New(signingKey, encryptionKey)
+			codec.MaxAge(int(c.lifespan.Seconds()))
+			codec.SetSerializer(securecookie.JSONEncoder{})
+			codecs = append(codecs, codec)
+		}
+	}
+	return codecs
 }
 
-func (c *Codec) delegate() *securecookie.SecureCookie {
-	codec := securecookie.New(c.signingKeyFunc(), c.encryptionKeyFunc())
-	codec.MaxAge(int(c.lifespan.Seconds()))
-	codec.SetSerializer(securecookie.JSONEncoder{})
-	return codec
+func singleKey(keyFunc KeyFunc) KeysFunc {
+	return func() [][]byte { return [][]byte{keyFunc()} }
+}
+
+// orNoKey returns a list containing a nil key when there are no keys, so that the codecs return the same errors
+// as they would for a missing key.
+func orNoKey(keys [][]byte) [][]byte {
+	if len(keys) == 0 {
+		return [][]byte{nil}
+	}
+	return keys
 }
@@ -1,4 +1,4 @@
-// Copyright 2020 the Pinniped contributors. All Rights Reserved.
+// Copyright 2020-2021 the Pinniped contributors. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
 package dynamiccodec
@@ -125,3 +125,78 @@ func TestCodec(t *testing.T) {
 		})
 	}
 }
+
+func TestCodecWithPreviousKeys(t *testing.T) {
+	var (
+		oldSigningKey    = []byte("old-signing-key")
+		oldEncryptionKey = []byte("16-byte-old--key")
+		newSigningKey    = []byte("new-signing-key")
+		newEncryptionKey = []byte("16-byte-new--key")
+	)
+
+	tests := []struct {
+		name             string
+		signingKeys      [][]byte
+		encryptionKeys   [][]byte
+		wantDecoderError string
+	}{
+		{
+			name:           "both keys were rotated",
+			signingKeys:    [][]byte{newSigningKey, oldSigningKey},
+			encryptionKeys: [][]byte{newEncryptionKey, oldEncryptionKey},
+		},
+		{
+			name:           "only the signing key was rotated",
+			signingKeys:    [][]byte{newSigningKey, oldSigningKey},
+			encryptionKeys: [][]byte{oldEncryptionKey},
+		},
+		{
+			name:           "only the encryption key was rotated",
+			signingKeys:    [][]byte{oldSigningKey},
+			encryptionKeys: [][]byte{newEncryptionKey, oldEncryptionKey},
+		},
+		{
+			name:             "the previous signing key was dropped",
+			signingKeys:      [][]byte{newSigningKey},
+			encryptionKeys:   [][]byte{newEncryptionKey, oldEncryptionKey},
+			wantDecoderError: "securecookie: the value is not valid (and 1 other error)",
+		},
+		{
+			name:             "the previous encryption key was dropped",
+			signingKeys:      [][]byte{newSigningKey, oldSigningKey},
+			encryptionKeys:   [][]byte{newEncryptionKey},
+			wantDecoderError: "securecookie: the value is not valid (and 1 other error)",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			oldCodec := New(time.Hour, func() []byte { return oldSigningKey }, func() []byte { return oldEncryptionKey })
+			encodedWithOldKeys, err := oldCodec.Encode("some-name", "some-old-message")
+			require.NoError(t, err)
+
+			rotatedCodec := NewWithPreviousKeys(time.Hour,
+				func() [][]byte { return test.signingKeys },
+				func() [][]byte { return test.encryptionKeys })
+
+			var decoded string
+			err = rotatedCodec.Decode("some-name", encodedWithOldKeys, &decoded)
+			if test.wantDecoderError != "" {
+				require.Error(t, err)
+				require.True(t, strings.HasPrefix(err.Error(), test.wantDecoderError), "expected %q to start with %q", err.Error(), test.wantDecoderError)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, "some-old-message", decoded)
+			}
+
+			// New values are always encoded with the current keys.
+			encodedWithNewKeys, err := rotatedCodec.Encode("some-name", "some-new-message")
+			require.NoError(t, err)
+			currentCodec := New(time.Hour,
+				func() []byte { return test.signingKeys[0] },
+				func() []byte { return test.encryptionKeys[0] })
+			require.NoError(t, currentCodec.Decode("some-name", encodedWithNewKeys, &decoded))
+			require.Equal(t, "some-new-message", decoded)
+		})
+	}
+}
@@ -14,15 +14,19 @@ import (
 )
 
 const (
-	idpDiscoveryTypeLDAP = "ldap"
-	idpDiscoveryTypeOIDC = "oidc"
+	IDPTypeLDAP            = "ldap"
+	IDPTypeOIDC            = "oidc"
+	IDPTypeActiveDirectory = "activedirectory"
+	IDPTypeGitHub          = "github"
+	IDPTypeSAML            = "saml"
 )
 
 type response struct {
-	IDPs []identityProviderResponse `json:"pinniped_identity_providers"`
+	IDPs []IdentityProvider `json:"pinniped_identity_providers"`
 }
 
-type identityProviderResponse struct {
+// IdentityProvider describes one upstream IDP as it is advertised by the discovery endpoint.
+type IdentityProvider struct {
 	Name string `json:"name"`
 	Type string `json:"type"`
 }
@@ -49,24 +53,40 @@ func NewHandler(upstreamIDPs oidc.UpstreamIdentityProvidersLister) http.Handler
 	})
 }
 
-func responseAsJSON(upstreamIDPs oidc.UpstreamIdentityProvidersLister) ([]byte, error) {
-	r := response{
-		IDPs: []identityProviderResponse{},
-	}
+// GetIdentityProviders returns the list of currently configured upstream IDPs, sorted by name.
+func GetIdentityProviders(upstreamIDPs oidc.UpstreamIdentityProvidersLister) []IdentityProvider {
+	idps := []IdentityProvider{}
 
 	// The cache of IDPs could change at any time, so always recalculate the list.
 	for _, provider := range upstreamIDPs.GetLDAPIdentityProviders() {
-		r.IDPs = append(r.IDPs, identityProviderResponse{Name: provider.GetName(), Type: idpDiscoveryTypeLDAP})
+		idps = append(idps, IdentityProvider{Name: provider.GetName(), Type: IDPTypeLDAP})
 	}
 	for _, provider := range upstreamIDPs.GetOIDCIdentityProviders() {
-		r.IDPs = append(r.IDPs, identityProviderResponse{Name: provider.GetName(), Type: idpDiscoveryTypeOIDC})
+		idps = append(idps, IdentityProvider{Name: provider.GetName(), Type: IDPTypeOIDC})
+	}
+	for _, provider := range upstreamIDPs.GetActiveDirectoryIdentityProviders() {
+		idps = append(idps, IdentityProvider{Name: provider.GetName(), Type: IDPTypeActiveDirectory})
+	}
+	for _, provider := range upstreamIDPs.GetGitHubIdentityProviders() {
+		idps = append(idps, IdentityProvider{Name: provider.GetName(), Type: IDPTypeGitHub})
+	}
+	for _, provider := range upstreamIDPs.GetSAMLIdentityProviders() {
+		idps = append(idps, IdentityProvider{Name: provider.GetName(), Type: IDPTypeSAML})
 	}
 
 	// Nobody like an API that changes the results unnecessarily. :)
-	sort.SliceStable(r.IDPs, func(i, j int) bool {
-		return r.IDPs[i].Name < r.IDPs[j].Name
+	sort.SliceStable(idps, func(i, j int) bool {
+		return idps[i].Name < idps[j].Name
 	})
 
+	return idps
+}
+
+func responseAsJSON(upstreamIDPs oidc.UpstreamIdentityProvidersLister) ([]byte, error) {
+	r := response{
+		IDPs: GetIdentityProviders(upstreamIDPs),
+	}
+
 	var b bytes.Buffer
 	encodeErr := json.NewEncoder(&b).Encode(&r)
 	encodedMetadata := b.Bytes()
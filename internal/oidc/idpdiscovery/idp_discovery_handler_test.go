@@ -36,21 +36,32 @@ func TestIDPDiscovery(t *testing.T) {
 			wantStatus:      http.StatusOK,
 			wantContentType: "application/json",
 			wantFirstResponseBodyJSON: &response{
-				IDPs: []identityProviderResponse{
+				IDPs: []IdentityProvider{
+					{Name: "a-some-ad-idp", Type: "activedirectory"},
+					{Name: "a-some-github-idp", Type: "github"},
 					{Name: "a-some-ldap-idp", Type: "ldap"},
 					{Name: "a-some-oidc-idp", Type: "oidc"},
+					{Name: "a-some-saml-idp", Type: "saml"},
 					{Name: "x-some-idp", Type: "ldap"},
 					{Name: "x-some-idp", Type: "oidc"},
+					{Name: "x-some-idp", Type: "activedirectory"},
+					{Name: "x-some-idp", Type: "github"},
+					{Name: "x-some-idp", Type: "saml"},
+					{Name: "z-some-ad-idp", Type: "activedirectory"},
 					{Name: "z-some-ldap-idp", Type: "ldap"},
 					{Name: "z-some-oidc-idp", Type: "oidc"},
 				},
 			},
 			wantSecondResponseBodyJSON: &response{
-				IDPs: []identityProviderResponse{
+				IDPs: []IdentityProvider{
+					{Name: "some-other-ad-idp-1", Type: "activedirectory"},
+					{Name: "some-other-ad-idp-2", Type: "activedirectory"},
+					{Name: "some-other-github-idp-1", Type: "github"},
 					{Name: "some-other-ldap-idp-1", Type: "ldap"},
 					{Name: "some-other-ldap-idp-2", Type: "ldap"},
 					{Name: "some-other-oidc-idp-1", Type: "oidc"},
 					{Name: "some-other-oidc-idp-2", Type: "oidc"},
+					{Name: "some-other-saml-idp-1", Type: "saml"},
 				},
 			},
 		},
@@ -73,6 +84,13 @@ func TestIDPDiscovery(t *testing.T) {
 				WithOIDC(&oidctestutil.TestUpstreamOIDCIdentityProvider{Name: "a-some-oidc-idp"}).
 				WithLDAP(&oidctestutil.TestUpstreamLDAPIdentityProvider{Name: "z-some-ldap-idp"}).
 				WithLDAP(&oidctestutil.TestUpstreamLDAPIdentityProvider{Name: "x-some-idp"}).
+				WithActiveDirectory(&oidctestutil.TestUpstreamLDAPIdentityProvider{Name: "z-some-ad-idp"}).
+				WithActiveDirectory(&oidctestutil.TestUpstreamLDAPIdentityProvider{Name: "a-some-ad-idp"}).
+				WithActiveDirectory(&oidctestutil.TestUpstreamLDAPIdentityProvider{Name: "x-some-idp"}).
+				WithGitHub(&oidctestutil.TestUpstreamOIDCIdentityProvider{Name: "x-some-idp"}).
+				WithGitHub(&oidctestutil.TestUpstreamOIDCIdentityProvider{Name: "a-some-github-idp"}).
+				WithSAML(&oidctestutil.TestUpstreamSAMLIdentityProvider{Name: "x-some-idp"}).
+				WithSAML(&oidctestutil.TestUpstreamSAMLIdentityProvider{Name: "a-some-saml-idp"}).
 				Build()
 
 			handler := NewHandler(idpLister)
@@ -99,10 +117,20 @@ func TestIDPDiscovery(t *testing.T) {
 				&oidctestutil.TestUpstreamLDAPIdentityProvider{Name: "some-other-ldap-idp-1"},
 				&oidctestutil.TestUpstreamLDAPIdentityProvider{Name: "some-other-ldap-idp-2"},
 			})
+			idpLister.SetActiveDirectoryIdentityProviders([]provider.UpstreamLDAPIdentityProviderI{
+				&oidctestutil.TestUpstreamLDAPIdentityProvider{Name: "some-other-ad-idp-1"},
+				&oidctestutil.TestUpstreamLDAPIdentityProvider{Name: "some-other-ad-idp-2"},
+			})
 			idpLister.SetOIDCIdentityProviders([]provider.UpstreamOIDCIdentityProviderI{
 				&oidctestutil.TestUpstreamOIDCIdentityProvider{Name: "some-other-oidc-idp-1"},
 				&oidctestutil.TestUpstreamOIDCIdentityProvider{Name: "some-other-oidc-idp-2"},
 			})
+			idpLister.SetGitHubIdentityProviders([]provider.UpstreamOIDCIdentityProviderI{
+				&oidctestutil.TestUpstreamOIDCIdentityProvider{Name: "some-other-github-idp-1"},
+			})
+			idpLister.SetSAMLIdentityProviders([]provider.UpstreamSAMLIdentityProviderI{
+				&oidctestutil.TestUpstreamSAMLIdentityProvider{Name: "some-other-saml-idp-1"},
+			})
 
 			// Make the same request to the same handler instance again, and expect different results.
 			rsp = httptest.NewRecorder()
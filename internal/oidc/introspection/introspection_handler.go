@@ -0,0 +1,32 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package introspection provides a handler for the OAuth 2.0 token introspection endpoint (RFC7662).
+package introspection
+
+import (
+	"net/http"
+
+	"github.com/ory/fosite"
+
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/psession"
+)
+
+// NewHandler returns an http.Handler which reports whether a downstream access or refresh token is currently active.
+// The caller must authenticate as a confidential client using HTTP basic auth, or present an active access token.
+func NewHandler(oauthHelper fosite.OAuth2Provider) http.Handler {
+	return httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		introspectionResponse, err := oauthHelper.NewIntrospectionRequest(r.Context(), r, psession.NewPinnipedSession())
+		if err != nil {
+			plog.Info("introspection request error", oidc.FositeErrorForLog(err)...)
+			oauthHelper.WriteIntrospectionError(w, err)
+			return nil
+		}
+
+		oauthHelper.WriteIntrospectionResponse(w, introspectionResponse)
+		return nil
+	})
+}
@@ -0,0 +1,189 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package introspection
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/token/jwt"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/internal/testutil/oidctestutil"
+)
+
+func TestIntrospectionHandler(t *testing.T) {
+	const (
+		downstreamIssuer = "https://my-downstream-issuer.com/some-path"
+		introspectorID   = "client.oauth.pinniped.dev-some-introspecting-client"
+	)
+
+	hmacSecret := []byte("some secret - must have at least 32 bytes")
+
+	introspector, introspectorSecret := oidctestutil.ConfidentialOIDCClient(t, "some-namespace", introspectorID)
+
+	tests := []struct {
+		name string
+		// body returns the request body, given the access token and refresh token which were issued to the CLI.
+		body func(accessToken, refreshToken string) url.Values
+		// authorize adds client authentication to the request, given the access token which was issued to the CLI.
+		authorize func(r *http.Request, accessToken string)
+
+		wantStatus       int
+		wantResponseJSON map[string]interface{}
+		wantErrorJSON    string
+	}{
+		{
+			name: "introspecting an active access token",
+			body: func(accessToken, _ string) url.Values {
+				return url.Values{"token": {accessToken}}
+			},
+			authorize: func(r *http.Request, _ string) {
+				r.SetBasicAuth(introspectorID, oidctestutil.ConfidentialOIDCClientSecret)
+			},
+			wantStatus: http.StatusOK,
+			wantResponseJSON: map[string]interface{}{
+				"active":    true,
+				"client_id": clientregistry.PinnipedCLIClientID,
+				"scope":     "openid offline_access",
+				"sub":       "some-subject",
+				"username":  "some-username",
+			},
+		},
+		{
+			name: "introspecting an active refresh token",
+			body: func(_, refreshToken string) url.Values {
+				return url.Values{"token": {refreshToken}, "token_type_hint": {"refresh_token"}}
+			},
+			authorize: func(r *http.Request, _ string) {
+				r.SetBasicAuth(introspectorID, oidctestutil.ConfidentialOIDCClientSecret)
+			},
+			wantStatus: http.StatusOK,
+			wantResponseJSON: map[string]interface{}{
+				"active":    true,
+				"client_id": clientregistry.PinnipedCLIClientID,
+				"scope":     "openid offline_access",
+				"sub":       "some-subject",
+				"username":  "some-username",
+			},
+		},
+		{
+			name: "introspecting an unknown token",
+			body: func(_, _ string) url.Values {
+				return url.Values{"token": {"some-unknown-token"}}
+			},
+			authorize: func(r *http.Request, _ string) {
+				r.SetBasicAuth(introspectorID, oidctestutil.ConfidentialOIDCClientSecret)
+			},
+			wantStatus:       http.StatusOK,
+			wantResponseJSON: map[string]interface{}{"active": false},
+		},
+		{
+			name: "the caller may authenticate with an active access token instead of client credentials",
+			body: func(_, refreshToken string) url.Values {
+				return url.Values{"token": {refreshToken}}
+			},
+			authorize: func(r *http.Request, accessToken string) {
+				r.Header.Set("Authorization", "Bearer "+accessToken)
+			},
+			wantStatus: http.StatusOK,
+			wantResponseJSON: map[string]interface{}{
+				"active":    true,
+				"client_id": clientregistry.PinnipedCLIClientID,
+				"scope":     "openid offline_access",
+				"sub":       "some-subject",
+				"username":  "some-username",
+			},
+		},
+		{
+			name: "the caller must authenticate",
+			body: func(accessToken, _ string) url.Values {
+				return url.Values{"token": {accessToken}}
+			},
+			wantStatus:    http.StatusUnauthorized,
+			wantErrorJSON: "request_unauthorized",
+		},
+		{
+			name: "the caller must use the correct client secret",
+			body: func(accessToken, _ string) url.Values {
+				return url.Values{"token": {accessToken}}
+			},
+			authorize: func(r *http.Request, _ string) {
+				r.SetBasicAuth(introspectorID, "wrong-secret")
+			},
+			wantStatus:    http.StatusUnauthorized,
+			wantErrorJSON: "request_unauthorized",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			secrets := fake.NewSimpleClientset().CoreV1().Secrets("some-namespace")
+			timeoutsConfiguration := oidc.DefaultOIDCTimeoutsConfiguration()
+			oauthStore := oidc.NewKubeStorage(secrets, oidctestutil.NewClientManager("some-namespace", introspector, introspectorSecret), timeoutsConfiguration)
+			oauthHelper := oidc.FositeOauth2Helper(oauthStore, downstreamIssuer, func() [][]byte { return [][]byte{hmacSecret} }, nil, timeoutsConfiguration)
+
+			accessToken, refreshToken := oidctestutil.StoreDownstreamTokens(t, oauthStore, hmacSecret, newDownstreamRequest())
+
+			req := httptest.NewRequest(http.MethodPost, "/path/shouldn't/matter", strings.NewReader(test.body(accessToken, refreshToken).Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if test.authorize != nil {
+				test.authorize(req, accessToken)
+			}
+			rsp := httptest.NewRecorder()
+			NewHandler(oauthHelper).ServeHTTP(rsp, req)
+			t.Logf("response: %#v", rsp)
+			t.Logf("response body: %q", rsp.Body.String())
+
+			require.Equal(t, test.wantStatus, rsp.Code)
+			if test.wantErrorJSON != "" {
+				require.Contains(t, rsp.Body.String(), `"error":"`+test.wantErrorJSON+`"`)
+			}
+			if test.wantResponseJSON != nil {
+				var parsedResponse map[string]interface{}
+				require.NoError(t, json.Unmarshal(rsp.Body.Bytes(), &parsedResponse))
+				for key, value := range test.wantResponseJSON {
+					require.Equal(t, value, parsedResponse[key], "unexpected value for %q", key)
+				}
+				if parsedResponse["active"] == false {
+					require.Len(t, parsedResponse, 1)
+				}
+			}
+		})
+	}
+}
+
+func newDownstreamRequest() *fosite.Request {
+	now := time.Now().UTC()
+	session := psession.NewPinnipedSession()
+	session.Claims = &jwt.IDTokenClaims{
+		Subject:     "some-subject",
+		RequestedAt: now,
+		AuthTime:    now,
+		Extra:       map[string]interface{}{oidc.DownstreamUsernameClaim: "some-username", oidc.DownstreamGroupsClaim: []string{"some-group"}},
+	}
+	session.ExpiresAt = map[fosite.TokenType]time.Time{
+		fosite.AccessToken:  now.Add(time.Minute),
+		fosite.RefreshToken: now.Add(time.Hour),
+	}
+	return &fosite.Request{
+		ID:             "some-request-id",
+		RequestedAt:    now,
+		Client:         clientregistry.PinnipedCLI(),
+		RequestedScope: fosite.Arguments{"openid", "offline_access"},
+		GrantedScope:   fosite.Arguments{"openid", "offline_access"},
+		Form:           url.Values{},
+		Session:        session,
+	}
+}
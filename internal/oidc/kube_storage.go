@@ -13,8 +13,10 @@ import (
 	fositepkce "github.com/ory/fosite/handler/pkce"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 
+	"go.pinniped.dev/internal/crud"
 	"go.pinniped.dev/internal/fositestorage/accesstoken"
 	"go.pinniped.dev/internal/fositestorage/authorizationcode"
+	"go.pinniped.dev/internal/fositestorage/devicecode"
 	"go.pinniped.dev/internal/fositestorage/openidconnect"
 	"go.pinniped.dev/internal/fositestorage/pkce"
 	"go.pinniped.dev/internal/fositestorage/refreshtoken"
@@ -29,19 +31,34 @@ type KubeStorage struct {
 	oidcStorage              openid.OpenIDConnectRequestStorage
 	accessTokenStorage       accesstoken.RevocationStorage
 	refreshTokenStorage      refreshtoken.RevocationStorage
+	deviceCodeStorage        devicecode.DeviceCodeStorage
 }
 
 var _ fositestoragei.AllFositeStorage = &KubeStorage{}
 
-func NewKubeStorage(secrets corev1client.SecretInterface, timeoutsConfiguration TimeoutsConfiguration) *KubeStorage {
-	nowFunc := time.Now
+func NewKubeStorage(
+	secrets corev1client.SecretInterface,
+	clientManager *clientregistry.ClientManager,
+	timeoutsConfiguration TimeoutsConfiguration,
+) *KubeStorage {
+	return NewKubeStorageWithStorageFactory(crud.SecretsStorageFactory(secrets, time.Now), clientManager, timeoutsConfiguration)
+}
+
+// NewKubeStorageWithStorageFactory is like NewKubeStorage, but stores the sessions in the Storage returned by
+// storageFactory for each type of session, instead of storing each session as a Secret.
+func NewKubeStorageWithStorageFactory(
+	storageFactory crud.StorageFactory,
+	clientManager *clientregistry.ClientManager,
+	timeoutsConfiguration TimeoutsConfiguration,
+) *KubeStorage {
 	return &KubeStorage{
-		clientManager:            &clientregistry.StaticClientManager{},
-		authorizationCodeStorage: authorizationcode.New(secrets, nowFunc, timeoutsConfiguration.AuthorizationCodeSessionStorageLifetime),
-		pkceStorage:              pkce.New(secrets, nowFunc, timeoutsConfiguration.PKCESessionStorageLifetime),
-		oidcStorage:              openidconnect.New(secrets, nowFunc, timeoutsConfiguration.OIDCSessionStorageLifetime),
-		accessTokenStorage:       accesstoken.New(secrets, nowFunc, timeoutsConfiguration.AccessTokenSessionStorageLifetime),
-		refreshTokenStorage:      refreshtoken.New(secrets, nowFunc, timeoutsConfiguration.RefreshTokenSessionStorageLifetime),
+		clientManager:            clientManager,
+		authorizationCodeStorage: authorizationcode.NewWithStorageFactory(storageFactory, timeoutsConfiguration.AuthorizationCodeSessionStorageLifetime),
+		pkceStorage:              pkce.NewWithStorageFactory(storageFactory, timeoutsConfiguration.PKCESessionStorageLifetime),
+		oidcStorage:              openidconnect.NewWithStorageFactory(storageFactory, timeoutsConfiguration.OIDCSessionStorageLifetime),
+		accessTokenStorage:       accesstoken.NewWithStorageFactory(storageFactory, timeoutsConfiguration.AccessTokenSessionStorageLifetime),
+		refreshTokenStorage:      refreshtoken.NewWithStorageFactory(storageFactory, timeoutsConfiguration.RefreshTokenSessionStorageLifetime),
+		deviceCodeStorage:        devicecode.NewWithStorageFactory(storageFactory, timeoutsConfiguration.DeviceCodeSessionStorageLifetime),
 	}
 }
 
@@ -180,9 +197,41 @@ func (k KubeStorage) RevokeRefreshToken(ctx context.Context, requestID string) e
 	return k.refreshTokenStorage.RevokeRefreshToken(ctx, requestID)
 }
 
+//
+// Device code sessions:
+//
+// These are keyed by the signature of the device code, and they can also be found by their user code.
+//
+// Our device authorization endpoint will create these. Our device verification flow will approve or deny them after
+// the end user has logged in with the upstream IDP. Our device code grant handler in the token endpoint will delete
+// them when the device code is redeemed for tokens. If the client stops polling, then they will be garbage collected.
+//
+
+func (k KubeStorage) CreateDeviceCodeSession(ctx context.Context, signatureOfDeviceCode string, userCode string, expiresAt time.Time, requester fosite.Requester) error {
+	return k.deviceCodeStorage.CreateDeviceCodeSession(ctx, signatureOfDeviceCode, userCode, expiresAt, requester)
+}
+
+func (k KubeStorage) GetDeviceCodeSession(ctx context.Context, signatureOfDeviceCode string) (*devicecode.Session, error) {
+	return k.deviceCodeStorage.GetDeviceCodeSession(ctx, signatureOfDeviceCode)
+}
+
+func (k KubeStorage) GetDeviceCodeSessionByUserCode(ctx context.Context, userCode string) (string, *devicecode.Session, error) {
+	return k.deviceCodeStorage.GetDeviceCodeSessionByUserCode(ctx, userCode)
+}
+
+func (k KubeStorage) UpdateDeviceCodeSession(ctx context.Context, signatureOfDeviceCode string, session *devicecode.Session) error {
+	return k.deviceCodeStorage.UpdateDeviceCodeSession(ctx, signatureOfDeviceCode, session)
+}
+
+func (k KubeStorage) DeleteDeviceCodeSession(ctx context.Context, signatureOfDeviceCode string) error {
+	return k.deviceCodeStorage.DeleteDeviceCodeSession(ctx, signatureOfDeviceCode)
+}
+
 //
 // OAuth client definitions:
 //
+// These are the static Pinniped CLI client and any OIDCClients, which are read from an informer cache.
+//
 
 func (k KubeStorage) GetClient(ctx context.Context, id string) (fosite.Client, error) {
 	return k.clientManager.GetClient(ctx, id)
@@ -5,18 +5,22 @@ package oidc
 
 import (
 	"context"
+	"time"
 
 	"github.com/ory/fosite"
 
 	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/fositestorage/devicecode"
 	"go.pinniped.dev/internal/fositestoragei"
 	"go.pinniped.dev/internal/oidc/clientregistry"
 )
 
 const errNullStorageNotImplemented = constable.Error("NullStorage does not implement this method. It should not have been called.")
 
+// NullStorage implements the fosite storage interfaces without storing anything. It only knows how to look up
+// clients, using its ClientManager.
 type NullStorage struct {
-	clientregistry.StaticClientManager
+	*clientregistry.ClientManager
 }
 
 var _ fositestoragei.AllFositeStorage = &NullStorage{}
@@ -88,3 +92,23 @@ func (NullStorage) GetAuthorizeCodeSession(_ context.Context, _ string, _ fosite
 func (NullStorage) InvalidateAuthorizeCodeSession(_ context.Context, _ string) (err error) {
 	return errNullStorageNotImplemented
 }
+
+func (NullStorage) CreateDeviceCodeSession(_ context.Context, _ string, _ string, _ time.Time, _ fosite.Requester) error {
+	return errNullStorageNotImplemented
+}
+
+func (NullStorage) GetDeviceCodeSession(_ context.Context, _ string) (*devicecode.Session, error) {
+	return nil, errNullStorageNotImplemented
+}
+
+func (NullStorage) GetDeviceCodeSessionByUserCode(_ context.Context, _ string) (string, *devicecode.Session, error) {
+	return "", nil, errNullStorageNotImplemented
+}
+
+func (NullStorage) UpdateDeviceCodeSession(_ context.Context, _ string, _ *devicecode.Session) error {
+	return errNullStorageNotImplemented
+}
+
+func (NullStorage) DeleteDeviceCodeSession(_ context.Context, _ string) error {
+	return errNullStorageNotImplemented
+}
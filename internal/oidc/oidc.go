@@ -11,6 +11,7 @@ import (
 	"github.com/ory/fosite"
 	"github.com/ory/fosite/compose"
 
+	"go.pinniped.dev/internal/idtransform"
 	"go.pinniped.dev/internal/oidc/csrftoken"
 	"go.pinniped.dev/internal/oidc/jwks"
 	"go.pinniped.dev/internal/oidc/provider"
@@ -20,12 +21,19 @@ import (
 )
 
 const (
-	WellKnownEndpointPath     = "/.well-known/openid-configuration"
-	AuthorizationEndpointPath = "/oauth2/authorize"
-	TokenEndpointPath         = "/oauth2/token" //nolint:gosec // ignore lint warning that this is a credential
-	CallbackEndpointPath      = "/callback"
-	JWKSEndpointPath          = "/jwks.json"
-	PinnipedIDPsPathV1Alpha1  = "/v1alpha1/pinniped_identity_providers"
+	WellKnownEndpointPath           = "/.well-known/openid-configuration"
+	AuthorizationEndpointPath       = "/oauth2/authorize"
+	TokenEndpointPath               = "/oauth2/token" //nolint:gosec // ignore lint warning that this is a credential
+	DeviceAuthorizationEndpointPath = "/oauth2/device_authorization"
+	DeviceVerificationEndpointPath  = "/oauth2/device"
+	RevocationEndpointPath          = "/oauth2/revoke"
+	IntrospectionEndpointPath       = "/oauth2/introspect"
+	UserInfoEndpointPath            = "/oauth2/userinfo"
+	CallbackEndpointPath            = "/callback"
+	SAMLACSEndpointPath             = "/saml/acs"
+	LoginEndpointPath               = "/login"
+	JWKSEndpointPath                = "/jwks.json"
+	PinnipedIDPsPathV1Alpha1        = "/v1alpha1/pinniped_identity_providers"
 )
 
 const (
@@ -62,6 +70,30 @@ const (
 	// information.
 	DownstreamGroupsClaim = "groups"
 
+	// AuthorizeUpstreamIDPNameParamName is the name of the optional param on the authorize endpoint which selects
+	// the upstream IDP by name. When omitted and more than one upstream IDP is configured, the end user will be asked
+	// to choose one.
+	AuthorizeUpstreamIDPNameParamName = "pinniped_idp_name"
+
+	// AuthorizeUpstreamIDPTypeParamName is the name of the optional param on the authorize endpoint which selects
+	// the type of the upstream IDP, e.g. "oidc" or "ldap". It is only needed to disambiguate between upstream IDPs
+	// of different types which have the same name.
+	AuthorizeUpstreamIDPTypeParamName = "pinniped_idp_type"
+
+	// AuthorizeDeviceUserCodeParamName is the name of the param on the authorize endpoint which is used by the device
+	// verification endpoint to start an upstream login on behalf of a device authorization request. When present, the
+	// upstream login results in the approval of that device authorization request instead of in a downstream authcode.
+	AuthorizeDeviceUserCodeParamName = "pinniped_device_user_code"
+
+	// AuthorizeDeviceCSRFParamName is the name of the param which carries the CSRF token when the end user confirms
+	// the login of their device on the authorize endpoint. The upstream login is only started when it matches the
+	// CSRF cookie, so that a link or a form on another site cannot log the end user into somebody else's device.
+	AuthorizeDeviceCSRFParamName = "pinniped_device_csrf"
+
+	// DeviceCodePollingInterval is the minimum amount of time that a client must wait between polling requests to the
+	// token endpoint when using the device authorization grant.
+	DeviceCodePollingInterval = 5 * time.Second
+
 	// CSRFCookieLifespan is the length of time that the CSRF cookie is valid. After this time, the
 	// Supervisor's authorization endpoint should give the browser a new CSRF cookie. We set it to
 	// a week so that it is unlikely to expire during a login.
@@ -97,6 +129,21 @@ type UpstreamStateParamData struct {
 	CSRFToken     csrftoken.CSRFToken `json:"c"`
 	PKCECode      pkce.Code           `json:"k"`
 	FormatVersion string              `json:"v"`
+
+	// DeviceUserCode is only set when the upstream login was started by the device verification endpoint, in which
+	// case AuthParams is empty because the login will approve that device authorization request instead.
+	DeviceUserCode string `json:"d,omitempty"`
+
+	// UpstreamType is only set when the upstream is not an OIDC provider, i.e. for a GitHub or SAML provider, so that
+	// the callback endpoints can tell apart upstreams of different types which have the same name.
+	UpstreamType string `json:"t,omitempty"`
+}
+
+// SAMLRequestID returns the ID of the SAML authentication request which is sent to an upstream SAML provider for the
+// given state param nonce. The assertion consumer service checks that the response is for a request with this ID.
+// XML IDs must not start with a digit, so the nonce is prefixed.
+func SAMLRequestID(n nonce.Nonce) string {
+	return "_" + string(n)
 }
 
 type TimeoutsConfiguration struct {
@@ -112,6 +159,10 @@ type TimeoutsConfiguration struct {
 	// has to come back to exchange the authcode for tokens at the token endpoint.
 	AuthorizeCodeLifespan time.Duration
 
+	// How long a device code issued by the device authorization endpoint is valid. This determines how much time
+	// the end user has to visit the device verification endpoint and finish their login with the upstream IDP.
+	DeviceCodeLifespan time.Duration
+
 	// The lifetime of an downstream access token issued by the token endpoint. Access tokens should generally
 	// be fairly short-lived.
 	AccessTokenLifespan time.Duration
@@ -151,6 +202,11 @@ type TimeoutsConfiguration struct {
 	// as AuthorizeCodeLifespan to avoid any chance of the garbage collector deleting it while it is being used.
 	OIDCSessionStorageLifetime time.Duration
 
+	// DeviceCodeSessionStorageLifetime is the length of time after which a device authorization request is allowed
+	// to be garbage collected from storage. Device authorization requests are explicitly deleted when their device code
+	// is redeemed at the token endpoint, so this can be just slightly longer than the DeviceCodeLifespan.
+	DeviceCodeSessionStorageLifetime time.Duration
+
 	// AccessTokenSessionStorageLifetime is the length of time after which an access token's session data is allowed
 	// to be garbage collected from storage.  These must exist in storage for as long as the refresh token is valid
 	// or else the refresh flow will not work properly. So this must be longer than RefreshTokenLifespan.
@@ -167,21 +223,39 @@ type TimeoutsConfiguration struct {
 	RefreshTokenSessionStorageLifetime time.Duration
 }
 
+const (
+	// DefaultAccessTokenLifespan is the lifetime of downstream access tokens and ID tokens when a FederationDomain
+	// does not configure its own token lifetimes.
+	DefaultAccessTokenLifespan = 2 * time.Minute
+
+	// DefaultRefreshTokenLifespan is the lifetime of downstream refresh tokens when a FederationDomain does not
+	// configure its own token lifetimes.
+	DefaultRefreshTokenLifespan = 9 * time.Hour
+)
+
 // Get the defaults for the Supervisor server.
 func DefaultOIDCTimeoutsConfiguration() TimeoutsConfiguration {
-	accessTokenLifespan := 2 * time.Minute
+	return OIDCTimeoutsConfigurationWithTokenLifespans(DefaultAccessTokenLifespan, DefaultRefreshTokenLifespan)
+}
+
+// OIDCTimeoutsConfigurationWithTokenLifespans returns the defaults for the Supervisor server, except that downstream
+// access tokens, ID tokens, and refresh tokens have the given lifetimes. The storage lifetimes which depend on the
+// token lifetimes are adjusted to match.
+func OIDCTimeoutsConfigurationWithTokenLifespans(accessTokenLifespan, refreshTokenLifespan time.Duration) TimeoutsConfiguration {
 	authorizationCodeLifespan := 10 * time.Minute
-	refreshTokenLifespan := 9 * time.Hour
+	deviceCodeLifespan := 10 * time.Minute
 
 	return TimeoutsConfiguration{
 		UpstreamStateParamLifespan:              90 * time.Minute,
 		AuthorizeCodeLifespan:                   authorizationCodeLifespan,
+		DeviceCodeLifespan:                      deviceCodeLifespan,
 		AccessTokenLifespan:                     accessTokenLifespan,
 		IDTokenLifespan:                         accessTokenLifespan,
 		RefreshTokenLifespan:                    refreshTokenLifespan,
 		AuthorizationCodeSessionStorageLifetime: authorizationCodeLifespan + refreshTokenLifespan,
 		PKCESessionStorageLifetime:              authorizationCodeLifespan + (1 * time.Minute),
 		OIDCSessionStorageLifetime:              authorizationCodeLifespan + (1 * time.Minute),
+		DeviceCodeSessionStorageLifetime:        deviceCodeLifespan + (1 * time.Minute),
 		AccessTokenSessionStorageLifetime:       refreshTokenLifespan + accessTokenLifespan,
 		RefreshTokenSessionStorageLifetime:      refreshTokenLifespan + accessTokenLifespan,
 	}
@@ -190,7 +264,7 @@ func DefaultOIDCTimeoutsConfiguration() TimeoutsConfiguration {
 func FositeOauth2Helper(
 	oauthStore interface{},
 	issuer string,
-	hmacSecretOfLengthAtLeast32Func func() []byte,
+	hmacSecretsOfLengthAtLeast32Func func() [][]byte,
 	jwksProvider jwks.DynamicJWKSProvider,
 	timeoutsConfiguration TimeoutsConfiguration,
 ) fosite.OAuth2Provider {
@@ -222,8 +296,8 @@ func FositeOauth2Helper(
 		oauthConfig,
 		oauthStore,
 		&compose.CommonStrategy{
-			// Note that Fosite requires the HMAC secret to be at least 32 bytes.
-			CoreStrategy:               newDynamicOauth2HMACStrategy(oauthConfig, hmacSecretOfLengthAtLeast32Func),
+			// Note that Fosite requires the HMAC secrets to be at least 32 bytes.
+			CoreStrategy:               newDynamicOauth2HMACStrategy(oauthConfig, hmacSecretsOfLengthAtLeast32Func),
 			OpenIDConnectTokenStrategy: newDynamicOpenIDConnectECDSAStrategy(oauthConfig, jwksProvider),
 		},
 		nil, // hasher, defaults to using BCrypt when nil. Used for hashing client secrets.
@@ -232,7 +306,10 @@ func FositeOauth2Helper(
 		compose.OpenIDConnectExplicitFactory,
 		compose.OpenIDConnectRefreshFactory,
 		compose.OAuth2PKCEFactory,
+		compose.OAuth2TokenRevocationFactory,
+		compose.OAuth2TokenIntrospectionFactory,
 		TokenExchangeFactory,
+		DeviceCodeFactory,
 	)
 	provider.(*fosite.Fosite).FormPostHTMLTemplate = formposthtml.Template()
 	return provider
@@ -267,19 +344,47 @@ type UpstreamLDAPIdentityProvidersLister interface {
 	GetLDAPIdentityProviders() []provider.UpstreamLDAPIdentityProviderI
 }
 
-type UpstreamIdentityProvidersLister interface {
+type UpstreamActiveDirectoryIdentityProvidersLister interface {
+	GetActiveDirectoryIdentityProviders() []provider.UpstreamLDAPIdentityProviderI
+}
+
+type UpstreamGitHubIdentityProvidersLister interface {
+	GetGitHubIdentityProviders() []provider.UpstreamOIDCIdentityProviderI
+}
+
+type UpstreamSAMLIdentityProvidersLister interface {
+	GetSAMLIdentityProviders() []provider.UpstreamSAMLIdentityProviderI
+}
+
+// IdentityTransformsLister looks up the identity transforms which apply to users who log in through an upstream IDP.
+type IdentityTransformsLister interface {
+	GetIdentityTransforms(idpName string, idpType string) *idtransform.Pipeline
+}
+
+// AllUpstreamIdentityProvidersLister lists all upstream IDPs, regardless of which FederationDomains may use them.
+type AllUpstreamIdentityProvidersLister interface {
 	UpstreamOIDCIdentityProvidersLister
 	UpstreamLDAPIdentityProvidersLister
+	UpstreamActiveDirectoryIdentityProvidersLister
+	UpstreamGitHubIdentityProvidersLister
+	UpstreamSAMLIdentityProvidersLister
+}
+
+// UpstreamIdentityProvidersLister lists the upstream IDPs which a FederationDomain may use, along with the identity
+// transforms which that FederationDomain applies to them.
+type UpstreamIdentityProvidersLister interface {
+	AllUpstreamIdentityProvidersLister
+	IdentityTransformsLister
 }
 
-func GrantScopeIfRequested(authorizeRequester fosite.AuthorizeRequester, scopeName string) {
-	if ScopeWasRequested(authorizeRequester, scopeName) {
-		authorizeRequester.GrantScope(scopeName)
+func GrantScopeIfRequested(requester fosite.Requester, scopeName string) {
+	if ScopeWasRequested(requester, scopeName) {
+		requester.GrantScope(scopeName)
 	}
 }
 
-func ScopeWasRequested(authorizeRequester fosite.AuthorizeRequester, scopeName string) bool {
-	for _, scope := range authorizeRequester.GetRequestedScopes() {
+func ScopeWasRequested(requester fosite.Requester, scopeName string) bool {
+	for _, scope := range requester.GetRequestedScopes() {
 		if scope == scopeName {
 			return true
 		}
@@ -0,0 +1,75 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package chooseidphtml defines the HTML template for the page which lets an end user pick an upstream
+// identity provider when the Supervisor has more than one configured.
+//nolint: gochecknoglobals // This package uses globals to ensure that all parsing and minifying happens at init.
+package chooseidphtml
+
+import (
+	"crypto/sha256"
+	_ "embed" // Needed to trigger //go:embed directives below.
+	"encoding/base64"
+	"html/template"
+	"strings"
+
+	"github.com/tdewolff/minify/v2/minify"
+)
+
+var (
+	//go:embed choose_idp.css
+	rawCSS      string
+	minifiedCSS = mustMinify(minify.CSS(rawCSS))
+
+	//go:embed choose_idp.gohtml
+	rawHTMLTemplate string
+)
+
+// Parse the Go templated HTML and inject functions providing the minified inline CSS.
+var parsedHTMLTemplate = template.Must(template.New("choose_idp.gohtml").Funcs(template.FuncMap{
+	"minifiedCSS": func() template.CSS { return template.CSS(minifiedCSS) },
+}).Parse(rawHTMLTemplate))
+
+// Generate the CSP header value once since it's effectively constant:
+var cspValue = strings.Join([]string{
+	`default-src 'none'`,
+	`style-src '` + cspHash(minifiedCSS) + `'`,
+	`frame-ancestors 'none'`,
+}, "; ")
+
+// PageData is the data used to render the Template().
+type PageData struct {
+	IdentityProviders []IdentityProvider
+}
+
+// IdentityProvider is a single choice rendered on the page.
+type IdentityProvider struct {
+	// Name of the upstream identity provider.
+	Name string
+
+	// Type of the upstream identity provider, e.g. "oidc" or "ldap".
+	Type string
+
+	// URL is the authorize endpoint URL, including the original authorization request params, which will
+	// continue the login using this upstream identity provider.
+	URL string
+}
+
+func mustMinify(s string, err error) string {
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func cspHash(s string) string {
+	hashBytes := sha256.Sum256([]byte(s))
+	return "sha256-" + base64.StdEncoding.EncodeToString(hashBytes[:])
+}
+
+// ContentSecurityPolicy returns the Content-Security-Policy header value to make the Template() operate correctly.
+func ContentSecurityPolicy() string { return cspValue }
+
+// Template returns the html/template.Template for rendering the identity provider chooser page.
+// It should be executed with a PageData.
+func Template() *template.Template { return parsedHTMLTemplate }
@@ -0,0 +1,58 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package chooseidphtml
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplate(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Template().Execute(&buf, &PageData{
+		IdentityProviders: []IdentityProvider{
+			{
+				Name: "some-ldap-idp",
+				Type: "ldap",
+				URL:  "https://issuer.example.com/oauth2/authorize?client_id=pinniped-cli&pinniped_idp_name=some-ldap-idp&pinniped_idp_type=ldap",
+			},
+			{
+				Name: "<some-oidc-idp>",
+				Type: "oidc",
+				URL:  "https://issuer.example.com/oauth2/authorize?client_id=pinniped-cli&pinniped_idp_name=%3Csome-oidc-idp%3E&pinniped_idp_type=oidc",
+			},
+		},
+	}))
+	out := buf.String()
+
+	require.Contains(t, out, "<style>"+minifiedCSS+"</style>")
+	require.Contains(t, out,
+		`<li><a href="https://issuer.example.com/oauth2/authorize?client_id=pinniped-cli&amp;pinniped_idp_name=some-ldap-idp&amp;pinniped_idp_type=ldap">`+
+			`some-ldap-idp<span class="idp-type">ldap</span></a></li>`,
+	)
+	// The name of the IDP must be escaped in the body, and the URL must be left alone other than the normal escaping of ampersands.
+	require.Contains(t, out,
+		`<li><a href="https://issuer.example.com/oauth2/authorize?client_id=pinniped-cli&amp;pinniped_idp_name=%3Csome-oidc-idp%3E&amp;pinniped_idp_type=oidc">`+
+			`&lt;some-oidc-idp&gt;<span class="idp-type">oidc</span></a></li>`,
+	)
+	require.NotContains(t, out, "<script")
+}
+
+func TestContentSecurityPolicyHashes(t *testing.T) {
+	require.Equal(t,
+		`default-src 'none'; style-src '`+cspHash(minifiedCSS)+`'; frame-ancestors 'none'`,
+		ContentSecurityPolicy(),
+	)
+}
+
+func TestHelpers(t *testing.T) {
+	require.Equal(t, "test", mustMinify("test", nil))
+	require.PanicsWithError(t, "some error", func() { mustMinify("", fmt.Errorf("some error")) })
+
+	// Example test vector from https://content-security-policy.com/hash/.
+	require.Equal(t, "sha256-RFWPLDbv2BY+rCkDzsE+0fr8ylGr2R2faWMhq4lfEQc=", cspHash("doSomething();"))
+}
@@ -0,0 +1,83 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package deviceverificationhtml defines the HTML template for the page where an end user enters the user code
+// which is displayed by their device during the device authorization grant.
+//nolint: gochecknoglobals // This package uses globals to ensure that all parsing and minifying happens at init.
+package deviceverificationhtml
+
+import (
+	"crypto/sha256"
+	_ "embed" // Needed to trigger //go:embed directives below.
+	"encoding/base64"
+	"html/template"
+	"strings"
+
+	"github.com/tdewolff/minify/v2/minify"
+)
+
+var (
+	//go:embed device_verification.css
+	rawCSS      string
+	minifiedCSS = mustMinify(minify.CSS(rawCSS))
+
+	//go:embed device_verification.gohtml
+	rawHTMLTemplate string
+)
+
+// Parse the Go templated HTML and inject functions providing the minified inline CSS.
+var parsedHTMLTemplate = template.Must(template.New("device_verification.gohtml").Funcs(template.FuncMap{
+	"minifiedCSS": func() template.CSS { return template.CSS(minifiedCSS) },
+}).Parse(rawHTMLTemplate))
+
+// Generate the CSP header value once since it's effectively constant:
+var cspValue = strings.Join([]string{
+	`default-src 'none'`,
+	`style-src '` + cspHash(minifiedCSS) + `'`,
+	`frame-ancestors 'none'`,
+}, "; ")
+
+// PageData is the data used to render the Template().
+type PageData struct {
+	// FormAction is the URL of the device verification endpoint, to which the user code is submitted.
+	FormAction string
+
+	// UserCode is the initial value of the user code input, e.g. from the verification_uri_complete.
+	UserCode string
+
+	// ErrorMessage explains why a previously submitted user code was not accepted, if any.
+	ErrorMessage string
+
+	// Confirm causes the page to ask the end user to confirm that they want to log in to the device of the client
+	// with ClientID which displays the UserCode, by submitting the HiddenParams to the FormAction.
+	Confirm bool
+
+	// ClientID is the ID of the client which made the device authorization request that is being confirmed.
+	ClientID string
+
+	// HiddenParams are submitted along with the confirmation, e.g. the user code and the CSRF token.
+	HiddenParams map[string]string
+
+	// Success causes the page to tell the end user that their device has been logged in, instead of
+	// showing the form.
+	Success bool
+}
+
+func mustMinify(s string, err error) string {
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func cspHash(s string) string {
+	hashBytes := sha256.Sum256([]byte(s))
+	return "sha256-" + base64.StdEncoding.EncodeToString(hashBytes[:])
+}
+
+// ContentSecurityPolicy returns the Content-Security-Policy header value to make the Template() operate correctly.
+func ContentSecurityPolicy() string { return cspValue }
+
+// Template returns the html/template.Template for rendering the device verification page.
+// It should be executed with a PageData.
+func Template() *template.Template { return parsedHTMLTemplate }
@@ -0,0 +1,80 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package deviceverificationhtml
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplate(t *testing.T) {
+	t.Run("form", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Template().Execute(&buf, &PageData{
+			FormAction:   "https://issuer.example.com/oauth2/device",
+			UserCode:     "<ABCD-EFGH>",
+			ErrorMessage: "The code has expired.",
+		}))
+		out := buf.String()
+
+		require.Contains(t, out, "<style>"+minifiedCSS+"</style>")
+		require.Contains(t, out, `<form method="post" action="https://issuer.example.com/oauth2/device">`)
+		// The user code must be escaped, since it may have come from the query params.
+		require.Contains(t, out, `value="&lt;ABCD-EFGH&gt;"`)
+		require.Contains(t, out, `<p class="error">The code has expired.</p>`)
+		require.NotContains(t, out, "has been logged in")
+		require.NotContains(t, out, "<script")
+	})
+
+	t.Run("confirm", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Template().Execute(&buf, &PageData{
+			FormAction: "https://issuer.example.com/oauth2/authorize",
+			UserCode:   "ABCD-EFGH",
+			Confirm:    true,
+			ClientID:   "<pinniped-cli>",
+			HiddenParams: map[string]string{
+				"pinniped_device_user_code": "ABCDEFGH",
+				"pinniped_device_csrf":      "<csrf>",
+			},
+		}))
+		out := buf.String()
+
+		require.Contains(t, out, `<form method="post" action="https://issuer.example.com/oauth2/authorize">`)
+		require.Contains(t, out, `<strong>&lt;pinniped-cli&gt;</strong>`)
+		require.Contains(t, out, `<p class="user-code">ABCD-EFGH</p>`)
+		require.Contains(t, out, `<input type="hidden" name="pinniped_device_csrf" value="&lt;csrf&gt;">`)
+		require.Contains(t, out, `<input type="hidden" name="pinniped_device_user_code" value="ABCDEFGH">`)
+		require.NotContains(t, out, `name="user_code"`)
+		require.NotContains(t, out, "<script")
+	})
+
+	t.Run("success", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Template().Execute(&buf, &PageData{Success: true}))
+		out := buf.String()
+
+		require.Contains(t, out, "Your device has been logged in.")
+		require.NotContains(t, out, "<form")
+		require.NotContains(t, out, "<script")
+	})
+}
+
+func TestContentSecurityPolicyHashes(t *testing.T) {
+	require.Equal(t,
+		`default-src 'none'; style-src '`+cspHash(minifiedCSS)+`'; frame-ancestors 'none'`,
+		ContentSecurityPolicy(),
+	)
+}
+
+func TestHelpers(t *testing.T) {
+	require.Equal(t, "test", mustMinify("test", nil))
+	require.PanicsWithError(t, "some error", func() { mustMinify("", fmt.Errorf("some error")) })
+
+	// Example test vector from https://content-security-policy.com/hash/.
+	require.Equal(t, "sha256-RFWPLDbv2BY+rCkDzsE+0fr8ylGr2R2faWMhq4lfEQc=", cspHash("doSomething();"))
+}
@@ -9,6 +9,7 @@ import (
 	"sync"
 
 	"golang.org/x/oauth2"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
 
 	"go.pinniped.dev/internal/authenticators"
 	"go.pinniped.dev/pkg/oidcclient/nonce"
@@ -47,6 +48,12 @@ type UpstreamOIDCIdentityProviderI interface {
 	) (*oidctypes.Token, error)
 
 	ValidateToken(ctx context.Context, tok *oauth2.Token, expectedIDTokenNonce nonce.Nonce) (*oidctypes.Token, error)
+
+	// Performs an upstream OIDC refresh grant using the given refresh token, then validates the new tokens.
+	// Returns the new tokens, where the claims of the ID token also include the claims from the userinfo endpoint.
+	// Providers are not required to return a new ID token when refreshing, so the ID token may only have the claims
+	// from the userinfo endpoint, or it may be nil when there was neither a new ID token nor a userinfo endpoint.
+	Refresh(ctx context.Context, refreshToken string) (*oidctypes.Token, error)
 }
 
 type UpstreamLDAPIdentityProviderI interface {
@@ -60,6 +67,40 @@ type UpstreamLDAPIdentityProviderI interface {
 
 	// A method for performing user authentication against the upstream LDAP provider.
 	authenticators.UserAuthenticator
+
+	// Repeats the user search for a user who previously authenticated using the given username, but without binding
+	// as that user, and returns their current mapped username, groups, and UID. Returns false when the user can no
+	// longer be found.
+	RefreshUser(ctx context.Context, username string) (*authenticator.Response, bool, error)
+}
+
+// SAMLAuthnRequest is an authentication request which is ready to be sent to the single sign-on service of an
+// upstream SAML provider by the browser of the end user.
+type SAMLAuthnRequest struct {
+	// The location of the single sign-on service. For the HTTP-Redirect binding, this includes the request in its
+	// query params.
+	URL *url.URL
+
+	// The form values which must be posted to the URL, for the HTTP-POST binding. Nil for the HTTP-Redirect binding.
+	PostParams url.Values
+}
+
+type UpstreamSAMLIdentityProviderI interface {
+	// A name for this upstream provider.
+	GetName() string
+
+	// The entity ID of the upstream provider from its metadata. It is combined with the NameID of the user to make
+	// the downstream subject, since NameIDs are only unique within one provider.
+	GetEntityID() string
+
+	// Builds an authentication request with the given ID for the Supervisor as the service provider, which is
+	// identified by spEntityID and whose assertion consumer service is acsURL. The relayState is returned to the
+	// assertion consumer service along with the response of the upstream provider.
+	AuthnRequest(requestID string, spEntityID string, acsURL string, relayState string) (*SAMLAuthnRequest, error)
+
+	// Validates the base64-encoded response of the upstream provider to the authentication request with the given ID,
+	// and returns the mapped username and groups of the user, with their NameID as the UID.
+	ValidateResponse(ctx context.Context, encodedResponse string, requestID string, spEntityID string, acsURL string) (*authenticator.Response, error)
 }
 
 type DynamicUpstreamIDPProvider interface {
@@ -67,18 +108,30 @@ type DynamicUpstreamIDPProvider interface {
 	GetOIDCIdentityProviders() []UpstreamOIDCIdentityProviderI
 	SetLDAPIdentityProviders(ldapIDPs []UpstreamLDAPIdentityProviderI)
 	GetLDAPIdentityProviders() []UpstreamLDAPIdentityProviderI
+	SetActiveDirectoryIdentityProviders(adIDPs []UpstreamLDAPIdentityProviderI)
+	GetActiveDirectoryIdentityProviders() []UpstreamLDAPIdentityProviderI
+	SetGitHubIdentityProviders(gitHubIDPs []UpstreamOIDCIdentityProviderI)
+	GetGitHubIdentityProviders() []UpstreamOIDCIdentityProviderI
+	SetSAMLIdentityProviders(samlIDPs []UpstreamSAMLIdentityProviderI)
+	GetSAMLIdentityProviders() []UpstreamSAMLIdentityProviderI
 }
 
 type dynamicUpstreamIDPProvider struct {
-	oidcUpstreams []UpstreamOIDCIdentityProviderI
-	ldapUpstreams []UpstreamLDAPIdentityProviderI
-	mutex         sync.RWMutex
+	oidcUpstreams            []UpstreamOIDCIdentityProviderI
+	ldapUpstreams            []UpstreamLDAPIdentityProviderI
+	activeDirectoryUpstreams []UpstreamLDAPIdentityProviderI
+	gitHubUpstreams          []UpstreamOIDCIdentityProviderI
+	samlUpstreams            []UpstreamSAMLIdentityProviderI
+	mutex                    sync.RWMutex
 }
 
 func NewDynamicUpstreamIDPProvider() DynamicUpstreamIDPProvider {
 	return &dynamicUpstreamIDPProvider{
-		oidcUpstreams: []UpstreamOIDCIdentityProviderI{},
-		ldapUpstreams: []UpstreamLDAPIdentityProviderI{},
+		oidcUpstreams:            []UpstreamOIDCIdentityProviderI{},
+		ldapUpstreams:            []UpstreamLDAPIdentityProviderI{},
+		activeDirectoryUpstreams: []UpstreamLDAPIdentityProviderI{},
+		gitHubUpstreams:          []UpstreamOIDCIdentityProviderI{},
+		samlUpstreams:            []UpstreamSAMLIdentityProviderI{},
 	}
 }
 
@@ -105,3 +158,39 @@ func (p *dynamicUpstreamIDPProvider) GetLDAPIdentityProviders() []UpstreamLDAPId
 	defer p.mutex.RUnlock()
 	return p.ldapUpstreams
 }
+
+func (p *dynamicUpstreamIDPProvider) SetActiveDirectoryIdentityProviders(adIDPs []UpstreamLDAPIdentityProviderI) {
+	p.mutex.Lock() // acquire a write lock
+	defer p.mutex.Unlock()
+	p.activeDirectoryUpstreams = adIDPs
+}
+
+func (p *dynamicUpstreamIDPProvider) GetActiveDirectoryIdentityProviders() []UpstreamLDAPIdentityProviderI {
+	p.mutex.RLock() // acquire a read lock
+	defer p.mutex.RUnlock()
+	return p.activeDirectoryUpstreams
+}
+
+func (p *dynamicUpstreamIDPProvider) SetGitHubIdentityProviders(gitHubIDPs []UpstreamOIDCIdentityProviderI) {
+	p.mutex.Lock() // acquire a write lock
+	defer p.mutex.Unlock()
+	p.gitHubUpstreams = gitHubIDPs
+}
+
+func (p *dynamicUpstreamIDPProvider) GetGitHubIdentityProviders() []UpstreamOIDCIdentityProviderI {
+	p.mutex.RLock() // acquire a read lock
+	defer p.mutex.RUnlock()
+	return p.gitHubUpstreams
+}
+
+func (p *dynamicUpstreamIDPProvider) SetSAMLIdentityProviders(samlIDPs []UpstreamSAMLIdentityProviderI) {
+	p.mutex.Lock() // acquire a write lock
+	defer p.mutex.Unlock()
+	p.samlUpstreams = samlIDPs
+}
+
+func (p *dynamicUpstreamIDPProvider) GetSAMLIdentityProviders() []UpstreamSAMLIdentityProviderI {
+	p.mutex.RLock() // acquire a read lock
+	defer p.mutex.RUnlock()
+	return p.samlUpstreams
+}
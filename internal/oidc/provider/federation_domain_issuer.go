@@ -7,20 +7,57 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 
 	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/idtransform"
 )
 
 // FederationDomainIssuer represents all of the settings and state for a downstream OIDC provider
 // as defined by a FederationDomain.
 type FederationDomainIssuer struct {
-	issuer     string
-	issuerHost string
-	issuerPath string
+	issuer            string
+	issuerHost        string
+	issuerPath        string
+	identityProviders []FederationDomainIdentityProvider
+	tokenLifetimes    *TokenLifetimes
 }
 
-func NewFederationDomainIssuer(issuer string) (*FederationDomainIssuer, error) {
-	p := FederationDomainIssuer{issuer: issuer}
+// FederationDomainIdentityProvider identifies an upstream IDP which is allowed to be used by a FederationDomain.
+type FederationDomainIdentityProvider struct {
+	// Name of the upstream IDP.
+	Name string
+
+	// Type of the upstream IDP, e.g. "oidc" or "ldap".
+	Type string
+
+	// Transforms to apply to the identities of users who log in through the upstream IDP. May be nil.
+	Transforms *idtransform.Pipeline
+}
+
+const (
+	// MaxAccessTokenLifetime is the longest lifetime of access tokens and ID tokens which a FederationDomain may
+	// configure. The Supervisor's JWKS retention must not be shorter, so that ID tokens can always be verified.
+	MaxAccessTokenLifetime = time.Hour
+
+	// MaxRefreshTokenLifetime is the longest lifetime of refresh tokens which a FederationDomain may configure.
+	MaxRefreshTokenLifetime = 7 * 24 * time.Hour
+)
+
+// TokenLifetimes are the lifetimes of the downstream tokens issued by a FederationDomain.
+type TokenLifetimes struct {
+	// AccessToken is the lifetime of access tokens and ID tokens.
+	AccessToken time.Duration
+
+	// RefreshToken is the lifetime of refresh tokens.
+	RefreshToken time.Duration
+}
+
+// NewFederationDomainIssuer returns a validated FederationDomainIssuer. When identityProviders is empty,
+// then all upstream IDPs are allowed to be used by the FederationDomain. When tokenLifetimes is nil,
+// then the FederationDomain issues tokens with the default lifetimes.
+func NewFederationDomainIssuer(issuer string, identityProviders []FederationDomainIdentityProvider, tokenLifetimes *TokenLifetimes) (*FederationDomainIssuer, error) {
+	p := FederationDomainIssuer{issuer: issuer, identityProviders: identityProviders, tokenLifetimes: tokenLifetimes}
 	err := p.validate()
 	if err != nil {
 		return nil, err
@@ -58,6 +95,21 @@ func (p *FederationDomainIssuer) validate() error {
 		return constable.Error(`issuer must not have fragment`)
 	}
 
+	if p.tokenLifetimes != nil {
+		if p.tokenLifetimes.AccessToken <= 0 || p.tokenLifetimes.RefreshToken <= 0 {
+			return constable.Error(`token lifetimes must be positive`)
+		}
+		if p.tokenLifetimes.RefreshToken < p.tokenLifetimes.AccessToken {
+			return constable.Error(`refresh token lifetime must not be shorter than access token lifetime`)
+		}
+		if p.tokenLifetimes.AccessToken > MaxAccessTokenLifetime {
+			return fmt.Errorf("access token lifetime must not be longer than %s", MaxAccessTokenLifetime)
+		}
+		if p.tokenLifetimes.RefreshToken > MaxRefreshTokenLifetime {
+			return fmt.Errorf("refresh token lifetime must not be longer than %s", MaxRefreshTokenLifetime)
+		}
+	}
+
 	p.issuerHost = issuerURL.Host
 	p.issuerPath = issuerURL.Path
 
@@ -75,3 +127,34 @@ func (p *FederationDomainIssuer) IssuerHost() string {
 func (p *FederationDomainIssuer) IssuerPath() string {
 	return p.issuerPath
 }
+
+// TokenLifetimes returns the lifetimes of the tokens issued by this FederationDomain, or nil when it uses the
+// default lifetimes.
+func (p *FederationDomainIssuer) TokenLifetimes() *TokenLifetimes {
+	return p.tokenLifetimes
+}
+
+// AllowsIdentityProvider returns true when the upstream IDP of the given name and type may be used to log in
+// to this FederationDomain.
+func (p *FederationDomainIssuer) AllowsIdentityProvider(name string, idpType string) bool {
+	if len(p.identityProviders) == 0 {
+		return true
+	}
+	for _, idp := range p.identityProviders {
+		if idp.Name == name && idp.Type == idpType {
+			return true
+		}
+	}
+	return false
+}
+
+// IdentityTransforms returns the transforms which this FederationDomain applies to users who log in through the
+// upstream IDP of the given name and type, or nil when there are none.
+func (p *FederationDomainIssuer) IdentityTransforms(name string, idpType string) *idtransform.Pipeline {
+	for _, idp := range p.identityProviders {
+		if idp.Name == name && idp.Type == idpType {
+			return idp.Transforms
+		}
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ package provider
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -72,7 +73,7 @@ func TestFederationDomainIssuerValidations(t *testing.T) {
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := NewFederationDomainIssuer(tt.issuer)
+			_, err := NewFederationDomainIssuer(tt.issuer, nil, nil)
 			if tt.wantError != "" {
 				require.EqualError(t, err, tt.wantError)
 			} else {
@@ -81,3 +82,124 @@ func TestFederationDomainIssuerValidations(t *testing.T) {
 		})
 	}
 }
+
+func TestFederationDomainIssuerTokenLifetimes(t *testing.T) {
+	tests := []struct {
+		name           string
+		tokenLifetimes *TokenLifetimes
+		wantError      string
+	}{
+		{
+			name: "nil lifetimes means the defaults",
+		},
+		{
+			name:           "valid lifetimes",
+			tokenLifetimes: &TokenLifetimes{AccessToken: 5 * time.Minute, RefreshToken: 24 * time.Hour},
+		},
+		{
+			name:           "refresh token lifetime equal to access token lifetime",
+			tokenLifetimes: &TokenLifetimes{AccessToken: time.Hour, RefreshToken: time.Hour},
+		},
+		{
+			name:           "zero access token lifetime",
+			tokenLifetimes: &TokenLifetimes{RefreshToken: time.Hour},
+			wantError:      "token lifetimes must be positive",
+		},
+		{
+			name:           "negative refresh token lifetime",
+			tokenLifetimes: &TokenLifetimes{AccessToken: time.Minute, RefreshToken: -time.Hour},
+			wantError:      "token lifetimes must be positive",
+		},
+		{
+			name:           "refresh token lifetime shorter than access token lifetime",
+			tokenLifetimes: &TokenLifetimes{AccessToken: time.Hour, RefreshToken: time.Minute},
+			wantError:      "refresh token lifetime must not be shorter than access token lifetime",
+		},
+		{
+			name:           "maximum lifetimes",
+			tokenLifetimes: &TokenLifetimes{AccessToken: time.Hour, RefreshToken: 7 * 24 * time.Hour},
+		},
+		{
+			name:           "access token lifetime longer than the maximum",
+			tokenLifetimes: &TokenLifetimes{AccessToken: time.Hour + time.Second, RefreshToken: 24 * time.Hour},
+			wantError:      "access token lifetime must not be longer than 1h0m0s",
+		},
+		{
+			name:           "refresh token lifetime longer than the maximum",
+			tokenLifetimes: &TokenLifetimes{AccessToken: time.Hour, RefreshToken: 8 * 24 * time.Hour},
+			wantError:      "refresh token lifetime must not be longer than 168h0m0s",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewFederationDomainIssuer("https://tuna.com", nil, tt.tokenLifetimes)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.tokenLifetimes, p.TokenLifetimes())
+		})
+	}
+}
+
+func TestFederationDomainIssuerAllowsIdentityProvider(t *testing.T) {
+	tests := []struct {
+		name              string
+		identityProviders []FederationDomainIdentityProvider
+		idpName           string
+		idpType           string
+		wantAllowed       bool
+	}{
+		{
+			name:        "nil list of identity providers allows everything",
+			idpName:     "some-idp",
+			idpType:     "oidc",
+			wantAllowed: true,
+		},
+		{
+			name:              "empty list of identity providers allows everything",
+			identityProviders: []FederationDomainIdentityProvider{},
+			idpName:           "some-idp",
+			idpType:           "ldap",
+			wantAllowed:       true,
+		},
+		{
+			name: "identity provider is in the list",
+			identityProviders: []FederationDomainIdentityProvider{
+				{Name: "some-other-idp", Type: "oidc"},
+				{Name: "some-idp", Type: "ldap"},
+			},
+			idpName:     "some-idp",
+			idpType:     "ldap",
+			wantAllowed: true,
+		},
+		{
+			name: "identity provider name is in the list but with a different type",
+			identityProviders: []FederationDomainIdentityProvider{
+				{Name: "some-idp", Type: "oidc"},
+			},
+			idpName:     "some-idp",
+			idpType:     "ldap",
+			wantAllowed: false,
+		},
+		{
+			name: "identity provider is not in the list",
+			identityProviders: []FederationDomainIdentityProvider{
+				{Name: "some-other-idp", Type: "oidc"},
+			},
+			idpName:     "some-idp",
+			idpType:     "oidc",
+			wantAllowed: false,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewFederationDomainIssuer("https://tuna.com", tt.identityProviders, nil)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantAllowed, p.AllowsIdentityProvider(tt.idpName, tt.idpType))
+		})
+	}
+}
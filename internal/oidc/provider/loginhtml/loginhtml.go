@@ -0,0 +1,75 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package loginhtml defines the HTML template for the page where an end user enters their username and password
+// to log in with an LDAP or Active Directory upstream identity provider using a web browser.
+//nolint: gochecknoglobals // This package uses globals to ensure that all parsing and minifying happens at init.
+package loginhtml
+
+import (
+	"crypto/sha256"
+	_ "embed" // Needed to trigger //go:embed directives below.
+	"encoding/base64"
+	"html/template"
+	"strings"
+
+	"github.com/tdewolff/minify/v2/minify"
+)
+
+var (
+	//go:embed login_form.css
+	rawCSS      string
+	minifiedCSS = mustMinify(minify.CSS(rawCSS))
+
+	//go:embed login_form.gohtml
+	rawHTMLTemplate string
+)
+
+// Parse the Go templated HTML and inject functions providing the minified inline CSS.
+var parsedHTMLTemplate = template.Must(template.New("login_form.gohtml").Funcs(template.FuncMap{
+	"minifiedCSS": func() template.CSS { return template.CSS(minifiedCSS) },
+}).Parse(rawHTMLTemplate))
+
+// Generate the CSP header value once since it's effectively constant:
+var cspValue = strings.Join([]string{
+	`default-src 'none'`,
+	`style-src '` + cspHash(minifiedCSS) + `'`,
+	`frame-ancestors 'none'`,
+}, "; ")
+
+// PageData is the data used to render the Template().
+type PageData struct {
+	// FormAction is the URL of the login endpoint, to which the username and password are submitted.
+	FormAction string
+
+	// State is the encoded upstream state param, which is submitted along with the username and password.
+	State string
+
+	// IdentityProviderName is the name of the upstream identity provider, which is shown to the end user.
+	IdentityProviderName string
+
+	// Username is the initial value of the username input, e.g. from a previous attempt to log in.
+	Username string
+
+	// ErrorMessage explains why a previous attempt to log in was not successful, if any.
+	ErrorMessage string
+}
+
+func mustMinify(s string, err error) string {
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func cspHash(s string) string {
+	hashBytes := sha256.Sum256([]byte(s))
+	return "sha256-" + base64.StdEncoding.EncodeToString(hashBytes[:])
+}
+
+// ContentSecurityPolicy returns the Content-Security-Policy header value to make the Template() operate correctly.
+func ContentSecurityPolicy() string { return cspValue }
+
+// Template returns the html/template.Template for rendering the login page.
+// It should be executed with a PageData.
+func Template() *template.Template { return parsedHTMLTemplate }
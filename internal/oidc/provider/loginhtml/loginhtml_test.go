@@ -0,0 +1,67 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package loginhtml
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplate(t *testing.T) {
+	t.Run("first attempt", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Template().Execute(&buf, &PageData{
+			FormAction:           "https://issuer.example.com/login",
+			State:                "some-encoded-state",
+			IdentityProviderName: "<some-ldap-idp>",
+		}))
+		out := buf.String()
+
+		require.Contains(t, out, "<style>"+minifiedCSS+"</style>")
+		// The name of the IDP must be escaped.
+		require.Contains(t, out, "<h1>Log in to &lt;some-ldap-idp&gt;</h1>")
+		require.Contains(t, out, `<form method="post" action="https://issuer.example.com/login">`)
+		require.Contains(t, out, `<input type="hidden" name="state" value="some-encoded-state">`)
+		require.Contains(t, out, `name="username" value="" autocomplete="username" autocapitalize="none" autofocus required>`)
+		require.Contains(t, out, `name="password" autocomplete="current-password" required>`)
+		require.NotContains(t, out, `class="error"`)
+		require.NotContains(t, out, "<script")
+	})
+
+	t.Run("after a failed attempt", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Template().Execute(&buf, &PageData{
+			FormAction:           "https://issuer.example.com/login",
+			State:                "some-encoded-state",
+			IdentityProviderName: "some-ldap-idp",
+			Username:             "<some-user>",
+			ErrorMessage:         "Incorrect username or password.",
+		}))
+		out := buf.String()
+
+		require.Contains(t, out, `<p class="error">Incorrect username or password.</p>`)
+		// The username must be escaped, since it was submitted by the end user.
+		require.Contains(t, out, `name="username" value="&lt;some-user&gt;" autocomplete="username" autocapitalize="none" required>`)
+		require.Contains(t, out, `name="password" autocomplete="current-password" autofocus required>`)
+		require.NotContains(t, out, "<script")
+	})
+}
+
+func TestContentSecurityPolicyHashes(t *testing.T) {
+	require.Equal(t,
+		`default-src 'none'; style-src '`+cspHash(minifiedCSS)+`'; frame-ancestors 'none'`,
+		ContentSecurityPolicy(),
+	)
+}
+
+func TestHelpers(t *testing.T) {
+	require.Equal(t, "test", mustMinify("test", nil))
+	require.PanicsWithError(t, "some error", func() { mustMinify("", fmt.Errorf("some error")) })
+
+	// Example test vector from https://content-security-policy.com/hash/.
+	require.Equal(t, "sha256-RFWPLDbv2BY+rCkDzsE+0fr8ylGr2R2faWMhq4lfEQc=", cspHash("doSomething();"))
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package manager
+
+import (
+	"go.pinniped.dev/internal/idtransform"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/idpdiscovery"
+	"go.pinniped.dev/internal/oidc/provider"
+)
+
+// federationDomainIdentityProvidersLister wraps the in-memory cache of all upstream IDPs and only lists the ones
+// which are allowed to be used by a particular FederationDomain.
+type federationDomainIdentityProvidersLister struct {
+	federationDomain *provider.FederationDomainIssuer
+	upstreamIDPs     oidc.AllUpstreamIdentityProvidersLister
+}
+
+var _ oidc.UpstreamIdentityProvidersLister = (*federationDomainIdentityProvidersLister)(nil)
+
+func newFederationDomainIdentityProvidersLister(
+	federationDomain *provider.FederationDomainIssuer,
+	upstreamIDPs oidc.AllUpstreamIdentityProvidersLister,
+) *federationDomainIdentityProvidersLister {
+	return &federationDomainIdentityProvidersLister{
+		federationDomain: federationDomain,
+		upstreamIDPs:     upstreamIDPs,
+	}
+}
+
+func (l *federationDomainIdentityProvidersLister) GetOIDCIdentityProviders() []provider.UpstreamOIDCIdentityProviderI {
+	allowed := []provider.UpstreamOIDCIdentityProviderI{}
+	// The cache of IDPs could change at any time, so always filter the current list.
+	for _, idp := range l.upstreamIDPs.GetOIDCIdentityProviders() {
+		if l.federationDomain.AllowsIdentityProvider(idp.GetName(), idpdiscovery.IDPTypeOIDC) {
+			allowed = append(allowed, idp)
+		}
+	}
+	return allowed
+}
+
+func (l *federationDomainIdentityProvidersLister) GetLDAPIdentityProviders() []provider.UpstreamLDAPIdentityProviderI {
+	allowed := []provider.UpstreamLDAPIdentityProviderI{}
+	// The cache of IDPs could change at any time, so always filter the current list.
+	for _, idp := range l.upstreamIDPs.GetLDAPIdentityProviders() {
+		if l.federationDomain.AllowsIdentityProvider(idp.GetName(), idpdiscovery.IDPTypeLDAP) {
+			allowed = append(allowed, idp)
+		}
+	}
+	return allowed
+}
+
+func (l *federationDomainIdentityProvidersLister) GetActiveDirectoryIdentityProviders() []provider.UpstreamLDAPIdentityProviderI {
+	allowed := []provider.UpstreamLDAPIdentityProviderI{}
+	// The cache of IDPs could change at any time, so always filter the current list.
+	for _, idp := range l.upstreamIDPs.GetActiveDirectoryIdentityProviders() {
+		if l.federationDomain.AllowsIdentityProvider(idp.GetName(), idpdiscovery.IDPTypeActiveDirectory) {
+			allowed = append(allowed, idp)
+		}
+	}
+	return allowed
+}
+
+func (l *federationDomainIdentityProvidersLister) GetGitHubIdentityProviders() []provider.UpstreamOIDCIdentityProviderI {
+	allowed := []provider.UpstreamOIDCIdentityProviderI{}
+	// The cache of IDPs could change at any time, so always filter the current list.
+	for _, idp := range l.upstreamIDPs.GetGitHubIdentityProviders() {
+		if l.federationDomain.AllowsIdentityProvider(idp.GetName(), idpdiscovery.IDPTypeGitHub) {
+			allowed = append(allowed, idp)
+		}
+	}
+	return allowed
+}
+
+func (l *federationDomainIdentityProvidersLister) GetSAMLIdentityProviders() []provider.UpstreamSAMLIdentityProviderI {
+	allowed := []provider.UpstreamSAMLIdentityProviderI{}
+	// The cache of IDPs could change at any time, so always filter the current list.
+	for _, idp := range l.upstreamIDPs.GetSAMLIdentityProviders() {
+		if l.federationDomain.AllowsIdentityProvider(idp.GetName(), idpdiscovery.IDPTypeSAML) {
+			allowed = append(allowed, idp)
+		}
+	}
+	return allowed
+}
+
+func (l *federationDomainIdentityProvidersLister) GetIdentityTransforms(idpName string, idpType string) *idtransform.Pipeline {
+	return l.federationDomain.IdentityTransforms(idpName, idpType)
+}
@@ -0,0 +1,156 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.pinniped.dev/internal/idtransform"
+	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/testutil/oidctestutil"
+)
+
+func TestFederationDomainIdentityProvidersLister(t *testing.T) {
+	oidcIDP1 := &oidctestutil.TestUpstreamOIDCIdentityProvider{Name: "oidc-idp-1"}
+	oidcIDP2 := &oidctestutil.TestUpstreamOIDCIdentityProvider{Name: "oidc-idp-2"}
+	ldapIDP1 := &oidctestutil.TestUpstreamLDAPIdentityProvider{Name: "ldap-idp-1"}
+	ldapIDPWithSameNameAsOIDC := &oidctestutil.TestUpstreamLDAPIdentityProvider{Name: "oidc-idp-1"}
+	adIDP1 := &oidctestutil.TestUpstreamLDAPIdentityProvider{Name: "ad-idp-1"}
+	adIDPWithSameNameAsLDAP := &oidctestutil.TestUpstreamLDAPIdentityProvider{Name: "ldap-idp-1"}
+	gitHubIDP1 := &oidctestutil.TestUpstreamOIDCIdentityProvider{Name: "github-idp-1"}
+	gitHubIDPWithSameNameAsOIDC := &oidctestutil.TestUpstreamOIDCIdentityProvider{Name: "oidc-idp-1"}
+	samlIDP1 := &oidctestutil.TestUpstreamSAMLIdentityProvider{Name: "saml-idp-1"}
+	samlIDPWithSameNameAsOIDC := &oidctestutil.TestUpstreamSAMLIdentityProvider{Name: "oidc-idp-1"}
+
+	tests := []struct {
+		name              string
+		identityProviders []provider.FederationDomainIdentityProvider
+		wantOIDC          []provider.UpstreamOIDCIdentityProviderI
+		wantLDAP          []provider.UpstreamLDAPIdentityProviderI
+		wantAD            []provider.UpstreamLDAPIdentityProviderI
+		wantGitHub        []provider.UpstreamOIDCIdentityProviderI
+		wantSAML          []provider.UpstreamSAMLIdentityProviderI
+	}{
+		{
+			name:       "no identity providers listed allows all of them",
+			wantOIDC:   []provider.UpstreamOIDCIdentityProviderI{oidcIDP1, oidcIDP2},
+			wantLDAP:   []provider.UpstreamLDAPIdentityProviderI{ldapIDP1, ldapIDPWithSameNameAsOIDC},
+			wantAD:     []provider.UpstreamLDAPIdentityProviderI{adIDP1, adIDPWithSameNameAsLDAP},
+			wantGitHub: []provider.UpstreamOIDCIdentityProviderI{gitHubIDP1, gitHubIDPWithSameNameAsOIDC},
+			wantSAML:   []provider.UpstreamSAMLIdentityProviderI{samlIDP1, samlIDPWithSameNameAsOIDC},
+		},
+		{
+			name: "only the listed identity providers are allowed",
+			identityProviders: []provider.FederationDomainIdentityProvider{
+				{Name: "oidc-idp-1", Type: "oidc"},
+				{Name: "ldap-idp-1", Type: "ldap"},
+				{Name: "ad-idp-1", Type: "activedirectory"},
+				{Name: "github-idp-1", Type: "github"},
+				{Name: "saml-idp-1", Type: "saml"},
+				{Name: "does-not-exist", Type: "ldap"},
+			},
+			wantOIDC:   []provider.UpstreamOIDCIdentityProviderI{oidcIDP1},
+			wantLDAP:   []provider.UpstreamLDAPIdentityProviderI{ldapIDP1},
+			wantAD:     []provider.UpstreamLDAPIdentityProviderI{adIDP1},
+			wantGitHub: []provider.UpstreamOIDCIdentityProviderI{gitHubIDP1},
+			wantSAML:   []provider.UpstreamSAMLIdentityProviderI{samlIDP1},
+		},
+		{
+			name: "the type of the identity provider must match",
+			identityProviders: []provider.FederationDomainIdentityProvider{
+				{Name: "oidc-idp-1", Type: "ldap"},
+			},
+			wantOIDC:   []provider.UpstreamOIDCIdentityProviderI{},
+			wantLDAP:   []provider.UpstreamLDAPIdentityProviderI{ldapIDPWithSameNameAsOIDC},
+			wantAD:     []provider.UpstreamLDAPIdentityProviderI{},
+			wantGitHub: []provider.UpstreamOIDCIdentityProviderI{},
+			wantSAML:   []provider.UpstreamSAMLIdentityProviderI{},
+		},
+		{
+			name: "an Active Directory identity provider is not allowed by an entry of type ldap",
+			identityProviders: []provider.FederationDomainIdentityProvider{
+				{Name: "ldap-idp-1", Type: "activedirectory"},
+			},
+			wantOIDC:   []provider.UpstreamOIDCIdentityProviderI{},
+			wantLDAP:   []provider.UpstreamLDAPIdentityProviderI{},
+			wantAD:     []provider.UpstreamLDAPIdentityProviderI{adIDPWithSameNameAsLDAP},
+			wantGitHub: []provider.UpstreamOIDCIdentityProviderI{},
+			wantSAML:   []provider.UpstreamSAMLIdentityProviderI{},
+		},
+		{
+			name: "a GitHub identity provider is not allowed by an entry of type oidc",
+			identityProviders: []provider.FederationDomainIdentityProvider{
+				{Name: "oidc-idp-1", Type: "github"},
+			},
+			wantOIDC:   []provider.UpstreamOIDCIdentityProviderI{},
+			wantLDAP:   []provider.UpstreamLDAPIdentityProviderI{},
+			wantAD:     []provider.UpstreamLDAPIdentityProviderI{},
+			wantGitHub: []provider.UpstreamOIDCIdentityProviderI{gitHubIDPWithSameNameAsOIDC},
+			wantSAML:   []provider.UpstreamSAMLIdentityProviderI{},
+		},
+		{
+			name: "a SAML identity provider is not allowed by an entry of type oidc",
+			identityProviders: []provider.FederationDomainIdentityProvider{
+				{Name: "oidc-idp-1", Type: "saml"},
+			},
+			wantOIDC:   []provider.UpstreamOIDCIdentityProviderI{},
+			wantLDAP:   []provider.UpstreamLDAPIdentityProviderI{},
+			wantAD:     []provider.UpstreamLDAPIdentityProviderI{},
+			wantGitHub: []provider.UpstreamOIDCIdentityProviderI{},
+			wantSAML:   []provider.UpstreamSAMLIdentityProviderI{samlIDPWithSameNameAsOIDC},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			upstreamIDPs := oidctestutil.NewUpstreamIDPListerBuilder().
+				WithOIDC(oidcIDP1, oidcIDP2).
+				WithLDAP(ldapIDP1, ldapIDPWithSameNameAsOIDC).
+				WithActiveDirectory(adIDP1, adIDPWithSameNameAsLDAP).
+				WithGitHub(gitHubIDP1, gitHubIDPWithSameNameAsOIDC).
+				WithSAML(samlIDP1, samlIDPWithSameNameAsOIDC).
+				Build()
+
+			federationDomain, err := provider.NewFederationDomainIssuer("https://issuer.com", tt.identityProviders, nil)
+			require.NoError(t, err)
+
+			subject := newFederationDomainIdentityProvidersLister(federationDomain, upstreamIDPs)
+			require.Equal(t, tt.wantOIDC, subject.GetOIDCIdentityProviders())
+			require.Equal(t, tt.wantLDAP, subject.GetLDAPIdentityProviders())
+			require.Equal(t, tt.wantAD, subject.GetActiveDirectoryIdentityProviders())
+			require.Equal(t, tt.wantGitHub, subject.GetGitHubIdentityProviders())
+			require.Equal(t, tt.wantSAML, subject.GetSAMLIdentityProviders())
+
+			// Changes to the underlying cache should be reflected immediately.
+			upstreamIDPs.SetOIDCIdentityProviders([]provider.UpstreamOIDCIdentityProviderI{})
+			upstreamIDPs.SetLDAPIdentityProviders([]provider.UpstreamLDAPIdentityProviderI{})
+			upstreamIDPs.SetActiveDirectoryIdentityProviders([]provider.UpstreamLDAPIdentityProviderI{})
+			upstreamIDPs.SetGitHubIdentityProviders([]provider.UpstreamOIDCIdentityProviderI{})
+			upstreamIDPs.SetSAMLIdentityProviders([]provider.UpstreamSAMLIdentityProviderI{})
+			require.Empty(t, subject.GetOIDCIdentityProviders())
+			require.Empty(t, subject.GetLDAPIdentityProviders())
+			require.Empty(t, subject.GetActiveDirectoryIdentityProviders())
+			require.Empty(t, subject.GetGitHubIdentityProviders())
+			require.Empty(t, subject.GetSAMLIdentityProviders())
+		})
+	}
+}
+
+func TestFederationDomainIdentityProvidersListerGetIdentityTransforms(t *testing.T) {
+	transforms, err := idtransform.NewPipeline([]idtransform.Transform{{Type: idtransform.TypePrefix, Prefix: "oidc:"}})
+	require.NoError(t, err)
+
+	federationDomain, err := provider.NewFederationDomainIssuer("https://issuer.com", []provider.FederationDomainIdentityProvider{
+		{Name: "some-idp", Type: "oidc", Transforms: transforms},
+		{Name: "some-idp", Type: "ldap"},
+	}, nil)
+	require.NoError(t, err)
+
+	subject := newFederationDomainIdentityProvidersLister(federationDomain, oidctestutil.NewUpstreamIDPListerBuilder().Build())
+	require.Same(t, transforms, subject.GetIdentityTransforms("some-idp", "oidc"))
+	require.Nil(t, subject.GetIdentityTransforms("some-idp", "ldap"))
+	require.Nil(t, subject.GetIdentityTransforms("other-idp", "oidc"))
+}
@@ -7,21 +7,30 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
-	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"github.com/ory/fosite"
 
+	"go.pinniped.dev/internal/crud"
 	"go.pinniped.dev/internal/oidc"
 	"go.pinniped.dev/internal/oidc/auth"
 	"go.pinniped.dev/internal/oidc/callback"
+	"go.pinniped.dev/internal/oidc/clientregistry"
 	"go.pinniped.dev/internal/oidc/csrftoken"
+	"go.pinniped.dev/internal/oidc/deviceauthorization"
+	"go.pinniped.dev/internal/oidc/deviceverification"
 	"go.pinniped.dev/internal/oidc/discovery"
 	"go.pinniped.dev/internal/oidc/dynamiccodec"
 	"go.pinniped.dev/internal/oidc/idpdiscovery"
+	"go.pinniped.dev/internal/oidc/introspection"
 	"go.pinniped.dev/internal/oidc/jwks"
 	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/oidc/revocation"
 	"go.pinniped.dev/internal/oidc/token"
+	"go.pinniped.dev/internal/oidc/userinfo"
 	"go.pinniped.dev/internal/plog"
 	"go.pinniped.dev/internal/secret"
+	"go.pinniped.dev/internal/tracing"
 	"go.pinniped.dev/pkg/oidcclient/nonce"
 	"go.pinniped.dev/pkg/oidcclient/pkce"
 )
@@ -32,24 +41,28 @@ import (
 type Manager struct {
 	mu                  sync.RWMutex
 	providers           []*provider.FederationDomainIssuer
-	providerHandlers    map[string]http.Handler              // map of all routes for all providers
-	nextHandler         http.Handler                         // the next handler in a chain, called when this manager didn't know how to handle a request
-	dynamicJWKSProvider jwks.DynamicJWKSProvider             // in-memory cache of per-issuer JWKS data
-	upstreamIDPs        oidc.UpstreamIdentityProvidersLister // in-memory cache of upstream IDPs
-	secretCache         *secret.Cache                        // in-memory cache of cryptographic material
-	secretsClient       corev1client.SecretInterface
+	providerHandlers    map[string]http.Handler                 // map of all routes for all providers
+	nextHandler         http.Handler                            // the next handler in a chain, called when this manager didn't know how to handle a request
+	dynamicJWKSProvider jwks.DynamicJWKSProvider                // in-memory cache of per-issuer JWKS data
+	upstreamIDPs        oidc.AllUpstreamIdentityProvidersLister // in-memory cache of upstream IDPs
+	secretCache         *secret.Cache                           // in-memory cache of cryptographic material
+	storageFactory      crud.StorageFactory                     // storage of the sessions of all providers
+	clientManager       *clientregistry.ClientManager           // in-memory cache of OIDC clients
 }
 
 // NewManager returns an empty Manager.
 // nextHandler will be invoked for any requests that could not be handled by this manager's providers.
 // dynamicJWKSProvider will be used as an in-memory cache for per-issuer JWKS data.
 // upstreamIDPs will be used as an in-memory cache of currently configured upstream IDPs.
+// storageFactory will be used to store the sessions of the providers.
+// clientManager will be used to look up the OIDC clients which may use the providers.
 func NewManager(
 	nextHandler http.Handler,
 	dynamicJWKSProvider jwks.DynamicJWKSProvider,
-	upstreamIDPs oidc.UpstreamIdentityProvidersLister,
+	upstreamIDPs oidc.AllUpstreamIdentityProvidersLister,
 	secretCache *secret.Cache,
-	secretsClient corev1client.SecretInterface,
+	storageFactory crud.StorageFactory,
+	clientManager *clientregistry.ClientManager,
 ) *Manager {
 	return &Manager{
 		providerHandlers:    make(map[string]http.Handler),
@@ -57,7 +70,8 @@ func NewManager(
 		dynamicJWKSProvider: dynamicJWKSProvider,
 		upstreamIDPs:        upstreamIDPs,
 		secretCache:         secretCache,
-		secretsClient:       secretsClient,
+		storageFactory:      storageFactory,
+		clientManager:       clientManager,
 	}
 }
 
@@ -86,53 +100,110 @@ func (m *Manager) SetProviders(federationDomains ...*provider.FederationDomainIs
 		issuer := incomingProvider.Issuer()
 		issuerHostWithPath := strings.ToLower(incomingProvider.IssuerHost()) + "/" + incomingProvider.IssuerPath()
 
-		tokenHMACKeyGetter := wrapGetter(incomingProvider.Issuer(), m.secretCache.GetTokenHMACKey)
+		tokenHMACKeysGetter := wrapGetter(incomingProvider.Issuer(), m.secretCache.GetTokenHMACKeys)
 
 		timeoutsConfiguration := oidc.DefaultOIDCTimeoutsConfiguration()
+		if tokenLifetimes := incomingProvider.TokenLifetimes(); tokenLifetimes != nil {
+			timeoutsConfiguration = oidc.OIDCTimeoutsConfigurationWithTokenLifespans(tokenLifetimes.AccessToken, tokenLifetimes.RefreshToken)
+		}
 
 		// Use NullStorage for the authorize endpoint because we do not actually want to store anything until
 		// the upstream callback endpoint is called later.
-		oauthHelperWithNullStorage := oidc.FositeOauth2Helper(oidc.NullStorage{}, issuer, tokenHMACKeyGetter, nil, timeoutsConfiguration)
+		oauthHelperWithNullStorage := oidc.FositeOauth2Helper(oidc.NullStorage{ClientManager: m.clientManager}, issuer, tokenHMACKeysGetter, nil, timeoutsConfiguration)
 
 		// For all the other endpoints, make another oauth helper with exactly the same settings except use real storage.
-		oauthHelperWithKubeStorage := oidc.FositeOauth2Helper(oidc.NewKubeStorage(m.secretsClient, timeoutsConfiguration), issuer, tokenHMACKeyGetter, m.dynamicJWKSProvider, timeoutsConfiguration)
+		kubeStorage := oidc.NewKubeStorageWithStorageFactory(m.storageFactory, m.clientManager, timeoutsConfiguration)
+		oauthHelperWithKubeStorage := oidc.FositeOauth2Helper(kubeStorage, issuer, tokenHMACKeysGetter, m.dynamicJWKSProvider, timeoutsConfiguration)
 
-		var upstreamStateEncoder = dynamiccodec.New(
+		var upstreamStateEncoder = dynamiccodec.NewWithPreviousKeys(
 			timeoutsConfiguration.UpstreamStateParamLifespan,
-			wrapGetter(incomingProvider.Issuer(), m.secretCache.GetStateEncoderHashKey),
-			wrapGetter(incomingProvider.Issuer(), m.secretCache.GetStateEncoderBlockKey),
+			wrapGetter(incomingProvider.Issuer(), m.secretCache.GetStateEncoderHashKeys),
+			wrapGetter(incomingProvider.Issuer(), m.secretCache.GetStateEncoderBlockKeys),
 		)
 
+		// Each FederationDomain may only use the upstream IDPs which it allows.
+		upstreamIDPs := newFederationDomainIdentityProvidersLister(incomingProvider, m.upstreamIDPs)
+
 		m.providerHandlers[(issuerHostWithPath + oidc.WellKnownEndpointPath)] = discovery.NewHandler(issuer)
 
 		m.providerHandlers[(issuerHostWithPath + oidc.JWKSEndpointPath)] = jwks.NewHandler(issuer, m.dynamicJWKSProvider)
 
-		m.providerHandlers[(issuerHostWithPath + oidc.PinnipedIDPsPathV1Alpha1)] = idpdiscovery.NewHandler(m.upstreamIDPs)
+		m.providerHandlers[(issuerHostWithPath + oidc.PinnipedIDPsPathV1Alpha1)] = idpdiscovery.NewHandler(upstreamIDPs)
 
-		m.providerHandlers[(issuerHostWithPath + oidc.AuthorizationEndpointPath)] = auth.NewHandler(
+		m.providerHandlers[(issuerHostWithPath + oidc.AuthorizationEndpointPath)] = tracing.Handler("supervisor authorize", auth.NewHandler(
 			issuer,
-			m.upstreamIDPs,
+			upstreamIDPs,
 			oauthHelperWithNullStorage,
 			oauthHelperWithKubeStorage,
+			kubeStorage,
 			csrftoken.Generate,
 			pkce.Generate,
 			nonce.Generate,
 			upstreamStateEncoder,
 			csrfCookieEncoder,
-		)
+		))
 
-		m.providerHandlers[(issuerHostWithPath + oidc.CallbackEndpointPath)] = callback.NewHandler(
-			m.upstreamIDPs,
+		m.providerHandlers[(issuerHostWithPath + oidc.CallbackEndpointPath)] = tracing.Handler("supervisor callback", callback.NewHandler(
+			issuer,
+			upstreamIDPs,
 			oauthHelperWithKubeStorage,
+			kubeStorage,
 			upstreamStateEncoder,
 			csrfCookieEncoder,
 			issuer+oidc.CallbackEndpointPath,
+		))
+
+		m.providerHandlers[(issuerHostWithPath + oidc.SAMLACSEndpointPath)] = tracing.Handler("supervisor saml acs", callback.NewSAMLACSHandler(
+			issuer,
+			upstreamIDPs,
+			oauthHelperWithKubeStorage,
+			kubeStorage,
+			upstreamStateEncoder,
+			csrfCookieEncoder,
+		))
+
+		m.providerHandlers[(issuerHostWithPath + oidc.LoginEndpointPath)] = tracing.Handler("supervisor login", callback.NewLoginHandler(
+			issuer,
+			upstreamIDPs,
+			oauthHelperWithKubeStorage,
+			kubeStorage,
+			upstreamStateEncoder,
+			csrfCookieEncoder,
+		))
+
+		m.providerHandlers[(issuerHostWithPath + oidc.TokenEndpointPath)] = tracing.Handler("supervisor token", token.NewHandler(
+			issuer,
+			upstreamIDPs,
+			oauthHelperWithKubeStorage,
+		))
+
+		m.providerHandlers[(issuerHostWithPath + oidc.RevocationEndpointPath)] = revocation.NewHandler(
+			oauthHelperWithKubeStorage,
+		)
+
+		m.providerHandlers[(issuerHostWithPath + oidc.IntrospectionEndpointPath)] = introspection.NewHandler(
+			oauthHelperWithKubeStorage,
 		)
 
-		m.providerHandlers[(issuerHostWithPath + oidc.TokenEndpointPath)] = token.NewHandler(
+		m.providerHandlers[(issuerHostWithPath + oidc.UserInfoEndpointPath)] = userinfo.NewHandler(
 			oauthHelperWithKubeStorage,
 		)
 
+		m.providerHandlers[(issuerHostWithPath + oidc.DeviceAuthorizationEndpointPath)] = deviceauthorization.NewHandler(
+			issuer,
+			oauthHelperWithKubeStorage.(*fosite.Fosite),
+			kubeStorage,
+			timeoutsConfiguration.DeviceCodeLifespan,
+			deviceauthorization.GenerateDeviceCode,
+			deviceauthorization.GenerateUserCode,
+		)
+
+		m.providerHandlers[(issuerHostWithPath + oidc.DeviceVerificationEndpointPath)] = deviceverification.NewHandler(
+			issuer,
+			kubeStorage,
+			time.Now,
+		)
+
 		plog.Debug("oidc provider manager added or updated issuer", "issuer", issuer)
 	}
 }
@@ -162,8 +233,8 @@ func (m *Manager) findHandler(req *http.Request) http.Handler {
 	return m.providerHandlers[strings.ToLower(req.Host)+"/"+req.URL.Path]
 }
 
-func wrapGetter(issuer string, getter func(string) []byte) func() []byte {
-	return func() []byte {
+func wrapGetter(issuer string, getter func(string) [][]byte) func() [][]byte {
+	return func() [][]byte {
 		return getter(issuer)
 	}
 }
@@ -14,6 +14,7 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"go.pinniped.dev/internal/secret"
 
@@ -22,6 +23,7 @@ import (
 	"gopkg.in/square/go-jose.v2"
 	"k8s.io/client-go/kubernetes/fake"
 
+	"go.pinniped.dev/internal/crud"
 	"go.pinniped.dev/internal/here"
 	"go.pinniped.dev/internal/oidc"
 	"go.pinniped.dev/internal/oidc/discovery"
@@ -175,7 +177,7 @@ func TestManager(t *testing.T) {
 			return actualLocationQueryParams.Get("code")
 		}
 
-		requireTokenRequestToBeHandled := func(requestIssuer, authCode string, jwks *jose.JSONWebKeySet, jwkIssuer string) {
+		requireTokenRequestToBeHandled := func(requestIssuer, authCode string, jwks *jose.JSONWebKeySet, jwkIssuer string, wantAccessTokenLifetime time.Duration) {
 			recorder := httptest.NewRecorder()
 
 			numberOfKubeActionsBeforeThisRequest := len(kubeClient.Actions())
@@ -197,6 +199,7 @@ func TestManager(t *testing.T) {
 			r.NoError(json.Unmarshal(recorder.Body.Bytes(), &body))
 			r.Contains(body, "id_token")
 			r.Contains(body, "access_token")
+			r.InDelta(wantAccessTokenLifetime.Seconds(), body["expires_in"], 1)
 
 			// Validate ID token is signed by the correct JWK to make sure we wired the token endpoint
 			// signing key correctly.
@@ -233,6 +236,62 @@ func TestManager(t *testing.T) {
 			return &parsedJWKSResult
 		}
 
+		requireRevocationRequestToBeHandled := func(requestIssuer string) {
+			recorder := httptest.NewRecorder()
+
+			revocationRequestBody := url.Values{
+				"token":     []string{"some-unknown-token"},
+				"client_id": []string{downstreamClientID},
+			}.Encode()
+			subject.ServeHTTP(recorder, newPostRequest(requestIssuer+oidc.RevocationEndpointPath, revocationRequestBody))
+
+			r.False(fallbackHandlerWasCalled)
+
+			// Minimal check to ensure that the right endpoint was called. Unknown tokens are not an error for revocation.
+			r.Equal(http.StatusOK, recorder.Code)
+		}
+
+		requireIntrospectionRequestToBeHandled := func(requestIssuer string) {
+			recorder := httptest.NewRecorder()
+
+			introspectionRequestBody := url.Values{"token": []string{"some-unknown-token"}}.Encode()
+			subject.ServeHTTP(recorder, newPostRequest(requestIssuer+oidc.IntrospectionEndpointPath, introspectionRequestBody))
+
+			r.False(fallbackHandlerWasCalled)
+
+			// Minimal check to ensure that the right endpoint was called. The request did not authenticate a client.
+			r.Equal(http.StatusUnauthorized, recorder.Code)
+			r.Contains(recorder.Body.String(), "request_unauthorized")
+		}
+
+		requireUserInfoRequestToBeHandled := func(requestIssuer string) {
+			recorder := httptest.NewRecorder()
+
+			request := newGetRequest(requestIssuer + oidc.UserInfoEndpointPath)
+			request.Header.Set("Authorization", "Bearer some-unknown-token")
+			subject.ServeHTTP(recorder, request)
+
+			r.False(fallbackHandlerWasCalled)
+
+			// Minimal check to ensure that the right endpoint was called. The access token is not valid.
+			r.Equal(http.StatusUnauthorized, recorder.Code)
+			r.Contains(recorder.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+		}
+
+		requireDeviceVerificationRequestToBeHandled := func(requestIssuer, requestURLSuffix, expectedFormAction string) {
+			recorder := httptest.NewRecorder()
+
+			subject.ServeHTTP(recorder, newGetRequest(requestIssuer+oidc.DeviceVerificationEndpointPath+requestURLSuffix))
+
+			r.False(fallbackHandlerWasCalled)
+
+			// Minimal check to ensure that the right device verification endpoint was called
+			r.Equal(http.StatusOK, recorder.Code)
+			responseBody, err := ioutil.ReadAll(recorder.Body)
+			r.NoError(err)
+			r.Contains(string(responseBody), expectedFormAction)
+		}
+
 		it.Before(func() {
 			r = require.New(t)
 			nextHandler = func(http.ResponseWriter, *http.Request) {
@@ -267,15 +326,15 @@ func TestManager(t *testing.T) {
 			cache := secret.Cache{}
 			cache.SetCSRFCookieEncoderHashKey([]byte("fake-csrf-hash-secret"))
 
-			cache.SetTokenHMACKey(issuer1, []byte("some secret 1 - must have at least 32 bytes"))
-			cache.SetStateEncoderHashKey(issuer1, []byte("some-state-encoder-hash-key-1"))
-			cache.SetStateEncoderBlockKey(issuer1, []byte("16-bytes-STATE01"))
+			cache.SetTokenHMACKeys(issuer1, [][]byte{[]byte("some secret 1 - must have at least 32 bytes")})
+			cache.SetStateEncoderHashKeys(issuer1, [][]byte{[]byte("some-state-encoder-hash-key-1")})
+			cache.SetStateEncoderBlockKeys(issuer1, [][]byte{[]byte("16-bytes-STATE01")})
 
-			cache.SetTokenHMACKey(issuer2, []byte("some secret 2 - must have at least 32 bytes"))
-			cache.SetStateEncoderHashKey(issuer2, []byte("some-state-encoder-hash-key-2"))
-			cache.SetStateEncoderBlockKey(issuer2, []byte("16-bytes-STATE02"))
+			cache.SetTokenHMACKeys(issuer2, [][]byte{[]byte("some secret 2 - must have at least 32 bytes")})
+			cache.SetStateEncoderHashKeys(issuer2, [][]byte{[]byte("some-state-encoder-hash-key-2")})
+			cache.SetStateEncoderBlockKeys(issuer2, [][]byte{[]byte("16-bytes-STATE02")})
 
-			subject = NewManager(nextHandler, dynamicJWKSProvider, idpLister, &cache, secretsClient)
+			subject = NewManager(nextHandler, dynamicJWKSProvider, idpLister, &cache, crud.SecretsStorageFactory(secretsClient, time.Now), oidctestutil.NewClientManager("some-namespace"))
 		})
 
 		when("given no providers via SetProviders()", func() {
@@ -304,7 +363,7 @@ func TestManager(t *testing.T) {
 			return &k
 		}
 
-		requireRoutesMatchingRequestsToAppropriateProvider := func() {
+		requireRoutesMatchingRequestsToAppropriateProvider := func(issuer1AccessTokenLifetime, issuer2AccessTokenLifetime time.Duration) {
 			requireDiscoveryRequestToBeHandled(issuer1, "", issuer1)
 			requireDiscoveryRequestToBeHandled(issuer2, "", issuer2)
 			requireDiscoveryRequestToBeHandled(issuer2, "?some=query", issuer2)
@@ -323,6 +382,18 @@ func TestManager(t *testing.T) {
 			requirePinnipedIDPsDiscoveryRequestToBeHandled(issuer2DifferentCaseHostname, "", upstreamIDPName, upstreamIDPType)
 			requirePinnipedIDPsDiscoveryRequestToBeHandled(issuer2DifferentCaseHostname, "?some=query", upstreamIDPName, upstreamIDPType)
 
+			requireRevocationRequestToBeHandled(issuer1)
+			requireRevocationRequestToBeHandled(issuer2DifferentCaseHostname)
+
+			requireIntrospectionRequestToBeHandled(issuer1)
+			requireIntrospectionRequestToBeHandled(issuer2DifferentCaseHostname)
+
+			requireUserInfoRequestToBeHandled(issuer1)
+			requireUserInfoRequestToBeHandled(issuer2DifferentCaseHostname)
+
+			requireDeviceVerificationRequestToBeHandled(issuer1, "", issuer1+oidc.DeviceVerificationEndpointPath)
+			requireDeviceVerificationRequestToBeHandled(issuer2, "?user_code=ABCD-EFGH", issuer2+oidc.DeviceVerificationEndpointPath)
+
 			issuer1JWKS := requireJWKSRequestToBeHandled(issuer1, "", issuer1KeyID)
 			issuer2JWKS := requireJWKSRequestToBeHandled(issuer2, "", issuer2KeyID)
 			requireJWKSRequestToBeHandled(issuer2, "?some=query", issuer2KeyID)
@@ -368,19 +439,19 @@ func TestManager(t *testing.T) {
 			downstreamAuthCode3 := requireCallbackRequestToBeHandled(issuer1DifferentCaseHostname, callbackRequestParams1, csrfCookieValue1)
 			downstreamAuthCode4 := requireCallbackRequestToBeHandled(issuer2DifferentCaseHostname, callbackRequestParams2, csrfCookieValue2)
 
-			requireTokenRequestToBeHandled(issuer1, downstreamAuthCode1, issuer1JWKS, issuer1)
-			requireTokenRequestToBeHandled(issuer2, downstreamAuthCode2, issuer2JWKS, issuer2)
+			requireTokenRequestToBeHandled(issuer1, downstreamAuthCode1, issuer1JWKS, issuer1, issuer1AccessTokenLifetime)
+			requireTokenRequestToBeHandled(issuer2, downstreamAuthCode2, issuer2JWKS, issuer2, issuer2AccessTokenLifetime)
 
 			// Hostnames are case-insensitive, so test that we can handle that.
-			requireTokenRequestToBeHandled(issuer1DifferentCaseHostname, downstreamAuthCode3, issuer1JWKS, issuer1)
-			requireTokenRequestToBeHandled(issuer2DifferentCaseHostname, downstreamAuthCode4, issuer2JWKS, issuer2)
+			requireTokenRequestToBeHandled(issuer1DifferentCaseHostname, downstreamAuthCode3, issuer1JWKS, issuer1, issuer1AccessTokenLifetime)
+			requireTokenRequestToBeHandled(issuer2DifferentCaseHostname, downstreamAuthCode4, issuer2JWKS, issuer2, issuer2AccessTokenLifetime)
 		}
 
 		when("given some valid providers via SetProviders()", func() {
 			it.Before(func() {
-				p1, err := provider.NewFederationDomainIssuer(issuer1)
+				p1, err := provider.NewFederationDomainIssuer(issuer1, nil, nil)
 				r.NoError(err)
-				p2, err := provider.NewFederationDomainIssuer(issuer2)
+				p2, err := provider.NewFederationDomainIssuer(issuer2, nil, nil)
 				r.NoError(err)
 				subject.SetProviders(p1, p2)
 
@@ -415,15 +486,15 @@ func TestManager(t *testing.T) {
 			})
 
 			it("routes matching requests to the appropriate provider", func() {
-				requireRoutesMatchingRequestsToAppropriateProvider()
+				requireRoutesMatchingRequestsToAppropriateProvider(oidc.DefaultAccessTokenLifespan, oidc.DefaultAccessTokenLifespan)
 			})
 		})
 
 		when("given the same valid providers as arguments to SetProviders() in reverse order", func() {
 			it.Before(func() {
-				p1, err := provider.NewFederationDomainIssuer(issuer1)
+				p1, err := provider.NewFederationDomainIssuer(issuer1, nil, nil)
 				r.NoError(err)
-				p2, err := provider.NewFederationDomainIssuer(issuer2)
+				p2, err := provider.NewFederationDomainIssuer(issuer2, nil, nil)
 				r.NoError(err)
 				subject.SetProviders(p2, p1)
 
@@ -439,7 +510,31 @@ func TestManager(t *testing.T) {
 			})
 
 			it("still routes matching requests to the appropriate provider", func() {
-				requireRoutesMatchingRequestsToAppropriateProvider()
+				requireRoutesMatchingRequestsToAppropriateProvider(oidc.DefaultAccessTokenLifespan, oidc.DefaultAccessTokenLifespan)
+			})
+		})
+
+		when("given some valid providers with token lifetimes via SetProviders()", func() {
+			it.Before(func() {
+				p1, err := provider.NewFederationDomainIssuer(issuer1, nil, &provider.TokenLifetimes{AccessToken: time.Hour, RefreshToken: 24 * time.Hour})
+				r.NoError(err)
+				p2, err := provider.NewFederationDomainIssuer(issuer2, nil, nil)
+				r.NoError(err)
+				subject.SetProviders(p1, p2)
+
+				jwksMap := map[string]*jose.JSONWebKeySet{
+					issuer1: {Keys: []jose.JSONWebKey{*newTestJWK(issuer1KeyID)}},
+					issuer2: {Keys: []jose.JSONWebKey{*newTestJWK(issuer2KeyID)}},
+				}
+				activeJWK := map[string]*jose.JSONWebKey{
+					issuer1: newTestJWK(issuer1KeyID),
+					issuer2: newTestJWK(issuer2KeyID),
+				}
+				dynamicJWKSProvider.SetIssuerToJWKSMap(jwksMap, activeJWK)
+			})
+
+			it("issues tokens with the lifetimes of each provider", func() {
+				requireRoutesMatchingRequestsToAppropriateProvider(time.Hour, oidc.DefaultAccessTokenLifespan)
 			})
 		})
 	})
@@ -0,0 +1,7 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+window.onload = () => {
+    // Submit the form as soon as the page has loaded, so the end user does not need to press the button.
+    document.forms[0].submit();
+};
@@ -0,0 +1,67 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package samlposthtml defines the HTML template for the page which posts a SAML message to its destination
+// using the browser of the end user, as required by the SAML HTTP-POST binding.
+//nolint: gochecknoglobals // This package uses globals to ensure that all parsing and minifying happens at init.
+package samlposthtml
+
+import (
+	"crypto/sha256"
+	_ "embed" // Needed to trigger //go:embed directives below.
+	"encoding/base64"
+	"html/template"
+	"net/url"
+	"strings"
+
+	"github.com/tdewolff/minify/v2/minify"
+)
+
+var (
+	//go:embed saml_post.js
+	rawJS      string
+	minifiedJS = mustMinify(minify.JS(rawJS))
+
+	//go:embed saml_post.gohtml
+	rawHTMLTemplate string
+)
+
+// Parse the Go templated HTML and inject functions providing the minified inline JS.
+var parsedHTMLTemplate = template.Must(template.New("saml_post.gohtml").Funcs(template.FuncMap{
+	"minifiedJS": func() template.JS { return template.JS(minifiedJS) }, //nolint:gosec // This is 100% static input, not attacker-controlled.
+}).Parse(rawHTMLTemplate))
+
+// Generate the CSP header value once since it's effectively constant:
+var cspValue = strings.Join([]string{
+	`default-src 'none'`,
+	`script-src '` + cspHash(minifiedJS) + `'`,
+	`frame-ancestors 'none'`,
+}, "; ")
+
+// PageData is the data used to render the Template().
+type PageData struct {
+	// FormAction is the URL to which the form is posted.
+	FormAction string
+
+	// Parameters are the form values which are posted, e.g. SAMLRequest and RelayState.
+	Parameters url.Values
+}
+
+func mustMinify(s string, err error) string {
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func cspHash(s string) string {
+	hashBytes := sha256.Sum256([]byte(s))
+	return "sha256-" + base64.StdEncoding.EncodeToString(hashBytes[:])
+}
+
+// ContentSecurityPolicy returns the Content-Security-Policy header value to make the Template() operate correctly.
+func ContentSecurityPolicy() string { return cspValue }
+
+// Template returns the html/template.Template for rendering the page which posts a SAML message.
+// It should be executed with a PageData.
+func Template() *template.Template { return parsedHTMLTemplate }
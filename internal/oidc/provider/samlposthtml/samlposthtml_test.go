@@ -0,0 +1,49 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package samlposthtml
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplate(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Template().Execute(&buf, &PageData{
+		FormAction: "https://idp.example.com/sso?tenant=a&b",
+		Parameters: url.Values{
+			"SAMLRequest": {"PHNhbWxwOkF1dGhuUmVxdWVzdC8+"},
+			"RelayState":  {`"><script>`},
+		},
+	}))
+	out := buf.String()
+
+	require.Contains(t, out, "<script>"+minifiedJS+"</script>")
+	require.Contains(t, out, `<form method="post" action="https://idp.example.com/sso?tenant=a&amp;b">`)
+	// The parameters are rendered in the order of their names, and their values must be escaped.
+	require.Contains(t, out,
+		`<input type="hidden" name="RelayState" value="&#34;&gt;&lt;script&gt;">`+"\n    "+
+			`<input type="hidden" name="SAMLRequest" value="PHNhbWxwOkF1dGhuUmVxdWVzdC8&#43;">`,
+	)
+	require.Contains(t, out, `<button type="submit">Continue</button>`)
+}
+
+func TestContentSecurityPolicyHashes(t *testing.T) {
+	require.Equal(t,
+		`default-src 'none'; script-src '`+cspHash(minifiedJS)+`'; frame-ancestors 'none'`,
+		ContentSecurityPolicy(),
+	)
+}
+
+func TestHelpers(t *testing.T) {
+	require.Equal(t, "test", mustMinify("test", nil))
+	require.PanicsWithError(t, "some error", func() { mustMinify("", fmt.Errorf("some error")) })
+
+	// Example test vector from https://content-security-policy.com/hash/.
+	require.Equal(t, "sha256-RFWPLDbv2BY+rCkDzsE+0fr8ylGr2R2faWMhq4lfEQc=", cspHash("doSomething();"))
+}
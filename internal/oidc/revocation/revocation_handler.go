@@ -0,0 +1,39 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package revocation provides a handler for the OAuth 2.0 token revocation endpoint (RFC7009).
+package revocation
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ory/fosite"
+
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/plog"
+)
+
+// NewHandler returns an http.Handler which revokes downstream access and refresh tokens. Revoking either kind of token
+// also revokes all other tokens which were issued for the same downstream session, so the client will need to start
+// a new login to get new tokens.
+func NewHandler(oauthHelper fosite.OAuth2Provider) http.Handler {
+	return httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		err := oauthHelper.NewRevocationRequest(r.Context(), r)
+		if err != nil {
+			plog.Info("revocation request error", oidc.FositeErrorForLog(err)...)
+		}
+
+		// Per RFC7009, a token which was issued to another client must not be revoked, and the client must be told
+		// so. Fosite's revocation response would report success for this error, so it is written as an OAuth error.
+		if errors.Is(err, fosite.ErrUnauthorizedClient) {
+			oauthHelper.WriteAccessError(w, nil, err)
+			return nil
+		}
+
+		// Per RFC7009, this responds with success when the token was already invalid or was not found.
+		oauthHelper.WriteRevocationResponse(w, err)
+		return nil
+	})
+}
@@ -0,0 +1,170 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package revocation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/token/jwt"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/fositestorage/accesstoken"
+	"go.pinniped.dev/internal/fositestorage/refreshtoken"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/internal/testutil"
+	"go.pinniped.dev/internal/testutil/oidctestutil"
+)
+
+func TestRevocationHandler(t *testing.T) {
+	const (
+		downstreamIssuer = "https://my-downstream-issuer.com/some-path"
+		otherClientID    = "client.oauth.pinniped.dev-some-other-client"
+	)
+
+	hmacSecret := []byte("some secret - must have at least 32 bytes")
+
+	otherClient, otherClientSecret := oidctestutil.ConfidentialOIDCClient(t, "some-namespace", otherClientID)
+
+	tests := []struct {
+		name string
+		// body returns the request body, given the access token and refresh token which were issued to the CLI.
+		body   func(accessToken, refreshToken string) url.Values
+		method string
+		// useOtherClient authenticates the request as a confidential client, instead of as the CLI.
+		useOtherClient bool
+
+		wantStatus              int
+		wantErrorJSON           string
+		wantTokenSessionsStored int
+	}{
+		{
+			name: "revoking the refresh token revokes the whole session",
+			body: func(_, refreshToken string) url.Values {
+				return url.Values{"client_id": {clientregistry.PinnipedCLIClientID}, "token": {refreshToken}}
+			},
+			wantStatus:              http.StatusOK,
+			wantTokenSessionsStored: 0,
+		},
+		{
+			name: "revoking the access token revokes the whole session",
+			body: func(accessToken, _ string) url.Values {
+				return url.Values{"client_id": {clientregistry.PinnipedCLIClientID}, "token": {accessToken}, "token_type_hint": {"access_token"}}
+			},
+			wantStatus:              http.StatusOK,
+			wantTokenSessionsStored: 0,
+		},
+		{
+			name: "revoking an unknown token succeeds without revoking anything, as required by RFC7009",
+			body: func(_, _ string) url.Values {
+				return url.Values{"client_id": {clientregistry.PinnipedCLIClientID}, "token": {"some-unknown-token"}}
+			},
+			wantStatus:              http.StatusOK,
+			wantTokenSessionsStored: 1,
+		},
+		{
+			name: "a client cannot revoke tokens which were issued to a different client",
+			body: func(_, refreshToken string) url.Values {
+				return url.Values{"token": {refreshToken}}
+			},
+			useOtherClient:          true,
+			wantStatus:              http.StatusBadRequest,
+			wantErrorJSON:           "unauthorized_client",
+			wantTokenSessionsStored: 1,
+		},
+		{
+			name: "an unknown client cannot revoke tokens",
+			body: func(_, refreshToken string) url.Values {
+				return url.Values{"client_id": {"some-unknown-client"}, "token": {refreshToken}}
+			},
+			wantStatus:              http.StatusUnauthorized,
+			wantErrorJSON:           "invalid_client",
+			wantTokenSessionsStored: 1,
+		},
+		{
+			name:   "wrong HTTP method",
+			method: http.MethodGet,
+			body: func(_, refreshToken string) url.Values {
+				return url.Values{"client_id": {clientregistry.PinnipedCLIClientID}, "token": {refreshToken}}
+			},
+			wantStatus:              http.StatusBadRequest,
+			wantErrorJSON:           "invalid_request",
+			wantTokenSessionsStored: 1,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			secrets := client.CoreV1().Secrets("some-namespace")
+			timeoutsConfiguration := oidc.DefaultOIDCTimeoutsConfiguration()
+			oauthStore := oidc.NewKubeStorage(secrets, oidctestutil.NewClientManager("some-namespace", otherClient, otherClientSecret), timeoutsConfiguration)
+			oauthHelper := oidc.FositeOauth2Helper(oauthStore, downstreamIssuer, func() [][]byte { return [][]byte{hmacSecret} }, nil, timeoutsConfiguration)
+
+			accessToken, refreshToken := oidctestutil.StoreDownstreamTokens(t, oauthStore, hmacSecret, newDownstreamRequest())
+			requireNumberOfTokenSessionsStored(t, client, 1)
+
+			method := test.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req := httptest.NewRequest(method, "/path/shouldn't/matter", strings.NewReader(test.body(accessToken, refreshToken).Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if test.useOtherClient {
+				req.SetBasicAuth(otherClientID, oidctestutil.ConfidentialOIDCClientSecret)
+			}
+			rsp := httptest.NewRecorder()
+			NewHandler(oauthHelper).ServeHTTP(rsp, req)
+			t.Logf("response: %#v", rsp)
+			t.Logf("response body: %q", rsp.Body.String())
+
+			require.Equal(t, test.wantStatus, rsp.Code)
+			if test.wantErrorJSON != "" {
+				require.Contains(t, rsp.Body.String(), `"error":"`+test.wantErrorJSON+`"`)
+			}
+			requireNumberOfTokenSessionsStored(t, client, test.wantTokenSessionsStored)
+		})
+	}
+}
+
+func newDownstreamRequest() *fosite.Request {
+	now := time.Now().UTC()
+	session := psession.NewPinnipedSession()
+	session.Claims = &jwt.IDTokenClaims{
+		Subject:     "some-subject",
+		RequestedAt: now,
+		AuthTime:    now,
+		Extra:       map[string]interface{}{oidc.DownstreamUsernameClaim: "some-username", oidc.DownstreamGroupsClaim: []string{"some-group"}},
+	}
+	session.ExpiresAt = map[fosite.TokenType]time.Time{
+		fosite.AccessToken:  now.Add(time.Minute),
+		fosite.RefreshToken: now.Add(time.Hour),
+	}
+	return &fosite.Request{
+		ID:             "some-request-id",
+		RequestedAt:    now,
+		Client:         clientregistry.PinnipedCLI(),
+		RequestedScope: fosite.Arguments{"openid", "offline_access"},
+		GrantedScope:   fosite.Arguments{"openid", "offline_access"},
+		Form:           url.Values{},
+		Session:        session,
+	}
+}
+
+func requireNumberOfTokenSessionsStored(t *testing.T, client *fake.Clientset, want int) {
+	t.Helper()
+	secrets := client.CoreV1().Secrets("some-namespace")
+	testutil.RequireNumberOfSecretsMatchingLabelSelector(t, secrets, labels.Set{crud.SecretLabelKey: accesstoken.TypeLabelValue}, want)
+	testutil.RequireNumberOfSecretsMatchingLabelSelector(t, secrets, labels.Set{crud.SecretLabelKey: refreshtoken.TypeLabelValue}, want)
+}
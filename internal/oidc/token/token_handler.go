@@ -1,41 +1,394 @@
-// Copyright 2020 the Pinniped contributors. All Rights Reserved.
+// Copyright 2020-2021 the Pinniped contributors. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
 // Package token provides a handler for the OIDC token endpoint.
 package token
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/ory/fosite"
-	"github.com/ory/fosite/handler/openid"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
 
+	"go.pinniped.dev/internal/auditlog"
 	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/metrics"
 	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/downstreamsession"
+	"go.pinniped.dev/internal/oidc/provider"
 	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/psession"
+)
+
+var (
+	errMissingUpstreamSessionData = fosite.ErrInvalidGrant.WithHint(
+		"There is no upstream session data in the session which is being refreshed. Please log in again.")
+
+	errUpstreamRefreshError = fosite.ErrInvalidGrant.WithHint(
+		"Error during upstream refresh. Please log in again.")
 )
 
 func NewHandler(
+	downstreamIssuer string,
+	idpLister oidc.UpstreamIdentityProvidersLister,
 	oauthHelper fosite.OAuth2Provider,
 ) http.Handler {
 	return httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
-		var session openid.DefaultSession
-		accessRequest, err := oauthHelper.NewAccessRequest(r.Context(), r, &session)
+		recordTokenGrant := func(result string) {
+			metrics.RecordTokenGrant(downstreamIssuer, knownGrantType(r), result)
+		}
+
+		session := psession.NewPinnipedSession()
+		accessRequest, err := oauthHelper.NewAccessRequest(r.Context(), r, session)
 		if err != nil {
 			plog.Info("token request error", oidc.FositeErrorForLog(err)...)
+			recordTokenGrant(metrics.ResultFailure)
+			recordAuditEvent(r, downstreamIssuer, accessRequest, auditlog.OutcomeFailure, fosite.ErrorToRFC6749Error(err).GetDescription())
 			oauthHelper.WriteAccessError(w, accessRequest, err)
 			return nil
 		}
 
+		// Check if we are performing a refresh grant.
+		if accessRequest.GetGrantTypes().ExactOne("refresh_token") {
+			// The above call to NewAccessRequest has loaded the session from storage into the accessRequest variable.
+			// Before issuing new tokens, check with the upstream provider that the user is still allowed to log in,
+			// and update the session with their current identity. Fosite will store the updated session along with
+			// the newly issued tokens, and the new ID token will contain the updated claims.
+			err = upstreamRefresh(r.Context(), accessRequest, idpLister)
+			if err != nil {
+				plog.Info("upstream refresh error", oidc.FositeErrorForLog(err)...)
+				recordTokenGrant(metrics.ResultFailure)
+				recordAuditEvent(r, downstreamIssuer, accessRequest, auditlog.OutcomeFailure, fosite.ErrorToRFC6749Error(err).GetDescription())
+				oauthHelper.WriteAccessError(w, accessRequest, err)
+				return nil
+			}
+		}
+
 		accessResponse, err := oauthHelper.NewAccessResponse(r.Context(), accessRequest)
 		if err != nil {
 			plog.Info("token response error", oidc.FositeErrorForLog(err)...)
+			recordTokenGrant(metrics.ResultError)
+			recordAuditEvent(r, downstreamIssuer, accessRequest, auditlog.OutcomeError, fosite.ErrorToRFC6749Error(err).GetDescription())
 			oauthHelper.WriteAccessError(w, accessRequest, err)
 			return nil
 		}
 
+		recordTokenGrant(metrics.ResultSuccess)
+		recordAuditEvent(r, downstreamIssuer, accessRequest, auditlog.OutcomeSuccess, "")
 		oauthHelper.WriteAccessResponse(w, accessRequest, accessResponse)
 
 		return nil
 	})
 }
+
+// knownGrantType returns the grant_type param of the token request, or "unknown" when it is not one of the
+// grant types which are supported by the Supervisor, to avoid recording arbitrary values from the client.
+func knownGrantType(r *http.Request) string {
+	switch grantType := r.PostFormValue("grant_type"); grantType {
+	case "authorization_code", "refresh_token", oidc.TokenExchangeGrantType, oidc.DeviceCodeGrantType:
+		return grantType
+	default:
+		return "unknown"
+	}
+}
+
+// recordAuditEvent records the outcome of a token request. Refreshes have their own event type, and all other grants
+// are token exchanges. The user is only known when the session of the request was loaded from storage.
+func recordAuditEvent(r *http.Request, downstreamIssuer string, accessRequest fosite.AccessRequester, outcome auditlog.Outcome, reason string) {
+	event := auditlog.Event{
+		Type:             auditlog.EventTypeSupervisorTokenExchange,
+		Outcome:          outcome,
+		Reason:           reason,
+		SourceIP:         auditlog.SourceIP(r),
+		ForwardedFor:     auditlog.ForwardedFor(r),
+		FederationDomain: downstreamIssuer,
+		GrantType:        knownGrantType(r),
+	}
+	if event.GrantType == "refresh_token" {
+		event.Type = auditlog.EventTypeSupervisorRefresh
+	}
+
+	if accessRequest == nil {
+		auditlog.Record(event)
+		return
+	}
+	if client := accessRequest.GetClient(); client != nil {
+		event.ClientID = client.GetID()
+	}
+	if session, ok := accessRequest.GetSession().(*psession.PinnipedSession); ok {
+		if session.Custom != nil && session.Custom.ProviderName != "" {
+			event.UpstreamIdentityProvider = &auditlog.IdentityProvider{
+				Name: session.Custom.ProviderName,
+				Type: string(session.Custom.ProviderType),
+			}
+		}
+		if session.Claims != nil {
+			if username, ok := session.Claims.Extra[oidc.DownstreamUsernameClaim].(string); ok {
+				event.User = &auditlog.User{Username: username, Groups: groupsFromClaim(session.Claims.Extra[oidc.DownstreamGroupsClaim])}
+			}
+		}
+	}
+	auditlog.Record(event)
+}
+
+// groupsFromClaim returns the groups claim of a session, which is a []interface{} after the session was loaded
+// from storage.
+func groupsFromClaim(claim interface{}) []string {
+	switch groups := claim.(type) {
+	case []string:
+		return groups
+	case []interface{}:
+		result := make([]string, 0, len(groups))
+		for _, group := range groups {
+			if g, ok := group.(string); ok {
+				result = append(result, g)
+			}
+		}
+		return result
+	default:
+		return nil
+	}
+}
+
+func upstreamRefresh(ctx context.Context, accessRequest fosite.AccessRequester, idpLister oidc.UpstreamIdentityProvidersLister) error {
+	session, ok := accessRequest.GetSession().(*psession.PinnipedSession)
+	if !ok || session.Custom == nil || session.Claims == nil {
+		return errors.WithStack(errMissingUpstreamSessionData)
+	}
+
+	customSessionData := session.Custom
+	switch customSessionData.ProviderType {
+	case psession.ProviderTypeOIDC:
+		return upstreamOIDCRefresh(ctx, session, idpLister)
+	case psession.ProviderTypeLDAP, psession.ProviderTypeActiveDirectory:
+		return upstreamLDAPRefresh(ctx, session, idpLister)
+	case psession.ProviderTypeGitHub:
+		return upstreamGitHubRefresh(ctx, session, idpLister)
+	case psession.ProviderTypeSAML:
+		return upstreamSAMLRefresh(session, idpLister)
+	default:
+		return errors.WithStack(errMissingUpstreamSessionData)
+	}
+}
+
+func upstreamOIDCRefresh(ctx context.Context, session *psession.PinnipedSession, idpLister oidc.UpstreamIdentityProvidersLister) error {
+	customSessionData := session.Custom
+	if customSessionData.OIDC == nil || customSessionData.OIDC.UpstreamRefreshToken == "" {
+		return errors.WithStack(errMissingUpstreamSessionData.WithDebugf(
+			"Provider %q of type %q did not issue a refresh token when the user logged in.",
+			customSessionData.ProviderName, customSessionData.ProviderType))
+	}
+
+	p := findOIDCProviderByName(customSessionData.ProviderName, idpLister.GetOIDCIdentityProviders())
+	if p == nil {
+		return errors.WithStack(errUpstreamRefreshError.WithDebugf(
+			"Provider %q of type %q from upstream session data was not found.",
+			customSessionData.ProviderName, customSessionData.ProviderType))
+	}
+
+	refreshedTokens, err := p.Refresh(ctx, customSessionData.OIDC.UpstreamRefreshToken)
+	if err != nil {
+		return errors.WithStack(errUpstreamRefreshError.WithWrap(err).WithDebugf(
+			"Upstream refresh failed using provider %q of type %q: %s",
+			customSessionData.ProviderName, customSessionData.ProviderType, err.Error()))
+	}
+
+	// Remember the newest upstream refresh token, since some providers rotate their refresh tokens on every use.
+	if refreshedTokens.RefreshToken != nil && refreshedTokens.RefreshToken.Token != "" {
+		customSessionData.OIDC.UpstreamRefreshToken = refreshedTokens.RefreshToken.Token
+	}
+
+	// When the upstream provider returned neither a new ID token nor any userinfo claims, the successful refresh
+	// is the only confirmation that the user may still log in, so keep their previous identity.
+	if refreshedTokens.IDToken == nil || len(refreshedTokens.IDToken.Claims) == 0 {
+		return nil
+	}
+
+	claims := refreshedTokens.IDToken.Claims
+	if _, hasIssuer := claims[oidc.IDTokenIssuerClaim]; !hasIssuer && customSessionData.OIDC.UpstreamIssuer != "" {
+		// Claims from the userinfo endpoint do not include the issuer, which is needed to determine the subject.
+		claims[oidc.IDTokenIssuerClaim] = customSessionData.OIDC.UpstreamIssuer
+	}
+
+	subject, username, err := downstreamsession.GetSubjectAndUsernameFromUpstreamIDToken(p, claims)
+	if err != nil {
+		return errors.WithStack(errUpstreamRefreshError.WithWrap(err).WithDebugf(
+			"Upstream refresh returned an invalid identity using provider %q of type %q: %s",
+			customSessionData.ProviderName, customSessionData.ProviderType, err.Error()))
+	}
+
+	groups, err := downstreamsession.GetGroupsFromUpstreamIDToken(p, claims)
+	if err != nil {
+		return errors.WithStack(errUpstreamRefreshError.WithWrap(err).WithDebugf(
+			"Upstream refresh returned invalid groups using provider %q of type %q: %s",
+			customSessionData.ProviderName, customSessionData.ProviderType, err.Error()))
+	}
+
+	return updateSessionIdentity(session, idpLister, subject, username, groups)
+}
+
+// upstreamGitHubRefresh uses the upstream access token to look up the user's current identity and organization
+// memberships, since GitHub does not issue refresh tokens to OAuth Apps. This fails when the token was revoked or
+// when the user is no longer a member of any allowed organization.
+func upstreamGitHubRefresh(ctx context.Context, session *psession.PinnipedSession, idpLister oidc.UpstreamIdentityProvidersLister) error {
+	customSessionData := session.Custom
+	if customSessionData.GitHub == nil || customSessionData.GitHub.UpstreamAccessToken == "" {
+		return errors.WithStack(errMissingUpstreamSessionData)
+	}
+
+	p := findOIDCProviderByName(customSessionData.ProviderName, idpLister.GetGitHubIdentityProviders())
+	if p == nil {
+		return errors.WithStack(errUpstreamRefreshError.WithDebugf(
+			"Provider %q of type %q from upstream session data was not found.",
+			customSessionData.ProviderName, customSessionData.ProviderType))
+	}
+
+	validatedTokens, err := p.ValidateToken(ctx, &oauth2.Token{AccessToken: customSessionData.GitHub.UpstreamAccessToken}, "")
+	if err != nil {
+		return errors.WithStack(errUpstreamRefreshError.WithWrap(err).WithDebugf(
+			"Upstream refresh failed using provider %q of type %q: %s",
+			customSessionData.ProviderName, customSessionData.ProviderType, err.Error()))
+	}
+
+	subject, username, err := downstreamsession.GetSubjectAndUsernameFromUpstreamIDToken(p, validatedTokens.IDToken.Claims)
+	if err != nil {
+		return errors.WithStack(errUpstreamRefreshError.WithWrap(err).WithDebugf(
+			"Upstream refresh returned an invalid identity using provider %q of type %q: %s",
+			customSessionData.ProviderName, customSessionData.ProviderType, err.Error()))
+	}
+
+	groups, err := downstreamsession.GetGroupsFromUpstreamIDToken(p, validatedTokens.IDToken.Claims)
+	if err != nil {
+		return errors.WithStack(errUpstreamRefreshError.WithWrap(err).WithDebugf(
+			"Upstream refresh returned invalid groups using provider %q of type %q: %s",
+			customSessionData.ProviderName, customSessionData.ProviderType, err.Error()))
+	}
+
+	return updateSessionIdentity(session, idpLister, subject, username, groups)
+}
+
+// upstreamLDAPRefresh repeats the user search for sessions from both LDAP and Active Directory upstreams.
+func upstreamLDAPRefresh(ctx context.Context, session *psession.PinnipedSession, idpLister oidc.UpstreamIdentityProvidersLister) error {
+	customSessionData := session.Custom
+
+	var username string
+	var upstreams []provider.UpstreamLDAPIdentityProviderI
+	if customSessionData.ProviderType == psession.ProviderTypeActiveDirectory {
+		if customSessionData.ActiveDirectory != nil {
+			username = customSessionData.ActiveDirectory.Username
+		}
+		upstreams = idpLister.GetActiveDirectoryIdentityProviders()
+	} else {
+		if customSessionData.LDAP != nil {
+			username = customSessionData.LDAP.Username
+		}
+		upstreams = idpLister.GetLDAPIdentityProviders()
+	}
+	if username == "" {
+		return errors.WithStack(errMissingUpstreamSessionData)
+	}
+
+	p := findLDAPProviderByName(customSessionData.ProviderName, upstreams)
+	if p == nil {
+		return errors.WithStack(errUpstreamRefreshError.WithDebugf(
+			"Provider %q of type %q from upstream session data was not found.",
+			customSessionData.ProviderName, customSessionData.ProviderType))
+	}
+
+	authenticateResponse, found, err := p.RefreshUser(ctx, username)
+	if err != nil {
+		return errors.WithStack(errUpstreamRefreshError.WithWrap(err).WithDebugf(
+			"Upstream refresh failed using provider %q of type %q: %s",
+			customSessionData.ProviderName, customSessionData.ProviderType, err.Error()))
+	}
+	if !found {
+		return errors.WithStack(errUpstreamRefreshError.WithDebugf(
+			"User %q was not found during upstream refresh using provider %q of type %q.",
+			username, customSessionData.ProviderName, customSessionData.ProviderType))
+	}
+
+	subject := downstreamsession.DownstreamSubjectFromUpstreamLDAP(p, authenticateResponse)
+	return updateSessionIdentity(session, idpLister, subject, authenticateResponse.User.GetName(), authenticateResponse.User.GetGroups())
+}
+
+// upstreamSAMLRefresh checks that the upstream SAML provider is still configured and applies the current identity
+// transforms to the identity from the original login, since SAML has no way to refresh an identity without the user.
+func upstreamSAMLRefresh(session *psession.PinnipedSession, idpLister oidc.UpstreamIdentityProvidersLister) error {
+	customSessionData := session.Custom
+	if customSessionData.SAML == nil || customSessionData.SAML.NameID == "" {
+		return errors.WithStack(errMissingUpstreamSessionData)
+	}
+
+	p := findSAMLProviderByName(customSessionData.ProviderName, idpLister.GetSAMLIdentityProviders())
+	if p == nil {
+		return errors.WithStack(errUpstreamRefreshError.WithDebugf(
+			"Provider %q of type %q from upstream session data was not found.",
+			customSessionData.ProviderName, customSessionData.ProviderType))
+	}
+
+	subject := downstreamsession.DownstreamSubjectFromUpstreamSAML(p, customSessionData.SAML.NameID)
+	return updateSessionIdentity(session, idpLister, subject, customSessionData.SAML.Username, customSessionData.SAML.Groups)
+}
+
+// updateSessionIdentity replaces the username and groups in the downstream session, after checking that the upstream
+// provider still considers the session to belong to the same user and applying the same identity transforms as
+// during the initial login.
+func updateSessionIdentity(
+	session *psession.PinnipedSession,
+	transformsLister oidc.IdentityTransformsLister,
+	subject string,
+	username string,
+	groups []string,
+) error {
+	if subject != session.Claims.Subject {
+		return errors.WithStack(errUpstreamRefreshError.WithDebugf(
+			"Upstream refresh returned a different subject %q than the session's subject %q.", subject, session.Claims.Subject))
+	}
+
+	transforms := transformsLister.GetIdentityTransforms(session.Custom.ProviderName, string(session.Custom.ProviderType))
+	username, groups, err := transforms.Evaluate(username, groups)
+	if err != nil {
+		return errors.WithStack(errUpstreamRefreshError.WithWrap(err).WithDebugf(
+			"Upstream refresh was denied by identity transforms using provider %q of type %q: %s",
+			session.Custom.ProviderName, session.Custom.ProviderType, err.Error()))
+	}
+
+	if groups == nil {
+		groups = []string{}
+	}
+	if session.Claims.Extra == nil {
+		session.Claims.Extra = map[string]interface{}{}
+	}
+	session.Claims.Extra[oidc.DownstreamUsernameClaim] = username
+	session.Claims.Extra[oidc.DownstreamGroupsClaim] = groups
+	return nil
+}
+
+func findOIDCProviderByName(name string, upstreams []provider.UpstreamOIDCIdentityProviderI) provider.UpstreamOIDCIdentityProviderI {
+	for _, p := range upstreams {
+		if p.GetName() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func findLDAPProviderByName(name string, upstreams []provider.UpstreamLDAPIdentityProviderI) provider.UpstreamLDAPIdentityProviderI {
+	for _, p := range upstreams {
+		if p.GetName() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func findSAMLProviderByName(name string, upstreams []provider.UpstreamSAMLIdentityProviderI) provider.UpstreamSAMLIdentityProviderI {
+	for _, p := range upstreams {
+		if p.GetName() == name {
+			return p
+		}
+	}
+	return nil
+}
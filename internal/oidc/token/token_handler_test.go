@@ -29,25 +29,34 @@ import (
 	"github.com/ory/fosite/token/jwt"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
+	xoauth2 "golang.org/x/oauth2"
 	"gopkg.in/square/go-jose.v2"
 	josejwt "gopkg.in/square/go-jose.v2/jwt"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/client-go/kubernetes/fake"
 	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
 
 	"go.pinniped.dev/internal/crud"
 	"go.pinniped.dev/internal/fositestorage/accesstoken"
 	"go.pinniped.dev/internal/fositestorage/authorizationcode"
+	"go.pinniped.dev/internal/fositestorage/devicecode"
 	"go.pinniped.dev/internal/fositestorage/openidconnect"
 	storagepkce "go.pinniped.dev/internal/fositestorage/pkce"
 	"go.pinniped.dev/internal/fositestorage/refreshtoken"
 	"go.pinniped.dev/internal/fositestoragei"
 	"go.pinniped.dev/internal/here"
+	"go.pinniped.dev/internal/idtransform"
 	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/clientregistry"
 	"go.pinniped.dev/internal/oidc/jwks"
+	"go.pinniped.dev/internal/psession"
 	"go.pinniped.dev/internal/testutil"
 	"go.pinniped.dev/internal/testutil/oidctestutil"
+	"go.pinniped.dev/pkg/oidcclient/nonce"
+	"go.pinniped.dev/pkg/oidcclient/oidctypes"
 )
 
 const (
@@ -58,7 +67,6 @@ const (
 	goodNonce            = "some-nonce-value-with-enough-bytes-to-exceed-min-allowed"
 	goodSubject          = "https://issuer?sub=some-subject"
 	goodUsername         = "some-username"
-	goodGroups           = "group1,groups2"
 
 	hmacSecret = "this needs to be at least 32 characters to meet entropy requirements"
 
@@ -72,9 +80,10 @@ const (
 var (
 	goodAuthTime        = time.Date(1, 2, 3, 4, 5, 6, 7, time.UTC)
 	goodRequestedAtTime = time.Date(7, 6, 5, 4, 3, 2, 1, time.UTC)
+	goodGroups          = []string{"group1", "groups2"}
 
-	hmacSecretFunc = func() []byte {
-		return []byte(hmacSecret)
+	hmacSecretFunc = func() [][]byte {
+		return [][]byte{[]byte(hmacSecret)}
 	}
 
 	fositeInvalidMethodErrorBody = func(actual string) string {
@@ -205,11 +214,14 @@ var (
 )
 
 type tokenEndpointResponseExpectedValues struct {
-	wantStatus            int
-	wantSuccessBodyFields []string
-	wantErrorResponseBody string
-	wantRequestedScopes   []string
-	wantGrantedScopes     []string
+	wantStatus                  int
+	wantSuccessBodyFields       []string
+	wantErrorResponseBody       string
+	wantRequestedScopes         []string
+	wantGrantedScopes           []string
+	wantUsername                string
+	wantGroups                  []string
+	wantCustomSessionDataStored *psession.CustomSessionData
 }
 
 type authcodeExchangeInputs struct {
@@ -224,7 +236,9 @@ type authcodeExchangeInputs struct {
 		t *testing.T,
 		authRequest *http.Request,
 		store fositestoragei.AllFositeStorage,
+		initialCustomSessionData *psession.CustomSessionData,
 	) (fosite.OAuth2Provider, string, *ecdsa.PrivateKey)
+	customSessionData *psession.CustomSessionData
 
 	want tokenEndpointResponseExpectedValues
 }
@@ -243,6 +257,8 @@ func TestTokenEndpoint(t *testing.T) {
 					wantSuccessBodyFields: []string{"id_token", "access_token", "token_type", "scope", "expires_in"}, // no refresh token
 					wantRequestedScopes:   []string{"openid", "profile", "email"},
 					wantGrantedScopes:     []string{"openid"},
+					wantUsername:          goodUsername,
+					wantGroups:            goodGroups,
 				},
 			},
 		},
@@ -255,6 +271,8 @@ func TestTokenEndpoint(t *testing.T) {
 					wantSuccessBodyFields: []string{"access_token", "token_type", "scope", "expires_in"}, // no id or refresh tokens
 					wantRequestedScopes:   []string{"profile", "email"},
 					wantGrantedScopes:     []string{},
+					wantUsername:          goodUsername,
+					wantGroups:            goodGroups,
 				},
 			},
 		},
@@ -267,6 +285,8 @@ func TestTokenEndpoint(t *testing.T) {
 					wantSuccessBodyFields: []string{"id_token", "access_token", "token_type", "scope", "expires_in", "refresh_token"}, // all possible tokens
 					wantRequestedScopes:   []string{"openid", "offline_access"},
 					wantGrantedScopes:     []string{"openid", "offline_access"},
+					wantUsername:          goodUsername,
+					wantGroups:            goodGroups,
 				},
 			},
 		},
@@ -279,6 +299,8 @@ func TestTokenEndpoint(t *testing.T) {
 					wantSuccessBodyFields: []string{"access_token", "token_type", "scope", "expires_in", "refresh_token"}, // no id token
 					wantRequestedScopes:   []string{"offline_access"},
 					wantGrantedScopes:     []string{"offline_access"},
+					wantUsername:          goodUsername,
+					wantGroups:            goodGroups,
 				},
 			},
 		},
@@ -518,7 +540,7 @@ func TestTokenEndpoint(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
 
-			exchangeAuthcodeForTokens(t, test.authcodeExchange)
+			exchangeAuthcodeForTokens(t, test.authcodeExchange, oidctestutil.NewUpstreamIDPListerBuilder().Build())
 		})
 	}
 }
@@ -537,6 +559,8 @@ func TestTokenEndpointWhenAuthcodeIsUsedTwice(t *testing.T) {
 					wantSuccessBodyFields: []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
 					wantRequestedScopes:   []string{"openid", "offline_access", "profile", "email"},
 					wantGrantedScopes:     []string{"openid", "offline_access"},
+					wantUsername:          goodUsername,
+					wantGroups:            goodGroups,
 				},
 			},
 		},
@@ -547,7 +571,7 @@ func TestTokenEndpointWhenAuthcodeIsUsedTwice(t *testing.T) {
 			t.Parallel()
 
 			// First call - should be successful.
-			subject, rsp, authCode, _, secrets, oauthStore := exchangeAuthcodeForTokens(t, test.authcodeExchange)
+			subject, rsp, authCode, _, secrets, oauthStore := exchangeAuthcodeForTokens(t, test.authcodeExchange, oidctestutil.NewUpstreamIDPListerBuilder().Build())
 			var parsedResponseBody map[string]interface{}
 			require.NoError(t, json.Unmarshal(rsp.Body.Bytes(), &parsedResponseBody))
 
@@ -573,7 +597,7 @@ func TestTokenEndpointWhenAuthcodeIsUsedTwice(t *testing.T) {
 			requireInvalidPKCEStorage(t, authCode, oauthStore)
 			// Fosite never cleans up OpenID Connect session storage, so it is still there
 			requireValidOIDCStorage(t, parsedResponseBody, authCode, oauthStore,
-				test.authcodeExchange.want.wantRequestedScopes, test.authcodeExchange.want.wantGrantedScopes)
+				test.authcodeExchange.want.wantRequestedScopes, test.authcodeExchange.want.wantGrantedScopes, test.authcodeExchange.customSessionData)
 
 			// Check that the access token and refresh token storage were both deleted, and the number of other storage objects did not change.
 			testutil.RequireNumberOfSecretsMatchingLabelSelector(t, secrets, labels.Set{crud.SecretLabelKey: authorizationcode.TypeLabelValue}, 1)
@@ -592,6 +616,8 @@ func TestTokenExchange(t *testing.T) {
 		wantSuccessBodyFields: []string{"id_token", "access_token", "token_type", "expires_in", "scope"},
 		wantRequestedScopes:   []string{"openid", "pinniped:request-audience"},
 		wantGrantedScopes:     []string{"openid", "pinniped:request-audience"},
+		wantUsername:          goodUsername,
+		wantGroups:            goodGroups,
 	}
 
 	doValidAuthCodeExchange := authcodeExchangeInputs{
@@ -624,6 +650,27 @@ func TestTokenExchange(t *testing.T) {
 			wantStatus:               http.StatusBadRequest,
 			wantResponseBodyContains: "missing audience parameter",
 		},
+		{
+			name:                     "audience which looks like the client ID of an OIDCClient",
+			authcodeExchange:         doValidAuthCodeExchange,
+			requestedAudience:        "client.oauth.pinniped.dev-some-client",
+			wantStatus:               http.StatusBadRequest,
+			wantResponseBodyContains: "requested audience cannot contain '.pinniped.dev'",
+		},
+		{
+			name:                     "audience which contains .pinniped.dev",
+			authcodeExchange:         doValidAuthCodeExchange,
+			requestedAudience:        "some-cluster.pinniped.dev",
+			wantStatus:               http.StatusBadRequest,
+			wantResponseBodyContains: "requested audience cannot contain '.pinniped.dev'",
+		},
+		{
+			name:                     "audience which is the client ID of the Pinniped CLI",
+			authcodeExchange:         doValidAuthCodeExchange,
+			requestedAudience:        "pinniped-cli",
+			wantStatus:               http.StatusBadRequest,
+			wantResponseBodyContains: "requested audience cannot be the client ID of a registered client",
+		},
 		{
 			name:              "missing subject_token",
 			authcodeExchange:  doValidAuthCodeExchange,
@@ -697,6 +744,8 @@ func TestTokenExchange(t *testing.T) {
 					wantSuccessBodyFields: []string{"id_token", "access_token", "token_type", "expires_in", "scope"},
 					wantRequestedScopes:   []string{"openid"},
 					wantGrantedScopes:     []string{"openid"},
+					wantUsername:          goodUsername,
+					wantGroups:            goodGroups,
 				},
 			},
 			requestedAudience:        "some-workload-cluster",
@@ -714,6 +763,8 @@ func TestTokenExchange(t *testing.T) {
 					wantSuccessBodyFields: []string{"access_token", "token_type", "expires_in", "scope"},
 					wantRequestedScopes:   []string{"pinniped:request-audience"},
 					wantGrantedScopes:     []string{"pinniped:request-audience"},
+					wantUsername:          goodUsername,
+					wantGroups:            goodGroups,
 				},
 			},
 			requestedAudience:        "some-workload-cluster",
@@ -740,7 +791,7 @@ func TestTokenExchange(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
 
-			subject, rsp, _, _, secrets, storage := exchangeAuthcodeForTokens(t, test.authcodeExchange)
+			subject, rsp, _, _, secrets, storage := exchangeAuthcodeForTokens(t, test.authcodeExchange, oidctestutil.NewUpstreamIDPListerBuilder().Build())
 			var parsedAuthcodeExchangeResponseBody map[string]interface{}
 			require.NoError(t, json.Unmarshal(rsp.Body.Bytes(), &parsedAuthcodeExchangeResponseBody))
 
@@ -813,7 +864,7 @@ func TestTokenExchange(t *testing.T) {
 			require.Equal(t, goodSubject, tokenClaims["sub"])
 			require.Equal(t, goodIssuer, tokenClaims["iss"])
 			require.Equal(t, goodUsername, tokenClaims["username"])
-			require.Equal(t, goodGroups, tokenClaims["groups"])
+			require.ElementsMatch(t, goodGroups, tokenClaims["groups"])
 
 			// Also assert that some are the same as the original downstream ID token.
 			requireClaimsAreEqual(t, "iss", claimsOfFirstIDToken, tokenClaims)       // issuer
@@ -843,126 +894,666 @@ type refreshRequestInputs struct {
 }
 
 func TestRefreshGrant(t *testing.T) {
+	const (
+		oidcUpstreamName                  = "some-oidc-idp"
+		oidcUpstreamIssuer                = "https://issuer" // the issuer and subject must match goodSubject
+		oidcUpstreamSubject               = "some-subject"
+		oidcUpstreamUsernameClaim         = "the-username-claim"
+		oidcUpstreamGroupsClaim           = "the-groups-claim"
+		oidcUpstreamInitialRefreshToken   = "initial-upstream-refresh-token"
+		oidcUpstreamRefreshedRefreshToken = "fake-refreshed-upstream-refresh-token"
+
+		gitHubUpstreamAccessToken = "some-github-access-token"
+
+		ldapUpstreamName     = "some-ldap-idp"
+		ldapUpstreamUsername = "some-ldap-username"
+		ldapUpstreamUID      = "some-subject" // the provider's URL and the user's UID must match goodSubject
+
+		samlUpstreamName   = "some-saml-idp"
+		samlUpstreamNameID = "some-subject" // the provider's entity ID and the user's NameID must match goodSubject
+	)
+
+	ldapUpstreamURL, err := url.Parse(oidcUpstreamIssuer)
+	require.NoError(t, err)
+
+	upstreamRefreshErrorBody := here.Doc(`
+		{
+			"error":             "invalid_grant",
+			"error_description": "The provided authorization grant (e.g., authorization code, resource owner credentials) or refresh token is invalid, expired, revoked, does not match the redirection URI used in the authorization request, or was issued to another client. Error during upstream refresh. Please log in again."
+		}
+	`)
+
+	missingUpstreamSessionDataErrorBody := here.Doc(`
+		{
+			"error":             "invalid_grant",
+			"error_description": "The provided authorization grant (e.g., authorization code, resource owner credentials) or refresh token is invalid, expired, revoked, does not match the redirection URI used in the authorization request, or was issued to another client. There is no upstream session data in the session which is being refreshed. Please log in again."
+		}
+	`)
+
+	initialUpstreamOIDCCustomSessionData := func() *psession.CustomSessionData {
+		return &psession.CustomSessionData{
+			ProviderName: oidcUpstreamName,
+			ProviderType: psession.ProviderTypeOIDC,
+			OIDC: &psession.OIDCSessionData{
+				UpstreamRefreshToken: oidcUpstreamInitialRefreshToken,
+				UpstreamIssuer:       oidcUpstreamIssuer,
+			},
+		}
+	}
+
+	upstreamOIDCCustomSessionDataWithRefreshToken := func(refreshToken string) *psession.CustomSessionData {
+		sessionData := initialUpstreamOIDCCustomSessionData()
+		sessionData.OIDC.UpstreamRefreshToken = refreshToken
+		return sessionData
+	}
+
+	happyLDAPCustomSessionData := &psession.CustomSessionData{
+		ProviderName: ldapUpstreamName,
+		ProviderType: psession.ProviderTypeLDAP,
+		LDAP:         &psession.LDAPSessionData{Username: ldapUpstreamUsername},
+	}
+
+	happyActiveDirectoryCustomSessionData := &psession.CustomSessionData{
+		ProviderName:    ldapUpstreamName,
+		ProviderType:    psession.ProviderTypeActiveDirectory,
+		ActiveDirectory: &psession.ActiveDirectorySessionData{Username: ldapUpstreamUsername},
+	}
+
+	happyGitHubCustomSessionData := &psession.CustomSessionData{
+		ProviderName: oidcUpstreamName,
+		ProviderType: psession.ProviderTypeGitHub,
+		GitHub:       &psession.GitHubSessionData{UpstreamAccessToken: gitHubUpstreamAccessToken},
+	}
+
+	happySAMLCustomSessionData := &psession.CustomSessionData{
+		ProviderName: samlUpstreamName,
+		ProviderType: psession.ProviderTypeSAML,
+		SAML: &psession.SAMLSessionData{
+			NameID:   samlUpstreamNameID,
+			Username: goodUsername,
+			Groups:   goodGroups,
+		},
+	}
+
+	happyUpstreamIDTokenClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":                     oidcUpstreamIssuer,
+			"sub":                     oidcUpstreamSubject,
+			oidcUpstreamUsernameClaim: goodUsername,
+			oidcUpstreamGroupsClaim:   []interface{}{"group1", "groups2"},
+		}
+	}
+
+	upstreamOIDCIdentityProviderReturning := func(tokens *oidctypes.Token, err error) *oidctestutil.TestUpstreamOIDCIdentityProvider {
+		return &oidctestutil.TestUpstreamOIDCIdentityProvider{
+			Name:          oidcUpstreamName,
+			ClientID:      "some-client-id",
+			UsernameClaim: oidcUpstreamUsernameClaim,
+			GroupsClaim:   oidcUpstreamGroupsClaim,
+			RefreshFunc: func(ctx context.Context, refreshToken string) (*oidctypes.Token, error) {
+				return tokens, err
+			},
+		}
+	}
+
+	upstreamOIDCIdentityProviderReturningClaims := func(claims map[string]interface{}) *oidctestutil.TestUpstreamOIDCIdentityProvider {
+		return upstreamOIDCIdentityProviderReturning(&oidctypes.Token{
+			RefreshToken: &oidctypes.RefreshToken{Token: oidcUpstreamRefreshedRefreshToken},
+			IDToken:      &oidctypes.IDToken{Claims: claims},
+		}, nil)
+	}
+
+	happyUpstreamOIDCIdentityProvider := func() *oidctestutil.TestUpstreamOIDCIdentityProvider {
+		return upstreamOIDCIdentityProviderReturningClaims(happyUpstreamIDTokenClaims())
+	}
+
+	upstreamGitHubIdentityProviderReturning := func(tokens *oidctypes.Token, err error) *oidctestutil.TestUpstreamOIDCIdentityProvider {
+		return &oidctestutil.TestUpstreamOIDCIdentityProvider{
+			Name:          oidcUpstreamName,
+			ClientID:      "some-client-id",
+			UsernameClaim: oidcUpstreamUsernameClaim,
+			GroupsClaim:   oidcUpstreamGroupsClaim,
+			ValidateTokenFunc: func(ctx context.Context, tok *xoauth2.Token, expectedIDTokenNonce nonce.Nonce) (*oidctypes.Token, error) {
+				return tokens, err
+			},
+		}
+	}
+
+	happyUpstreamGitHubIdentityProvider := func() *oidctestutil.TestUpstreamOIDCIdentityProvider {
+		return upstreamGitHubIdentityProviderReturning(&oidctypes.Token{
+			AccessToken: &oidctypes.AccessToken{Token: gitHubUpstreamAccessToken},
+			IDToken:     &oidctypes.IDToken{Claims: happyUpstreamIDTokenClaims()},
+		}, nil)
+	}
+
+	upstreamLDAPIdentityProviderReturning := func(response *authenticator.Response, found bool, err error) *oidctestutil.TestUpstreamLDAPIdentityProvider {
+		return &oidctestutil.TestUpstreamLDAPIdentityProvider{
+			Name: ldapUpstreamName,
+			URL:  ldapUpstreamURL,
+			RefreshUserFunc: func(ctx context.Context, username string) (*authenticator.Response, bool, error) {
+				return response, found, err
+			},
+		}
+	}
+
+	happyUpstreamLDAPIdentityProvider := func() *oidctestutil.TestUpstreamLDAPIdentityProvider {
+		return upstreamLDAPIdentityProviderReturning(&authenticator.Response{
+			User: &user.DefaultInfo{Name: goodUsername, UID: ldapUpstreamUID, Groups: goodGroups},
+		}, true, nil)
+	}
+
+	happyUpstreamSAMLIdentityProvider := func() *oidctestutil.TestUpstreamSAMLIdentityProvider {
+		return &oidctestutil.TestUpstreamSAMLIdentityProvider{
+			Name:     samlUpstreamName,
+			EntityID: oidcUpstreamIssuer,
+		}
+	}
+
+	happyTokenResponseForOpenIDAndOfflineAccess := func(wantCustomSessionDataStored *psession.CustomSessionData) tokenEndpointResponseExpectedValues {
+		return tokenEndpointResponseExpectedValues{
+			wantStatus:                  http.StatusOK,
+			wantSuccessBodyFields:       []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
+			wantRequestedScopes:         []string{"openid", "offline_access"},
+			wantGrantedScopes:           []string{"openid", "offline_access"},
+			wantUsername:                goodUsername,
+			wantGroups:                  goodGroups,
+			wantCustomSessionDataStored: wantCustomSessionDataStored,
+		}
+	}
+
+	happyAuthcodeExchangeInputsForUpstream := func(customSessionData *psession.CustomSessionData) authcodeExchangeInputs {
+		return authcodeExchangeInputs{
+			modifyAuthRequest: func(r *http.Request) { r.Form.Set("scope", "openid offline_access") },
+			customSessionData: customSessionData,
+			want:              happyTokenResponseForOpenIDAndOfflineAccess(customSessionData),
+		}
+	}
+
 	tests := []struct {
 		name             string
+		oidcUpstream     *oidctestutil.TestUpstreamOIDCIdentityProvider
+		ldapUpstream     *oidctestutil.TestUpstreamLDAPIdentityProvider
+		ldapTransforms   []idtransform.Transform
+		adUpstream       *oidctestutil.TestUpstreamLDAPIdentityProvider
+		adTransforms     []idtransform.Transform
+		gitHubUpstream   *oidctestutil.TestUpstreamOIDCIdentityProvider
+		samlUpstream     *oidctestutil.TestUpstreamSAMLIdentityProvider
+		samlTransforms   []idtransform.Transform
 		authcodeExchange authcodeExchangeInputs
 		refreshRequest   refreshRequestInputs
+
+		wantUpstreamOIDCRefreshCall         *oidctestutil.RefreshArgs
+		wantUpstreamLDAPRefreshCall         *oidctestutil.RefreshArgs
+		wantUpstreamADRefreshCall           *oidctestutil.RefreshArgs
+		wantUpstreamGitHubValidateTokenCall *oidctestutil.ValidateTokenArgs
 	}{
 		{
-			name: "happy path refresh grant with ID token",
+			name:             "happy path refresh grant with ID token",
+			oidcUpstream:     happyUpstreamOIDCIdentityProvider(),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(initialUpstreamOIDCCustomSessionData()),
+			refreshRequest: refreshRequestInputs{
+				want: happyTokenResponseForOpenIDAndOfflineAccess(
+					upstreamOIDCCustomSessionDataWithRefreshToken(oidcUpstreamRefreshedRefreshToken),
+				),
+			},
+			wantUpstreamOIDCRefreshCall: &oidctestutil.RefreshArgs{RefreshToken: oidcUpstreamInitialRefreshToken},
+		},
+		{
+			name:         "happy path refresh grant without ID token",
+			oidcUpstream: happyUpstreamOIDCIdentityProvider(),
 			authcodeExchange: authcodeExchangeInputs{
-				modifyAuthRequest: func(r *http.Request) { r.Form.Set("scope", "openid offline_access") },
+				modifyAuthRequest: func(r *http.Request) { r.Form.Set("scope", "offline_access") },
+				customSessionData: initialUpstreamOIDCCustomSessionData(),
 				want: tokenEndpointResponseExpectedValues{
-					wantStatus:            http.StatusOK,
-					wantSuccessBodyFields: []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
-					wantRequestedScopes:   []string{"openid", "offline_access"},
-					wantGrantedScopes:     []string{"openid", "offline_access"},
+					wantStatus:                  http.StatusOK,
+					wantSuccessBodyFields:       []string{"refresh_token", "access_token", "token_type", "expires_in", "scope"},
+					wantRequestedScopes:         []string{"offline_access"},
+					wantGrantedScopes:           []string{"offline_access"},
+					wantUsername:                goodUsername,
+					wantGroups:                  goodGroups,
+					wantCustomSessionDataStored: initialUpstreamOIDCCustomSessionData(),
 				},
 			},
 			refreshRequest: refreshRequestInputs{
 				want: tokenEndpointResponseExpectedValues{
-					wantStatus:            http.StatusOK,
-					wantSuccessBodyFields: []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
-					wantRequestedScopes:   []string{"openid", "offline_access"},
-					wantGrantedScopes:     []string{"openid", "offline_access"},
+					wantStatus:                  http.StatusOK,
+					wantSuccessBodyFields:       []string{"refresh_token", "access_token", "token_type", "expires_in", "scope"},
+					wantRequestedScopes:         []string{"offline_access"},
+					wantGrantedScopes:           []string{"offline_access"},
+					wantUsername:                goodUsername,
+					wantGroups:                  goodGroups,
+					wantCustomSessionDataStored: upstreamOIDCCustomSessionDataWithRefreshToken(oidcUpstreamRefreshedRefreshToken),
 				}},
+			wantUpstreamOIDCRefreshCall: &oidctestutil.RefreshArgs{RefreshToken: oidcUpstreamInitialRefreshToken},
 		},
 		{
-			name: "happy path refresh grant without ID token",
-			authcodeExchange: authcodeExchangeInputs{
-				modifyAuthRequest: func(r *http.Request) { r.Form.Set("scope", "offline_access") },
+			name:             "when the upstream refresh returns a new username and new groups then they are used in the refreshed tokens",
+			oidcUpstream:     upstreamOIDCIdentityProviderReturningClaims(map[string]interface{}{"iss": oidcUpstreamIssuer, "sub": oidcUpstreamSubject, oidcUpstreamUsernameClaim: "some-new-username", oidcUpstreamGroupsClaim: []interface{}{"new-group1", "new-group2"}}),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(initialUpstreamOIDCCustomSessionData()),
+			refreshRequest: refreshRequestInputs{
 				want: tokenEndpointResponseExpectedValues{
-					wantStatus:            http.StatusOK,
-					wantSuccessBodyFields: []string{"refresh_token", "access_token", "token_type", "expires_in", "scope"},
-					wantRequestedScopes:   []string{"offline_access"},
-					wantGrantedScopes:     []string{"offline_access"},
-				},
+					wantStatus:                  http.StatusOK,
+					wantSuccessBodyFields:       []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
+					wantRequestedScopes:         []string{"openid", "offline_access"},
+					wantGrantedScopes:           []string{"openid", "offline_access"},
+					wantUsername:                "some-new-username",
+					wantGroups:                  []string{"new-group1", "new-group2"},
+					wantCustomSessionDataStored: upstreamOIDCCustomSessionDataWithRefreshToken(oidcUpstreamRefreshedRefreshToken),
+				}},
+			wantUpstreamOIDCRefreshCall: &oidctestutil.RefreshArgs{RefreshToken: oidcUpstreamInitialRefreshToken},
+		},
+		{
+			name:             "when the upstream refresh returns only userinfo claims then the issuer from the session is used to check the subject",
+			oidcUpstream:     upstreamOIDCIdentityProviderReturningClaims(map[string]interface{}{"sub": oidcUpstreamSubject, oidcUpstreamUsernameClaim: goodUsername, oidcUpstreamGroupsClaim: []interface{}{"group1", "groups2"}}),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(initialUpstreamOIDCCustomSessionData()),
+			refreshRequest: refreshRequestInputs{
+				want: happyTokenResponseForOpenIDAndOfflineAccess(
+					upstreamOIDCCustomSessionDataWithRefreshToken(oidcUpstreamRefreshedRefreshToken),
+				),
+			},
+			wantUpstreamOIDCRefreshCall: &oidctestutil.RefreshArgs{RefreshToken: oidcUpstreamInitialRefreshToken},
+		},
+		{
+			name:             "when the upstream refresh returns neither claims nor a new refresh token then the previous identity and upstream refresh token are kept",
+			oidcUpstream:     upstreamOIDCIdentityProviderReturning(&oidctypes.Token{AccessToken: &oidctypes.AccessToken{Token: "some-upstream-access-token"}}, nil),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(initialUpstreamOIDCCustomSessionData()),
+			refreshRequest: refreshRequestInputs{
+				want: happyTokenResponseForOpenIDAndOfflineAccess(initialUpstreamOIDCCustomSessionData()),
 			},
+			wantUpstreamOIDCRefreshCall: &oidctestutil.RefreshArgs{RefreshToken: oidcUpstreamInitialRefreshToken},
+		},
+		{
+			name:             "when the upstream refresh fails",
+			oidcUpstream:     upstreamOIDCIdentityProviderReturning(nil, errors.New("some upstream refresh error")),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(initialUpstreamOIDCCustomSessionData()),
 			refreshRequest: refreshRequestInputs{
 				want: tokenEndpointResponseExpectedValues{
-					wantStatus:            http.StatusOK,
-					wantSuccessBodyFields: []string{"refresh_token", "access_token", "token_type", "expires_in", "scope"},
-					wantRequestedScopes:   []string{"offline_access"},
-					wantGrantedScopes:     []string{"offline_access"},
+					wantStatus:            http.StatusBadRequest,
+					wantErrorResponseBody: upstreamRefreshErrorBody,
 				}},
+			wantUpstreamOIDCRefreshCall: &oidctestutil.RefreshArgs{RefreshToken: oidcUpstreamInitialRefreshToken},
 		},
 		{
-			name: "when the refresh request adds a new scope to the list of requested scopes then it is ignored",
-			authcodeExchange: authcodeExchangeInputs{
-				modifyAuthRequest: func(r *http.Request) { r.Form.Set("scope", "openid offline_access") },
+			name:             "when the upstream refresh returns a different subject",
+			oidcUpstream:     upstreamOIDCIdentityProviderReturningClaims(map[string]interface{}{"iss": oidcUpstreamIssuer, "sub": "some-other-subject", oidcUpstreamUsernameClaim: goodUsername}),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(initialUpstreamOIDCCustomSessionData()),
+			refreshRequest: refreshRequestInputs{
 				want: tokenEndpointResponseExpectedValues{
-					wantStatus:            http.StatusOK,
-					wantSuccessBodyFields: []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
-					wantRequestedScopes:   []string{"openid", "offline_access"},
-					wantGrantedScopes:     []string{"openid", "offline_access"},
-				},
+					wantStatus:            http.StatusBadRequest,
+					wantErrorResponseBody: upstreamRefreshErrorBody,
+				}},
+			wantUpstreamOIDCRefreshCall: &oidctestutil.RefreshArgs{RefreshToken: oidcUpstreamInitialRefreshToken},
+		},
+		{
+			name:             "when the upstream refresh returns claims which are missing the username claim",
+			oidcUpstream:     upstreamOIDCIdentityProviderReturningClaims(map[string]interface{}{"iss": oidcUpstreamIssuer, "sub": oidcUpstreamSubject}),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(initialUpstreamOIDCCustomSessionData()),
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:            http.StatusBadRequest,
+					wantErrorResponseBody: upstreamRefreshErrorBody,
+				}},
+			wantUpstreamOIDCRefreshCall: &oidctestutil.RefreshArgs{RefreshToken: oidcUpstreamInitialRefreshToken},
+		},
+		{
+			name:             "when the upstream OIDC provider no longer exists",
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(initialUpstreamOIDCCustomSessionData()),
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:            http.StatusBadRequest,
+					wantErrorResponseBody: upstreamRefreshErrorBody,
+				}},
+		},
+		{
+			name:             "when the session has no upstream refresh token because the upstream did not return one during login",
+			oidcUpstream:     happyUpstreamOIDCIdentityProvider(),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(upstreamOIDCCustomSessionDataWithRefreshToken("")),
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:            http.StatusBadRequest,
+					wantErrorResponseBody: missingUpstreamSessionDataErrorBody,
+				}},
+		},
+		{
+			name:             "when the session has no custom session data",
+			oidcUpstream:     happyUpstreamOIDCIdentityProvider(),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(nil),
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:            http.StatusBadRequest,
+					wantErrorResponseBody: missingUpstreamSessionDataErrorBody,
+				}},
+		},
+		{
+			name:             "happy path refresh grant for an LDAP upstream",
+			ldapUpstream:     happyUpstreamLDAPIdentityProvider(),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(happyLDAPCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want: happyTokenResponseForOpenIDAndOfflineAccess(happyLDAPCustomSessionData),
 			},
+			wantUpstreamLDAPRefreshCall: &oidctestutil.RefreshArgs{Username: ldapUpstreamUsername},
+		},
+		{
+			name: "when the LDAP upstream returns a new username and new groups then they are used in the refreshed tokens",
+			ldapUpstream: upstreamLDAPIdentityProviderReturning(&authenticator.Response{
+				User: &user.DefaultInfo{Name: "some-new-username", UID: ldapUpstreamUID, Groups: []string{"new-group"}},
+			}, true, nil),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(happyLDAPCustomSessionData),
 			refreshRequest: refreshRequestInputs{
-				modifyTokenRequest: func(r *http.Request, refreshToken string, accessToken string) {
-					r.Body = happyRefreshRequestBody(refreshToken).WithScope("openid some-other-scope-not-from-auth-request").ReadCloser()
-				},
 				want: tokenEndpointResponseExpectedValues{
-					wantStatus:            http.StatusOK,
-					wantSuccessBodyFields: []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
-					wantRequestedScopes:   []string{"openid", "offline_access"},
-					wantGrantedScopes:     []string{"openid", "offline_access"},
+					wantStatus:                  http.StatusOK,
+					wantSuccessBodyFields:       []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
+					wantRequestedScopes:         []string{"openid", "offline_access"},
+					wantGrantedScopes:           []string{"openid", "offline_access"},
+					wantUsername:                "some-new-username",
+					wantGroups:                  []string{"new-group"},
+					wantCustomSessionDataStored: happyLDAPCustomSessionData,
 				}},
+			wantUpstreamLDAPRefreshCall: &oidctestutil.RefreshArgs{Username: ldapUpstreamUsername},
 		},
 		{
-			name: "when the refresh request removes a scope which was originally granted from the list of requested scopes then it is granted anyway",
-			authcodeExchange: authcodeExchangeInputs{
-				modifyAuthRequest: func(r *http.Request) { r.Form.Set("scope", "openid offline_access pinniped:request-audience") },
+			name:             "when the LDAP upstream has identity transforms then they are applied to the refreshed identity",
+			ldapUpstream:     happyUpstreamLDAPIdentityProvider(),
+			ldapTransforms:   []idtransform.Transform{{Type: idtransform.TypePrefix, Target: idtransform.TargetGroups, Prefix: "ldap:"}},
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(happyLDAPCustomSessionData),
+			refreshRequest: refreshRequestInputs{
 				want: tokenEndpointResponseExpectedValues{
-					wantStatus:            http.StatusOK,
-					wantSuccessBodyFields: []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
-					wantRequestedScopes:   []string{"openid", "offline_access", "pinniped:request-audience"},
-					wantGrantedScopes:     []string{"openid", "offline_access", "pinniped:request-audience"},
-				},
+					wantStatus:                  http.StatusOK,
+					wantSuccessBodyFields:       []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
+					wantRequestedScopes:         []string{"openid", "offline_access"},
+					wantGrantedScopes:           []string{"openid", "offline_access"},
+					wantUsername:                goodUsername,
+					wantGroups:                  []string{"ldap:" + goodGroups[0], "ldap:" + goodGroups[1]},
+					wantCustomSessionDataStored: happyLDAPCustomSessionData,
+				}},
+			wantUpstreamLDAPRefreshCall: &oidctestutil.RefreshArgs{Username: ldapUpstreamUsername},
+		},
+		{
+			name:             "when the LDAP upstream has identity transforms which now deny the login",
+			ldapUpstream:     happyUpstreamLDAPIdentityProvider(),
+			ldapTransforms:   []idtransform.Transform{{Type: idtransform.TypeDenyLogin, Regex: "^" + goodUsername + "$"}},
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(happyLDAPCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:            http.StatusBadRequest,
+					wantErrorResponseBody: upstreamRefreshErrorBody,
+				}},
+			wantUpstreamLDAPRefreshCall: &oidctestutil.RefreshArgs{Username: ldapUpstreamUsername},
+		},
+		{
+			name:             "when the LDAP user can no longer be found",
+			ldapUpstream:     upstreamLDAPIdentityProviderReturning(nil, false, nil),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(happyLDAPCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:            http.StatusBadRequest,
+					wantErrorResponseBody: upstreamRefreshErrorBody,
+				}},
+			wantUpstreamLDAPRefreshCall: &oidctestutil.RefreshArgs{Username: ldapUpstreamUsername},
+		},
+		{
+			name:             "when the LDAP user search fails",
+			ldapUpstream:     upstreamLDAPIdentityProviderReturning(nil, false, errors.New("some LDAP error")),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(happyLDAPCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:            http.StatusBadRequest,
+					wantErrorResponseBody: upstreamRefreshErrorBody,
+				}},
+			wantUpstreamLDAPRefreshCall: &oidctestutil.RefreshArgs{Username: ldapUpstreamUsername},
+		},
+		{
+			name: "when the LDAP user now has a different UID",
+			ldapUpstream: upstreamLDAPIdentityProviderReturning(&authenticator.Response{
+				User: &user.DefaultInfo{Name: goodUsername, UID: "some-other-uid", Groups: goodGroups},
+			}, true, nil),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(happyLDAPCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:            http.StatusBadRequest,
+					wantErrorResponseBody: upstreamRefreshErrorBody,
+				}},
+			wantUpstreamLDAPRefreshCall: &oidctestutil.RefreshArgs{Username: ldapUpstreamUsername},
+		},
+		{
+			name:             "happy path refresh grant for an Active Directory upstream",
+			adUpstream:       happyUpstreamLDAPIdentityProvider(),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(happyActiveDirectoryCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want: happyTokenResponseForOpenIDAndOfflineAccess(happyActiveDirectoryCustomSessionData),
 			},
+			wantUpstreamADRefreshCall: &oidctestutil.RefreshArgs{Username: ldapUpstreamUsername},
+		},
+		{
+			name:             "when the Active Directory upstream has identity transforms then they are applied to the refreshed identity",
+			adUpstream:       happyUpstreamLDAPIdentityProvider(),
+			ldapTransforms:   []idtransform.Transform{{Type: idtransform.TypeDenyLogin, Regex: "^" + goodUsername + "$"}},
+			adTransforms:     []idtransform.Transform{{Type: idtransform.TypePrefix, Target: idtransform.TargetGroups, Prefix: "ad:"}},
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(happyActiveDirectoryCustomSessionData),
 			refreshRequest: refreshRequestInputs{
-				modifyTokenRequest: func(r *http.Request, refreshToken string, accessToken string) {
-					r.Body = happyRefreshRequestBody(refreshToken).WithScope("openid").ReadCloser() // do not ask for "pinniped:request-audience" again
-				},
 				want: tokenEndpointResponseExpectedValues{
-					wantStatus:            http.StatusOK,
-					wantSuccessBodyFields: []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
-					wantRequestedScopes:   []string{"openid", "offline_access", "pinniped:request-audience"},
-					wantGrantedScopes:     []string{"openid", "offline_access", "pinniped:request-audience"},
+					wantStatus:                  http.StatusOK,
+					wantSuccessBodyFields:       []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
+					wantRequestedScopes:         []string{"openid", "offline_access"},
+					wantGrantedScopes:           []string{"openid", "offline_access"},
+					wantUsername:                goodUsername,
+					wantGroups:                  []string{"ad:" + goodGroups[0], "ad:" + goodGroups[1]},
+					wantCustomSessionDataStored: happyActiveDirectoryCustomSessionData,
+				}},
+			wantUpstreamADRefreshCall: &oidctestutil.RefreshArgs{Username: ldapUpstreamUsername},
+		},
+		{
+			name:             "when the Active Directory user can no longer be found, e.g. because their account was disabled",
+			adUpstream:       upstreamLDAPIdentityProviderReturning(nil, false, nil),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(happyActiveDirectoryCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:            http.StatusBadRequest,
+					wantErrorResponseBody: upstreamRefreshErrorBody,
 				}},
+			wantUpstreamADRefreshCall: &oidctestutil.RefreshArgs{Username: ldapUpstreamUsername},
 		},
 		{
-			name: "when the refresh request does not include a scope param then it gets all the same scopes as the original authorization request",
+			name:             "when the Active Directory upstream no longer exists but an LDAP upstream has the same name",
+			ldapUpstream:     happyUpstreamLDAPIdentityProvider(),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(happyActiveDirectoryCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:            http.StatusBadRequest,
+					wantErrorResponseBody: upstreamRefreshErrorBody,
+				}},
+		},
+		{
+			name:       "when the session for an Active Directory upstream has no Active Directory session data",
+			adUpstream: happyUpstreamLDAPIdentityProvider(),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(&psession.CustomSessionData{
+				ProviderName: ldapUpstreamName,
+				ProviderType: psession.ProviderTypeActiveDirectory,
+				LDAP:         &psession.LDAPSessionData{Username: ldapUpstreamUsername},
+			}),
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:            http.StatusBadRequest,
+					wantErrorResponseBody: missingUpstreamSessionDataErrorBody,
+				}},
+		},
+		{
+			name:             "happy path refresh grant for a GitHub upstream",
+			gitHubUpstream:   happyUpstreamGitHubIdentityProvider(),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(happyGitHubCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want: happyTokenResponseForOpenIDAndOfflineAccess(happyGitHubCustomSessionData),
+			},
+			wantUpstreamGitHubValidateTokenCall: &oidctestutil.ValidateTokenArgs{Tok: &xoauth2.Token{AccessToken: gitHubUpstreamAccessToken}},
+		},
+		{
+			name:             "when the GitHub access token is no longer valid, e.g. because the user revoked the authorization of the app",
+			gitHubUpstream:   upstreamGitHubIdentityProviderReturning(nil, errors.New("some validation error")),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(happyGitHubCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:            http.StatusBadRequest,
+					wantErrorResponseBody: upstreamRefreshErrorBody,
+				}},
+			wantUpstreamGitHubValidateTokenCall: &oidctestutil.ValidateTokenArgs{Tok: &xoauth2.Token{AccessToken: gitHubUpstreamAccessToken}},
+		},
+		{
+			name:             "when the GitHub upstream no longer exists but an OIDC upstream has the same name",
+			oidcUpstream:     happyUpstreamOIDCIdentityProvider(),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(happyGitHubCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:            http.StatusBadRequest,
+					wantErrorResponseBody: upstreamRefreshErrorBody,
+				}},
+		},
+		{
+			name:           "when the session for a GitHub upstream has no GitHub session data",
+			gitHubUpstream: happyUpstreamGitHubIdentityProvider(),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(&psession.CustomSessionData{
+				ProviderName: oidcUpstreamName,
+				ProviderType: psession.ProviderTypeGitHub,
+			}),
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:            http.StatusBadRequest,
+					wantErrorResponseBody: missingUpstreamSessionDataErrorBody,
+				}},
+		},
+		{
+			name:             "happy path refresh grant for a SAML upstream keeps the identity from the login",
+			samlUpstream:     happyUpstreamSAMLIdentityProvider(),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(happySAMLCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want: happyTokenResponseForOpenIDAndOfflineAccess(happySAMLCustomSessionData),
+			},
+		},
+		{
+			name:             "when the SAML upstream has identity transforms then they are applied to the identity from the login",
+			samlUpstream:     happyUpstreamSAMLIdentityProvider(),
+			samlTransforms:   []idtransform.Transform{{Type: idtransform.TypePrefix, Target: idtransform.TargetGroups, Prefix: "saml:"}},
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(happySAMLCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:                  http.StatusOK,
+					wantSuccessBodyFields:       []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
+					wantRequestedScopes:         []string{"openid", "offline_access"},
+					wantGrantedScopes:           []string{"openid", "offline_access"},
+					wantUsername:                goodUsername,
+					wantGroups:                  []string{"saml:" + goodGroups[0], "saml:" + goodGroups[1]},
+					wantCustomSessionDataStored: happySAMLCustomSessionData,
+				}},
+		},
+		{
+			name:             "when the SAML upstream has identity transforms which now deny the login",
+			samlUpstream:     happyUpstreamSAMLIdentityProvider(),
+			samlTransforms:   []idtransform.Transform{{Type: idtransform.TypeDenyLogin, Regex: "^" + goodUsername + "$"}},
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(happySAMLCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:            http.StatusBadRequest,
+					wantErrorResponseBody: upstreamRefreshErrorBody,
+				}},
+		},
+		{
+			name:             "when the SAML upstream no longer exists",
+			oidcUpstream:     happyUpstreamOIDCIdentityProvider(),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(happySAMLCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:            http.StatusBadRequest,
+					wantErrorResponseBody: upstreamRefreshErrorBody,
+				}},
+		},
+		{
+			name: "when the SAML upstream now has a different entity ID",
+			samlUpstream: &oidctestutil.TestUpstreamSAMLIdentityProvider{
+				Name:     samlUpstreamName,
+				EntityID: "https://some-other-entity-id",
+			},
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(happySAMLCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:            http.StatusBadRequest,
+					wantErrorResponseBody: upstreamRefreshErrorBody,
+				}},
+		},
+		{
+			name:         "when the session for a SAML upstream has no SAML session data",
+			samlUpstream: happyUpstreamSAMLIdentityProvider(),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(&psession.CustomSessionData{
+				ProviderName: samlUpstreamName,
+				ProviderType: psession.ProviderTypeSAML,
+			}),
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:            http.StatusBadRequest,
+					wantErrorResponseBody: missingUpstreamSessionDataErrorBody,
+				}},
+		},
+		{
+			name:             "when the refresh request adds a new scope to the list of requested scopes then it is ignored",
+			oidcUpstream:     happyUpstreamOIDCIdentityProvider(),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(initialUpstreamOIDCCustomSessionData()),
+			refreshRequest: refreshRequestInputs{
+				modifyTokenRequest: func(r *http.Request, refreshToken string, accessToken string) {
+					r.Body = happyRefreshRequestBody(refreshToken).WithScope("openid some-other-scope-not-from-auth-request").ReadCloser()
+				},
+				want: happyTokenResponseForOpenIDAndOfflineAccess(
+					upstreamOIDCCustomSessionDataWithRefreshToken(oidcUpstreamRefreshedRefreshToken),
+				),
+			},
+			wantUpstreamOIDCRefreshCall: &oidctestutil.RefreshArgs{RefreshToken: oidcUpstreamInitialRefreshToken},
+		},
+		{
+			name:         "when the refresh request removes a scope which was originally granted from the list of requested scopes then it is granted anyway",
+			oidcUpstream: happyUpstreamOIDCIdentityProvider(),
 			authcodeExchange: authcodeExchangeInputs{
-				modifyAuthRequest: func(r *http.Request) { r.Form.Set("scope", "openid offline_access") },
+				modifyAuthRequest: func(r *http.Request) { r.Form.Set("scope", "openid offline_access pinniped:request-audience") },
+				customSessionData: initialUpstreamOIDCCustomSessionData(),
 				want: tokenEndpointResponseExpectedValues{
-					wantStatus:            http.StatusOK,
-					wantSuccessBodyFields: []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
-					wantRequestedScopes:   []string{"openid", "offline_access"},
-					wantGrantedScopes:     []string{"openid", "offline_access"},
+					wantStatus:                  http.StatusOK,
+					wantSuccessBodyFields:       []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
+					wantRequestedScopes:         []string{"openid", "offline_access", "pinniped:request-audience"},
+					wantGrantedScopes:           []string{"openid", "offline_access", "pinniped:request-audience"},
+					wantUsername:                goodUsername,
+					wantGroups:                  goodGroups,
+					wantCustomSessionDataStored: initialUpstreamOIDCCustomSessionData(),
 				},
 			},
 			refreshRequest: refreshRequestInputs{
 				modifyTokenRequest: func(r *http.Request, refreshToken string, accessToken string) {
-					r.Body = happyRefreshRequestBody(refreshToken).WithScope("").ReadCloser()
+					r.Body = happyRefreshRequestBody(refreshToken).WithScope("openid").ReadCloser() // do not ask for "pinniped:request-audience" again
 				},
 				want: tokenEndpointResponseExpectedValues{
-					wantStatus:            http.StatusOK,
-					wantSuccessBodyFields: []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
-					wantRequestedScopes:   []string{"openid", "offline_access"},
-					wantGrantedScopes:     []string{"openid", "offline_access"},
+					wantStatus:                  http.StatusOK,
+					wantSuccessBodyFields:       []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
+					wantRequestedScopes:         []string{"openid", "offline_access", "pinniped:request-audience"},
+					wantGrantedScopes:           []string{"openid", "offline_access", "pinniped:request-audience"},
+					wantUsername:                goodUsername,
+					wantGroups:                  goodGroups,
+					wantCustomSessionDataStored: upstreamOIDCCustomSessionDataWithRefreshToken(oidcUpstreamRefreshedRefreshToken),
 				}},
+			wantUpstreamOIDCRefreshCall: &oidctestutil.RefreshArgs{RefreshToken: oidcUpstreamInitialRefreshToken},
 		},
 		{
-			name: "when a bad refresh token is sent in the refresh request",
-			authcodeExchange: authcodeExchangeInputs{
-				modifyAuthRequest: func(r *http.Request) { r.Form.Set("scope", "offline_access") },
-				want: tokenEndpointResponseExpectedValues{
-					wantStatus:            http.StatusOK,
-					wantSuccessBodyFields: []string{"refresh_token", "access_token", "token_type", "expires_in", "scope"},
-					wantRequestedScopes:   []string{"offline_access"},
-					wantGrantedScopes:     []string{"offline_access"},
+			name:             "when the refresh request does not include a scope param then it gets all the same scopes as the original authorization request",
+			oidcUpstream:     happyUpstreamOIDCIdentityProvider(),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(initialUpstreamOIDCCustomSessionData()),
+			refreshRequest: refreshRequestInputs{
+				modifyTokenRequest: func(r *http.Request, refreshToken string, accessToken string) {
+					r.Body = happyRefreshRequestBody(refreshToken).WithScope("").ReadCloser()
 				},
+				want: happyTokenResponseForOpenIDAndOfflineAccess(
+					upstreamOIDCCustomSessionDataWithRefreshToken(oidcUpstreamRefreshedRefreshToken),
+				),
 			},
+			wantUpstreamOIDCRefreshCall: &oidctestutil.RefreshArgs{RefreshToken: oidcUpstreamInitialRefreshToken},
+		},
+		{
+			name:             "when a bad refresh token is sent in the refresh request",
+			oidcUpstream:     happyUpstreamOIDCIdentityProvider(),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(initialUpstreamOIDCCustomSessionData()),
 			refreshRequest: refreshRequestInputs{
 				modifyTokenRequest: func(r *http.Request, refreshToken string, accessToken string) {
 					r.Body = happyRefreshRequestBody(refreshToken).WithRefreshToken("bad refresh token").ReadCloser()
@@ -973,16 +1564,9 @@ func TestRefreshGrant(t *testing.T) {
 				}},
 		},
 		{
-			name: "when the access token is sent as if it were a refresh token",
-			authcodeExchange: authcodeExchangeInputs{
-				modifyAuthRequest: func(r *http.Request) { r.Form.Set("scope", "offline_access") },
-				want: tokenEndpointResponseExpectedValues{
-					wantStatus:            http.StatusOK,
-					wantSuccessBodyFields: []string{"refresh_token", "access_token", "token_type", "expires_in", "scope"},
-					wantRequestedScopes:   []string{"offline_access"},
-					wantGrantedScopes:     []string{"offline_access"},
-				},
-			},
+			name:             "when the access token is sent as if it were a refresh token",
+			oidcUpstream:     happyUpstreamOIDCIdentityProvider(),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(initialUpstreamOIDCCustomSessionData()),
 			refreshRequest: refreshRequestInputs{
 				modifyTokenRequest: func(r *http.Request, refreshToken string, accessToken string) {
 					r.Body = happyRefreshRequestBody(refreshToken).WithRefreshToken(accessToken).ReadCloser()
@@ -993,16 +1577,9 @@ func TestRefreshGrant(t *testing.T) {
 				}},
 		},
 		{
-			name: "when the wrong client ID is included in the refresh request",
-			authcodeExchange: authcodeExchangeInputs{
-				modifyAuthRequest: func(r *http.Request) { r.Form.Set("scope", "offline_access") },
-				want: tokenEndpointResponseExpectedValues{
-					wantStatus:            http.StatusOK,
-					wantSuccessBodyFields: []string{"refresh_token", "access_token", "token_type", "expires_in", "scope"},
-					wantRequestedScopes:   []string{"offline_access"},
-					wantGrantedScopes:     []string{"offline_access"},
-				},
-			},
+			name:             "when the wrong client ID is included in the refresh request",
+			oidcUpstream:     happyUpstreamOIDCIdentityProvider(),
+			authcodeExchange: happyAuthcodeExchangeInputsForUpstream(initialUpstreamOIDCCustomSessionData()),
 			refreshRequest: refreshRequestInputs{
 				modifyTokenRequest: func(r *http.Request, refreshToken string, accessToken string) {
 					r.Body = happyRefreshRequestBody(refreshToken).WithClientID("wrong-client-id").ReadCloser()
@@ -1018,8 +1595,40 @@ func TestRefreshGrant(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
 
+			idpListerBuilder := oidctestutil.NewUpstreamIDPListerBuilder()
+			if test.oidcUpstream != nil {
+				idpListerBuilder.WithOIDC(test.oidcUpstream)
+			}
+			if test.ldapUpstream != nil {
+				idpListerBuilder.WithLDAP(test.ldapUpstream)
+			}
+			if test.ldapTransforms != nil {
+				transforms, err := idtransform.NewPipeline(test.ldapTransforms)
+				require.NoError(t, err)
+				idpListerBuilder.WithIdentityTransforms(ldapUpstreamName, "ldap", transforms)
+			}
+			if test.adUpstream != nil {
+				idpListerBuilder.WithActiveDirectory(test.adUpstream)
+			}
+			if test.adTransforms != nil {
+				transforms, err := idtransform.NewPipeline(test.adTransforms)
+				require.NoError(t, err)
+				idpListerBuilder.WithIdentityTransforms(ldapUpstreamName, "activedirectory", transforms)
+			}
+			if test.gitHubUpstream != nil {
+				idpListerBuilder.WithGitHub(test.gitHubUpstream)
+			}
+			if test.samlUpstream != nil {
+				idpListerBuilder.WithSAML(test.samlUpstream)
+			}
+			if test.samlTransforms != nil {
+				transforms, err := idtransform.NewPipeline(test.samlTransforms)
+				require.NoError(t, err)
+				idpListerBuilder.WithIdentityTransforms(samlUpstreamName, "saml", transforms)
+			}
+
 			// First exchange the authcode for tokens, including a refresh token.
-			subject, rsp, authCode, jwtSigningKey, secrets, oauthStore := exchangeAuthcodeForTokens(t, test.authcodeExchange)
+			subject, rsp, authCode, jwtSigningKey, secrets, oauthStore := exchangeAuthcodeForTokens(t, test.authcodeExchange, idpListerBuilder.Build())
 			var parsedAuthcodeExchangeResponseBody map[string]interface{}
 			require.NoError(t, json.Unmarshal(rsp.Body.Bytes(), &parsedAuthcodeExchangeResponseBody))
 
@@ -1043,11 +1652,49 @@ func TestRefreshGrant(t *testing.T) {
 			t.Logf("second response: %#v", refreshResponse)
 			t.Logf("second response body: %q", refreshResponse.Body.String())
 
+			// Check which upstream refresh calls were made, if any.
+			if test.oidcUpstream != nil {
+				if test.wantUpstreamOIDCRefreshCall != nil {
+					require.Equal(t, 1, test.oidcUpstream.RefreshCallCount())
+					test.wantUpstreamOIDCRefreshCall.Ctx = req.Context()
+					require.Equal(t, test.wantUpstreamOIDCRefreshCall, test.oidcUpstream.RefreshArgs(0))
+				} else {
+					require.Equal(t, 0, test.oidcUpstream.RefreshCallCount())
+				}
+			}
+			if test.ldapUpstream != nil {
+				if test.wantUpstreamLDAPRefreshCall != nil {
+					require.Equal(t, 1, test.ldapUpstream.RefreshUserCallCount())
+					test.wantUpstreamLDAPRefreshCall.Ctx = req.Context()
+					require.Equal(t, test.wantUpstreamLDAPRefreshCall, test.ldapUpstream.RefreshUserArgs(0))
+				} else {
+					require.Equal(t, 0, test.ldapUpstream.RefreshUserCallCount())
+				}
+			}
+			if test.adUpstream != nil {
+				if test.wantUpstreamADRefreshCall != nil {
+					require.Equal(t, 1, test.adUpstream.RefreshUserCallCount())
+					test.wantUpstreamADRefreshCall.Ctx = req.Context()
+					require.Equal(t, test.wantUpstreamADRefreshCall, test.adUpstream.RefreshUserArgs(0))
+				} else {
+					require.Equal(t, 0, test.adUpstream.RefreshUserCallCount())
+				}
+			}
+			if test.gitHubUpstream != nil {
+				if test.wantUpstreamGitHubValidateTokenCall != nil {
+					require.Equal(t, 1, test.gitHubUpstream.ValidateTokenCallCount())
+					test.wantUpstreamGitHubValidateTokenCall.Ctx = req.Context()
+					require.Equal(t, test.wantUpstreamGitHubValidateTokenCall, test.gitHubUpstream.ValidateTokenArgs(0))
+				} else {
+					require.Equal(t, 0, test.gitHubUpstream.ValidateTokenCallCount())
+				}
+			}
+
 			// The bug in fosite that prevents at_hash from appearing in the initial ID token does not impact the refreshed ID token
 			wantAtHashClaimInIDToken := true
 			// Refreshed ID tokens do not include the nonce from the original auth request
 			wantNonceValueInIDToken := false
-			requireTokenEndpointBehavior(t, test.refreshRequest.want, wantAtHashClaimInIDToken, wantNonceValueInIDToken, refreshResponse, authCode, oauthStore, jwtSigningKey, secrets)
+			requireTokenEndpointBehavior(t, test.refreshRequest.want, test.authcodeExchange.customSessionData, wantAtHashClaimInIDToken, wantNonceValueInIDToken, refreshResponse, authCode, oauthStore, jwtSigningKey, secrets)
 
 			if test.refreshRequest.want.wantStatus == http.StatusOK {
 				wantIDToken := contains(test.refreshRequest.want.wantSuccessBodyFields, "id_token")
@@ -1095,6 +1742,224 @@ func TestRefreshGrant(t *testing.T) {
 	}
 }
 
+func TestDeviceCodeGrant(t *testing.T) {
+	const (
+		deviceCode = "some-device-code"
+		userCode   = "WDJB-MJHT"
+	)
+
+	type deviceCodeSession struct {
+		status       devicecode.Status
+		expiresAt    time.Time
+		lastPolledAt time.Time
+		clientID     string
+	}
+
+	pendingSession := deviceCodeSession{
+		status:    devicecode.StatusPending,
+		expiresAt: time.Now().Add(5 * time.Minute),
+	}
+	approvedSession := deviceCodeSession{
+		status:    devicecode.StatusApproved,
+		expiresAt: time.Now().Add(5 * time.Minute),
+	}
+
+	tests := []struct {
+		name            string
+		session         *deviceCodeSession
+		requestedScopes []string
+		modifyParams    func(params url.Values)
+
+		wantStatus            int
+		wantErrorType         string
+		wantSuccessBodyFields []string
+		wantGrantedScopes     string
+		wantSessionDeleted    bool
+	}{
+		{
+			name:                  "approved with openid and offline_access",
+			session:               &approvedSession,
+			requestedScopes:       []string{"openid", "offline_access"},
+			wantStatus:            http.StatusOK,
+			wantSuccessBodyFields: []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
+			wantGrantedScopes:     "openid offline_access",
+			wantSessionDeleted:    true,
+		},
+		{
+			name:                  "approved without offline_access",
+			session:               &approvedSession,
+			requestedScopes:       []string{"openid"},
+			wantStatus:            http.StatusOK,
+			wantSuccessBodyFields: []string{"id_token", "access_token", "token_type", "expires_in", "scope"},
+			wantGrantedScopes:     "openid",
+			wantSessionDeleted:    true,
+		},
+		{
+			name:                  "approved without openid",
+			session:               &approvedSession,
+			requestedScopes:       []string{"offline_access"},
+			wantStatus:            http.StatusOK,
+			wantSuccessBodyFields: []string{"refresh_token", "access_token", "token_type", "expires_in", "scope"},
+			wantGrantedScopes:     "offline_access",
+			wantSessionDeleted:    true,
+		},
+		{
+			name:            "pending",
+			session:         &pendingSession,
+			requestedScopes: []string{"openid"},
+			wantStatus:      http.StatusBadRequest,
+			wantErrorType:   "authorization_pending",
+		},
+		{
+			name: "pending and polled too recently",
+			session: &deviceCodeSession{
+				status:       devicecode.StatusPending,
+				expiresAt:    time.Now().Add(5 * time.Minute),
+				lastPolledAt: time.Now().Add(-time.Second),
+			},
+			requestedScopes: []string{"openid"},
+			wantStatus:      http.StatusBadRequest,
+			wantErrorType:   "slow_down",
+		},
+		{
+			name: "denied",
+			session: &deviceCodeSession{
+				status:    devicecode.StatusDenied,
+				expiresAt: time.Now().Add(5 * time.Minute),
+			},
+			requestedScopes: []string{"openid"},
+			wantStatus:      http.StatusForbidden,
+			wantErrorType:   "access_denied",
+		},
+		{
+			name: "expired",
+			session: &deviceCodeSession{
+				status:    devicecode.StatusApproved,
+				expiresAt: time.Now().Add(-time.Second),
+			},
+			requestedScopes: []string{"openid"},
+			wantStatus:      http.StatusBadRequest,
+			wantErrorType:   "expired_token",
+		},
+		{
+			name: "issued to a different client",
+			session: &deviceCodeSession{
+				status:    devicecode.StatusApproved,
+				expiresAt: time.Now().Add(5 * time.Minute),
+				clientID:  "some-other-client",
+			},
+			requestedScopes: []string{"openid"},
+			wantStatus:      http.StatusBadRequest,
+			wantErrorType:   "invalid_grant",
+		},
+		{
+			name:          "unknown device code",
+			wantStatus:    http.StatusBadRequest,
+			wantErrorType: "invalid_grant",
+		},
+		{
+			name:            "missing device code",
+			session:         &approvedSession,
+			requestedScopes: []string{"openid"},
+			modifyParams:    func(params url.Values) { params.Del("device_code") },
+			wantStatus:      http.StatusBadRequest,
+			wantErrorType:   "invalid_request",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			secrets := client.CoreV1().Secrets("some-namespace")
+			oauthStore := oidc.NewKubeStorage(secrets, oidctestutil.NewClientManager("some-namespace"), oidc.DefaultOIDCTimeoutsConfiguration())
+			_, jwkProvider := generateJWTSigningKeyAndJWKSProvider(t, goodIssuer)
+			oauthHelper := oidc.FositeOauth2Helper(oauthStore, goodIssuer, hmacSecretFunc, jwkProvider, oidc.DefaultOIDCTimeoutsConfiguration())
+			subject := NewHandler(goodIssuer, oidctestutil.NewUpstreamIDPListerBuilder().Build(), oauthHelper)
+
+			signature := oidc.DeviceCodeSignature(deviceCode)
+			if test.session != nil {
+				// Simulate the device authorization endpoint and the callback endpoint having already run.
+				request := fosite.NewRequest()
+				deviceClient := clientregistry.PinnipedCLI()
+				if test.session.clientID != "" {
+					deviceClient.ID = test.session.clientID
+				}
+				request.Client = deviceClient
+				request.RequestedScope = test.requestedScopes
+				request.Session = psession.NewPinnipedSession()
+				require.NoError(t, oauthStore.CreateDeviceCodeSession(context.Background(), signature, userCode, test.session.expiresAt, request))
+
+				_, session, err := oauthStore.GetDeviceCodeSessionByUserCode(context.Background(), userCode)
+				require.NoError(t, err)
+				session.Status = test.session.status
+				session.LastPolledAt = test.session.lastPolledAt
+				if test.session.status == devicecode.StatusApproved {
+					session.Request.Session = &psession.PinnipedSession{
+						DefaultSession: openid.DefaultSession{
+							Claims: &jwt.IDTokenClaims{
+								Subject:     goodSubject,
+								RequestedAt: goodRequestedAtTime,
+								AuthTime:    goodAuthTime,
+								Extra: map[string]interface{}{
+									oidc.DownstreamUsernameClaim: goodUsername,
+									oidc.DownstreamGroupsClaim:   goodGroups,
+								},
+							},
+						},
+					}
+					for _, scope := range test.requestedScopes {
+						session.Request.GrantScope(scope)
+					}
+				}
+				require.NoError(t, oauthStore.UpdateDeviceCodeSession(context.Background(), signature, session))
+			}
+
+			params := url.Values{
+				"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+				"device_code": {deviceCode},
+				"client_id":   {goodClient},
+			}
+			if test.modifyParams != nil {
+				test.modifyParams(params)
+			}
+			req := httptest.NewRequest("POST", "/path/shouldn't/matter", strings.NewReader(params.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rsp := httptest.NewRecorder()
+			subject.ServeHTTP(rsp, req)
+			t.Logf("response: %#v", rsp)
+			t.Logf("response body: %q", rsp.Body.String())
+
+			require.Equal(t, test.wantStatus, rsp.Code)
+			testutil.RequireEqualContentType(t, rsp.Header().Get("Content-Type"), "application/json")
+
+			var parsedResponseBody map[string]interface{}
+			require.NoError(t, json.Unmarshal(rsp.Body.Bytes(), &parsedResponseBody))
+
+			if test.wantErrorType != "" {
+				require.Equal(t, test.wantErrorType, parsedResponseBody["error"])
+				return
+			}
+
+			require.ElementsMatch(t, test.wantSuccessBodyFields, getMapKeys(parsedResponseBody))
+			require.Equal(t, "bearer", parsedResponseBody["token_type"])
+			require.Equal(t, test.wantGrantedScopes, parsedResponseBody["scope"])
+			require.InDelta(t, accessTokenExpirationSeconds, parsedResponseBody["expires_in"], 2)
+
+			// The access token should be usable, so it must be in storage.
+			accessTokenSignature := getFositeDataSignature(t, parsedResponseBody["access_token"].(string))
+			accessTokenSession, err := oauthStore.GetAccessTokenSession(context.Background(), accessTokenSignature, psession.NewPinnipedSession())
+			require.NoError(t, err)
+			require.Equal(t, goodSubject, accessTokenSession.GetSession().(*psession.PinnipedSession).Claims.Subject)
+
+			// A device code can only be redeemed once.
+			if test.wantSessionDeleted {
+				_, err := oauthStore.GetDeviceCodeSession(context.Background(), signature)
+				require.True(t, errors.Is(err, fosite.ErrNotFound))
+			}
+		})
+	}
+}
+
 func requireClaimsAreNotEqual(t *testing.T, claimName string, claimsOfTokenA map[string]interface{}, claimsOfTokenB map[string]interface{}) {
 	require.NotEmpty(t, claimsOfTokenA[claimName])
 	require.NotEmpty(t, claimsOfTokenB[claimName])
@@ -1107,7 +1972,7 @@ func requireClaimsAreEqual(t *testing.T, claimName string, claimsOfTokenA map[st
 	require.Equal(t, claimsOfTokenA[claimName], claimsOfTokenB[claimName])
 }
 
-func exchangeAuthcodeForTokens(t *testing.T, test authcodeExchangeInputs) (
+func exchangeAuthcodeForTokens(t *testing.T, test authcodeExchangeInputs, idps *oidctestutil.UpstreamIDPLister) (
 	subject http.Handler,
 	rsp *httptest.ResponseRecorder,
 	authCode string,
@@ -1125,17 +1990,17 @@ func exchangeAuthcodeForTokens(t *testing.T, test authcodeExchangeInputs) (
 
 	var oauthHelper fosite.OAuth2Provider
 
-	oauthStore = oidc.NewKubeStorage(secrets, oidc.DefaultOIDCTimeoutsConfiguration())
+	oauthStore = oidc.NewKubeStorage(secrets, oidctestutil.NewClientManager("some-namespace"), oidc.DefaultOIDCTimeoutsConfiguration())
 	if test.makeOathHelper != nil {
-		oauthHelper, authCode, jwtSigningKey = test.makeOathHelper(t, authRequest, oauthStore)
+		oauthHelper, authCode, jwtSigningKey = test.makeOathHelper(t, authRequest, oauthStore, test.customSessionData)
 	} else {
-		oauthHelper, authCode, jwtSigningKey = makeHappyOauthHelper(t, authRequest, oauthStore)
+		oauthHelper, authCode, jwtSigningKey = makeHappyOauthHelper(t, authRequest, oauthStore, test.customSessionData)
 	}
 
 	if test.modifyStorage != nil {
 		test.modifyStorage(t, oauthStore, authCode)
 	}
-	subject = NewHandler(oauthHelper)
+	subject = NewHandler(goodIssuer, idps, oauthHelper)
 
 	authorizeEndpointGrantedOpenIDScope := strings.Contains(authRequest.Form.Get("scope"), "openid")
 	expectedNumberOfIDSessionsStored := 0
@@ -1161,7 +2026,7 @@ func exchangeAuthcodeForTokens(t *testing.T, test authcodeExchangeInputs) (
 
 	wantAtHashClaimInIDToken := false // due to a bug in fosite, the at_hash claim is not filled in during authcode exchange
 	wantNonceValueInIDToken := true   // ID tokens returned by the authcode exchange must include the nonce from the auth request (unliked refreshed ID tokens)
-	requireTokenEndpointBehavior(t, test.want, wantAtHashClaimInIDToken, wantNonceValueInIDToken, rsp, authCode, oauthStore, jwtSigningKey, secrets)
+	requireTokenEndpointBehavior(t, test.want, test.customSessionData, wantAtHashClaimInIDToken, wantNonceValueInIDToken, rsp, authCode, oauthStore, jwtSigningKey, secrets)
 
 	return subject, rsp, authCode, jwtSigningKey, secrets, oauthStore
 }
@@ -1169,6 +2034,7 @@ func exchangeAuthcodeForTokens(t *testing.T, test authcodeExchangeInputs) (
 func requireTokenEndpointBehavior(
 	t *testing.T,
 	test tokenEndpointResponseExpectedValues,
+	oldCustomSessionData *psession.CustomSessionData,
 	wantAtHashClaimInIDToken bool,
 	wantNonceValueInIDToken bool,
 	tokenEndpointResponse *httptest.ResponseRecorder,
@@ -1191,9 +2057,10 @@ func requireTokenEndpointBehavior(
 		wantRefreshToken := contains(test.wantSuccessBodyFields, "refresh_token")
 
 		requireInvalidAuthCodeStorage(t, authCode, oauthStore, secrets)
-		requireValidAccessTokenStorage(t, parsedResponseBody, oauthStore, test.wantRequestedScopes, test.wantGrantedScopes, secrets)
+		requireValidAccessTokenStorage(t, parsedResponseBody, oauthStore, test.wantRequestedScopes, test.wantGrantedScopes, test.wantUsername, test.wantGroups, test.wantCustomSessionDataStored, secrets)
 		requireInvalidPKCEStorage(t, authCode, oauthStore)
-		requireValidOIDCStorage(t, parsedResponseBody, authCode, oauthStore, test.wantRequestedScopes, test.wantGrantedScopes)
+		// Fosite never updates the OIDC session storage, so it still holds the session from the authorize endpoint.
+		requireValidOIDCStorage(t, parsedResponseBody, authCode, oauthStore, test.wantRequestedScopes, test.wantGrantedScopes, oldCustomSessionData)
 
 		expectedNumberOfRefreshTokenSessionsStored := 0
 		if wantRefreshToken {
@@ -1202,10 +2069,10 @@ func requireTokenEndpointBehavior(
 		expectedNumberOfIDSessionsStored := 0
 		if wantIDToken {
 			expectedNumberOfIDSessionsStored = 1
-			requireValidIDToken(t, parsedResponseBody, jwtSigningKey, wantAtHashClaimInIDToken, wantNonceValueInIDToken, parsedResponseBody["access_token"].(string))
+			requireValidIDToken(t, parsedResponseBody, jwtSigningKey, test.wantUsername, test.wantGroups, wantAtHashClaimInIDToken, wantNonceValueInIDToken, parsedResponseBody["access_token"].(string))
 		}
 		if wantRefreshToken {
-			requireValidRefreshTokenStorage(t, parsedResponseBody, oauthStore, test.wantRequestedScopes, test.wantGrantedScopes, secrets)
+			requireValidRefreshTokenStorage(t, parsedResponseBody, oauthStore, test.wantRequestedScopes, test.wantGrantedScopes, test.wantUsername, test.wantGroups, test.wantCustomSessionDataStored, secrets)
 		}
 
 		testutil.RequireNumberOfSecretsMatchingLabelSelector(t, secrets, labels.Set{crud.SecretLabelKey: authorizationcode.TypeLabelValue}, 1)
@@ -1306,12 +2173,13 @@ func makeHappyOauthHelper(
 	t *testing.T,
 	authRequest *http.Request,
 	store fositestoragei.AllFositeStorage,
+	initialCustomSessionData *psession.CustomSessionData,
 ) (fosite.OAuth2Provider, string, *ecdsa.PrivateKey) {
 	t.Helper()
 
 	jwtSigningKey, jwkProvider := generateJWTSigningKeyAndJWKSProvider(t, goodIssuer)
 	oauthHelper := oidc.FositeOauth2Helper(store, goodIssuer, hmacSecretFunc, jwkProvider, oidc.DefaultOIDCTimeoutsConfiguration())
-	authResponder := simulateAuthEndpointHavingAlreadyRun(t, authRequest, oauthHelper)
+	authResponder := simulateAuthEndpointHavingAlreadyRun(t, authRequest, oauthHelper, initialCustomSessionData)
 	return oauthHelper, authResponder.GetCode(), jwtSigningKey
 }
 
@@ -1332,12 +2200,13 @@ func makeOauthHelperWithJWTKeyThatWorksOnlyOnce(
 	t *testing.T,
 	authRequest *http.Request,
 	store fositestoragei.AllFositeStorage,
+	initialCustomSessionData *psession.CustomSessionData,
 ) (fosite.OAuth2Provider, string, *ecdsa.PrivateKey) {
 	t.Helper()
 
 	jwtSigningKey, jwkProvider := generateJWTSigningKeyAndJWKSProvider(t, goodIssuer)
 	oauthHelper := oidc.FositeOauth2Helper(store, goodIssuer, hmacSecretFunc, &singleUseJWKProvider{DynamicJWKSProvider: jwkProvider}, oidc.DefaultOIDCTimeoutsConfiguration())
-	authResponder := simulateAuthEndpointHavingAlreadyRun(t, authRequest, oauthHelper)
+	authResponder := simulateAuthEndpointHavingAlreadyRun(t, authRequest, oauthHelper, initialCustomSessionData)
 	return oauthHelper, authResponder.GetCode(), jwtSigningKey
 }
 
@@ -1345,31 +2214,40 @@ func makeOauthHelperWithNilPrivateJWTSigningKey(
 	t *testing.T,
 	authRequest *http.Request,
 	store fositestoragei.AllFositeStorage,
+	initialCustomSessionData *psession.CustomSessionData,
 ) (fosite.OAuth2Provider, string, *ecdsa.PrivateKey) {
 	t.Helper()
 
 	jwkProvider := jwks.NewDynamicJWKSProvider() // empty provider which contains no signing key for this issuer
 	oauthHelper := oidc.FositeOauth2Helper(store, goodIssuer, hmacSecretFunc, jwkProvider, oidc.DefaultOIDCTimeoutsConfiguration())
-	authResponder := simulateAuthEndpointHavingAlreadyRun(t, authRequest, oauthHelper)
+	authResponder := simulateAuthEndpointHavingAlreadyRun(t, authRequest, oauthHelper, initialCustomSessionData)
 	return oauthHelper, authResponder.GetCode(), nil
 }
 
 // Simulate the auth endpoint running so Fosite code will fill the store with realistic values.
-func simulateAuthEndpointHavingAlreadyRun(t *testing.T, authRequest *http.Request, oauthHelper fosite.OAuth2Provider) fosite.AuthorizeResponder {
+func simulateAuthEndpointHavingAlreadyRun(
+	t *testing.T,
+	authRequest *http.Request,
+	oauthHelper fosite.OAuth2Provider,
+	initialCustomSessionData *psession.CustomSessionData,
+) fosite.AuthorizeResponder {
 	// We only set the fields in the session that Fosite wants us to set.
 	ctx := context.Background()
-	session := &openid.DefaultSession{
-		Claims: &jwt.IDTokenClaims{
-			Subject:     goodSubject,
-			RequestedAt: goodRequestedAtTime,
-			AuthTime:    goodAuthTime,
-			Extra: map[string]interface{}{
-				oidc.DownstreamUsernameClaim: goodUsername,
-				oidc.DownstreamGroupsClaim:   goodGroups,
+	session := &psession.PinnipedSession{
+		DefaultSession: openid.DefaultSession{
+			Claims: &jwt.IDTokenClaims{
+				Subject:     goodSubject,
+				RequestedAt: goodRequestedAtTime,
+				AuthTime:    goodAuthTime,
+				Extra: map[string]interface{}{
+					oidc.DownstreamUsernameClaim: goodUsername,
+					oidc.DownstreamGroupsClaim:   goodGroups,
+				},
 			},
+			Subject:  "", // not used, note that callback_handler.go does not set this
+			Username: "", // not used, note that callback_handler.go does not set this
 		},
-		Subject:  "", // not used, note that callback_handler.go does not set this
-		Username: "", // not used, note that callback_handler.go does not set this
+		Custom: initialCustomSessionData,
 	}
 	authRequester, err := oauthHelper.NewAuthorizeRequest(ctx, authRequest)
 	require.NoError(t, err)
@@ -1425,6 +2303,9 @@ func requireValidRefreshTokenStorage(
 	storage oauth2.CoreStorage,
 	wantRequestedScopes []string,
 	wantGrantedScopes []string,
+	wantUsername string,
+	wantGroups []string,
+	wantCustomSessionData *psession.CustomSessionData,
 	secrets v1.SecretInterface,
 ) {
 	t.Helper()
@@ -1445,6 +2326,9 @@ func requireValidRefreshTokenStorage(
 		storedRequest.Sanitize([]string{}).GetRequestForm(),
 		wantRequestedScopes,
 		wantGrantedScopes,
+		wantUsername,
+		wantGroups,
+		wantCustomSessionData,
 		true,
 	)
 
@@ -1457,6 +2341,9 @@ func requireValidAccessTokenStorage(
 	storage oauth2.CoreStorage,
 	wantRequestedScopes []string,
 	wantGrantedScopes []string,
+	wantUsername string,
+	wantGroups []string,
+	wantCustomSessionData *psession.CustomSessionData,
 	secrets v1.SecretInterface,
 ) {
 	t.Helper()
@@ -1496,6 +2383,9 @@ func requireValidAccessTokenStorage(
 		storedRequest.Sanitize([]string{}).GetRequestForm(),
 		wantRequestedScopes,
 		wantGrantedScopes,
+		wantUsername,
+		wantGroups,
+		wantCustomSessionData,
 		true,
 	)
 
@@ -1538,6 +2428,7 @@ func requireValidOIDCStorage(
 	storage openid.OpenIDConnectRequestStorage,
 	wantRequestedScopes []string,
 	wantGrantedScopes []string,
+	wantCustomSessionData *psession.CustomSessionData,
 ) {
 	t.Helper()
 
@@ -1559,6 +2450,9 @@ func requireValidOIDCStorage(
 			storedRequest.Sanitize([]string{"nonce"}).GetRequestForm(),
 			wantRequestedScopes,
 			wantGrantedScopes,
+			goodUsername,
+			goodGroups,
+			wantCustomSessionData,
 			false,
 		)
 	} else {
@@ -1573,6 +2467,9 @@ func requireValidStoredRequest(
 	wantRequestForm url.Values,
 	wantRequestedScopes []string,
 	wantGrantedScopes []string,
+	wantUsername string,
+	wantGroups []string,
+	wantCustomSessionData *psession.CustomSessionData,
 	wantAccessTokenExpiresAt bool,
 ) {
 	t.Helper()
@@ -1588,8 +2485,11 @@ func requireValidStoredRequest(
 	require.Equal(t, wantRequestForm, request.GetRequestForm()) // Fosite stores access token request without form
 
 	// Cast session to the type we think it should be.
-	session, ok := request.GetSession().(*openid.DefaultSession)
-	require.Truef(t, ok, "could not cast %T to %T", request.GetSession(), &openid.DefaultSession{})
+	session, ok := request.GetSession().(*psession.PinnipedSession)
+	require.Truef(t, ok, "could not cast %T to %T", request.GetSession(), &psession.PinnipedSession{})
+
+	// The custom session data should always be stored, regardless of which scopes were granted.
+	require.Equal(t, wantCustomSessionData, session.Custom)
 
 	// Assert that the session claims are what we think they should be, but only if we are doing OIDC.
 	if contains(wantGrantedScopes, "openid") {
@@ -1597,11 +2497,10 @@ func requireValidStoredRequest(
 		require.Empty(t, claims.JTI) // When claims.JTI is empty, Fosite will generate a UUID for this field.
 		require.Equal(t, goodSubject, claims.Subject)
 
-		// Our custom claims from the authorize endpoint should still be set.
-		require.Equal(t, map[string]interface{}{
-			"username": goodUsername,
-			"groups":   goodGroups,
-		}, claims.Extra)
+		// Our custom claims from the authorize endpoint should still be set, unless they were updated by a refresh.
+		require.Len(t, claims.Extra, 2)
+		require.Equal(t, wantUsername, claims.Extra["username"])
+		require.ElementsMatch(t, wantGroups, claims.Extra["groups"])
 
 		// We are in charge of setting these fields. For the purpose of testing, we ensure that the
 		// sentinel test value is set correctly.
@@ -1684,6 +2583,8 @@ func requireValidIDToken(
 	t *testing.T,
 	body map[string]interface{},
 	jwtSigningKey *ecdsa.PrivateKey,
+	wantUsername string,
+	wantGroups []string,
 	wantAtHashClaimInIDToken bool,
 	wantNonceValueInIDToken bool,
 	actualAccessToken string,
@@ -1709,7 +2610,7 @@ func requireValidIDToken(
 		IssuedAt        int64    `json:"iat"`
 		RequestedAt     int64    `json:"rat"`
 		AuthTime        int64    `json:"auth_time"`
-		Groups          string   `json:"groups"`
+		Groups          []string `json:"groups"`
 		Username        string   `json:"username"`
 	}
 
@@ -1733,8 +2634,8 @@ func requireValidIDToken(
 	err := token.Claims(&claims)
 	require.NoError(t, err)
 	require.Equal(t, goodSubject, claims.Subject)
-	require.Equal(t, goodUsername, claims.Username)
-	require.Equal(t, goodGroups, claims.Groups)
+	require.Equal(t, wantUsername, claims.Username)
+	require.Equal(t, wantGroups, claims.Groups)
 	require.Len(t, claims.Audience, 1)
 	require.Equal(t, goodClient, claims.Audience[0])
 	require.Equal(t, goodIssuer, claims.Issuer)
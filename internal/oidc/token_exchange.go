@@ -6,6 +6,7 @@ package oidc
 import (
 	"context"
 	"net/url"
+	"strings"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/ory/fosite"
@@ -21,6 +22,9 @@ const (
 	pinnipedTokenExchangeScope = "pinniped:request-audience"                     //nolint: gosec
 )
 
+// TokenExchangeGrantType is the grant_type param value for the token exchange grant from RFC8693.
+const TokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange" //nolint: gosec
+
 type stsParams struct {
 	subjectAccessToken string
 	requestedAudience  string
@@ -31,6 +35,7 @@ func TokenExchangeFactory(config *compose.Config, storage interface{}, strategy
 		idTokenStrategy:     strategy.(openid.OpenIDConnectTokenStrategy),
 		accessTokenStrategy: strategy.(oauth2.AccessTokenStrategy),
 		accessTokenStorage:  storage.(oauth2.AccessTokenStorage),
+		clientManager:       storage.(fosite.ClientManager),
 	}
 }
 
@@ -38,6 +43,7 @@ type TokenExchangeHandler struct {
 	idTokenStrategy     openid.OpenIDConnectTokenStrategy
 	accessTokenStrategy oauth2.AccessTokenStrategy
 	accessTokenStorage  oauth2.AccessTokenStorage
+	clientManager       fosite.ClientManager
 }
 
 var _ fosite.TokenEndpointHandler = (*TokenExchangeHandler)(nil)
@@ -61,6 +67,11 @@ func (t *TokenExchangeHandler) PopulateTokenEndpointResponse(ctx context.Context
 		return errors.WithStack(err)
 	}
 
+	// An ID token for the audience of a client would be accepted by that client, as if the user had logged into it.
+	if _, err := t.clientManager.GetClient(ctx, params.requestedAudience); err == nil {
+		return errors.WithStack(fosite.ErrInvalidRequest.WithHint("requested audience cannot be the client ID of a registered client"))
+	}
+
 	// Validate the incoming access token and lookup the information about the original authorize request.
 	originalRequester, err := t.validateAccessToken(ctx, requester, params.subjectAccessToken)
 	if err != nil {
@@ -102,6 +113,10 @@ func (t *TokenExchangeHandler) validateParams(params url.Values) (*stsParams, er
 	if result.requestedAudience == "" {
 		return nil, fosite.ErrInvalidRequest.WithHint("missing audience parameter")
 	}
+	// Audiences of the pinniped.dev domains are reserved, e.g. for the client IDs of OIDCClients.
+	if strings.Contains(result.requestedAudience, ".pinniped.dev") {
+		return nil, fosite.ErrInvalidRequest.WithHint("requested audience cannot contain '.pinniped.dev'")
+	}
 	result.subjectAccessToken = params.Get("subject_token")
 	if result.subjectAccessToken == "" {
 		return nil, fosite.ErrInvalidRequest.WithHint("missing subject_token parameter")
@@ -147,5 +162,5 @@ func (t *TokenExchangeHandler) CanSkipClientAuth(_ fosite.AccessRequester) bool
 }
 
 func (t *TokenExchangeHandler) CanHandleTokenEndpointRequest(requester fosite.AccessRequester) bool {
-	return requester.GetGrantTypes().ExactOne("urn:ietf:params:oauth:grant-type:token-exchange")
+	return requester.GetGrantTypes().ExactOne(TokenExchangeGrantType)
 }
@@ -0,0 +1,83 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package userinfo provides a handler for the OIDC UserInfo endpoint.
+package userinfo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ory/fosite"
+
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/psession"
+)
+
+// NewHandler returns an http.Handler which returns the claims of the user to whom a downstream access token was
+// issued, as described in https://openid.net/specs/openid-connect-core-1_0.html#UserInfo. The access token is
+// validated by looking up its session in the storage of the oauthHelper, so revoked and expired access tokens are
+// rejected. The access token must have been granted the openid scope.
+func NewHandler(oauthHelper fosite.OAuth2Provider) http.Handler {
+	return httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			return httperr.Newf(http.StatusMethodNotAllowed, "%s (try GET or POST)", r.Method)
+		}
+
+		accessToken := fosite.AccessTokenFromRequest(r)
+		if accessToken == "" {
+			// Per RFC6750 section 3.1, a request without any authentication information does not get an error code.
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			return httperr.New(http.StatusUnauthorized, "missing access token")
+		}
+
+		tokenUse, requester, err := oauthHelper.IntrospectToken(r.Context(), accessToken, fosite.AccessToken, psession.NewPinnipedSession(), "openid")
+		switch {
+		case errors.Is(err, fosite.ErrInvalidScope):
+			plog.Info("userinfo request error", oidc.FositeErrorForLog(err)...)
+			writeBearerError(w, http.StatusForbidden, "insufficient_scope", "The access token was not granted the openid scope.")
+			return nil
+		case err != nil:
+			plog.Info("userinfo request error", oidc.FositeErrorForLog(err)...)
+			writeBearerError(w, http.StatusUnauthorized, "invalid_token", "The access token is invalid, expired, or revoked.")
+			return nil
+		case tokenUse != fosite.AccessToken:
+			// Do not allow a refresh token to be used as a bearer token.
+			writeBearerError(w, http.StatusUnauthorized, "invalid_token", "The token is not an access token.")
+			return nil
+		}
+
+		session, ok := requester.GetSession().(*psession.PinnipedSession)
+		if !ok {
+			return httperr.New(http.StatusInternalServerError, "wrong session type")
+		}
+
+		response := map[string]interface{}{"sub": session.GetSubject()}
+		if session.Claims != nil {
+			for _, claim := range []string{oidc.DownstreamUsernameClaim, oidc.DownstreamGroupsClaim} {
+				if value, ok := session.Claims.Extra[claim]; ok {
+					response[claim] = value
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			return httperr.Wrap(http.StatusInternalServerError, "failed to encode response", err)
+		}
+		return nil
+	})
+}
+
+// writeBearerError writes an error response as described in RFC6750 section 3.
+func writeBearerError(w http.ResponseWriter, status int, errorCode string, description string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error=%q, error_description=%q`, errorCode, description))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": errorCode, "error_description": description})
+}
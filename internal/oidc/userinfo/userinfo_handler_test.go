@@ -0,0 +1,179 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package userinfo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/token/jwt"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/internal/testutil/oidctestutil"
+)
+
+func TestUserInfoHandler(t *testing.T) {
+	const downstreamIssuer = "https://my-downstream-issuer.com/some-path"
+
+	hmacSecret := []byte("some secret - must have at least 32 bytes")
+
+	tests := []struct {
+		name          string
+		method        string
+		grantedScopes fosite.Arguments
+		// request returns the request, given the access token and refresh token which were issued to the CLI.
+		request func(method, accessToken, refreshToken string) *http.Request
+
+		wantStatus          int
+		wantResponseJSON    string
+		wantWWWAuthenticate string
+		wantBody            string
+	}{
+		{
+			name: "GET with the access token in the authorization header",
+			request: func(method, accessToken, _ string) *http.Request {
+				req := httptest.NewRequest(method, "/path/shouldn't/matter", nil)
+				req.Header.Set("Authorization", "Bearer "+accessToken)
+				return req
+			},
+			wantStatus:       http.StatusOK,
+			wantResponseJSON: `{"sub":"some-subject","username":"some-username","groups":["some-group"]}`,
+		},
+		{
+			name:   "POST with the access token in the form body",
+			method: http.MethodPost,
+			request: func(method, accessToken, _ string) *http.Request {
+				req := httptest.NewRequest(method, "/path/shouldn't/matter", strings.NewReader(url.Values{"access_token": {accessToken}}.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			},
+			wantStatus:       http.StatusOK,
+			wantResponseJSON: `{"sub":"some-subject","username":"some-username","groups":["some-group"]}`,
+		},
+		{
+			name: "missing access token",
+			request: func(method, _, _ string) *http.Request {
+				return httptest.NewRequest(method, "/path/shouldn't/matter", nil)
+			},
+			wantStatus:          http.StatusUnauthorized,
+			wantWWWAuthenticate: "Bearer",
+			wantBody:            "Unauthorized: missing access token\n",
+		},
+		{
+			name: "unknown access token",
+			request: func(method, _, _ string) *http.Request {
+				req := httptest.NewRequest(method, "/path/shouldn't/matter", nil)
+				req.Header.Set("Authorization", "Bearer some-unknown-token")
+				return req
+			},
+			wantStatus:          http.StatusUnauthorized,
+			wantWWWAuthenticate: `Bearer error="invalid_token", error_description="The access token is invalid, expired, or revoked."`,
+			wantResponseJSON:    `{"error":"invalid_token","error_description":"The access token is invalid, expired, or revoked."}`,
+		},
+		{
+			name: "a refresh token cannot be used as the access token",
+			request: func(method, _, refreshToken string) *http.Request {
+				req := httptest.NewRequest(method, "/path/shouldn't/matter", nil)
+				req.Header.Set("Authorization", "Bearer "+refreshToken)
+				return req
+			},
+			wantStatus:          http.StatusUnauthorized,
+			wantWWWAuthenticate: `Bearer error="invalid_token", error_description="The token is not an access token."`,
+			wantResponseJSON:    `{"error":"invalid_token","error_description":"The token is not an access token."}`,
+		},
+		{
+			name:          "the access token was not granted the openid scope",
+			grantedScopes: fosite.Arguments{"offline_access"},
+			request: func(method, accessToken, _ string) *http.Request {
+				req := httptest.NewRequest(method, "/path/shouldn't/matter", nil)
+				req.Header.Set("Authorization", "Bearer "+accessToken)
+				return req
+			},
+			wantStatus:          http.StatusForbidden,
+			wantWWWAuthenticate: `Bearer error="insufficient_scope", error_description="The access token was not granted the openid scope."`,
+			wantResponseJSON:    `{"error":"insufficient_scope","error_description":"The access token was not granted the openid scope."}`,
+		},
+		{
+			name:   "wrong HTTP method",
+			method: http.MethodPut,
+			request: func(method, accessToken, _ string) *http.Request {
+				req := httptest.NewRequest(method, "/path/shouldn't/matter", nil)
+				req.Header.Set("Authorization", "Bearer "+accessToken)
+				return req
+			},
+			wantStatus: http.StatusMethodNotAllowed,
+			wantBody:   "Method Not Allowed: PUT (try GET or POST)\n",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			secrets := fake.NewSimpleClientset().CoreV1().Secrets("some-namespace")
+			timeoutsConfiguration := oidc.DefaultOIDCTimeoutsConfiguration()
+			oauthStore := oidc.NewKubeStorage(secrets, oidctestutil.NewClientManager("some-namespace"), timeoutsConfiguration)
+			oauthHelper := oidc.FositeOauth2Helper(oauthStore, downstreamIssuer, func() [][]byte { return [][]byte{hmacSecret} }, nil, timeoutsConfiguration)
+
+			grantedScopes := test.grantedScopes
+			if grantedScopes == nil {
+				grantedScopes = fosite.Arguments{"openid", "offline_access"}
+			}
+			accessToken, refreshToken := oidctestutil.StoreDownstreamTokens(t, oauthStore, hmacSecret, newDownstreamRequest(grantedScopes))
+
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			rsp := httptest.NewRecorder()
+			NewHandler(oauthHelper).ServeHTTP(rsp, test.request(method, accessToken, refreshToken))
+			t.Logf("response: %#v", rsp)
+			t.Logf("response body: %q", rsp.Body.String())
+
+			require.Equal(t, test.wantStatus, rsp.Code)
+			require.Equal(t, test.wantWWWAuthenticate, rsp.Header().Get("WWW-Authenticate"))
+			if test.wantResponseJSON != "" {
+				require.Equal(t, "application/json", rsp.Header().Get("Content-Type"))
+				require.JSONEq(t, test.wantResponseJSON, rsp.Body.String())
+			}
+			if test.wantBody != "" {
+				require.Equal(t, test.wantBody, rsp.Body.String())
+			}
+			if test.wantStatus == http.StatusOK {
+				require.Equal(t, "no-store", rsp.Header().Get("Cache-Control"))
+			}
+		})
+	}
+}
+
+func newDownstreamRequest(grantedScopes fosite.Arguments) *fosite.Request {
+	now := time.Now().UTC()
+	session := psession.NewPinnipedSession()
+	session.Claims = &jwt.IDTokenClaims{
+		Subject:     "some-subject",
+		RequestedAt: now,
+		AuthTime:    now,
+		Extra:       map[string]interface{}{oidc.DownstreamUsernameClaim: "some-username", oidc.DownstreamGroupsClaim: []string{"some-group"}},
+	}
+	session.ExpiresAt = map[fosite.TokenType]time.Time{
+		fosite.AccessToken:  now.Add(time.Minute),
+		fosite.RefreshToken: now.Add(time.Hour),
+	}
+	return &fosite.Request{
+		ID:             "some-request-id",
+		RequestedAt:    now,
+		Client:         clientregistry.PinnipedCLI(),
+		RequestedScope: grantedScopes,
+		GrantedScope:   grantedScopes,
+		Form:           url.Values{},
+		Session:        session,
+	}
+}
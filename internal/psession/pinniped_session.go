@@ -0,0 +1,167 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package psession provides the session type which the Supervisor stores for each downstream login.
+package psession
+
+import (
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/handler/openid"
+)
+
+// PinnipedSession is a session container which includes the standard fosite OIDC session plus custom Pinniped data.
+type PinnipedSession struct {
+	// The fosite session is embedded, so its fields are serialized at the top level of the JSON. This keeps the stored
+	// format compatible with sessions which were stored before the custom data was added.
+	openid.DefaultSession
+
+	// Custom holds the Supervisor's own data about the login. It is never included in any token.
+	Custom *CustomSessionData `json:"custom,omitempty"`
+}
+
+var _ openid.Session = &PinnipedSession{}
+
+// downstreamUsernameClaim is the name of the custom claim which holds the downstream username. This must match
+// oidc.DownstreamUsernameClaim, which cannot be imported here without causing an import cycle.
+const downstreamUsernameClaim = "username"
+
+// ProviderType is the type of an upstream identity provider.
+type ProviderType string
+
+const (
+	ProviderTypeOIDC            ProviderType = "oidc"
+	ProviderTypeLDAP            ProviderType = "ldap"
+	ProviderTypeActiveDirectory ProviderType = "activedirectory"
+	ProviderTypeGitHub          ProviderType = "github"
+	ProviderTypeSAML            ProviderType = "saml"
+)
+
+// CustomSessionData is the custom session data which the Supervisor needs to remember about a login, for example to
+// check with the upstream identity provider again when the downstream refresh token is used.
+type CustomSessionData struct {
+	// ProviderName is the name of the upstream identity provider which was used to log in.
+	ProviderName string `json:"providerName"`
+
+	// ProviderType is the type of the upstream identity provider which was used to log in.
+	ProviderType ProviderType `json:"providerType"`
+
+	// Only used when ProviderType == "oidc".
+	OIDC *OIDCSessionData `json:"oidc,omitempty"`
+
+	// Only used when ProviderType == "ldap".
+	LDAP *LDAPSessionData `json:"ldap,omitempty"`
+
+	// Only used when ProviderType == "activedirectory".
+	ActiveDirectory *ActiveDirectorySessionData `json:"activedirectory,omitempty"`
+
+	// Only used when ProviderType == "github".
+	GitHub *GitHubSessionData `json:"github,omitempty"`
+
+	// Only used when ProviderType == "saml".
+	SAML *SAMLSessionData `json:"saml,omitempty"`
+}
+
+// OIDCSessionData is the additional data needed by the Supervisor for sessions from upstream OIDC providers.
+type OIDCSessionData struct {
+	// UpstreamRefreshToken is the most recent refresh token which was issued by the upstream provider.
+	UpstreamRefreshToken string `json:"upstreamRefreshToken"`
+
+	// UpstreamIssuer is the issuer of the upstream provider's ID tokens, which is a component of the downstream
+	// subject. It is needed when a refresh returns claims only from the upstream userinfo endpoint.
+	UpstreamIssuer string `json:"upstreamIssuer"`
+}
+
+// LDAPSessionData is the additional data needed by the Supervisor for sessions from upstream LDAP providers.
+type LDAPSessionData struct {
+	// Username is the username which the end user typed when logging in, which is needed to repeat the user search.
+	Username string `json:"username"`
+}
+
+// ActiveDirectorySessionData is the additional data needed by the Supervisor for sessions from upstream Active
+// Directory providers.
+type ActiveDirectorySessionData struct {
+	// Username is the username which the end user typed when logging in, which is needed to repeat the user search.
+	Username string `json:"username"`
+}
+
+// GitHubSessionData is the additional data needed by the Supervisor for sessions from upstream GitHub providers.
+type GitHubSessionData struct {
+	// UpstreamAccessToken is the access token which was issued by GitHub, which is needed to check the user's
+	// identity and organization memberships again. GitHub does not issue refresh tokens to OAuth Apps.
+	UpstreamAccessToken string `json:"upstreamAccessToken"`
+}
+
+// SAMLSessionData is the additional data needed by the Supervisor for sessions from upstream SAML providers.
+// There is no way to check with a SAML provider again without the end user, so the upstream identity from the
+// login is kept, which allows the identity transforms to be applied again during a refresh.
+type SAMLSessionData struct {
+	// NameID is the NameID of the subject of the assertion, which is a component of the downstream subject.
+	NameID string `json:"nameID"`
+
+	// Username is the upstream username of the user, before any identity transforms.
+	Username string `json:"username"`
+
+	// Groups are the upstream group names of the user, before any identity transforms.
+	Groups []string `json:"groups"`
+}
+
+// NewPinnipedSession returns a new empty session.
+func NewPinnipedSession() *PinnipedSession {
+	return &PinnipedSession{}
+}
+
+// GetSubject implements fosite.Session by returning the subject of the downstream ID token claims, for example so it
+// can be included in token introspection responses. The subject field of the embedded fosite session is never set.
+func (s *PinnipedSession) GetSubject() string {
+	if s == nil || s.Claims == nil {
+		return ""
+	}
+	return s.Claims.Subject
+}
+
+// GetUsername implements fosite.Session by returning the downstream username from the ID token claims, which is
+// kept up to date when the upstream identity is re-validated during a refresh.
+func (s *PinnipedSession) GetUsername() string {
+	if s == nil || s.Claims == nil {
+		return ""
+	}
+	username, _ := s.Claims.Extra[downstreamUsernameClaim].(string)
+	return username
+}
+
+// Clone implements fosite.Session. It must be overridden so the clone keeps the custom data and the type of the session.
+func (s *PinnipedSession) Clone() fosite.Session {
+	if s == nil {
+		return nil
+	}
+	clone := &PinnipedSession{}
+	if fositeClone, ok := s.DefaultSession.Clone().(*openid.DefaultSession); ok && fositeClone != nil {
+		clone.DefaultSession = *fositeClone
+	}
+	if s.Custom != nil {
+		customClone := *s.Custom
+		if s.Custom.OIDC != nil {
+			oidcClone := *s.Custom.OIDC
+			customClone.OIDC = &oidcClone
+		}
+		if s.Custom.LDAP != nil {
+			ldapClone := *s.Custom.LDAP
+			customClone.LDAP = &ldapClone
+		}
+		if s.Custom.ActiveDirectory != nil {
+			adClone := *s.Custom.ActiveDirectory
+			customClone.ActiveDirectory = &adClone
+		}
+		if s.Custom.GitHub != nil {
+			gitHubClone := *s.Custom.GitHub
+			customClone.GitHub = &gitHubClone
+		}
+		if s.Custom.SAML != nil {
+			samlClone := *s.Custom.SAML
+			samlClone.Groups = append([]string(nil), s.Custom.SAML.Groups...)
+			customClone.SAML = &samlClone
+		}
+		clone.Custom = &customClone
+	}
+	return clone
+}
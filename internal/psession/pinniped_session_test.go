@@ -0,0 +1,108 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package psession
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ory/fosite/handler/openid"
+	"github.com/ory/fosite/token/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClone(t *testing.T) {
+	original := &PinnipedSession{
+		DefaultSession: openid.DefaultSession{
+			Claims:  &jwt.IDTokenClaims{Subject: "some-subject", Extra: map[string]interface{}{"username": "some-username"}},
+			Headers: &jwt.Headers{},
+			Subject: "some-subject",
+		},
+		Custom: &CustomSessionData{
+			ProviderName: "some-idp",
+			ProviderType: ProviderTypeOIDC,
+			OIDC:         &OIDCSessionData{UpstreamRefreshToken: "some-refresh-token", UpstreamIssuer: "https://some-issuer"},
+		},
+	}
+
+	clone, ok := original.Clone().(*PinnipedSession)
+	require.True(t, ok)
+	require.Equal(t, original, clone)
+
+	// Changing the clone must not change the original.
+	clone.Custom.OIDC.UpstreamRefreshToken = "some-other-refresh-token"
+	clone.Custom.ProviderName = "some-other-idp"
+	clone.Claims.Subject = "some-other-subject"
+	require.Equal(t, "some-refresh-token", original.Custom.OIDC.UpstreamRefreshToken)
+	require.Equal(t, "some-idp", original.Custom.ProviderName)
+	require.Equal(t, "some-subject", original.Claims.Subject)
+
+	var nilSession *PinnipedSession
+	require.Nil(t, nilSession.Clone())
+
+	withoutCustom, ok := NewPinnipedSession().Clone().(*PinnipedSession)
+	require.True(t, ok)
+	require.Nil(t, withoutCustom.Custom)
+
+	// The upstream groups of SAML sessions are copied too.
+	samlOriginal := &PinnipedSession{
+		Custom: &CustomSessionData{
+			ProviderName: "some-saml-idp",
+			ProviderType: ProviderTypeSAML,
+			SAML:         &SAMLSessionData{NameID: "some-name-id", Username: "some-username", Groups: []string{"some-group"}},
+		},
+	}
+	samlClone, ok := samlOriginal.Clone().(*PinnipedSession)
+	require.True(t, ok)
+	require.Equal(t, samlOriginal.Custom, samlClone.Custom)
+	samlClone.Custom.SAML.Groups[0] = "some-other-group"
+	require.Equal(t, []string{"some-group"}, samlOriginal.Custom.SAML.Groups)
+}
+
+func TestJSONFormat(t *testing.T) {
+	session := &PinnipedSession{
+		DefaultSession: openid.DefaultSession{Subject: "some-subject"},
+		Custom: &CustomSessionData{
+			ProviderName: "some-ldap-idp",
+			ProviderType: ProviderTypeLDAP,
+			LDAP:         &LDAPSessionData{Username: "some-username"},
+		},
+	}
+
+	b, err := json.Marshal(session)
+	require.NoError(t, err)
+
+	var parsed map[string]interface{}
+	require.NoError(t, json.Unmarshal(b, &parsed))
+	// The fosite session fields are at the top level, next to the custom data.
+	require.Equal(t, "some-subject", parsed["Subject"])
+	require.Equal(t, map[string]interface{}{
+		"providerName": "some-ldap-idp",
+		"providerType": "ldap",
+		"ldap":         map[string]interface{}{"username": "some-username"},
+	}, parsed["custom"])
+
+	// Sessions stored before the custom data was added can still be read.
+	var old PinnipedSession
+	require.NoError(t, json.Unmarshal([]byte(`{"Subject":"some-subject"}`), &old))
+	require.Equal(t, "some-subject", old.Subject)
+	require.Nil(t, old.Custom)
+}
+
+func TestGetSubjectAndUsername(t *testing.T) {
+	session := &PinnipedSession{
+		DefaultSession: openid.DefaultSession{
+			Claims: &jwt.IDTokenClaims{Subject: "some-subject", Extra: map[string]interface{}{"username": "some-username"}},
+		},
+	}
+	require.Equal(t, "some-subject", session.GetSubject())
+	require.Equal(t, "some-username", session.GetUsername())
+
+	require.Empty(t, NewPinnipedSession().GetSubject())
+	require.Empty(t, NewPinnipedSession().GetUsername())
+
+	var nilSession *PinnipedSession
+	require.Empty(t, nilSession.GetSubject())
+	require.Empty(t, nilSession.GetUsername())
+}
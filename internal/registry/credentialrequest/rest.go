@@ -6,6 +6,8 @@ package credentialrequest
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"time"
 
@@ -21,14 +23,17 @@ import (
 	"k8s.io/utils/trace"
 
 	loginapi "go.pinniped.dev/generated/latest/apis/concierge/login"
+	"go.pinniped.dev/internal/auditlog"
 	"go.pinniped.dev/internal/issuer"
 )
 
-// clientCertificateTTL is the TTL for short-lived client certificates returned by this API.
+// clientCertificateTTL is the default TTL for short-lived client certificates returned by this API, for
+// authenticators which do not configure their own.
 const clientCertificateTTL = 5 * time.Minute
 
 type TokenCredentialRequestAuthenticator interface {
 	AuthenticateTokenCredentialRequest(ctx context.Context, req *loginapi.TokenCredentialRequest) (user.Info, error)
+	ClientCertificateTTL(req *loginapi.TokenCredentialRequest, defaultTTL time.Duration) time.Duration
 }
 
 func NewREST(authenticator TokenCredentialRequestAuthenticator, issuer issuer.ClientCertIssuer, resource schema.GroupResource) *REST {
@@ -99,22 +104,34 @@ func (r *REST) Create(ctx context.Context, obj runtime.Object, createValidation
 	userInfo, err := r.authenticator.AuthenticateTokenCredentialRequest(ctx, credentialRequest)
 	if err != nil {
 		traceFailureWithError(t, "token authentication", err)
+		recordAuditEvent(ctx, credentialRequest, auditlog.OutcomeError, "token authentication: "+err.Error(), nil)
 		return failureResponse(), nil
 	}
 	if ok := isUserInfoValid(userInfo); !ok {
 		traceSuccess(t, userInfo, false)
+		recordAuditEvent(ctx, credentialRequest, auditlog.OutcomeFailure, "token was not authenticated or the user info is not valid", nil)
 		return failureResponse(), nil
 	}
 
-	// this timestamp should be returned from IssueClientCertPEM but this is a safe approximation
-	expires := metav1.NewTime(time.Now().UTC().Add(clientCertificateTTL))
-	certPEM, keyPEM, err := r.issuer.IssueClientCertPEM(userInfo.GetName(), userInfo.GetGroups(), clientCertificateTTL)
+	ttl := r.authenticator.ClientCertificateTTL(credentialRequest, clientCertificateTTL)
+
+	certPEM, keyPEM, err := r.issuer.IssueClientCertPEM(userInfo.GetName(), userInfo.GetGroups(), userInfo.GetExtra(), ttl)
 	if err != nil {
 		traceFailureWithError(t, "cert issuer", err)
+		recordAuditEvent(ctx, credentialRequest, auditlog.OutcomeError, "cert issuer: "+err.Error(), userInfo)
+		return failureResponse(), nil
+	}
+
+	// the issuer does not necessarily honor the ttl exactly, so report when the certificate really expires
+	expires, err := certificateExpiration(certPEM)
+	if err != nil {
+		traceFailureWithError(t, "cert issuer", err)
+		recordAuditEvent(ctx, credentialRequest, auditlog.OutcomeError, "cert issuer: "+err.Error(), userInfo)
 		return failureResponse(), nil
 	}
 
 	traceSuccess(t, userInfo, true)
+	recordAuditEvent(ctx, credentialRequest, auditlog.OutcomeSuccess, "", userInfo)
 
 	return &loginapi.TokenCredentialRequest{
 		Status: loginapi.TokenCredentialRequestStatus{
@@ -127,6 +144,18 @@ func (r *REST) Create(ctx context.Context, obj runtime.Object, createValidation
 	}, nil
 }
 
+func certificateExpiration(certPEM []byte) (metav1.Time, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return metav1.Time{}, fmt.Errorf("issued certificate is not PEM-encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return metav1.Time{}, fmt.Errorf("could not parse issued certificate: %w", err)
+	}
+	return metav1.NewTime(cert.NotAfter.UTC()), nil
+}
+
 func validateRequest(ctx context.Context, obj runtime.Object, createValidation rest.ValidateObjectFunc, options *metav1.CreateOptions, t *trace.Trace) (*loginapi.TokenCredentialRequest, error) {
 	credentialRequest, ok := obj.(*loginapi.TokenCredentialRequest)
 	if !ok {
@@ -171,12 +200,32 @@ func validateRequest(ctx context.Context, obj runtime.Object, createValidation r
 	return credentialRequest, nil
 }
 
+func recordAuditEvent(ctx context.Context, req *loginapi.TokenCredentialRequest, outcome auditlog.Outcome, reason string, userInfo user.Info) {
+	event := auditlog.Event{
+		Type:         auditlog.EventTypeConciergeTokenCredentialRequest,
+		Outcome:      outcome,
+		Reason:       reason,
+		SourceIP:     auditlog.SourceIPFrom(ctx),
+		ForwardedFor: auditlog.ForwardedForFrom(ctx),
+		Authenticator: &auditlog.Authenticator{
+			Kind: req.Spec.Authenticator.Kind,
+			Name: req.Spec.Authenticator.Name,
+		},
+	}
+	if req.Spec.Authenticator.APIGroup != nil {
+		event.Authenticator.APIGroup = *req.Spec.Authenticator.APIGroup
+	}
+	if userInfo != nil {
+		event.User = &auditlog.User{Username: userInfo.GetName(), Groups: userInfo.GetGroups()}
+	}
+	auditlog.Record(event)
+}
+
 func isUserInfoValid(userInfo user.Info) bool {
 	switch {
 	case userInfo == nil, // must be non-nil
-		len(userInfo.GetName()) == 0,  // must have a username, groups are optional
-		len(userInfo.GetUID()) != 0,   // certs cannot assert UID
-		len(userInfo.GetExtra()) != 0: // certs cannot assert extra
+		len(userInfo.GetName()) == 0, // must have a username, groups and extra are optional
+		len(userInfo.GetUID()) != 0:  // certs cannot assert UID
 		return false
 
 	default:
@@ -24,6 +24,7 @@ import (
 	"k8s.io/utils/pointer"
 
 	loginapi "go.pinniped.dev/generated/latest/apis/concierge/login"
+	"go.pinniped.dev/internal/certauthority"
 	"go.pinniped.dev/internal/issuer"
 	"go.pinniped.dev/internal/mocks/credentialrequestmocks"
 	"go.pinniped.dev/internal/mocks/issuermocks"
@@ -81,6 +82,7 @@ func TestCreate(t *testing.T) {
 
 		it("CreateSucceedsWhenGivenATokenAndTheWebhookAuthenticatesTheToken", func() {
 			req := validCredentialRequest()
+			certPEM := testCertPEM(t, 5*time.Minute)
 
 			requestAuthenticator := credentialrequestmocks.NewMockTokenCredentialRequestAuthenticator(ctrl)
 			requestAuthenticator.EXPECT().AuthenticateTokenCredentialRequest(gomock.Any(), req).
@@ -88,13 +90,15 @@ func TestCreate(t *testing.T) {
 					Name:   "test-user",
 					Groups: []string{"test-group-1", "test-group-2"},
 				}, nil)
+			requestAuthenticator.EXPECT().ClientCertificateTTL(req, 5*time.Minute).Return(5 * time.Minute)
 
 			clientCertIssuer := issuermocks.NewMockClientCertIssuer(ctrl)
 			clientCertIssuer.EXPECT().IssueClientCertPEM(
 				"test-user",
 				[]string{"test-group-1", "test-group-2"},
+				nil,
 				5*time.Minute,
-			).Return([]byte("test-cert"), []byte("test-key"), nil)
+			).Return(certPEM, []byte("test-key"), nil)
 
 			storage := NewREST(requestAuthenticator, clientCertIssuer, schema.GroupResource{})
 
@@ -112,7 +116,7 @@ func TestCreate(t *testing.T) {
 				Status: loginapi.TokenCredentialRequestStatus{
 					Credential: &loginapi.ClusterCredential{
 						ExpirationTimestamp:   metav1.Time{},
-						ClientCertificateData: "test-cert",
+						ClientCertificateData: string(certPEM),
 						ClientKeyData:         "test-key",
 					},
 				},
@@ -120,6 +124,38 @@ func TestCreate(t *testing.T) {
 			requireOneLogStatement(r, logger, `"success" userID:,hasExtra:false,authenticated:true`)
 		})
 
+		it("CreateSucceedsWithTheClientCertificateTTLOfTheAuthenticator", func() {
+			req := validCredentialRequest()
+
+			requestAuthenticator := credentialrequestmocks.NewMockTokenCredentialRequestAuthenticator(ctrl)
+			requestAuthenticator.EXPECT().AuthenticateTokenCredentialRequest(gomock.Any(), req).
+				Return(&user.DefaultInfo{
+					Name:   "test-user",
+					Groups: []string{"test-group-1", "test-group-2"},
+				}, nil)
+			requestAuthenticator.EXPECT().ClientCertificateTTL(req, 5*time.Minute).Return(time.Hour)
+
+			clientCertIssuer := issuermocks.NewMockClientCertIssuer(ctrl)
+			clientCertIssuer.EXPECT().IssueClientCertPEM(
+				"test-user",
+				[]string{"test-group-1", "test-group-2"},
+				nil,
+				time.Hour,
+			).Return(testCertPEM(t, time.Hour), []byte("test-key"), nil)
+
+			storage := NewREST(requestAuthenticator, clientCertIssuer, schema.GroupResource{})
+
+			response, err := callCreate(context.Background(), storage, req)
+
+			r.NoError(err)
+			r.IsType(&loginapi.TokenCredentialRequest{}, response)
+
+			expires := response.(*loginapi.TokenCredentialRequest).Status.Credential.ExpirationTimestamp
+			r.NotNil(expires)
+			r.InDelta(time.Now().Add(time.Hour).Unix(), expires.Unix(), 5)
+			requireOneLogStatement(r, logger, `"success" userID:,hasExtra:false,authenticated:true`)
+		})
+
 		it("CreateFailsWithValidTokenWhenCertIssuerFails", func() {
 			req := validCredentialRequest()
 
@@ -129,10 +165,11 @@ func TestCreate(t *testing.T) {
 					Name:   "test-user",
 					Groups: []string{"test-group-1", "test-group-2"},
 				}, nil)
+			requestAuthenticator.EXPECT().ClientCertificateTTL(req, 5*time.Minute).Return(5 * time.Minute)
 
 			clientCertIssuer := issuermocks.NewMockClientCertIssuer(ctrl)
 			clientCertIssuer.EXPECT().
-				IssueClientCertPEM(gomock.Any(), gomock.Any(), gomock.Any()).
+				IssueClientCertPEM(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
 				Return(nil, nil, fmt.Errorf("some certificate authority error"))
 
 			storage := NewREST(requestAuthenticator, clientCertIssuer, schema.GroupResource{})
@@ -142,6 +179,49 @@ func TestCreate(t *testing.T) {
 			requireOneLogStatement(r, logger, `"failure" failureType:cert issuer,msg:some certificate authority error`)
 		})
 
+		it("CreateSucceedsWithTheExpirationOfTheIssuedCertificate", func() {
+			req := validCredentialRequest()
+
+			requestAuthenticator := credentialrequestmocks.NewMockTokenCredentialRequestAuthenticator(ctrl)
+			requestAuthenticator.EXPECT().AuthenticateTokenCredentialRequest(gomock.Any(), req).
+				Return(&user.DefaultInfo{Name: "test-user"}, nil)
+			requestAuthenticator.EXPECT().ClientCertificateTTL(req, 5*time.Minute).Return(5 * time.Minute)
+
+			// issuers may round the ttl up, e.g. to the minimum duration of a CertificateSigningRequest
+			clientCertIssuer := issuermocks.NewMockClientCertIssuer(ctrl)
+			clientCertIssuer.EXPECT().
+				IssueClientCertPEM(gomock.Any(), gomock.Any(), gomock.Any(), 5*time.Minute).
+				Return(testCertPEM(t, 10*time.Minute), []byte("test-key"), nil)
+
+			storage := NewREST(requestAuthenticator, clientCertIssuer, schema.GroupResource{})
+
+			response, err := callCreate(context.Background(), storage, req)
+
+			r.NoError(err)
+			expires := response.(*loginapi.TokenCredentialRequest).Status.Credential.ExpirationTimestamp
+			r.InDelta(time.Now().Add(10*time.Minute).Unix(), expires.Unix(), 5)
+		})
+
+		it("CreateFailsWithValidTokenWhenCertIssuerReturnsAnInvalidCertificate", func() {
+			req := validCredentialRequest()
+
+			requestAuthenticator := credentialrequestmocks.NewMockTokenCredentialRequestAuthenticator(ctrl)
+			requestAuthenticator.EXPECT().AuthenticateTokenCredentialRequest(gomock.Any(), req).
+				Return(&user.DefaultInfo{Name: "test-user"}, nil)
+			requestAuthenticator.EXPECT().ClientCertificateTTL(req, 5*time.Minute).Return(5 * time.Minute)
+
+			clientCertIssuer := issuermocks.NewMockClientCertIssuer(ctrl)
+			clientCertIssuer.EXPECT().
+				IssueClientCertPEM(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+				Return([]byte("test-cert"), []byte("test-key"), nil)
+
+			storage := NewREST(requestAuthenticator, clientCertIssuer, schema.GroupResource{})
+
+			response, err := callCreate(context.Background(), storage, req)
+			requireSuccessfulResponseWithAuthenticationFailureMessage(t, err, response)
+			requireOneLogStatement(r, logger, `"failure" failureType:cert issuer,msg:issued certificate is not PEM-encoded`)
+		})
+
 		it("CreateSucceedsWithAnUnauthenticatedStatusWhenGivenATokenAndTheWebhookReturnsNilUser", func() {
 			req := validCredentialRequest()
 
@@ -205,8 +285,9 @@ func TestCreate(t *testing.T) {
 			requireOneLogStatement(r, logger, `"success" userID:test-uid,hasExtra:false,authenticated:false`)
 		})
 
-		it("CreateSucceedsWithAnUnauthenticatedStatusWhenWebhookReturnsAUserWithExtra", func() {
+		it("CreateSucceedsWhenWebhookReturnsAUserWithExtra", func() {
 			req := validCredentialRequest()
+			certPEM := testCertPEM(t, 5*time.Minute)
 
 			requestAuthenticator := credentialrequestmocks.NewMockTokenCredentialRequestAuthenticator(ctrl)
 			requestAuthenticator.EXPECT().AuthenticateTokenCredentialRequest(gomock.Any(), req).
@@ -215,13 +296,23 @@ func TestCreate(t *testing.T) {
 					Groups: []string{"test-group-1", "test-group-2"},
 					Extra:  map[string][]string{"test-key": {"test-val-1", "test-val-2"}},
 				}, nil)
+			requestAuthenticator.EXPECT().ClientCertificateTTL(req, 5*time.Minute).Return(5 * time.Minute)
 
-			storage := NewREST(requestAuthenticator, nil, schema.GroupResource{})
+			clientCertIssuer := issuermocks.NewMockClientCertIssuer(ctrl)
+			clientCertIssuer.EXPECT().IssueClientCertPEM(
+				"test-user",
+				[]string{"test-group-1", "test-group-2"},
+				map[string][]string{"test-key": {"test-val-1", "test-val-2"}},
+				5*time.Minute,
+			).Return(certPEM, []byte("test-key"), nil)
+
+			storage := NewREST(requestAuthenticator, clientCertIssuer, schema.GroupResource{})
 
 			response, err := callCreate(context.Background(), storage, req)
 
-			requireSuccessfulResponseWithAuthenticationFailureMessage(t, err, response)
-			requireOneLogStatement(r, logger, `"success" userID:,hasExtra:true,authenticated:false`)
+			r.NoError(err)
+			r.Equal(string(certPEM), response.(*loginapi.TokenCredentialRequest).Status.Credential.ClientCertificateData)
+			requireOneLogStatement(r, logger, `"success" userID:,hasExtra:true,authenticated:true`)
 		})
 
 		it("CreateFailsWhenGivenTheWrongInputType", func() {
@@ -267,8 +358,9 @@ func TestCreate(t *testing.T) {
 			requestAuthenticator := credentialrequestmocks.NewMockTokenCredentialRequestAuthenticator(ctrl)
 			requestAuthenticator.EXPECT().AuthenticateTokenCredentialRequest(gomock.Any(), req.DeepCopy()).
 				Return(&user.DefaultInfo{Name: "test-user"}, nil)
+			requestAuthenticator.EXPECT().ClientCertificateTTL(gomock.Any(), 5*time.Minute).Return(5 * time.Minute)
 
-			storage := NewREST(requestAuthenticator, successfulIssuer(ctrl), schema.GroupResource{})
+			storage := NewREST(requestAuthenticator, successfulIssuer(t, ctrl), schema.GroupResource{})
 			response, err := storage.Create(
 				context.Background(),
 				req,
@@ -288,8 +380,9 @@ func TestCreate(t *testing.T) {
 			requestAuthenticator := credentialrequestmocks.NewMockTokenCredentialRequestAuthenticator(ctrl)
 			requestAuthenticator.EXPECT().AuthenticateTokenCredentialRequest(gomock.Any(), req.DeepCopy()).
 				Return(&user.DefaultInfo{Name: "test-user"}, nil)
+			requestAuthenticator.EXPECT().ClientCertificateTTL(gomock.Any(), 5*time.Minute).Return(5 * time.Minute)
 
-			storage := NewREST(requestAuthenticator, successfulIssuer(ctrl), schema.GroupResource{})
+			storage := NewREST(requestAuthenticator, successfulIssuer(t, ctrl), schema.GroupResource{})
 			validationFunctionWasCalled := false
 			var validationFunctionSawTokenValue string
 			response, err := storage.Create(
@@ -390,10 +483,20 @@ func requireSuccessfulResponseWithAuthenticationFailureMessage(t *testing.T, err
 	})
 }
 
-func successfulIssuer(ctrl *gomock.Controller) issuer.ClientCertIssuer {
+func successfulIssuer(t *testing.T, ctrl *gomock.Controller) issuer.ClientCertIssuer {
 	clientCertIssuer := issuermocks.NewMockClientCertIssuer(ctrl)
 	clientCertIssuer.EXPECT().
-		IssueClientCertPEM(gomock.Any(), gomock.Any(), gomock.Any()).
-		Return([]byte("test-cert"), []byte("test-key"), nil)
+		IssueClientCertPEM(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(testCertPEM(t, 5*time.Minute), []byte("test-key"), nil)
 	return clientCertIssuer
 }
+
+// testCertPEM returns a client certificate which expires after the given ttl.
+func testCertPEM(t *testing.T, ttl time.Duration) []byte {
+	t.Helper()
+	ca, err := certauthority.New("test-ca", time.Hour)
+	require.NoError(t, err)
+	certPEM, _, err := ca.IssueClientCertPEM("test-user", nil, nil, ttl)
+	require.NoError(t, err)
+	return certPEM
+}
@@ -19,6 +19,7 @@ import (
 
 	identityapi "go.pinniped.dev/generated/latest/apis/concierge/identity"
 	identityapivalidation "go.pinniped.dev/generated/latest/apis/concierge/identity/validation"
+	"go.pinniped.dev/internal/auditlog"
 )
 
 func NewREST(resource schema.GroupResource) *REST {
@@ -103,6 +104,14 @@ func (r *REST) Create(ctx context.Context, obj runtime.Object, createValidation
 		return nil, apierrors.NewInternalError(fmt.Errorf("no user info on request"))
 	}
 
+	auditlog.Record(auditlog.Event{
+		Type:         auditlog.EventTypeConciergeWhoAmIRequest,
+		Outcome:      auditlog.OutcomeSuccess,
+		SourceIP:     auditlog.SourceIPFrom(ctx),
+		ForwardedFor: auditlog.ForwardedForFrom(ctx),
+		User:         &auditlog.User{Username: userInfo.GetName(), Groups: userInfo.GetGroups()},
+	})
+
 	auds, _ := authenticator.AudiencesFrom(ctx)
 
 	out := &identityapi.WhoAmIRequest{
@@ -1,4 +1,4 @@
-// Copyright 2020 the Pinniped contributors. All Rights Reserved.
+// Copyright 2020-2021 the Pinniped contributors. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
 package secret
@@ -16,10 +16,14 @@ type Cache struct {
 // New returns an empty Cache.
 func New() *Cache { return &Cache{} }
 
+// federationDomainCache holds the symmetric keys of a FederationDomain. Each key kind is a list, which starts with
+// the current key and continues with the previous keys, newest first. The current key is used to sign and encrypt,
+// while all of the keys may be used to verify and decrypt, so that rotating a key does not invalidate the values
+// which were encoded with the previous keys.
 type federationDomainCache struct {
-	tokenHMACKey         atomic.Value
-	stateEncoderHashKey  atomic.Value
-	stateEncoderBlockKey atomic.Value
+	tokenHMACKeys         atomic.Value
+	stateEncoderHashKeys  atomic.Value
+	stateEncoderBlockKeys atomic.Value
 }
 
 func (c *Cache) GetCSRFCookieEncoderHashKey() []byte {
@@ -30,28 +34,28 @@ func (c *Cache) SetCSRFCookieEncoderHashKey(key []byte) {
 	c.csrfCookieEncoderHashKey.Store(key)
 }
 
-func (c *Cache) GetTokenHMACKey(oidcIssuer string) []byte {
-	return bytesOrNil(c.getFederationDomainCache(oidcIssuer).tokenHMACKey.Load())
+func (c *Cache) GetTokenHMACKeys(oidcIssuer string) [][]byte {
+	return keysOrNil(c.getFederationDomainCache(oidcIssuer).tokenHMACKeys.Load())
 }
 
-func (c *Cache) SetTokenHMACKey(oidcIssuer string, key []byte) {
-	c.getFederationDomainCache(oidcIssuer).tokenHMACKey.Store(key)
+func (c *Cache) SetTokenHMACKeys(oidcIssuer string, keys [][]byte) {
+	c.getFederationDomainCache(oidcIssuer).tokenHMACKeys.Store(keys)
 }
 
-func (c *Cache) GetStateEncoderHashKey(oidcIssuer string) []byte {
-	return bytesOrNil(c.getFederationDomainCache(oidcIssuer).stateEncoderHashKey.Load())
+func (c *Cache) GetStateEncoderHashKeys(oidcIssuer string) [][]byte {
+	return keysOrNil(c.getFederationDomainCache(oidcIssuer).stateEncoderHashKeys.Load())
 }
 
-func (c *Cache) SetStateEncoderHashKey(oidcIssuer string, key []byte) {
-	c.getFederationDomainCache(oidcIssuer).stateEncoderHashKey.Store(key)
+func (c *Cache) SetStateEncoderHashKeys(oidcIssuer string, keys [][]byte) {
+	c.getFederationDomainCache(oidcIssuer).stateEncoderHashKeys.Store(keys)
 }
 
-func (c *Cache) GetStateEncoderBlockKey(oidcIssuer string) []byte {
-	return bytesOrNil(c.getFederationDomainCache(oidcIssuer).stateEncoderBlockKey.Load())
+func (c *Cache) GetStateEncoderBlockKeys(oidcIssuer string) [][]byte {
+	return keysOrNil(c.getFederationDomainCache(oidcIssuer).stateEncoderBlockKeys.Load())
 }
 
-func (c *Cache) SetStateEncoderBlockKey(oidcIssuer string, key []byte) {
-	c.getFederationDomainCache(oidcIssuer).stateEncoderBlockKey.Store(key)
+func (c *Cache) SetStateEncoderBlockKeys(oidcIssuer string, keys [][]byte) {
+	c.getFederationDomainCache(oidcIssuer).stateEncoderBlockKeys.Store(keys)
 }
 
 func (c *Cache) getFederationDomainCache(oidcIssuer string) *federationDomainCache {
@@ -69,3 +73,10 @@ func bytesOrNil(b interface{}) []byte {
 	}
 	return b.([]byte)
 }
+
+func keysOrNil(k interface{}) [][]byte {
+	if k == nil {
+		return nil
+	}
+	return k.([][]byte)
+}
@@ -16,21 +16,32 @@ import (
 
 	coreosoidc "github.com/coreos/go-oidc/v3/oidc"
 	"github.com/ory/fosite"
-	"github.com/ory/fosite/handler/openid"
+	"github.com/ory/fosite/compose"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/oauth2"
 	"gopkg.in/square/go-jose.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apiserver/pkg/authentication/authenticator"
 	"k8s.io/client-go/kubernetes/fake"
 	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	configv1alpha1listers "go.pinniped.dev/generated/latest/client/supervisor/listers/config/v1alpha1"
 	"go.pinniped.dev/internal/crud"
 	"go.pinniped.dev/internal/fositestorage/authorizationcode"
 	"go.pinniped.dev/internal/fositestorage/openidconnect"
 	pkce2 "go.pinniped.dev/internal/fositestorage/pkce"
 	"go.pinniped.dev/internal/fositestoragei"
+	"go.pinniped.dev/internal/idtransform"
+	"go.pinniped.dev/internal/oidc/clientregistry"
 	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/psession"
 	"go.pinniped.dev/internal/testutil"
 	"go.pinniped.dev/pkg/oidcclient/nonce"
 	"go.pinniped.dev/pkg/oidcclient/oidctypes"
@@ -49,10 +60,28 @@ type ExchangeAuthcodeAndValidateTokenArgs struct {
 	RedirectURI          string
 }
 
+// RefreshArgs is used to spy on calls to TestUpstreamOIDCIdentityProvider.RefreshFunc()
+// and TestUpstreamLDAPIdentityProvider.RefreshUserFunc().
+type RefreshArgs struct {
+	Ctx          context.Context
+	RefreshToken string
+	Username     string
+}
+
+// ValidateTokenArgs is used to spy on calls to TestUpstreamOIDCIdentityProvider.ValidateTokenFunc().
+type ValidateTokenArgs struct {
+	Ctx                  context.Context
+	Tok                  *oauth2.Token
+	ExpectedIDTokenNonce nonce.Nonce
+}
+
 type TestUpstreamLDAPIdentityProvider struct {
 	Name             string
 	URL              *url.URL
 	AuthenticateFunc func(ctx context.Context, username, password string) (*authenticator.Response, bool, error)
+	RefreshUserFunc  func(ctx context.Context, username string) (*authenticator.Response, bool, error)
+
+	refreshUserArgs []*RefreshArgs
 }
 
 var _ provider.UpstreamLDAPIdentityProviderI = &TestUpstreamLDAPIdentityProvider{}
@@ -69,6 +98,22 @@ func (u *TestUpstreamLDAPIdentityProvider) GetURL() *url.URL {
 	return u.URL
 }
 
+func (u *TestUpstreamLDAPIdentityProvider) RefreshUser(ctx context.Context, username string) (*authenticator.Response, bool, error) {
+	u.refreshUserArgs = append(u.refreshUserArgs, &RefreshArgs{
+		Ctx:      ctx,
+		Username: username,
+	})
+	return u.RefreshUserFunc(ctx, username)
+}
+
+func (u *TestUpstreamLDAPIdentityProvider) RefreshUserCallCount() int {
+	return len(u.refreshUserArgs)
+}
+
+func (u *TestUpstreamLDAPIdentityProvider) RefreshUserArgs(call int) *RefreshArgs {
+	return u.refreshUserArgs[call]
+}
+
 type TestUpstreamOIDCIdentityProvider struct {
 	Name                                  string
 	ClientID                              string
@@ -82,9 +127,13 @@ type TestUpstreamOIDCIdentityProvider struct {
 		pkceCodeVerifier pkce.Code,
 		expectedIDTokenNonce nonce.Nonce,
 	) (*oidctypes.Token, error)
+	RefreshFunc       func(ctx context.Context, refreshToken string) (*oidctypes.Token, error)
+	ValidateTokenFunc func(ctx context.Context, tok *oauth2.Token, expectedIDTokenNonce nonce.Nonce) (*oidctypes.Token, error)
 
 	exchangeAuthcodeAndValidateTokensCallCount int
 	exchangeAuthcodeAndValidateTokensArgs      []*ExchangeAuthcodeAndValidateTokenArgs
+	refreshArgs                                []*RefreshArgs
+	validateTokenArgs                          []*ValidateTokenArgs
 }
 
 func (u *TestUpstreamOIDCIdentityProvider) GetName() string {
@@ -143,13 +192,120 @@ func (u *TestUpstreamOIDCIdentityProvider) ExchangeAuthcodeAndValidateTokensArgs
 	return u.exchangeAuthcodeAndValidateTokensArgs[call]
 }
 
-func (u *TestUpstreamOIDCIdentityProvider) ValidateToken(_ context.Context, _ *oauth2.Token, _ nonce.Nonce) (*oidctypes.Token, error) {
-	panic("implement me")
+func (u *TestUpstreamOIDCIdentityProvider) ValidateToken(ctx context.Context, tok *oauth2.Token, expectedIDTokenNonce nonce.Nonce) (*oidctypes.Token, error) {
+	u.validateTokenArgs = append(u.validateTokenArgs, &ValidateTokenArgs{
+		Ctx:                  ctx,
+		Tok:                  tok,
+		ExpectedIDTokenNonce: expectedIDTokenNonce,
+	})
+	return u.ValidateTokenFunc(ctx, tok, expectedIDTokenNonce)
+}
+
+func (u *TestUpstreamOIDCIdentityProvider) ValidateTokenCallCount() int {
+	return len(u.validateTokenArgs)
+}
+
+func (u *TestUpstreamOIDCIdentityProvider) ValidateTokenArgs(call int) *ValidateTokenArgs {
+	return u.validateTokenArgs[call]
+}
+
+func (u *TestUpstreamOIDCIdentityProvider) Refresh(ctx context.Context, refreshToken string) (*oidctypes.Token, error) {
+	u.refreshArgs = append(u.refreshArgs, &RefreshArgs{
+		Ctx:          ctx,
+		RefreshToken: refreshToken,
+	})
+	return u.RefreshFunc(ctx, refreshToken)
+}
+
+func (u *TestUpstreamOIDCIdentityProvider) RefreshCallCount() int {
+	return len(u.refreshArgs)
+}
+
+func (u *TestUpstreamOIDCIdentityProvider) RefreshArgs(call int) *RefreshArgs {
+	return u.refreshArgs[call]
+}
+
+// AuthnRequestArgs is used to spy on calls to TestUpstreamSAMLIdentityProvider.AuthnRequestFunc().
+type AuthnRequestArgs struct {
+	RequestID  string
+	SPEntityID string
+	ACSURL     string
+	RelayState string
+}
+
+// ValidateResponseArgs is used to spy on calls to TestUpstreamSAMLIdentityProvider.ValidateResponseFunc().
+type ValidateResponseArgs struct {
+	Ctx             context.Context
+	EncodedResponse string
+	RequestID       string
+	SPEntityID      string
+	ACSURL          string
+}
+
+type TestUpstreamSAMLIdentityProvider struct {
+	Name                 string
+	EntityID             string
+	AuthnRequestFunc     func(requestID string, spEntityID string, acsURL string, relayState string) (*provider.SAMLAuthnRequest, error)
+	ValidateResponseFunc func(ctx context.Context, encodedResponse string, requestID string, spEntityID string, acsURL string) (*authenticator.Response, error)
+
+	authnRequestArgs     []*AuthnRequestArgs
+	validateResponseArgs []*ValidateResponseArgs
+}
+
+var _ provider.UpstreamSAMLIdentityProviderI = &TestUpstreamSAMLIdentityProvider{}
+
+func (u *TestUpstreamSAMLIdentityProvider) GetName() string {
+	return u.Name
+}
+
+func (u *TestUpstreamSAMLIdentityProvider) GetEntityID() string {
+	return u.EntityID
+}
+
+func (u *TestUpstreamSAMLIdentityProvider) AuthnRequest(requestID string, spEntityID string, acsURL string, relayState string) (*provider.SAMLAuthnRequest, error) {
+	u.authnRequestArgs = append(u.authnRequestArgs, &AuthnRequestArgs{
+		RequestID:  requestID,
+		SPEntityID: spEntityID,
+		ACSURL:     acsURL,
+		RelayState: relayState,
+	})
+	return u.AuthnRequestFunc(requestID, spEntityID, acsURL, relayState)
+}
+
+func (u *TestUpstreamSAMLIdentityProvider) AuthnRequestCallCount() int {
+	return len(u.authnRequestArgs)
+}
+
+func (u *TestUpstreamSAMLIdentityProvider) AuthnRequestArgs(call int) *AuthnRequestArgs {
+	return u.authnRequestArgs[call]
+}
+
+func (u *TestUpstreamSAMLIdentityProvider) ValidateResponse(ctx context.Context, encodedResponse string, requestID string, spEntityID string, acsURL string) (*authenticator.Response, error) {
+	u.validateResponseArgs = append(u.validateResponseArgs, &ValidateResponseArgs{
+		Ctx:             ctx,
+		EncodedResponse: encodedResponse,
+		RequestID:       requestID,
+		SPEntityID:      spEntityID,
+		ACSURL:          acsURL,
+	})
+	return u.ValidateResponseFunc(ctx, encodedResponse, requestID, spEntityID, acsURL)
+}
+
+func (u *TestUpstreamSAMLIdentityProvider) ValidateResponseCallCount() int {
+	return len(u.validateResponseArgs)
+}
+
+func (u *TestUpstreamSAMLIdentityProvider) ValidateResponseArgs(call int) *ValidateResponseArgs {
+	return u.validateResponseArgs[call]
 }
 
 type UpstreamIDPListerBuilder struct {
 	upstreamOIDCIdentityProviders []*TestUpstreamOIDCIdentityProvider
 	upstreamLDAPIdentityProviders []*TestUpstreamLDAPIdentityProvider
+	upstreamADIdentityProviders   []*TestUpstreamLDAPIdentityProvider
+	upstreamGitHubProviders       []*TestUpstreamOIDCIdentityProvider
+	upstreamSAMLProviders         []*TestUpstreamSAMLIdentityProvider
+	identityTransforms            map[string]*idtransform.Pipeline
 }
 
 func (b *UpstreamIDPListerBuilder) WithOIDC(upstreamOIDCIdentityProviders ...*TestUpstreamOIDCIdentityProvider) *UpstreamIDPListerBuilder {
@@ -162,7 +318,32 @@ func (b *UpstreamIDPListerBuilder) WithLDAP(upstreamLDAPIdentityProviders ...*Te
 	return b
 }
 
-func (b *UpstreamIDPListerBuilder) Build() provider.DynamicUpstreamIDPProvider {
+func (b *UpstreamIDPListerBuilder) WithActiveDirectory(upstreamActiveDirectoryIdentityProviders ...*TestUpstreamLDAPIdentityProvider) *UpstreamIDPListerBuilder {
+	b.upstreamADIdentityProviders = append(b.upstreamADIdentityProviders, upstreamActiveDirectoryIdentityProviders...)
+	return b
+}
+
+// WithGitHub adds GitHub upstreams, which have the same interface as OIDC upstreams.
+func (b *UpstreamIDPListerBuilder) WithGitHub(upstreamGitHubIdentityProviders ...*TestUpstreamOIDCIdentityProvider) *UpstreamIDPListerBuilder {
+	b.upstreamGitHubProviders = append(b.upstreamGitHubProviders, upstreamGitHubIdentityProviders...)
+	return b
+}
+
+func (b *UpstreamIDPListerBuilder) WithSAML(upstreamSAMLIdentityProviders ...*TestUpstreamSAMLIdentityProvider) *UpstreamIDPListerBuilder {
+	b.upstreamSAMLProviders = append(b.upstreamSAMLProviders, upstreamSAMLIdentityProviders...)
+	return b
+}
+
+// WithIdentityTransforms configures the identity transforms for the upstream IDP of the given name and type.
+func (b *UpstreamIDPListerBuilder) WithIdentityTransforms(idpName string, idpType string, transforms *idtransform.Pipeline) *UpstreamIDPListerBuilder {
+	if b.identityTransforms == nil {
+		b.identityTransforms = map[string]*idtransform.Pipeline{}
+	}
+	b.identityTransforms[idpType+"/"+idpName] = transforms
+	return b
+}
+
+func (b *UpstreamIDPListerBuilder) Build() *UpstreamIDPLister {
 	idpProvider := provider.NewDynamicUpstreamIDPProvider()
 
 	oidcUpstreams := make([]provider.UpstreamOIDCIdentityProviderI, len(b.upstreamOIDCIdentityProviders))
@@ -177,7 +358,39 @@ func (b *UpstreamIDPListerBuilder) Build() provider.DynamicUpstreamIDPProvider {
 	}
 	idpProvider.SetLDAPIdentityProviders(ldapUpstreams)
 
-	return idpProvider
+	adUpstreams := make([]provider.UpstreamLDAPIdentityProviderI, len(b.upstreamADIdentityProviders))
+	for i := range b.upstreamADIdentityProviders {
+		adUpstreams[i] = provider.UpstreamLDAPIdentityProviderI(b.upstreamADIdentityProviders[i])
+	}
+	idpProvider.SetActiveDirectoryIdentityProviders(adUpstreams)
+
+	gitHubUpstreams := make([]provider.UpstreamOIDCIdentityProviderI, len(b.upstreamGitHubProviders))
+	for i := range b.upstreamGitHubProviders {
+		gitHubUpstreams[i] = provider.UpstreamOIDCIdentityProviderI(b.upstreamGitHubProviders[i])
+	}
+	idpProvider.SetGitHubIdentityProviders(gitHubUpstreams)
+
+	samlUpstreams := make([]provider.UpstreamSAMLIdentityProviderI, len(b.upstreamSAMLProviders))
+	for i := range b.upstreamSAMLProviders {
+		samlUpstreams[i] = provider.UpstreamSAMLIdentityProviderI(b.upstreamSAMLProviders[i])
+	}
+	idpProvider.SetSAMLIdentityProviders(samlUpstreams)
+
+	return &UpstreamIDPLister{
+		DynamicUpstreamIDPProvider: idpProvider,
+		identityTransforms:         b.identityTransforms,
+	}
+}
+
+// UpstreamIDPLister is an in-memory cache of upstream IDPs which also looks up their identity transforms, like the
+// upstream IDP lister of a FederationDomain does.
+type UpstreamIDPLister struct {
+	provider.DynamicUpstreamIDPProvider
+	identityTransforms map[string]*idtransform.Pipeline
+}
+
+func (l *UpstreamIDPLister) GetIdentityTransforms(idpName string, idpType string) *idtransform.Pipeline {
+	return l.identityTransforms[idpType+"/"+idpName]
 }
 
 func NewUpstreamIDPListerBuilder() *UpstreamIDPListerBuilder {
@@ -195,6 +408,8 @@ type ExpectedUpstreamStateParamFormat struct {
 	C string `json:"c"`
 	K string `json:"k"`
 	V string `json:"v"`
+	D string `json:"d,omitempty"`
+	T string `json:"t,omitempty"`
 }
 
 type staticKeySet struct {
@@ -252,6 +467,7 @@ func RequireAuthCodeRegexpMatch(
 	wantDownstreamNonce string,
 	wantDownstreamClientID string,
 	wantDownstreamRedirectURI string,
+	wantDownstreamCustomSessionData *psession.CustomSessionData,
 ) {
 	t.Helper()
 
@@ -286,6 +502,7 @@ func RequireAuthCodeRegexpMatch(
 		wantDownstreamRequestedScopes,
 		wantDownstreamClientID,
 		wantDownstreamRedirectURI,
+		wantDownstreamCustomSessionData,
 	)
 
 	// One PKCE should have been stored.
@@ -336,7 +553,8 @@ func validateAuthcodeStorage(
 	wantDownstreamRequestedScopes []string,
 	wantDownstreamClientID string,
 	wantDownstreamRedirectURI string,
-) (*fosite.Request, *openid.DefaultSession) {
+	wantDownstreamCustomSessionData *psession.CustomSessionData,
+) (*fosite.Request, *psession.PinnipedSession) {
 	t.Helper()
 
 	const (
@@ -368,6 +586,9 @@ func validateAuthcodeStorage(
 	require.Empty(t, storedSessionFromAuthcode.Username)
 	require.Empty(t, storedSessionFromAuthcode.Headers)
 
+	// Check the custom session data which is needed to refresh the upstream session later.
+	require.Equal(t, wantDownstreamCustomSessionData, storedSessionFromAuthcode.Custom)
+
 	// The authcode that we are issuing should be good for the length of time that we declare in the fosite config.
 	testutil.RequireTimeInDelta(t, time.Now().Add(authCodeExpirationSeconds*time.Second), storedSessionFromAuthcode.ExpiresAt[fosite.AuthorizeCode], timeComparisonFudgeFactor)
 	require.Len(t, storedSessionFromAuthcode.ExpiresAt, 1)
@@ -415,7 +636,7 @@ func validatePKCEStorage(
 	oauthStore fositestoragei.AllFositeStorage,
 	storeKey string,
 	storedRequestFromAuthcode *fosite.Request,
-	storedSessionFromAuthcode *openid.DefaultSession,
+	storedSessionFromAuthcode *psession.PinnipedSession,
 	wantDownstreamPKCEChallenge, wantDownstreamPKCEChallengeMethod string,
 ) {
 	t.Helper()
@@ -440,7 +661,7 @@ func validateIDSessionStorage(
 	oauthStore fositestoragei.AllFositeStorage,
 	storeKey string,
 	storedRequestFromAuthcode *fosite.Request,
-	storedSessionFromAuthcode *openid.DefaultSession,
+	storedSessionFromAuthcode *psession.PinnipedSession,
 	wantDownstreamNonce string,
 ) {
 	t.Helper()
@@ -459,13 +680,87 @@ func validateIDSessionStorage(
 	require.Equal(t, wantDownstreamNonce, storedRequestFromIDSession.Form.Get("nonce"))
 }
 
-func castStoredAuthorizeRequest(t *testing.T, storedAuthorizeRequest fosite.Requester) (*fosite.Request, *openid.DefaultSession) {
+func castStoredAuthorizeRequest(t *testing.T, storedAuthorizeRequest fosite.Requester) (*fosite.Request, *psession.PinnipedSession) {
 	t.Helper()
 
 	storedRequest, ok := storedAuthorizeRequest.(*fosite.Request)
 	require.Truef(t, ok, "could not cast %T to %T", storedAuthorizeRequest, &fosite.Request{})
-	storedSession, ok := storedAuthorizeRequest.GetSession().(*openid.DefaultSession)
-	require.Truef(t, ok, "could not cast %T to %T", storedAuthorizeRequest.GetSession(), &openid.DefaultSession{})
+	storedSession, ok := storedAuthorizeRequest.GetSession().(*psession.PinnipedSession)
+	require.Truef(t, ok, "could not cast %T to %T", storedAuthorizeRequest.GetSession(), &psession.PinnipedSession{})
 
 	return storedRequest, storedSession
 }
+
+// NewClientManager returns a clientregistry.ClientManager which is backed by caches that contain only the given
+// OIDCClients and Secrets, which should all be in the given namespace.
+func NewClientManager(namespace string, objects ...runtime.Object) *clientregistry.ClientManager {
+	oidcClientsIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	secretsIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, obj := range objects {
+		switch o := obj.(type) {
+		case *configv1alpha1.OIDCClient:
+			_ = oidcClientsIndexer.Add(o)
+		case *corev1.Secret:
+			_ = secretsIndexer.Add(o)
+		default:
+			panic(fmt.Sprintf("unexpected object type %T", obj))
+		}
+	}
+	return clientregistry.NewClientManager(
+		configv1alpha1listers.NewOIDCClientLister(oidcClientsIndexer).OIDCClients(namespace),
+		corev1listers.NewSecretLister(secretsIndexer).Secrets(namespace),
+	)
+}
+
+// StoreDownstreamTokens generates a downstream access token and refresh token for the given request using the given
+// HMAC secret, and stores them as if they had been issued by the token endpoint. The request's session should set
+// the expiration times of both tokens. Returns the access token and the refresh token.
+func StoreDownstreamTokens(
+	t *testing.T,
+	oauthStore fositestoragei.AllFositeStorage,
+	hmacSecret []byte,
+	request fosite.Requester,
+) (string, string) {
+	t.Helper()
+
+	ctx := context.Background()
+	strategy := compose.NewOAuth2HMACStrategy(&compose.Config{}, hmacSecret, nil)
+
+	accessToken, accessTokenSignature, err := strategy.GenerateAccessToken(ctx, request)
+	require.NoError(t, err)
+	require.NoError(t, oauthStore.CreateAccessTokenSession(ctx, accessTokenSignature, request))
+
+	refreshToken, refreshTokenSignature, err := strategy.GenerateRefreshToken(ctx, request)
+	require.NoError(t, err)
+	require.NoError(t, oauthStore.CreateRefreshTokenSession(ctx, refreshTokenSignature, request))
+
+	return accessToken, refreshToken
+}
+
+// ConfidentialOIDCClientSecret is the plaintext client secret of the clients returned by ConfidentialOIDCClient.
+const ConfidentialOIDCClientSecret = "some-client-secret" //nolint:gosec // this is not a real credential
+
+// ConfidentialOIDCClient returns a valid OIDCClient with the given name and the Secret which holds the hash of
+// ConfidentialOIDCClientSecret, which can be passed to NewClientManager.
+func ConfidentialOIDCClient(t *testing.T, namespace string, name string) (*configv1alpha1.OIDCClient, *corev1.Secret) {
+	t.Helper()
+
+	hashedSecret, err := bcrypt.GenerateFromPassword([]byte(ConfidentialOIDCClientSecret), 12)
+	require.NoError(t, err)
+
+	oidcClient := &configv1alpha1.OIDCClient{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: configv1alpha1.OIDCClientSpec{
+			AllowedRedirectURIs: []configv1alpha1.RedirectURI{"https://example.com/callback"},
+			AllowedGrantTypes:   []configv1alpha1.GrantType{"authorization_code", "refresh_token"},
+			AllowedScopes:       []configv1alpha1.Scope{"openid", "offline_access"},
+			ClientSecretName:    name + "-secret",
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name + "-secret", Namespace: namespace},
+		Type:       clientregistry.OIDCClientSecretType,
+		Data:       map[string][]byte{clientregistry.OIDCClientSecretBcryptHashKey: hashedSecret},
+	}
+	return oidcClient, secret
+}
@@ -0,0 +1,233 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.pinniped.dev/internal/plog"
+)
+
+const (
+	scopeName = "go.pinniped.dev/internal/tracing"
+
+	// Spans are buffered and exported in batches, like the batch span processor of the OpenTelemetry SDKs.
+	// Spans which are ended while the buffer is full are dropped, so that tracing never slows down a login.
+	maxQueueSize       = 2048
+	maxExportBatchSize = 512
+	exportInterval     = 5 * time.Second
+	exportTimeout      = 10 * time.Second
+
+	// The status code of a failed span in OTLP.
+	statusCodeError = 2
+)
+
+// exporter sends batches of spans to an OTLP/HTTP receiver using the JSON encoding of OTLP.
+type exporter struct {
+	url         string
+	serviceName string
+	client      *http.Client
+	queue       chan *Span
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+func newExporter(url, serviceName string) *exporter {
+	e := &exporter{
+		url:         url,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: exportTimeout, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment}},
+		queue:       make(chan *Span, maxQueueSize),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *exporter) enqueue(span *Span) {
+	select {
+	case e.queue <- span:
+	default:
+		plog.Debug("dropped a span because the tracing export queue is full", "span", span.name)
+	}
+}
+
+func (e *exporter) shutdown() {
+	close(e.stop)
+	<-e.done
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, maxExportBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.export(batch); err != nil {
+			plog.WarningErr("could not export spans", err, "url", e.url, "spans", len(batch))
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) == maxExportBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.stop:
+			for {
+				select {
+				case span := <-e.queue:
+					batch = append(batch, span)
+					if len(batch) == maxExportBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *exporter) export(spans []*Span) error {
+	body, err := json.Marshal(e.encode(spans))
+	if err != nil {
+		return fmt.Errorf("could not encode spans: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status %q", resp.Status)
+	}
+	return nil
+}
+
+// The following types are the JSON encoding of an OTLP ExportTraceServiceRequest. See
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/docs/specification.md#json-protobuf-encoding.
+
+type exportTraceServiceRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanJSON `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type spanJSON struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              SpanKind   `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            status     `json:"status"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+// anyValue uses strings for integers, as required by the JSON encoding of 64-bit integers.
+type anyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+type status struct {
+	Message string `json:"message,omitempty"`
+	Code    int    `json:"code,omitempty"`
+}
+
+func (e *exporter) encode(spans []*Span) *exportTraceServiceRequest {
+	encoded := make([]spanJSON, 0, len(spans))
+	for _, s := range spans {
+		s.lock.Lock()
+		sj := spanJSON{
+			TraceID:           hex.EncodeToString(s.spanContext.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanContext.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentSpanID != [8]byte{} {
+			sj.ParentSpanID = hex.EncodeToString(s.parentSpanID[:])
+		}
+		for _, a := range s.attributes {
+			sj.Attributes = append(sj.Attributes, keyValue{Key: a.Key, Value: a.value})
+		}
+		if s.failed {
+			sj.Status = status{Message: s.errMessage, Code: statusCodeError}
+		}
+		s.lock.Unlock()
+		encoded = append(encoded, sj)
+	}
+
+	serviceName := e.serviceName
+	return &exportTraceServiceRequest{
+		ResourceSpans: []resourceSpans{{
+			Resource: resource{Attributes: []keyValue{
+				{Key: "service.name", Value: anyValue{StringValue: &serviceName}},
+			}},
+			ScopeSpans: []scopeSpans{{
+				Scope: scope{Name: scopeName},
+				Spans: encoded,
+			}},
+		}},
+	}
+}
@@ -0,0 +1,396 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package tracing records OpenTelemetry spans of the login flows of the Supervisor and of the requests to the
+// Concierge, and exports them to an OpenTelemetry collector using OTLP over HTTP. Trace context is propagated
+// to and from other services using the W3C traceparent header, but never to upstream identity providers. The
+// traceparent header of an incoming request is only used when the request was made by a trusted client, since any
+// other client could use it to add spans to an arbitrary trace.
+//
+// Tracing is disabled by default, in which case all functions of this package are cheap no-ops.
+//
+// This package does not use the OpenTelemetry Go SDK, because its OTLP exporters require a version of gRPC which
+// is not compatible with the etcd client that is used by the version of k8s.io/apiserver in go.mod. It should be
+// replaced by the SDK once the Kubernetes libraries are upgraded.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/httputil/responsewriter"
+	"go.pinniped.dev/internal/httputil/roundtripper"
+)
+
+// ErrInvalidEndpoint is returned by ValidateEndpoint.
+const ErrInvalidEndpoint = constable.Error("OTLP endpoint must be empty or an http or https URL without a query or fragment")
+
+const traceparentHeader = "traceparent"
+
+// SpanKind is the role of a span in a trace. The values are the ones used by OTLP.
+type SpanKind int
+
+const (
+	// SpanKindInternal is an operation which does not cross a process boundary, e.g. an LDAP authentication.
+	SpanKindInternal SpanKind = 1
+
+	// SpanKindServer is the handling of an incoming request.
+	SpanKindServer SpanKind = 2
+
+	// SpanKindClient is an outgoing request to another service.
+	SpanKindClient SpanKind = 3
+)
+
+// Attribute is a key and value which describes a span.
+type Attribute struct {
+	Key   string
+	value anyValue
+}
+
+// String returns a string attribute.
+func String(key, value string) Attribute {
+	return Attribute{Key: key, value: anyValue{StringValue: &value}}
+}
+
+// Int returns an integer attribute.
+func Int(key string, value int) Attribute {
+	s := strconv.Itoa(value)
+	return Attribute{Key: key, value: anyValue{IntValue: &s}}
+}
+
+// Bool returns a boolean attribute.
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, value: anyValue{BoolValue: &value}}
+}
+
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+func (sc spanContext) isValid() bool {
+	return sc.traceID != [16]byte{} && sc.spanID != [8]byte{}
+}
+
+// Span is a single timed operation of a trace. A nil *Span is valid and does nothing, which is what Start returns
+// when tracing is disabled.
+type Span struct {
+	exporter     *exporter
+	spanContext  spanContext
+	parentSpanID [8]byte
+	name         string
+	kind         SpanKind
+	start        time.Time
+
+	lock       sync.Mutex
+	end        time.Time
+	attributes []Attribute
+	errMessage string
+	failed     bool
+	ended      bool
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.attributes = append(s.attributes, attributes...)
+}
+
+// RecordError marks the span as failed with the given error. A nil error is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.failed = true
+	s.errMessage = err.Error()
+}
+
+// End finishes the span and queues it for export. Only the first call has any effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = now()
+	s.lock.Unlock()
+
+	s.exporter.enqueue(s)
+}
+
+//nolint: gochecknoglobals // the tracer is process wide, just like the plog logger
+var (
+	lock           sync.RWMutex
+	activeExporter *exporter
+	trustedClients []*net.IPNet
+	now            = time.Now
+)
+
+// ValidateEndpoint checks the OTLP endpoint setting, which may be empty to disable tracing, or the base URL of an
+// OTLP/HTTP receiver such as http://otel-collector.observability.svc:4318.
+func ValidateEndpoint(endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return ErrInvalidEndpoint
+	}
+	return nil
+}
+
+// ValidateTrustedClients checks the trusted clients setting, which is a list of CIDRs.
+func ValidateTrustedClients(cidrs []string) error {
+	_, err := parseTrustedClients(cidrs)
+	return err
+}
+
+func parseTrustedClients(cidrs []string) ([]*net.IPNet, error) {
+	parsed := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted clients: %w", err)
+		}
+		parsed = append(parsed, ipNet)
+	}
+	return parsed, nil
+}
+
+// Configure starts exporting spans to the given OTLP endpoint, which must be valid according to ValidateEndpoint,
+// on behalf of the named service. An empty endpoint leaves tracing disabled. The traceparent headers of requests
+// are only used when they come from one of the trusted client CIDRs. The returned function stops tracing and
+// flushes the spans which have not been exported yet.
+func Configure(endpoint, serviceName string, trustedClientCIDRs []string) (func(), error) {
+	if endpoint == "" {
+		return func() {}, nil
+	}
+	if err := ValidateEndpoint(endpoint); err != nil {
+		return nil, err
+	}
+	parsedTrustedClients, err := parseTrustedClients(trustedClientCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	e := newExporter(strings.TrimSuffix(endpoint, "/")+"/v1/traces", serviceName)
+
+	lock.Lock()
+	activeExporter = e
+	trustedClients = parsedTrustedClients
+	lock.Unlock()
+
+	return func() {
+		lock.Lock()
+		if activeExporter == e {
+			activeExporter = nil
+			trustedClients = nil
+		}
+		lock.Unlock()
+		e.shutdown()
+	}, nil
+}
+
+// isTrustedClient returns whether the request was made from one of the trusted client CIDRs.
+func isTrustedClient(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	lock.RLock()
+	defer lock.RUnlock()
+	for _, cidr := range trustedClients {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type spanContextKey struct{}
+
+func spanContextFrom(ctx context.Context) spanContext {
+	sc, _ := ctx.Value(spanContextKey{}).(spanContext)
+	return sc
+}
+
+// Start begins a new span, which is a child of the span in the context, if any. The returned context contains the
+// new span, so that the spans of nested operations will be its children. Callers must End the returned span.
+func Start(ctx context.Context, name string, kind SpanKind, attributes ...Attribute) (context.Context, *Span) {
+	lock.RLock()
+	e := activeExporter
+	lock.RUnlock()
+
+	if e == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		exporter:   e,
+		name:       name,
+		kind:       kind,
+		start:      now(),
+		attributes: attributes,
+	}
+
+	if parent := spanContextFrom(ctx); parent.isValid() {
+		span.spanContext.traceID = parent.traceID
+		span.parentSpanID = parent.spanID
+	} else {
+		randomBytes(span.spanContext.traceID[:])
+	}
+	randomBytes(span.spanContext.spanID[:])
+
+	return context.WithValue(ctx, spanContextKey{}, span.spanContext), span
+}
+
+func randomBytes(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		// This should never happen, and an invalid ID only means that the span cannot be correlated.
+		for i := range b {
+			b[i] = 0
+		}
+	}
+}
+
+// Handler wraps an http.Handler to record a server span for each request, named after the given operation.
+// A traceparent header in a request from a trusted client makes the span a part of the trace of the caller.
+// Otherwise, the span starts a new trace.
+func Handler(operation string, delegate http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if parent, ok := parseTraceparent(r.Header.Get(traceparentHeader)); ok && isTrustedClient(r) {
+			ctx = context.WithValue(ctx, spanContextKey{}, parent)
+		}
+
+		ctx, span := Start(ctx, operation, SpanKindServer,
+			String("http.method", r.Method),
+			String("http.target", r.URL.Path),
+		)
+		if span == nil {
+			delegate.ServeHTTP(w, r)
+			return
+		}
+		defer span.End()
+
+		rw := responsewriter.NewStatusRecorder(w)
+		delegate.ServeHTTP(rw, r.WithContext(ctx))
+
+		code := rw.StatusCode()
+		span.SetAttributes(Int("http.status_code", code))
+		if code >= http.StatusInternalServerError {
+			span.RecordError(fmt.Errorf("%d %s", code, http.StatusText(code)))
+		}
+	})
+}
+
+// WrapTransport wraps an http.RoundTripper to record a client span for each request which is made on behalf of a
+// traced operation, and to propagate the trace to the server using the traceparent header. Requests which are not
+// part of a trace, such as those made by controllers, are passed through untouched.
+func WrapTransport(rt http.RoundTripper) http.RoundTripper {
+	return wrapTransport(rt, true)
+}
+
+// WrapUpstreamClient returns a shallow copy of the http.Client which records a client span for each request which is
+// made on behalf of a traced operation. Unlike WrapTransport, it never sends a traceparent header, because the client
+// is used for upstream identity providers, which are third parties that should not learn about the traces of the
+// Supervisor.
+func WrapUpstreamClient(client *http.Client) *http.Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	rt := client.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	wrapped := *client
+	wrapped.Transport = wrapTransport(rt, false)
+	return &wrapped
+}
+
+func wrapTransport(rt http.RoundTripper, propagate bool) http.RoundTripper {
+	return roundtripper.Func(func(req *http.Request) (*http.Response, error) {
+		if !spanContextFrom(req.Context()).isValid() {
+			return rt.RoundTrip(req)
+		}
+
+		ctx, span := Start(req.Context(), "HTTP "+req.Method, SpanKindClient,
+			String("http.method", req.Method),
+			String("http.url", (&url.URL{Scheme: req.URL.Scheme, Host: req.URL.Host, Path: req.URL.Path}).String()),
+			String("net.peer.name", req.URL.Hostname()),
+		)
+		if span == nil {
+			return rt.RoundTrip(req)
+		}
+		defer span.End()
+
+		// A RoundTripper must not modify the original request.
+		req = req.Clone(ctx)
+		if propagate {
+			req.Header.Set(traceparentHeader, formatTraceparent(span.spanContext))
+		}
+
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		span.SetAttributes(Int("http.status_code", resp.StatusCode))
+		if resp.StatusCode >= http.StatusBadRequest {
+			span.RecordError(fmt.Errorf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode)))
+		}
+		return resp, nil
+	})
+}
+
+// parseTraceparent parses a W3C traceparent header, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func parseTraceparent(header string) (spanContext, bool) {
+	var sc spanContext
+	parts := strings.Split(header, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.traceID[:], []byte(parts[1])); err != nil {
+		return spanContext{}, false
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(parts[2])); err != nil {
+		return spanContext{}, false
+	}
+	if !sc.isValid() {
+		return spanContext{}, false
+	}
+	return sc, true
+}
+
+// formatTraceparent returns the W3C traceparent header of a span, which is always sampled.
+func formatTraceparent(sc spanContext) string {
+	return "00-" + hex.EncodeToString(sc.traceID[:]) + "-" + hex.EncodeToString(sc.spanID[:]) + "-01"
+}
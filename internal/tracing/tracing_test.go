@@ -0,0 +1,288 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		wantErr  error
+	}{
+		{endpoint: ""},
+		{endpoint: "http://otel-collector.observability.svc:4318"},
+		{endpoint: "https://otel-collector.example.com/some/path/"},
+		{endpoint: "otel-collector:4317", wantErr: ErrInvalidEndpoint},
+		{endpoint: "grpc://otel-collector:4317", wantErr: ErrInvalidEndpoint},
+		{endpoint: "http://otel-collector:4318?foo=bar", wantErr: ErrInvalidEndpoint},
+		{endpoint: "http:///v1/traces", wantErr: ErrInvalidEndpoint},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.endpoint, func(t *testing.T) {
+			require.Equal(t, tt.wantErr, ValidateEndpoint(tt.endpoint))
+		})
+	}
+}
+
+func TestValidateTrustedClients(t *testing.T) {
+	require.NoError(t, ValidateTrustedClients(nil))
+	require.NoError(t, ValidateTrustedClients([]string{"10.0.0.0/8", "fd00::/8"}))
+	require.EqualError(t, ValidateTrustedClients([]string{"10.0.0.1"}), "invalid trusted clients: invalid CIDR address: 10.0.0.1")
+
+	_, err := Configure("http://otel-collector.observability.svc:4318", "some-service", []string{"10.0.0.1"})
+	require.EqualError(t, err, "invalid trusted clients: invalid CIDR address: 10.0.0.1")
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		wantOK bool
+	}{
+		{name: "valid", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantOK: true},
+		{name: "future version with more fields", header: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", wantOK: true},
+		{name: "empty", header: ""},
+		{name: "invalid version", header: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "version 00 with more fields", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+		{name: "zero trace ID", header: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{name: "zero span ID", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+		{name: "short trace ID", header: "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01"},
+		{name: "not hex", header: "00-4bf92f3577b34da6a3ce929d0e0e47z-00f067aa0ba902b7-01"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := parseTraceparent(tt.header)
+			require.Equal(t, tt.wantOK, ok)
+			if tt.wantOK {
+				require.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", formatTraceparent(sc))
+			}
+		})
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	shutdown, err := Configure("", "some-service", nil)
+	require.NoError(t, err)
+	defer shutdown()
+
+	ctx, span := Start(context.Background(), "some-operation", SpanKindInternal)
+	require.Nil(t, span)
+	require.Equal(t, context.Background(), ctx)
+
+	// All methods of a nil span are no-ops.
+	span.SetAttributes(String("some-key", "some-value"))
+	span.RecordError(fmt.Errorf("some error"))
+	span.End()
+
+	var gotTraceparent []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTraceparent = r.Header.Values("traceparent")
+	}))
+	defer upstream.Close()
+
+	Handler("some-operation", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+		require.NoError(t, err)
+		resp, err := (&http.Client{Transport: WrapTransport(upstream.Client().Transport)}).Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/some/path", nil))
+
+	require.Empty(t, gotTraceparent)
+}
+
+func TestExport(t *testing.T) {
+	var (
+		collectorLock sync.Mutex
+		requests      []exportTraceServiceRequest
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/some/prefix/v1/traces", r.URL.Path)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var req exportTraceServiceRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		collectorLock.Lock()
+		requests = append(requests, req)
+		collectorLock.Unlock()
+	}))
+	defer collector.Close()
+
+	var gotTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTraceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer upstream.Close()
+
+	var gotIDPTraceparent []string
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotIDPTraceparent = r.Header.Values("traceparent")
+	}))
+	defer idp.Close()
+
+	// The requests of httptest.NewRequest come from 192.0.2.1.
+	shutdown, err := Configure(collector.URL+"/some/prefix/", "some-service", []string{"192.0.2.0/24"})
+	require.NoError(t, err)
+
+	handler := Handler("some-operation", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "some-internal-operation", SpanKindInternal, Bool("some-bool", true))
+		span.RecordError(fmt.Errorf("some error"))
+		span.End()
+		span.End() // ending a span more than once is allowed
+
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, upstream.URL+"/some/upstream/path?secret=value", nil)
+		require.NoError(t, err)
+		resp, err := (&http.Client{Transport: WrapTransport(upstream.Client().Transport)}).Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		// The original request is not modified.
+		require.Empty(t, req.Header.Get("traceparent"))
+
+		req, err = http.NewRequestWithContext(r.Context(), http.MethodGet, idp.URL+"/some/idp/path", nil)
+		require.NoError(t, err)
+		resp, err = WrapUpstreamClient(idp.Client()).Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		w.WriteHeader(http.StatusFound)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/some/path?some=query", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	shutdown()
+
+	collectorLock.Lock()
+	defer collectorLock.Unlock()
+	require.Len(t, requests, 1)
+	require.Len(t, requests[0].ResourceSpans, 1)
+	require.Equal(t, "service.name", requests[0].ResourceSpans[0].Resource.Attributes[0].Key)
+	require.Equal(t, "some-service", *requests[0].ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+	require.Len(t, requests[0].ResourceSpans[0].ScopeSpans, 1)
+	require.Equal(t, scopeName, requests[0].ResourceSpans[0].ScopeSpans[0].Scope.Name)
+
+	spans := requests[0].ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 4)
+	internalSpan, clientSpan, idpClientSpan, serverSpan := spans[0], spans[1], spans[2], spans[3]
+
+	// All spans are part of the trace of the caller.
+	for _, s := range spans {
+		require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", s.TraceID)
+		require.Len(t, s.SpanID, 16)
+		require.NotEmpty(t, s.StartTimeUnixNano)
+		require.NotEmpty(t, s.EndTimeUnixNano)
+	}
+
+	require.Equal(t, "some-operation", serverSpan.Name)
+	require.Equal(t, SpanKindServer, serverSpan.Kind)
+	require.Equal(t, "00f067aa0ba902b7", serverSpan.ParentSpanID)
+	require.Equal(t, status{}, serverSpan.Status)
+	requireAttributes(t, map[string]string{
+		"http.method":      "GET",
+		"http.target":      "/some/path",
+		"http.status_code": "302",
+	}, serverSpan.Attributes)
+
+	require.Equal(t, "some-internal-operation", internalSpan.Name)
+	require.Equal(t, SpanKindInternal, internalSpan.Kind)
+	require.Equal(t, serverSpan.SpanID, internalSpan.ParentSpanID)
+	require.Equal(t, status{Message: "some error", Code: statusCodeError}, internalSpan.Status)
+	requireAttributes(t, map[string]string{"some-bool": "true"}, internalSpan.Attributes)
+
+	require.Equal(t, "HTTP POST", clientSpan.Name)
+	require.Equal(t, SpanKindClient, clientSpan.Kind)
+	require.Equal(t, serverSpan.SpanID, clientSpan.ParentSpanID)
+	require.Equal(t, status{Message: "401 Unauthorized", Code: statusCodeError}, clientSpan.Status)
+	require.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+clientSpan.SpanID+"-01", gotTraceparent)
+	requireAttributes(t, map[string]string{
+		"http.method":      "POST",
+		"http.url":         upstream.URL + "/some/upstream/path",
+		"net.peer.name":    "127.0.0.1",
+		"http.status_code": "401",
+	}, clientSpan.Attributes)
+
+	// The trace is recorded but not propagated to identity providers.
+	require.Equal(t, "HTTP GET", idpClientSpan.Name)
+	require.Equal(t, SpanKindClient, idpClientSpan.Kind)
+	require.Equal(t, serverSpan.SpanID, idpClientSpan.ParentSpanID)
+	require.Equal(t, status{}, idpClientSpan.Status)
+	require.Empty(t, gotIDPTraceparent)
+	requireAttributes(t, map[string]string{
+		"http.method":      "GET",
+		"http.url":         idp.URL + "/some/idp/path",
+		"net.peer.name":    "127.0.0.1",
+		"http.status_code": "200",
+	}, idpClientSpan.Attributes)
+
+	// No spans are recorded after shutdown.
+	_, span := Start(context.Background(), "some-operation", SpanKindInternal)
+	require.Nil(t, span)
+}
+
+func TestUntrustedTraceparent(t *testing.T) {
+	var (
+		collectorLock sync.Mutex
+		requests      []exportTraceServiceRequest
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req exportTraceServiceRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		collectorLock.Lock()
+		requests = append(requests, req)
+		collectorLock.Unlock()
+	}))
+	defer collector.Close()
+
+	shutdown, err := Configure(collector.URL, "some-service", []string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/some/path", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	Handler("some-operation", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), r)
+
+	shutdown()
+
+	collectorLock.Lock()
+	defer collectorLock.Unlock()
+	require.Len(t, requests, 1)
+	spans := requests[0].ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 1)
+
+	// The span starts a new trace, because the request did not come from a trusted client.
+	require.Equal(t, "some-operation", spans[0].Name)
+	require.Len(t, spans[0].TraceID, 32)
+	require.NotEqual(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].TraceID)
+	require.Empty(t, spans[0].ParentSpanID)
+}
+
+func requireAttributes(t *testing.T, want map[string]string, attributes []keyValue) {
+	t.Helper()
+
+	got := map[string]string{}
+	for _, kv := range attributes {
+		switch {
+		case kv.Value.StringValue != nil:
+			got[kv.Key] = *kv.Value.StringValue
+		case kv.Value.IntValue != nil:
+			got[kv.Key] = *kv.Value.IntValue
+		case kv.Value.BoolValue != nil:
+			got[kv.Key] = fmt.Sprintf("%t", *kv.Value.BoolValue)
+		}
+	}
+	require.Equal(t, want, got)
+}
@@ -0,0 +1,72 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package upstreamad contains the Microsoft Active Directory specific parts of the upstream LDAP IDP interactions.
+package upstreamad
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	// DefaultUserSearchFilter allows users to log in with either their sAMAccountName or their userPrincipalName,
+	// and excludes computer accounts and system objects.
+	DefaultUserSearchFilter = "(&(objectClass=person)(!(objectClass=computer))(!(showInAdvancedViewOnly=TRUE))(|(sAMAccountName={})(userPrincipalName={})))"
+
+	// DefaultUsernameAttribute is the attribute which becomes the username by default.
+	DefaultUsernameAttribute = "userPrincipalName"
+
+	// DefaultUIDAttribute is the attribute which becomes the UID by default.
+	DefaultUIDAttribute = ObjectGUIDAttributeName
+
+	// DefaultGroupSearchFilter finds all of the groups of which the user is a member, including nested groups,
+	// using the LDAP_MATCHING_RULE_IN_CHAIN matching rule.
+	DefaultGroupSearchFilter = "(&(objectClass=group)(member:1.2.840.113556.1.4.1941:={}))"
+
+	// DefaultGroupNameAttribute is the attribute which becomes the group name by default.
+	DefaultGroupNameAttribute = "sAMAccountName"
+
+	// ObjectGUIDAttributeName is the name of the binary attribute which uniquely identifies each Active Directory entry.
+	ObjectGUIDAttributeName = "objectGUID"
+
+	// UserAccountControlAttributeName is the name of the attribute which holds the flags of a user account.
+	UserAccountControlAttributeName = "userAccountControl"
+
+	// accountDisabledBit is the ACCOUNTDISABLE flag of the userAccountControl attribute.
+	// See https://docs.microsoft.com/en-us/troubleshoot/windows-server/identity/useraccountcontrol-manipulate-account-properties.
+	accountDisabledBit = 0x2
+)
+
+// ObjectGUIDAttributeParsing returns the objectGUID of the entry in the usual string form of a UUID,
+// e.g. "04030201-0605-0807-0910-111213141516". Active Directory stores the first three groups of the
+// GUID in little-endian byte order.
+func ObjectGUIDAttributeParsing(entry *ldap.Entry) (string, error) {
+	guid := entry.GetRawAttributeValue(ObjectGUIDAttributeName)
+	if len(guid) != 16 {
+		return "", fmt.Errorf("expected %d bytes but found %d bytes", 16, len(guid))
+	}
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(guid[0:4]),
+		binary.LittleEndian.Uint16(guid[4:6]),
+		binary.LittleEndian.Uint16(guid[6:8]),
+		guid[8:10],
+		guid[10:16],
+	), nil
+}
+
+// ValidUserAccountControl returns an error when the userAccountControl attribute of the entry
+// is missing or invalid, or says that the account is disabled.
+func ValidUserAccountControl(entry *ldap.Entry) error {
+	userAccountControl, err := strconv.Atoi(entry.GetAttributeValue(UserAccountControlAttributeName))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", UserAccountControlAttributeName, err)
+	}
+	if userAccountControl&accountDisabledBit != 0 {
+		return fmt.Errorf("the account is disabled")
+	}
+	return nil
+}
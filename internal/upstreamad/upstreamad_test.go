@@ -0,0 +1,108 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamad
+
+import (
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObjectGUIDAttributeParsing(t *testing.T) {
+	tests := []struct {
+		name     string
+		rawValue []byte
+		wantUID  string
+		wantErr  string
+	}{
+		{
+			name:     "happy path",
+			rawValue: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16},
+			wantUID:  "04030201-0605-0807-0910-111213141516",
+		},
+		{
+			name:     "real objectGUID",
+			rawValue: []byte{0x5e, 0x2c, 0x9e, 0xe3, 0x6e, 0x3a, 0x5e, 0x41, 0xa4, 0x6a, 0x8b, 0xa1, 0x2b, 0x51, 0x0c, 0x60},
+			wantUID:  "e39e2c5e-3a6e-415e-a46a-8ba12b510c60",
+		},
+		{
+			name:     "too short",
+			rawValue: []byte{0x01, 0x02, 0x03},
+			wantErr:  "expected 16 bytes but found 3 bytes",
+		},
+		{
+			name:    "missing",
+			wantErr: "expected 16 bytes but found 0 bytes",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &ldap.Entry{DN: "some-dn"}
+			if tt.rawValue != nil {
+				entry.Attributes = []*ldap.EntryAttribute{{Name: ObjectGUIDAttributeName, ByteValues: [][]byte{tt.rawValue}}}
+			}
+			uid, err := ObjectGUIDAttributeParsing(entry)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				require.Empty(t, uid)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantUID, uid)
+		})
+	}
+}
+
+func TestValidUserAccountControl(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		wantErr string
+	}{
+		{
+			name:   "normal account",
+			values: []string{"512"},
+		},
+		{
+			name:   "normal account with a password which does not expire",
+			values: []string{"66048"},
+		},
+		{
+			name:    "disabled account",
+			values:  []string{"514"},
+			wantErr: "the account is disabled",
+		},
+		{
+			name:    "disabled account with a password which does not expire",
+			values:  []string{"66050"},
+			wantErr: "the account is disabled",
+		},
+		{
+			name:    "not a number",
+			values:  []string{"abc"},
+			wantErr: `invalid userAccountControl: strconv.Atoi: parsing "abc": invalid syntax`,
+		},
+		{
+			name:    "missing",
+			wantErr: `invalid userAccountControl: strconv.Atoi: parsing "": invalid syntax`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &ldap.Entry{DN: "some-dn"}
+			if tt.values != nil {
+				entry.Attributes = []*ldap.EntryAttribute{ldap.NewEntryAttribute(UserAccountControlAttributeName, tt.values)}
+			}
+			err := ValidUserAccountControl(entry)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,351 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package upstreamgithub implements an abstraction of upstream GitHub IDP interactions.
+//
+// GitHub is a plain OAuth 2.0 provider without ID tokens, so the identity of the user is read from the GitHub API
+// using the access token. The result is presented as the claims of an ID token, so that the rest of the Supervisor
+// can treat a GitHub provider like any other upstream OIDC provider.
+package upstreamgithub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	idpv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/tracing"
+	"go.pinniped.dev/pkg/oidcclient/nonce"
+	"go.pinniped.dev/pkg/oidcclient/oidctypes"
+	"go.pinniped.dev/pkg/oidcclient/pkce"
+)
+
+const (
+	// UsernameClaim is the name of the claim which holds the username of the GitHub user.
+	UsernameClaim = "username"
+
+	// GroupsClaim is the name of the claim which holds the group names of the GitHub user.
+	GroupsClaim = "groups"
+
+	// The page size of the GitHub API requests for lists, which is the maximum which is allowed by GitHub.
+	pageSize = 100
+)
+
+// ProviderConfig holds the active configuration of an upstream GitHub provider.
+type ProviderConfig struct {
+	// Name is the unique name of this upstream GitHub IDP.
+	Name string
+
+	// Issuer identifies the GitHub server, e.g. "https://github.com". It is combined with the ID of the user to make
+	// the downstream subject, since user IDs are only unique within one GitHub server.
+	Issuer string
+
+	// APIBaseURL is the base URL of the GitHub API, e.g. "https://api.github.com".
+	APIBaseURL string
+
+	// Config is the OAuth 2.0 client configuration, including the endpoints of the GitHub server.
+	Config *oauth2.Config
+
+	// UsernameAttribute determines which attribute of the GitHub user becomes their username.
+	UsernameAttribute idpv1alpha1.GitHubUsernameAttribute
+
+	// GroupNameAttribute determines which attribute of the GitHub teams of the user become their group names.
+	GroupNameAttribute idpv1alpha1.GitHubGroupNameAttribute
+
+	// AllowedOrganizations are the logins of the GitHub organizations whose members may log in. When it is empty,
+	// all GitHub users may log in and the teams of all of their organizations become groups.
+	AllowedOrganizations []string
+
+	// Client is the HTTP client which is used to talk to the GitHub server.
+	Client *http.Client
+}
+
+var _ provider.UpstreamOIDCIdentityProviderI = (*ProviderConfig)(nil)
+
+// clientContext returns a context which makes the oauth2 library use the HTTP client of the provider,
+// with tracing of the requests which are made on behalf of a login.
+func (p *ProviderConfig) clientContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, tracing.WrapUpstreamClient(p.Client))
+}
+
+func (p *ProviderConfig) GetName() string {
+	return p.Name
+}
+
+func (p *ProviderConfig) GetClientID() string {
+	return p.Config.ClientID
+}
+
+func (p *ProviderConfig) GetAuthorizationURL() *url.URL {
+	result, _ := url.Parse(p.Config.Endpoint.AuthURL)
+	return result
+}
+
+func (p *ProviderConfig) GetScopes() []string {
+	return p.Config.Scopes
+}
+
+func (p *ProviderConfig) GetUsernameClaim() string {
+	return UsernameClaim
+}
+
+func (p *ProviderConfig) GetGroupsClaim() string {
+	return GroupsClaim
+}
+
+func (p *ProviderConfig) ExchangeAuthcodeAndValidateTokens(ctx context.Context, authcode string, pkceCodeVerifier pkce.Code, _ nonce.Nonce, redirectURI string) (*oidctypes.Token, error) {
+	tok, err := p.Config.Exchange(
+		p.clientContext(ctx),
+		authcode,
+		pkceCodeVerifier.Verifier(),
+		oauth2.SetAuthURLParam("redirect_uri", redirectURI),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.ValidateToken(ctx, tok, "")
+}
+
+// Refresh is only possible for GitHub Apps which issue expiring user access tokens along with refresh tokens.
+func (p *ProviderConfig) Refresh(ctx context.Context, refreshToken string) (*oidctypes.Token, error) {
+	tok, err := p.Config.TokenSource(
+		p.clientContext(ctx),
+		&oauth2.Token{RefreshToken: refreshToken},
+	).Token()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.ValidateToken(ctx, tok, "")
+}
+
+// ValidateToken uses the access token to read the user, their organizations, and their teams from the GitHub API.
+// It returns the tokens along with claims which describe the user, as if they were the claims of an ID token.
+// There is no ID token from GitHub, so there is no nonce to check.
+func (p *ProviderConfig) ValidateToken(ctx context.Context, tok *oauth2.Token, _ nonce.Nonce) (*oidctypes.Token, error) {
+	client := &apiClient{baseURL: p.APIBaseURL, accessToken: tok.AccessToken, client: tracing.WrapUpstreamClient(p.Client)}
+
+	var user githubUser
+	if err := client.get(ctx, "/user", &user); err != nil {
+		return nil, httperr.Wrap(http.StatusInternalServerError, "could not get user from GitHub API", err)
+	}
+	if user.ID == 0 || user.Login == "" {
+		return nil, httperr.New(http.StatusUnprocessableEntity, "GitHub API response did not contain the login and ID of the user")
+	}
+
+	allowedOrganizations, err := p.allowedOrganizationsOfUser(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	var teams []githubTeam
+	if err := client.getAllPages(ctx, "/user/teams", func(decoder *json.Decoder) (int, error) {
+		var page []githubTeam
+		if err := decoder.Decode(&page); err != nil {
+			return 0, err
+		}
+		teams = append(teams, page...)
+		return len(page), nil
+	}); err != nil {
+		return nil, httperr.Wrap(http.StatusInternalServerError, "could not get teams from GitHub API", err)
+	}
+
+	groups := []string{}
+	for _, team := range teams {
+		if allowedOrganizations != nil && !allowedOrganizations[strings.ToLower(team.Organization.Login)] {
+			continue
+		}
+		groupName := team.Slug
+		if p.GroupNameAttribute == idpv1alpha1.GitHubUseTeamNameForGroupName {
+			groupName = team.Name
+		}
+		groups = append(groups, team.Organization.Login+"/"+groupName)
+	}
+
+	claims := map[string]interface{}{
+		oidc.IDTokenIssuerClaim:  p.Issuer,
+		oidc.IDTokenSubjectClaim: strconv.FormatInt(user.ID, 10),
+		UsernameClaim:            p.username(&user),
+		GroupsClaim:              groups,
+	}
+	plog.All("claims from GitHub API", "providerName", p.Name, "claims", claims)
+
+	return &oidctypes.Token{
+		AccessToken: &oidctypes.AccessToken{
+			Token:  tok.AccessToken,
+			Type:   tok.TokenType,
+			Expiry: metav1.NewTime(tok.Expiry),
+		},
+		RefreshToken: &oidctypes.RefreshToken{
+			Token: tok.RefreshToken,
+		},
+		IDToken: &oidctypes.IDToken{
+			Claims: claims,
+		},
+	}, nil
+}
+
+// allowedOrganizationsOfUser returns the lower-cased logins of the allowed organizations of which the user is a
+// member, or nil when all GitHub users are allowed. It returns an error when the user is not a member of any of the
+// allowed organizations.
+func (p *ProviderConfig) allowedOrganizationsOfUser(ctx context.Context, client *apiClient) (map[string]bool, error) {
+	if len(p.AllowedOrganizations) == 0 {
+		return nil, nil
+	}
+
+	allowed := map[string]bool{}
+	for _, org := range p.AllowedOrganizations {
+		allowed[strings.ToLower(org)] = true
+	}
+
+	memberships := map[string]bool{}
+	if err := client.getAllPages(ctx, "/user/orgs", func(decoder *json.Decoder) (int, error) {
+		var page []githubOrganization
+		if err := decoder.Decode(&page); err != nil {
+			return 0, err
+		}
+		for _, org := range page {
+			if login := strings.ToLower(org.Login); allowed[login] {
+				memberships[login] = true
+			}
+		}
+		return len(page), nil
+	}); err != nil {
+		return nil, httperr.Wrap(http.StatusInternalServerError, "could not get organizations from GitHub API", err)
+	}
+
+	if len(memberships) == 0 {
+		return nil, httperr.New(http.StatusForbidden, "user is not a member of any of the allowed organizations")
+	}
+	return memberships, nil
+}
+
+func (p *ProviderConfig) username(user *githubUser) string {
+	switch p.UsernameAttribute {
+	case idpv1alpha1.GitHubUsernameLogin:
+		return user.Login
+	case idpv1alpha1.GitHubUsernameID:
+		return strconv.FormatInt(user.ID, 10)
+	default:
+		return fmt.Sprintf("%s:%d", user.Login, user.ID)
+	}
+}
+
+// The parts of the GitHub API responses which are used. See https://docs.github.com/en/rest/users/users,
+// https://docs.github.com/en/rest/orgs/orgs, and https://docs.github.com/en/rest/teams/teams.
+
+type githubUser struct {
+	Login string `json:"login"`
+	ID    int64  `json:"id"`
+}
+
+type githubOrganization struct {
+	Login string `json:"login"`
+}
+
+type githubTeam struct {
+	Name         string             `json:"name"`
+	Slug         string             `json:"slug"`
+	Organization githubOrganization `json:"organization"`
+}
+
+// apiClient makes authenticated requests to the GitHub API on behalf of a user.
+type apiClient struct {
+	baseURL     string
+	accessToken string
+	client      *http.Client
+}
+
+func (c *apiClient) get(ctx context.Context, path string, into interface{}) error {
+	return c.do(ctx, strings.TrimSuffix(c.baseURL, "/")+path, func(resp *http.Response) error {
+		return json.NewDecoder(resp.Body).Decode(into)
+	})
+}
+
+// getAllPages reads every page of a list from the GitHub API. The decodePage func returns the number of items which
+// it decoded, so that reading stops at an empty page even when the server keeps sending a link to the next page.
+func (c *apiClient) getAllPages(ctx context.Context, path string, decodePage func(*json.Decoder) (int, error)) error {
+	nextURL := fmt.Sprintf("%s%s?per_page=%d", strings.TrimSuffix(c.baseURL, "/"), path, pageSize)
+	for nextURL != "" {
+		currentURL := nextURL
+		nextURL = ""
+		if err := c.do(ctx, currentURL, func(resp *http.Response) error {
+			count, err := decodePage(json.NewDecoder(resp.Body))
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				nextURL = nextPageURL(resp.Header.Get("Link"))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		// Never send the access token to any server other than the GitHub API.
+		if nextURL != "" && !strings.HasPrefix(nextURL, strings.TrimSuffix(c.baseURL, "/")+"/") {
+			return fmt.Errorf("GitHub API returned a link to a next page on another server: %s", nextURL)
+		}
+	}
+	return nil
+}
+
+func (c *apiClient) do(ctx context.Context, requestURL string, handleResponse func(*http.Response) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return fmt.Errorf("could not build request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status %q from %s", resp.Status, req.URL.Path)
+	}
+	if err := handleResponse(resp); err != nil {
+		return fmt.Errorf("could not decode response from %s: %w", req.URL.Path, err)
+	}
+	return nil
+}
+
+// nextPageURL returns the URL of the next page from a Link header, e.g.
+// `<https://api.github.com/user/teams?page=2>; rel="next", <https://api.github.com/user/teams?page=5>; rel="last"`.
+func nextPageURL(linkHeader string) string {
+	for _, link := range strings.Split(linkHeader, ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 {
+			continue
+		}
+		target := strings.TrimSpace(parts[0])
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,262 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamgithub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	idpv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+	"go.pinniped.dev/pkg/oidcclient/pkce"
+)
+
+// newFakeGitHub returns a fake GitHub server which serves the OAuth token endpoint and the parts of the API which
+// are used by the provider. The lists of organizations and teams are split into pages of two items.
+func newFakeGitHub(t *testing.T, orgs []githubOrganization, teams []githubTeam) *httptest.Server {
+	t.Helper()
+
+	var server *httptest.Server
+	writePage := func(w http.ResponseWriter, r *http.Request, items []interface{}) {
+		require.Equal(t, "100", r.URL.Query().Get("per_page"))
+		page := 1
+		if p := r.URL.Query().Get("page"); p != "" {
+			_, err := fmt.Sscanf(p, "%d", &page)
+			require.NoError(t, err)
+		}
+		start, end := (page-1)*2, page*2
+		if start > len(items) {
+			start = len(items)
+		}
+		if end >= len(items) {
+			end = len(items)
+		} else {
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s?per_page=100&page=%d>; rel="next", <%s%s?per_page=100&page=99>; rel="last"`,
+				server.URL, r.URL.Path, page+1, server.URL, r.URL.Path))
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(items[start:end]))
+	}
+
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login/oauth/access_token" {
+			require.NoError(t, r.ParseForm())
+			if r.Form.Get("code") != "test-authcode" {
+				w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
+				_, _ = w.Write([]byte("error=bad_verification_code"))
+				return
+			}
+			require.Equal(t, "test-client-id", r.Form.Get("client_id"))
+			require.Equal(t, "test-client-secret", r.Form.Get("client_secret"))
+			require.Equal(t, "https://example.com/callback", r.Form.Get("redirect_uri"))
+			require.NotEmpty(t, r.Form.Get("code_verifier"))
+			w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
+			_, _ = w.Write([]byte("access_token=test-access-token&scope=read%3Aorg%2Cread%3Auser&token_type=bearer"))
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer test-access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v3/user":
+			_, _ = w.Write([]byte(`{"login": "some-login", "id": 1234, "name": "Some Name"}`))
+		case "/api/v3/user/orgs":
+			items := make([]interface{}, 0, len(orgs))
+			for _, org := range orgs {
+				items = append(items, org)
+			}
+			writePage(w, r, items)
+		case "/api/v3/user/teams":
+			items := make([]interface{}, 0, len(teams))
+			for _, team := range teams {
+				items = append(items, team)
+			}
+			writePage(w, r, items)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestProviderConfig(t *testing.T) {
+	t.Run("getters get", func(t *testing.T) {
+		p := ProviderConfig{
+			Name: "test-name",
+			Config: &oauth2.Config{
+				ClientID: "test-client-id",
+				Endpoint: oauth2.Endpoint{AuthURL: "https://github.com/login/oauth/authorize"},
+				Scopes:   []string{"read:user", "read:org"},
+			},
+		}
+		require.Equal(t, "test-name", p.GetName())
+		require.Equal(t, "test-client-id", p.GetClientID())
+		require.Equal(t, "https://github.com/login/oauth/authorize", p.GetAuthorizationURL().String())
+		require.Equal(t, []string{"read:user", "read:org"}, p.GetScopes())
+		require.Equal(t, "username", p.GetUsernameClaim())
+		require.Equal(t, "groups", p.GetGroupsClaim())
+	})
+
+	orgs := []githubOrganization{{Login: "org-1"}, {Login: "Org-2"}, {Login: "org-3"}}
+	teams := []githubTeam{
+		{Name: "Team One", Slug: "team-one", Organization: githubOrganization{Login: "org-1"}},
+		{Name: "Team Two", Slug: "team-two", Organization: githubOrganization{Login: "Org-2"}},
+		{Name: "Team Three", Slug: "team-three", Organization: githubOrganization{Login: "org-3"}},
+		{Name: "Team Four", Slug: "team-four", Organization: githubOrganization{Login: "org-1"}},
+		{Name: "Team Five", Slug: "team-five", Organization: githubOrganization{Login: "org-4"}},
+	}
+
+	tests := []struct {
+		name                 string
+		authCode             string
+		usernameAttribute    idpv1alpha1.GitHubUsernameAttribute
+		groupNameAttribute   idpv1alpha1.GitHubGroupNameAttribute
+		allowedOrganizations []string
+		wantErr              string
+		wantUsername         string
+		wantGroups           []string
+	}{
+		{
+			name:         "all users are allowed, with default attributes",
+			authCode:     "test-authcode",
+			wantUsername: "some-login:1234",
+			wantGroups:   []string{"org-1/team-one", "Org-2/team-two", "org-3/team-three", "org-1/team-four", "org-4/team-five"},
+		},
+		{
+			name:               "all users are allowed, with login username and team name groups",
+			authCode:           "test-authcode",
+			usernameAttribute:  idpv1alpha1.GitHubUsernameLogin,
+			groupNameAttribute: idpv1alpha1.GitHubUseTeamNameForGroupName,
+			wantUsername:       "some-login",
+			wantGroups:         []string{"org-1/Team One", "Org-2/Team Two", "org-3/Team Three", "org-1/Team Four", "org-4/Team Five"},
+		},
+		{
+			name:                 "the user is a member of some allowed organizations, which are compared case-insensitively",
+			authCode:             "test-authcode",
+			usernameAttribute:    idpv1alpha1.GitHubUsernameID,
+			groupNameAttribute:   idpv1alpha1.GitHubUseTeamSlugForGroupName,
+			allowedOrganizations: []string{"ORG-1", "org-2", "org-4", "some-other-org"},
+			wantUsername:         "1234",
+			wantGroups:           []string{"org-1/team-one", "Org-2/team-two", "org-1/team-four"},
+		},
+		{
+			name:                 "the user is not a member of any allowed organization",
+			authCode:             "test-authcode",
+			allowedOrganizations: []string{"some-other-org"},
+			wantErr:              "user is not a member of any of the allowed organizations",
+		},
+		{
+			name:     "the authcode is not accepted",
+			authCode: "bad-authcode",
+			wantErr:  "oauth2: server response missing access_token",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeGitHub(t, orgs, teams)
+
+			p := ProviderConfig{
+				Name:       "test-name",
+				Issuer:     "https://github.example.com",
+				APIBaseURL: server.URL + "/api/v3",
+				Config: &oauth2.Config{
+					ClientID:     "test-client-id",
+					ClientSecret: "test-client-secret",
+					Endpoint: oauth2.Endpoint{
+						AuthURL:   server.URL + "/login/oauth/authorize",
+						TokenURL:  server.URL + "/login/oauth/access_token",
+						AuthStyle: oauth2.AuthStyleInParams,
+					},
+					Scopes: []string{"read:user", "read:org"},
+				},
+				UsernameAttribute:    tt.usernameAttribute,
+				GroupNameAttribute:   tt.groupNameAttribute,
+				AllowedOrganizations: tt.allowedOrganizations,
+				Client:               server.Client(),
+			}
+
+			tok, err := p.ExchangeAuthcodeAndValidateTokens(context.Background(), tt.authCode, pkce.Code("test-pkce"), "test-nonce", "https://example.com/callback")
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				require.Nil(t, tok)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "test-access-token", tok.AccessToken.Token)
+			require.Equal(t, "bearer", tok.AccessToken.Type)
+			require.Equal(t, map[string]interface{}{
+				"iss":      "https://github.example.com",
+				"sub":      "1234",
+				"username": tt.wantUsername,
+				"groups":   tt.wantGroups,
+			}, tok.IDToken.Claims)
+
+			// The same identity is found again when only the access token is validated, e.g. during a refresh.
+			validated, err := p.ValidateToken(context.Background(), &oauth2.Token{AccessToken: "test-access-token"}, "")
+			require.NoError(t, err)
+			require.Equal(t, tok.IDToken.Claims, validated.IDToken.Claims)
+		})
+	}
+
+	t.Run("the access token was revoked", func(t *testing.T) {
+		server := newFakeGitHub(t, orgs, teams)
+		p := ProviderConfig{Name: "test-name", APIBaseURL: server.URL + "/api/v3", Client: server.Client()}
+		tok, err := p.ValidateToken(context.Background(), &oauth2.Token{AccessToken: "revoked-access-token"}, "")
+		require.EqualError(t, err, `could not get user from GitHub API: unexpected response status "401 Unauthorized" from /api/v3/user`)
+		require.Nil(t, tok)
+	})
+
+	t.Run("the API links to a next page on another server", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/user":
+				_, _ = w.Write([]byte(`{"login": "some-login", "id": 1234}`))
+			default:
+				w.Header().Set("Link", `<https://evil.example.com/user/teams?page=2>; rel="next"`)
+				_, _ = w.Write([]byte(`[{"name": "Team One", "slug": "team-one", "organization": {"login": "org-1"}}]`))
+			}
+		}))
+		defer server.Close()
+		p := ProviderConfig{Name: "test-name", APIBaseURL: server.URL, Client: server.Client()}
+		tok, err := p.ValidateToken(context.Background(), &oauth2.Token{AccessToken: "test-access-token"}, "")
+		require.EqualError(t, err, "could not get teams from GitHub API: GitHub API returned a link to a next page on another server: https://evil.example.com/user/teams?page=2")
+		require.Nil(t, tok)
+	})
+}
+
+func TestNextPageURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{name: "empty", header: "", want: ""},
+		{
+			name:   "next and last",
+			header: `<https://api.github.com/user/teams?page=2>; rel="next", <https://api.github.com/user/teams?page=5>; rel="last"`,
+			want:   "https://api.github.com/user/teams?page=2",
+		},
+		{
+			name:   "prev and first only",
+			header: `<https://api.github.com/user/teams?page=4>; rel="prev", <https://api.github.com/user/teams?page=1>; rel="first"`,
+			want:   "",
+		},
+		{name: "malformed", header: `https://api.github.com/user/teams?page=2; rel="next"`, want: ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, nextPageURL(tt.header))
+		})
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"go.pinniped.dev/internal/endpointaddr"
 	"go.pinniped.dev/internal/oidc/provider"
 	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/tracing"
 )
 
 const (
@@ -120,6 +121,16 @@ type UserSearchConfig struct {
 	// UIDAttribute is the attribute in the LDAP entry from which the user's unique ID should be
 	// retrieved.
 	UIDAttribute string
+
+	// UIDAttributeParsingOverrides maps attribute names to functions which compute the user's unique ID from the
+	// user's entry. When UIDAttribute is a key of this map, its function is used instead of the default encoding
+	// of the attribute's raw value. Optional.
+	UIDAttributeParsingOverrides map[string]func(*ldap.Entry) (string, error)
+
+	// UserEntryChecks maps attribute names to functions which inspect the user's entry, e.g. to reject disabled
+	// accounts. Each attribute is requested during the user search. When a check returns an error, the user is
+	// treated as though they could not be authenticated. Optional.
+	UserEntryChecks map[string]func(*ldap.Entry) error
 }
 
 // GroupSearchConfig contains information about how to search for group membership for users in the upstream LDAP IDP.
@@ -299,6 +310,14 @@ func (p *Provider) DryRunAuthenticateUser(ctx context.Context, username string)
 	return p.authenticateUserImpl(ctx, username, endUserBindFunc)
 }
 
+// RefreshUser repeats the user search and group search for an end user who has already authenticated, for example
+// when their downstream session is being refreshed. Like DryRunAuthenticateUser, it does not bind as that user,
+// since their password is not known at this time. It returns false when the user can no longer be found.
+// Implements provider.UpstreamLDAPIdentityProviderI.
+func (p *Provider) RefreshUser(ctx context.Context, username string) (*authenticator.Response, bool, error) {
+	return p.DryRunAuthenticateUser(ctx, username)
+}
+
 // Authenticate an end user and return their mapped username, groups, and UID. Implements authenticators.UserAuthenticator.
 func (p *Provider) AuthenticateUser(ctx context.Context, username, password string) (*authenticator.Response, bool, error) {
 	endUserBindFunc := func(conn Conn, foundUserDN string) error {
@@ -311,39 +330,45 @@ func (p *Provider) authenticateUserImpl(ctx context.Context, username string, bi
 	t := trace.FromContext(ctx).Nest("slow ldap authenticate user attempt", trace.Field{Key: "providerName", Value: p.GetName()})
 	defer t.LogIfLong(500 * time.Millisecond) // to help users debug slow LDAP searches
 
+	ctx, span := tracing.Start(ctx, "ldap authenticate user", tracing.SpanKindInternal,
+		tracing.String("ldap.provider_name", p.GetName()),
+		tracing.String("ldap.host", p.c.Host),
+	)
+	defer span.End()
+
 	err := p.validateConfig()
 	if err != nil {
-		p.traceAuthFailure(t, err)
+		p.traceAuthFailure(t, span, err)
 		return nil, false, err
 	}
 
 	if len(username) == 0 {
 		// Empty passwords are already handled by go-ldap.
-		p.traceAuthFailure(t, fmt.Errorf("empty username"))
+		p.traceAuthFailure(t, span, fmt.Errorf("empty username"))
 		return nil, false, nil
 	}
 
 	conn, err := p.dial(ctx)
 	if err != nil {
-		p.traceAuthFailure(t, err)
+		p.traceAuthFailure(t, span, err)
 		return nil, false, fmt.Errorf(`error dialing host "%s": %w`, p.c.Host, err)
 	}
 	defer conn.Close()
 
 	err = conn.Bind(p.c.BindUsername, p.c.BindPassword)
 	if err != nil {
-		p.traceAuthFailure(t, err)
+		p.traceAuthFailure(t, span, err)
 		return nil, false, fmt.Errorf(`error binding as "%s" before user search: %w`, p.c.BindUsername, err)
 	}
 
 	mappedUsername, mappedUID, mappedGroupNames, err := p.searchAndBindUser(conn, username, bindFunc)
 	if err != nil {
-		p.traceAuthFailure(t, err)
+		p.traceAuthFailure(t, span, err)
 		return nil, false, err
 	}
 	if len(mappedUsername) == 0 || len(mappedUID) == 0 {
 		// Couldn't find the username or couldn't bind using the password.
-		p.traceAuthFailure(t, fmt.Errorf("bad username or password"))
+		p.traceAuthFailure(t, span, fmt.Errorf("bad username or password"))
 		return nil, false, nil
 	}
 
@@ -354,7 +379,7 @@ func (p *Provider) authenticateUserImpl(ctx context.Context, username string, bi
 			Groups: mappedGroupNames,
 		},
 	}
-	p.traceAuthSuccess(t)
+	p.traceAuthSuccess(t, span)
 	return response, true, nil
 }
 
@@ -431,13 +456,19 @@ func (p *Provider) searchAndBindUser(conn Conn, username string, bindFunc func(c
 		return "", "", nil, err
 	}
 
-	// We would like to support binary typed attributes for UIDs, so always read them as binary and encode them,
-	// even when the attribute may not be binary.
-	mappedUID, err := p.getSearchResultAttributeRawValueEncoded(p.c.UserSearch.UIDAttribute, userEntry, username)
+	mappedUID, err := p.getUID(userEntry, username)
 	if err != nil {
 		return "", "", nil, err
 	}
 
+	for _, attributeName := range sortedKeys(p.c.UserSearch.UserEntryChecks) {
+		if err := p.c.UserSearch.UserEntryChecks[attributeName](userEntry); err != nil {
+			plog.DebugErr("user entry failed a check, so the user may not log in",
+				err, "upstreamName", p.GetName(), "username", username, "dn", userEntry.DN, "attribute", attributeName)
+			return "", "", nil, nil
+		}
+	}
+
 	mappedGroupNames := []string{}
 	if len(p.c.GroupSearch.Base) > 0 {
 		mappedGroupNames, err = p.searchGroupsForUserDN(conn, userEntry.DN)
@@ -462,6 +493,19 @@ func (p *Provider) searchAndBindUser(conn Conn, username string, bindFunc func(c
 	return mappedUsername, mappedUID, mappedGroupNames, nil
 }
 
+func (p *Provider) getUID(userEntry *ldap.Entry, username string) (string, error) {
+	if parseUID, ok := p.c.UserSearch.UIDAttributeParsingOverrides[p.c.UserSearch.UIDAttribute]; ok {
+		mappedUID, err := parseUID(userEntry)
+		if err != nil {
+			return "", fmt.Errorf(`error parsing attribute "%s" while searching for user "%s": %w`, p.c.UserSearch.UIDAttribute, username, err)
+		}
+		return mappedUID, nil
+	}
+	// We would like to support binary typed attributes for UIDs, so always read them as binary and encode them,
+	// even when the attribute may not be binary.
+	return p.getSearchResultAttributeRawValueEncoded(p.c.UserSearch.UIDAttribute, userEntry, username)
+}
+
 func (p *Provider) userSearchRequest(username string) *ldap.SearchRequest {
 	// See https://ldap.com/the-ldap-search-operation for general documentation of LDAP search options.
 	return &ldap.SearchRequest{
@@ -500,7 +544,16 @@ func (p *Provider) userSearchRequestedAttributes() []string {
 	if p.c.UserSearch.UIDAttribute != distinguishedNameAttributeName {
 		attributes = append(attributes, p.c.UserSearch.UIDAttribute)
 	}
-	return attributes
+	return append(attributes, sortedKeys(p.c.UserSearch.UserEntryChecks)...)
+}
+
+func sortedKeys(m map[string]func(*ldap.Entry) error) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 func (p *Provider) groupSearchRequestedAttributes() []string {
@@ -589,15 +642,18 @@ func (p *Provider) getSearchResultAttributeValue(attributeName string, entry *ld
 	return attributeValue, nil
 }
 
-func (p *Provider) traceAuthFailure(t *trace.Trace, err error) {
+func (p *Provider) traceAuthFailure(t *trace.Trace, span *tracing.Span, err error) {
 	t.Step("authentication failed",
 		trace.Field{Key: "authenticated", Value: false},
 		trace.Field{Key: "reason", Value: err.Error()},
 	)
+	span.SetAttributes(tracing.Bool("authenticated", false))
+	span.RecordError(err)
 }
 
-func (p *Provider) traceAuthSuccess(t *trace.Trace) {
+func (p *Provider) traceAuthSuccess(t *trace.Trace, span *tracing.Span) {
 	t.Step("authentication succeeded",
 		trace.Field{Key: "authenticated", Value: true},
 	)
+	span.SetAttributes(tracing.Bool("authenticated", true))
 }
@@ -926,6 +926,94 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Return(err).Times(1)
 			},
 		},
+		{
+			name:     "when the UIDAttribute has a parsing override",
+			username: testUpstreamUsername,
+			password: testUpstreamPassword,
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.UserSearch.UIDAttributeParsingOverrides = map[string]func(*ldap.Entry) (string, error){
+					testUserSearchUIDAttribute: func(entry *ldap.Entry) (string, error) {
+						return "parsed-" + entry.GetAttributeValue(testUserSearchUIDAttribute), nil
+					},
+					"some-other-attribute": func(entry *ldap.Entry) (string, error) {
+						return "", errors.New("should not be called")
+					},
+				}
+			}),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch(nil)).Return(exampleUserSearchResult, nil).Times(1)
+				conn.EXPECT().SearchWithPaging(expectedGroupSearch(nil), expectedGroupSearchPageSize).
+					Return(exampleGroupSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+			},
+			wantAuthResponse: expectedAuthResponse(func(r *user.DefaultInfo) {
+				r.UID = "parsed-" + testUserSearchResultUIDAttributeValue
+			}),
+		},
+		{
+			name:     "when the UIDAttribute parsing override returns an error",
+			username: testUpstreamUsername,
+			password: testUpstreamPassword,
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.UserSearch.UIDAttributeParsingOverrides = map[string]func(*ldap.Entry) (string, error){
+					testUserSearchUIDAttribute: func(entry *ldap.Entry) (string, error) {
+						return "", errors.New("some parsing error")
+					},
+				}
+			}),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch(nil)).Return(exampleUserSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantError: fmt.Sprintf(`error parsing attribute "%s" while searching for user "%s": some parsing error`, testUserSearchUIDAttribute, testUpstreamUsername),
+		},
+		{
+			name:     "when the user entry passes the user entry checks",
+			username: testUpstreamUsername,
+			password: testUpstreamPassword,
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.UserSearch.UserEntryChecks = map[string]func(*ldap.Entry) error{
+					"some-check-attribute-b": func(entry *ldap.Entry) error { return nil },
+					"some-check-attribute-a": func(entry *ldap.Entry) error { return nil },
+				}
+			}),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch(func(r *ldap.SearchRequest) {
+					r.Attributes = []string{testUserSearchUsernameAttribute, testUserSearchUIDAttribute, "some-check-attribute-a", "some-check-attribute-b"}
+				})).Return(exampleUserSearchResult, nil).Times(1)
+				conn.EXPECT().SearchWithPaging(expectedGroupSearch(nil), expectedGroupSearchPageSize).
+					Return(exampleGroupSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+			},
+			wantAuthResponse: expectedAuthResponse(nil),
+		},
+		{
+			name:     "when the user entry fails a user entry check",
+			username: testUpstreamUsername,
+			password: testUpstreamPassword,
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.UserSearch.UserEntryChecks = map[string]func(*ldap.Entry) error{
+					"some-check-attribute": func(entry *ldap.Entry) error { return errors.New("some check error") },
+				}
+			}),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch(func(r *ldap.SearchRequest) {
+					r.Attributes = []string{testUserSearchUsernameAttribute, testUserSearchUIDAttribute, "some-check-attribute"}
+				})).Return(exampleUserSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantUnauthenticated: true,
+		},
 		{
 			name:                "when no username is specified",
 			username:            "",
@@ -1010,6 +1098,30 @@ func TestEndUserAuthentication(t *testing.T) {
 				require.True(t, authenticated)
 				require.Equal(t, tt.wantAuthResponse, authResponse)
 			}
+
+			// RefreshUser() should also behave exactly like DryRunAuthenticateUser(), since it also cannot bind as the end user.
+			dialWasAttempted = false
+			conn = mockldapconn.NewMockConn(ctrl)
+			if tt.searchMocks != nil {
+				tt.searchMocks(conn)
+			}
+
+			authResponse, authenticated, err = provider.RefreshUser(context.Background(), tt.username)
+			require.Equal(t, !tt.wantToSkipDial, dialWasAttempted)
+			switch {
+			case tt.wantError != "":
+				require.EqualError(t, err, tt.wantError)
+				require.False(t, authenticated)
+				require.Nil(t, authResponse)
+			case tt.wantUnauthenticated:
+				require.NoError(t, err)
+				require.False(t, authenticated)
+				require.Nil(t, authResponse)
+			default:
+				require.NoError(t, err)
+				require.True(t, authenticated)
+				require.Equal(t, tt.wantAuthResponse, authResponse)
+			}
 		})
 	}
 }
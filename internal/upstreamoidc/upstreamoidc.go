@@ -17,11 +17,16 @@ import (
 	"go.pinniped.dev/internal/oidc"
 	"go.pinniped.dev/internal/oidc/provider"
 	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/tracing"
 	"go.pinniped.dev/pkg/oidcclient/nonce"
 	"go.pinniped.dev/pkg/oidcclient/oidctypes"
 	"go.pinniped.dev/pkg/oidcclient/pkce"
 )
 
+// userInfoUnsupported is the error returned by the oidc library when the provider's discovery document does not
+// declare a userinfo endpoint.
+const userInfoUnsupported = "oidc: user info endpoint is not supported by this provider"
+
 func New(config *oauth2.Config, provider *coreosoidc.Provider, client *http.Client) provider.UpstreamOIDCIdentityProviderI {
 	return &ProviderConfig{Config: config, Provider: provider, Client: client}
 }
@@ -39,6 +44,12 @@ type ProviderConfig struct {
 	Client *http.Client
 }
 
+// clientContext returns a context which makes the oidc and oauth2 libraries use the HTTP client of the provider,
+// with tracing of the requests which are made on behalf of a login.
+func (p *ProviderConfig) clientContext(ctx context.Context) context.Context {
+	return coreosoidc.ClientContext(ctx, tracing.WrapUpstreamClient(p.Client))
+}
+
 func (p *ProviderConfig) GetName() string {
 	return p.Name
 }
@@ -66,7 +77,7 @@ func (p *ProviderConfig) GetGroupsClaim() string {
 
 func (p *ProviderConfig) ExchangeAuthcodeAndValidateTokens(ctx context.Context, authcode string, pkceCodeVerifier pkce.Code, expectedIDTokenNonce nonce.Nonce, redirectURI string) (*oidctypes.Token, error) {
 	tok, err := p.Config.Exchange(
-		coreosoidc.ClientContext(ctx, p.Client),
+		p.clientContext(ctx),
 		authcode,
 		pkceCodeVerifier.Verifier(),
 		oauth2.SetAuthURLParam("redirect_uri", redirectURI),
@@ -78,12 +89,59 @@ func (p *ProviderConfig) ExchangeAuthcodeAndValidateTokens(ctx context.Context,
 	return p.ValidateToken(ctx, tok, expectedIDTokenNonce)
 }
 
+func (p *ProviderConfig) Refresh(ctx context.Context, refreshToken string) (*oidctypes.Token, error) {
+	// Use the refresh token to get new tokens. When the provider does not return a new refresh token,
+	// the oauth2 library keeps the old refresh token in the result.
+	tok, err := p.Config.TokenSource(
+		p.clientContext(ctx),
+		&oauth2.Token{RefreshToken: refreshToken},
+	).Token()
+	if err != nil {
+		return nil, err
+	}
+
+	// Providers may return a new ID token from a refresh, in which case it must be validated like any other.
+	// Note that an ID token returned from a refresh does not contain a nonce.
+	if _, hasIDTok := tok.Extra("id_token").(string); hasIDTok {
+		return p.ValidateToken(ctx, tok, "")
+	}
+
+	// Otherwise, use the userinfo endpoint to learn about the user's current identity, if there is one.
+	result := &oidctypes.Token{
+		AccessToken: &oidctypes.AccessToken{
+			Token:  tok.AccessToken,
+			Type:   tok.TokenType,
+			Expiry: metav1.NewTime(tok.Expiry),
+		},
+		RefreshToken: &oidctypes.RefreshToken{
+			Token: tok.RefreshToken,
+		},
+	}
+	userInfo, err := p.Provider.UserInfo(p.clientContext(ctx), oauth2.StaticTokenSource(tok))
+	if err != nil {
+		if err.Error() == userInfoUnsupported {
+			return result, nil
+		}
+		return nil, httperr.Wrap(http.StatusInternalServerError, "could not get user info", err)
+	}
+	if len(userInfo.Subject) == 0 {
+		return nil, httperr.New(http.StatusUnprocessableEntity, "userinfo response did not contain a 'sub' claim")
+	}
+	var userInfoClaims map[string]interface{}
+	if err := userInfo.Claims(&userInfoClaims); err != nil {
+		return nil, httperr.Wrap(http.StatusInternalServerError, "could not unmarshal user info claims", err)
+	}
+	plog.All("claims from userinfo after refresh", "providerName", p.Name, "claims", userInfoClaims)
+	result.IDToken = &oidctypes.IDToken{Claims: userInfoClaims}
+	return result, nil
+}
+
 func (p *ProviderConfig) ValidateToken(ctx context.Context, tok *oauth2.Token, expectedIDTokenNonce nonce.Nonce) (*oidctypes.Token, error) {
 	idTok, hasIDTok := tok.Extra("id_token").(string)
 	if !hasIDTok {
 		return nil, httperr.New(http.StatusBadRequest, "received response missing ID token")
 	}
-	validated, err := p.Provider.Verifier(&coreosoidc.Config{ClientID: p.GetClientID()}).Verify(coreosoidc.ClientContext(ctx, p.Client), idTok)
+	validated, err := p.Provider.Verifier(&coreosoidc.Config{ClientID: p.GetClientID()}).Verify(p.clientContext(ctx), idTok)
 	if err != nil {
 		return nil, httperr.Wrap(http.StatusBadRequest, "received invalid ID token", err)
 	}
@@ -132,10 +190,9 @@ func (p *ProviderConfig) fetchUserInfo(ctx context.Context, tok *oauth2.Token, c
 		return nil // defer to existing ID token validation
 	}
 
-	userInfo, err := p.Provider.UserInfo(coreosoidc.ClientContext(ctx, p.Client), oauth2.StaticTokenSource(tok))
+	userInfo, err := p.Provider.UserInfo(p.clientContext(ctx), oauth2.StaticTokenSource(tok))
 	if err != nil {
 		// the user info endpoint is not required but we do not have a good way to probe if it was provided
-		const userInfoUnsupported = "oidc: user info endpoint is not supported by this provider"
 		if err.Error() == userInfoUnsupported {
 			return nil
 		}
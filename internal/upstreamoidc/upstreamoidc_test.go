@@ -306,6 +306,147 @@ func TestProviderConfig(t *testing.T) {
 			require.Equal(t, tt.wantUserInfoCalled, p.Provider.(*mockProvider).called)
 		})
 	}
+
+	refreshTests := []struct {
+		name               string
+		refreshToken       string
+		returnIDTok        string
+		returnRefreshToken string
+		userInfo           *oidc.UserInfo
+		userInfoErr        error
+		wantErr            string
+		wantToken          *oidctypes.Token
+		wantUserInfoCalled bool
+	}{
+		{
+			name:         "refresh fails",
+			refreshToken: "invalid-refresh-token",
+			wantErr:      "oauth2: cannot fetch token: 401 Unauthorized\nResponse: invalid refresh token\n",
+		},
+		{
+			name:               "refresh returns a new ID token",
+			refreshToken:       "valid-refresh-token",
+			returnIDTok:        validIDToken,
+			returnRefreshToken: "test-new-refresh-token",
+			userInfo:           forceUserInfoWithClaims("test-user", `{"groups":"fancy-group"}`),
+			wantToken: &oidctypes.Token{
+				AccessToken:  &oidctypes.AccessToken{Token: "test-access-token"},
+				RefreshToken: &oidctypes.RefreshToken{Token: "test-new-refresh-token"},
+				IDToken: &oidctypes.IDToken{
+					Token: validIDToken,
+					Claims: map[string]interface{}{
+						"foo":    "bar",
+						"bat":    "baz",
+						"aud":    "test-client-id",
+						"iat":    1.606768593e+09,
+						"jti":    "test-jti",
+						"nbf":    1.606768593e+09,
+						"sub":    "test-user",
+						"groups": "fancy-group",
+					},
+				},
+			},
+			wantUserInfoCalled: true,
+		},
+		{
+			name:               "refresh returns an invalid ID token",
+			refreshToken:       "valid-refresh-token",
+			returnIDTok:        "invalid-jwt",
+			returnRefreshToken: "test-new-refresh-token",
+			wantErr:            "received invalid ID token: oidc: malformed jwt: square/go-jose: compact JWS format must have three parts",
+		},
+		{
+			name:         "refresh returns no ID token and no new refresh token, so the claims come from the userinfo endpoint",
+			refreshToken: "valid-refresh-token",
+			userInfo:     forceUserInfoWithClaims("test-user", `{"sub":"test-user","groups":"fancy-group"}`),
+			wantToken: &oidctypes.Token{
+				AccessToken:  &oidctypes.AccessToken{Token: "test-access-token"},
+				RefreshToken: &oidctypes.RefreshToken{Token: "valid-refresh-token"},
+				IDToken: &oidctypes.IDToken{
+					Claims: map[string]interface{}{
+						"sub":    "test-user",
+						"groups": "fancy-group",
+					},
+				},
+			},
+			wantUserInfoCalled: true,
+		},
+		{
+			name:         "refresh returns no ID token and the provider has no userinfo endpoint",
+			refreshToken: "valid-refresh-token",
+			userInfoErr:  userInfoNotSupported,
+			wantToken: &oidctypes.Token{
+				AccessToken:  &oidctypes.AccessToken{Token: "test-access-token"},
+				RefreshToken: &oidctypes.RefreshToken{Token: "valid-refresh-token"},
+			},
+			wantUserInfoCalled: true,
+		},
+		{
+			name:               "refresh returns no ID token and the userinfo endpoint fails",
+			refreshToken:       "valid-refresh-token",
+			userInfoErr:        errors.New("some userinfo error"),
+			wantErr:            "could not get user info: some userinfo error",
+			wantUserInfoCalled: true,
+		},
+		{
+			name:               "refresh returns no ID token and the userinfo response has no subject",
+			refreshToken:       "valid-refresh-token",
+			userInfo:           forceUserInfoWithClaims("", `{"groups":"fancy-group"}`),
+			wantErr:            "userinfo response did not contain a 'sub' claim",
+			wantUserInfoCalled: true,
+		},
+	}
+	for _, tt := range refreshTests {
+		tt := tt
+		t.Run("Refresh: "+tt.name, func(t *testing.T) {
+			tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodPost, r.Method)
+				require.NoError(t, r.ParseForm())
+				require.Equal(t, "test-client-id", r.Form.Get("client_id"))
+				require.Equal(t, "refresh_token", r.Form.Get("grant_type"))
+				if r.Form.Get("refresh_token") != "valid-refresh-token" {
+					http.Error(w, "invalid refresh token", http.StatusUnauthorized)
+					return
+				}
+				var response struct {
+					oauth2.Token
+					IDToken string `json:"id_token,omitempty"`
+				}
+				response.AccessToken = "test-access-token"
+				response.RefreshToken = tt.returnRefreshToken
+				response.IDToken = tt.returnIDTok
+				w.Header().Set("content-type", "application/json")
+				require.NoError(t, json.NewEncoder(w).Encode(&response))
+			}))
+			t.Cleanup(tokenServer.Close)
+
+			p := ProviderConfig{
+				Name: "test-name",
+				Config: &oauth2.Config{
+					ClientID: "test-client-id",
+					Endpoint: oauth2.Endpoint{
+						AuthURL:   "https://example.com",
+						TokenURL:  tokenServer.URL,
+						AuthStyle: oauth2.AuthStyleInParams,
+					},
+				},
+				Provider: &mockProvider{
+					userInfo:    tt.userInfo,
+					userInfoErr: tt.userInfoErr,
+				},
+			}
+
+			tok, err := p.Refresh(context.Background(), tt.refreshToken)
+			require.Equal(t, tt.wantUserInfoCalled, p.Provider.(*mockProvider).called)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				require.Nil(t, tok)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantToken, tok)
+		})
+	}
 }
 
 // mockVerifier returns an *oidc.IDTokenVerifier that validates any correctly serialized JWT without doing much else.
@@ -0,0 +1,154 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamsaml
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+
+	idpv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+)
+
+const (
+	metadataNamespace  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	protocolNamespace  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	assertionNamespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+
+	httpRedirectBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	httpPOSTBinding     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+)
+
+// Metadata holds the parts of the SAML metadata of an identity provider which are needed to log in with it.
+type Metadata struct {
+	// EntityID is the entity ID of the identity provider, which is the issuer of its responses and assertions.
+	EntityID string
+
+	// SingleSignOnServices are the locations of the single sign-on service of the identity provider, by binding.
+	SingleSignOnServices map[idpv1alpha1.SAMLBinding]*url.URL
+
+	// SigningCertificates are the certificates whose keys may sign the responses and assertions of the identity
+	// provider. Signatures are only accepted while the certificate which verifies them is valid.
+	SigningCertificates []*x509.Certificate
+}
+
+// ParseMetadata parses the SAML metadata document of an identity provider. The document must either be an
+// EntityDescriptor, or an EntitiesDescriptor which holds exactly one EntityDescriptor of an identity provider.
+func ParseMetadata(data []byte) (*Metadata, error) {
+	root, err := parseXML(data)
+	if err != nil {
+		return nil, err
+	}
+
+	entityDescriptor, err := findIdentityProviderEntityDescriptor(root)
+	if err != nil {
+		return nil, err
+	}
+
+	entityID, _ := attr(entityDescriptor, "entityID")
+	if entityID == "" {
+		return nil, fmt.Errorf("EntityDescriptor has no entityID")
+	}
+
+	idpDescriptor := childElement(entityDescriptor, metadataNamespace, "IDPSSODescriptor")
+	if idpDescriptor == nil {
+		return nil, fmt.Errorf("EntityDescriptor %q must have exactly one IDPSSODescriptor", entityID)
+	}
+	if protocols, _ := attr(idpDescriptor, "protocolSupportEnumeration"); !containsField(protocols, protocolNamespace) {
+		return nil, fmt.Errorf("IDPSSODescriptor of %q does not support the SAML 2.0 protocol", entityID)
+	}
+
+	metadata := &Metadata{
+		EntityID:             entityID,
+		SingleSignOnServices: map[idpv1alpha1.SAMLBinding]*url.URL{},
+	}
+
+	for _, keyDescriptor := range childElements(idpDescriptor, metadataNamespace, "KeyDescriptor") {
+		// Keys without a use may be used for both signing and encryption.
+		if use, _ := attr(keyDescriptor, "use"); use != "" && use != "signing" {
+			continue
+		}
+		keyInfo := childElement(keyDescriptor, dsig.Namespace, "KeyInfo")
+		if keyInfo == nil {
+			continue
+		}
+		for _, x509Data := range childElements(keyInfo, dsig.Namespace, "X509Data") {
+			for _, x509Certificate := range childElements(x509Data, dsig.Namespace, "X509Certificate") {
+				der, err := decodeBase64(text(x509Certificate))
+				if err != nil {
+					return nil, fmt.Errorf("could not decode signing certificate of %q: %w", entityID, err)
+				}
+				cert, err := x509.ParseCertificate(der)
+				if err != nil {
+					return nil, fmt.Errorf("could not parse signing certificate of %q: %w", entityID, err)
+				}
+				metadata.SigningCertificates = append(metadata.SigningCertificates, cert)
+			}
+		}
+	}
+	if len(metadata.SigningCertificates) == 0 {
+		return nil, fmt.Errorf("IDPSSODescriptor of %q has no signing certificates", entityID)
+	}
+
+	for _, service := range childElements(idpDescriptor, metadataNamespace, "SingleSignOnService") {
+		var binding idpv1alpha1.SAMLBinding
+		switch b, _ := attr(service, "Binding"); b {
+		case httpRedirectBinding:
+			binding = idpv1alpha1.SAMLBindingHTTPRedirect
+		case httpPOSTBinding:
+			binding = idpv1alpha1.SAMLBindingHTTPPOST
+		default:
+			continue
+		}
+		if _, ok := metadata.SingleSignOnServices[binding]; ok {
+			// Use the first service for each binding.
+			continue
+		}
+		location, _ := attr(service, "Location")
+		locationURL, err := url.Parse(location)
+		if err != nil || locationURL.Scheme != "https" || locationURL.Host == "" {
+			return nil, fmt.Errorf("SingleSignOnService of %q has an invalid location %q: must be an https URL", entityID, location)
+		}
+		metadata.SingleSignOnServices[binding] = locationURL
+	}
+	if len(metadata.SingleSignOnServices) == 0 {
+		return nil, fmt.Errorf("IDPSSODescriptor of %q has no SingleSignOnService with the HTTP-Redirect or HTTP-POST binding", entityID)
+	}
+
+	return metadata, nil
+}
+
+func findIdentityProviderEntityDescriptor(root *etree.Element) (*etree.Element, error) {
+	switch {
+	case is(root, metadataNamespace, "EntityDescriptor"):
+		return root, nil
+	case is(root, metadataNamespace, "EntitiesDescriptor"):
+		var found []*etree.Element
+		for _, entityDescriptor := range childElements(root, metadataNamespace, "EntityDescriptor") {
+			if len(childElements(entityDescriptor, metadataNamespace, "IDPSSODescriptor")) > 0 {
+				found = append(found, entityDescriptor)
+			}
+		}
+		if len(found) != 1 {
+			return nil, fmt.Errorf("EntitiesDescriptor must have exactly one EntityDescriptor of an identity provider, found %d", len(found))
+		}
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("metadata must be an EntityDescriptor or an EntitiesDescriptor, found %s", root.Tag)
+	}
+}
+
+// containsField returns true when the whitespace-separated list contains the value.
+func containsField(list string, value string) bool {
+	for _, field := range strings.Fields(list) {
+		if field == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamsaml
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	idpv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+)
+
+func TestParseMetadata(t *testing.T) {
+	cert1, cert1DER := newTestCertificate(t, "cert1")
+	cert2, cert2DER := newTestCertificate(t, "cert2")
+	_, encryptionCertDER := newTestCertificate(t, "encryption")
+
+	entityDescriptor := func(idpDescriptor string) string {
+		return `<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="https://idp.example.com/metadata">` +
+			idpDescriptor +
+			`</md:EntityDescriptor>`
+	}
+	keyDescriptor := func(use string, der []byte) string {
+		useAttr := ""
+		if use != "" {
+			useAttr = ` use="` + use + `"`
+		}
+		// Certificates in metadata are often wrapped over several lines.
+		encoded := base64.StdEncoding.EncodeToString(der)
+		return `<md:KeyDescriptor` + useAttr + `><ds:KeyInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:X509Data><ds:X509Certificate>` +
+			encoded[:40] + "\n    " + encoded[40:] +
+			`</ds:X509Certificate></ds:X509Data></ds:KeyInfo></md:KeyDescriptor>`
+	}
+	const (
+		redirectService    = `<md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="https://idp.example.com/sso/redirect"/>`
+		postService        = `<md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="https://idp.example.com/sso/post"/>`
+		idpDescriptorStart = `<md:IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">`
+		idpDescriptorEnd   = `</md:IDPSSODescriptor>`
+	)
+	happyIDPDescriptor := idpDescriptorStart +
+		keyDescriptor("signing", cert1DER) +
+		keyDescriptor("encryption", encryptionCertDER) +
+		keyDescriptor("", cert2DER) +
+		`<md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:SOAP" Location="https://idp.example.com/sso/soap"/>` +
+		redirectService +
+		postService +
+		`<md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="https://idp.example.com/sso/other"/>` +
+		idpDescriptorEnd
+
+	tests := []struct {
+		name                     string
+		metadata                 string
+		wantEntityID             string
+		wantSingleSignOnServices map[idpv1alpha1.SAMLBinding]string
+		wantSigningCertificates  []*x509.Certificate
+		wantErr                  string
+	}{
+		{
+			name:         "an EntityDescriptor",
+			metadata:     entityDescriptor(happyIDPDescriptor),
+			wantEntityID: "https://idp.example.com/metadata",
+			wantSingleSignOnServices: map[idpv1alpha1.SAMLBinding]string{
+				idpv1alpha1.SAMLBindingHTTPRedirect: "https://idp.example.com/sso/redirect",
+				idpv1alpha1.SAMLBindingHTTPPOST:     "https://idp.example.com/sso/post",
+			},
+			wantSigningCertificates: []*x509.Certificate{cert1, cert2},
+		},
+		{
+			name: "an EntitiesDescriptor with one identity provider",
+			metadata: `<md:EntitiesDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata">` +
+				`<md:EntityDescriptor entityID="https://sp.example.com"><md:SPSSODescriptor/></md:EntityDescriptor>` +
+				`<md:EntityDescriptor entityID="https://idp.example.com/metadata">` + happyIDPDescriptor + `</md:EntityDescriptor>` +
+				`</md:EntitiesDescriptor>`,
+			wantEntityID: "https://idp.example.com/metadata",
+			wantSingleSignOnServices: map[idpv1alpha1.SAMLBinding]string{
+				idpv1alpha1.SAMLBindingHTTPRedirect: "https://idp.example.com/sso/redirect",
+				idpv1alpha1.SAMLBindingHTTPPOST:     "https://idp.example.com/sso/post",
+			},
+			wantSigningCertificates: []*x509.Certificate{cert1, cert2},
+		},
+		{
+			name: "an EntitiesDescriptor with two identity providers",
+			metadata: `<md:EntitiesDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata">` +
+				`<md:EntityDescriptor entityID="https://idp1.example.com">` + happyIDPDescriptor + `</md:EntityDescriptor>` +
+				`<md:EntityDescriptor entityID="https://idp2.example.com">` + happyIDPDescriptor + `</md:EntityDescriptor>` +
+				`</md:EntitiesDescriptor>`,
+			wantErr: "EntitiesDescriptor must have exactly one EntityDescriptor of an identity provider, found 2",
+		},
+		{
+			name:     "not metadata",
+			metadata: `<html><body>Log in</body></html>`,
+			wantErr:  "metadata must be an EntityDescriptor or an EntitiesDescriptor, found html",
+		},
+		{
+			name:     "not XML",
+			metadata: `{"entityID": "https://idp.example.com/metadata"}`,
+			wantErr:  "could not parse XML: no root element",
+		},
+		{
+			name:     "no entity ID",
+			metadata: strings.Replace(entityDescriptor(happyIDPDescriptor), ` entityID="https://idp.example.com/metadata"`, "", 1),
+			wantErr:  "EntityDescriptor has no entityID",
+		},
+		{
+			name:     "no IDPSSODescriptor",
+			metadata: entityDescriptor(`<md:SPSSODescriptor/>`),
+			wantErr:  `EntityDescriptor "https://idp.example.com/metadata" must have exactly one IDPSSODescriptor`,
+		},
+		{
+			name:     "the IDPSSODescriptor does not support SAML 2.0",
+			metadata: entityDescriptor(strings.Replace(happyIDPDescriptor, "SAML:2.0:protocol", "SAML:1.1:protocol", 1)),
+			wantErr:  `IDPSSODescriptor of "https://idp.example.com/metadata" does not support the SAML 2.0 protocol`,
+		},
+		{
+			name:     "no signing certificates",
+			metadata: entityDescriptor(idpDescriptorStart + keyDescriptor("encryption", encryptionCertDER) + redirectService + idpDescriptorEnd),
+			wantErr:  `IDPSSODescriptor of "https://idp.example.com/metadata" has no signing certificates`,
+		},
+		{
+			name:     "a signing certificate which is not base64",
+			metadata: entityDescriptor(idpDescriptorStart + strings.Replace(keyDescriptor("signing", cert1DER), "<ds:X509Certificate>", "<ds:X509Certificate>!", 1) + redirectService + idpDescriptorEnd),
+			wantErr:  `could not decode signing certificate of "https://idp.example.com/metadata": illegal base64 data at input byte 0`,
+		},
+		{
+			name:     "no single sign-on service with a supported binding",
+			metadata: entityDescriptor(idpDescriptorStart + keyDescriptor("signing", cert1DER) + idpDescriptorEnd),
+			wantErr:  `IDPSSODescriptor of "https://idp.example.com/metadata" has no SingleSignOnService with the HTTP-Redirect or HTTP-POST binding`,
+		},
+		{
+			name:     "a single sign-on service which is not https",
+			metadata: entityDescriptor(idpDescriptorStart + keyDescriptor("signing", cert1DER) + strings.Replace(redirectService, "https://", "http://", 1) + idpDescriptorEnd),
+			wantErr:  `SingleSignOnService of "https://idp.example.com/metadata" has an invalid location "http://idp.example.com/sso/redirect": must be an https URL`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			metadata, err := ParseMetadata([]byte(tt.metadata))
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				require.Nil(t, metadata)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantEntityID, metadata.EntityID)
+			require.Equal(t, tt.wantSigningCertificates, metadata.SigningCertificates)
+			require.Len(t, metadata.SingleSignOnServices, len(tt.wantSingleSignOnServices))
+			for binding, location := range tt.wantSingleSignOnServices {
+				require.Equal(t, location, metadata.SingleSignOnServices[binding].String())
+			}
+		})
+	}
+}
+
+func newTestCertificate(t *testing.T, commonName string) (*x509.Certificate, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, der
+}
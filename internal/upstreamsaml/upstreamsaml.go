@@ -0,0 +1,510 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package upstreamsaml implements an abstraction of upstream SAML 2.0 IDP interactions.
+//
+// The Supervisor acts as a service provider of the Web Browser SSO profile. It sends unsigned authentication requests
+// with either the HTTP-Redirect or the HTTP-POST binding, and receives responses at its assertion consumer service
+// with the HTTP-POST binding. Either the response or its assertion must be signed by the identity provider, and
+// encrypted assertions are not supported.
+package upstreamsaml
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	idpv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/plog"
+)
+
+const (
+	successStatus      = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	bearerConfirmation = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+
+	// The amount of clock skew between the Supervisor and the identity provider which is tolerated when checking
+	// the validity periods of assertions.
+	allowedClockSkew = 3 * time.Minute
+
+	errSignatureVerificationFailed = constable.Error("signature verification failed")
+)
+
+// allowedSignatureAlgorithms are the allowed signature methods. SHA-1 is deliberately not allowed.
+//nolint: gochecknoglobals // This set is effectively constant.
+var allowedSignatureAlgorithms = sets.NewString(
+	dsig.RSASHA256SignatureMethod,
+	dsig.RSASHA384SignatureMethod,
+	dsig.RSASHA512SignatureMethod,
+	dsig.ECDSASHA256SignatureMethod,
+	dsig.ECDSASHA384SignatureMethod,
+	dsig.ECDSASHA512SignatureMethod,
+)
+
+// allowedDigestAlgorithms are the allowed digest methods. SHA-1 is deliberately not allowed.
+//nolint: gochecknoglobals // This set is effectively constant.
+var allowedDigestAlgorithms = sets.NewString(
+	"http://www.w3.org/2001/04/xmlenc#sha256",
+	"http://www.w3.org/2001/04/xmldsig-more#sha384",
+	"http://www.w3.org/2001/04/xmlenc#sha512",
+)
+
+// ProviderConfig holds the active configuration of an upstream SAML provider.
+type ProviderConfig struct {
+	// Name is the unique name of this upstream SAML IDP.
+	Name string
+
+	// EntityID is the entity ID of the identity provider from its metadata.
+	EntityID string
+
+	// SingleSignOnURL is the location of the single sign-on service of the identity provider for the Binding.
+	SingleSignOnURL *url.URL
+
+	// Binding is the SAML binding which is used to send authentication requests to the SingleSignOnURL.
+	Binding idpv1alpha1.SAMLBinding
+
+	// SigningCertificates are the certificates of the identity provider whose keys are trusted to sign responses.
+	SigningCertificates []*x509.Certificate
+
+	// UsernameAttribute is the name of the assertion attribute which holds the username. When it is empty, the NameID
+	// of the subject is used as the username.
+	UsernameAttribute string
+
+	// GroupsAttribute is the name of the assertion attribute which holds the group names. When it is empty, users do
+	// not get any groups from this identity provider.
+	GroupsAttribute string
+
+	// Clock returns the current time. When it is nil, time.Now is used.
+	Clock func() time.Time
+}
+
+var _ provider.UpstreamSAMLIdentityProviderI = (*ProviderConfig)(nil)
+
+func (p *ProviderConfig) GetName() string {
+	return p.Name
+}
+
+func (p *ProviderConfig) GetEntityID() string {
+	return p.EntityID
+}
+
+// The authentication request which is sent to the identity provider.
+type authnRequest struct {
+	XMLName                     xml.Name     `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID                          string       `xml:"ID,attr"`
+	Version                     string       `xml:"Version,attr"`
+	IssueInstant                string       `xml:"IssueInstant,attr"`
+	Destination                 string       `xml:"Destination,attr"`
+	AssertionConsumerServiceURL string       `xml:"AssertionConsumerServiceURL,attr"`
+	ProtocolBinding             string       `xml:"ProtocolBinding,attr"`
+	Issuer                      issuer       `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	NameIDPolicy                nameIDPolicy `xml:"urn:oasis:names:tc:SAML:2.0:protocol NameIDPolicy"`
+}
+
+type issuer struct {
+	Value string `xml:",chardata"`
+}
+
+type nameIDPolicy struct {
+	AllowCreate bool `xml:"AllowCreate,attr"`
+}
+
+func (p *ProviderConfig) AuthnRequest(requestID string, spEntityID string, acsURL string, relayState string) (*provider.SAMLAuthnRequest, error) {
+	request, err := xml.Marshal(&authnRequest{
+		ID:                          requestID,
+		Version:                     "2.0",
+		IssueInstant:                p.now().UTC().Format(time.RFC3339),
+		Destination:                 p.SingleSignOnURL.String(),
+		AssertionConsumerServiceURL: acsURL,
+		ProtocolBinding:             httpPOSTBinding,
+		Issuer:                      issuer{Value: spEntityID},
+		NameIDPolicy:                nameIDPolicy{AllowCreate: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not encode authentication request: %w", err)
+	}
+
+	ssoURL := *p.SingleSignOnURL
+	if p.Binding == idpv1alpha1.SAMLBindingHTTPPOST {
+		return &provider.SAMLAuthnRequest{
+			URL: &ssoURL,
+			PostParams: url.Values{
+				"SAMLRequest": []string{base64.StdEncoding.EncodeToString(request)},
+				"RelayState":  []string{relayState},
+			},
+		}, nil
+	}
+
+	// The HTTP-Redirect binding requires the request to be compressed with DEFLATE.
+	var compressed bytes.Buffer
+	writer, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	if err != nil {
+		return nil, fmt.Errorf("could not compress authentication request: %w", err)
+	}
+	if _, err := writer.Write(request); err != nil {
+		return nil, fmt.Errorf("could not compress authentication request: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("could not compress authentication request: %w", err)
+	}
+
+	query := ssoURL.Query()
+	query.Set("SAMLRequest", base64.StdEncoding.EncodeToString(compressed.Bytes()))
+	query.Set("RelayState", relayState)
+	ssoURL.RawQuery = query.Encode()
+	return &provider.SAMLAuthnRequest{URL: &ssoURL}, nil
+}
+
+// ValidateResponse validates a response of the Web Browser SSO profile, as described in
+// https://docs.oasis-open.org/security/saml/v2.0/saml-profiles-2.0-os.pdf, and returns the identity of the user from
+// its assertion. Only responses to the given authentication request are accepted, so unsolicited responses are
+// always rejected.
+func (p *ProviderConfig) ValidateResponse(_ context.Context, encodedResponse string, requestID string, spEntityID string, acsURL string) (*authenticator.Response, error) {
+	data, err := decodeBase64(encodedResponse)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode response: %w", err)
+	}
+	response, err := parseXML(data)
+	if err != nil {
+		return nil, err
+	}
+	if !is(response, protocolNamespace, "Response") {
+		return nil, fmt.Errorf("expected a Response, found %s", response.Tag)
+	}
+
+	if len(childElements(response, assertionNamespace, "EncryptedAssertion")) > 0 {
+		return nil, fmt.Errorf("encrypted assertions are not supported")
+	}
+	assertion := childElement(response, assertionNamespace, "Assertion")
+	if assertion == nil {
+		return nil, fmt.Errorf("response must have exactly one assertion")
+	}
+
+	// Either the whole response or the assertion must be signed. The assertion is a child of the response, so
+	// it is covered by a signature of the response. Only the elements which were returned by the signature
+	// validation are used afterwards, so that no unsigned content can be wrapped around the signed content.
+	responseSigned, err := isSigned(response)
+	if err != nil {
+		return nil, err
+	}
+	assertionSigned, err := isSigned(assertion)
+	if err != nil {
+		return nil, err
+	}
+	if !responseSigned && !assertionSigned {
+		return nil, fmt.Errorf("%w: neither the response nor the assertion is signed", errSignatureVerificationFailed)
+	}
+	if responseSigned {
+		if response, err = p.verifySignature(response); err != nil {
+			return nil, err
+		}
+		if assertion = childElement(response, assertionNamespace, "Assertion"); assertion == nil {
+			return nil, fmt.Errorf("response must have exactly one assertion")
+		}
+	}
+	if assertionSigned {
+		// The assertion is validated on its own, so it needs the namespace declarations of its ancestors.
+		nsContext, err := etreeutils.NSBuildParentContext(assertion)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errSignatureVerificationFailed, err)
+		}
+		if assertion, err = etreeutils.NSDetatch(nsContext, assertion); err != nil {
+			return nil, fmt.Errorf("%w: %v", errSignatureVerificationFailed, err)
+		}
+		if assertion, err = p.verifySignature(assertion); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.validateResponseEnvelope(response, requestID, acsURL); err != nil {
+		return nil, err
+	}
+
+	nameID, err := p.validateAssertion(assertion, requestID, spEntityID, acsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := attributesOf(assertion)
+	plog.All("attributes from SAML assertion", "providerName", p.Name, "nameID", nameID, "attributes", attributes)
+
+	username := nameID
+	if p.UsernameAttribute != "" {
+		values := attributes[p.UsernameAttribute]
+		if len(values) != 1 || values[0] == "" {
+			return nil, fmt.Errorf("assertion must have exactly one non-empty value of the username attribute %q", p.UsernameAttribute)
+		}
+		username = values[0]
+	}
+
+	var groups []string
+	if p.GroupsAttribute != "" {
+		groups = attributes[p.GroupsAttribute]
+	}
+
+	return &authenticator.Response{
+		User: &user.DefaultInfo{
+			Name:   username,
+			UID:    nameID,
+			Groups: groups,
+		},
+	}, nil
+}
+
+// validateResponseEnvelope checks the parts of the response which are outside of its assertion.
+func (p *ProviderConfig) validateResponseEnvelope(response *etree.Element, requestID string, acsURL string) error {
+	if version, _ := attr(response, "Version"); version != "2.0" {
+		return fmt.Errorf("unsupported response version %q", version)
+	}
+	if destination, ok := attr(response, "Destination"); ok && destination != acsURL {
+		return fmt.Errorf("response destination %q does not match the assertion consumer service %q", destination, acsURL)
+	}
+	if inResponseTo, _ := attr(response, "InResponseTo"); inResponseTo != requestID {
+		return fmt.Errorf("response is not for the authentication request of this login")
+	}
+	if responseIssuer := childElement(response, assertionNamespace, "Issuer"); responseIssuer != nil && strings.TrimSpace(text(responseIssuer)) != p.EntityID {
+		return fmt.Errorf("response issuer %q does not match the entity ID %q", strings.TrimSpace(text(responseIssuer)), p.EntityID)
+	}
+
+	status := childElement(response, protocolNamespace, "Status")
+	if status == nil {
+		return fmt.Errorf("response has no status")
+	}
+	statusCode := childElement(status, protocolNamespace, "StatusCode")
+	if statusCode == nil {
+		return fmt.Errorf("response has no status code")
+	}
+	if value, _ := attr(statusCode, "Value"); value != successStatus {
+		if subStatusCode := childElement(statusCode, protocolNamespace, "StatusCode"); subStatusCode != nil {
+			subValue, _ := attr(subStatusCode, "Value")
+			return fmt.Errorf("login failed with status %q (%q)", value, subValue)
+		}
+		return fmt.Errorf("login failed with status %q", value)
+	}
+	return nil
+}
+
+// validateAssertion checks the issuer, the subject, and the conditions of the assertion, and returns the NameID of
+// its subject.
+func (p *ProviderConfig) validateAssertion(assertion *etree.Element, requestID string, spEntityID string, acsURL string) (string, error) {
+	now := p.now()
+
+	if version, _ := attr(assertion, "Version"); version != "2.0" {
+		return "", fmt.Errorf("unsupported assertion version %q", version)
+	}
+	assertionIssuer := childElement(assertion, assertionNamespace, "Issuer")
+	if assertionIssuer == nil || strings.TrimSpace(text(assertionIssuer)) != p.EntityID {
+		return "", fmt.Errorf("assertion issuer does not match the entity ID %q", p.EntityID)
+	}
+
+	subject := childElement(assertion, assertionNamespace, "Subject")
+	if subject == nil {
+		return "", fmt.Errorf("assertion has no subject")
+	}
+	nameIDElement := childElement(subject, assertionNamespace, "NameID")
+	if nameIDElement == nil {
+		return "", fmt.Errorf("assertion subject has no NameID")
+	}
+	nameID := strings.TrimSpace(text(nameIDElement))
+	if nameID == "" {
+		return "", fmt.Errorf("assertion subject has an empty NameID")
+	}
+
+	// At least one bearer subject confirmation must be valid for this login.
+	confirmationErr := fmt.Errorf("assertion subject has no bearer subject confirmation")
+	for _, confirmation := range childElements(subject, assertionNamespace, "SubjectConfirmation") {
+		if method, _ := attr(confirmation, "Method"); method != bearerConfirmation {
+			continue
+		}
+		confirmationErr = validateBearerConfirmation(confirmation, requestID, acsURL, now)
+		if confirmationErr == nil {
+			break
+		}
+	}
+	if confirmationErr != nil {
+		return "", confirmationErr
+	}
+
+	conditions := childElement(assertion, assertionNamespace, "Conditions")
+	if conditions == nil {
+		return "", fmt.Errorf("assertion has no conditions")
+	}
+	if err := validateTimeRange(conditions, now); err != nil {
+		return "", fmt.Errorf("assertion conditions: %w", err)
+	}
+	audienceRestrictions := childElements(conditions, assertionNamespace, "AudienceRestriction")
+	if len(audienceRestrictions) == 0 {
+		return "", fmt.Errorf("assertion conditions have no audience restriction")
+	}
+	for _, audienceRestriction := range audienceRestrictions {
+		found := false
+		for _, audience := range childElements(audienceRestriction, assertionNamespace, "Audience") {
+			if strings.TrimSpace(text(audience)) == spEntityID {
+				found = true
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("assertion audience restriction does not include %q", spEntityID)
+		}
+	}
+
+	return nameID, nil
+}
+
+func validateBearerConfirmation(confirmation *etree.Element, requestID string, acsURL string, now time.Time) error {
+	data := childElement(confirmation, assertionNamespace, "SubjectConfirmationData")
+	if data == nil {
+		return fmt.Errorf("bearer subject confirmation has no data")
+	}
+	if recipient, _ := attr(data, "Recipient"); recipient != acsURL {
+		return fmt.Errorf("bearer subject confirmation recipient %q does not match the assertion consumer service %q", recipient, acsURL)
+	}
+	// The profile requires the InResponseTo of a response to an authentication request, and it binds the assertion
+	// to this login even when only the assertion is signed.
+	if inResponseTo, _ := attr(data, "InResponseTo"); inResponseTo != requestID {
+		return fmt.Errorf("bearer subject confirmation is not for the authentication request of this login")
+	}
+	if _, ok := attr(data, "NotBefore"); ok {
+		return fmt.Errorf("bearer subject confirmation must not have a NotBefore time")
+	}
+	if _, ok := attr(data, "NotOnOrAfter"); !ok {
+		return fmt.Errorf("bearer subject confirmation has no NotOnOrAfter time")
+	}
+	if err := validateTimeRange(data, now); err != nil {
+		return fmt.Errorf("bearer subject confirmation: %w", err)
+	}
+	return nil
+}
+
+// validateTimeRange checks the optional NotBefore and NotOnOrAfter attributes of the element.
+func validateTimeRange(e *etree.Element, now time.Time) error {
+	if notBefore, ok := attr(e, "NotBefore"); ok {
+		t, err := time.Parse(time.RFC3339Nano, notBefore)
+		if err != nil {
+			return fmt.Errorf("invalid NotBefore time %q", notBefore)
+		}
+		if now.Add(allowedClockSkew).Before(t) {
+			return fmt.Errorf("not valid before %s", notBefore)
+		}
+	}
+	if notOnOrAfter, ok := attr(e, "NotOnOrAfter"); ok {
+		t, err := time.Parse(time.RFC3339Nano, notOnOrAfter)
+		if err != nil {
+			return fmt.Errorf("invalid NotOnOrAfter time %q", notOnOrAfter)
+		}
+		if !now.Add(-allowedClockSkew).Before(t) {
+			return fmt.Errorf("expired at %s", notOnOrAfter)
+		}
+	}
+	return nil
+}
+
+// attributesOf returns the values of the attributes of the assertion, by attribute name.
+func attributesOf(assertion *etree.Element) map[string][]string {
+	attributes := map[string][]string{}
+	for _, statement := range childElements(assertion, assertionNamespace, "AttributeStatement") {
+		for _, attribute := range childElements(statement, assertionNamespace, "Attribute") {
+			name, _ := attr(attribute, "Name")
+			for _, value := range childElements(attribute, assertionNamespace, "AttributeValue") {
+				attributes[name] = append(attributes[name], strings.TrimSpace(text(value)))
+			}
+		}
+	}
+	return attributes
+}
+
+// isSigned returns whether the element has an XML signature as a direct child.
+func isSigned(e *etree.Element) (bool, error) {
+	switch signatures := childElements(e, dsig.Namespace, dsig.SignatureTag); len(signatures) {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	default:
+		return false, fmt.Errorf("%w: element %s has more than one signature", errSignatureVerificationFailed, e.Tag)
+	}
+}
+
+// verifySignature verifies the enveloped signature of the element with one of the signing certificates, and returns
+// the signed element without its signature. The signing certificates must be valid at the current time.
+func (p *ProviderConfig) verifySignature(e *etree.Element) (*etree.Element, error) {
+	if err := checkSignatureAlgorithms(e); err != nil {
+		return nil, err
+	}
+
+	validate := func(certs ...*x509.Certificate) (*etree.Element, error) {
+		validationContext := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: certs})
+		validationContext.Clock = dsig.NewFakeClockAt(p.now())
+		validated, err := validationContext.Validate(e)
+		if err != nil {
+			return nil, fmt.Errorf("%w: element %s: %v", errSignatureVerificationFailed, e.Tag, err)
+		}
+		return validated, nil
+	}
+
+	signature := childElement(e, dsig.Namespace, dsig.SignatureTag)
+	if signature != nil && childElement(signature, dsig.Namespace, dsig.KeyInfoTag) != nil {
+		return validate(p.SigningCertificates...)
+	}
+
+	// Without a KeyInfo in the signature, a validation context can only use a single certificate, so each
+	// certificate is tried on its own.
+	for _, cert := range p.SigningCertificates {
+		if validated, err := validate(cert); err == nil {
+			return validated, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: element %s was not signed by any of the signing certificates", errSignatureVerificationFailed, e.Tag)
+}
+
+// checkSignatureAlgorithms rejects the element when any XML signature within it uses an algorithm which is not
+// allowed, since the validation of goxmldsig also accepts SHA-1.
+func checkSignatureAlgorithms(e *etree.Element) error {
+	for _, child := range e.ChildElements() {
+		if err := checkSignatureAlgorithms(child); err != nil {
+			return err
+		}
+	}
+	if !is(e, dsig.Namespace, dsig.SignatureTag) {
+		return nil
+	}
+	signedInfo := childElement(e, dsig.Namespace, dsig.SignedInfoTag)
+	if signedInfo == nil {
+		return fmt.Errorf("%w: signature has no SignedInfo", errSignatureVerificationFailed)
+	}
+	for _, method := range childElements(signedInfo, dsig.Namespace, dsig.SignatureMethodTag) {
+		if algorithm, _ := attr(method, dsig.AlgorithmAttr); !allowedSignatureAlgorithms.Has(algorithm) {
+			return fmt.Errorf("%w: unsupported signature method %q", errSignatureVerificationFailed, algorithm)
+		}
+	}
+	for _, reference := range childElements(signedInfo, dsig.Namespace, dsig.ReferenceTag) {
+		for _, method := range childElements(reference, dsig.Namespace, dsig.DigestMethodTag) {
+			if algorithm, _ := attr(method, dsig.AlgorithmAttr); !allowedDigestAlgorithms.Has(algorithm) {
+				return fmt.Errorf("%w: unsupported digest method %q", errSignatureVerificationFailed, algorithm)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *ProviderConfig) now() time.Time {
+	if p.Clock == nil {
+		return time.Now()
+	}
+	return p.Clock()
+}
@@ -0,0 +1,534 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamsaml
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	idpv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+)
+
+const (
+	testEntityID   = "https://idp.example.com/metadata"
+	testSPEntityID = "https://issuer.example.com/some/path"
+	testACSURL     = "https://issuer.example.com/some/path/saml/acs"
+	testRequestID  = "_test-request-id"
+
+	testResponseID  = "_test-response-id"
+	testAssertionID = "_test-assertion-id"
+)
+
+// testResponse is an unsigned response. The signatures of the response and the assertion go after their issuers.
+const testResponse = `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ` +
+	`ID="_test-response-id" Version="2.0" IssueInstant="2021-06-01T12:00:00Z" ` +
+	`Destination="https://issuer.example.com/some/path/saml/acs" InResponseTo="_test-request-id">
+  <saml:Issuer>https://idp.example.com/metadata</saml:Issuer>
+  <samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>
+  <saml:Assertion ID="_test-assertion-id" Version="2.0" IssueInstant="2021-06-01T12:00:00Z">
+    <saml:Issuer>https://idp.example.com/metadata</saml:Issuer>
+    <saml:Subject>
+      <saml:NameID Format="urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified">user-id-123</saml:NameID>
+      <saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">
+        <saml:SubjectConfirmationData InResponseTo="_test-request-id" NotOnOrAfter="2021-06-01T12:05:00Z" Recipient="https://issuer.example.com/some/path/saml/acs"/>
+      </saml:SubjectConfirmation>
+    </saml:Subject>
+    <saml:Conditions NotBefore="2021-06-01T11:59:00Z" NotOnOrAfter="2021-06-01T12:05:00Z">
+      <saml:AudienceRestriction><saml:Audience>https://issuer.example.com/some/path</saml:Audience></saml:AudienceRestriction>
+    </saml:Conditions>
+    <saml:AuthnStatement AuthnInstant="2021-06-01T12:00:00Z"/>
+    <saml:AttributeStatement>
+      <saml:Attribute Name="email"><saml:AttributeValue>alice@example.com</saml:AttributeValue></saml:Attribute>
+      <saml:Attribute Name="groups"><saml:AttributeValue>admins</saml:AttributeValue><saml:AttributeValue>developers</saml:AttributeValue></saml:Attribute>
+    </saml:AttributeStatement>
+  </saml:Assertion>
+</samlp:Response>`
+
+func TestGetters(t *testing.T) {
+	p := &ProviderConfig{Name: "test-name", EntityID: testEntityID}
+	require.Equal(t, "test-name", p.GetName())
+	require.Equal(t, testEntityID, p.GetEntityID())
+}
+
+func TestAuthnRequest(t *testing.T) {
+	ssoURL, err := url.Parse("https://idp.example.com/sso?tenant=123")
+	require.NoError(t, err)
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	wantRequest := `<AuthnRequest xmlns="urn:oasis:names:tc:SAML:2.0:protocol" ID="_test-request-id" Version="2.0" ` +
+		`IssueInstant="2021-06-01T12:00:00Z" Destination="https://idp.example.com/sso?tenant=123" ` +
+		`AssertionConsumerServiceURL="https://issuer.example.com/some/path/saml/acs" ` +
+		`ProtocolBinding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST">` +
+		`<Issuer xmlns="urn:oasis:names:tc:SAML:2.0:assertion">https://issuer.example.com/some/path</Issuer>` +
+		`<NameIDPolicy xmlns="urn:oasis:names:tc:SAML:2.0:protocol" AllowCreate="true"></NameIDPolicy>` +
+		`</AuthnRequest>`
+
+	t.Run("HTTP-Redirect binding", func(t *testing.T) {
+		p := &ProviderConfig{SingleSignOnURL: ssoURL, Binding: idpv1alpha1.SAMLBindingHTTPRedirect, Clock: func() time.Time { return now }}
+		request, err := p.AuthnRequest(testRequestID, testSPEntityID, testACSURL, "test-relay-state")
+		require.NoError(t, err)
+
+		require.Nil(t, request.PostParams)
+		require.Equal(t, "https://idp.example.com/sso", (&url.URL{Scheme: request.URL.Scheme, Host: request.URL.Host, Path: request.URL.Path}).String())
+		query := request.URL.Query()
+		require.Len(t, query, 3)
+		require.Equal(t, "123", query.Get("tenant"))
+		require.Equal(t, "test-relay-state", query.Get("RelayState"))
+		compressed, err := base64.StdEncoding.DecodeString(query.Get("SAMLRequest"))
+		require.NoError(t, err)
+		decompressed, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+		require.NoError(t, err)
+		require.Equal(t, wantRequest, string(decompressed))
+
+		// The configured URL must not be modified.
+		require.Equal(t, "https://idp.example.com/sso?tenant=123", ssoURL.String())
+	})
+
+	t.Run("HTTP-POST binding", func(t *testing.T) {
+		p := &ProviderConfig{SingleSignOnURL: ssoURL, Binding: idpv1alpha1.SAMLBindingHTTPPOST, Clock: func() time.Time { return now }}
+		request, err := p.AuthnRequest(testRequestID, testSPEntityID, testACSURL, "test-relay-state")
+		require.NoError(t, err)
+
+		require.Equal(t, "https://idp.example.com/sso?tenant=123", request.URL.String())
+		require.Len(t, request.PostParams, 2)
+		require.Equal(t, "test-relay-state", request.PostParams.Get("RelayState"))
+		decoded, err := base64.StdEncoding.DecodeString(request.PostParams.Get("SAMLRequest"))
+		require.NoError(t, err)
+		require.Equal(t, wantRequest, string(decoded))
+	})
+}
+
+func TestValidateResponse(t *testing.T) {
+	generateRSAKey := func() (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 2048) }
+	validUntil := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	rsaKey := newTestSigningKey(t, generateRSAKey, validUntil)
+	ecdsaKey := newTestSigningKey(t, func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P256(), rand.Reader) }, validUntil)
+	expiredKey := newTestSigningKey(t, generateRSAKey, time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC))
+	otherKey := newTestSigningKey(t, generateRSAKey, validUntil)
+
+	happyResponse := func(edits ...func(string) string) string {
+		response := testResponse
+		for _, edit := range edits {
+			response = edit(response)
+		}
+		return response
+	}
+	replace := func(old, replacement string) func(string) string {
+		return func(s string) string {
+			require.Contains(t, s, old)
+			return strings.Replace(s, old, replacement, 1)
+		}
+	}
+
+	tests := []struct {
+		name              string
+		response          string
+		signAssertion     bool
+		signResponse      bool
+		signingKey        *testSigningKey
+		signatureMethod   string
+		withoutKeyInfo    bool
+		editSigned        func(string) string
+		usernameAttribute string
+		groupsAttribute   string
+		now               time.Time
+		wantResponse      *authenticator.Response
+		wantErr           string
+	}{
+		{
+			name:            "signed assertion with the NameID as the username and groups from an attribute",
+			response:        happyResponse(),
+			signAssertion:   true,
+			groupsAttribute: "groups",
+			wantResponse: &authenticator.Response{User: &user.DefaultInfo{
+				Name:   "user-id-123",
+				UID:    "user-id-123",
+				Groups: []string{"admins", "developers"},
+			}},
+		},
+		{
+			name:              "signed response with the username from an attribute",
+			response:          happyResponse(),
+			signResponse:      true,
+			usernameAttribute: "email",
+			wantResponse: &authenticator.Response{User: &user.DefaultInfo{
+				Name: "alice@example.com",
+				UID:  "user-id-123",
+			}},
+		},
+		{
+			name:              "signed response and assertion",
+			response:          happyResponse(),
+			signAssertion:     true,
+			signResponse:      true,
+			usernameAttribute: "email",
+			groupsAttribute:   "groups",
+			wantResponse: &authenticator.Response{User: &user.DefaultInfo{
+				Name:   "alice@example.com",
+				UID:    "user-id-123",
+				Groups: []string{"admins", "developers"},
+			}},
+		},
+		{
+			name:            "ECDSA signature",
+			response:        happyResponse(),
+			signAssertion:   true,
+			signingKey:      ecdsaKey,
+			signatureMethod: dsig.ECDSASHA256SignatureMethod,
+			wantResponse:    &authenticator.Response{User: &user.DefaultInfo{Name: "user-id-123", UID: "user-id-123"}},
+		},
+		{
+			name:            "signature without key info",
+			response:        happyResponse(),
+			signResponse:    true,
+			signingKey:      ecdsaKey,
+			signatureMethod: dsig.ECDSASHA256SignatureMethod,
+			withoutKeyInfo:  true,
+			wantResponse:    &authenticator.Response{User: &user.DefaultInfo{Name: "user-id-123", UID: "user-id-123"}},
+		},
+		{
+			name:          "a comment in the NameID does not truncate it",
+			response:      happyResponse(replace(`>user-id-123<`, `>user-id<!-- a comment -->-123<`)),
+			signAssertion: true,
+			wantResponse:  &authenticator.Response{User: &user.DefaultInfo{Name: "user-id-123", UID: "user-id-123"}},
+		},
+		{
+			name:          "validity periods are checked with some allowed clock skew",
+			response:      happyResponse(),
+			signAssertion: true,
+			now:           time.Date(2021, 6, 1, 12, 7, 0, 0, time.UTC),
+			wantResponse:  &authenticator.Response{User: &user.DefaultInfo{Name: "user-id-123", UID: "user-id-123"}},
+		},
+		{
+			name:     "not signed",
+			response: happyResponse(),
+			wantErr:  "signature verification failed: neither the response nor the assertion is signed",
+		},
+		{
+			name:          "signed by an untrusted key",
+			response:      happyResponse(),
+			signAssertion: true,
+			signingKey:    otherKey,
+			wantErr:       "signature verification failed: element Assertion: Could not verify certificate against trusted certs",
+		},
+		{
+			name:           "signed by an untrusted key without key info",
+			response:       happyResponse(),
+			signAssertion:  true,
+			signingKey:     otherKey,
+			withoutKeyInfo: true,
+			wantErr:        "signature verification failed: element Assertion was not signed by any of the signing certificates",
+		},
+		{
+			name:          "signed by a key whose certificate has expired",
+			response:      happyResponse(),
+			signAssertion: true,
+			signingKey:    expiredKey,
+			wantErr:       "signature verification failed: element Assertion: Cert is not valid at this time",
+		},
+		{
+			name:            "signed with SHA-1",
+			response:        happyResponse(),
+			signAssertion:   true,
+			signatureMethod: dsig.RSASHA1SignatureMethod,
+			wantErr:         `signature verification failed: unsupported signature method "http://www.w3.org/2000/09/xmldsig#rsa-sha1"`,
+		},
+		{
+			name:          "a SHA-1 digest",
+			response:      happyResponse(),
+			signAssertion: true,
+			editSigned:    replace(`"http://www.w3.org/2001/04/xmlenc#sha256"`, `"http://www.w3.org/2000/09/xmldsig#sha1"`),
+			wantErr:       `signature verification failed: unsupported digest method "http://www.w3.org/2000/09/xmldsig#sha1"`,
+		},
+		{
+			name:          "the assertion was changed after it was signed",
+			response:      happyResponse(),
+			signAssertion: true,
+			editSigned:    replace("user-id-123", "admin"),
+			wantErr:       "signature verification failed: element Assertion: Signature could not be verified",
+		},
+		{
+			name:         "the response was changed after it was signed",
+			response:     happyResponse(),
+			signResponse: true,
+			editSigned:   replace("<saml:AttributeValue>admins</saml:AttributeValue>", "<saml:AttributeValue>cluster-admins</saml:AttributeValue>"),
+			wantErr:      "signature verification failed: element Response: Signature could not be verified",
+		},
+		{
+			name:          "the signature refers to another element",
+			response:      happyResponse(),
+			signAssertion: true,
+			editSigned:    replace(`URI="#_test-assertion-id"`, `URI="#_test-response-id"`),
+			wantErr:       "signature verification failed: element Assertion: Missing signature referencing the top-level element",
+		},
+		{
+			name:          "an unsigned assertion is added next to the signed assertion",
+			response:      happyResponse(),
+			signAssertion: true,
+			editSigned: replace("</samlp:Response>",
+				`<saml:Assertion ID="_evil" Version="2.0"><saml:Issuer>https://idp.example.com/metadata</saml:Issuer></saml:Assertion></samlp:Response>`),
+			wantErr: "response must have exactly one assertion",
+		},
+		{
+			name:          "an encrypted assertion",
+			response:      happyResponse(replace("</samlp:Response>", `<saml:EncryptedAssertion/></samlp:Response>`)),
+			signAssertion: true,
+			wantErr:       "encrypted assertions are not supported",
+		},
+		{
+			name:     "not a response",
+			response: `<samlp:LogoutRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol"/>`,
+			wantErr:  "expected a Response, found LogoutRequest",
+		},
+		{
+			name:          "the response is for another request",
+			response:      happyResponse(replace(`Destination="https://issuer.example.com/some/path/saml/acs" InResponseTo="_test-request-id"`, `Destination="https://issuer.example.com/some/path/saml/acs" InResponseTo="_other"`)),
+			signAssertion: true,
+			wantErr:       "response is not for the authentication request of this login",
+		},
+		{
+			name:          "an unsolicited response",
+			response:      happyResponse(replace(` InResponseTo="_test-request-id">`, `>`)),
+			signAssertion: true,
+			wantErr:       "response is not for the authentication request of this login",
+		},
+		{
+			name:          "the response is for another destination",
+			response:      happyResponse(replace(`Destination="https://issuer.example.com/some/path/saml/acs"`, `Destination="https://other.example.com/saml/acs"`)),
+			signAssertion: true,
+			wantErr:       `response destination "https://other.example.com/saml/acs" does not match the assertion consumer service "https://issuer.example.com/some/path/saml/acs"`,
+		},
+		{
+			name:          "the response is from another issuer",
+			response:      happyResponse(replace(`<saml:Issuer>https://idp.example.com/metadata</saml:Issuer>`, `<saml:Issuer>https://other.example.com</saml:Issuer>`)),
+			signAssertion: true,
+			wantErr:       `response issuer "https://other.example.com" does not match the entity ID "https://idp.example.com/metadata"`,
+		},
+		{
+			name:          "the login failed",
+			response:      happyResponse(replace(`<samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/>`, `<samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Responder"><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:AuthnFailed"/></samlp:StatusCode>`)),
+			signAssertion: true,
+			wantErr:       `login failed with status "urn:oasis:names:tc:SAML:2.0:status:Responder" ("urn:oasis:names:tc:SAML:2.0:status:AuthnFailed")`,
+		},
+		{
+			name:          "the assertion is from another issuer",
+			response:      happyResponse(replace("<saml:Issuer>https://idp.example.com/metadata</saml:Issuer>\n    <saml:Subject>", "<saml:Issuer>https://other.example.com</saml:Issuer>\n    <saml:Subject>")),
+			signAssertion: true,
+			wantErr:       `assertion issuer does not match the entity ID "https://idp.example.com/metadata"`,
+		},
+		{
+			name:          "the assertion is for another audience",
+			response:      happyResponse(replace(`<saml:Audience>https://issuer.example.com/some/path</saml:Audience>`, `<saml:Audience>https://other.example.com</saml:Audience>`)),
+			signAssertion: true,
+			wantErr:       `assertion audience restriction does not include "https://issuer.example.com/some/path"`,
+		},
+		{
+			name:          "the assertion has expired",
+			response:      happyResponse(),
+			signAssertion: true,
+			now:           time.Date(2021, 6, 1, 12, 8, 0, 0, time.UTC),
+			wantErr:       "bearer subject confirmation: expired at 2021-06-01T12:05:00Z",
+		},
+		{
+			name:          "the assertion is not valid yet",
+			response:      happyResponse(),
+			signAssertion: true,
+			now:           time.Date(2021, 6, 1, 11, 55, 0, 0, time.UTC),
+			wantErr:       "assertion conditions: not valid before 2021-06-01T11:59:00Z",
+		},
+		{
+			name:          "the subject confirmation is for another recipient",
+			response:      happyResponse(replace(`Recipient="https://issuer.example.com/some/path/saml/acs"`, `Recipient="https://other.example.com/saml/acs"`)),
+			signAssertion: true,
+			wantErr:       `bearer subject confirmation recipient "https://other.example.com/saml/acs" does not match the assertion consumer service "https://issuer.example.com/some/path/saml/acs"`,
+		},
+		{
+			name:          "the subject confirmation is for another request",
+			response:      happyResponse(replace(`<saml:SubjectConfirmationData InResponseTo="_test-request-id"`, `<saml:SubjectConfirmationData InResponseTo="_other"`)),
+			signAssertion: true,
+			wantErr:       "bearer subject confirmation is not for the authentication request of this login",
+		},
+		{
+			name:          "no bearer subject confirmation",
+			response:      happyResponse(replace(`urn:oasis:names:tc:SAML:2.0:cm:bearer`, `urn:oasis:names:tc:SAML:2.0:cm:holder-of-key`)),
+			signAssertion: true,
+			wantErr:       "assertion subject has no bearer subject confirmation",
+		},
+		{
+			name:          "an empty NameID",
+			response:      happyResponse(replace(`>user-id-123<`, `><`)),
+			signAssertion: true,
+			wantErr:       "assertion subject has an empty NameID",
+		},
+		{
+			name:              "the username attribute is missing",
+			response:          happyResponse(),
+			signAssertion:     true,
+			usernameAttribute: "username",
+			wantErr:           `assertion must have exactly one non-empty value of the username attribute "username"`,
+		},
+		{
+			name:              "the username attribute has more than one value",
+			response:          happyResponse(),
+			signAssertion:     true,
+			usernameAttribute: "groups",
+			wantErr:           `assertion must have exactly one non-empty value of the username attribute "groups"`,
+		},
+		{
+			name:     "an empty response",
+			response: "",
+			wantErr:  "could not parse XML: no root element",
+		},
+		{
+			name:     "a document type declaration",
+			response: `<!DOCTYPE samlp:Response [<!ENTITY id "user-id-123">]>` + happyResponse(),
+			wantErr:  "could not parse XML: directives are not allowed",
+		},
+		{
+			name:     "an incomplete response",
+			response: strings.TrimSuffix(happyResponse(), "</samlp:Response>"),
+			wantErr:  "could not parse XML: XML syntax error on line 21: unexpected EOF",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			signingKey, signatureMethod := rsaKey, tt.signatureMethod
+			if tt.signingKey != nil {
+				signingKey = tt.signingKey
+			}
+			if signatureMethod == "" {
+				signatureMethod = dsig.RSASHA256SignatureMethod
+			}
+			response := tt.response
+			if tt.signAssertion {
+				response = signElement(t, response, testAssertionID, signingKey, signatureMethod, tt.withoutKeyInfo)
+			}
+			if tt.signResponse {
+				response = signElement(t, response, testResponseID, signingKey, signatureMethod, tt.withoutKeyInfo)
+			}
+			if tt.editSigned != nil {
+				response = tt.editSigned(response)
+			}
+
+			now := tt.now
+			if now.IsZero() {
+				now = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+			}
+			p := &ProviderConfig{
+				Name:                "test-name",
+				EntityID:            testEntityID,
+				SigningCertificates: []*x509.Certificate{ecdsaKey.cert, rsaKey.cert, expiredKey.cert},
+				UsernameAttribute:   tt.usernameAttribute,
+				GroupsAttribute:     tt.groupsAttribute,
+				Clock:               func() time.Time { return now },
+			}
+			// Responses are posted as base64, which may be wrapped over several lines.
+			encoded := base64.StdEncoding.EncodeToString([]byte(response))
+			if len(encoded) > 76 {
+				encoded = encoded[:76] + "\r\n" + encoded[76:]
+			}
+
+			got, err := p.ValidateResponse(context.Background(), encoded, testRequestID, testSPEntityID, testACSURL)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				require.Nil(t, got)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantResponse, got)
+		})
+	}
+}
+
+type testSigningKey struct {
+	signer crypto.Signer
+	cert   *x509.Certificate
+}
+
+// signElement signs the element with the given ID with an enveloped signature, which is inserted after its issuer.
+func signElement(t *testing.T, doc string, id string, key *testSigningKey, signatureMethod string, withoutKeyInfo bool) string {
+	t.Helper()
+
+	root, err := parseXML([]byte(doc))
+	require.NoError(t, err)
+	e := findElementByID(root, id)
+	require.NotNil(t, e)
+	nsContext, err := etreeutils.NSBuildParentContext(e)
+	require.NoError(t, err)
+	detached, err := etreeutils.NSDetatch(nsContext, e)
+	require.NoError(t, err)
+
+	signingContext, err := dsig.NewSigningContext(key.signer, [][]byte{key.cert.Raw})
+	require.NoError(t, err)
+	signingContext.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	require.NoError(t, signingContext.SetSignatureMethod(signatureMethod))
+	signature, err := signingContext.ConstructSignature(detached, true)
+	require.NoError(t, err)
+	if withoutKeyInfo {
+		signature.RemoveChild(signature.SelectElement(dsig.KeyInfoTag))
+	}
+
+	issuer := childElement(e, assertionNamespace, "Issuer")
+	require.NotNil(t, issuer)
+	e.InsertChildAt(issuer.Index()+1, signature)
+
+	signed := etree.NewDocument()
+	signed.SetRoot(root)
+	s, err := signed.WriteToString()
+	require.NoError(t, err)
+	return s
+}
+
+func findElementByID(e *etree.Element, id string) *etree.Element {
+	if elementID, _ := attr(e, "ID"); elementID == id {
+		return e
+	}
+	for _, child := range e.ChildElements() {
+		if found := findElementByID(child, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func newTestSigningKey(t *testing.T, generateKey func() (crypto.Signer, error), notAfter time.Time) *testSigningKey {
+	t.Helper()
+
+	key, err := generateKey()
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-signing-key"},
+		NotBefore:    time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testSigningKey{signer: key, cert: cert}
+}
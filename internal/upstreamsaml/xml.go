@@ -0,0 +1,106 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamsaml
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+// parseXML parses a document and returns its root element. The document is first checked with the strict decoder
+// of encoding/xml, since etree does not check that the document is well-formed. Documents with a DTD are rejected,
+// since no legitimate SAML message has one.
+func parseXML(data []byte) (*etree.Element, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	depth, roots := 0, 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse XML: %w", err)
+		}
+		switch token.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				roots++
+			}
+			if roots > 1 {
+				return nil, fmt.Errorf("could not parse XML: more than one root element")
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.Directive:
+			return nil, fmt.Errorf("could not parse XML: directives are not allowed")
+		}
+	}
+	if roots == 0 {
+		return nil, fmt.Errorf("could not parse XML: no root element")
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return nil, fmt.Errorf("could not parse XML: %w", err)
+	}
+	return doc.Root(), nil
+}
+
+func is(e *etree.Element, namespace, local string) bool {
+	return e.Tag == local && e.NamespaceURI() == namespace
+}
+
+// attr returns the value of the unqualified attribute with the given name.
+func attr(e *etree.Element, local string) (string, bool) {
+	for _, a := range e.Attr {
+		if a.Space == "" && a.Key == local {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// childElements returns the child elements with the given namespace and name.
+func childElements(e *etree.Element, namespace, local string) []*etree.Element {
+	var found []*etree.Element
+	for _, child := range e.ChildElements() {
+		if is(child, namespace, local) {
+			found = append(found, child)
+		}
+	}
+	return found
+}
+
+// childElement returns the only child element with the given namespace and name, or nil when there is not exactly one.
+func childElement(e *etree.Element, namespace, local string) *etree.Element {
+	found := childElements(e, namespace, local)
+	if len(found) != 1 {
+		return nil
+	}
+	return found[0]
+}
+
+// text returns the character data of the element, without that of its descendants. Unlike etree's Text, it does not
+// stop at the first comment, so that a comment cannot be used to truncate a value.
+func text(e *etree.Element) string {
+	var b strings.Builder
+	for _, c := range e.Child {
+		if data, ok := c.(*etree.CharData); ok {
+			b.WriteString(data.Data)
+		}
+	}
+	return b.String()
+}
+
+// decodeBase64 decodes base64 which may be wrapped over several lines, as is common in XML documents.
+func decodeBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
@@ -156,3 +156,14 @@ func (c *sessionCache) lookup(key oidcclient.SessionCacheKey) *sessionEntry {
 func (c *sessionCache) insert(entries ...sessionEntry) {
 	c.Sessions = append(c.Sessions, entries...)
 }
+
+// remove any cache entries with the given key.
+func (c *sessionCache) remove(key oidcclient.SessionCacheKey) {
+	kept := c.Sessions[:0]
+	for _, s := range c.Sessions {
+		if !reflect.DeepEqual(s.Key, key) {
+			kept = append(kept, s)
+		}
+	}
+	c.Sessions = kept
+}
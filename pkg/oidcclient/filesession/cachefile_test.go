@@ -268,3 +268,16 @@ func TestInsert(t *testing.T) {
 	c.insert(sessionEntry{})
 	require.Len(t, c.Sessions, 1)
 }
+
+func TestRemove(t *testing.T) {
+	t.Parallel()
+	key := oidcclient.SessionCacheKey{Issuer: "test-issuer"}
+	c := emptySessionCache()
+	c.insert(sessionEntry{Key: key}, sessionEntry{Key: oidcclient.SessionCacheKey{Issuer: "other-issuer"}}, sessionEntry{Key: key})
+	c.remove(key)
+	require.Len(t, c.Sessions, 1)
+	require.Equal(t, "other-issuer", c.Sessions[0].Key.Issuer)
+
+	c.remove(oidcclient.SessionCacheKey{Issuer: "not-found"})
+	require.Len(t, c.Sessions, 1)
+}
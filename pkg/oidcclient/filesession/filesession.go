@@ -113,6 +113,37 @@ func (c *Cache) PutToken(key oidcclient.SessionCacheKey, token *oidctypes.Token)
 	})
 }
 
+// DeleteToken removes the cached data for the given parameters, if there is any. It does not return an error but may
+// silently fail to update the session cache.
+func (c *Cache) DeleteToken(key oidcclient.SessionCacheKey) {
+	// If the cache file does not exist, there is nothing to delete.
+	if _, err := os.Stat(c.path); errors.Is(err, os.ErrNotExist) {
+		return
+	}
+
+	// Mutate the cache to remove the matching session entry.
+	c.withCache(func(cache *sessionCache) {
+		cache.remove(key)
+	})
+}
+
+// Keys returns the keys of all the valid sessions in the session cache. It may return an empty result if the session
+// cache could not be read.
+func (c *Cache) Keys() []oidcclient.SessionCacheKey {
+	// If the cache file does not exist, exit immediately with no error log
+	if _, err := os.Stat(c.path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	var result []oidcclient.SessionCacheKey
+	c.withCache(func(cache *sessionCache) {
+		for _, entry := range cache.Sessions {
+			result = append(result, entry.Key)
+		}
+	})
+	return result
+}
+
 // withCache is an internal helper which locks, reads the cache, processes/mutates it with the provided function, then
 // saves it back to the file.
 func (c *Cache) withCache(transact func(*sessionCache)) {
@@ -160,6 +160,43 @@ func TestGetToken(t *testing.T) {
 			},
 			wantErrors: []string{},
 		},
+		{
+			name: "valid file but cache miss for a different upstream identity provider",
+			makeTestFile: func(t *testing.T, tmp string) {
+				validCache := emptySessionCache()
+				validCache.insert(sessionEntry{
+					Key: oidcclient.SessionCacheKey{
+						Issuer:                       "test-issuer",
+						ClientID:                     "test-client-id",
+						Scopes:                       []string{"email", "offline_access", "openid", "profile"},
+						RedirectURI:                  "http://localhost:0/callback",
+						UpstreamIdentityProviderName: "some-ldap-idp",
+						UpstreamIdentityProviderType: "ldap",
+					},
+					CreationTimestamp: metav1.NewTime(now.Add(-2 * time.Hour)),
+					LastUsedTimestamp: metav1.NewTime(now.Add(-1 * time.Hour)),
+					Tokens: oidctypes.Token{
+						IDToken: &oidctypes.IDToken{
+							Token:  "test-id-token",
+							Expiry: metav1.NewTime(now.Add(1 * time.Hour)),
+						},
+						RefreshToken: &oidctypes.RefreshToken{
+							Token: "test-refresh-token",
+						},
+					},
+				})
+				require.NoError(t, validCache.writeTo(tmp))
+			},
+			key: oidcclient.SessionCacheKey{
+				Issuer:                       "test-issuer",
+				ClientID:                     "test-client-id",
+				Scopes:                       []string{"email", "offline_access", "openid", "profile"},
+				RedirectURI:                  "http://localhost:0/callback",
+				UpstreamIdentityProviderName: "some-oidc-idp",
+				UpstreamIdentityProviderType: "oidc",
+			},
+			wantErrors: []string{},
+		},
 		{
 			name: "valid file with cache hit",
 			makeTestFile: func(t *testing.T, tmp string) {
@@ -497,6 +534,90 @@ func TestPutToken(t *testing.T) {
 	}
 }
 
+func TestDeleteToken(t *testing.T) {
+	t.Parallel()
+	now := time.Now().Round(1 * time.Second)
+	key := oidcclient.SessionCacheKey{
+		Issuer:      "test-issuer",
+		ClientID:    "test-client-id",
+		Scopes:      []string{"email", "offline_access", "openid", "profile"},
+		RedirectURI: "http://localhost:0/callback",
+	}
+	otherKey := oidcclient.SessionCacheKey{
+		Issuer:      "other-issuer",
+		ClientID:    "test-client-id",
+		Scopes:      []string{"email", "offline_access", "openid", "profile"},
+		RedirectURI: "http://localhost:0/callback",
+	}
+	writeSessions := func(t *testing.T, tmp string, keys ...oidcclient.SessionCacheKey) {
+		validCache := emptySessionCache()
+		for _, k := range keys {
+			validCache.insert(sessionEntry{
+				Key:               k,
+				CreationTimestamp: metav1.NewTime(now.Add(-2 * time.Hour)),
+				LastUsedTimestamp: metav1.NewTime(now.Add(-1 * time.Hour)),
+				Tokens: oidctypes.Token{
+					RefreshToken: &oidctypes.RefreshToken{Token: "test-refresh-token"},
+				},
+			})
+		}
+		require.NoError(t, validCache.writeTo(tmp))
+	}
+	tests := []struct {
+		name         string
+		makeTestFile func(t *testing.T, tmp string)
+		wantErrors   []string
+		wantKeys     []oidcclient.SessionCacheKey
+	}{
+		{
+			name:       "file does not exist",
+			wantErrors: []string{},
+		},
+		{
+			name: "invalid file",
+			makeTestFile: func(t *testing.T, tmp string) {
+				require.NoError(t, ioutil.WriteFile(tmp, []byte("invalid yaml"), 0600))
+			},
+			wantErrors: []string{
+				"failed to read cache, resetting: invalid session file: error unmarshaling JSON: while decoding JSON: json: cannot unmarshal string into Go value of type filesession.sessionCache",
+			},
+		},
+		{
+			name: "cache miss",
+			makeTestFile: func(t *testing.T, tmp string) {
+				writeSessions(t, tmp, otherKey)
+			},
+			wantErrors: []string{},
+			wantKeys:   []oidcclient.SessionCacheKey{otherKey},
+		},
+		{
+			name: "cache hit",
+			makeTestFile: func(t *testing.T, tmp string) {
+				writeSessions(t, tmp, key, otherKey)
+			},
+			wantErrors: []string{},
+			wantKeys:   []oidcclient.SessionCacheKey{otherKey},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			tmp := testutil.TempDir(t) + "/sessions.yaml"
+			if tt.makeTestFile != nil {
+				tt.makeTestFile(t, tmp)
+			}
+
+			// Initialize a cache with a reporter that collects errors
+			errors := errorCollector{t: t}
+			c := New(tmp, errors.collect())
+			c.DeleteToken(key)
+			errors.require(tt.wantErrors, "TEMPFILE", tmp)
+			require.Equal(t, tt.wantKeys, c.Keys())
+		})
+	}
+}
+
 type errorCollector struct {
 	t   *testing.T
 	saw []error
@@ -67,6 +67,10 @@ const (
 
 	httpLocationHeaderName = "Location"
 
+	// For the device authorization grant from RFC8628.
+	deviceCodeGrantType          = "urn:ietf:params:oauth:grant-type:device_code" //nolint:gosec // this is not a credential
+	defaultDevicePollingInterval = 5 * time.Second
+
 	debugLogLevel = 4
 )
 
@@ -85,6 +89,7 @@ type handlerState struct {
 	upstreamIdentityProviderName string
 	upstreamIdentityProviderType string
 	cliToSendCredentials         bool
+	useDeviceAuthorizationGrant  bool
 
 	requestedAudience string
 
@@ -102,6 +107,12 @@ type handlerState struct {
 	nonce        nonce.Nonce
 	pkce         pkce.Code
 
+	// The RFC8628 device authorization endpoint, if the issuer advertised one in its discovery document.
+	deviceAuthorizationEndpoint string
+
+	// The RFC7009 token revocation endpoint, if the issuer advertised one in its discovery document.
+	revocationEndpoint string
+
 	// External calls for things.
 	generateState   func() (state.State, error)
 	generatePKCE    func() (pkce.Code, error)
@@ -114,6 +125,7 @@ type handlerState struct {
 	validateIDToken func(ctx context.Context, provider *oidc.Provider, audience string, token string) (*oidc.IDToken, error)
 	promptForValue  func(ctx context.Context, promptLabel string) (string, error)
 	promptForSecret func(promptLabel string) (string, error)
+	waitForPoll     func(ctx context.Context, interval time.Duration) error
 
 	callbacks chan callbackResult
 }
@@ -203,6 +215,11 @@ type SessionCacheKey struct {
 	ClientID    string   `json:"clientID"`
 	Scopes      []string `json:"scopes"`
 	RedirectURI string   `json:"redirect_uri"`
+
+	// UpstreamIdentityProviderName and UpstreamIdentityProviderType are set when the login chose an upstream identity
+	// provider of the Supervisor, so that the sessions of the different upstreams of an issuer are kept apart.
+	UpstreamIdentityProviderName string `json:"upstreamIdentityProviderName,omitempty"`
+	UpstreamIdentityProviderType string `json:"upstreamIdentityProviderType,omitempty"`
 }
 
 type SessionCache interface {
@@ -210,6 +227,11 @@ type SessionCache interface {
 	PutToken(SessionCacheKey, *oidctypes.Token)
 }
 
+// sessionCacheDeleter is optionally implemented by a SessionCache which can remove a cached session, for use by Logout().
+type sessionCacheDeleter interface {
+	DeleteToken(SessionCacheKey)
+}
+
 // WithSessionCache sets the session cache backend for storing and retrieving previously-issued ID tokens and refresh tokens.
 func WithSessionCache(cache SessionCache) Option {
 	return func(h *handlerState) error {
@@ -247,6 +269,18 @@ func WithCLISendingCredentials() Option {
 	}
 }
 
+// WithDeviceAuthorizationGrant causes the login flow to use the OAuth 2.0 device authorization grant from RFC8628
+// instead of the authorization code flow. The user is asked to visit a URL and enter a short code using a web browser
+// on any device, while the CLI polls the issuer's token endpoint until the login is finished. This is useful when the
+// CLI is running on a host which has no web browser and cannot receive a localhost callback, such as over SSH.
+// The issuer must advertise a device_authorization_endpoint in its OIDC discovery document.
+func WithDeviceAuthorizationGrant() Option {
+	return func(h *handlerState) error {
+		h.useDeviceAuthorizationGrant = true
+		return nil
+	}
+}
+
 // WithUpstreamIdentityProvider causes the specified name and type to be sent as custom query parameters to the
 // issuer's authorize endpoint. This is only intended to be used when the issuer is a Pinniped Supervisor, in which
 // case it provides a mechanism to choose among several upstream identity providers.
@@ -293,6 +327,7 @@ func Login(issuer string, clientID string, opts ...Option) (*oidctypes.Token, er
 		},
 		promptForValue:  promptForValue,
 		promptForSecret: promptForSecret,
+		waitForPoll:     waitForPoll,
 	}
 	for _, opt := range opts {
 		if err := opt(&h); err != nil {
@@ -347,13 +382,7 @@ func Login(issuer string, clientID string, opts ...Option) (*oidctypes.Token, er
 
 func (h *handlerState) baseLogin() (*oidctypes.Token, error) {
 	// Check the cache for a previous session issued with the same parameters.
-	sort.Strings(h.scopes)
-	cacheKey := SessionCacheKey{
-		Issuer:      h.issuer,
-		ClientID:    h.clientID,
-		Scopes:      h.scopes,
-		RedirectURI: (&url.URL{Scheme: "http", Host: h.listenAddr, Path: h.callbackPath}).String(),
-	}
+	cacheKey := h.sessionCacheKey()
 
 	// If the ID token is still valid for a bit, return it immediately and skip the rest of the flow.
 	cached := h.cache.GetToken(cacheKey)
@@ -397,6 +426,9 @@ func (h *handlerState) baseLogin() (*oidctypes.Token, error) {
 	if h.cliToSendCredentials {
 		authFunc = h.cliBasedAuth
 	}
+	if h.useDeviceAuthorizationGrant {
+		authFunc = h.deviceAuthorizationGrantAuth
+	}
 
 	// Perform the authorize request and authcode exchange to get back OIDC tokens.
 	token, err := authFunc(&authorizeOptions)
@@ -409,6 +441,20 @@ func (h *handlerState) baseLogin() (*oidctypes.Token, error) {
 	return token, err
 }
 
+// sessionCacheKey returns the key of the session cache entry for the parameters of this login.
+func (h *handlerState) sessionCacheKey() SessionCacheKey {
+	sort.Strings(h.scopes)
+	return SessionCacheKey{
+		Issuer:      h.issuer,
+		ClientID:    h.clientID,
+		Scopes:      h.scopes,
+		RedirectURI: (&url.URL{Scheme: "http", Host: h.listenAddr, Path: h.callbackPath}).String(),
+
+		UpstreamIdentityProviderName: h.upstreamIdentityProviderName,
+		UpstreamIdentityProviderType: h.upstreamIdentityProviderType,
+	}
+}
+
 // Make a direct call to the authorize endpoint, including the user's username and password on custom http headers,
 // and parse the authcode from the response. Exchange the authcode for tokens. Return the tokens or an error.
 func (h *handlerState) cliBasedAuth(authorizeOptions *[]oauth2.AuthCodeOption) (*oidctypes.Token, error) {
@@ -532,6 +578,152 @@ func (h *handlerState) getUsernameAndPassword() (string, string, error) {
 	return username, password, nil
 }
 
+// Perform the device authorization grant from RFC8628. Ask the issuer for a device code and a user code, tell the user
+// where to enter the user code, and then poll the token endpoint until the user has finished logging in.
+// The authorizeOptions are ignored, since there is no authorization request made by the CLI in this flow.
+func (h *handlerState) deviceAuthorizationGrantAuth(_ *[]oauth2.AuthCodeOption) (*oidctypes.Token, error) {
+	if h.deviceAuthorizationEndpoint == "" {
+		return nil, fmt.Errorf("issuer %q does not support the device authorization grant", h.issuer)
+	}
+
+	// Start the device authorization request.
+	params := url.Values{
+		"client_id": []string{h.clientID},
+		"scope":     []string{strings.Join(h.scopes, " ")},
+	}
+	if h.upstreamIdentityProviderName != "" {
+		params.Set(supervisorAuthorizeUpstreamNameParam, h.upstreamIdentityProviderName)
+		params.Set(supervisorAuthorizeUpstreamTypeParam, h.upstreamIdentityProviderType)
+	}
+	var deviceAuthorization struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int64  `json:"expires_in"`
+		Interval                int64  `json:"interval"`
+	}
+	statusCode, errorCode, err := h.postForm(h.deviceAuthorizationEndpoint, params, &deviceAuthorization)
+	if err != nil {
+		return nil, fmt.Errorf("device authorization request failed: %w", err)
+	}
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("device authorization request failed with status %d and error %q", statusCode, errorCode)
+	}
+	if deviceAuthorization.DeviceCode == "" || deviceAuthorization.UserCode == "" || deviceAuthorization.VerificationURI == "" {
+		return nil, errors.New("device authorization response is missing a required parameter")
+	}
+
+	// Tell the user how to finish logging in, and open a browser too in case there is one available.
+	_, _ = fmt.Fprintf(os.Stderr, "Log in by visiting this link:\n\n    %s\n\nand entering the code: %s\n\n",
+		deviceAuthorization.VerificationURI, deviceAuthorization.UserCode)
+	if deviceAuthorization.VerificationURIComplete != "" {
+		if err := h.openURL(deviceAuthorization.VerificationURIComplete); err != nil {
+			h.logger.V(debugLogLevel).Error(err, "could not open browser")
+		}
+	}
+
+	ctx := h.ctx
+	if deviceAuthorization.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(deviceAuthorization.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+
+	interval := defaultDevicePollingInterval
+	if deviceAuthorization.Interval > 0 {
+		interval = time.Duration(deviceAuthorization.Interval) * time.Second
+	}
+
+	// Poll the token endpoint until the user has approved or denied the request, as described in RFC8628 section 3.4.
+	tokenParams := url.Values{
+		"client_id":   []string{h.clientID},
+		"grant_type":  []string{deviceCodeGrantType},
+		"device_code": []string{deviceAuthorization.DeviceCode},
+	}
+	for {
+		if err := h.waitForPoll(ctx, interval); err != nil {
+			return nil, fmt.Errorf("timed out waiting for device authorization: %w", err)
+		}
+
+		var tokenResponse struct {
+			AccessToken  string `json:"access_token"`
+			TokenType    string `json:"token_type"`
+			RefreshToken string `json:"refresh_token"`
+			ExpiresIn    int64  `json:"expires_in"`
+			IDToken      string `json:"id_token"`
+		}
+		statusCode, errorCode, err := h.postForm(h.oauth2Config.Endpoint.TokenURL, tokenParams, &tokenResponse)
+		if err != nil {
+			return nil, fmt.Errorf("device access token request failed: %w", err)
+		}
+
+		switch {
+		case statusCode == http.StatusOK:
+			h.logger.V(debugLogLevel).Info("Pinniped: Device authorization was approved.")
+			tok := (&oauth2.Token{
+				AccessToken:  tokenResponse.AccessToken,
+				TokenType:    tokenResponse.TokenType,
+				RefreshToken: tokenResponse.RefreshToken,
+				Expiry:       time.Now().Add(time.Duration(tokenResponse.ExpiresIn) * time.Second),
+			}).WithExtra(map[string]interface{}{"id_token": tokenResponse.IDToken})
+			// The ID token in this flow does not carry a nonce, since the CLI never made an authorization request.
+			return h.getProvider(h.oauth2Config, h.provider, h.httpClient).ValidateToken(h.ctx, tok, "")
+		case errorCode == "authorization_pending":
+			continue
+		case errorCode == "slow_down":
+			interval += defaultDevicePollingInterval
+			continue
+		default:
+			return nil, fmt.Errorf("device authorization failed with status %d and error %q", statusCode, errorCode)
+		}
+	}
+}
+
+// postForm makes an HTTP POST request with form parameters to the issuer. It decodes a successful JSON response into
+// successBody, unless successBody is nil, and returns the status code, along with the OAuth error code of an
+// unsuccessful response.
+func (h *handlerState) postForm(endpoint string, params url.Values, successBody interface{}) (int, string, error) {
+	req, err := http.NewRequestWithContext(h.ctx, http.MethodPost, endpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return 0, "", fmt.Errorf("could not build request: %w", err)
+	}
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusOK {
+		if successBody == nil {
+			return resp.StatusCode, "", nil
+		}
+		if err := json.NewDecoder(resp.Body).Decode(successBody); err != nil {
+			return 0, "", fmt.Errorf("failed to decode response: %w", err)
+		}
+		return resp.StatusCode, "", nil
+	}
+
+	var errorBody struct {
+		Error string `json:"error"`
+	}
+	// The error code is informational, so ignore errors when the response is not a JSON error response.
+	_ = json.NewDecoder(resp.Body).Decode(&errorBody)
+	return resp.StatusCode, errorBody.Error, nil
+}
+
+// waitForPoll sleeps for the polling interval, or until the context is done.
+func waitForPoll(ctx context.Context, interval time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(interval):
+		return nil
+	}
+}
+
 // Open a web browser, or ask the user to open a web browser, to visit the authorize endpoint.
 // Create a localhost callback listener which exchanges the authcode for tokens. Return the tokens or an error.
 func (h *handlerState) webBrowserBasedAuth(authorizeOptions *[]oauth2.AuthCodeOption) (*oidctypes.Token, error) {
@@ -716,12 +908,16 @@ func (h *handlerState) initOIDCDiscovery() error {
 
 	// Use response_mode=form_post if the provider supports it.
 	var discoveryClaims struct {
-		ResponseModesSupported []string `json:"response_modes_supported"`
+		ResponseModesSupported      []string `json:"response_modes_supported"`
+		DeviceAuthorizationEndpoint string   `json:"device_authorization_endpoint"`
+		RevocationEndpoint          string   `json:"revocation_endpoint"`
 	}
 	if err := h.provider.Claims(&discoveryClaims); err != nil {
 		return fmt.Errorf("could not decode response_modes_supported in OIDC discovery from %q: %w", h.issuer, err)
 	}
 	h.useFormPost = stringSliceContains(discoveryClaims.ResponseModesSupported, "form_post")
+	h.deviceAuthorizationEndpoint = discoveryClaims.DeviceAuthorizationEndpoint
+	h.revocationEndpoint = discoveryClaims.RevocationEndpoint
 	return nil
 }
 
@@ -48,6 +48,7 @@ type mockSessionCache struct {
 	sawGetKeys      []SessionCacheKey
 	sawPutKeys      []SessionCacheKey
 	sawPutTokens    []*oidctypes.Token
+	sawDeleteKeys   []SessionCacheKey
 }
 
 func (m *mockSessionCache) GetToken(key SessionCacheKey) *oidctypes.Token {
@@ -62,6 +63,11 @@ func (m *mockSessionCache) PutToken(key SessionCacheKey, token *oidctypes.Token)
 	m.sawPutTokens = append(m.sawPutTokens, token)
 }
 
+func (m *mockSessionCache) DeleteToken(key SessionCacheKey) {
+	m.t.Logf("saw mock session cache DeleteToken() with client ID %s", key.ClientID)
+	m.sawDeleteKeys = append(m.sawDeleteKeys, key)
+}
+
 func TestLogin(t *testing.T) { // nolint:gocyclo
 	time1 := time.Date(2035, 10, 12, 13, 14, 15, 16, time.UTC)
 	time1Unix := int64(2075807775)
@@ -259,6 +265,9 @@ func TestLogin(t *testing.T) { // nolint:gocyclo
 			ClientID:    "test-client-id",
 			Scopes:      []string{"test-scope"},
 			RedirectURI: "http://localhost:0/callback",
+
+			UpstreamIdentityProviderName: "some-upstream-name",
+			UpstreamIdentityProviderType: "ldap",
 		}
 		t.Cleanup(func() {
 			require.Equal(t, []SessionCacheKey{cacheKey}, cache.sawGetKeys)
@@ -753,6 +762,9 @@ func TestLogin(t *testing.T) { // nolint:gocyclo
 						ClientID:    "test-client-id",
 						Scopes:      []string{"test-scope"},
 						RedirectURI: "http://localhost:0/callback",
+
+						UpstreamIdentityProviderName: "some-upstream-name",
+						UpstreamIdentityProviderType: "oidc",
 					}
 					t.Cleanup(func() {
 						require.Equal(t, []SessionCacheKey{cacheKey}, cache.sawGetKeys)
@@ -1029,6 +1041,9 @@ func TestLogin(t *testing.T) { // nolint:gocyclo
 						ClientID:    "test-client-id",
 						Scopes:      []string{"test-scope"},
 						RedirectURI: "http://localhost:0/callback",
+
+						UpstreamIdentityProviderName: "some-upstream-name",
+						UpstreamIdentityProviderType: "ldap",
 					}
 					t.Cleanup(func() {
 						require.Equal(t, []SessionCacheKey{cacheKey}, cache.sawGetKeys)
@@ -1136,6 +1151,9 @@ func TestLogin(t *testing.T) { // nolint:gocyclo
 						ClientID:    "test-client-id",
 						Scopes:      []string{"test-scope"},
 						RedirectURI: "http://localhost:0/callback",
+
+						UpstreamIdentityProviderName: "some-upstream-name",
+						UpstreamIdentityProviderType: "ldap",
 					}
 					t.Cleanup(func() {
 						require.Equal(t, []SessionCacheKey{cacheKey}, cache.sawGetKeys)
@@ -1597,6 +1615,162 @@ func TestLogin(t *testing.T) { // nolint:gocyclo
 	}
 }
 
+func TestLoginWithDeviceAuthorizationGrant(t *testing.T) {
+	testToken := oidctypes.Token{
+		AccessToken:  &oidctypes.AccessToken{Token: "test-access-token", Expiry: metav1.NewTime(time.Now().Add(2 * time.Minute))},
+		RefreshToken: &oidctypes.RefreshToken{Token: "test-refresh-token"},
+		IDToken:      &oidctypes.IDToken{Token: "test-id-token", Expiry: metav1.NewTime(time.Now().Add(2 * time.Minute))},
+	}
+
+	tests := []struct {
+		name                     string
+		advertiseDeviceEndpoint  bool
+		deviceAuthorizationError string
+		tokenResponses           []string // the OAuth error codes to return from each poll, or "" to return tokens
+		wantPollIntervals        []time.Duration
+		wantOpenedURL            string
+		wantErr                  string
+		wantToken                *oidctypes.Token
+	}{
+		{
+			name:                    "issuer does not advertise a device authorization endpoint",
+			advertiseDeviceEndpoint: false,
+			wantErr:                 `issuer "ISSUER" does not support the device authorization grant`,
+		},
+		{
+			name:                     "device authorization request fails",
+			advertiseDeviceEndpoint:  true,
+			deviceAuthorizationError: "unauthorized_client",
+			wantErr:                  `device authorization request failed with status 400 and error "unauthorized_client"`,
+		},
+		{
+			name:                    "user approves after some polling",
+			advertiseDeviceEndpoint: true,
+			tokenResponses:          []string{"authorization_pending", "slow_down", "authorization_pending", ""},
+			wantPollIntervals:       []time.Duration{3 * time.Second, 3 * time.Second, 8 * time.Second, 8 * time.Second},
+			wantOpenedURL:           "https://example.com/device?user_code=WDJB-MJHT",
+			wantToken:               &testToken,
+		},
+		{
+			name:                    "user denies",
+			advertiseDeviceEndpoint: true,
+			tokenResponses:          []string{"authorization_pending", "access_denied"},
+			wantPollIntervals:       []time.Duration{3 * time.Second, 3 * time.Second},
+			wantOpenedURL:           "https://example.com/device?user_code=WDJB-MJHT",
+			wantErr:                 `device authorization failed with status 400 and error "access_denied"`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var tokenRequests int
+			mux := http.NewServeMux()
+			server := httptest.NewServer(mux)
+			t.Cleanup(server.Close)
+			mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+				discovery := map[string]interface{}{
+					"issuer":                 server.URL,
+					"authorization_endpoint": server.URL + "/authorize",
+					"token_endpoint":         server.URL + "/token",
+					"jwks_uri":               server.URL + "/keys",
+				}
+				if tt.advertiseDeviceEndpoint {
+					discovery["device_authorization_endpoint"] = server.URL + "/device_authorization"
+				}
+				w.Header().Set("content-type", "application/json")
+				require.NoError(t, json.NewEncoder(w).Encode(discovery))
+			})
+			mux.HandleFunc("/device_authorization", func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodPost, r.Method)
+				require.NoError(t, r.ParseForm())
+				require.Equal(t, url.Values{
+					"client_id":         {"test-client-id"},
+					"scope":             {"test-scope"},
+					"pinniped_idp_name": {"some-upstream-name"},
+					"pinniped_idp_type": {"oidc"},
+				}, r.PostForm)
+				w.Header().Set("content-type", "application/json")
+				if tt.deviceAuthorizationError != "" {
+					w.WriteHeader(http.StatusBadRequest)
+					_, _ = fmt.Fprintf(w, `{"error":%q}`, tt.deviceAuthorizationError)
+					return
+				}
+				_, _ = w.Write([]byte(`{
+					"device_code": "test-device-code",
+					"user_code": "WDJB-MJHT",
+					"verification_uri": "https://example.com/device",
+					"verification_uri_complete": "https://example.com/device?user_code=WDJB-MJHT",
+					"expires_in": 600,
+					"interval": 3
+				}`))
+			})
+			mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodPost, r.Method)
+				require.NoError(t, r.ParseForm())
+				require.Equal(t, url.Values{
+					"client_id":   {"test-client-id"},
+					"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+					"device_code": {"test-device-code"},
+				}, r.PostForm)
+				require.Less(t, tokenRequests, len(tt.tokenResponses), "too many token requests")
+				errorCode := tt.tokenResponses[tokenRequests]
+				tokenRequests++
+				w.Header().Set("content-type", "application/json")
+				if errorCode != "" {
+					w.WriteHeader(http.StatusBadRequest)
+					_, _ = fmt.Fprintf(w, `{"error":%q}`, errorCode)
+					return
+				}
+				_, _ = w.Write([]byte(`{
+					"access_token": "test-access-token",
+					"token_type": "bearer",
+					"refresh_token": "test-refresh-token",
+					"expires_in": 120,
+					"id_token": "test-id-token"
+				}`))
+			})
+
+			var sawPollIntervals []time.Duration
+			var sawOpenedURL string
+			tok, err := Login(server.URL, "test-client-id",
+				WithContext(context.Background()),
+				WithScopes([]string{"test-scope"}),
+				WithDeviceAuthorizationGrant(),
+				WithUpstreamIdentityProvider("some-upstream-name", "oidc"),
+				func(h *handlerState) error {
+					h.openURL = func(url string) error {
+						sawOpenedURL = url
+						return nil
+					}
+					h.waitForPoll = func(_ context.Context, interval time.Duration) error {
+						sawPollIntervals = append(sawPollIntervals, interval)
+						return nil
+					}
+					h.getProvider = func(_ *oauth2.Config, _ *oidc.Provider, _ *http.Client) provider.UpstreamOIDCIdentityProviderI {
+						mock := mockUpstream(t)
+						mock.EXPECT().
+							ValidateToken(gomock.Any(), HasAccessToken(testToken.AccessToken.Token), nonce.Nonce("")).
+							Return(&testToken, nil)
+						return mock
+					}
+					return nil
+				},
+			)
+
+			require.Equal(t, tt.wantPollIntervals, sawPollIntervals)
+			require.Equal(t, tt.wantOpenedURL, sawOpenedURL)
+			require.Equal(t, len(tt.tokenResponses), tokenRequests)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, strings.ReplaceAll(tt.wantErr, "ISSUER", server.URL))
+				require.Nil(t, tok)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantToken, tok)
+		})
+	}
+}
+
 func TestHandlePasteCallback(t *testing.T) {
 	const testRedirectURI = "http://127.0.0.1:12324/callback"
 
@@ -0,0 +1,115 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package oidcclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-logr/logr"
+)
+
+// Logout removes the session which Login() would have cached for the same issuer, client ID, and options from the
+// session cache. If the issuer advertises an RFC7009 revocation_endpoint in its OIDC discovery document, then the
+// cached refresh token is also revoked, which ends the session on the issuer too.
+//
+// Only the options which select the session cache entry (WithScopes, WithListenPort, and WithSessionCache) and the
+// options which configure requests to the issuer (WithContext, WithLogger, and WithClient) have any effect.
+func Logout(issuer string, clientID string, opts ...Option) error {
+	h, cancel, err := newLogoutHandlerState(issuer, clientID, opts)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	return h.logout(h.sessionCacheKey())
+}
+
+// LogoutSession is like Logout(), but it removes the session cache entry with the given key. This is useful for
+// ending every session in a session cache.
+func LogoutSession(key SessionCacheKey, opts ...Option) error {
+	h, cancel, err := newLogoutHandlerState(key.Issuer, key.ClientID, opts)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	return h.logout(key)
+}
+
+func newLogoutHandlerState(issuer string, clientID string, opts []Option) (*handlerState, context.CancelFunc, error) {
+	h := handlerState{
+		issuer:       issuer,
+		clientID:     clientID,
+		listenAddr:   "localhost:0",
+		scopes:       []string{oidc.ScopeOfflineAccess, oidc.ScopeOpenID, "email", "profile"},
+		cache:        &nopCache{},
+		callbackPath: "/callback",
+		ctx:          context.Background(),
+		logger:       logr.Discard(), // discard logs unless a logger is specified
+		httpClient:   http.DefaultClient,
+	}
+	for _, opt := range opts {
+		if err := opt(&h); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Copy the configured HTTP client to set a request timeout (the Go default client has no timeout configured).
+	httpClientWithTimeout := *h.httpClient
+	httpClientWithTimeout.Timeout = httpRequestTimeout
+	h.httpClient = &httpClientWithTimeout
+
+	// Logout involves no user interaction, so it should be about as fast as a few HTTPS requests.
+	ctx, cancel := context.WithTimeout(h.ctx, httpRequestTimeout)
+	h.ctx = oidc.ClientContext(ctx, h.httpClient)
+	return &h, cancel, nil
+}
+
+func (h *handlerState) logout(key SessionCacheKey) error {
+	cached := h.cache.GetToken(key)
+	if cached == nil {
+		h.logger.V(debugLogLevel).Info("Pinniped: No cached session found.", "issuer", key.Issuer)
+		return nil
+	}
+
+	// Always forget the session locally, even if it cannot be revoked on the issuer.
+	if deleter, ok := h.cache.(sessionCacheDeleter); ok {
+		deleter.DeleteToken(key)
+		h.logger.V(debugLogLevel).Info("Pinniped: Removed cached session.", "issuer", key.Issuer)
+	} else {
+		h.logger.V(debugLogLevel).Info("Pinniped: Session cache does not support removing sessions, skipping.", "issuer", key.Issuer)
+	}
+
+	if cached.RefreshToken == nil || cached.RefreshToken.Token == "" {
+		return nil
+	}
+	return h.revokeRefreshToken(cached.RefreshToken.Token)
+}
+
+// revokeRefreshToken revokes a refresh token using the RFC7009 revocation endpoint of the issuer, if it has one.
+func (h *handlerState) revokeRefreshToken(refreshToken string) error {
+	if err := h.initOIDCDiscovery(); err != nil {
+		return err
+	}
+	if h.revocationEndpoint == "" {
+		h.logger.V(debugLogLevel).Info("Pinniped: Issuer does not support token revocation, skipping.", "issuer", h.issuer)
+		return nil
+	}
+
+	h.logger.V(debugLogLevel).Info("Pinniped: Revoking cached refresh token.", "endpoint", h.revocationEndpoint)
+	statusCode, errorCode, err := h.postForm(h.revocationEndpoint, url.Values{
+		"client_id":       []string{h.clientID},
+		"token":           []string{refreshToken},
+		"token_type_hint": []string{"refresh_token"},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("token revocation request failed: %w", err)
+	}
+	if statusCode != http.StatusOK {
+		return fmt.Errorf("token revocation request failed with status %d and error %q", statusCode, errorCode)
+	}
+	return nil
+}
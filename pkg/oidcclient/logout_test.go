@@ -0,0 +1,196 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package oidcclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.pinniped.dev/pkg/oidcclient/oidctypes"
+)
+
+func TestLogout(t *testing.T) {
+	tests := []struct {
+		name                string
+		cachedToken         *oidctypes.Token
+		advertiseRevocation bool
+		revocationError     string
+		wantRevokedToken    string
+		wantDelete          bool
+		wantErr             string
+	}{
+		{
+			name: "no cached session",
+		},
+		{
+			name:                "cached session without a refresh token",
+			cachedToken:         &oidctypes.Token{IDToken: &oidctypes.IDToken{Token: "test-id-token"}},
+			advertiseRevocation: true,
+			wantDelete:          true,
+		},
+		{
+			name:                "issuer does not support revocation",
+			cachedToken:         &oidctypes.Token{RefreshToken: &oidctypes.RefreshToken{Token: "test-refresh-token"}},
+			advertiseRevocation: false,
+			wantDelete:          true,
+		},
+		{
+			name:                "refresh token is revoked",
+			cachedToken:         &oidctypes.Token{RefreshToken: &oidctypes.RefreshToken{Token: "test-refresh-token"}},
+			advertiseRevocation: true,
+			wantRevokedToken:    "test-refresh-token",
+			wantDelete:          true,
+		},
+		{
+			name:                "revocation fails",
+			cachedToken:         &oidctypes.Token{RefreshToken: &oidctypes.RefreshToken{Token: "test-refresh-token"}},
+			advertiseRevocation: true,
+			revocationError:     "unauthorized_client",
+			wantRevokedToken:    "test-refresh-token",
+			wantDelete:          true,
+			wantErr:             `token revocation request failed with status 400 and error "unauthorized_client"`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var sawRevokedToken string
+			mux := http.NewServeMux()
+			server := httptest.NewServer(mux)
+			t.Cleanup(server.Close)
+			mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+				discovery := map[string]interface{}{
+					"issuer":                 server.URL,
+					"authorization_endpoint": server.URL + "/authorize",
+					"token_endpoint":         server.URL + "/token",
+					"jwks_uri":               server.URL + "/keys",
+				}
+				if tt.advertiseRevocation {
+					discovery["revocation_endpoint"] = server.URL + "/revoke"
+				}
+				w.Header().Set("content-type", "application/json")
+				require.NoError(t, json.NewEncoder(w).Encode(discovery))
+			})
+			mux.HandleFunc("/revoke", func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodPost, r.Method)
+				require.NoError(t, r.ParseForm())
+				require.Equal(t, "test-client-id", r.PostForm.Get("client_id"))
+				require.Equal(t, "refresh_token", r.PostForm.Get("token_type_hint"))
+				sawRevokedToken = r.PostForm.Get("token")
+				if tt.revocationError != "" {
+					w.Header().Set("content-type", "application/json")
+					w.WriteHeader(http.StatusBadRequest)
+					_, _ = fmt.Fprintf(w, `{"error":%q}`, tt.revocationError)
+				}
+			})
+
+			cache := &mockSessionCache{t: t, getReturnsToken: tt.cachedToken}
+			err := Logout(server.URL, "test-client-id",
+				WithContext(context.Background()),
+				WithScopes([]string{"test-scope-2", "test-scope-1"}),
+				WithListenPort(1234),
+				WithSessionCache(cache),
+			)
+
+			wantKey := SessionCacheKey{
+				Issuer:      server.URL,
+				ClientID:    "test-client-id",
+				Scopes:      []string{"test-scope-1", "test-scope-2"},
+				RedirectURI: "http://localhost:1234/callback",
+			}
+			require.Equal(t, []SessionCacheKey{wantKey}, cache.sawGetKeys)
+			if tt.wantDelete {
+				require.Equal(t, []SessionCacheKey{wantKey}, cache.sawDeleteKeys)
+			} else {
+				require.Empty(t, cache.sawDeleteKeys)
+			}
+			require.Equal(t, tt.wantRevokedToken, sawRevokedToken)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestLogoutSession(t *testing.T) {
+	var sawRevokedToken string
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/json")
+		_, _ = fmt.Fprint(w, strings.ReplaceAll(`{
+			"issuer": "ISSUER",
+			"authorization_endpoint": "ISSUER/authorize",
+			"token_endpoint": "ISSUER/token",
+			"jwks_uri": "ISSUER/keys",
+			"revocation_endpoint": "ISSUER/revoke"
+		}`, "ISSUER", server.URL))
+	})
+	mux.HandleFunc("/revoke", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, url.Values{
+			"client_id":       {"some-other-client-id"},
+			"token":           {"test-refresh-token"},
+			"token_type_hint": {"refresh_token"},
+		}, r.PostForm)
+		sawRevokedToken = r.PostForm.Get("token")
+	})
+
+	key := SessionCacheKey{
+		Issuer:      server.URL,
+		ClientID:    "some-other-client-id",
+		Scopes:      []string{"openid"},
+		RedirectURI: "http://localhost:0/callback",
+	}
+	cache := &mockSessionCache{t: t, getReturnsToken: &oidctypes.Token{RefreshToken: &oidctypes.RefreshToken{Token: "test-refresh-token"}}}
+	require.NoError(t, LogoutSession(key, WithSessionCache(cache)))
+	require.Equal(t, []SessionCacheKey{key}, cache.sawGetKeys)
+	require.Equal(t, []SessionCacheKey{key}, cache.sawDeleteKeys)
+	require.Equal(t, "test-refresh-token", sawRevokedToken)
+}
+
+// getPutOnlySessionCache is a SessionCache which cannot remove cached sessions.
+type getPutOnlySessionCache struct {
+	token *oidctypes.Token
+}
+
+func (c *getPutOnlySessionCache) GetToken(SessionCacheKey) *oidctypes.Token  { return c.token }
+func (c *getPutOnlySessionCache) PutToken(SessionCacheKey, *oidctypes.Token) {}
+
+func TestLogoutWithSessionCacheWhichCannotDelete(t *testing.T) {
+	var sawRevokedToken string
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/json")
+		_, _ = fmt.Fprint(w, strings.ReplaceAll(`{
+			"issuer": "ISSUER",
+			"authorization_endpoint": "ISSUER/authorize",
+			"token_endpoint": "ISSUER/token",
+			"jwks_uri": "ISSUER/keys",
+			"revocation_endpoint": "ISSUER/revoke"
+		}`, "ISSUER", server.URL))
+	})
+	mux.HandleFunc("/revoke", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		sawRevokedToken = r.PostForm.Get("token")
+	})
+
+	// The session can still be revoked on the issuer, even though it cannot be removed from the cache.
+	cache := &getPutOnlySessionCache{token: &oidctypes.Token{RefreshToken: &oidctypes.RefreshToken{Token: "test-refresh-token"}}}
+	require.NoError(t, Logout(server.URL, "test-client-id", WithSessionCache(cache)))
+	require.Equal(t, "test-refresh-token", sawRevokedToken)
+}
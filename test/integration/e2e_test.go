@@ -126,7 +126,7 @@ func TestE2EFullIntegration(t *testing.T) {
 		})
 
 		// Create upstream OIDC provider and wait for it to become ready.
-		testlib.CreateTestOIDCIdentityProvider(t, idpv1alpha1.OIDCIdentityProviderSpec{
+		oidcIdentityProvider := testlib.CreateTestOIDCIdentityProvider(t, idpv1alpha1.OIDCIdentityProviderSpec{
 			Issuer: env.SupervisorUpstreamOIDC.Issuer,
 			TLS: &idpv1alpha1.TLSSpec{
 				CertificateAuthorityData: base64.StdEncoding.EncodeToString([]byte(env.SupervisorUpstreamOIDC.CABundle)),
@@ -263,6 +263,8 @@ func TestE2EFullIntegration(t *testing.T) {
 
 		requireUserCanUseKubectlWithoutAuthenticatingAgain(ctx, t, env,
 			downstream,
+			oidcIdentityProvider.Name,
+			"oidc",
 			kubeconfigPath,
 			sessionCachePath,
 			pinnipedExe,
@@ -291,7 +293,7 @@ func TestE2EFullIntegration(t *testing.T) {
 		})
 
 		// Create upstream OIDC provider and wait for it to become ready.
-		testlib.CreateTestOIDCIdentityProvider(t, idpv1alpha1.OIDCIdentityProviderSpec{
+		oidcIdentityProvider := testlib.CreateTestOIDCIdentityProvider(t, idpv1alpha1.OIDCIdentityProviderSpec{
 			Issuer: env.SupervisorUpstreamOIDC.Issuer,
 			TLS: &idpv1alpha1.TLSSpec{
 				CertificateAuthorityData: base64.StdEncoding.EncodeToString([]byte(env.SupervisorUpstreamOIDC.CABundle)),
@@ -374,6 +376,8 @@ func TestE2EFullIntegration(t *testing.T) {
 
 		requireUserCanUseKubectlWithoutAuthenticatingAgain(ctx, t, env,
 			downstream,
+			oidcIdentityProvider.Name,
+			"oidc",
 			kubeconfigPath,
 			sessionCachePath,
 			pinnipedExe,
@@ -392,7 +396,7 @@ func TestE2EFullIntegration(t *testing.T) {
 		expectedUsername := env.SupervisorUpstreamLDAP.TestUserMailAttributeValue
 		expectedGroups := env.SupervisorUpstreamLDAP.TestUserDirectGroupsDNs
 
-		setupClusterForEndToEndLDAPTest(t, expectedUsername, env)
+		ldapIdentityProvider := setupClusterForEndToEndLDAPTest(t, expectedUsername, env)
 
 		// Use a specific session cache for this test.
 		sessionCachePath := tempDir + "/ldap-test-sessions.yaml"
@@ -431,6 +435,8 @@ func TestE2EFullIntegration(t *testing.T) {
 
 		requireUserCanUseKubectlWithoutAuthenticatingAgain(ctx, t, env,
 			downstream,
+			ldapIdentityProvider.Name,
+			"ldap",
 			kubeconfigPath,
 			sessionCachePath,
 			pinnipedExe,
@@ -449,7 +455,7 @@ func TestE2EFullIntegration(t *testing.T) {
 		expectedUsername := env.SupervisorUpstreamLDAP.TestUserMailAttributeValue
 		expectedGroups := env.SupervisorUpstreamLDAP.TestUserDirectGroupsDNs
 
-		setupClusterForEndToEndLDAPTest(t, expectedUsername, env)
+		ldapIdentityProvider := setupClusterForEndToEndLDAPTest(t, expectedUsername, env)
 
 		// Use a specific session cache for this test.
 		sessionCachePath := tempDir + "/ldap-test-with-env-vars-sessions.yaml"
@@ -500,6 +506,8 @@ func TestE2EFullIntegration(t *testing.T) {
 
 		requireUserCanUseKubectlWithoutAuthenticatingAgain(ctx, t, env,
 			downstream,
+			ldapIdentityProvider.Name,
+			"ldap",
 			kubeconfigPath,
 			sessionCachePath,
 			pinnipedExe,
@@ -509,7 +517,7 @@ func TestE2EFullIntegration(t *testing.T) {
 	})
 }
 
-func setupClusterForEndToEndLDAPTest(t *testing.T, username string, env *testlib.TestEnv) {
+func setupClusterForEndToEndLDAPTest(t *testing.T, username string, env *testlib.TestEnv) *idpv1alpha1.LDAPIdentityProvider {
 	// Create a ClusterRoleBinding to give our test user from the upstream read-only access to the cluster.
 	testlib.CreateTestClusterRoleBinding(t,
 		rbacv1.Subject{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: username},
@@ -531,7 +539,7 @@ func setupClusterForEndToEndLDAPTest(t *testing.T, username string, env *testlib
 	)
 
 	// Create upstream LDAP provider and wait for it to become ready.
-	testlib.CreateTestLDAPIdentityProvider(t, idpv1alpha1.LDAPIdentityProviderSpec{
+	return testlib.CreateTestLDAPIdentityProvider(t, idpv1alpha1.LDAPIdentityProviderSpec{
 		Host: env.SupervisorUpstreamLDAP.Host,
 		TLS: &idpv1alpha1.TLSSpec{
 			CertificateAuthorityData: base64.StdEncoding.EncodeToString([]byte(env.SupervisorUpstreamLDAP.CABundle)),
@@ -604,6 +612,8 @@ func requireUserCanUseKubectlWithoutAuthenticatingAgain(
 	t *testing.T,
 	env *testlib.TestEnv,
 	downstream *configv1alpha1.FederationDomain,
+	upstreamName string,
+	upstreamType string,
 	kubeconfigPath string,
 	sessionCachePath string,
 	pinnipedExe string,
@@ -631,6 +641,9 @@ func requireUserCanUseKubectlWithoutAuthenticatingAgain(
 		ClientID:    "pinniped-cli",
 		Scopes:      downstreamScopes,
 		RedirectURI: "http://localhost:0/callback",
+
+		UpstreamIdentityProviderName: upstreamName,
+		UpstreamIdentityProviderType: upstreamType,
 	})
 	require.NotNil(t, token)
 
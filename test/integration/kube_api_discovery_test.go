@@ -137,6 +137,20 @@ func TestGetAPIResourceList(t *testing.T) {
 						Kind:       "FederationDomain",
 						Verbs:      []string{"get", "patch", "update"},
 					},
+					{
+						Name:         "oidcclients",
+						SingularName: "oidcclient",
+						Namespaced:   true,
+						Kind:         "OIDCClient",
+						Verbs:        []string{"delete", "deletecollection", "get", "list", "patch", "create", "update", "watch"},
+						Categories:   []string{"pinniped"},
+					},
+					{
+						Name:       "oidcclients/status",
+						Namespaced: true,
+						Kind:       "OIDCClient",
+						Verbs:      []string{"get", "patch", "update"},
+					},
 				},
 			},
 		},
@@ -184,6 +198,48 @@ func TestGetAPIResourceList(t *testing.T) {
 						Kind:       "LDAPIdentityProvider",
 						Verbs:      []string{"get", "patch", "update"},
 					},
+					{
+						Name:         "activedirectoryidentityproviders",
+						SingularName: "activedirectoryidentityprovider",
+						Namespaced:   true,
+						Kind:         "ActiveDirectoryIdentityProvider",
+						Verbs:        []string{"delete", "deletecollection", "get", "list", "patch", "create", "update", "watch"},
+						Categories:   []string{"pinniped", "pinniped-idp", "pinniped-idps"},
+					},
+					{
+						Name:       "activedirectoryidentityproviders/status",
+						Namespaced: true,
+						Kind:       "ActiveDirectoryIdentityProvider",
+						Verbs:      []string{"get", "patch", "update"},
+					},
+					{
+						Name:         "githubidentityproviders",
+						SingularName: "githubidentityprovider",
+						Namespaced:   true,
+						Kind:         "GitHubIdentityProvider",
+						Verbs:        []string{"delete", "deletecollection", "get", "list", "patch", "create", "update", "watch"},
+						Categories:   []string{"pinniped", "pinniped-idp", "pinniped-idps"},
+					},
+					{
+						Name:       "githubidentityproviders/status",
+						Namespaced: true,
+						Kind:       "GitHubIdentityProvider",
+						Verbs:      []string{"get", "patch", "update"},
+					},
+					{
+						Name:         "samlidentityproviders",
+						SingularName: "samlidentityprovider",
+						Namespaced:   true,
+						Kind:         "SAMLIdentityProvider",
+						Verbs:        []string{"delete", "deletecollection", "get", "list", "patch", "create", "update", "watch"},
+						Categories:   []string{"pinniped", "pinniped-idp", "pinniped-idps"},
+					},
+					{
+						Name:       "samlidentityproviders/status",
+						Namespaced: true,
+						Kind:       "SAMLIdentityProvider",
+						Verbs:      []string{"get", "patch", "update"},
+					},
 				},
 			},
 		},
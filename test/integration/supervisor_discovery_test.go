@@ -477,15 +477,19 @@ func requireWellKnownEndpointIsWorking(t *testing.T, supervisorScheme, superviso
       "token_endpoint": "%s/oauth2/token",
       "token_endpoint_auth_methods_supported": ["client_secret_basic"],
       "jwks_uri": "%s/jwks.json",
+      "userinfo_endpoint": "%s/oauth2/userinfo",
       "scopes_supported": ["openid", "offline"],
       "response_types_supported": ["code"],
       "response_modes_supported": ["query", "form_post"],
       "claims_supported": ["groups"],
       "discovery.supervisor.pinniped.dev/v1alpha1": {"pinniped_identity_providers_endpoint": "%s/v1alpha1/pinniped_identity_providers"},
       "subject_types_supported": ["public"],
-      "id_token_signing_alg_values_supported": ["ES256"]
+      "id_token_signing_alg_values_supported": ["ES256"],
+      "device_authorization_endpoint": "%s/oauth2/device_authorization",
+      "revocation_endpoint": "%s/oauth2/revoke",
+      "introspection_endpoint": "%s/oauth2/introspect"
     }`)
-	expectedJSON := fmt.Sprintf(expectedResultTemplate, issuerName, issuerName, issuerName, issuerName, issuerName)
+	expectedJSON := fmt.Sprintf(expectedResultTemplate, issuerName, issuerName, issuerName, issuerName, issuerName, issuerName, issuerName, issuerName, issuerName)
 
 	require.Equal(t, "application/json", response.Header.Get("content-type"))
 	require.JSONEq(t, expectedJSON, responseBody)